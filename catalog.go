@@ -0,0 +1,111 @@
+package sealpack
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"github.com/apex/log"
+	"github.com/innomotics/sealpack/internal"
+)
+
+// Catalog lists the packages of a repository, so devices can discover available updates
+type Catalog = internal.Catalog
+
+// CatalogEntry describes a package of a Catalog
+type CatalogEntry = internal.CatalogEntry
+
+type CatalogConfig struct {
+	PrivKeyPath string
+	// BaseURL is joined with the relative paths of the packages to form their URLs, e.g. https://updates.example.com/
+	BaseURL string
+	Output  string
+	// Annotations only include packages with matching annotations, given as "key=value" or "key" for any value
+	Annotations []string
+	// AWS configures the sessions used for S3 sources and outputs
+	AWS AWSConfig
+}
+
+// BuildCatalog scans a directory or s3:// prefix of packages and writes a catalog signed with the private key to the output
+func BuildCatalog(source string, config *CatalogConfig) error {
+	internal.ConfigureAWS(config.AWS)
+	if config.PrivKeyPath == "" {
+		return fmt.Errorf("no private signing key provided")
+	}
+	catalog, err := internal.ScanCatalog(source, config.BaseURL)
+	if err != nil {
+		return err
+	}
+	catalog.FilterAnnotations(config.Annotations)
+	signed, err := catalog.Sign(config.PrivKeyPath)
+	if err != nil {
+		return err
+	}
+	if err = internal.WriteFileBytes(config.Output, signed); err != nil {
+		return err
+	}
+	log.Infof("index: cataloged %d packages of %s", len(catalog.Packages), source)
+	return nil
+}
+
+// VerifyCatalog checks the signature of a catalog written by BuildCatalog and returns its contents
+func VerifyCatalog(signed []byte, publicKeyPath string) (*Catalog, error) {
+	return internal.VerifyCatalog(signed, publicKeyPath)
+}
+
+type PromoteConfig struct {
+	// SigningKeyPath is the public key the catalog is verified with before it is changed
+	SigningKeyPath string
+	// PrivKeyPath signs the promoted catalog
+	PrivKeyPath string
+	Package     string
+	Version     string
+	Channel     string
+	// Output is the file the promoted catalog is written to, the catalog itself if empty
+	Output string
+	// AWS configures the sessions used for S3 catalogs
+	AWS AWSConfig
+}
+
+// Promote moves a package version of a signed catalog to another channel and signs the catalog again,
+// so staged rollouts need no rebuild of the package
+func Promote(catalogPath string, config *PromoteConfig) error {
+	internal.ConfigureAWS(config.AWS)
+	if config.PrivKeyPath == "" {
+		return fmt.Errorf("no private signing key provided")
+	}
+	signed, err := internal.ReadFileBytes(catalogPath)
+	if err != nil {
+		return err
+	}
+	catalog, err := internal.VerifyCatalog(signed, config.SigningKeyPath)
+	if err != nil {
+		return err
+	}
+	if err = catalog.Promote(config.Package, config.Version, config.Channel); err != nil {
+		return err
+	}
+	if signed, err = catalog.Sign(config.PrivKeyPath); err != nil {
+		return err
+	}
+	output := config.Output
+	if output == "" {
+		output = catalogPath
+	}
+	if err = internal.WriteFileBytes(output, signed); err != nil {
+		return err
+	}
+	log.Infof("promote: moved %s %s to channel %s in %s", config.Package, config.Version, config.Channel, output)
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ConfigEnvPrefix is the prefix of environment variables setting flag defaults
+	ConfigEnvPrefix = "SEALPACK_"
+	// systemConfigFile is the system-wide configuration file
+	systemConfigFile = "/etc/sealpack/config.yaml"
+)
+
+// configFiles lists all configuration files in ascending priority.
+// An explicitly provided file replaces the user configuration file.
+func configFiles(explicit string) []string {
+	files := []string{systemConfigFile}
+	if explicit != "" {
+		return append(files, explicit)
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, filepath.Join(dir, "sealpack", "config.yaml"))
+	}
+	return files
+}
+
+// applyConfig sets all flags of a command that were not provided on the command line from
+// configuration files and SEALPACK_* environment variables. Environment variables take precedence over files.
+func applyConfig(cmd *cobra.Command, files []string, environ []string) error {
+	values := map[string]string{}
+	for _, file := range files {
+		if err := readConfigFile(file, cmd.Name(), values); err != nil {
+			return err
+		}
+	}
+	readConfigEnv(cmd.Name(), environ, values)
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		value, ok := values[f.Name]
+		if err != nil || !ok || f.Changed {
+			return
+		}
+		if setErr := cmd.Flags().Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid configuration value for '%s': %v", f.Name, setErr)
+		}
+	})
+	return err
+}
+
+// readConfigFile reads flag values from a YAML file. Top-level keys apply to all commands,
+// keys in a section named like the command override them. Missing files are ignored.
+func readConfigFile(file, command string, values map[string]string) error {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	contents := map[string]any{}
+	if err = yaml.Unmarshal(data, &contents); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %v", file, err)
+	}
+	for key, value := range contents {
+		if _, isSection := value.(map[string]any); !isSection {
+			values[key] = configString(value)
+		}
+	}
+	if section, ok := contents[command].(map[string]any); ok {
+		for key, value := range section {
+			values[key] = configString(value)
+		}
+	}
+	return nil
+}
+
+// writeConfigValue sets a flag value in a command section of a YAML configuration file, keeping all other values.
+// Missing files and directories are created.
+func writeConfigValue(file, command, key, value string) error {
+	contents := map[string]any{}
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err = yaml.Unmarshal(data, &contents); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %v", file, err)
+	}
+	section, ok := contents[command].(map[string]any)
+	if !ok {
+		section = map[string]any{}
+		contents[command] = section
+	}
+	section[key] = value
+	if data, err = yaml.Marshal(contents); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// readConfigEnv reads flag values from environment variables. SEALPACK_<FLAG> applies to all commands,
+// SEALPACK_<COMMAND>_<FLAG> overrides it for a single command. Dashes in flag names are replaced by underscores.
+func readConfigEnv(command string, environ []string, values map[string]string) {
+	commandPrefix := ConfigEnvPrefix + envName(command) + "_"
+	specific := map[string]string{}
+	for _, env := range environ {
+		key, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(key, ConfigEnvPrefix) {
+			continue
+		}
+		if strings.HasPrefix(key, commandPrefix) {
+			specific[flagName(strings.TrimPrefix(key, commandPrefix))] = value
+			continue
+		}
+		values[flagName(strings.TrimPrefix(key, ConfigEnvPrefix))] = value
+	}
+	for key, value := range specific {
+		values[key] = value
+	}
+}
+
+// configString converts a YAML value into its flag representation; lists are joined by commas
+func configString(value any) string {
+	if list, ok := value.([]any); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+// envName converts a flag or command name to its environment variable form
+func envName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// flagName converts an environment variable suffix to a flag name
+func flagName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+}
@@ -0,0 +1,88 @@
+package main
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newConfigTestCmd() (*cobra.Command, *string, *string, *[]string) {
+	cmd := &cobra.Command{Use: "unseal"}
+	signer := cmd.Flags().StringP("signer-key", "s", "", "")
+	registry := cmd.Flags().String("target-registry", "local", "")
+	files := cmd.Flags().StringSlice("file", nil, "")
+	return cmd, signer, registry, files
+}
+
+func Test_applyConfig(t *testing.T) {
+	dir := t.TempDir()
+	system := filepath.Join(dir, "system.yaml")
+	user := filepath.Join(dir, "user.yaml")
+	assert.NoError(t, os.WriteFile(system, []byte("signer-key: /etc/signer.pem\ntarget-registry: registry.local\n"), 0644))
+	assert.NoError(t, os.WriteFile(user, []byte("file: [a.txt, b.txt]\nunseal:\n  signer-key: /home/signer.pem\nseal:\n  target-registry: ignored\n"), 0644))
+
+	cmd, signer, registry, files := newConfigTestCmd()
+	assert.NoError(t, applyConfig(cmd, []string{system, user, filepath.Join(dir, "missing.yaml")}, nil))
+	assert.Equal(t, "/home/signer.pem", *signer)
+	assert.Equal(t, "registry.local", *registry)
+	assert.Equal(t, []string{"a.txt", "b.txt"}, *files)
+}
+
+func Test_applyConfig_Precedence(t *testing.T) {
+	cmd, signer, registry, _ := newConfigTestCmd()
+	assert.NoError(t, cmd.Flags().Parse([]string{"-s", "flag.pem"}))
+	env := []string{
+		"SEALPACK_SIGNER_KEY=env.pem",
+		"SEALPACK_TARGET_REGISTRY=generic",
+		"SEALPACK_UNSEAL_TARGET_REGISTRY=specific",
+		"OTHER_TARGET_REGISTRY=other",
+	}
+	assert.NoError(t, applyConfig(cmd, nil, env))
+	assert.Equal(t, "flag.pem", *signer)
+	assert.Equal(t, "specific", *registry)
+}
+
+func Test_applyConfig_Invalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("signer-key: [unclosed"), 0644))
+	cmd, _, _, _ := newConfigTestCmd()
+	assert.ErrorContains(t, applyConfig(cmd, []string{file}, nil), "invalid configuration file")
+
+	cmd = &cobra.Command{Use: "keygen"}
+	cmd.Flags().Int("bits", 0, "")
+	assert.ErrorContains(t, applyConfig(cmd, nil, []string{"SEALPACK_BITS=many"}), "invalid configuration value for 'bits'")
+}
+
+func Test_writeConfigValue(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sealpack", "config.yaml")
+	assert.NoError(t, writeConfigValue(file, "unseal", "privkey", "tpm:0x81000100"))
+	assert.NoError(t, os.WriteFile(file, []byte("signer-key: /etc/signer.pem\nunseal:\n  privkey: old.pem\n  target-registry: registry.local\n"), 0644))
+	assert.NoError(t, writeConfigValue(file, "unseal", "privkey", "tpm:0x81000100"))
+	assert.NoError(t, writeConfigValue(file, "verify", "privkey", "tpm:0x81000100"))
+
+	cmd, signer, registry, _ := newConfigTestCmd()
+	privkey := cmd.Flags().StringP("privkey", "p", "", "")
+	assert.NoError(t, applyConfig(cmd, []string{file}, nil))
+	assert.Equal(t, "tpm:0x81000100", *privkey)
+	assert.Equal(t, "/etc/signer.pem", *signer)
+	assert.Equal(t, "registry.local", *registry)
+
+	assert.NoError(t, os.WriteFile(file, []byte("- not a map"), 0644))
+	assert.ErrorContains(t, writeConfigValue(file, "unseal", "privkey", "key.pem"), "invalid configuration file")
+}
@@ -0,0 +1,45 @@
+package main
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+)
+
+// confirm asks a yes/no question on the terminal; anything but y or yes declines
+func confirm(question string) (bool, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, fmt.Errorf("no terminal available to confirm: %s; use --yes to proceed without asking", question)
+	}
+	return readConfirmation(os.Stdin, os.Stderr, question)
+}
+
+// readConfirmation writes a question and reads the answer from a line of input
+func readConfirmation(in io.Reader, out io.Writer, question string) (bool, error) {
+	_, _ = fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_readConfirmation(t *testing.T) {
+	for answer, expected := range map[string]bool{"y\n": true, "YES\n": true, "n\n": false, "\n": false, "": false, "maybe\n": false} {
+		out := new(bytes.Buffer)
+		ok, err := readConfirmation(strings.NewReader(answer), out, "Continue?")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, ok, answer)
+		assert.Equal(t, "Continue? [y/N] ", out.String())
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+	"os"
+)
+
+var (
+	// manPages defines whether docs are generated as man pages instead of markdown
+	manPages bool
+	// docsDir defines the directory the docs are written to
+	docsDir string
+	// completionCmd describes the `completion` subcommand as cobra.Command
+	completionCmd = &cobra.Command{
+		Use:       "completion [bash|zsh|fish|powershell]",
+		Short:     "Generates shell completion scripts",
+		Long:      "Generates a shell completion script for sealpack and writes it to stdout",
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		Run: func(cmd *cobra.Command, args []string) {
+			check(genCompletion(cmd.Root(), args[0]))
+		},
+	}
+	// docsCmd describes the `docs` subcommand as cobra.Command
+	docsCmd = &cobra.Command{
+		Use:   "docs",
+		Short: "Generates documentation",
+		Long:  "Generates markdown documentation or man pages for all sealpack commands",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			check(genDocs(cmd.Root(), docsDir, manPages))
+		},
+	}
+)
+
+// genCompletion writes the completion script for a shell to stdout
+func genCompletion(root *cobra.Command, shell string) error {
+	switch shell {
+	case "bash":
+		return root.GenBashCompletionV2(os.Stdout, true)
+	case "zsh":
+		return root.GenZshCompletion(os.Stdout)
+	case "fish":
+		return root.GenFishCompletion(os.Stdout, true)
+	default:
+		return root.GenPowerShellCompletionWithDesc(os.Stdout)
+	}
+}
+
+// genDocs writes the documentation of all commands to a directory
+func genDocs(root *cobra.Command, dir string, man bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	root.DisableAutoGenTag = true
+	if man {
+		return doc.GenManTree(root, &doc.GenManHeader{
+			Title:   "SEALPACK",
+			Section: "1",
+			Source:  "sealpack",
+		}, dir)
+	}
+	return doc.GenMarkdownTree(root, dir)
+}
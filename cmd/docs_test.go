@@ -0,0 +1,36 @@
+package main
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_genDocs(t *testing.T) {
+	root := &cobra.Command{Use: "sealpack"}
+	root.AddCommand(&cobra.Command{Use: "seal", Run: func(cmd *cobra.Command, args []string) {}})
+	dir := t.TempDir()
+	assert.NoError(t, genDocs(root, dir, true))
+	assert.FileExists(t, filepath.Join(dir, "sealpack.1"))
+	assert.FileExists(t, filepath.Join(dir, "sealpack-seal.1"))
+	md := filepath.Join(t.TempDir(), "md")
+	assert.NoError(t, genDocs(root, md, false))
+	_, err := os.Stat(filepath.Join(md, "sealpack_seal.md"))
+	assert.NoError(t, err)
+}
@@ -16,33 +16,94 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/apex/log"
 	jsonHandler "github.com/apex/log/handlers/json"
+	textHandler "github.com/apex/log/handlers/text"
 	"github.com/innomotics/sealpack"
 	"github.com/spf13/cobra"
 	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes of the sealpack CLI, one per failure class
+const (
+	ExitOk = iota
+	ExitGeneric
+	ExitBadSignature
+	ExitNotRecipient
+	ExitCorruptEnvelope
+	ExitNetwork
+	ExitPartialImport
+	ExitLimitExceeded
+	ExitLocked
 )
 
 type CommandConfig struct {
 	Seal    *sealpack.SealConfig
 	Unseal  *sealpack.UnsealConfig
+	Keygen  *sealpack.KeygenConfig
+	Enroll  *sealpack.EnrollConfig
+	Catalog *sealpack.CatalogConfig
+	Mirror  *sealpack.MirrorConfig
+	Prune   *sealpack.PruneConfig
+	Promote *sealpack.PromoteConfig
 	Inspect string
 }
 
 var (
 	// logLevel defines the verbosity of logging
 	logLevel string
+	// logFormat defines the format of log messages, either text or json
+	logFormat = "json"
+	// quiet only logs errors, regardless of the log level
+	quiet bool
+	// configFile defines an explicit configuration file replacing the user configuration
+	configFile string
+	// awsConfig configures the AWS sessions of all commands
+	awsConfig sealpack.AWSConfig
+	// bandwidth caps the network transfers of all commands
+	bandwidth sealpack.BandwidthLimits
 	// rootCmd describes the main cobra.Command
 	rootCmd = &cobra.Command{
 		Use:  "sealpack",
 		Long: "A cryptographic sealing packager",
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if cmd != nil {
+				if err := applyConfig(cmd, configFiles(configFile), os.Environ()); err != nil {
+					return err
+				}
+			}
 			l, err := log.ParseLevel(logLevel)
 			if err != nil {
 				return err
 			}
+			if quiet {
+				l = log.ErrorLevel
+			}
+			handler, err := logHandler(logFormat)
+			if err != nil {
+				return err
+			}
+			log.SetHandler(handler)
 			log.SetLevel(l)
-			return err
+			if err = awsConfig.Validate(); err != nil {
+				return err
+			}
+			if err = bandwidth.Validate(); err != nil {
+				return err
+			}
+			if cmd != nil && cmd.Context() != nil {
+				if conf, ok := cmd.Context().Value("config").(*CommandConfig); ok {
+					conf.Seal.AWS, conf.Unseal.AWS, conf.Catalog.AWS, conf.Mirror.AWS, conf.Prune.AWS = awsConfig, awsConfig, awsConfig, awsConfig, awsConfig
+					conf.Promote.AWS = awsConfig
+				}
+			}
+			sealpack.ConfigureAWS(awsConfig)
+			sealpack.LimitBandwidth(bandwidth)
+			return nil
 		},
 	}
 
@@ -52,7 +113,30 @@ var (
 		Short: "Create sealed archive",
 		Long:  "Create a sealed package",
 		Run: func(cmd *cobra.Command, args []string) {
-			check(sealpack.Seal(cmd.Context().Value("config").(*CommandConfig).Seal))
+			check(sealpack.SealContext(cmd.Context(), cmd.Context().Value("config").(*CommandConfig).Seal))
+		},
+	}
+
+	// convertCmd describes the `convert` subcommand as cobra.Command
+	convertCmd = &cobra.Command{
+		Use:   "convert <artifact>",
+		Short: "Converts an existing archive into a sealed package",
+		Long:  "Seals the files of an existing tar, tar.gz or zip artifact, keeping their names, modes and modification times; '-' reads a tar stream from stdin",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			check(sealpack.Convert(args[0], cmd.Context().Value("config").(*CommandConfig).Seal))
+		},
+	}
+
+	// estimateCmd describes the `estimate` subcommand as cobra.Command
+	estimateCmd = &cobra.Command{
+		Use:   "estimate",
+		Short: "Estimates the size of a package before sealing",
+		Long:  "Resolves files and image manifests without pulling images and reports the expected raw, compressed and encrypted sizes of the package",
+		Run: func(cmd *cobra.Command, args []string) {
+			estimate, err := sealpack.Estimate(cmd.Context().Value("config").(*CommandConfig).Seal)
+			check(err)
+			_, _ = cmd.OutOrStdout().Write([]byte(estimate.String()))
 		},
 	}
 
@@ -63,9 +147,262 @@ var (
 		Long:  "Inspects a sealed archive and allows for identifying any errors",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
+			if showProvenance {
+				provenance, err := sealpack.Provenance(args[0], cmd.Context().Value("config").(*CommandConfig).Unseal)
+				check(err)
+				_, err = cmd.OutOrStdout().Write(provenance)
+				check(err)
+				return
+			}
+			if showOrigins {
+				origins, err := sealpack.Origins(args[0], cmd.Context().Value("config").(*CommandConfig).Unseal)
+				check(err)
+				_, err = cmd.OutOrStdout().Write([]byte(origins.String()))
+				check(err)
+				return
+			}
+			if len(annotationFilters) > 0 {
+				info, err := sealpack.ReadEnvelope(args[0])
+				check(err)
+				if !info.MatchAnnotations(annotationFilters) {
+					check(fmt.Errorf("%s does not match the annotations %s", args[0], strings.Join(annotationFilters, ", ")))
+				}
+			}
+			if config := cmd.Context().Value("config").(*CommandConfig).Unseal; config.Recursive {
+				check(sealpack.InspectRecursive(args[0], config))
+				return
+			}
 			check(sealpack.Inspect(args[0]))
 		},
 	}
+	// diagnoseCmd describes the `diagnose` subcommand as cobra.Command
+	diagnoseCmd = &cobra.Command{
+		Use:   "diagnose",
+		Short: "Diagnoses a damaged archive",
+		Long:  "Walks the envelope structure of a sealed archive and reports which sections are intact and where truncation or corruption begins",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			diagnosis, err := sealpack.Diagnose(args[0])
+			if diagnosis != nil {
+				_, _ = cmd.OutOrStdout().Write([]byte(diagnosis.String()))
+			}
+			check(err)
+		},
+	}
+	// listCmd describes the `list` subcommand as cobra.Command
+	listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "Lists the contents of a sealed archive",
+		Long:  "Lists names and sizes of all files and images in a sealed archive without extracting them",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			check(sealpack.List(args[0], cmd.Context().Value("config").(*CommandConfig).Unseal))
+		},
+	}
+	// extractOneCmd describes the `extract-one` subcommand as cobra.Command
+	extractOneCmd = &cobra.Command{
+		Use:   "extract-one",
+		Short: "Extracts a single file of an indexed archive",
+		Long:  "Extracts a single file of an archive sealed with --index, reading only the byte ranges needed from local files, s3:// or http(s):// URLs",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			check(sealpack.ExtractOne(args[0], extractPath, cmd.Context().Value("config").(*CommandConfig).Unseal))
+		},
+	}
+	// verifyCmd describes the `verify` subcommand as cobra.Command
+	verifyCmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Verifies a sealed archive against an expected TOC",
+		Long:  "Verifies that a sealed archive contains exactly the entries of an expected TOC with matching digests, without extracting it",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			check(sealpack.VerifyToc(args[0], expectedTocPath, digestAlgorithm, cmd.Context().Value("config").(*CommandConfig).Unseal))
+		},
+	}
+	// checkCmd describes the `check` subcommand as cobra.Command
+	checkCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Checks an installation for drift",
+		Long:  "Rehashes installed files and looks up imported images to verify an installation still matches its manifest, without needing the package",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			check(sealpack.Check(manifestPath, cmd.Context().Value("config").(*CommandConfig).Unseal))
+		},
+	}
+	// rollbackCmd describes the `rollback` subcommand as cobra.Command
+	rollbackCmd = &cobra.Command{
+		Use:   "rollback",
+		Short: "Reverts an unseal",
+		Long:  "Restores the files and image tags captured in a rollback bundle written by unseal --rollback-bundle",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			check(sealpack.Rollback(args[0], cmd.Context().Value("config").(*CommandConfig).Unseal))
+		},
+	}
+	// keygenCmd describes the `keygen` subcommand as cobra.Command
+	keygenCmd = &cobra.Command{
+		Use:   "keygen",
+		Short: "Creates a key pair",
+		Long:  "Creates a signing or recipient key pair as PEM files usable by seal and unseal",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			conf := cmd.Context().Value("config").(*CommandConfig).Keygen
+			if askPassphrase {
+				var err error
+				conf.Passphrase, err = readNewPassphrase()
+				check(err)
+			}
+			check(sealpack.Keygen(conf))
+		},
+	}
+	// enrollCmd describes the `enroll` subcommand as cobra.Command
+	enrollCmd = &cobra.Command{
+		Use:   "enroll",
+		Short: "Enrolls a device identity",
+		Long: "Creates the recipient key of a device in a key file or the TPM, writes its public key and optionally a " +
+			"certificate request for the packaging backend, and configures unseal and verify to use the key",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			conf := cmd.Context().Value("config").(*CommandConfig).Enroll
+			if askPassphrase {
+				var err error
+				conf.Passphrase, err = readNewPassphrase()
+				check(err)
+			}
+			check(sealpack.Enroll(conf))
+			file := configFile
+			if file == "" {
+				file = systemConfigFile
+			}
+			for _, command := range []string{"unseal", "verify"} {
+				check(writeConfigValue(file, command, "privkey", conf.KeyReference))
+			}
+			log.Infof("enroll: configured %s as private key of unseal and verify in %s", conf.KeyReference, file)
+		},
+	}
+	// indexCmd describes the `index` subcommand as cobra.Command
+	indexCmd = &cobra.Command{
+		Use:   "index",
+		Short: "Creates a signed catalog of packages",
+		Long:  "Scans a directory or s3:// prefix of sealed packages and writes a signed catalog of their names, versions, digests, sizes, recipients and URLs for devices to poll",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			check(sealpack.BuildCatalog(args[0], cmd.Context().Value("config").(*CommandConfig).Catalog))
+		},
+	}
+	// mirrorCmd describes the `mirror` subcommand as cobra.Command
+	mirrorCmd = &cobra.Command{
+		Use:   "mirror <source> <target>",
+		Short: "Copies packages between storage backends",
+		Long:  "Streams a package, or all packages of a directory or of a URI ending with /, between local paths, s3:// and sftp:// locations, verifying each against its checksum trailer. Single packages can also be read from http(s):// URLs",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			mirrored, err := sealpack.Mirror(args[0], args[1], cmd.Context().Value("config").(*CommandConfig).Mirror)
+			check(err)
+			log.Infof("mirror: copied %d packages", len(mirrored))
+		},
+	}
+	// pruneCmd describes the `prune` subcommand as cobra.Command
+	pruneCmd = &cobra.Command{
+		Use:   "prune",
+		Short: "Removes old packages of a repository",
+		Long:  "Removes the packages of a directory, s3:// prefix or sftp:// directory exceeding the retention rules, per channel of a package name within a directory. The most recent package of each channel is always kept",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			pruned, err := sealpack.Prune(args[0], cmd.Context().Value("config").(*CommandConfig).Prune)
+			check(err)
+			log.Infof("prune: %d packages pruned", len(pruned))
+		},
+	}
+	// promoteCmd describes the `promote` subcommand as cobra.Command
+	promoteCmd = &cobra.Command{
+		Use:   "promote",
+		Short: "Moves a package of a catalog to another channel",
+		Long:  "Verifies a catalog written by index, moves a package version to another release channel and signs the catalog again, without rebuilding the package",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			check(sealpack.Promote(args[0], cmd.Context().Value("config").(*CommandConfig).Promote))
+		},
+	}
+	// keyCmd groups subcommands handling keys
+	keyCmd = &cobra.Command{
+		Use:   "key",
+		Short: "Key handling commands",
+	}
+	// keyInfoCmd describes the `key info` subcommand as cobra.Command
+	keyInfoCmd = &cobra.Command{
+		Use:   "info",
+		Short: "Shows details of a key",
+		Long:  "Shows type, size, SHA-256 fingerprint and possible usage of a PEM key file, an awskms:/// or a tpm: key",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			check(sealpack.KeyInfo(args[0]))
+		},
+	}
+	// keyFleetDeviceCmd describes the `key fleet-device` subcommand as cobra.Command
+	keyFleetDeviceCmd = &cobra.Command{
+		Use:   "fleet-device [device ID]",
+		Short: "Derives the key of a fleet device",
+		Long:  "Derives the key of a single device from the fleet master secret; devices are provisioned with it instead of the master secret",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			fleetDevice.DeviceID = args[0]
+			check(sealpack.DeriveFleetDeviceKey(&fleetDevice))
+		},
+	}
+	// auditCmd groups subcommands handling audit logs
+	auditCmd = &cobra.Command{
+		Use:   "audit",
+		Short: "Audit log commands",
+	}
+	// auditVerifyCmd describes the `audit verify` subcommand as cobra.Command
+	auditVerifyCmd = &cobra.Command{
+		Use:   "verify [audit log]",
+		Short: "Verifies a signed audit log",
+		Long:  "Verifies the MACs of all records of an audit log signed with --audit-key, detecting changed, removed and reordered records",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			check(sealpack.VerifyAuditLog(args[0], auditKeyPath))
+		},
+	}
+	// expectedTocPath is the expected TOC packages are verified against
+	expectedTocPath string
+	// extractPath is the name of the entry extract-one extracts
+	extractPath string
+	// manifestPath is the manifest installations are checked against
+	manifestPath string
+	// digestAlgorithm is the hash the digests of the expected TOC are calculated with
+	digestAlgorithm string
+	// auditKeyPath is the key audit logs are verified with
+	auditKeyPath string
+	// fleetDevice configures the derivation of a fleet device key
+	fleetDevice sealpack.FleetDeviceConfig
+	// askPassphrase defines whether keygen and enroll should protect the private key with a passphrase
+	askPassphrase bool
+	// showProvenance defines whether inspect prints the provenance statement instead of the envelope
+	showProvenance bool
+	// showOrigins defines whether inspect prints the origins of all entries instead of the envelope
+	showOrigins bool
+	// annotationFilters are the annotations a package must have for inspect to succeed
+	annotationFilters []string
+	// assumeYes skips confirming destructive unseal operations
+	assumeYes bool
+	// detachedSignaturePath is the file unwrap writes the TOC signature to
+	detachedSignaturePath string
+	// unwrapCmd describes the `unwrap` subcommand as cobra.Command
+	unwrapCmd = &cobra.Command{
+		Use:   "unwrap <package>",
+		Short: "Exports a sealed package as plain signed tarball",
+		Long:  "Verifies a sealed package and writes its decrypted contents with the signed TOC to a plain tar archive and the TOC signature to a detached file",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			config := cmd.Context().Value("config").(*CommandConfig).Unseal
+			if !assumeYes {
+				config.Confirm = confirm
+			}
+			check(sealpack.Unwrap(args[0], detachedSignaturePath, config))
+		},
+	}
 	// unsealCmd describes the `unpack` subcommand as cobra.Command
 	unsealCmd = &cobra.Command{
 		Use:   "unseal",
@@ -73,8 +410,12 @@ var (
 		Long:  "Unpacks a sealed archive if the provided private key is valid",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
+			config := cmd.Context().Value("config").(*CommandConfig).Unseal
+			if !assumeYes {
+				config.Confirm = confirm
+			}
 			// Pass filename as first argument
-			check(sealpack.Unseal(args[0], cmd.Context().Value("config").(*CommandConfig).Unseal))
+			check(sealpack.UnsealContext(cmd.Context(), args[0], config))
 		},
 	}
 )
@@ -84,35 +425,321 @@ func ParseCommands() error {
 	conf := &CommandConfig{
 		Seal:    &sealpack.SealConfig{},
 		Unseal:  &sealpack.UnsealConfig{},
+		Keygen:  &sealpack.KeygenConfig{},
+		Enroll:  &sealpack.EnrollConfig{},
+		Catalog: &sealpack.CatalogConfig{},
+		Mirror:  &sealpack.MirrorConfig{},
+		Prune:   &sealpack.PruneConfig{},
+		Promote: &sealpack.PromoteConfig{},
 		Inspect: "",
 	}
 
 	rootCmd.Commands()
 	rootCmd.PersistentFlags().StringVarP(&logLevel, "loglevel", "l", "info", "Logging verbosity. Allowed values are 'debug', 'info', 'warning', 'error', 'fatal'. Default is 'info'")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "json", "Format of log messages. Allowed values are 'text' and 'json'")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only log errors, overriding the log level")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Configuration file providing flag defaults; replaces ~/.config/sealpack/config.yaml")
+	rootCmd.PersistentFlags().StringVar(&awsConfig.Region, "aws-region", "", "AWS region for KMS, S3 and Secrets Manager; defaults to AWS_REGION or the profile's region")
+	rootCmd.PersistentFlags().StringVar(&awsConfig.Profile, "aws-profile", "", "Profile of the shared AWS configuration to take credentials and region from")
+	rootCmd.PersistentFlags().StringVar(&awsConfig.EndpointURL, "aws-endpoint-url", "", "Endpoint URL replacing all AWS service endpoints, e.g. http://localhost:4566 for localstack")
+	rootCmd.PersistentFlags().StringVar(&awsConfig.RoleARN, "aws-role-arn", "", "ARN of a role to assume before accessing AWS services")
+	rootCmd.PersistentFlags().StringToStringVar(&awsConfig.S3Tags, "s3-tag", nil, "Tag set on all objects uploaded to S3 as key=value, e.g. team=ops")
+	rootCmd.PersistentFlags().StringVar(&awsConfig.S3ServerSideEncryption, "s3-sse", "", "Server-side encryption of objects uploaded to S3 [AES256, aws:kms, aws:kms:dsse]")
+	rootCmd.PersistentFlags().StringVar(&awsConfig.S3KMSKeyID, "s3-sse-kms-key-id", "", "KMS key objects uploaded to S3 are encrypted with; implies --s3-sse aws:kms")
+	rootCmd.PersistentFlags().StringVar(&awsConfig.S3ACL, "s3-acl", "", "Canned ACL of objects uploaded to S3, e.g. bucket-owner-full-control")
+	rootCmd.PersistentFlags().StringVar(&awsConfig.S3StorageClass, "s3-storage-class", "", "Storage class of objects uploaded to S3, e.g. STANDARD_IA")
+	rootCmd.PersistentFlags().Int64Var(&bandwidth.MaxDownloadRate, "max-download-rate", 0, "Maximum bytes per second of registry pulls, downloads and S3 or SFTP reads; 0 disables the cap")
+	rootCmd.PersistentFlags().Int64Var(&bandwidth.MaxUploadRate, "max-upload-rate", 0, "Maximum bytes per second of registry pushes and S3 or SFTP uploads; 0 disables the cap")
 
 	rootCmd.AddCommand(sealCmd)
 	sealCmd.Flags().StringVarP(&conf.Seal.PrivKeyPath, "privkey", "p", "", "Path to the private signing key. AWS KMS keys can be used with awskms:/// prefix")
-	sealCmd.Flags().StringSliceVarP(&conf.Seal.RecipientPubKeyPaths, "recipient-pubkey", "r", make([]string, 0), "Paths of recipients' public keys")
-	sealCmd.Flags().StringVarP(&conf.Seal.Output, "output", "o", "", "Filename to store the result in")
+	sealCmd.Flags().StringSliceVarP(&conf.Seal.RecipientPubKeyPaths, "recipient-pubkey", "r", make([]string, 0), "Paths of recipients' public keys, keyring files or directories of those")
+	sealCmd.Flags().BoolVar(&conf.Seal.RecipientHints, "recipient-hints", false, "Record the fingerprints of the recipient keys, so inspect shows whom the package is sealed for")
+	sealCmd.Flags().BoolVar(&conf.Seal.AllowDualUse, "allow-dual-use", false, "Allow signing with recipient keys, sealing for signing keys and sealing for the signing key itself")
+	sealCmd.Flags().StringVar(&conf.Seal.FleetKeyPath, "fleet-key", "", "Path to a fleet master secret; the package key is wrapped for the keys derived from it for all --fleet-devices")
+	sealCmd.Flags().StringVar(&conf.Seal.FleetDevicesPath, "fleet-devices", "", "File listing the IDs of the fleet devices to seal for with --fleet-key, one per line")
+	sealCmd.Flags().StringVar(&conf.Seal.FleetID, "fleet-id", sealpack.DefaultFleetID, "ID of the fleet the package key is derived for with --fleet-key")
+	sealCmd.Flags().StringVarP(&conf.Seal.Output, "output", "o", "", "Filename to store the result in; may be provided by the selected profile")
 	_ = sealCmd.MarkFlagRequired("privkey")
-	_ = sealCmd.MarkFlagRequired("output")
 	sealCmd.Flags().BoolVar(&conf.Seal.Public, "public", false, "Don't encrypt, contents are signed only and can be retrieved from any receiver")
 	sealCmd.Flags().StringVarP(&conf.Seal.ContentFileName, "contents", "c", "", "Provide all contents as a central configurations file (supports JSON, YAML)")
+	sealCmd.Flags().StringToStringVar(&conf.Seal.Variables, "set", nil, "Set variables used in the contents file as key=value; environment variables are used otherwise")
+	sealCmd.Flags().StringVar(&conf.Seal.Profile, "profile", "", "Name of the profile in the contents file to seal for")
 	sealCmd.Flags().StringSliceVarP(&conf.Seal.Files, "file", "f", make([]string, 0), "Path to the files to be added")
+	sealCmd.Flags().StringArrayVar(&conf.Seal.Nested, "nested", make([]string, 0), "Sealed package to add as nested package, given as path or path=target; unseal --recursive unseals it into the target directory")
+	sealCmd.Flags().StringVar(&conf.Seal.FromTar, "from-tar", "", "Add the regular files of an existing tarball, optionally gzip compressed; '-' reads it from stdin")
 	sealCmd.Flags().StringSliceVarP(&conf.Seal.ImageNames, "image", "i", make([]string, 0), "Name of container images to be added")
+	sealCmd.Flags().StringVar(&conf.Seal.ImageCache, "image-cache", "", "Directory caching the layers of pulled images, so repeated seals do not download them again")
+	sealCmd.Flags().Int64Var(&conf.Seal.ImageCacheSize, "image-cache-size", 10<<30, "Maximum bytes of the image cache; the least recently used layers are evicted, 0 disables the bound")
+	sealCmd.Flags().StringVar(&conf.Seal.ImageDir, "image-dir", "", "Read images from pre-staged tarballs or OCI layouts in this directory instead of pulling them, e.g. in air-gapped enclaves")
 	sealCmd.Flags().StringVarP(&conf.Seal.HashingAlgorithm, "hashing-algorithm", "a", "SHA512", "Name of hashing algorithm to be used")
-	sealCmd.Flags().StringVarP(&conf.Seal.CompressionAlgorithm, "compression-algorithm", "z", "gzip", "Name of compression algorithm to be used [gzip, zlib, zip, flate]")
+	sealCmd.Flags().StringVar(&conf.Seal.SignatureHash, "signature-hash", "SHA256", "Hash used for signing the TOC [SHA256, SHA384, SHA512, Ed25519ph]; recorded in the package for unseal")
+	sealCmd.Flags().StringVarP(&conf.Seal.CompressionAlgorithm, "compression-algorithm", "z", "gzip", "Name of compression algorithm to be used [gzip, zlib, zip, flate, zstd]")
+	sealCmd.Flags().BoolVar(&conf.Seal.Strict, "strict", true, "Fail on unknown hashing or compression algorithm names; --strict=false falls back to SHA512 and gzip")
+	sealCmd.Flags().IntVar(&conf.Seal.CompressionLevel, "compression-level", 0, "Compression level, 1-9 for gzip, zlib and flate, 1-22 for zstd; 0 uses the default level")
+	sealCmd.Flags().IntVar(&conf.Seal.CompressionThreads, "compression-threads", 0, "Number of threads used by gzip and zstd compression; 0 uses all CPUs")
+	sealCmd.Flags().StringVar(&conf.Seal.OnDuplicate, "on-duplicate", "fail", "Policy for contents stored with the same name [fail, skip]; skip keeps the first one")
+	sealCmd.Flags().StringVar(&conf.Seal.Symlinks, "symlinks", "follow", "Policy for symbolic links among the files [follow, preserve, reject]; preserve stores the links instead of their targets")
+	sealCmd.Flags().BoolVar(&conf.Seal.ImageSignatures, "image-signatures", false, "Bundle the cosign signatures of all images, so they can be verified against an image policy on unseal")
+	sealCmd.Flags().BoolVar(&conf.Seal.Provenance, "provenance", false, "Embed a signed in-toto/SLSA provenance statement of all contents in the package")
+	sealCmd.Flags().BoolVar(&conf.Seal.Origins, "origins", false, "Record the origin of every entry (source path, URL, image digest), signed with the contents and printed by inspect --origins")
+	sealCmd.Flags().StringVar(&conf.Seal.OriginCommit, "origin-commit", "", "Commit of the build input recorded in the origins of all entries, implies --origins")
+	sealCmd.Flags().BoolVar(&conf.Seal.Rekor, "rekor", false, "Record the TOC signature in the Rekor transparency log and embed the inclusion proof for offline verification")
+	sealCmd.Flags().StringVar(&conf.Seal.RekorURL, "rekor-url", sealpack.DefaultRekorURL, "Rekor instance the TOC signature is recorded in with --rekor")
+	sealCmd.Flags().StringSliceVar(&conf.Seal.RestartUnits, "restart-unit", make([]string, 0), "Systemd units to restart after unsealing with --systemd, signed with the contents")
+	sealCmd.Flags().Uint8Var(&conf.Seal.EnvelopeVersion, "envelope-version", 6, "Envelope format version; 6 protects the header with a checksum, 5 encrypts the payload in frames, 4 signs header and keys, 3 supports keys of any length, 2 adds a checksum trailer, 1 can be read by sealpack versions without it")
+	sealCmd.Flags().BoolVar(&conf.Seal.NoSparse, "no-sparse", false, "Store sparse files fully expanded, e.g. for devices running sealpack versions without sparse file support")
+	sealCmd.Flags().BoolVar(&conf.Seal.Dedup, "dedup", false, "Store files as content-defined chunks, so repeated chunks across all files are only stored once")
+	sealCmd.Flags().BoolVar(&conf.Seal.DedupLayers, "dedup-layers", false, "Store the layers of all images once, so images sharing base layers do not repeat them")
+	sealCmd.Flags().BoolVar(&conf.Seal.Index, "index", false, "Store an index of all entries, so single files can be extracted with extract-one; needs gzip, zstd or zip compression")
+	sealCmd.Flags().StringVar(&conf.Seal.Channel, "channel", "", "Release channel the package is sealed for, e.g. dev, beta or stable; signed with the envelope and listed by index")
+	sealCmd.Flags().StringArrayVar(&conf.Seal.Annotations, "annotation", make([]string, 0), "Annotation as key=value, e.g. ticket=OPS-123; signed with the envelope and filterable by inspect and index")
+	sealCmd.Flags().StringVar(&conf.Seal.ReportPath, "report", "", "Write a JSON report of all processed contents to this file ('-' for stdout)")
+	sealCmd.Flags().StringVar(&conf.Seal.TocPath, "export-toc", "", "Also write the signed TOC to this file, so the extracted contents can be verified with check later")
+	sealCmd.Flags().StringVar(&conf.Seal.PresignPath, "presign", "", "Write a presigned download link of the S3 output to this file ('-' for stdout), e.g. for partners without bucket access")
+	sealCmd.Flags().DurationVar(&conf.Seal.PresignValidity, "presign-validity", 24*time.Hour, "Validity of the download link written with --presign, at most 168h")
+	sealCmd.Flags().StringVar(&conf.Seal.AuditLogPath, "audit-log", "", "Append a record of the operation to this audit log (JSON lines)")
+	sealCmd.Flags().StringVar(&conf.Seal.AuditKeyPath, "audit-key", "", "Sign audit records with HMAC-SHA256 using the secret in this file")
+	sealCmd.Flags().StringArrayVar(&conf.Seal.HTTPHeaders, "http-header", make([]string, 0), "Header sent with all downloads of http:// and https:// files as 'Name: value', e.g. for authorization")
+	sealCmd.Flags().BoolVar(&conf.Seal.DryRun, "dry-run", false, "Only resolve contents and image manifests and print the resulting TOC with estimated sizes")
+
+	rootCmd.AddCommand(convertCmd)
+	convertCmd.Flags().StringVarP(&conf.Seal.PrivKeyPath, "privkey", "p", "", "Path to the private signing key. AWS KMS keys can be used with awskms:/// prefix")
+	convertCmd.Flags().StringSliceVarP(&conf.Seal.RecipientPubKeyPaths, "recipient-pubkey", "r", make([]string, 0), "Paths of recipients' public keys, keyring files or directories of those")
+	convertCmd.Flags().BoolVar(&conf.Seal.RecipientHints, "recipient-hints", false, "Record the fingerprints of the recipient keys, so inspect shows whom the package is sealed for")
+	convertCmd.Flags().StringVar(&conf.Seal.FleetKeyPath, "fleet-key", "", "Path to a fleet master secret; the package key is wrapped for the keys derived from it for all --fleet-devices")
+	convertCmd.Flags().StringVar(&conf.Seal.FleetDevicesPath, "fleet-devices", "", "File listing the IDs of the fleet devices to seal for with --fleet-key, one per line")
+	convertCmd.Flags().StringVar(&conf.Seal.FleetID, "fleet-id", sealpack.DefaultFleetID, "ID of the fleet the package key is derived for with --fleet-key")
+	convertCmd.Flags().BoolVar(&conf.Seal.Public, "public", false, "Don't encrypt, contents are signed only and can be retrieved from any receiver")
+	convertCmd.Flags().StringVarP(&conf.Seal.Output, "output", "o", "", "Filename to store the sealed package in")
+	_ = convertCmd.MarkFlagRequired("privkey")
+	_ = convertCmd.MarkFlagRequired("output")
+	convertCmd.Flags().StringVarP(&conf.Seal.HashingAlgorithm, "hashing-algorithm", "a", "SHA512", "Name of hashing algorithm to be used")
+	convertCmd.Flags().StringVar(&conf.Seal.SignatureHash, "signature-hash", "SHA256", "Hash used for signing the TOC [SHA256, SHA384, SHA512, Ed25519ph]; recorded in the package for unseal")
+	convertCmd.Flags().StringVarP(&conf.Seal.CompressionAlgorithm, "compression-algorithm", "z", "gzip", "Name of compression algorithm to be used [gzip, zlib, zip, flate, zstd]")
+	convertCmd.Flags().IntVar(&conf.Seal.CompressionLevel, "compression-level", 0, "Compression level, 1-9 for gzip, zlib and flate, 1-22 for zstd; 0 uses the default level")
+	convertCmd.Flags().StringVar(&conf.Seal.OnDuplicate, "on-duplicate", "fail", "Policy for files stored with the same name in the artifact [fail, skip]; skip keeps the first one")
+	convertCmd.Flags().Uint8Var(&conf.Seal.EnvelopeVersion, "envelope-version", 6, "Envelope format version, see seal")
+	convertCmd.Flags().BoolVar(&conf.Seal.Dedup, "dedup", false, "Store files as content-defined chunks, so repeated chunks across all files are only stored once")
+	convertCmd.Flags().BoolVar(&conf.Seal.Index, "index", false, "Store an index of all entries, so single files can be extracted with extract-one; needs gzip, zstd or zip compression")
+	convertCmd.Flags().StringVar(&conf.Seal.Channel, "channel", "", "Release channel the package is sealed for, e.g. dev, beta or stable")
+	convertCmd.Flags().StringArrayVar(&conf.Seal.Annotations, "annotation", make([]string, 0), "Annotation as key=value, e.g. ticket=OPS-123; signed with the envelope")
+	convertCmd.Flags().StringVar(&conf.Seal.ReportPath, "report", "", "Write a JSON report of all processed contents to this file ('-' for stdout)")
+	convertCmd.Flags().StringVar(&conf.Seal.PresignPath, "presign", "", "Write a presigned download link of the S3 output to this file ('-' for stdout)")
+	convertCmd.Flags().DurationVar(&conf.Seal.PresignValidity, "presign-validity", 24*time.Hour, "Validity of the download link written with --presign, at most 168h")
+	convertCmd.Flags().StringVar(&conf.Seal.AuditLogPath, "audit-log", "", "Append a record of the operation to this audit log (JSON lines)")
+	convertCmd.Flags().StringVar(&conf.Seal.AuditKeyPath, "audit-key", "", "Sign audit records with HMAC-SHA256 using the secret in this file")
+	convertCmd.Flags().BoolVar(&conf.Seal.DryRun, "dry-run", false, "Only read the artifact and print the resulting TOC")
+
+	rootCmd.AddCommand(estimateCmd)
+	estimateCmd.Flags().StringVarP(&conf.Seal.PrivKeyPath, "privkey", "p", "", "Path to the private signing key; the envelope signature is only accounted for if provided")
+	estimateCmd.Flags().StringSliceVarP(&conf.Seal.RecipientPubKeyPaths, "recipient-pubkey", "r", make([]string, 0), "Paths of recipients' public keys, keyring files or directories of those")
+	estimateCmd.Flags().BoolVar(&conf.Seal.RecipientHints, "recipient-hints", false, "Account for the fingerprints of the recipient keys recorded in the envelope")
+	estimateCmd.Flags().BoolVar(&conf.Seal.AllowDualUse, "allow-dual-use", false, "Allow signing with recipient keys, sealing for signing keys and sealing for the signing key itself")
+	estimateCmd.Flags().StringVar(&conf.Seal.FleetKeyPath, "fleet-key", "", "Path to a fleet master secret the package key would be wrapped for")
+	estimateCmd.Flags().StringVar(&conf.Seal.FleetDevicesPath, "fleet-devices", "", "File listing the IDs of the fleet devices the package key would be wrapped for")
+	estimateCmd.Flags().StringVar(&conf.Seal.FleetID, "fleet-id", sealpack.DefaultFleetID, "ID of the fleet the package key would be derived for with --fleet-key")
+	estimateCmd.Flags().BoolVar(&conf.Seal.Public, "public", false, "Estimate a package that is not encrypted")
+	estimateCmd.Flags().StringVarP(&conf.Seal.ContentFileName, "contents", "c", "", "Provide all contents as a central configurations file (supports JSON, YAML)")
+	estimateCmd.Flags().StringToStringVar(&conf.Seal.Variables, "set", nil, "Set variables used in the contents file as key=value; environment variables are used otherwise")
+	estimateCmd.Flags().StringVar(&conf.Seal.Profile, "profile", "", "Name of the profile in the contents file to estimate")
+	estimateCmd.Flags().StringSliceVarP(&conf.Seal.Files, "file", "f", make([]string, 0), "Path to the files to be added")
+	estimateCmd.Flags().StringArrayVar(&conf.Seal.Nested, "nested", make([]string, 0), "Sealed package to add as nested package, given as path or path=target")
+	estimateCmd.Flags().StringVar(&conf.Seal.FromTar, "from-tar", "", "Add the regular files of an existing tarball, optionally gzip compressed; '-' reads it from stdin")
+	estimateCmd.Flags().StringSliceVarP(&conf.Seal.ImageNames, "image", "i", make([]string, 0), "Name of container images to be added")
+	estimateCmd.Flags().StringVar(&conf.Seal.ImageDir, "image-dir", "", "Read image manifests from pre-staged tarballs or OCI layouts in this directory instead of registries")
+	estimateCmd.Flags().StringVarP(&conf.Seal.HashingAlgorithm, "hashing-algorithm", "a", "SHA512", "Name of hashing algorithm to be used")
+	estimateCmd.Flags().StringVar(&conf.Seal.SignatureHash, "signature-hash", "SHA256", "Hash used for signing the TOC [SHA256, SHA384, SHA512, Ed25519ph]")
+	estimateCmd.Flags().StringVarP(&conf.Seal.CompressionAlgorithm, "compression-algorithm", "z", "gzip", "Name of compression algorithm to be used [gzip, zlib, zip, flate, zstd]")
+	estimateCmd.Flags().IntVar(&conf.Seal.CompressionLevel, "compression-level", 0, "Compression level, 1-9 for gzip, zlib and flate, 1-22 for zstd; 0 uses the default level")
+	estimateCmd.Flags().Uint8Var(&conf.Seal.EnvelopeVersion, "envelope-version", 6, "Envelope format version, see seal")
+	estimateCmd.Flags().BoolVar(&conf.Seal.Index, "index", false, "Account for an index of all entries; needs gzip, zstd or zip compression")
+	estimateCmd.Flags().StringVar(&conf.Seal.Channel, "channel", "", "Release channel the package would be sealed for")
+	estimateCmd.Flags().StringArrayVar(&conf.Seal.Annotations, "annotation", make([]string, 0), "Annotation as key=value, e.g. ticket=OPS-123")
+	estimateCmd.Flags().StringArrayVar(&conf.Seal.HTTPHeaders, "http-header", make([]string, 0), "Header sent with requests for http:// and https:// files as 'Name: value', e.g. for authorization")
 
 	rootCmd.AddCommand(inspectCmd)
+	inspectCmd.Flags().BoolVar(&showProvenance, "provenance", false, "Print the signed provenance statement of the package")
+	inspectCmd.Flags().BoolVar(&showOrigins, "origins", false, "Print the origins recorded for all entries of the package")
+	inspectCmd.Flags().StringArrayVar(&annotationFilters, "annotation", make([]string, 0), "Fail unless the package has the annotation, given as key=value or key for any value")
+	inspectCmd.Flags().StringVarP(&conf.Unseal.PrivKeyPath, "privkey", "p", "", "Private key of the receiver, required to read the provenance or origins of sealed packages")
+	inspectCmd.Flags().StringVar(&conf.Unseal.FleetKeyPath, "fleet-key", "", "Path to the device key derived with key fleet-device, used instead of the private key for packages sealed for a fleet")
+	inspectCmd.Flags().StringVar(&conf.Unseal.FleetID, "fleet-id", sealpack.DefaultFleetID, "ID of the fleet to derive the package key for with --fleet-key")
+	inspectCmd.Flags().StringVarP(&conf.Unseal.SigningKeyPath, "signer-key", "s", "", "Public key of the signing entity to verify the provenance or origins with, required for the provenance")
+	inspectCmd.Flags().BoolVar(&conf.Unseal.AllowUnverifiedProvenance, "allow-unverified", false, "Print the provenance without --signer-key, leaving its signature unverified")
+	inspectCmd.Flags().BoolVar(&conf.Unseal.Recursive, "recursive", false, "Also inspect the nested packages declared by the package, as far as they can be decrypted with the private key")
+
+	rootCmd.AddCommand(diagnoseCmd)
+
+	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().StringVarP(&conf.Unseal.PrivKeyPath, "privkey", "p", "", "Private key of the receiver")
+	listCmd.Flags().StringVar(&conf.Unseal.FleetKeyPath, "fleet-key", "", "Path to the device key derived with key fleet-device, used instead of the private key for packages sealed for a fleet")
+	listCmd.Flags().StringVar(&conf.Unseal.FleetID, "fleet-id", sealpack.DefaultFleetID, "ID of the fleet to derive the package key for with --fleet-key")
+
+	rootCmd.AddCommand(extractOneCmd)
+	extractOneCmd.Flags().StringVar(&extractPath, "path", "", "Name of the file inside the archive, as shown by list")
+	_ = extractOneCmd.MarkFlagRequired("path")
+	extractOneCmd.Flags().StringVarP(&conf.Unseal.PrivKeyPath, "privkey", "p", "", "Private key of the receiver")
+	extractOneCmd.Flags().StringVar(&conf.Unseal.FleetKeyPath, "fleet-key", "", "Path to the device key derived with key fleet-device, used instead of the private key for packages sealed for a fleet")
+	extractOneCmd.Flags().StringVar(&conf.Unseal.FleetID, "fleet-id", sealpack.DefaultFleetID, "ID of the fleet to derive the package key for with --fleet-key")
+	extractOneCmd.Flags().StringVarP(&conf.Unseal.SigningKeyPath, "signer-key", "s", "", "Public key of the signing entity")
+	extractOneCmd.Flags().BoolVar(&conf.Unseal.AllowUnsignedEnvelope, "allow-unsigned-envelope", false, "Accept packages without envelope signature (envelope versions 1 to 3)")
+	_ = extractOneCmd.MarkFlagRequired("signer-key")
+	extractOneCmd.Flags().StringVarP(&conf.Unseal.HashingAlgorithm, "hashing-algorithm", "a", "SHA512", "Name of hashing algorithm the package was sealed with")
+	extractOneCmd.Flags().StringVarP(&conf.Unseal.OutputPath, "output", "o", ".", "Output path to extract the file to")
+	extractOneCmd.Flags().StringArrayVar(&conf.Unseal.HTTPHeaders, "http-header", make([]string, 0), "Header sent with all requests for http:// and https:// packages as 'Name: value', e.g. for authorization")
+
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVar(&expectedTocPath, "expected-toc", "", "Expected TOC in sha256sum format, one '<digest>  <name>' line per entry")
+	_ = verifyCmd.MarkFlagRequired("expected-toc")
+	verifyCmd.Flags().StringVar(&digestAlgorithm, "digest-algorithm", "SHA256", "Name of hashing algorithm the digests of the expected TOC are calculated with")
+	verifyCmd.Flags().StringVarP(&conf.Unseal.PrivKeyPath, "privkey", "p", "", "Private key of the receiver")
+	verifyCmd.Flags().StringVar(&conf.Unseal.FleetKeyPath, "fleet-key", "", "Path to the device key derived with key fleet-device, used instead of the private key for packages sealed for a fleet")
+	verifyCmd.Flags().StringVar(&conf.Unseal.FleetID, "fleet-id", sealpack.DefaultFleetID, "ID of the fleet to derive the package key for with --fleet-key")
+	verifyCmd.Flags().StringVarP(&conf.Unseal.SigningKeyPath, "signer-key", "s", "", "Public key of the signing entity")
+	verifyCmd.Flags().BoolVar(&conf.Unseal.AllowUnsignedEnvelope, "allow-unsigned-envelope", false, "Accept packages without envelope signature (envelope versions 1 to 3)")
+	_ = verifyCmd.MarkFlagRequired("signer-key")
+	verifyCmd.Flags().StringVarP(&conf.Unseal.HashingAlgorithm, "hashing-algorithm", "a", "SHA512", "Name of hashing algorithm the package was sealed with")
+
+	rootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringVar(&manifestPath, "manifest", "", "Manifest written by unseal --manifest or seal --export-toc")
+	_ = checkCmd.MarkFlagRequired("manifest")
+	checkCmd.Flags().StringVar(&conf.Unseal.OutputPath, "root", "", "Directory the package was unsealed to")
+	_ = checkCmd.MarkFlagRequired("root")
+	checkCmd.Flags().StringVarP(&conf.Unseal.SigningKeyPath, "signer-key", "s", "", "Public key of the signing entity")
+	_ = checkCmd.MarkFlagRequired("signer-key")
+
+	rootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().StringVarP(&conf.Unseal.SigningKeyPath, "signer-key", "s", "", "Public key matching the key the rollback bundle was signed with")
+	_ = rollbackCmd.MarkFlagRequired("signer-key")
+	rollbackCmd.Flags().StringVarP(&conf.Unseal.HashingAlgorithm, "hashing-algorithm", "a", "SHA512", "Name of hashing algorithm the rollback bundle was written with")
+	rollbackCmd.Flags().BoolVar(&conf.Unseal.DryRun, "dry-run", false, "Only verify the rollback bundle and show what would be reverted")
+
+	rootCmd.AddCommand(keygenCmd)
+	keygenCmd.Flags().StringVarP(&conf.Keygen.KeyType, "type", "t", "rsa", "Type of the key [rsa, ecdsa, ed25519]")
+	keygenCmd.Flags().IntVarP(&conf.Keygen.Bits, "bits", "b", 0, "Size of the key in bits; defaults to 4096 for rsa and 384 for ecdsa")
+	keygenCmd.Flags().StringVarP(&conf.Keygen.Usage, "usage", "u", "signing", "Usage of the key [signing, recipient]")
+	keygenCmd.Flags().StringVarP(&conf.Keygen.Output, "output", "o", "sealpack", "Prefix of the resulting files <output>-private.pem and <output>-public.pem")
+	keygenCmd.Flags().BoolVar(&askPassphrase, "passphrase", false, "Protect the private key with a passphrase (read from "+sealpack.PassphraseEnvVar+" or prompted)")
+
+	rootCmd.AddCommand(enrollCmd)
+	enrollCmd.Flags().StringVar(&conf.Enroll.DeviceID, "device-id", "", "ID of the device, used as common name of the certificate request")
+	_ = enrollCmd.MarkFlagRequired("device-id")
+	enrollCmd.Flags().StringVar(&conf.Enroll.KeyStore, "key-store", sealpack.KeyStoreFile, "Where to create the device key [file, tpm]; tpm requires the tpm2-tools")
+	enrollCmd.Flags().StringVarP(&conf.Enroll.PrivKeyPath, "privkey", "p", "/etc/sealpack/device-private.pem", "Path of the private key file with --key-store file")
+	enrollCmd.Flags().StringVar(&conf.Enroll.TPMHandle, "tpm-handle", sealpack.DefaultTPMHandle, "Persistent handle of the key with --key-store tpm")
+	enrollCmd.Flags().StringVarP(&conf.Enroll.Output, "output", "o", "device", "Prefix of the resulting files <output>-public.pem and <output>.csr")
+	enrollCmd.Flags().BoolVar(&conf.Enroll.CSR, "csr", false, "Also write a certificate request for the device ID, signed with the device key (file key store only)")
+	enrollCmd.Flags().BoolVar(&askPassphrase, "passphrase", false, "Protect the private key file with a passphrase (read from "+sealpack.PassphraseEnvVar+" or prompted)")
+
+	rootCmd.AddCommand(indexCmd)
+	indexCmd.Flags().StringVarP(&conf.Catalog.PrivKeyPath, "privkey", "p", "", "Path to the private key the catalog is signed with. AWS KMS keys can be used with awskms:/// prefix")
+	_ = indexCmd.MarkFlagRequired("privkey")
+	indexCmd.Flags().StringVarP(&conf.Catalog.Output, "output", "o", "catalog.json", "File to write the signed catalog to; s3:// URIs and '-' for stdout are supported")
+	indexCmd.Flags().StringVar(&conf.Catalog.BaseURL, "base-url", "", "URL the packages are downloaded from, joined with their relative paths; defaults to their paths")
+	indexCmd.Flags().StringArrayVar(&conf.Catalog.Annotations, "annotation", make([]string, 0), "Only catalog packages with the annotation, given as key=value or key for any value")
+
+	rootCmd.AddCommand(mirrorCmd)
+	mirrorCmd.Flags().StringArrayVar(&conf.Mirror.HTTPHeaders, "http-header", make([]string, 0), "Header sent with all requests for http:// and https:// packages as 'Name: value', e.g. for authorization")
+	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().IntVar(&conf.Prune.KeepLast, "keep-last", 0, "Number of most recently modified packages kept per channel")
+	pruneCmd.Flags().DurationVar(&conf.Prune.MaxAge, "max-age", 0, "Age after which packages are removed, e.g. 720h")
+	pruneCmd.Flags().BoolVar(&conf.Prune.DryRun, "dry-run", false, "Only list the packages which would be removed")
+	pruneCmd.Flags().StringVarP(&conf.Prune.Catalog.PrivKeyPath, "privkey", "p", "", "Private key to sign a new catalog of the remaining packages with; no catalog is written if empty")
+	pruneCmd.Flags().StringVarP(&conf.Prune.Catalog.Output, "output", "o", "catalog.json", "File to write the new catalog to, as for index")
+	pruneCmd.Flags().StringVar(&conf.Prune.Catalog.BaseURL, "base-url", "", "URL the packages are downloaded from, as for index")
+	rootCmd.AddCommand(promoteCmd)
+	promoteCmd.Flags().StringVarP(&conf.Promote.SigningKeyPath, "signer-key", "s", "", "Public key the catalog was signed with")
+	_ = promoteCmd.MarkFlagRequired("signer-key")
+	promoteCmd.Flags().StringVarP(&conf.Promote.PrivKeyPath, "privkey", "p", "", "Path to the private key the promoted catalog is signed with. AWS KMS keys can be used with awskms:/// prefix")
+	_ = promoteCmd.MarkFlagRequired("privkey")
+	promoteCmd.Flags().StringVar(&conf.Promote.Package, "package", "", "Name of the package to promote")
+	_ = promoteCmd.MarkFlagRequired("package")
+	promoteCmd.Flags().StringVar(&conf.Promote.Version, "version", "", "Version of the package to promote")
+	_ = promoteCmd.MarkFlagRequired("version")
+	promoteCmd.Flags().StringVar(&conf.Promote.Channel, "channel", "", "Channel to move the package to, e.g. stable")
+	_ = promoteCmd.MarkFlagRequired("channel")
+	promoteCmd.Flags().StringVarP(&conf.Promote.Output, "output", "o", "", "File to write the promoted catalog to; s3:// URIs and '-' for stdout are supported. Defaults to the catalog")
+	rootCmd.AddCommand(keyCmd)
+	keyCmd.AddCommand(keyInfoCmd)
+	keyCmd.AddCommand(keyFleetDeviceCmd)
+	keyFleetDeviceCmd.Flags().StringVar(&fleetDevice.FleetKeyPath, "fleet-key", "", "Path to the fleet master secret")
+	_ = keyFleetDeviceCmd.MarkFlagRequired("fleet-key")
+	keyFleetDeviceCmd.Flags().StringVar(&fleetDevice.FleetID, "fleet-id", sealpack.DefaultFleetID, "ID of the fleet the device belongs to")
+	keyFleetDeviceCmd.Flags().StringVarP(&fleetDevice.Output, "output", "o", "", "File to write the device key to; defaults to <device ID>.fleet.key")
+
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+	auditVerifyCmd.Flags().StringVarP(&auditKeyPath, "key", "k", "", "Secret the audit log was signed with")
+	_ = auditVerifyCmd.MarkFlagRequired("key")
+
+	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(docsCmd)
+	docsCmd.Flags().BoolVar(&manPages, "man", false, "Generate man pages instead of markdown")
+	docsCmd.Flags().StringVarP(&docsDir, "dir", "d", ".", "Directory to write the documentation to")
+
+	rootCmd.AddCommand(unwrapCmd)
+	unwrapCmd.Flags().StringVarP(&conf.Unseal.PrivKeyPath, "privkey", "p", "", "Private key of the receiver")
+	unwrapCmd.Flags().StringVar(&conf.Unseal.FleetKeyPath, "fleet-key", "", "Path to the device key derived with key fleet-device, used instead of the private key for packages sealed for a fleet")
+	unwrapCmd.Flags().StringVar(&conf.Unseal.FleetID, "fleet-id", sealpack.DefaultFleetID, "ID of the fleet to derive the package key for with --fleet-key")
+	unwrapCmd.Flags().StringVarP(&conf.Unseal.SigningKeyPath, "signer-key", "s", "", "Public key of the signing entity")
+	_ = unwrapCmd.MarkFlagRequired("signer-key")
+	unwrapCmd.Flags().StringVarP(&conf.Unseal.HashingAlgorithm, "hashing-algorithm", "a", "SHA512", "Name of hashing algorithm the package was sealed with")
+	unwrapCmd.Flags().StringVarP(&conf.Unseal.OutputPath, "output", "o", "", "Tar archive to write the contents to")
+	_ = unwrapCmd.MarkFlagRequired("output")
+	unwrapCmd.Flags().StringVar(&detachedSignaturePath, "signature", "", "File to write the TOC signature to; defaults to the output with suffix .sig")
+	unwrapCmd.Flags().StringVar(&conf.Unseal.ImagePolicyPath, "image-policy", "", "Verify the bundled cosign signatures of all images against this policy before adding them")
+	unwrapCmd.Flags().Int64Var(&conf.Unseal.MaxTotalSize, "max-total-size", 0, "Maximum number of bytes of all contents together; 0 disables the limit")
+	unwrapCmd.Flags().Int64Var(&conf.Unseal.MaxFileSize, "max-file-size", 0, "Maximum number of bytes of a single file or image; 0 disables the limit")
+	unwrapCmd.Flags().IntVar(&conf.Unseal.MaxEntries, "max-entries", 0, "Maximum number of entries in the package, including its signed TOC; 0 disables the limit")
+	unwrapCmd.Flags().BoolVar(&conf.Unseal.AllowDualUse, "allow-dual-use", false, "Allow decrypting with signing keys, verifying with recipient keys and decrypting with the signing key itself")
+	unwrapCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Replace an existing output file without asking for confirmation")
 
 	rootCmd.AddCommand(unsealCmd)
 	unsealCmd.Flags().StringVarP(&conf.Unseal.PrivKeyPath, "privkey", "p", "", "Private key of the receiver")
+	unsealCmd.Flags().StringVar(&conf.Unseal.FleetKeyPath, "fleet-key", "", "Path to the device key derived with key fleet-device, used instead of the private key for packages sealed for a fleet")
+	unsealCmd.Flags().StringVar(&conf.Unseal.FleetID, "fleet-id", sealpack.DefaultFleetID, "ID of the fleet to derive the package key for with --fleet-key")
 	unsealCmd.Flags().StringVarP(&conf.Unseal.SigningKeyPath, "signer-key", "s", "", "Public key of the signing entity")
-	unsealCmd.Flags().StringVarP(&conf.Unseal.OutputPath, "output", "o", ".", "Output path to unpack the contents to")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.AllowUnsignedEnvelope, "allow-unsigned-envelope", false, "Accept packages without envelope signature (envelope versions 1 to 3), whose header and keys are not protected")
+	unsealCmd.Flags().StringVarP(&conf.Unseal.OutputPath, "output", "o", ".", "Output path to unpack the contents to, or the archive file for the tar and zip output formats")
+	unsealCmd.Flags().StringVar(&conf.Unseal.OutputFormat, "output-format", "dir", "Extract to a directory or write a verified plain archive instead [dir, tar, zip]")
 	_ = sealCmd.MarkFlagRequired("signer-key")
 	unsealCmd.Flags().StringVarP(&conf.Unseal.HashingAlgorithm, "hashing-algorithm", "a", "SHA512", "Name of hashing algorithm to be used")
 	unsealCmd.Flags().StringVarP(&conf.Unseal.TargetRegistry, "target-registry", "r", "local", "URL of the target registry to import container images; 'local' imports them locally")
 	unsealCmd.Flags().StringVarP(&conf.Unseal.Namespace, "namespace", "n", "default", "ContainerD namespace to import the images into")
+	unsealCmd.Flags().StringVar(&conf.Unseal.ReportPath, "report", "", "Write a JSON report of all processed contents to this file ('-' for stdout)")
+	unsealCmd.Flags().StringVar(&conf.Unseal.AuditLogPath, "audit-log", "", "Append a record of the operation to this audit log (JSON lines)")
+	unsealCmd.Flags().StringVar(&conf.Unseal.AuditKeyPath, "audit-key", "", "Sign audit records with HMAC-SHA256 using the secret in this file")
+	unsealCmd.Flags().StringVar(&conf.Unseal.ImageRefsPath, "image-refs", "", "Write the references of all imported images (registry/name:tag@digest) to this file ('-' for stdout)")
+	unsealCmd.Flags().StringVar(&conf.Unseal.ManifestPath, "manifest", "", "Write the signed TOC and the imported images to this file, so the installation can be verified with check later")
+	unsealCmd.Flags().StringVar(&conf.Unseal.ChecksumsPath, "checksums", "", "Write the SHA-256 sums of all written files in sha256sum format to this file ('-' for stdout)")
+	unsealCmd.Flags().Int64Var(&conf.Unseal.MaxTotalSize, "max-total-size", 0, "Maximum number of bytes of all contents together; 0 disables the limit")
+	unsealCmd.Flags().Int64Var(&conf.Unseal.MaxFileSize, "max-file-size", 0, "Maximum number of bytes of a single file or image; 0 disables the limit")
+	unsealCmd.Flags().IntVar(&conf.Unseal.MaxEntries, "max-entries", 0, "Maximum number of entries in the package, including its signed TOC; 0 disables the limit")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.Preallocate, "preallocate", false, "Allocate files of 16 MiB and more before writing them, so they cannot run out of space midway")
+	unsealCmd.Flags().IntVar(&conf.Unseal.Parallel, "parallel", 1, "Number of files written in parallel; images are always imported sequentially")
+	unsealCmd.Flags().StringVar(&conf.Unseal.ImagePolicyPath, "image-policy", "", "Verify the bundled cosign signatures of all images against this policy before importing them")
+	unsealCmd.Flags().StringVar(&conf.Unseal.RekorKeyPath, "rekor-key", "", "Public key of the Rekor log; require and verify offline that the TOC signature is recorded in it")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.ImageFallback, "image-fallback", false, "Store images as OCI files in the output path if no local containerd is available")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.Systemd, "systemd", false, "Restart the systemd units declared by the package and notify systemd about readiness after unsealing (Linux only)")
+	unsealCmd.Flags().StringVar(&conf.Unseal.StatePath, "state", "", "Record the packages applied to each output path in this file and skip unsealing a package applied before")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.Force, "force", false, "Unseal even if the state file records the package as applied already")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.AllowDualUse, "allow-dual-use", false, "Allow decrypting with signing keys, verifying with recipient keys and decrypting with the signing key itself")
+	unsealCmd.Flags().StringVar(&conf.Unseal.RollbackPath, "rollback-bundle", "", "Capture replaced files and previous image tags in this rollback bundle, so the unseal can be reverted with rollback")
+	unsealCmd.Flags().StringVar(&conf.Unseal.RollbackKeyPath, "rollback-key", "", "Private key to sign the rollback bundle with")
+	unsealCmd.Flags().DurationVar(&conf.Unseal.LockTimeout, "wait", 0, "Maximum time to wait for another unseal into the same output path or namespace; 0 waits until it finished")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.NoWait, "no-wait", false, "Fail immediately if another unseal into the same output path or namespace is running")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.ContinueOnError, "continue-on-error", false, "Continue with the remaining entries if a file cannot be written or an image cannot be imported, and list all failures at the end")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.PreserveTimes, "preserve-times", false, "Set the modification time of written files to the one recorded in the package")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.OnlyNarrowModes, "only-narrow-modes", false, "Never widen the mode of existing files; they keep the bits of their mode that are also recorded in the package")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.StrictEntries, "strict-entries", false, "Reject packages with other entries than regular files instead of creating directories and skipping links and other types")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.Scan, "scan", false, "Search for the envelope if other data was prepended to the package, e.g. by a transport")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.DryRun, "dry-run", false, "Only verify the package and list what would be written or imported")
+	unsealCmd.Flags().StringVar(&conf.Unseal.Chown, "chown", "", "Owner of created files and directories as user[:group], by name or numeric ID")
+	unsealCmd.Flags().StringVar(&conf.Unseal.ChmodMask, "chmod-mask", "", "Octal mask cleared from the mode of created files and directories, e.g. 027")
+	unsealCmd.Flags().StringVar(&conf.Unseal.OnConflict, "on-conflict", "overwrite", "Handling of files already present in the output path [skip, overwrite, backup, fail]; backup keeps them as <name>.bak")
+	unsealCmd.Flags().BoolVar(&conf.Unseal.Recursive, "recursive", false, "Unseal the nested packages declared by the package into their target directories below the output path")
+	unsealCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Unseal into a non-empty output path and re-tag existing images without asking for confirmation")
 
 	return rootCmd.ExecuteContext(context.WithValue(context.Background(), "config", conf))
 }
@@ -120,18 +747,61 @@ func ParseCommands() error {
 // main is the central entrypoint for sealpack.
 func main() {
 	log.SetHandler(jsonHandler.Default)
+	sealpack.SetPassphraseProvider(readPassphrase)
+	check(setupTelemetry(context.Background()))
 	// Parse CLI params and config
 	// Internally starts execution from cobra
 	check(ParseCommands())
+	flushTelemetry()
+}
+
+// logHandler creates the handler writing log messages in a format to stderr
+func logHandler(format string) (log.Handler, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		return jsonHandler.New(os.Stderr), nil
+	case "text":
+		return textHandler.New(os.Stderr), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, allowed values are 'text' and 'json'", format)
 }
 
 // check tests if an error is nil; if not, it logs the error and exits the program
 func check(err error, plus ...string) {
 	if err != nil {
-		log.Error(err.Error())
+		var logger log.Interface = log.Log
+		if hints := sealpack.Hints(err); len(hints) > 0 {
+			logger = log.WithField("hints", hints)
+		}
+		logger.Error(err.Error())
 		for _, e := range plus {
 			log.Error(e)
 		}
-		os.Exit(1)
+		flushTelemetry()
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode maps an error to the exit code of its failure class
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOk
+	case errors.Is(err, sealpack.ErrBadSignature):
+		return ExitBadSignature
+	case errors.Is(err, sealpack.ErrNotRecipient):
+		return ExitNotRecipient
+	case errors.Is(err, sealpack.ErrCorruptEnvelope):
+		return ExitCorruptEnvelope
+	case errors.Is(err, sealpack.ErrPartialImport):
+		return ExitPartialImport
+	case errors.Is(err, sealpack.ErrNetwork):
+		return ExitNetwork
+	case errors.Is(err, sealpack.ErrLimitExceeded):
+		return ExitLimitExceeded
+	case errors.Is(err, sealpack.ErrLocked):
+		return ExitLocked
+	default:
+		return ExitGeneric
 	}
 }
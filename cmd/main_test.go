@@ -15,7 +15,9 @@ package main
  */
 
 import (
+	"fmt"
 	"github.com/apex/log"
+	"github.com/innomotics/sealpack"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -54,3 +56,41 @@ func Test_RootCmd_SetLogLevel(t *testing.T) {
 		})
 	}
 }
+
+func Test_RootCmd_QuietAndLogFormat(t *testing.T) {
+	defer func() { logLevel, logFormat, quiet = "info", "json", false }()
+	logLevel, quiet = "debug", true
+	assert.NoError(t, rootCmd.PersistentPreRunE(nil, []string{}))
+	assert.Equal(t, log.ErrorLevel, log.Log.(*log.Logger).Level)
+
+	quiet = false
+	for _, format := range []string{"text", "JSON"} {
+		logFormat = format
+		assert.NoError(t, rootCmd.PersistentPreRunE(nil, []string{}))
+	}
+	logFormat = "xml"
+	assert.ErrorContains(t, rootCmd.PersistentPreRunE(nil, []string{}), "invalid log format")
+}
+
+func Test_exitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"no error", nil, ExitOk},
+		{"generic error", fmt.Errorf("fnord"), ExitGeneric},
+		{"bad signature", fmt.Errorf("%w: tocs not matching", sealpack.ErrBadSignature), ExitBadSignature},
+		{"not a recipient", fmt.Errorf("%w: foo", sealpack.ErrNotRecipient), ExitNotRecipient},
+		{"corrupt envelope", fmt.Errorf("%w: EOF", sealpack.ErrCorruptEnvelope), ExitCorruptEnvelope},
+		{"network failure", fmt.Errorf("%w: no such host", sealpack.ErrNetwork), ExitNetwork},
+		{"partial import over network", fmt.Errorf("%w: %w", sealpack.ErrPartialImport, sealpack.ErrNetwork), ExitPartialImport},
+		{"limit exceeded", fmt.Errorf("%w: too many entries", sealpack.ErrLimitExceeded), ExitLimitExceeded},
+		{"locked", fmt.Errorf("%w: held by process 42", sealpack.ErrLocked), ExitLocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, exitCode(tt.err))
+		})
+	}
+}
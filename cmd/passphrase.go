@@ -0,0 +1,64 @@
+package main
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/innomotics/sealpack"
+	"golang.org/x/term"
+	"os"
+)
+
+// readPassphrase gets the passphrase of an encrypted private key from the environment or prompts for it
+func readPassphrase(path string) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(sealpack.PassphraseEnvVar); ok {
+		return []byte(passphrase), nil
+	}
+	return prompt(fmt.Sprintf("Passphrase for %s: ", path))
+}
+
+// readNewPassphrase gets a new passphrase from the environment or prompts for it twice
+func readNewPassphrase() ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(sealpack.PassphraseEnvVar); ok {
+		return []byte(passphrase), nil
+	}
+	passphrase, err := prompt("New passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	repeated, err := prompt("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, repeated) {
+		return nil, fmt.Errorf("passphrases do not match")
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	return passphrase, nil
+}
+
+// prompt reads a secret from the terminal without echoing it
+func prompt(text string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no terminal available, provide the passphrase in %s", sealpack.PassphraseEnvVar)
+	}
+	_, _ = fmt.Fprint(os.Stderr, text)
+	defer fmt.Fprintln(os.Stderr)
+	return term.ReadPassword(fd)
+}
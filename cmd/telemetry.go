@@ -0,0 +1,87 @@
+package main
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"os"
+	"time"
+)
+
+const (
+	// otlpEndpointEnv enables the export of traces and metrics via OTLP when set
+	otlpEndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	// telemetryFlushTimeout limits how long exiting waits for pending telemetry to be exported
+	telemetryFlushTimeout = 5 * time.Second
+)
+
+// shutdownTelemetry flushes and stops the exporters registered by setupTelemetry
+var shutdownTelemetry = func(context.Context) error { return nil }
+
+// setupTelemetry registers OTLP/gRPC exporters for traces and metrics if OTEL_EXPORTER_OTLP_ENDPOINT is set.
+// Further settings are taken from the standard OTEL_* environment variables.
+func setupTelemetry(ctx context.Context) error {
+	if os.Getenv(otlpEndpointEnv) == "" {
+		return nil
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "sealpack")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return fmt.Errorf("telemetry: failed describing resource: %w", err)
+	}
+	traceExporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return fmt.Errorf("telemetry: failed creating trace exporter: %w", err)
+	}
+	metricExporter, err := otlpmetricgrpc.New(ctx)
+	if err != nil {
+		_ = traceExporter.Shutdown(ctx)
+		return fmt.Errorf("telemetry: failed creating metric exporter: %w", err)
+	}
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+	shutdownTelemetry = func(ctx context.Context) error {
+		return errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+	}
+	return nil
+}
+
+// flushTelemetry exports pending spans and metrics before the program exits; failures are only logged
+func flushTelemetry() {
+	ctx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+	defer cancel()
+	if err := shutdownTelemetry(ctx); err != nil {
+		log.Warnf("telemetry: failed exporting pending telemetry: %s", err.Error())
+	}
+	shutdownTelemetry = func(context.Context) error { return nil }
+}
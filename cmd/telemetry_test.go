@@ -0,0 +1,46 @@
+package main
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"testing"
+	"time"
+)
+
+func Test_SetupTelemetry_Disabled(t *testing.T) {
+	t.Setenv(otlpEndpointEnv, "")
+	provider := otel.GetTracerProvider()
+	assert.NoError(t, setupTelemetry(context.Background()))
+	assert.Equal(t, provider, otel.GetTracerProvider())
+	flushTelemetry()
+}
+
+func Test_SetupTelemetry_Endpoint(t *testing.T) {
+	t.Setenv(otlpEndpointEnv, "http://127.0.0.1:4317")
+	provider := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(provider)
+	// gRPC connects lazily, so no collector is needed for the setup
+	assert.NoError(t, setupTelemetry(context.Background()))
+	assert.IsType(t, &sdktrace.TracerProvider{}, otel.GetTracerProvider())
+	// Without a collector, exporting pending metrics can only fail
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = shutdownTelemetry(ctx)
+	shutdownTelemetry = func(context.Context) error { return nil }
+}
@@ -0,0 +1,42 @@
+package sealpack
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"fmt"
+	"github.com/innomotics/sealpack/internal"
+)
+
+// Convert seals an existing tar, tar.gz or zip artifact, e.g. a legacy update archive.
+// The names, modes and modification times of its files are kept; "-" reads a tar stream from stdin.
+// All other options of the SealConfig apply as for Seal, but no further contents may be configured.
+func Convert(artifact string, sealCfg *SealConfig) error {
+	if len(sealCfg.Files) > 0 || len(sealCfg.ImageNames) > 0 || sealCfg.ContentFileName != "" || sealCfg.FromTar != "" || sealCfg.FromZip != "" {
+		return fmt.Errorf("convert only seals the contents of the artifact, use seal to add further files or images")
+	}
+	if artifact == "-" {
+		sealCfg.FromTar = artifact
+		return Seal(sealCfg)
+	}
+	isZip, err := internal.IsZipArchive(artifact)
+	if err != nil {
+		return err
+	}
+	if isZip {
+		sealCfg.FromZip = artifact
+	} else {
+		sealCfg.FromTar = artifact
+	}
+	return Seal(sealCfg)
+}
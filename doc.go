@@ -0,0 +1,26 @@
+// Package sealpack creates sealed packages of files and container images and unseals them on the target devices.
+//
+// # Compatibility
+//
+// The module follows semantic versioning. Within a major version, the exported API of this package is stable:
+// functions, types, fields and error values are not removed or changed incompatibly, but new ones may be added.
+// Therefore, build config structs like SealConfig with field names and test errors with errors.Is.
+// Packages below internal are not part of the API and may change with any release.
+//
+// Packages sealed by any release can be unsealed by all later releases of the same major version.
+// A major version may raise the envelope version written by default, but keeps reading all earlier ones.
+package sealpack
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
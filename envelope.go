@@ -0,0 +1,118 @@
+package sealpack
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"github.com/innomotics/sealpack/internal"
+	"os"
+	"strings"
+)
+
+// ContainerImage is a container image sealed into a package
+type ContainerImage = internal.ContainerImage
+
+// Events receive the progress of sealing, unsealing and rolling back, see SealConfig.Events and UnsealConfig.Events
+type Events = internal.Events
+
+// NoEvents ignores all events; embed it to only implement the events of interest
+type NoEvents = internal.NoEvents
+
+// ReportEntry describes a file or image added or extracted, as passed to Events
+type ReportEntry = internal.ReportEntry
+
+// Diagnosis describes the sections of a possibly damaged package
+type Diagnosis = internal.Diagnosis
+
+// EnvelopeInfo describes the envelope of a package, as far as it can be read without any key
+type EnvelopeInfo struct {
+	// Version of the envelope format
+	Version uint8
+	// Public packages are not encrypted
+	Public bool
+	// Recipients is the number of recipient keys the package was sealed for
+	Recipients int
+	// RecipientFingerprints are the SHA-256 fingerprints of the recipient keys, if the package records them
+	RecipientFingerprints []string
+	// FleetKey is set if the package was sealed for a fleet key
+	FleetKey bool
+	// Deduplicated is set if the payload stores files as deduplicated chunks or images with shared layers
+	Deduplicated bool
+	// Indexed is set if the payload has an index, so single files can be extracted with ExtractOne
+	Indexed bool
+	// Channel is the release channel the package was sealed for, if it records one
+	Channel string
+	// Annotations are the signed key-value pairs the package was sealed with
+	Annotations map[string]string
+	// PayloadSize is the size of the compressed and encrypted payload in bytes
+	PayloadSize int64
+	// CompressionAlgorithm is the name of the algorithm the payload is compressed with
+	CompressionAlgorithm string
+	// HashingAlgorithm is the name of the hash the TOC is created with
+	HashingAlgorithm string
+	// Checksum is the verified SHA-256 checksum of the envelope, hex encoded, if it has one
+	Checksum string
+	// Signed is set if header, payload and keys are covered by an envelope signature, which is verified on unseal
+	Signed bool
+	// SignatureHash is the name of the hash of the envelope signature, if it is signed
+	SignatureHash string
+}
+
+// ReadEnvelope reads the envelope of a package and verifies its checksum, if it has one
+func ReadEnvelope(sealedFile string) (*EnvelopeInfo, error) {
+	raw, err := os.Open(sealedFile)
+	if err != nil {
+		return nil, err
+	}
+	defer raw.Close()
+	envelope, err := internal.ParseEnvelope(raw)
+	if err != nil {
+		return nil, err
+	}
+	info := &EnvelopeInfo{
+		Version:               max(envelope.Version, internal.EnvelopeVersion1),
+		Public:                len(envelope.ReceiverKeys) == 0,
+		Recipients:            len(envelope.ReceiverKeys),
+		RecipientFingerprints: envelope.RecipientFingerprints(),
+		FleetKey:              envelope.HasFleetKey(),
+		Deduplicated:          envelope.Flags&internal.EnvelopeFlagChunked != 0,
+		Indexed:               envelope.HasIndex(),
+		Channel:               envelope.Channel,
+		Annotations:           envelope.Annotations,
+		PayloadSize:           envelope.PayloadLen,
+		CompressionAlgorithm:  internal.GetCompressionAlgoName(envelope.CompressionAlgo),
+		// Names as accepted by HashingAlgorithm of SealConfig, e.g. SHA512 or SHA3256
+		HashingAlgorithm: strings.ReplaceAll(envelope.HashAlgorithm.String(), "-", ""),
+		Checksum:         hex.EncodeToString(envelope.Checksum),
+		Signed:           envelope.IsSigned(),
+		SignatureHash:    envelope.SignatureHash,
+	}
+	if info.FleetKey {
+		info.Recipients--
+	}
+	return info, nil
+}
+
+// MatchAnnotations determines whether the annotations of a package satisfy all filters, given as "key=value" or "key" for any value
+func (info *EnvelopeInfo) MatchAnnotations(filters []string) bool {
+	return internal.MatchAnnotations(info.Annotations, filters)
+}
+
+// Verify checks a package like Unseal does, i.e. its signatures and contents, without writing any file or importing any image
+func Verify(sealedFile string, config *UnsealConfig) error {
+	dryRun := *config
+	dryRun.DryRun = true
+	return Unseal(sealedFile, &dryRun)
+}
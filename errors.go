@@ -0,0 +1,43 @@
+package sealpack
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import "github.com/innomotics/sealpack/internal"
+
+// Failure classes returned by Seal, Unseal and Inspect. Test for them using errors.Is.
+var (
+	// ErrBadSignature is returned if the contents do not match the signed TOC or the signature is invalid.
+	ErrBadSignature = internal.ErrBadSignature
+	// ErrNotRecipient is returned if the package was not sealed for the provided private key.
+	ErrNotRecipient = internal.ErrNotRecipient
+	// ErrCorruptEnvelope is returned if the file is not a sealpack file or its structure is damaged.
+	ErrCorruptEnvelope = internal.ErrCorruptEnvelope
+	// ErrNetwork is returned if a registry, S3 or KMS operation failed on network level.
+	ErrNetwork = internal.ErrNetwork
+	// ErrPartialImport is returned if importing a container image failed after unsealing had started.
+	ErrPartialImport = internal.ErrPartialImport
+	// ErrNoContainerD is returned if images should be imported locally, but no containerd instance is available.
+	// It is always wrapped together with ErrPartialImport.
+	ErrNoContainerD = internal.ErrNoContainerD
+	// ErrLimitExceeded is returned if the contents of a package exceed the configured unpack limits.
+	ErrLimitExceeded = internal.ErrLimitExceeded
+	// ErrLocked is returned if the output path or namespace is locked by another unseal and waiting was not allowed.
+	ErrLocked = internal.ErrLocked
+)
+
+// Hints provides hints on how to resolve an error returned by sealpack, e.g. to show them to users
+func Hints(err error) []string {
+	return internal.Hints(err)
+}
@@ -0,0 +1,89 @@
+package sealpack
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"fmt"
+	"github.com/innomotics/sealpack/internal"
+	"io"
+)
+
+// Estimate resolves the contents of a seal and its image manifests without pulling images and reports the expected
+// raw, compressed and encrypted sizes of the package. Neither output nor signing key are required, without a signing
+// key the envelope signature is not accounted for.
+func Estimate(sealCfg *SealConfig) (*internal.SizeEstimate, error) {
+	estimate := *sealCfg
+	estimate.estimating = true
+	if err := prepareSealing(&estimate); err != nil {
+		return nil, err
+	}
+	header, err := internal.ParseHTTPHeaders(estimate.HTTPHeaders)
+	if err != nil {
+		return nil, err
+	}
+	providers, err := estimate.contentProviders(header, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = internal.CleanupImages() }() // Removes the files spooled from a tar stream
+	envelope, err := estimate.envelopeWithoutPayload()
+	if err != nil {
+		return nil, err
+	}
+	return internal.EstimateProviders(providers, envelope, estimate.compressionOptions(), estimate.Public)
+}
+
+// envelopeWithoutPayload creates the envelope a seal would write, with keys for all recipients of a random payload key
+func (sealCfg *SealConfig) envelopeWithoutPayload() (*internal.Envelope, error) {
+	envelope := &internal.Envelope{
+		Version:         sealCfg.EnvelopeVersion,
+		HashAlgorithm:   internal.GetHashAlgorithm(sealCfg.HashingAlgorithm),
+		CompressionAlgo: internal.GetCompressionAlgoIndex(sealCfg.CompressionAlgorithm),
+	}
+	if envelope.Version >= internal.EnvelopeVersion4 && sealCfg.PrivKeyPath != "" {
+		signer, err := internal.CreateSignerWithHash(sealCfg.PrivKeyPath, sealCfg.SignatureHash)
+		if err != nil {
+			return nil, fmt.Errorf("estimate: could not create signer: %v", err)
+		}
+		envelope.Signer, envelope.SignatureHash = signer, sealCfg.SignatureHash
+	}
+	if sealCfg.Index {
+		if err := envelope.SetIndex(&internal.WriteArchive{}); err != nil {
+			return nil, err
+		}
+	}
+	if sealCfg.Channel != "" {
+		if err := envelope.SetChannel(sealCfg.Channel); err != nil {
+			return nil, err
+		}
+	}
+	annotations, err := internal.ParseAnnotations(sealCfg.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if err = envelope.SetAnnotations(annotations); err != nil {
+		return nil, err
+	}
+	if sealCfg.Public {
+		return envelope, nil
+	}
+	arc := &internal.WriteArchive{}
+	if arc.EncryptionKey, _, err = internal.EncryptFramedWriter(io.Discard); err != nil {
+		return nil, err
+	}
+	if err = encryptKeys(nil, sealCfg, envelope, arc, &internal.AuditRecord{}); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
@@ -0,0 +1,125 @@
+package format
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Envelope is the structure of a sealpack file: the header, the location of the encrypted payload,
+// the key section and, if flagged, the checksum trailer
+type Envelope struct {
+	Header *Header
+	Keys   *Keys
+	// Trailer is nil for envelopes without FlagTrailer
+	Trailer *Trailer
+	// PayloadOffset is the position of the payload in the file
+	PayloadOffset int64
+	// KeysOffset is the position of the key section in the file
+	KeysOffset int64
+	// TrailerOffset is the position of the trailer in the file, which is the number of bytes it covers.
+	// For envelopes without trailer, it is the size of the file.
+	TrailerOffset int64
+}
+
+// Read is the reference reader of the envelope structure of a file of size bytes.
+// It reads header, key section and trailer, but neither the payload nor the checksums; use Verify for that.
+func Read(r io.ReaderAt, size int64) (*Envelope, error) {
+	buf := make([]byte, min(size, MaxHeaderSize))
+	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	h, err := ParseHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	e := &Envelope{Header: h, PayloadOffset: int64(h.Size()), TrailerOffset: size}
+	if h.PayloadLength > uint64(size-e.PayloadOffset) {
+		return nil, fmt.Errorf("%w: payload of %d bytes exceeds the file", ErrTruncated, h.PayloadLength)
+	}
+	e.KeysOffset = e.PayloadOffset + int64(h.PayloadLength)
+	if h.Has(FlagTrailer) {
+		// The trailer ends with its own length
+		end := make([]byte, min(size, 4))
+		if _, err = r.ReadAt(end, size-int64(len(end))); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(end) == 4 {
+			end = make([]byte, min(size, max(TrailerFixedSize, int64(binary.LittleEndian.Uint32(end)))))
+			if _, err = r.ReadAt(end, size-int64(len(end))); err != nil && err != io.EOF {
+				return nil, err
+			}
+		}
+		var length int
+		if e.Trailer, length, err = ParseTrailer(end); err != nil {
+			return nil, err
+		}
+		e.TrailerOffset = size - int64(length)
+		if e.TrailerOffset < e.KeysOffset {
+			return nil, fmt.Errorf("%w at byte %d, payload ends at byte %d", ErrTruncated, e.TrailerOffset, e.KeysOffset)
+		}
+	}
+	keys := make([]byte, e.TrailerOffset-e.KeysOffset)
+	if _, err = r.ReadAt(keys, e.KeysOffset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if e.Keys, err = ParseKeys(h, keys); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Verify checks the checksum trailer of the envelope read from r, if it has one
+func (e *Envelope) Verify(r io.ReaderAt) error {
+	if e.Trailer == nil {
+		return nil
+	}
+	return e.Trailer.Verify(io.NewSectionReader(r, 0, e.TrailerOffset))
+}
+
+// Payload provides a reader of the encrypted payload in r
+func (e *Envelope) Payload(r io.ReaderAt) *io.SectionReader {
+	return io.NewSectionReader(r, e.PayloadOffset, int64(e.Header.PayloadLength))
+}
+
+// Append appends the complete envelope with the payload to b, adding the trailer if FlagTrailer is set.
+// The payload length of the header is set to the size of payload.
+func (e *Envelope) Append(b, payload []byte) ([]byte, error) {
+	start := len(b)
+	e.Header.PayloadLength = uint64(len(payload))
+	b = append(e.Header.Append(b), payload...)
+	keys := e.Keys
+	if keys == nil {
+		keys = &Keys{}
+	}
+	b, err := keys.Append(e.Header, b)
+	if err != nil {
+		return nil, err
+	}
+	if e.Header.Has(FlagTrailer) {
+		chunkSize := uint32(ChecksumChunkSize)
+		if e.Trailer != nil && e.Trailer.ChunkSize != 0 {
+			chunkSize = e.Trailer.ChunkSize
+		}
+		if e.Trailer, err = NewTrailer(bytes.NewReader(b[start:]), chunkSize); err != nil {
+			return nil, err
+		}
+		b = e.Trailer.Append(b)
+	}
+	return b, nil
+}
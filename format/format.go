@@ -0,0 +1,194 @@
+// Package format defines the binary layout of sealpack envelopes: the header, the key section following the payload
+// and the checksum trailer. It only depends on the standard library, so readers in other languages and tools not
+// decrypting anything can track the format from a single source. The specification in doc/envelope.md is generated
+// from this package, the test vectors in testdata/vectors.json are shared with implementations in other languages.
+//
+// The package neither decrypts nor decompresses the payload; see the sealpack package for that.
+package format
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+//go:generate go run gen.go
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// Magic starts envelopes of version 1. Its first byte is the ASCII sum of "ECS" = 333(octal) or DB(hex).
+	Magic = "\xDBIPC"
+	// MagicV2 starts envelopes of version 2 and newer, whose header records version and flags
+	MagicV2 = "\xDBIPV"
+)
+
+const (
+	// Version1 is the original format without checksum trailer
+	Version1 uint8 = 1
+	// Version2 adds a version and a flags byte to the header
+	Version2 uint8 = 2
+	// Version3 prefixes key entries with their length in bytes as uvarint instead of len/8
+	Version3 uint8 = 3
+	// Version4 adds an envelope signature covering header, payload and keys; unknown flags are rejected
+	Version4 uint8 = 4
+	// Version5 encrypts the payload in authenticated frames, which can be decrypted independently
+	Version5 uint8 = 5
+	// Version6 ends the header with a CRC32C, so damaged headers and magic sequences within other data are told apart
+	Version6 uint8 = 6
+	// VersionLatest is the newest version known
+	VersionLatest = Version6
+)
+
+const (
+	// FlagTrailer marks envelopes ending with a checksum trailer
+	FlagTrailer uint8 = 1 << 0
+	// FlagSignature marks envelopes with a signature record between payload and keys
+	FlagSignature uint8 = 1 << 1
+	// FlagFleetKey marks envelopes whose first key entry is wrapped for a fleet master secret
+	FlagFleetKey uint8 = 1 << 2
+	// FlagRecipientHints marks envelopes whose recipient key entries start with the fingerprint of their key
+	FlagRecipientHints uint8 = 1 << 3
+	// FlagChunked marks envelopes whose payload stores files as deduplicated chunks or images with shared layers
+	FlagChunked uint8 = 1 << 4
+	// FlagIndexed marks envelopes with an index record after the signature record, locating the index of the payload
+	FlagIndexed uint8 = 1 << 5
+	// FlagLabels marks envelopes with a labels record after the index record: channel and annotations of the package
+	FlagLabels uint8 = 1 << 6
+	// KnownFlags are all flags defined; from Version4 on, envelopes with other flags are rejected
+	KnownFlags = FlagTrailer | FlagSignature | FlagFleetKey | FlagRecipientHints | FlagChunked | FlagIndexed | FlagLabels
+)
+
+// flagVersions are the versions each flag is evaluated from; readers ignore flags set in older envelopes
+var flagVersions = []struct {
+	flag    uint8
+	name    string
+	version uint8
+}{
+	{FlagTrailer, "trailer", Version2},
+	{FlagSignature, "signature", Version4},
+	{FlagFleetKey, "fleet key", Version4},
+	{FlagRecipientHints, "recipient hints", Version4},
+	{FlagChunked, "chunked", Version4},
+	{FlagIndexed, "indexed", Version5},
+	{FlagLabels, "labels", Version5},
+}
+
+const (
+	// HeaderSizeV1 is the size of the header of Version1 envelopes: magic, config byte and payload length
+	HeaderSizeV1 = 4 + 1 + 8
+	// HeaderSize is the size of the header of Version2 to Version5: magic, version, config byte, flags and payload length
+	HeaderSize = 4 + 1 + 1 + 1 + 8
+	// HeaderSizeV6 is the size of the header of Version6 and newer, which adds a CRC32C of the header before it
+	HeaderSizeV6 = HeaderSize + 4
+	// MaxHeaderSize is the size of the largest header of all versions
+	MaxHeaderSize = HeaderSizeV6
+	// MaxKeyLength limits the size of a single key entry
+	MaxKeyLength = 1 << 16
+	// MaxFleetKeyLength limits the size of the fleet key entry, which holds a record for every device of the fleet
+	MaxFleetKeyLength = 16 << 20
+	// RecipientHintLength is the size of a recipient hint, the SHA-256 fingerprint of the recipient key
+	RecipientHintLength = 32
+	// MaxSignatureHashLength limits the size of the hash name in a signature record
+	MaxSignatureHashLength = 32
+	// MaxSignatureLength limits the size of the signature in a signature record
+	MaxSignatureLength = 1 << 14
+	// MaxIndexLength limits the size of the payload index located by an index record
+	MaxIndexLength = 64 << 20
+	// MaxChannelLength limits the size of the channel in a labels record
+	MaxChannelLength = 64
+	// MaxAnnotations limits the number of annotations in a labels record
+	MaxAnnotations = 64
+	// MaxAnnotationKeyLength and MaxAnnotationValueLength limit the size of a single annotation
+	MaxAnnotationKeyLength   = 128
+	MaxAnnotationValueLength = 1024
+	// ChecksumChunkSize is the size of the chunks the checksum trailer holds a CRC32C for
+	ChecksumChunkSize = 1 << 20
+	// TrailerFixedSize is the size of a trailer without chunk checksums: chunk size, chunk count, SHA-256 digest and trailer length
+	TrailerFixedSize = 4 + 4 + 32 + 4
+)
+
+// Compression algorithms of the payload, stored in the upper 3 bits of the config byte
+const (
+	CompressionGzip uint8 = iota
+	CompressionZlib
+	// CompressionZip stores the payload as uncompressed tar archive
+	CompressionZip
+	CompressionFlate
+	CompressionZstd
+)
+
+// compressionNames are the names of the compression algorithms as used on the command line
+var compressionNames = []string{"gzip", "zlib", "zip", "flate", "zstd"}
+
+// CompressionName provides the name of a compression algorithm, or an empty string for unknown ones
+func CompressionName(id uint8) string {
+	if int(id) >= len(compressionNames) {
+		return ""
+	}
+	return compressionNames[id]
+}
+
+// Hash is a hash algorithm the TOC of a package is created with, stored in the lower 5 bits of the config byte.
+// The IDs are those of crypto.Hash in Go.
+type Hash struct {
+	ID   uint8
+	Name string
+	Size int
+}
+
+// Hashes are all hash algorithms a TOC can be created with
+var Hashes = []Hash{
+	{4, "SHA-224", 28},
+	{5, "SHA-256", 32},
+	{6, "SHA-384", 48},
+	{7, "SHA-512", 64},
+	{10, "SHA3-224", 28},
+	{11, "SHA3-256", 32},
+	{12, "SHA3-384", 48},
+	{13, "SHA3-512", 64},
+	{14, "SHA-512/224", 28},
+	{15, "SHA-512/256", 32},
+	{16, "BLAKE2s-256", 32},
+	{17, "BLAKE2b-256", 32},
+	{18, "BLAKE2b-384", 48},
+	{19, "BLAKE2b-512", 64},
+}
+
+// LookupHash finds a hash algorithm by its ID
+func LookupHash(id uint8) (Hash, bool) {
+	for _, h := range Hashes {
+		if h.ID == id {
+			return h, true
+		}
+	}
+	return Hash{}, false
+}
+
+// SignatureHashes are the names of the hashes an envelope signature can be created with
+var SignatureHashes = []string{"SHA256", "SHA384", "SHA512", "Ed25519ph"}
+
+var (
+	// ErrMagic is returned for input not starting with one of the magic byte sequences
+	ErrMagic = errors.New("not a valid sealpack file")
+	// ErrTruncated is returned if the input ends within a structure
+	ErrTruncated = errors.New("file truncated")
+	// ErrHeaderChecksum is returned for headers of Version6 and newer not matching their CRC32C
+	ErrHeaderChecksum = errors.New("header corrupted: checksum does not match")
+)
+
+// unsupportedVersion reports a version newer than VersionLatest or a Version1 envelope using MagicV2
+func unsupportedVersion(version uint8) error {
+	return fmt.Errorf("unsupported envelope version %d, a newer sealpack is required", version)
+}
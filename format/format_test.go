@@ -0,0 +1,200 @@
+package format
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"github.com/stretchr/testify/assert"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	h := &Header{Version: Version4, Compression: CompressionZstd, Hash: 5, Flags: FlagTrailer | FlagSignature, PayloadLength: 1 << 40}
+	b := h.Append(nil)
+	assert.Len(t, b, HeaderSize)
+	assert.Equal(t, []byte{0xDB, 'I', 'P', 'V', 4, 0x85, 0x03, 0, 0, 0, 0, 0, 1, 0, 0}, b)
+	parsed, err := ParseHeader(b)
+	assert.NoError(t, err)
+	assert.Equal(t, h, parsed)
+	assert.True(t, parsed.Has(FlagSignature))
+	assert.False(t, parsed.Has(FlagIndexed))
+
+	_, err = ParseHeader(b[:HeaderSize-1])
+	assert.ErrorIs(t, err, ErrTruncated)
+	_, err = ParseHeader([]byte("PK\x03\x04"))
+	assert.ErrorIs(t, err, ErrMagic)
+	_, err = ParseHeader([]byte(MagicV2 + "\x01"))
+	assert.ErrorContains(t, err, "unsupported envelope version 1")
+
+	v1 := &Header{Compression: CompressionZlib, Hash: 6, PayloadLength: 3}
+	b = v1.Append(nil)
+	assert.Len(t, b, HeaderSizeV1)
+	parsed, err = ParseHeader(b)
+	assert.NoError(t, err)
+	assert.Equal(t, Version1, parsed.Version)
+	assert.Equal(t, uint64(3), parsed.PayloadLength)
+}
+
+func TestParseHeader_Checksum(t *testing.T) {
+	h := &Header{Version: Version6, Compression: CompressionGzip, Hash: 5, Flags: FlagTrailer, PayloadLength: 17}
+	b := h.Append(nil)
+	assert.Len(t, b, HeaderSizeV6)
+	parsed, err := ParseHeader(b)
+	assert.NoError(t, err)
+	assert.Equal(t, h, parsed)
+
+	_, err = ParseHeader(b[:HeaderSize])
+	assert.ErrorIs(t, err, ErrTruncated)
+	for i := len(Magic) + 1; i < HeaderSizeV6; i++ {
+		damaged := bytes.Clone(b)
+		damaged[i] ^= 0x01
+		_, err = ParseHeader(damaged)
+		assert.ErrorIs(t, err, ErrHeaderChecksum, "byte %d", i)
+	}
+}
+
+func TestHeader_Has(t *testing.T) {
+	// Flags of older versions are ignored
+	assert.False(t, (&Header{Version: Version3, Flags: FlagSignature}).Has(FlagSignature))
+	assert.False(t, (&Header{Version: Version4, Flags: FlagLabels}).Has(FlagLabels))
+	assert.True(t, (&Header{Version: Version2, Flags: FlagTrailer}).Has(FlagTrailer))
+	assert.False(t, (&Header{Version: Version1, Flags: FlagTrailer}).Has(FlagTrailer))
+}
+
+func TestParseKeys(t *testing.T) {
+	h := &Header{Version: Version5, Flags: FlagIndexed, PayloadLength: 100}
+	_, err := ParseKeys(h, AppendIndexRecord(nil, 90, 20))
+	assert.ErrorContains(t, err, "exceeds the payload")
+
+	h = &Header{Version: Version4, Flags: FlagRecipientHints}
+	_, err = ParseKeys(h, []byte{4, 1, 2, 3, 4})
+	assert.ErrorContains(t, err, "too short for a recipient hint")
+
+	h = &Header{Version: Version3}
+	_, err = ParseKeys(h, []byte{8, 1, 2})
+	assert.ErrorIs(t, err, ErrTruncated)
+	_, err = ParseKeys(h, []byte{0x81, 0x80, 0x04})
+	assert.ErrorContains(t, err, "invalid key length")
+
+	h = &Header{Version: Version2}
+	keys, err := ParseKeys(h, append([]byte{1}, pattern(0, 8)...))
+	assert.NoError(t, err)
+	assert.Equal(t, pattern(0, 8), keys.Entries[0].Key)
+	_, err = keys.Append(h, nil)
+	assert.NoError(t, err)
+	_, err = AppendKeyEntries(nil, Version2, 0, [][]byte{pattern(0, 7)})
+	assert.ErrorContains(t, err, "invalid key length 7 for envelope version 2")
+
+	// Only the fleet key entry may exceed MaxKeyLength
+	fleet := pattern(0, MaxKeyLength+1)
+	_, err = AppendKeyEntries(nil, Version4, 0, [][]byte{fleet})
+	assert.ErrorContains(t, err, "invalid key length")
+	b, err := AppendKeyEntries(nil, Version4, FlagFleetKey, [][]byte{fleet})
+	assert.NoError(t, err)
+	keys, err = ParseKeys(&Header{Version: Version4, Flags: FlagFleetKey}, b)
+	assert.NoError(t, err)
+	assert.True(t, keys.Entries[0].Fleet)
+	_, err = ParseKeys(&Header{Version: Version4}, b)
+	assert.ErrorContains(t, err, "invalid key length")
+}
+
+func TestTrailer_Verify(t *testing.T) {
+	data := pattern(0, 40)
+	trailer, err := NewTrailer(bytes.NewReader(data), 16)
+	assert.NoError(t, err)
+	assert.Len(t, trailer.Chunks, 3)
+	encoded := trailer.Append(nil)
+	assert.Len(t, encoded, trailer.Size())
+
+	parsed, length, err := ParseTrailer(append(pattern(0, 5), encoded...))
+	assert.NoError(t, err)
+	assert.Equal(t, trailer.Size(), length)
+	assert.Equal(t, trailer, parsed)
+	assert.NoError(t, parsed.Verify(bytes.NewReader(data)))
+
+	data[20] ^= 0xFF
+	assert.ErrorContains(t, parsed.Verify(bytes.NewReader(data)), "file corrupted at byte 16: checksum of bytes 16 to 31 does not match")
+	assert.ErrorContains(t, parsed.Verify(bytes.NewReader(data[:32])), "file corrupted at byte 16")
+
+	_, _, err = ParseTrailer(encoded[1:])
+	assert.ErrorContains(t, err, "invalid checksum trailer")
+	_, _, err = ParseTrailer(encoded[:10])
+	assert.ErrorIs(t, err, ErrTruncated)
+}
+
+// TestHashes checks the hash table against the IDs of crypto.Hash, which sealpack stores
+func TestHashes(t *testing.T) {
+	for _, h := range Hashes {
+		assert.Equal(t, crypto.Hash(h.ID).String(), h.Name)
+		assert.Equal(t, crypto.Hash(h.ID).Size(), h.Size)
+	}
+	_, ok := LookupHash(1)
+	assert.False(t, ok)
+	assert.Equal(t, "zstd", CompressionName(CompressionZstd))
+	assert.Equal(t, "", CompressionName(7))
+}
+
+// TestMarkdown checks that doc/envelope.md is up to date
+func TestMarkdown(t *testing.T) {
+	doc, err := os.ReadFile("../doc/envelope.md")
+	assert.NoError(t, err)
+	assert.Equal(t, Markdown(), string(doc), "run go generate ./format")
+}
+
+// TestStandardLibraryOnly keeps the package free of dependencies, so it can be vendored by other readers
+func TestStandardLibraryOnly(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	assert.NoError(t, err)
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") || file == "gen.go" {
+			continue
+		}
+		parsed, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+		assert.NoError(t, err)
+		for _, imp := range parsed.Imports {
+			path := strings.Trim(imp.Path.Value, `"`)
+			assert.NotContains(t, strings.Split(path, "/")[0], ".", "%s imports %s", file, path)
+		}
+	}
+}
+
+// TestReadGolden reads the golden packages of sealpack with the reference reader
+func TestReadGolden(t *testing.T) {
+	files, err := filepath.Glob("../internal/testdata/golden/*.ipc")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		e, err := Read(bytes.NewReader(data), int64(len(data)))
+		if err == nil {
+			err = e.Verify(bytes.NewReader(data))
+		}
+		if strings.HasPrefix(filepath.Base(file), "corrupt-") {
+			assert.Error(t, err, file)
+			continue
+		}
+		assert.NoError(t, err, file)
+		if filepath.Base(file) != "public.ipc" {
+			assert.NotEmpty(t, e.Keys.Entries, file)
+		}
+	}
+}
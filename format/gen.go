@@ -0,0 +1,16 @@
+//go:build ignore
+
+// gen writes the specification of the envelope format to doc/envelope.md
+package main
+
+import (
+	"github.com/innomotics/sealpack/format"
+	"log"
+	"os"
+)
+
+func main() {
+	if err := os.WriteFile("../doc/envelope.md", []byte(format.Markdown()), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,125 @@
+package format
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Header is the fixed size start of an envelope, followed by the payload
+type Header struct {
+	// Version of the envelope format, 0 is treated as Version1
+	Version uint8
+	// Compression is the algorithm the payload is compressed with, e.g. CompressionGzip
+	Compression uint8
+	// Hash is the ID of the hash algorithm the TOC is created with, see Hashes
+	Hash uint8
+	// Flags are only stored from Version2 on
+	Flags uint8
+	// PayloadLength is the size of the encrypted payload in bytes
+	PayloadLength uint64
+}
+
+// HeaderSizeOf provides the size of the header of an envelope with the version, which is the offset of the payload
+func HeaderSizeOf(version uint8) int {
+	switch {
+	case version >= Version6:
+		return HeaderSizeV6
+	case version > Version1:
+		return HeaderSize
+	}
+	return HeaderSizeV1
+}
+
+// ParseHeader reads the header from the start of b, which must hold at least HeaderSizeOf the version bytes.
+// Unknown versions, from Version4 on unknown flags and from Version6 on headers not matching their checksum are rejected.
+func ParseHeader(b []byte) (*Header, error) {
+	if len(b) < len(Magic) {
+		return nil, ErrTruncated
+	}
+	h := &Header{}
+	switch string(b[:len(Magic)]) {
+	case Magic:
+		h.Version = Version1
+	case MagicV2:
+		if len(b) <= len(MagicV2) {
+			return nil, ErrTruncated
+		}
+		h.Version = b[len(MagicV2)]
+		if h.Version > VersionLatest || h.Version < Version2 {
+			return nil, unsupportedVersion(h.Version)
+		}
+	default:
+		return nil, ErrMagic
+	}
+	if len(b) < h.Size() {
+		return nil, ErrTruncated
+	}
+	if h.Version >= Version6 && crc32.Checksum(b[:HeaderSize], CRCTable) != binary.LittleEndian.Uint32(b[HeaderSize:]) {
+		return nil, ErrHeaderChecksum
+	}
+	b = b[len(Magic):]
+	if h.Version > Version1 {
+		b = b[1:]
+	}
+	// The config byte holds the compression in bits 7-5 and the hash in bits 4-0
+	h.Compression, h.Hash = b[0]>>5, b[0]&0b00011111
+	b = b[1:]
+	if h.Version > Version1 {
+		h.Flags = b[0]
+		if h.Version >= Version4 && h.Flags&^KnownFlags != 0 {
+			return nil, fmt.Errorf("unsupported envelope flags 0x%02x, a newer sealpack is required", h.Flags)
+		}
+		b = b[1:]
+	}
+	h.PayloadLength = binary.LittleEndian.Uint64(b)
+	return h, nil
+}
+
+// Size is the size of the encoded header
+func (h *Header) Size() int {
+	return HeaderSizeOf(h.Version)
+}
+
+// Has determines whether a flag is set and evaluated for the version of the envelope
+func (h *Header) Has(flag uint8) bool {
+	for _, f := range flagVersions {
+		if f.flag == flag {
+			return h.Version >= f.version && h.Flags&flag != 0
+		}
+	}
+	return false
+}
+
+// Append appends the encoded header to b. Compression and hash are truncated to the bits available in the config byte.
+func (h *Header) Append(b []byte) []byte {
+	start := len(b)
+	if h.Version > Version1 {
+		b = append(append(b, MagicV2...), h.Version)
+	} else {
+		b = append(b, Magic...)
+	}
+	b = append(b, h.Compression<<5|h.Hash&0b00011111)
+	if h.Version > Version1 {
+		b = append(b, h.Flags)
+	}
+	b = binary.LittleEndian.AppendUint64(b, h.PayloadLength)
+	if h.Version >= Version6 {
+		b = binary.LittleEndian.AppendUint32(b, crc32.Checksum(b[start:], CRCTable))
+	}
+	return b
+}
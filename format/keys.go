@@ -0,0 +1,269 @@
+package format
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Keys is the key section between payload and trailer: the records flagged in the header followed by the key entries
+type Keys struct {
+	// SignatureHash and Signature form the signature record, if FlagSignature is set
+	SignatureHash string
+	Signature     []byte
+	// IndexOffset and IndexLength form the index record, if FlagIndexed is set
+	IndexOffset uint64
+	IndexLength uint64
+	// Channel and Annotations form the labels record, if FlagLabels is set
+	Channel     string
+	Annotations map[string]string
+	// Entries are the wrapped payload keys
+	Entries []KeyEntry
+}
+
+// KeyEntry is the payload key wrapped for a single recipient or a fleet
+type KeyEntry struct {
+	// Fleet marks the entry wrapped for a fleet master secret; it is always the first entry
+	Fleet bool
+	// Hint is the SHA-256 fingerprint of the recipient key, if FlagRecipientHints is set
+	Hint []byte
+	// Key is the wrapped payload key
+	Key []byte
+}
+
+// ParseKeys reads the key section of an envelope with the header h from b, which must end where the trailer starts
+func ParseKeys(h *Header, b []byte) (*Keys, error) {
+	rd := &byteReader{b: b}
+	k := &Keys{}
+	var err error
+	if h.Has(FlagSignature) {
+		var name []byte
+		if name, err = rd.field(MaxSignatureHashLength); err == nil {
+			k.Signature, err = rd.field(MaxSignatureLength)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid envelope signature: %w", err)
+		}
+		k.SignatureHash = string(name)
+	}
+	if h.Has(FlagIndexed) {
+		if k.IndexOffset, k.IndexLength, err = rd.indexRecord(); err != nil {
+			return nil, fmt.Errorf("invalid index record: %w", err)
+		}
+		if k.IndexLength > MaxIndexLength || k.IndexOffset+k.IndexLength > h.PayloadLength {
+			return nil, fmt.Errorf("invalid index record: index of %d bytes at %d exceeds the payload", k.IndexLength, k.IndexOffset)
+		}
+	}
+	if h.Has(FlagLabels) {
+		if err = k.readLabels(rd); err != nil {
+			return nil, fmt.Errorf("invalid labels record: %w", err)
+		}
+	}
+	for rd.len() > 0 {
+		var length uint64
+		if h.Version < Version3 {
+			prefix, _ := rd.ReadByte()
+			length = uint64(prefix) * 8
+		} else if length, err = binary.ReadUvarint(rd); err != nil {
+			return nil, err
+		} else if length > maxEntryLength(h, len(k.Entries)) {
+			return nil, fmt.Errorf("invalid key length %d", length)
+		}
+		key, err := rd.next(length)
+		if err != nil {
+			return nil, err
+		}
+		entry := KeyEntry{Fleet: len(k.Entries) == 0 && h.Has(FlagFleetKey), Key: key}
+		if h.Has(FlagRecipientHints) && !entry.Fleet {
+			if len(key) <= RecipientHintLength {
+				return nil, fmt.Errorf("key entry %d is too short for a recipient hint", len(k.Entries)+1)
+			}
+			entry.Hint, entry.Key = key[:RecipientHintLength], key[RecipientHintLength:]
+		}
+		k.Entries = append(k.Entries, entry)
+	}
+	return k, nil
+}
+
+// readLabels reads channel and annotations of a labels record
+func (k *Keys) readLabels(rd *byteReader) error {
+	channel, err := rd.field(MaxChannelLength)
+	if err != nil {
+		return err
+	}
+	count, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return err
+	}
+	if count > MaxAnnotations {
+		return fmt.Errorf("%d annotations exceed the limit of %d", count, MaxAnnotations)
+	}
+	k.Channel = string(channel)
+	for range count {
+		key, err := rd.field(MaxAnnotationKeyLength)
+		if err != nil {
+			return err
+		}
+		value, err := rd.field(MaxAnnotationValueLength)
+		if err != nil {
+			return err
+		}
+		if k.Annotations == nil {
+			k.Annotations = make(map[string]string, count)
+		}
+		k.Annotations[string(key)] = string(value)
+	}
+	return nil
+}
+
+// Append appends the encoded key section of an envelope with the header h to b.
+// Records are only written if flagged in the header, key entries must fit the version.
+func (k *Keys) Append(h *Header, b []byte) ([]byte, error) {
+	if h.Has(FlagSignature) {
+		b = AppendSignatureRecord(b, k.SignatureHash, k.Signature)
+	}
+	if h.Has(FlagIndexed) {
+		b = AppendIndexRecord(b, k.IndexOffset, k.IndexLength)
+	}
+	if h.Has(FlagLabels) {
+		b = AppendLabelsRecord(b, k.Channel, k.Annotations)
+	}
+	return AppendKeyEntries(b, h.Version, h.Flags, k.entries(h))
+}
+
+// entries provides the key entries as stored, with recipient hints prepended if flagged
+func (k *Keys) entries(h *Header) [][]byte {
+	entries := make([][]byte, len(k.Entries))
+	for i, entry := range k.Entries {
+		entries[i] = entry.Key
+		if h.Has(FlagRecipientHints) && !entry.Fleet {
+			entries[i] = append(append([]byte{}, entry.Hint...), entry.Key...)
+		}
+	}
+	return entries
+}
+
+// AppendSignatureRecord appends the name of the signature hash and the signature, each prefixed with its length as uvarint
+func AppendSignatureRecord(b []byte, signatureHash string, sig []byte) []byte {
+	b = appendField(b, []byte(signatureHash))
+	return appendField(b, sig)
+}
+
+// AppendIndexRecord appends offset and length of the payload index as uvarints
+func AppendIndexRecord(b []byte, offset, length uint64) []byte {
+	return binary.AppendUvarint(binary.AppendUvarint(b, offset), length)
+}
+
+// AppendLabelsRecord appends the channel, possibly empty, and the annotations sorted by key,
+// all strings prefixed with their length as uvarint
+func AppendLabelsRecord(b []byte, channel string, annotations map[string]string) []byte {
+	b = appendField(b, []byte(channel))
+	b = binary.AppendUvarint(b, uint64(len(annotations)))
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		b = appendField(appendField(b, []byte(key)), []byte(annotations[key]))
+	}
+	return b
+}
+
+// AppendKeyEntries appends key entries prefixed with their sizes.
+// Before Version3, sizes are stored as len/8 in a single byte, so they must be a multiple of 8 and at most 2040 bytes.
+func AppendKeyEntries(b []byte, version, flags uint8, entries [][]byte) ([]byte, error) {
+	h := &Header{Version: version, Flags: flags}
+	for i, key := range entries {
+		if version >= Version3 {
+			if uint64(len(key)) > maxEntryLength(h, i) {
+				return nil, fmt.Errorf("invalid key length %d", len(key))
+			}
+			b = binary.AppendUvarint(b, uint64(len(key)))
+		} else if len(key)%8 != 0 || len(key)/8 > 0xFF {
+			return nil, fmt.Errorf("invalid key length %d for envelope version %d", len(key), max(version, Version1))
+		} else {
+			b = append(b, uint8(len(key)/8))
+		}
+		b = append(b, key...)
+	}
+	return b, nil
+}
+
+// maxEntryLength is the size limit of the i-th key entry of an envelope with the header h
+func maxEntryLength(h *Header, i int) uint64 {
+	if i == 0 && h.Has(FlagFleetKey) {
+		return MaxFleetKeyLength
+	}
+	return MaxKeyLength
+}
+
+// appendField appends a field prefixed with its length as uvarint
+func appendField(b, field []byte) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(field))), field...)
+}
+
+// byteReader reads the fields of the key section from a byte slice
+type byteReader struct {
+	b   []byte
+	pos int
+}
+
+// ReadByte implements io.ByteReader
+func (r *byteReader) ReadByte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, ErrTruncated
+	}
+	r.pos++
+	return r.b[r.pos-1], nil
+}
+
+// len provides the number of bytes left
+func (r *byteReader) len() int {
+	return len(r.b) - r.pos
+}
+
+// next provides the next n bytes
+func (r *byteReader) next(n uint64) ([]byte, error) {
+	if n > uint64(r.len()) {
+		return nil, ErrTruncated
+	}
+	b := r.b[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// field reads a field prefixed with its length as uvarint
+func (r *byteReader) field(maxLength uint64) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxLength {
+		return nil, fmt.Errorf("invalid field length %d", length)
+	}
+	return r.next(length)
+}
+
+// indexRecord reads offset and length of an index record
+func (r *byteReader) indexRecord() (offset, length uint64, err error) {
+	if offset, err = binary.ReadUvarint(r); err != nil {
+		return 0, 0, err
+	}
+	length, err = binary.ReadUvarint(r)
+	return offset, length, err
+}
@@ -0,0 +1,186 @@
+package format
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+// versionChanges describe what each envelope version introduced
+var versionChanges = []struct {
+	version uint8
+	changes string
+}{
+	{Version1, "Original format. Key entries must be a multiple of 8 bytes and at most 2040 bytes long."},
+	{Version2, "Adds a version and a flags byte to the header and the checksum trailer."},
+	{Version3, "Key entries are prefixed with their length in bytes as uvarint."},
+	{Version4, "Adds the envelope signature, fleet keys, recipient hints and chunked payloads. Unknown flags are rejected."},
+	{Version5, "Encrypts the payload in authenticated frames. Adds the index and labels records."},
+	{Version6, "Ends the header with a CRC32C of the header."},
+}
+
+// Markdown generates the specification of the envelope format from the definitions of this package.
+// It is written to doc/envelope.md by go generate.
+func Markdown() string {
+	sb := &strings.Builder{}
+	p := func(format string, args ...any) { _, _ = fmt.Fprintf(sb, format+"\n", args...) }
+	p("<!-- Code generated by go generate in ./format; DO NOT EDIT. -->")
+	p("")
+	p("# Sealpack envelope format")
+	p("")
+	p("A sealpack file is an envelope around the encrypted payload: a fixed size header, the payload, the key section and")
+	p("from version %d on, a checksum trailer. All integers are little endian unless stated otherwise;", Version2)
+	p("*uvarint* denotes the unsigned LEB128 encoding of Go's `encoding/binary`.")
+	p("Test vectors for implementations in other languages are in [format/testdata/vectors.json](../format/testdata/vectors.json).")
+	p("")
+	p("| Section | Size |")
+	p("|---------|------|")
+	p("| Header | %d bytes, %d bytes in version %d, %d bytes from version %d on |", HeaderSize, HeaderSizeV1, Version1, HeaderSizeV6, Version6)
+	p("| Payload | payload length of the header |")
+	p("| Keys | up to the trailer or the end of the file |")
+	p("| Trailer | last field holds its length |")
+	p("")
+	p("## Versions")
+	p("")
+	p("| Version | Changes |")
+	p("|---------|---------|")
+	for _, v := range versionChanges {
+		p("| %d | %s |", v.version, v.changes)
+	}
+	p("")
+	p("Readers must reject versions newer than they know. To find an envelope behind other data, e.g. left by a transport,")
+	p("readers may search for the magic bytes; from version %d on, the header checksum tells envelopes from magic bytes within other data.", Version6)
+	p("")
+	p("## Header")
+	p("")
+	p("| Offset | Size | Field |")
+	p("|--------|------|-------|")
+	p("| 0 | 4 | Magic: `%s` for version %d, `%s` for newer versions |", hexString(Magic), Version1, hexString(MagicV2))
+	p("| 4 | 1 | Version, only from version %d on |", Version2)
+	p("| 5 | 1 | Config: compression in bits 7-5, hash of the TOC in bits 4-0 |")
+	p("| 6 | 1 | Flags, only from version %d on |", Version2)
+	p("| 7 | 8 | Payload length in bytes, uint64 |")
+	p("| 15 | 4 | CRC32C (Castagnoli) of bytes 0 to 14, uint32, only from version %d on |", Version6)
+	p("")
+	p("Version %d headers have no version and flags byte, so the config byte is at offset 4 and the payload length at offset 5.", Version1)
+	p("")
+	p("### Flags")
+	p("")
+	p("Flags are only evaluated from the version listed on; from version %d on, envelopes with other flags than these are rejected.", Version4)
+	p("")
+	p("| Bit | Value | Name | Since version |")
+	p("|-----|-------|------|---------------|")
+	for _, f := range flagVersions {
+		bit := 0
+		for f.flag>>bit != 1 {
+			bit++
+		}
+		p("| %d | 0x%02x | %s | %d |", bit, f.flag, f.name, f.version)
+	}
+	p("")
+	p("### Compression")
+	p("")
+	p("The payload is a tar archive, compressed with the algorithm of the config byte before it is encrypted.")
+	p("")
+	p("| ID | Name |")
+	p("|----|------|")
+	for id := range compressionNames {
+		name := compressionNames[id]
+		if uint8(id) == CompressionZip {
+			name += " (uncompressed tar)"
+		}
+		p("| %d | %s |", id, name)
+	}
+	p("")
+	p("### Hashes")
+	p("")
+	p("The TOC of the payload lists the digests of all entries created with this hash. The IDs are those of `crypto.Hash` in Go.")
+	p("")
+	p("| ID | Name | Digest size |")
+	p("|----|------|-------------|")
+	for _, h := range Hashes {
+		p("| %d | %s | %d |", h.ID, h.Name, h.Size)
+	}
+	p("")
+	p("## Payload")
+	p("")
+	p("The payload is encrypted with XChaCha20-Poly1305 using the payload key, which is wrapped for each recipient in the key section.")
+	p("Public packages have no key entries and an unencrypted payload.")
+	p("From version %d on, it starts with a random nonce prefix of 15 bytes followed by frames of 4 MiB of plaintext,", Version5)
+	p("each sealed on its own with the nonce made of the prefix, the frame index as big endian uint64 and a last byte of 1 for the last frame, 0 otherwise.")
+	p("")
+	p("## Key section")
+	p("")
+	p("The key section holds the following records, each only if its flag is set and evaluated, followed by the key entries up to the end of the section.")
+	p("Strings and byte fields are prefixed with their length as uvarint.")
+	p("")
+	p("### Signature record")
+	p("")
+	p("| Field | Encoding | Limit |")
+	p("|-------|----------|-------|")
+	p("| Signature hash | string, one of %s | %d bytes |", strings.Join(SignatureHashes, ", "), MaxSignatureHashLength)
+	p("| Signature | bytes | %d bytes |", MaxSignatureLength)
+	p("")
+	p("The signature covers the header, the SHA-256 digest of the payload, the index and labels records and all key entries with their length prefixes.")
+	p("")
+	p("### Index record")
+	p("")
+	p("| Field | Encoding | Limit |")
+	p("|-------|----------|-------|")
+	p("| Offset | uvarint | within the decrypted payload |")
+	p("| Length | uvarint | %d bytes |", MaxIndexLength)
+	p("")
+	p("### Labels record")
+	p("")
+	p("| Field | Encoding | Limit |")
+	p("|-------|----------|-------|")
+	p("| Channel | string, may be empty | %d bytes |", MaxChannelLength)
+	p("| Count | uvarint | %d annotations |", MaxAnnotations)
+	p("| Annotations | key and value string, sorted by key | %d and %d bytes |", MaxAnnotationKeyLength, MaxAnnotationValueLength)
+	p("")
+	p("### Key entries")
+	p("")
+	p("Each entry is prefixed with its length in bytes as uvarint, at most %d bytes.", MaxKeyLength)
+	p("Before version %d, the prefix is a single byte holding the length divided by 8.", Version3)
+	p("If the fleet key flag is set, the first entry holds the payload key wrapped for every device of a fleet, at most %d bytes.", MaxFleetKeyLength)
+	p("If the recipient hints flag is set, every other entry starts with the %d byte SHA-256 fingerprint of the recipient key.", RecipientHintLength)
+	p("")
+	p("## Checksum trailer")
+	p("")
+	p("The trailer covers everything in front of it. It is found from the end of the file by its last field.")
+	p("")
+	p("| Size | Field |")
+	p("|------|-------|")
+	p("| 4 | Chunk size, uint32, %d when written by sealpack |", ChecksumChunkSize)
+	p("| 4 | Chunk count *n*, uint32 |")
+	p("| 4 × *n* | CRC32C (Castagnoli) of each chunk, uint32 |")
+	p("| 32 | SHA-256 digest |")
+	p("| 4 | Trailer length %d + 4 × *n*, uint32 |", TrailerFixedSize)
+	return sb.String()
+}
+
+// hexString formats bytes as escaped hex string, e.g. \xDBIPC
+func hexString(s string) string {
+	sb := strings.Builder{}
+	for _, c := range []byte(s) {
+		if c < 0x20 || c > 0x7E {
+			sb.WriteString(fmt.Sprintf("\\x%02X", c))
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,125 @@
+package format
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// CRCTable is the CRC32C (Castagnoli) table the chunk checksums of the trailer are calculated with
+var CRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Trailer is the checksum trailer ending envelopes with FlagTrailer, covering everything before it.
+// The SHA-256 digest detects any modification, the CRC32C of each chunk tells where a file is corrupted.
+type Trailer struct {
+	ChunkSize uint32
+	Chunks    []uint32
+	Digest    []byte
+}
+
+// NewTrailer calculates the trailer covering everything read from r with chunks of chunkSize
+func NewTrailer(r io.Reader, chunkSize uint32) (*Trailer, error) {
+	t, _, err := newTrailer(r, chunkSize)
+	return t, err
+}
+
+// newTrailer calculates the trailer of everything read from r and provides the number of bytes covered
+func newTrailer(r io.Reader, chunkSize uint32) (*Trailer, int64, error) {
+	digest := sha256.New()
+	t := &Trailer{ChunkSize: chunkSize}
+	var covered int64
+	for {
+		crc := crc32.New(CRCTable)
+		n, err := io.CopyN(io.MultiWriter(digest, crc), r, int64(chunkSize))
+		if n > 0 {
+			t.Chunks = append(t.Chunks, crc.Sum32())
+			covered += n
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, covered, err
+		}
+	}
+	t.Digest = digest.Sum(nil)
+	return t, covered, nil
+}
+
+// Size is the size of the encoded trailer
+func (t *Trailer) Size() int {
+	return TrailerFixedSize + 4*len(t.Chunks)
+}
+
+// Append appends the encoded trailer to b, ending with its own length so it can be found from the end of a file
+func (t *Trailer) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(b, t.ChunkSize)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(t.Chunks)))
+	for _, chunk := range t.Chunks {
+		b = binary.LittleEndian.AppendUint32(b, chunk)
+	}
+	b = append(b, t.Digest...)
+	return binary.LittleEndian.AppendUint32(b, uint32(t.Size()))
+}
+
+// ParseTrailer reads the trailer from the end of an envelope. The last 4 bytes of end must hold the trailer length;
+// end must hold the complete trailer, but may start anywhere before it. The size of the trailer is provided as well.
+func ParseTrailer(end []byte) (*Trailer, int, error) {
+	if len(end) < TrailerFixedSize {
+		return nil, 0, fmt.Errorf("%w: checksum trailer is missing", ErrTruncated)
+	}
+	length := binary.LittleEndian.Uint32(end[len(end)-4:])
+	if length < TrailerFixedSize || uint64(length) > uint64(len(end)) || (length-TrailerFixedSize)%4 != 0 {
+		return nil, 0, fmt.Errorf("file corrupted or truncated at the end: invalid checksum trailer")
+	}
+	raw := end[len(end)-int(length):]
+	t := &Trailer{ChunkSize: binary.LittleEndian.Uint32(raw)}
+	count := binary.LittleEndian.Uint32(raw[4:])
+	if count != (length-TrailerFixedSize)/4 || t.ChunkSize == 0 {
+		return nil, 0, fmt.Errorf("file corrupted or truncated at the end: invalid checksum trailer")
+	}
+	for i := range count {
+		t.Chunks = append(t.Chunks, binary.LittleEndian.Uint32(raw[8+4*i:]))
+	}
+	t.Digest = raw[8+4*count : 8+4*count+sha256.Size]
+	return t, int(length), nil
+}
+
+// Verify checks everything read from r, which must be the data covered by the trailer,
+// and reports the byte offset a corruption starts at
+func (t *Trailer) Verify(r io.Reader) error {
+	actual, covered, err := newTrailer(r, t.ChunkSize)
+	if err != nil {
+		return err
+	}
+	for i := range max(len(t.Chunks), len(actual.Chunks)) {
+		if i >= len(t.Chunks) || i >= len(actual.Chunks) || actual.Chunks[i] != t.Chunks[i] {
+			start, end := int64(i)*int64(t.ChunkSize), int64(i+1)*int64(t.ChunkSize)
+			if i < len(actual.Chunks) {
+				end = min(end, covered)
+			}
+			return fmt.Errorf("file corrupted at byte %d: checksum of bytes %d to %d does not match", start, start, end-1)
+		}
+	}
+	if !bytes.Equal(actual.Digest, t.Digest) {
+		return fmt.Errorf("file corrupted: SHA-256 checksum does not match")
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package format
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// updateVectors regenerates the test vectors instead of verifying them: go test ./format -run TestVectors -update
+var updateVectors = flag.Bool("update", false, "regenerate the test vectors in testdata/vectors.json")
+
+const vectorsPath = "testdata/vectors.json"
+
+// vector is a test vector as stored in vectors.json; all binary values are hex encoded.
+// Valid envelopes list the expected structure, invalid ones the error reading or verifying them must contain.
+type vector struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Hex         string          `json:"hex"`
+	Expected    *vectorEnvelope `json:"expected,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// vectorEnvelope is the structure expected to be read from a vector
+type vectorEnvelope struct {
+	Version       uint8             `json:"version"`
+	Compression   uint8             `json:"compression"`
+	Hash          uint8             `json:"hash"`
+	Flags         uint8             `json:"flags"`
+	PayloadOffset int64             `json:"payload_offset"`
+	PayloadLength uint64            `json:"payload_length"`
+	KeysOffset    int64             `json:"keys_offset"`
+	TrailerOffset int64             `json:"trailer_offset"`
+	SignatureHash string            `json:"signature_hash,omitempty"`
+	Signature     string            `json:"signature,omitempty"`
+	IndexOffset   uint64            `json:"index_offset,omitempty"`
+	IndexLength   uint64            `json:"index_length,omitempty"`
+	Channel       string            `json:"channel,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	Entries       []vectorEntry     `json:"entries"`
+	ChunkSize     uint32            `json:"chunk_size,omitempty"`
+	Digest        string            `json:"digest,omitempty"`
+}
+
+// vectorEntry is an expected key entry
+type vectorEntry struct {
+	Fleet bool   `json:"fleet,omitempty"`
+	Hint  string `json:"hint,omitempty"`
+	Key   string `json:"key"`
+}
+
+// pattern provides n bytes counting up from start, so vectors are deterministic
+func pattern(start byte, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = start + byte(i)
+	}
+	return b
+}
+
+// vectorSource describes how a vector is created
+type vectorSource struct {
+	name, description string
+	envelope          *Envelope
+	payload           []byte
+	// damage modifies the encoded envelope of an invalid vector
+	damage func([]byte) []byte
+	err    string
+}
+
+func vectorSources() []vectorSource {
+	recipient := func(hint byte, key []byte) KeyEntry {
+		return KeyEntry{Hint: pattern(hint, RecipientHintLength), Key: key}
+	}
+	v2 := func() *Envelope {
+		return &Envelope{Header: &Header{Version: Version2, Compression: CompressionZlib, Hash: 7, Flags: FlagTrailer},
+			Keys: &Keys{Entries: []KeyEntry{{Key: pattern(0x40, 16)}}}}
+	}
+	return []vectorSource{
+		{name: "v1-two-keys", description: "Version 1 with gzip and SHA-256, two keys with len/8 prefix, no trailer",
+			envelope: &Envelope{Header: &Header{Version: Version1, Compression: CompressionGzip, Hash: 5},
+				Keys: &Keys{Entries: []KeyEntry{{Key: pattern(0x10, 8)}, {Key: pattern(0x20, 16)}}}},
+			payload: []byte("encrypted payload")},
+		{name: "v2-trailer", description: "Version 2 with zlib and SHA-512, checksum trailer",
+			envelope: v2(), payload: []byte("encrypted payload")},
+		{name: "v3-long-key", description: "Version 3 with zstd and SHA3-256, a key of 300 bytes with two byte uvarint prefix",
+			envelope: &Envelope{Header: &Header{Version: Version3, Compression: CompressionZstd, Hash: 11, Flags: FlagTrailer},
+				Keys: &Keys{Entries: []KeyEntry{{Key: pattern(0x00, 300)}}}},
+			payload: []byte("encrypted payload")},
+		{name: "v4-signed-fleet-hints", description: "Version 4 with flate and BLAKE2b-512, signature record, fleet key and two recipients with hints",
+			envelope: &Envelope{Header: &Header{Version: Version4, Compression: CompressionFlate, Hash: 19,
+				Flags: FlagTrailer | FlagSignature | FlagFleetKey | FlagRecipientHints | FlagChunked},
+				Keys: &Keys{SignatureHash: "SHA384", Signature: pattern(0x80, 8),
+					Entries: []KeyEntry{{Fleet: true, Key: pattern(0x30, 24)}, recipient(0xA0, pattern(0x50, 8)), recipient(0xC0, pattern(0x60, 8))}}},
+			payload: []byte("encrypted payload")},
+		{name: "v5-indexed-labels", description: "Version 5 with uncompressed tar and SHA-256, signature, index and labels records, trailer chunks of 16 bytes",
+			envelope: &Envelope{Header: &Header{Version: Version5, Compression: CompressionZip, Hash: 5,
+				Flags: FlagTrailer | FlagSignature | FlagIndexed | FlagLabels},
+				Keys: &Keys{SignatureHash: "Ed25519ph", Signature: pattern(0x90, 8), IndexOffset: 16, IndexLength: 200,
+					Channel: "stable", Annotations: map[string]string{"ticket": "OPS-1", "build.id": "42"},
+					Entries: []KeyEntry{{Key: pattern(0x70, 8)}}},
+				Trailer: &Trailer{ChunkSize: 16}},
+			payload: pattern(0x00, 300)},
+		{name: "v5-public", description: "Version 5 public package without keys",
+			envelope: &Envelope{Header: &Header{Version: Version5, Compression: CompressionGzip, Hash: 5, Flags: FlagTrailer}},
+			payload:  []byte("plain payload")},
+		{name: "v6-header-checksum", description: "Version 6 with gzip and SHA-256, header ending with its CRC32C",
+			envelope: &Envelope{Header: &Header{Version: Version6, Compression: CompressionGzip, Hash: 5, Flags: FlagTrailer | FlagSignature},
+				Keys: &Keys{SignatureHash: "SHA256", Signature: pattern(0xB0, 8), Entries: []KeyEntry{{Key: pattern(0x70, 8)}}}},
+			payload: []byte("encrypted payload")},
+		{name: "invalid-magic", description: "Unknown magic bytes", envelope: v2(), payload: []byte("encrypted payload"),
+			damage: func(b []byte) []byte { b[3] = 'X'; return b }, err: "not a valid sealpack file"},
+		{name: "invalid-version", description: "Version newer than known", envelope: v2(), payload: []byte("encrypted payload"),
+			damage: func(b []byte) []byte { b[4] = 0xFF; return b }, err: "unsupported envelope version 255"},
+		{name: "invalid-flags", description: "Unknown flag in a version 4 envelope",
+			envelope: &Envelope{Header: &Header{Version: Version4, Hash: 5, Flags: FlagTrailer}}, payload: []byte("encrypted payload"),
+			damage: func(b []byte) []byte { b[6] |= 0x80; return b }, err: "unsupported envelope flags 0x81"},
+		{name: "invalid-header-checksum", description: "Payload length of a version 6 header changed, detected by the header checksum",
+			envelope: &Envelope{Header: &Header{Version: Version6, Hash: 5, Flags: FlagTrailer}}, payload: []byte("encrypted payload"),
+			damage: func(b []byte) []byte { b[7] ^= 0x01; return b }, err: "header corrupted: checksum does not match"},
+		{name: "invalid-truncated", description: "Last byte of the trailer missing", envelope: v2(), payload: []byte("encrypted payload"),
+			damage: func(b []byte) []byte { return b[:len(b)-1] }, err: "invalid checksum trailer"},
+		{name: "invalid-payload", description: "Payload byte changed, detected by the checksum trailer", envelope: v2(), payload: []byte("encrypted payload"),
+			damage: func(b []byte) []byte { b[HeaderSize] ^= 0xFF; return b }, err: "file corrupted at byte 0"},
+	}
+}
+
+// newVector encodes the envelope of a source and describes what must be read from it
+func newVector(t *testing.T, src vectorSource) vector {
+	data, err := src.envelope.Append(nil, src.payload)
+	assert.NoError(t, err)
+	v := vector{Name: src.name, Description: src.description, Hex: hex.EncodeToString(data), Error: src.err}
+	if src.damage != nil {
+		v.Hex = hex.EncodeToString(src.damage(data))
+		return v
+	}
+	e := src.envelope
+	h, k := e.Header, e.Keys
+	if k == nil {
+		k = &Keys{}
+	}
+	v.Expected = &vectorEnvelope{Version: max(h.Version, Version1), Compression: h.Compression, Hash: h.Hash, Flags: h.Flags,
+		PayloadOffset: int64(h.Size()), PayloadLength: h.PayloadLength, KeysOffset: int64(h.Size()) + int64(h.PayloadLength),
+		TrailerOffset: int64(len(data)), SignatureHash: k.SignatureHash, Signature: hex.EncodeToString(k.Signature),
+		IndexOffset: k.IndexOffset, IndexLength: k.IndexLength, Channel: k.Channel, Annotations: k.Annotations, Entries: []vectorEntry{}}
+	for _, entry := range k.Entries {
+		v.Expected.Entries = append(v.Expected.Entries, vectorEntry{Fleet: entry.Fleet, Hint: hex.EncodeToString(entry.Hint), Key: hex.EncodeToString(entry.Key)})
+	}
+	if e.Trailer != nil {
+		v.Expected.TrailerOffset -= int64(e.Trailer.Size())
+		v.Expected.ChunkSize, v.Expected.Digest = e.Trailer.ChunkSize, hex.EncodeToString(e.Trailer.Digest)
+	}
+	return v
+}
+
+// readVector reads and verifies the envelope of a vector, describing its structure like newVector
+func readVector(data []byte) (*vectorEnvelope, error) {
+	e, err := Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	if err = e.Verify(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	h, k := e.Header, e.Keys
+	read := &vectorEnvelope{Version: h.Version, Compression: h.Compression, Hash: h.Hash, Flags: h.Flags,
+		PayloadOffset: e.PayloadOffset, PayloadLength: h.PayloadLength, KeysOffset: e.KeysOffset, TrailerOffset: e.TrailerOffset,
+		SignatureHash: k.SignatureHash, Signature: hex.EncodeToString(k.Signature), IndexOffset: k.IndexOffset, IndexLength: k.IndexLength,
+		Channel: k.Channel, Annotations: k.Annotations, Entries: []vectorEntry{}}
+	for _, entry := range k.Entries {
+		read.Entries = append(read.Entries, vectorEntry{Fleet: entry.Fleet, Hint: hex.EncodeToString(entry.Hint), Key: hex.EncodeToString(entry.Key)})
+	}
+	if e.Trailer != nil {
+		read.ChunkSize, read.Digest = e.Trailer.ChunkSize, hex.EncodeToString(e.Trailer.Digest)
+	}
+	return read, nil
+}
+
+func TestVectors(t *testing.T) {
+	if *updateVectors {
+		var vectors []vector
+		for _, src := range vectorSources() {
+			vectors = append(vectors, newVector(t, src))
+		}
+		data, err := json.MarshalIndent(vectors, "", "  ")
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(vectorsPath, append(data, '\n'), 0644))
+	}
+	data, err := os.ReadFile(vectorsPath)
+	assert.NoError(t, err)
+	var vectors []vector
+	assert.NoError(t, json.Unmarshal(data, &vectors))
+	assert.Len(t, vectors, len(vectorSources()), "run go test ./format -run TestVectors -update after adding vectors")
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			raw, err := hex.DecodeString(v.Hex)
+			assert.NoError(t, err)
+			read, err := readVector(raw)
+			if v.Error != "" {
+				assert.ErrorContains(t, err, v.Error)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, v.Expected, read)
+		})
+	}
+}
+
+// TestVectorsRoundTrip checks that encoding what was read from a vector reproduces it byte by byte
+func TestVectorsRoundTrip(t *testing.T) {
+	for _, src := range vectorSources() {
+		if src.damage != nil {
+			continue
+		}
+		data, err := src.envelope.Append(nil, src.payload)
+		assert.NoError(t, err)
+		e, err := Read(bytes.NewReader(data), int64(len(data)))
+		assert.NoError(t, err)
+		payload := make([]byte, e.Header.PayloadLength)
+		_, err = e.Payload(bytes.NewReader(data)).Read(payload)
+		assert.NoError(t, err)
+		assert.Equal(t, src.payload, payload)
+		again, err := e.Append(nil, payload)
+		assert.NoError(t, err)
+		assert.Equal(t, data, again, src.name)
+	}
+}
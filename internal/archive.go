@@ -18,146 +18,325 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"context"
 	"crypto"
-	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/apex/log"
+	"github.com/awnumar/memguard"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/innomotics/sealpack/format"
 	"github.com/klauspost/compress/flate"
 	"github.com/klauspost/compress/gzip"
 	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
 	"github.com/ovh/symmecrypt"
+	"github.com/ovh/symmecrypt/ciphers/xchacha20poly1305"
 	"github.com/sigstore/sigstore/pkg/signature"
 	"github.com/sigstore/sigstore/pkg/signature/options"
+	"go.opentelemetry.io/otel/attribute"
+	"hash"
 	"io"
+	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 const (
 	// EnvelopeMagicBytes is set to ASCII sum of "ECS" = 333(octal) or DB(hex)
-	EnvelopeMagicBytes = "\xDBIPC"
-	TocFileName        = ".sealpack.toc"
+	EnvelopeMagicBytes = format.Magic
+	// EnvelopeMagicBytesV2 introduces envelopes with format version and flags in the header
+	EnvelopeMagicBytesV2 = format.MagicV2
+	TocFileName          = ".sealpack.toc"
+	// paxSignatureHash holds the name of the hash the TOC signature was created with
+	paxSignatureHash = "SEALPACK.signature.hash"
+	// paxModeRecorded marks entries whose permission bits were taken from their source, so unseal applies them
+	paxModeRecorded = "SEALPACK.mode.recorded"
+	// paxImageDigest holds the manifest digest of a pulled image, so unseal can tell if importing it changes a tag
+	paxImageDigest = "SEALPACK.image.digest"
+	// maxKeyLength limits the size of a single receiver key record
+	maxKeyLength = format.MaxKeyLength
+	// maxFleetKeyLength limits the size of the fleet key entry, which holds a record for every device
+	maxFleetKeyLength = format.MaxFleetKeyLength
 )
 
-// ParseEnvelope tries to extract the information for an Envelope from a byte slice
+const (
+	// EnvelopeVersion1 is the original format without checksum trailer
+	EnvelopeVersion1 = format.Version1
+	// EnvelopeVersion2 adds a version and a flags byte to the header
+	EnvelopeVersion2 = format.Version2
+	// EnvelopeVersion3 prefixes receiver keys with their length in bytes as uvarint instead of len/8
+	EnvelopeVersion3 = format.Version3
+	// EnvelopeVersion4 adds an envelope signature covering header, payload and keys; unknown flags are rejected
+	EnvelopeVersion4 = format.Version4
+	// EnvelopeVersion5 encrypts the payload in authenticated frames, which can be decrypted independently
+	EnvelopeVersion5 = format.Version5
+	// EnvelopeVersion6 ends the header with a CRC32C, so damaged headers are found before the payload is read
+	EnvelopeVersion6 = format.Version6
+	// EnvelopeVersionLatest is the version written by default
+	EnvelopeVersionLatest = format.VersionLatest
+	// EnvelopeFlagTrailer marks envelopes ending with a checksum trailer
+	EnvelopeFlagTrailer = format.FlagTrailer
+	// EnvelopeFlagSignature marks envelopes with a signature record between payload and keys
+	EnvelopeFlagSignature = format.FlagSignature
+	// EnvelopeFlagFleetKey marks envelopes whose first key entry is wrapped for a fleet master secret
+	EnvelopeFlagFleetKey = format.FlagFleetKey
+	// EnvelopeFlagRecipientHints marks envelopes whose recipient key entries start with the fingerprint of their key
+	EnvelopeFlagRecipientHints = format.FlagRecipientHints
+	// EnvelopeFlagChunked marks envelopes whose payload stores files as deduplicated chunks or images with shared layers,
+	// so sealpack versions unable to assemble them reject the package
+	EnvelopeFlagChunked = format.FlagChunked
+	// EnvelopeFlagIndexed marks envelopes with an index record after the signature record, locating the index of the payload
+	EnvelopeFlagIndexed = format.FlagIndexed
+	// EnvelopeFlagLabels marks envelopes with a labels record after the index record: the channel the package was sealed for and its annotations
+	EnvelopeFlagLabels = format.FlagLabels
+	// knownFlags are all flags this version of sealpack can read
+	knownFlags = format.KnownFlags
+)
+
+// ParseEnvelope tries to extract the information for an Envelope from a byte slice.
+// Envelopes with a checksum trailer are verified, so corruptions are found before decryption.
+// All parsing errors are classified as ErrCorruptEnvelope.
 func ParseEnvelope(input io.ReadSeeker) (*Envelope, error) {
+	envel, err := parseEnvelope(input, true)
+	if err != nil {
+		return nil, corruptEnvelope(err)
+	}
+	return envel, nil
+}
+
+// ParseEnvelopeUnverified reads an Envelope without verifying the checksum trailer.
+// It reads the header and the key section only, so it suits packages in remote storage.
+func ParseEnvelopeUnverified(input io.ReadSeeker) (*Envelope, error) {
+	envel, err := parseEnvelope(input, false)
+	if err != nil {
+		return nil, corruptEnvelope(err)
+	}
+	return envel, nil
+}
+
+// parseEnvelope reads the envelope structure from the input, verifying the checksum trailer if requested
+func parseEnvelope(input io.ReadSeeker, verify bool) (*Envelope, error) {
 	rd := bufio.NewReader(input)
-	sig, err := rd.Peek(len(EnvelopeMagicBytes))
+	magic, err := rd.Peek(len(EnvelopeMagicBytes))
 	if err != nil {
 		return nil, err
 	}
-	if !bytes.Equal(sig, []byte(EnvelopeMagicBytes)) {
-		return nil, fmt.Errorf("not a valid sealpack file")
+	size := format.HeaderSizeV1
+	if string(magic) == EnvelopeMagicBytesV2 {
+		size = format.MaxHeaderSize
 	}
-	if _, err = rd.Discard(len(EnvelopeMagicBytes)); err != nil {
-		return nil, err
+	raw, peekErr := rd.Peek(size)
+	header, err := format.ParseHeader(raw)
+	if errors.Is(err, format.ErrTruncated) {
+		return nil, peekErr
 	}
-	// config Contains 2 infos (LSB)
-	// Bytes 7-5: Compression algorithm
-	// Bytes 4-0: Hash algorithm
-	config, err := rd.ReadByte()
 	if err != nil {
 		return nil, err
 	}
+	if _, err = rd.Discard(header.Size()); err != nil {
+		return nil, err
+	}
 	envel := &Envelope{
-		HashAlgorithm:   crypto.Hash(config & 0b00011111),
-		CompressionAlgo: config >> 5,
+		Version:         header.Version,
+		Flags:           header.Flags,
+		PayloadLen:      int64(header.PayloadLength),
+		HashAlgorithm:   crypto.Hash(header.Hash),
+		CompressionAlgo: header.Compression,
+	}
+	envel.PayloadReader = input
+	keys := rd
+	if envel.HasTrailer() {
+		if keys, err = envel.readTrailer(input, verify); err != nil {
+			return nil, err
+		}
+	} else if !verify {
+		// Skip the payload without reading it
+		if _, err = input.Seek(envel.headerSize()+envel.PayloadLen, io.SeekStart); err != nil {
+			return nil, err
+		}
+		keys = bufio.NewReader(input)
+	} else if _, err = rd.Discard(int(envel.PayloadLen)); err != nil {
+		return nil, err
 	}
-	payload := make([]byte, 8)
-	if _, err = rd.Read(payload); err != nil {
+	if err = envel.readKeys(keys); err != nil {
 		return nil, err
 	}
-	envel.PayloadLen = int64(binary.LittleEndian.Uint64(payload))
-	envel.PayloadReader = input
-	if _, err = rd.Discard(int(envel.PayloadLen)); err != nil {
+	if _, err = envel.PayloadReader.Seek(envel.headerSize(), io.SeekStart); err != nil {
+		return nil, err
+	}
+	return envel, nil
+}
+
+// readTrailer reads and optionally verifies the checksum trailer and provides a reader for the keys in front of it
+func (e *Envelope) readTrailer(input io.ReadSeeker, verify bool) (*bufio.Reader, error) {
+	trailer, err := readTrailer(input)
+	if err != nil {
+		return nil, err
+	}
+	keysStart := e.headerSize() + e.PayloadLen
+	if trailer.offset < keysStart {
+		return nil, fmt.Errorf("file truncated at byte %d, payload ends at byte %d", trailer.offset, keysStart)
+	}
+	if verify {
+		if err = trailer.Verify(input); err != nil {
+			return nil, err
+		}
+	}
+	e.Checksum = trailer.digest
+	if _, err = input.Seek(keysStart, io.SeekStart); err != nil {
 		return nil, err
 	}
-	var k byte
+	return bufio.NewReader(io.LimitReader(input, trailer.offset-keysStart)), nil
+}
+
+// readKeys reads the key section following the payload: the signature record, if signed, the index record, if indexed,
+// the labels record, if it has labels, and all receiver keys
+func (e *Envelope) readKeys(keys *bufio.Reader) (err error) {
+	if e.IsSigned() {
+		if e.SignatureHash, e.Signature, _, err = readSignatureRecord(keys); err != nil {
+			return fmt.Errorf("invalid envelope signature: %w", err)
+		}
+	}
+	if e.HasIndex() {
+		if err = e.readIndexRecord(keys); err != nil {
+			return fmt.Errorf("invalid index record: %w", err)
+		}
+	}
+	if e.HasLabels() {
+		if err = e.readLabelsRecord(keys); err != nil {
+			return fmt.Errorf("invalid labels record: %w", err)
+		}
+	}
 	for {
-		k, err = rd.ReadByte()
-		if err != nil {
+		var keyLen int64
+		if keyLen, err = e.readKeyLength(keys); err != nil {
 			break
 		}
 		receiverKey := bytes.NewBuffer([]byte{})
-		if _, err = io.CopyN(receiverKey, rd, int64(k)*8); err != nil {
-			return nil, err
+		if _, err = io.CopyN(receiverKey, keys, keyLen); err != nil {
+			return err
 		}
-		envel.ReceiverKeys = append(envel.ReceiverKeys, receiverKey.Bytes())
+		e.ReceiverKeys = append(e.ReceiverKeys, receiverKey.Bytes())
 	}
-	// 4 Magic Bytes + 1 Byte Hash Algorithm + 8 Bytes Payload Length = 13 Bytes offset
-	if _, err = envel.PayloadReader.Seek(13, 0); err != nil {
-		return nil, err
+	if !errors.Is(err, io.EOF) {
+		return err
 	}
-	return envel, nil
+	return e.splitRecipientHints()
+}
+
+// readKeyLength reads the length prefix of a receiver key in bytes.
+// io.EOF is returned if no more keys follow.
+func (e *Envelope) readKeyLength(rd io.ByteReader) (int64, error) {
+	if e.Version < EnvelopeVersion3 {
+		k, err := rd.ReadByte()
+		return int64(k) * 8, err
+	}
+	keyLen, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return 0, err
+	}
+	if keyLen > uint64(e.keyLengthLimit(len(e.ReceiverKeys))) {
+		return 0, fmt.Errorf("invalid key length %d", keyLen)
+	}
+	return int64(keyLen), nil
+}
+
+// keyLengthLimit is the size limit of the i-th key entry, which is larger for the fleet key entry
+func (e *Envelope) keyLengthLimit(i int) int {
+	if i == 0 && e.Version >= EnvelopeVersion4 && e.Flags&EnvelopeFlagFleetKey != 0 {
+		return maxFleetKeyLength
+	}
+	return maxKeyLength
 }
 
 // Envelope is the package with headers and so on
 type Envelope struct {
+	// Version of the envelope format, 0 is treated as EnvelopeVersion1
+	Version         uint8
+	Flags           uint8
 	PayloadLen      int64
 	PayloadReader   io.ReadSeeker
 	PayloadWriter   *os.File
 	HashAlgorithm   crypto.Hash
 	CompressionAlgo uint8
 	ReceiverKeys    [][]byte
+	// RecipientHints are the SHA-256 fingerprints of the recipient keys, if the envelope records them
+	RecipientHints [][]byte
+	// Checksum is the SHA-256 digest of the envelope, if it has a checksum trailer; verified when parsing
+	Checksum []byte
+	// Signer signs the envelope when writing EnvelopeVersion4 or newer
+	Signer signature.Signer
+	// SignatureHash is the name of the hash the envelope signature uses
+	SignatureHash string
+	// Signature of the envelope, if it is signed
+	Signature []byte
+	// IndexOffset and IndexLength locate the index in the decrypted payload, if it has one
+	IndexOffset int64
+	IndexLength int64
+	// Channel is the release channel the package was sealed for, if it records one
+	Channel string
+	// Annotations are arbitrary key-value pairs of the package, e.g. a ticket or build ID
+	Annotations map[string]string
+}
+
+// HasTrailer determines whether the envelope ends with a checksum trailer
+func (e *Envelope) HasTrailer() bool {
+	return e.Version > EnvelopeVersion1 && e.Flags&EnvelopeFlagTrailer != 0
+}
+
+// headerSize is the offset of the payload in the envelope
+func (e *Envelope) headerSize() int64 {
+	return int64(format.HeaderSizeOf(e.Version))
 }
 
 // ToBytes provides an Envelope as Bytes.
 // Caution: using this method may massively increase memory usage!
 func (e *Envelope) ToBytes() []byte {
-	// Add basic header information
-	result := append(
-		[]byte(EnvelopeMagicBytes),
-		(e.CompressionAlgo<<5)|uint8(e.HashAlgorithm),
-	)
-	// Payload Length
-	payloadLen := make([]byte, 8)
-	binary.LittleEndian.PutUint64(payloadLen, uint64(e.PayloadLen))
-	result = append(result, payloadLen...)
-	// Then the Payload
+	result := &bytes.Buffer{}
 	buf, _ := os.ReadFile(e.PayloadWriter.Name())
-	result = append(result, buf...)
-	// Finally, the receivers' keys prefixed with their digest sizes in bytes
-	for _, key := range e.ReceiverKeys {
-		result = append(result, uint8(len(key)/8))
-		result = append(result, key...)
-	}
-	return result
+	_ = e.writeEnvelope(result, bytes.NewReader(buf))
+	return result.Bytes()
 }
 
 // WriteHeader writes the envelope headers to an io.Writer.
 func (e *Envelope) WriteHeader(w io.Writer) error {
-	if _, err := w.Write([]byte(EnvelopeMagicBytes)); err != nil {
-		return err
-	}
-	if _, err := w.Write([]byte{(e.CompressionAlgo << 5) | uint8(e.HashAlgorithm)}); err != nil {
-		return err
-	}
-	payloadLen := make([]byte, 8)
-	binary.LittleEndian.PutUint64(payloadLen, uint64(e.PayloadLen))
-	if _, err := w.Write(payloadLen); err != nil {
-		return err
-	}
-	return nil
+	header := &format.Header{
+		Version:       e.Version,
+		Compression:   e.CompressionAlgo,
+		Hash:          uint8(e.HashAlgorithm),
+		Flags:         e.Flags,
+		PayloadLength: uint64(e.PayloadLen),
+	}
+	_, err := w.Write(header.Append(nil))
+	return err
 }
 
 // WriteKeys writes encrypted keys to an io.Writer.
+// Before EnvelopeVersion3, key lengths are stored as len/8 in a single byte, so they must be a multiple of 8 and at most 2040 bytes.
 func (e *Envelope) WriteKeys(w io.Writer) error {
-	// Finally, the receivers' keys prefixed with their digest sizes in bytes
-	for _, key := range e.ReceiverKeys {
-		if len(key)%8 != 0 {
-			return fmt.Errorf("invalid key length")
-		}
-		if _, err := w.Write([]byte{uint8(len(key) / 8)}); err != nil {
-			return err
-		}
-		if _, err := w.Write(key); err != nil {
-			return err
-		}
+	if e.HasRecipientHints() && len(e.RecipientHints) != len(e.recipientKeys()) {
+		return fmt.Errorf("%d recipient hints for %d recipient keys", len(e.RecipientHints), len(e.recipientKeys()))
 	}
-	return nil
+	// Finally, the receivers' keys prefixed with their sizes
+	keys, err := format.AppendKeyEntries(nil, e.Version, e.Flags, e.keyEntries())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(keys)
+	return err
 }
 
 // String prints a string representation of an Envelope with basic information
@@ -168,30 +347,108 @@ func (e *Envelope) String() string {
 	} else {
 		sb.WriteString("File is a sealed package.\n")
 	}
+	sb.WriteString(fmt.Sprintf("\tEnvelope version %d\n", max(e.Version, EnvelopeVersion1)))
 	sb.WriteString(fmt.Sprintf("\tPayload size (compressed): %d Bytes\n", e.PayloadLen))
+	sb.WriteString(fmt.Sprintf("\tPayload compressed using %s\n", GetCompressionAlgoName(e.CompressionAlgo)))
 	sb.WriteString(fmt.Sprintf("\tSignatures hashed using %s (%d Bit)\n", e.HashAlgorithm.String(), e.HashAlgorithm.Size()))
-	if len(e.ReceiverKeys) > 0 {
-		sb.WriteString(fmt.Sprintf("\tSealed for %d receivers\n", len(e.ReceiverKeys)))
+	if e.Version >= EnvelopeVersion4 && e.Flags&EnvelopeFlagChunked != 0 {
+		sb.WriteString("\tContents stored as deduplicated chunks or layers\n")
+	}
+	if e.HasIndex() {
+		sb.WriteString("\tPayload indexed for extracting single files\n")
+	}
+	if e.Channel != "" {
+		sb.WriteString(fmt.Sprintf("\tSealed for channel %s\n", e.Channel))
+	}
+	if len(e.Annotations) > 0 {
+		sb.WriteString("\tAnnotations\n")
+		for _, key := range annotationKeys(e.Annotations) {
+			sb.WriteString(fmt.Sprintf("\t\t%s=%s\n", key, e.Annotations[key]))
+		}
+	}
+	if e.HasFleetKey() {
+		sb.WriteString("\tSealed for a fleet key\n")
+	}
+	if len(e.recipientKeys()) > 0 {
+		sb.WriteString(fmt.Sprintf("\tSealed for %d receivers\n", len(e.recipientKeys())))
+	}
+	for _, description := range e.keyDescriptions() {
+		sb.WriteString("\t\t" + description + "\n")
+	}
+	if e.Checksum != nil {
+		sb.WriteString(fmt.Sprintf("\tEnvelope checksum verified (SHA-256 %x)\n", e.Checksum))
+	} else {
+		sb.WriteString("\tEnvelope has no checksum\n")
+	}
+	if e.IsSigned() {
+		sb.WriteString(fmt.Sprintf("\tEnvelope signed using %s, verified on unseal\n", e.SignatureHash))
+	} else {
+		sb.WriteString("\tEnvelope header and keys are not signed\n")
 	}
 	return sb.String()
 }
 
-// WriteOutput creates an encrypted output file from encrypted payload
-func (e *Envelope) WriteOutput(f *os.File, arc *WriteArchive) error {
-	if err := e.WriteHeader(f); err != nil {
+// newOutputWriter wraps w to calculate the checksum trailer, if the envelope version supports it
+func (e *Envelope) newOutputWriter(w io.Writer) (io.Writer, *checksumWriter) {
+	if e.Version <= EnvelopeVersion1 {
+		return w, nil
+	}
+	e.Flags |= EnvelopeFlagTrailer
+	sums := newChecksumWriter(w, checksumChunkSize)
+	return sums, sums
+}
+
+// writeEnvelope writes header, payload, signature, keys and trailer of the envelope
+func (e *Envelope) writeEnvelope(out io.Writer, payload io.Reader) error {
+	w, sums := e.newOutputWriter(out)
+	if e.Signer != nil && e.Version >= EnvelopeVersion4 {
+		e.Flags |= EnvelopeFlagSignature
+	}
+	if err := e.WriteHeader(w); err != nil {
 		return err
 	}
-	payload, err := os.Open(arc.outFile.Name())
-	if err != nil {
+	digest := sha256.New()
+	if _, err := io.Copy(w, io.TeeReader(payload, digest)); err != nil {
 		return err
 	}
-	if _, err = io.Copy(f, payload); err != nil {
+	if e.IsSigned() {
+		if err := e.sign(digest.Sum(nil)); err != nil {
+			return err
+		}
+		if _, err := w.Write(signatureRecord(e.SignatureHash, e.Signature)); err != nil {
+			return err
+		}
+	}
+	if e.HasIndex() {
+		if _, err := w.Write(e.indexRecord()); err != nil {
+			return err
+		}
+	}
+	if e.HasLabels() {
+		if _, err := w.Write(e.labelsRecord()); err != nil {
+			return err
+		}
+	}
+	if err := e.WriteKeys(w); err != nil {
 		return err
 	}
-	if err = payload.Close(); err != nil {
+	if sums != nil {
+		trailer := sums.Trailer()
+		if _, err := out.Write(trailer.Bytes()); err != nil {
+			return err
+		}
+		e.Checksum = trailer.digest
+	}
+	return nil
+}
+
+// WriteOutput creates an encrypted output file from encrypted payload
+func (e *Envelope) WriteOutput(f *os.File, arc *WriteArchive) error {
+	info, err := arc.outFile.Stat()
+	if err != nil {
 		return err
 	}
-	if err = e.WriteKeys(f); err != nil {
+	if err = e.writeEnvelope(f, io.NewSectionReader(arc.outFile, 0, info.Size())); err != nil {
 		return err
 	}
 	if err = f.Sync(); err != nil {
@@ -201,37 +458,86 @@ func (e *Envelope) WriteOutput(f *os.File, arc *WriteArchive) error {
 }
 
 // GetPayload provides the Payload from the envelope
-func (e *Envelope) GetPayload(privateKeyPath string) (payload io.Reader, err error) {
+func (e *Envelope) GetPayload(privateKeyPath string) (io.Reader, error) {
 	if len(e.ReceiverKeys) < 1 {
-		log.Info("unseal: read public archive")
 		// Was not encrypted: public archive
-		payload = e.PayloadReader
-	} else {
-		log.Infof("unseal: read archive sealed for %d receivers", len(e.ReceiverKeys))
-		// Try to find a key that can be decrypted with the provided private key
-		var pKey interface{}
-		pKey, err = LoadPrivateKey(privateKeyPath)
-		if err != nil {
-			return
-		}
-		decryptionKey, ok := pKey.(*rsa.PrivateKey)
-		if !ok {
-			return nil, fmt.Errorf("could not use provided private key for decryption")
+		return e.PayloadReader, nil
+	}
+	plainKey, err := e.PayloadKey(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(plainKey)
+	return e.decryptPayload(plainKey)
+}
+
+// PayloadKey decrypts the payload key from the key entry of the recipient owning the private key.
+// The key has to be wiped after use.
+func (e *Envelope) PayloadKey(privateKeyPath string) (plainKey []byte, err error) {
+	if len(e.recipientKeys()) < 1 {
+		return nil, WithHint(fmt.Errorf("%w: sealed for a fleet only, a fleet key is required", ErrNotRecipient),
+			"provide the fleet master secret with --fleet-key and the fleet ID with --fleet-id")
+	}
+	// Try to find a key that can be decrypted with the provided private key
+	var provider KeyProvider
+	if provider, err = KeyProviderFor(privateKeyPath); err != nil {
+		return
+	}
+	var pKey crypto.PrivateKey
+	if pKey, err = provider.PrivateKey(privateKeyPath); err != nil {
+		return
+	}
+	var publicKey crypto.PublicKey
+	switch key := pKey.(type) {
+	case crypto.Signer:
+		publicKey = key.Public()
+	case openpgp.EntityList:
+		publicKey = key
+	case tpmKey:
+		if publicKey, err = key.Public(); err != nil {
+			return nil, err
 		}
-		var symKey symmecrypt.Key
-		for _, key := range e.ReceiverKeys {
-			symKey, err = TryUnsealKey(key, decryptionKey)
-			if err == nil {
-				break
-			}
+	default:
+		return nil, WithHint(fmt.Errorf("%w: could not use provided private key for decryption", ErrNotRecipient),
+			"only RSA, ECDSA, Ed25519 and OpenPGP keys can decrypt packages, but %s is an %s key", privateKeyPath, keyTypeName(pKey))
+	}
+	for _, key := range e.recipientKeys() {
+		plainKey, err = tryUnsealKeyWith(key, pKey)
+		if err == nil {
+			break
 		}
-		if symKey == nil {
-			return nil, fmt.Errorf("not sealed for the provided private key")
+	}
+	if plainKey == nil {
+		fingerprint, _ := Fingerprint(publicKey)
+		err = fmt.Errorf("%w: not sealed for the provided private key", ErrNotRecipient)
+		if e.HasRecipientHints() {
+			return nil, WithHint(err, "the public key of %s has the fingerprint %s, but the package is sealed for %s",
+				privateKeyPath, fingerprint, strings.Join(e.RecipientFingerprints(), ", "))
 		}
-		// Decrypt the payload and decrypt it
-		payload, err = symmecrypt.NewReader(io.LimitReader(e.PayloadReader, e.PayloadLen), symKey)
+		return nil, WithHint(err, "the package is sealed for %d other keys; the public key of %s has the fingerprint %s, "+
+			"compare it to the recipient keys using `sealpack key info`", len(e.recipientKeys()), privateKeyPath, fingerprint)
 	}
-	return
+	return plainKey, nil
+}
+
+// decryptPayload decrypts the payload with the plain payload key.
+// Since EnvelopeVersion5 the payload is encrypted in frames, before that as a whole.
+func (e *Envelope) decryptPayload(plainKey []byte) (io.Reader, error) {
+	payload := io.LimitReader(e.PayloadReader, e.PayloadLen)
+	if e.Version >= EnvelopeVersion5 {
+		return NewFrameReader(payload, plainKey)
+	}
+	symKey, err := symmecrypt.NewKey(xchacha20poly1305.CipherName, string(plainKey))
+	if err != nil {
+		return nil, err
+	}
+	return symmecrypt.NewReader(payload, symKey)
+}
+
+// RewindPayload moves the payload reader back to the start of the payload, so the payload can be read again.
+func (e *Envelope) RewindPayload() error {
+	_, err := e.PayloadReader.Seek(e.headerSize(), io.SeekStart)
+	return err
 }
 
 /****************
@@ -244,14 +550,94 @@ type WriteArchive struct {
 	compressionAlgo uint8
 	tarWriter       *tar.Writer
 	outFile         *os.File
-	EncryptionKey   string
+	cleanedUp       bool
+	encryptionKey   *memguard.LockedBuffer
+	// EncryptionKey is the symmetric key of the payload, kept in memguard memory that is destroyed by Cleanup
+	EncryptionKey []byte
+	Report        *Report
+	// Events receive the files and images added, if set
+	Events      Events
+	FileDigests map[string]string
+	Duplicates  string
+	NoSparse    bool
+	// Symlinks is the policy for symbolic links among the files added: SymlinksFollow if empty, SymlinksPreserve or SymlinksReject
+	Symlinks string
+	// Dedup stores files as content-defined chunks, so chunks repeated in any file are only stored once
+	Dedup bool
+	// DedupLayers stores the layers of images once in the layers area, so images sharing base layers do not repeat them
+	DedupLayers     bool
+	ImageSignatures bool
+	// SignatureHash is the name of the hash the TOC is signed with, DefaultSignatureHash if empty
+	SignatureHash string
+	// Context carries the trace of the operation the archive is written in, may be nil
+	Context context.Context
+	// HTTPHeader is sent with all downloads of HTTP sources, e.g. for authorization
+	HTTPHeader http.Header
+	// Logger receives the log messages of the archive, the global logger if nil
+	Logger      log.Interface
+	compression CompressionOptions
+	sources     map[string]string
+	// following are the directories of symbolic links currently being followed, to detect loops
+	following map[string]bool
+	// chunks are the digests of all chunks stored with Dedup
+	chunks map[string]bool
+	// layers are the names of all layers stored with DedupLayers
+	layers map[string]bool
+	// Index compresses every entry separately and records where it is stored, so single entries can be extracted
+	Index bool
+	// plain counts the compressed payload before encryption, locating the entries of the index
+	plain *positionWriter
+	index []IndexEntry
+	// IndexOffset and IndexLength locate the index in the compressed payload after Finalize
+	IndexOffset int64
+	IndexLength int64
+	// RecordOrigins records the origin of every entry, so installed files can be traced back to their build input
+	RecordOrigins bool
+	// OriginCommit is recorded as commit of the build input in the origins of all entries, if set
+	OriginCommit string
+	origins      Origins
+	// toc, tocSignature and tocSignatureHash are kept by AddToc, so the signed TOC can be exported
+	toc              []byte
+	tocSignature     []byte
+	tocSignatureHash string
 }
 
+// CompressionOptions tune the compression of a WriteArchive
+type CompressionOptions struct {
+	// Level of compression, 0 uses the default level of the algorithm
+	Level int
+	// Threads used by gzip and zstd, 0 uses all CPUs
+	Threads int
+}
+
+// Validate checks if the options are valid for a compression algorithm
+func (o CompressionOptions) Validate(compressionAlgo uint8) error {
+	maxLevel := 9
+	if compressionAlgo == GetCompressionAlgoIndex(CompressionZstd) {
+		maxLevel = 22
+	}
+	if o.Level < 0 || o.Level > maxLevel {
+		return fmt.Errorf("compression level of %s must be between 1 and %d", GetCompressionAlgoName(compressionAlgo), maxLevel)
+	}
+	if o.Threads < 0 {
+		return fmt.Errorf("compression threads must not be negative")
+	}
+	return nil
+}
+
+const (
+	// DuplicatesFail aborts sealing if two contents are stored with the same name
+	DuplicatesFail = "fail"
+	// DuplicatesSkip keeps the first of multiple contents with the same name and skips the others
+	DuplicatesSkip = "skip"
+)
+
 const (
 	CompressionGzip  = "gzip"
 	CompressionZlib  = "zlib"
 	CompressionZip   = "zip"
 	CompressionFlate = "flate"
+	CompressionZstd  = "zstd"
 )
 
 // compressionAlgorithms as provided by https://github.com/klauspost/compress/
@@ -260,6 +646,7 @@ var compressionAlgorithms = []string{
 	CompressionZlib,
 	CompressionZip,
 	CompressionFlate,
+	CompressionZstd,
 }
 
 // GetCompressionAlgoName gets the name of an algo index or defaults to gzip (0)
@@ -273,35 +660,72 @@ func GetCompressionAlgoName(idx uint8) string {
 
 // GetCompressionAlgoIndex gets the index of an algo name or defaults to 0 (gzip)
 func GetCompressionAlgoIndex(algo string) uint8 {
+	idx, err := ParseCompressionAlgo(algo)
+	if err != nil {
+		log.Warnf("Invalid algorithm '%s', defaulting to '%s'", algo, compressionAlgorithms[0])
+	}
+	return idx
+}
+
+// ParseCompressionAlgo retrieves the index of a compression algorithm, failing for unknown names
+func ParseCompressionAlgo(algo string) (uint8, error) {
 	for i, algoName := range compressionAlgorithms {
 		if algoName == algo {
-			return uint8(i)
+			return uint8(i), nil
 		}
 	}
-	log.Warnf("Invalid algorithm '%s', defaulting to '%s'", algo, compressionAlgorithms[0])
-	return 0
+	return 0, fmt.Errorf("unknown compression algorithm '%s', use one of %s", algo, strings.Join(compressionAlgorithms, ", "))
 }
 
 // CreateArchiveWriter opens a stream of writers (tar to gzip to buffer) and funnel to a csutom writer.
 func CreateArchiveWriter(public bool, compressionAlgo uint8) *WriteArchive {
-	f, err := os.CreateTemp("", "packed_contents")
+	return CreateArchiveWriterWithOptions(public, compressionAlgo, CompressionOptions{}, EnvelopeVersionLatest)
+}
+
+// CreateArchiveWriterWithOptions creates a WriteArchive like CreateArchiveWriter, tuning the compression.
+// The payload is encrypted as expected by the envelope version.
+func CreateArchiveWriterWithOptions(public bool, compressionAlgo uint8, compression CompressionOptions, envelopeVersion uint8) *WriteArchive {
+	f, err := createTempFile("packed_contents")
 	if err != nil {
 		log.Fatal("could not create temp file")
 	}
 	arc := &WriteArchive{
-		outFile: f,
+		outFile:         f,
+		compression:     compression,
+		compressionAlgo: compressionAlgo,
 	}
 	if !public {
-		arc.EncryptionKey, arc.encryptWriter = EncryptWriter(arc.outFile)
-		arc.InitializeCompression(arc.encryptWriter, compressionAlgo)
+		var key []byte
+		if envelopeVersion >= EnvelopeVersion5 {
+			if key, arc.encryptWriter, err = EncryptFramedWriter(payloadWriter{f}); err != nil {
+				log.Fatalf("could not create payload encryption: %v", err)
+			}
+		} else {
+			key, arc.encryptWriter = EncryptWriter(payloadWriter{f})
+		}
+		if arc.encryptionKey, err = lockBytes(key); err != nil {
+			log.Fatalf("could not lock payload key: %v", err)
+		}
+		arc.EncryptionKey = arc.encryptionKey.Bytes()
+		arc.plain = &positionWriter{w: arc.encryptWriter}
 	} else {
-		log.Warn("The --public flag was set. Your contents will NOT BE ENCRYPTED. Please verify this is on purpose.")
-		arc.InitializeCompression(arc.outFile, compressionAlgo)
+		arc.plain = &positionWriter{w: f}
 	}
-	arc.tarWriter = tar.NewWriter(arc.compressWriter)
+	arc.InitializeCompression(arc.plain, compressionAlgo)
+	// The tar writer writes to the current compression writer, which is replaced for every member of an indexed payload
+	arc.tarWriter = tar.NewWriter(memberWriter{arc})
 	return arc
 }
 
+// payloadWriter writes to the temporary payload without closing it, as it is read again after finalizing
+type payloadWriter struct {
+	io.Writer
+}
+
+func (payloadWriter) Close() error {
+	return nil
+}
+
 // Finalize closes the tar and gzip writers and retrieves the archive.
 // Additionally, it returns the size of the payload.
 func (arc *WriteArchive) Finalize() (int64, error) {
@@ -310,6 +734,11 @@ func (arc *WriteArchive) Finalize() (int64, error) {
 	if err = arc.tarWriter.Close(); err != nil {
 		return 0, err
 	}
+	if arc.Index {
+		if err = arc.writeIndex(); err != nil {
+			return 0, err
+		}
+	}
 	_, closeable := arc.compressWriter.(interface{}).(io.Closer)
 	if closeable {
 		// Do not fail on closing closed closer
@@ -317,144 +746,291 @@ func (arc *WriteArchive) Finalize() (int64, error) {
 			return 0, err
 		}
 	}
+	if arc.Index {
+		arc.IndexLength = arc.plain.written - arc.IndexOffset
+	}
 	if arc.encryptWriter != nil {
 		if err = arc.encryptWriter.Close(); err != nil {
 			return 0, err
 		}
 	}
 	// Collect size
-	stat, err := os.Stat(arc.outFile.Name())
+	stat, err := arc.outFile.Stat()
 	if err != nil {
 		return 0, err
 	}
 	return stat.Size(), nil
 }
 
-// Cleanup closes streams and removes temporary files
+// Cleanup wipes the encryption key and overwrites and removes the temporary payload.
+// It is safe to call it multiple times, so it can be deferred right after creating the archive.
 func (arc *WriteArchive) Cleanup() error {
-	// Close if not already done
-	_ = arc.outFile.Close()
-	return os.Remove(arc.outFile.Name())
+	if arc.cleanedUp {
+		return nil
+	}
+	arc.cleanedUp = true
+	arc.EncryptionKey = nil
+	if arc.encryptionKey != nil {
+		arc.encryptionKey.Destroy()
+	}
+	return removeTempFile(arc.outFile)
 }
 
 // WriteToArchive adds a new file identified by its name to the tar.gz archive.
 // The contents are added as reader resource.
 func (arc *WriteArchive) WriteToArchive(fileName string, contents *os.File) error {
+	return arc.writeFile(fileName, contents, 0, nil)
+}
+
+// writeFile adds a file with its permission bits, 0755 if zero, the modification time of the contents and
+// additional PAX records
+func (arc *WriteArchive) writeFile(fileName string, contents *os.File, mode os.FileMode, records map[string]string) error {
 	info, err := contents.Stat()
 	if err != nil {
 		return err
 	}
-	if err = arc.tarWriter.WriteHeader(&tar.Header{
-		Name:    fileName,
-		Size:    info.Size(),
-		Mode:    0755,
-		ModTime: info.ModTime(),
-	}); err != nil {
+	recorded := mode != 0
+	if mode == 0 {
+		mode = 0755
+	}
+	header := &tar.Header{
+		Name:       fileName,
+		Size:       info.Size(),
+		Mode:       int64(mode.Perm()),
+		ModTime:    info.ModTime(),
+		Format:     tar.FormatPAX,
+		PAXRecords: maps.Clone(records),
+	}
+	if recorded {
+		if header.PAXRecords == nil {
+			header.PAXRecords = map[string]string{}
+		}
+		header.PAXRecords[paxModeRecorded] = "1"
+	}
+	if arc.DedupLayers && strings.HasPrefix(fileName, ContainerImagePrefix) {
+		if layered, err := arc.writeLayered(header, contents); layered || err != nil {
+			return err
+		}
+	}
+	if arc.Dedup && info.Size() > 0 {
+		return arc.writeChunked(header, contents)
+	}
+	var reader io.Reader = contents
+	// Sparse files are stored packed, only containing their data regions
+	if regions, sparse := sparseRegions(contents, info.Size()); sparse && !arc.NoSparse {
+		setSparseHeader(header, regions)
+		reader = packedReader(contents, regions)
+	}
+	if err = arc.beginEntry(fileName); err != nil {
 		return err
 	}
+	if err = arc.tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("cannot add %s to archive: %w", fileName, err)
+	}
 	if _, err = contents.Seek(0, 0); err != nil {
 		return err
 	}
-	_, err = io.CopyN(arc.tarWriter, contents, info.Size())
+	_, err = io.CopyN(arc.tarWriter, reader, header.Size)
 	if err != nil {
+		return fileSizeError(fileName, err)
+	}
+	return arc.tarWriter.Flush()
+}
+
+// writeChunked adds a file as content-defined chunks, only storing chunks not stored by a previous entry
+func (arc *WriteArchive) writeChunked(header *tar.Header, contents *os.File) error {
+	if _, err := contents.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
+	chunks, err := chunkFile(contents)
+	if err != nil {
+		return err
+	}
+	if arc.chunks == nil {
+		arc.chunks = map[string]bool{}
+	}
+	added := setChunkHeader(header, chunks, arc.chunks)
+	if err = arc.beginEntry(header.Name); err != nil {
+		return err
+	}
+	if err = arc.tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("cannot add %s to archive: %w", header.Name, err)
+	}
+	for _, c := range added {
+		if _, err = io.Copy(arc.tarWriter, io.NewSectionReader(contents, c.Offset, c.Length)); err != nil {
+			return fileSizeError(header.Name, err)
+		}
+	}
 	return arc.tarWriter.Flush()
 }
 
 // AddToArchive adds a new file identified by its name to the tar.gz archive.
 // The contents are added as byte slices.
 func (arc *WriteArchive) AddToArchive(imgName string, contents []byte) error {
-	return BytesToTar(arc.tarWriter, &imgName, contents)
+	return arc.addBytes(imgName, contents, nil)
+}
+
+// addBytes adds a file with additional PAX records to the archive
+func (arc *WriteArchive) addBytes(name string, contents []byte, records map[string]string) error {
+	if err := arc.beginEntry(name); err != nil {
+		return err
+	}
+	return bytesToTar(arc.tarWriter, name, contents, records)
 }
 
 // AddContents adds first files, secondly images to the WriteArchive providing FileSignatures for verification
-func (arc *WriteArchive) AddContents(files []string, images []*ContainerImage, signatures *FileSignatures) (err error) {
-	err = arc.addFiles(files, signatures)
+func (arc *WriteArchive) AddContents(files []string, images []*ContainerImage, signatures *FileSignatures) error {
+	providers, err := ContentProviders(files, images, arc.ImageSignatures, arc.HTTPHeader, arc.Context)
 	if err != nil {
-		return
+		return err
 	}
-	err = arc.addImages(images, signatures)
-	return
+	return arc.AddProviders(providers, signatures)
 }
 
-// addImages adds images to the WriteArchive providing FileSignatures for verification
-func (arc *WriteArchive) addImages(images []*ContainerImage, signatures *FileSignatures) (err error) {
-	var inFile *os.File
-	for _, content := range images {
-		inFile, err = SaveImage(content)
+// AddProviders adds the entries of all providers in order, providing FileSignatures for verification
+func (arc *WriteArchive) AddProviders(providers []ContentProvider, signatures *FileSignatures) error {
+	pinned := 0
+	for _, provider := range providers {
+		entries, err := provider.Resolve()
 		if err != nil {
-			return fmt.Errorf("failed reading image: %v", err)
+			return err
 		}
-		if err = arc.storeContents(inFile, content.ToFileName(), signatures); err != nil {
-			return
+		for _, entry := range entries {
+			if _, ok := arc.FileDigests[entry.Source]; ok {
+				pinned++
+			}
+			if err = arc.addEntry(entry, signatures); err != nil {
+				return err
+			}
 		}
 	}
-	return
+	if pinned < len(arc.FileDigests) {
+		return fmt.Errorf("digests can only be pinned for single files that are added to the archive")
+	}
+	return nil
 }
 
-// isDir checks if a path is a directory or a file. On error, a file is assumed
-func isDir(name string) bool {
-	var err error
-	var file *os.File
-	var fi os.FileInfo
-	file, err = os.Open(name)
+// addEntry opens the contents of an entry and adds them, unless an entry of the same name has been added before
+func (arc *WriteArchive) addEntry(entry ProvidedEntry, signatures *FileSignatures) error {
+	if first, ok := arc.sources[entry.Name]; ok {
+		if arc.Duplicates != DuplicatesSkip {
+			return fmt.Errorf("duplicate entry %s: added from %s and %s", entry.Name, first, entry.Source)
+		}
+		arc.logger().Warnf("seal: skipping duplicate entry %s from %s, already added from %s", entry.Name, entry.Source, first)
+		return nil
+	}
+	if entry.Link != "" {
+		if stored, err := arc.applySymlinkPolicy(entry); stored || err != nil {
+			return err
+		}
+		if followed, err := arc.followDirectory(entry, signatures); followed || err != nil {
+			return err
+		}
+	}
+	inFile, err := entry.Open()
 	if err != nil {
-		return false
+		return err
+	}
+	if expected, ok := arc.FileDigests[entry.Source]; ok {
+		if err = VerifyDigest(inFile, expected); err != nil {
+			_ = inFile.Close()
+			return fmt.Errorf("file %s: %v", entry.Source, err)
+		}
+	}
+	var records map[string]string
+	if entry.Origin != nil && entry.Origin.ImageDigest != "" {
+		records = map[string]string{paxImageDigest: entry.Origin.ImageDigest}
 	}
-	if fi, err = file.Stat(); err != nil {
+	if err = arc.storeContents(inFile, entry.Name, entry.Source, entry.Mode, records, signatures); err != nil {
+		return err
+	}
+	arc.recordOrigin(entry.Name, entry.Origin)
+	return nil
+}
+
+// isDir checks if a path is a directory or a file. On error, a file is assumed.
+// Symbolic links are not followed, so a link given as path is handled by the symlink policy like any other link.
+func isDir(name string) bool {
+	fi, err := os.Lstat(name)
+	if err != nil {
 		return false
 	}
 	return fi.IsDir()
 }
 
-// addFiles adds files to the WriteArchive providing FileSignatures for verification
-func (arc *WriteArchive) addFiles(files []string, signatures *FileSignatures) (err error) {
+// ResolvedFile is a file found on disk and the name it is stored as inside the archive
+type ResolvedFile struct {
+	Path string
+	Name string
+	// Link is the target of the file if it is a symbolic link, empty otherwise
+	Link string
+}
+
+// ResolveFiles expands file paths, directories and globs to the list of files to be added to an archive
+func ResolveFiles(files []string) (resolved []ResolvedFile, err error) {
 	var globs []string
 	var parent, abs, innerGlob string
-	var inFile *os.File
 	for _, glob := range files {
 		if abs, err = filepath.Abs(glob); err != nil {
-			return fmt.Errorf("invalid path '%s': %v", glob, err)
+			return nil, fmt.Errorf("invalid path '%s': %v", glob, err)
 		}
 		innerGlob = abs
 		if isDir(abs) {
-			innerGlob += "/*"
+			innerGlob = filepath.Join(abs, "*")
 		}
 		globs, err = filepath.Glob(innerGlob)
 		if err != nil {
-			return fmt.Errorf("invalid file glob: %v", err)
+			return nil, fmt.Errorf("invalid file glob: %v", err)
 		}
 		parent = filepath.Dir(abs)
 		for _, content := range globs {
-			inFile, err = os.Open(content)
-			if err != nil {
-				return fmt.Errorf("failed reading file: %v", err)
+			var rel string
+			if rel, err = filepath.Rel(parent, content); err != nil {
+				return nil, fmt.Errorf("invalid path '%s': %v", content, err)
 			}
-			content = strings.TrimPrefix(content, parent+"/")
-			if err = arc.storeContents(inFile, content, signatures); err != nil {
-				return
+			// Archive names always use slashes, independent of the platform sealing
+			file := ResolvedFile{
+				Path: content,
+				Name: filepath.ToSlash(rel),
 			}
+			if info, err := os.Lstat(content); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if file.Link, err = os.Readlink(content); err != nil {
+					return nil, fmt.Errorf("invalid symbolic link '%s': %v", content, err)
+				}
+			}
+			resolved = append(resolved, file)
 		}
 	}
-	return
+	return resolved, nil
 }
 
 // storeContents adds an io.Reader and a filename to add a signature and the contents to the archive.
-func (arc *WriteArchive) storeContents(inFile *os.File, filename string, signatures *FileSignatures) error {
-	var err error
+// The source describes where the contents originate from and is only used for reporting.
+func (arc *WriteArchive) storeContents(inFile *os.File, filename, source string, mode os.FileMode, records map[string]string, signatures *FileSignatures) (err error) {
+	_, end := StartPhase(arc.Context, PhaseCompress, attribute.String("name", filename))
+	defer func() { end(err) }()
+	if arc.sources == nil {
+		arc.sources = map[string]string{}
+	}
+	arc.sources[filename] = source
+	entry := NewReportEntry(filename)
+	entry.Source = source
 	if _, err = inFile.Seek(0, 0); err != nil {
 		return err
 	}
 	if err = signatures.AddFileFromReader(filename, inFile); err != nil {
 		return fmt.Errorf("failed hashing image: %v", err)
 	}
-	if err = arc.WriteToArchive(filename, inFile); err != nil {
+	if err = arc.writeFile(filename, inFile, mode, records); err != nil {
 		return fmt.Errorf("failed adding image to archive: %v", err)
 	}
+	if info, err := inFile.Stat(); err == nil {
+		entry.Size = info.Size()
+	}
 	if err = inFile.Close(); err != nil {
 		return err
 	}
+	arc.finishEntry(entry, signatures)
 	return nil
 }
 
@@ -462,7 +1038,11 @@ func (arc *WriteArchive) storeContents(inFile *os.File, filename string, signatu
 func (arc *WriteArchive) AddToc(privateKeyPath string, signatures *FileSignatures) (err error) {
 	// Create Signer according to configuration
 	var signer signature.Signer
-	signer, err = CreateSigner(privateKeyPath)
+	signatureHash, err := ParseSignatureHash(arc.SignatureHash)
+	if err != nil {
+		return err
+	}
+	signer, err = CreateSignerWithHash(privateKeyPath, signatureHash)
 	if err != nil {
 		return fmt.Errorf("seal: could not create signer: %v", err)
 	}
@@ -474,27 +1054,53 @@ func (arc *WriteArchive) AddToc(privateKeyPath string, signatures *FileSignature
 	if err != nil {
 		return fmt.Errorf("seal: failed signing TOC: %v", err)
 	}
-	if err = arc.AddToArchive(TocFileName+".sig", tocSignature); err != nil {
+	// The hash is recorded, so the signature can be verified without configuring it on unseal
+	records := map[string]string{paxSignatureHash: signatureHash}
+	if err = arc.addBytes(TocFileName+".sig", tocSignature, records); err != nil {
 		return fmt.Errorf("seal: failed adding TOC signature to archive: %v", err)
 	}
+	arc.toc, arc.tocSignature, arc.tocSignatureHash = signatures.Bytes(), tocSignature, signatureHash
 	return
 }
 
 // InitializeCompression creates a compression writer based on selected algorithm
+// gzip and zstd use parallel implementations unless a single thread is requested.
 func (arc *WriteArchive) InitializeCompression(w io.WriteCloser, compressionAlgo uint8) {
+	level := arc.compression.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	threads := arc.compression.Threads
+	if threads == 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
 	switch compressionAlgo {
 	case 1: // zlib
-		arc.compressWriter = zlib.NewWriter(w)
+		arc.compressWriter, _ = zlib.NewWriterLevel(w, level)
 		break
 	case 2: // zip
 		log.Warnf("ZIP writer currently not implemented")
-		arc.compressWriter = w
+		// Finalize closes the encryption writer itself, closing it twice corrupts the payload
+		arc.compressWriter = payloadWriter{w}
 		break
 	case 3: // flate
-		arc.compressWriter, _ = flate.NewWriter(w, flate.DefaultCompression)
+		arc.compressWriter, _ = flate.NewWriter(w, level)
+		break
+	case 4: // zstd
+		zstdLevel := zstd.SpeedDefault
+		if arc.compression.Level > 0 {
+			zstdLevel = zstd.EncoderLevelFromZstd(arc.compression.Level)
+		}
+		arc.compressWriter, _ = zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel), zstd.WithEncoderConcurrency(threads))
 		break
 	default: // gzip
-		arc.compressWriter = gzip.NewWriter(w)
+		if threads == 1 {
+			arc.compressWriter, _ = gzip.NewWriterLevel(w, level)
+			break
+		}
+		pgz, _ := pgzip.NewWriterLevel(w, level)
+		_ = pgz.SetConcurrency(1<<20, 2*threads)
+		arc.compressWriter = pgz
 		break
 	}
 }
@@ -507,16 +1113,73 @@ type ReadArchive struct {
 	compressReader io.Reader
 	TarReader      *tar.Reader
 	reader         io.Reader
+	Report         *Report
+	// Events receive the entries extracted and the verification result, if set
+	Events        Events
+	DryRun        bool
+	Parallel      int
+	ImageFallback bool
+	ImagePolicy   *ImagePolicy
+	// Limits restrict the entries extracted by Unpack
+	Limits UnpackLimits
+	// Context carries the trace of the operation the archive is read in, may be nil
+	Context context.Context
+	// Logger receives the log messages of the archive, the global logger if nil
+	Logger log.Interface
+	// Preallocate allocates large files before writing them, so they cannot run out of space midway
+	Preallocate bool
+	// Checksums collects the SHA-256 sums of all written files, if set
+	Checksums *ChecksumList
+	// ImageReferences lists all images imported by Unpack as registry/name:tag@digest
+	ImageReferences []string
+	// Metadata is read from packages declaring any; it is only verified once Unpack succeeded
+	Metadata *PackageMetadata
+	// Installed collects the verified TOC and the imported images, so the installation can be checked later, if set
+	Installed *TocFile
+	// Rollback captures the files and image tags replaced by Unpack, if set
+	Rollback *RollbackRecorder
+	// OnConflict decides how files already present in the output path are handled, overwriting them if empty
+	OnConflict ConflictPolicy
+	// Ownership maps the owner and permissions of created files and directories, if set
+	Ownership *Ownership
+	// Output collects all contents and images in a plain archive instead of extracting them, if set
+	Output *ArchiveOutput
+	// RollbackManifest is read from rollback bundles; it is only verified once Unpack succeeded
+	RollbackManifest *RollbackManifest
+	// RekorKey is the public key of a Rekor transparency log, which the TOC signature must be recorded in, if set
+	RekorKey    string
+	rekorBundle *RekorBundle
+	// ContinueOnError records entries that cannot be written or imported in Failures and continues with the others.
+	// Unpack still verifies the package and fails with ErrPartialImport if any entry failed.
+	ContinueOnError bool
+	// PreserveTimes sets the modification time of written files to the one recorded in the package
+	PreserveTimes bool
+	// OnlyNarrowModes keeps the bits of the mode of replaced files that are not recorded in the package instead of
+	// applying the recorded mode, so existing files are never widened
+	OnlyNarrowModes bool
+	// StrictEntries rejects all entries other than regular files instead of creating directories and skipping other types
+	StrictEntries bool
+	// Failures are the entries that could not be written or imported with ContinueOnError
+	Failures       []*EntryFailure
+	failuresMutex  sync.Mutex
+	workers        *extractWorkers
+	chunks         *chunkCache
+	layers         *chunkCache
+	assembling     *io.PipeReader
+	localImportErr error
+	imagesAsFiles  bool
+	bundles        map[string]*ImageSignatureBundle
+	reservedSpace  atomic.Int64
+	ownedDirs      map[string]bool
 }
 
 // OpenArchive opens a compressed tar archive for reading
 func OpenArchive(data []byte, compressionAlgo uint8) (arc *ReadArchive, err error) {
-	arc = &ReadArchive{
-		reader: bytes.NewReader(data),
-	}
+	arc = &ReadArchive{}
+	arc.reader, compressionAlgo = sniffCompression(bytes.NewReader(data), compressionAlgo, arc.logger())
 	err = arc.InitializeCompression(arc.reader, compressionAlgo)
 	if err != nil {
-		return nil, err
+		return nil, corruptEnvelope(fmt.Errorf("payload cannot be decompressed as %s: %w", GetCompressionAlgoName(compressionAlgo), err))
 	}
 	arc.TarReader = tar.NewReader(arc.compressReader)
 	return arc, nil
@@ -524,12 +1187,16 @@ func OpenArchive(data []byte, compressionAlgo uint8) (arc *ReadArchive, err erro
 
 // OpenArchiveReader opens a compressed tar archive for reading from a reader
 func OpenArchiveReader(r io.Reader, compressionAlgo uint8) (arc *ReadArchive, err error) {
-	arc = &ReadArchive{
-		reader: r,
-	}
+	return OpenArchiveReaderWithLogger(r, compressionAlgo, nil)
+}
+
+// OpenArchiveReaderWithLogger opens an archive like OpenArchiveReader, logging to the provided logger
+func OpenArchiveReaderWithLogger(r io.Reader, compressionAlgo uint8, logger log.Interface) (arc *ReadArchive, err error) {
+	arc = &ReadArchive{Logger: logger}
+	arc.reader, compressionAlgo = sniffCompression(r, compressionAlgo, arc.logger())
 	err = arc.InitializeCompression(arc.reader, compressionAlgo)
 	if err != nil {
-		return nil, err
+		return nil, corruptEnvelope(fmt.Errorf("payload cannot be decompressed as %s: %w", GetCompressionAlgoName(compressionAlgo), err))
 	}
 	arc.TarReader = tar.NewReader(arc.compressReader)
 	return arc, nil
@@ -537,11 +1204,36 @@ func OpenArchiveReader(r io.Reader, compressionAlgo uint8) (arc *ReadArchive, er
 
 func (arc *ReadArchive) Unpack(signingKeyPath, hashingAlgorithm, outputPath, namespace, targetRegistry string) (err error) {
 	var h *tar.Header
-	log.Debug("unseal: create verifier")
+	arc.logger().Debug("unseal: create verifier")
 	verifier, err := NewVerifier(signingKeyPath, hashingAlgorithm)
 	if err != nil {
 		return err
 	}
+	verifier.Logger = arc.Logger
+	if arc.RekorKey != "" {
+		verifier.Transparency = arc.verifyTransparency
+	}
+	defer arc.removeChunks()
+	defer func() {
+		// Imports interrupted before verification must not keep their contents leased
+		if err != nil {
+			verifier.abortLease()
+		}
+	}()
+	// Reading the umask may change it for a moment, so it is read before any files are written
+	umask()
+	outputPath = longOutputPath(outputPath)
+	if targetRegistry == LocalContainerRegistry && arc.Output == nil {
+		arc.probeLocalImport()
+	}
+	if arc.Parallel > 1 && !arc.DryRun && arc.Output == nil {
+		arc.workers = newExtractWorkers(arc, arc.Parallel)
+		defer func() {
+			_ = arc.workers.stop()
+			arc.workers = nil
+		}()
+	}
+	limits := &limitCounter{limits: arc.Limits}
 	for {
 		h, err = arc.TarReader.Next()
 		if err == io.EOF {
@@ -550,97 +1242,549 @@ func (arc *ReadArchive) Unpack(signingKeyPath, hashingAlgorithm, outputPath, nam
 		if err != nil {
 			return err
 		}
+		if err = limits.add(h); err != nil {
+			return err
+		}
 		switch h.Typeflag {
 		case tar.TypeReg:
 			if err = arc.extract(outputPath, namespace, targetRegistry, h, verifier); err != nil {
 				return err
 			}
 		default:
-			return fmt.Errorf("unknown type: %b in %s", h.Typeflag, h.Name)
+			if err = arc.unpackOther(outputPath, h); err != nil {
+				return err
+			}
+		}
+	}
+	if arc.workers != nil {
+		if err = arc.workers.stop(); err != nil {
+			return err
+		}
+		arc.workers.merge(verifier.Signatures)
+	}
+	arc.logger().Debug("unseal: verifying contents signature")
+	if arc.Installed != nil && verifier.toc != nil && verifier.tocSignature != nil {
+		// Verifying consumes the buffers
+		arc.Installed.toc, arc.Installed.signature = bytes.Clone(verifier.toc.Bytes()), bytes.Clone(verifier.tocSignature.Bytes())
+		arc.Installed.signatureHash, _ = ParseSignatureHash(verifier.sigHash)
+		arc.Installed.hashingAlgorithm = hashingAlgorithm
+	}
+	_, end := StartPhase(arc.Context, PhaseVerify)
+	if arc.DryRun || arc.Output != nil {
+		// Nothing has been written to the output path, so there is nothing to roll back
+		err = verifier.Verify("", namespace, targetRegistry)
+	} else {
+		err = verifier.Verify(outputPath, namespace, targetRegistry)
+	}
+	end(err)
+	if arc.Events != nil {
+		arc.Events.OnVerificationResult(err)
+	}
+	if err != nil {
+		return err
+	}
+	return arc.failuresError()
+}
+
+// probeLocalImport checks whether images can be imported into a local containerD instance before anything is extracted.
+// If not, images are stored as OCI files if a fallback is allowed; otherwise importing them fails with a clear error.
+func (arc *ReadArchive) probeLocalImport() {
+	if arc.localImportErr = ProbeLocalImport(); arc.localImportErr == nil {
+		return
+	}
+	if ImageFallbackEnabled(arc.ImageFallback) {
+		arc.logger().Warnf("unseal: %v, images are stored as OCI files in the output path", arc.localImportErr)
+		arc.imagesAsFiles = true
+		return
+	}
+	arc.logger().Warnf("unseal: %v, unsealing fails if the package contains images", arc.localImportErr)
+}
+
+// Images reads all tar headers of the archive and provides those of container images, without extracting or
+// verifying any contents. It allows checking the images of a package before unpacking it in a second pass.
+func (arc *ReadArchive) Images() ([]*tar.Header, error) {
+	headers, err := arc.List()
+	if err != nil {
+		return nil, err
+	}
+	var images []*tar.Header
+	for _, h := range headers {
+		if strings.HasPrefix(h.Name, ContainerImagePrefix+"/") {
+			images = append(images, h)
 		}
 	}
-	log.Debug("unseal: verifying contents signature")
-	return verifier.Verify(outputPath, namespace, targetRegistry)
+	return images, nil
+}
+
+// List reads all tar headers of the archive without extracting any contents.
+// Sealpack-internal files like the TOC are omitted.
+func (arc *ReadArchive) List() (headers []*tar.Header, err error) {
+	var h *tar.Header
+	for {
+		h, err = arc.TarReader.Next()
+		if err == io.EOF {
+			return headers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(h.Name, TocFileName) || h.Name == ProvenanceFileName || h.Name == MetadataFileName || h.Name == RollbackFileName ||
+			h.Name == OriginsFileName || h.Name == RekorFileName || isLayerEntry(h) {
+			continue
+		}
+		headers = append(headers, h)
+	}
+}
+
+// ListString formats a list of tar headers as human-readable list of files and images
+func ListString(headers []*tar.Header) string {
+	var total int64
+	sb := strings.Builder{}
+	sb.WriteString("Package contains:\n")
+	for _, h := range headers {
+		if strings.HasPrefix(h.Name, ContainerImagePrefix) {
+			sb.WriteString(fmt.Sprintf("\timage %s (%d Bytes)\n", strings.TrimSuffix(strings.TrimPrefix(h.Name, ContainerImagePrefix+"/"), OCISuffix), contentSize(h)))
+		} else if h.Typeflag == tar.TypeSymlink {
+			sb.WriteString(fmt.Sprintf("\tlink  %s -> %s\n", h.Name, h.Linkname))
+		} else {
+			sb.WriteString(fmt.Sprintf("\tfile  %s (%d Bytes)\n", h.Name, contentSize(h)))
+		}
+		total += contentSize(h)
+	}
+	sb.WriteString(fmt.Sprintf("\t%d entries, %d Bytes uncompressed\n", len(headers), total))
+	return sb.String()
 }
 
 func (arc *ReadArchive) extract(outputPath, namespace, targetRegistry string, h *tar.Header, v *Verifier) (err error) {
-	fullFile := filepath.Join(outputPath, h.Name)
-	if !strings.HasPrefix(h.Name, ContainerImagePrefix) { // Skip creation of folder for images
+	// Entries are written before the TOC is verified, so names must not lead out of the output path
+	if !filepath.IsLocal(localFileName(h.Name)) {
+		return corruptEnvelope(fmt.Errorf("invalid entry name %s leading out of the output path", h.Name))
+	}
+	fullFile := filepath.Join(outputPath, localFileName(h.Name))
+	if !arc.DryRun && arc.Output == nil && !strings.HasPrefix(h.Name, ContainerImagePrefix) && !strings.HasPrefix(h.Name, ImageSignaturePrefix) && h.Name != ProvenanceFileName && h.Name != OriginsFileName && h.Name != RekorFileName && !isLayerEntry(h) { // Skip creation of folder for images
 		if err = os.MkdirAll(filepath.Dir(fullFile), 0755); err != nil {
 			return fmt.Errorf("creating archive for %s failed: %s", fullFile, err.Error())
 		}
+		if err = arc.applyDirectories(outputPath, fullFile); err != nil {
+			return err
+		}
 	}
-	if !strings.HasPrefix(h.Name, TocFileName) {
-		err = arc.extractContentFile(namespace, targetRegistry, h, fullFile, v)
-	} else {
+	switch {
+	case strings.HasPrefix(h.Name, TocFileName):
 		err = v.AddTocComponent(h, arc.TarReader)
+	case strings.HasPrefix(h.Name, ImageSignaturePrefix):
+		err = arc.addSignatureBundle(h, v)
+	case h.Name == ProvenanceFileName:
+		// The provenance is signed on its own and only read by inspect
+	case h.Name == RekorFileName:
+		err = arc.readRekorBundle()
+	case h.Name == MetadataFileName:
+		err = arc.readMetadata(h, v)
+	case h.Name == RollbackFileName:
+		err = arc.readRollbackManifest(h, v)
+	case h.Name == OriginsFileName:
+		err = arc.readOrigins(h, v)
+	case isLayerEntry(h):
+		err = arc.cacheLayer(h)
+	case arc.DryRun:
+		err = arc.planContentFile(namespace, targetRegistry, h, fullFile, v)
+	case arc.workers != nil && arc.workers.accepts(h):
+		err = arc.workers.submit(h, fullFile)
+	default:
+		err = arc.extractContentFile(namespace, targetRegistry, h, fullFile, v)
 	}
 	return err
 }
 
-// extractContentFile reads a single file from sealed archive and stores as local file or container image
-func (arc *ReadArchive) extractContentFile(namespace, targetRegistry string, h *tar.Header, fullFile string, verify *Verifier) (err error) {
-	// Use pipe to parallel read body and create signature
-	buf, bufW := io.Pipe()
-	errCh := make(chan error, 1)
-	go func() {
-		reader := io.TeeReader(arc.TarReader, bufW)
-		// If file: persist, if image: import
-		if strings.HasPrefix(h.Name, ContainerImagePrefix) {
-			err = arc.storeImage(namespace, targetRegistry, h, reader, verify)
+// planContentFile only hashes a single file from sealed archive and logs where it would be stored or imported to
+func (arc *ReadArchive) planContentFile(namespace, targetRegistry string, h *tar.Header, fullFile string, verify *Verifier) (err error) {
+	entry := NewReportEntry(h.Name)
+	entry.Size = contentSize(h)
+	entry.Destination = fullFile
+	if strings.HasPrefix(h.Name, ContainerImagePrefix) {
+		entry.Destination = targetRegistry
+		if targetRegistry == LocalContainerRegistry {
+			entry.Destination = "containerd://" + namespace
+			if arc.imagesAsFiles {
+				entry.Destination = fullFile
+			}
+		}
+		arc.logger().Infof("unseal: would import %s (%d Bytes) into %s", strings.TrimPrefix(h.Name, ContainerImagePrefix+"/"), entry.Size, entry.Destination)
+	} else {
+		arc.logger().Infof("unseal: would write %s (%d Bytes)", fullFile, entry.Size)
+		if _, err = os.Lstat(fullFile); err == nil && arc.OnConflict != "" && arc.OnConflict != ConflictOverwrite {
+			arc.logger().Infof("unseal: %s exists already and would be handled by the %s policy", fullFile, arc.OnConflict)
+		}
+	}
+	contents, err := arc.contentReader(h)
+	if err != nil {
+		return err
+	}
+	if arc.ImagePolicy != nil && strings.HasPrefix(h.Name, ContainerImagePrefix) {
+		// Verifying images against the policy needs a temporary copy, but nothing is imported
+		err = verify.Signatures.AddFileWhileReading(h.Name, contents, func(r io.Reader) error {
+			spool, err := arc.verifyImage(h, r)
 			if err != nil {
-				errCh <- err
+				return err
 			}
-		} else {
-			if err = arc.storeFile(h, reader, fullFile); err != nil {
-				errCh <- err
+			return removeTempFile(spool)
+		})
+	} else {
+		err = verify.Signatures.AddFileFromReader(h.Name, contents)
+	}
+	if err != nil {
+		return err
+	}
+	arc.finishEntry(entry, verify.Signatures)
+	return nil
+}
+
+// extractContentFile reads a single file from sealed archive and stores as local file or container image.
+// The contents are hashed while being stored, so every byte is only read once.
+func (arc *ReadArchive) extractContentFile(namespace, targetRegistry string, h *tar.Header, fullFile string, verify *Verifier) (err error) {
+	entry := NewReportEntry(h.Name)
+	entry.Size = contentSize(h)
+	contents, err := arc.contentReader(h)
+	if err != nil {
+		return err
+	}
+	err = verify.Signatures.AddFileWhileReading(h.Name, contents, func(reader io.Reader) error {
+		return arc.tolerate(entry, arc.storeContent(namespace, targetRegistry, h, reader, fullFile, verify, entry))
+	})
+	arc.finishEntry(entry, verify.Signatures)
+	return
+}
+
+// storeContent writes a single file to the output or the output path, or imports an image
+func (arc *ReadArchive) storeContent(namespace, targetRegistry string, h *tar.Header, r io.Reader, fullFile string, verify *Verifier, entry *ReportEntry) error {
+	if arc.Output != nil {
+		return arc.writeOutput(h, r, entry)
+	}
+	// If file: persist, if image: import
+	if strings.HasPrefix(h.Name, ContainerImagePrefix) {
+		return arc.storeImage(namespace, targetRegistry, h, r, fullFile, verify, entry)
+	}
+	entry.Destination = fullFile
+	return arc.storeFile(h, r, fullFile)
+}
+
+// copyBufferSize is the size of buffers used for copying contents
+const copyBufferSize = 64 * 1024
+
+// copyBuffers are reused for copying contents, avoiding an allocation per extracted file
+var copyBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// contentReader reads the real contents of the current archive entry, expanding packed sparse files
+// and assembling chunked files
+func (arc *ReadArchive) contentReader(h *tar.Header) (io.Reader, error) {
+	if isSparseEntry(h) {
+		return newExpandedReader(h, arc.TarReader)
+	}
+	if isLayeredEntry(h) {
+		return arc.newLayeredReader(h)
+	}
+	if isChunkedEntry(h) {
+		if arc.chunks == nil {
+			var err error
+			if arc.chunks, err = newChunkCache(); err != nil {
+				return nil, err
 			}
 		}
-		defer func() {
-			errCh <- bufW.Close()
-		}()
-	}()
-	if err = verify.Signatures.AddFileFromReader(h.Name, buf); err != nil {
-		return
+		return newChunkedReader(h, arc.TarReader, arc.chunks)
+	}
+	return arc.TarReader, nil
+}
+
+// removeChunks deletes the chunks and layers cached while reading chunked and layered entries
+func (arc *ReadArchive) removeChunks() {
+	if arc.chunks != nil {
+		_ = arc.chunks.remove()
+		arc.chunks = nil
+	}
+	arc.closeLayered()
+	if arc.layers != nil {
+		_ = arc.layers.remove()
+		arc.layers = nil
 	}
-	err = <-errCh
-	return
 }
 
 // storeFile creates a file with a specified name and copies contents from a Reader to it
+// Sparse files are checked with their packed size, as holes do not take up space.
 func (arc *ReadArchive) storeFile(h *tar.Header, r io.Reader, fullFile string) (err error) {
-	f, err := os.Create(fullFile)
+	if arc.Rollback != nil && !strings.HasPrefix(h.Name, ContainerImagePrefix) {
+		// Captured before a backup moves the file aside
+		if err = arc.Rollback.captureFile(h.Name, fullFile); err != nil {
+			return fmt.Errorf("cannot capture %s for rollback: %w", fullFile, err)
+		}
+	}
+	keep, err := arc.resolveConflict(fullFile)
+	if err != nil {
+		return err
+	}
+	if keep {
+		return skipFile(r)
+	}
+	// Replaced files keep their mode when truncated; with OnlyNarrowModes, the recorded mode may only narrow it
+	existingMode := os.ModePerm
+	if info, err := os.Lstat(fullFile); err == nil && arc.OnlyNarrowModes {
+		existingMode = info.Mode().Perm()
+	}
+	size := diskSize(h)
+	release, err := arc.reserveSpace(fullFile, size)
 	if err != nil {
 		return err
 	}
-	if bts, err := io.Copy(f, r); err != nil {
-		log.Errorf("unseal: EOF after %d bytes of %d\n", bts, h.Size)
+	defer release()
+	f, err := os.Create(fullFile)
+	if err != nil {
 		return err
 	}
+	if arc.Preallocate && !isSparseEntry(h) && size >= preallocateMinSize {
+		if err = preallocate(f, size); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("cannot preallocate %d bytes for %s: %w", size, fullFile, err)
+		}
+	}
+	var sum hash.Hash
+	if arc.Checksums != nil {
+		sum = sha256.New()
+		r = io.TeeReader(r, sum)
+	}
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	var bts int64
+	if isSparseEntry(h) {
+		bts, err = writeSparse(f, r, *buf)
+	} else {
+		// Hide io.ReaderFrom of the file, which would allocate its own buffer
+		bts, err = io.CopyBuffer(struct{ io.Writer }{f}, r, *buf)
+	}
+	if err != nil {
+		_ = f.Close()
+		arc.logger().Errorf("unseal: EOF after %d bytes of %d", bts, contentSize(h))
+		return fileSizeError(fullFile, err)
+	}
 	if err = f.Sync(); err != nil {
 		return err
 	}
 	if err = f.Close(); err != nil {
 		return err
 	}
+	if (arc.Ownership == nil || !arc.Ownership.Chmod) && modeRecorded(h) {
+		mode := fileMode(h) & existingMode
+		if arc.RollbackManifest != nil {
+			// Rollback bundles restore the exact mode a file had before it was replaced
+			mode = os.FileMode(h.Mode).Perm()
+		}
+		if err = os.Chmod(fullFile, mode); err != nil {
+			return err
+		}
+	}
+	if arc.Ownership != nil {
+		if err = arc.Ownership.apply(fullFile, os.FileMode(h.Mode)); err != nil {
+			return fmt.Errorf("cannot set ownership of %s: %w", fullFile, err)
+		}
+	}
+	if arc.PreserveTimes {
+		if err = os.Chtimes(fullFile, time.Time{}, h.ModTime); err != nil {
+			return fmt.Errorf("cannot set modification time of %s: %w", fullFile, err)
+		}
+	}
+	if sum != nil {
+		arc.Checksums.add(h.Name, sum.Sum(nil))
+	}
+	return nil
+}
+
+// umask is the file mode creation mask of the process, read once before the first file is unpacked
+var umask = sync.OnceValue(readUmask)
+
+// modeRecorded checks whether the permission bits of an entry were taken from its source.
+// Older packages store 0755 for all files added from disk, so their modes are not applied.
+func modeRecorded(h *tar.Header) bool {
+	_, ok := h.PAXRecords[paxModeRecorded]
+	return ok
+}
+
+// fileMode is the mode of an unpacked file: the permission bits recorded in the package, 0666 if there are none, without the umask
+func fileMode(h *tar.Header) os.FileMode {
+	mode := os.FileMode(h.Mode).Perm()
+	if mode == 0 {
+		mode = 0666
+	}
+	return mode &^ umask()
+}
+
+// fileSizeError explains errors caused by files exceeding the maximum file size of a filesystem
+func fileSizeError(name string, err error) error {
+	if errors.Is(err, syscall.EFBIG) {
+		return fmt.Errorf("%s exceeds the maximum file size of the filesystem (temporary files are stored in %s): %w", name, os.TempDir(), err)
+	}
+	return err
+}
+
+// addSignatureBundle reads the cosign signatures of an image, so the image can be verified against the ImagePolicy
+func (arc *ReadArchive) addSignatureBundle(h *tar.Header, verify *Verifier) error {
+	bundle := &ImageSignatureBundle{}
+	err := verify.Signatures.AddFileWhileReading(h.Name, arc.TarReader, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(bundle)
+	})
+	if err != nil {
+		return fmt.Errorf("invalid signature bundle %s: %w", h.Name, err)
+	}
+	if arc.bundles == nil {
+		arc.bundles = map[string]*ImageSignatureBundle{}
+	}
+	ref := strings.TrimSuffix(strings.TrimPrefix(h.Name, ImageSignaturePrefix+"/"), SignatureBundleSuffix)
+	arc.bundles[ref] = bundle
 	return nil
 }
 
+// verifyImage spools an image to a temporary file and verifies it against the ImagePolicy.
+// The returned file must be removed after use.
+func (arc *ReadArchive) verifyImage(h *tar.Header, r io.Reader) (*os.File, error) {
+	spool, err := createTempFile("sealpack-verify-*" + OCISuffix)
+	if err != nil {
+		return nil, err
+	}
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	if _, err = io.CopyBuffer(struct{ io.Writer }{spool}, r, *buf); err == nil {
+		img := ParseContainerImage(strings.TrimPrefix(h.Name, ContainerImagePrefix+"/"))
+		err = arc.ImagePolicy.Verify(img, arc.bundles[img.String()], spool)
+	}
+	if err != nil {
+		_ = removeTempFile(spool)
+		return nil, err
+	}
+	return spool, nil
+}
+
 // storeImage imports a binary image from a Reader into a registry specified by a Tag
-func (arc *ReadArchive) storeImage(namespace, targetRegistry string, h *tar.Header, r io.Reader, v *Verifier) (err error) {
+// If no local containerD instance is available and the fallback is enabled, the image is stored as OCI file in fullFile instead.
+func (arc *ReadArchive) storeImage(namespace, targetRegistry string, h *tar.Header, r io.Reader, fullFile string, v *Verifier, entry *ReportEntry) (err error) {
+	_, end := StartPhase(arc.Context, PhaseImport, attribute.String("image", h.Name))
+	defer func() { end(err) }()
+	if targetRegistry == LocalContainerRegistry && arc.localImportErr != nil && !arc.imagesAsFiles {
+		entry.ImportStatus = ImportStatusFailed
+		return fmt.Errorf("%w: cannot import %s: %w; enable the image fallback to store images as OCI files or provide a target registry", ErrPartialImport, h.Name, arc.localImportErr)
+	}
+	if arc.ImagePolicy != nil {
+		spool, err := arc.verifyImage(h, r)
+		if err != nil {
+			entry.ImportStatus = ImportStatusFailed
+			return err
+		}
+		defer func() { _ = removeTempFile(spool) }()
+		r = spool
+	}
+	if targetRegistry == LocalContainerRegistry && arc.imagesAsFiles {
+		arc.logger().Infof("unseal: storing image %s as %s", h.Name, fullFile)
+		if err = os.MkdirAll(filepath.Dir(fullFile), 0755); err != nil {
+			return err
+		}
+		entry.Destination = fullFile
+		entry.ImportStatus = ImportStatusStored
+		return arc.storeFile(h, r, fullFile)
+	}
 	var tag name.Tag
-	if tag, err = name.NewTag(strings.TrimPrefix(h.Name, ContainerImagePrefix+"/")); err != nil {
+	if tag, err = ParseContainerImage(strings.TrimPrefix(h.Name, ContainerImagePrefix+"/")).ImportTag(); err != nil {
 		return err
 	}
 	// If everything matches, reimport images if target registry has been provided
+	var lease *ImportLease
+	if targetRegistry == LocalContainerRegistry {
+		if lease, err = v.importLease(namespace); err != nil {
+			entry.ImportStatus = ImportStatusFailed
+			return fmt.Errorf("%w: importing %s failed: %w", ErrPartialImport, tag.Name(), err)
+		}
+	}
+	if arc.Rollback != nil {
+		if err = arc.Rollback.captureImage(namespace, targetRegistry, tag); err != nil {
+			entry.ImportStatus = ImportStatusFailed
+			return fmt.Errorf("cannot capture %s for rollback: %w", tag.Name(), err)
+		}
+	}
 	var wasImported bool
-	if wasImported, err = ImportImage(namespace, targetRegistry, io.NopCloser(r), &tag); wasImported {
+	var digest string
+	digest, wasImported, err = ImportImage(namespace, targetRegistry, io.NopCloser(r), &tag, lease)
+	entry.Destination = tag.Name()
+	if targetRegistry == LocalContainerRegistry {
+		entry.Destination = "containerd://" + namespace + "/" + tag.Name()
+	}
+	if err == nil {
+		entry.Reference = tag.Name() + "@" + digest
+		arc.ImageReferences = append(arc.ImageReferences, entry.Reference)
+		if arc.Installed != nil {
+			installed := InstalledImage{Reference: entry.Reference}
+			if targetRegistry == LocalContainerRegistry {
+				installed.Namespace = namespace
+			}
+			arc.Installed.Images[h.Name] = installed
+		}
+	}
+	switch {
+	case wasImported:
+		entry.ImportStatus = ImportStatusImported
 		v.AddUnsafeTag(&tag)
 		return nil
+	case err != nil:
+		entry.ImportStatus = ImportStatusFailed
+		return fmt.Errorf("%w: importing %s failed: %w", ErrPartialImport, tag.Name(), err)
+	default:
+		entry.ImportStatus = ImportStatusUnchanged
 	}
 	return err
 }
 
+// tarMagicOffset is the position of the "ustar" magic in a tar header
+const tarMagicOffset = 257
+
+// DetectCompression identifies the compression algorithm of a payload by its first bytes.
+// Uncompressed tar archives are detected as zip, which is stored uncompressed;
+// flate streams have no magic bytes and cannot be detected.
+// The tar magic is checked first, as the two bytes identifying zlib also begin many entry names.
+func DetectCompression(head []byte) (uint8, bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return GetCompressionAlgoIndex(CompressionGzip), true
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return GetCompressionAlgoIndex(CompressionZstd), true
+	case len(head) >= tarMagicOffset+5 && string(head[tarMagicOffset:tarMagicOffset+5]) == "ustar":
+		return GetCompressionAlgoIndex(CompressionZip), true
+	case len(head) >= 2 && head[0]&0x0f == 8 && head[0]>>4 <= 7 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0:
+		return GetCompressionAlgoIndex(CompressionZlib), true
+	}
+	return 0, false
+}
+
+// sniffCompression detects the compression of a payload and cross-checks it against the algorithm of the header.
+// If they differ, the header byte is likely damaged, so the detected algorithm is used. The zlib header is too weak
+// a magic to override the header, one in 31 pairs of bytes matches it, so it is only warned about.
+func sniffCompression(r io.Reader, declared uint8, logger log.Interface) (io.Reader, uint8) {
+	buffered := bufio.NewReaderSize(r, 512)
+	head, _ := buffered.Peek(tarMagicOffset + 5)
+	detected, ok := DetectCompression(head)
+	switch {
+	case ok && detected != declared && detected == GetCompressionAlgoIndex(CompressionZlib):
+		logger.Warnf("payload may be zlib compressed, but the header declares %s; using %s",
+			GetCompressionAlgoName(declared), GetCompressionAlgoName(declared))
+	case ok && detected != declared:
+		logger.Warnf("payload is %s compressed, but the header declares %s; the header is probably damaged",
+			GetCompressionAlgoName(detected), GetCompressionAlgoName(declared))
+		return buffered, detected
+	case !ok && declared != GetCompressionAlgoIndex(CompressionFlate):
+		logger.Warnf("payload does not look %s compressed; the payload is probably damaged or encrypted with another key",
+			GetCompressionAlgoName(declared))
+	}
+	return buffered, declared
+}
+
 // InitializeCompression creates a compression writer based on selected algorithm
 func (arc *ReadArchive) InitializeCompression(r io.Reader, compressionAlgo uint8) (err error) {
 	switch compressionAlgo {
@@ -648,26 +1792,48 @@ func (arc *ReadArchive) InitializeCompression(r io.Reader, compressionAlgo uint8
 		arc.compressReader, err = zlib.NewReader(r)
 		break
 	case 2: // zip
-		log.Warnf("ZIP writer currently not implemented")
+		arc.logger().Warnf("ZIP writer currently not implemented")
 		arc.compressReader = r
 		break
 	case 3: // flate
 		arc.compressReader = flate.NewReader(r)
 		break
+	case 4: // zstd
+		arc.compressReader, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(runtime.GOMAXPROCS(0)))
+		break
 	default: // gzip
-		arc.compressReader, err = gzip.NewReader(r)
+		// pgzip decompresses ahead in the background, overlapping with decryption and extraction
+		arc.compressReader, err = pgzip.NewReader(r)
 		break
 	}
 	return
 }
 
+// Close stops the background decompression of the archive
+func (arc *ReadArchive) Close() error {
+	switch r := arc.compressReader.(type) {
+	case *zstd.Decoder:
+		r.Close()
+	case io.Closer:
+		return r.Close()
+	}
+	return nil
+}
+
 // BytesToTar adds a file to a writer using a filename and a byte slice with contents to be written.
 func BytesToTar(w *tar.Writer, filename *string, contents []byte) error {
+	return bytesToTar(w, *filename, contents, nil)
+}
+
+// bytesToTar adds a file with additional PAX records to a writer
+func bytesToTar(w *tar.Writer, filename string, contents []byte, records map[string]string) error {
 	var err error
 	if err = w.WriteHeader(&tar.Header{
-		Name: *filename,
-		Size: int64(len(contents)),
-		Mode: 0755,
+		Name:       filename,
+		Size:       int64(len(contents)),
+		Mode:       0755,
+		Format:     tar.FormatPAX,
+		PAXRecords: records,
 	}); err != nil {
 		return err
 	}
@@ -15,6 +15,7 @@ package internal
  */
 
 import (
+	"archive/tar"
 	"bytes"
 	"crypto"
 	"encoding/binary"
@@ -27,8 +28,10 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_OpenArchive(t *testing.T) {
@@ -79,9 +82,12 @@ func Test_Envelope(t *testing.T) {
 	// Test string
 	strs := strings.Split(envelope.String(), "\n")
 	assert.Contains(t, strs[0], "is a sealed package")
-	assert.Contains(t, strs[1], "0 Bytes")
-	assert.Contains(t, strs[2], "SHA-256 (32 Bit)")
-	assert.Contains(t, strs[3], "for 1 receivers")
+	assert.Contains(t, strs[1], "version 1")
+	assert.Contains(t, strs[2], "0 Bytes")
+	assert.Contains(t, strs[3], "using gzip")
+	assert.Contains(t, strs[4], "SHA-256 (32 Bit)")
+	assert.Contains(t, strs[5], "for 1 receivers")
+	assert.Contains(t, strs[6], "Key 1: 8 Bytes")
 
 	// Test envelope byte slice
 	bts := envelope.ToBytes()
@@ -171,7 +177,8 @@ func TestGetCompressionAlgoName(t *testing.T) {
 		{"ZLIB", 1, "zlib"},
 		{"ZIP", 2, "zip"},
 		{"FLATE", 3, "flate"},
-		{"INVALID", 4, "gzip"},
+		{"ZSTD", 4, "zstd"},
+		{"INVALID", 5, "gzip"},
 		{"INVALID", 99, "gzip"},
 	}
 
@@ -201,6 +208,7 @@ func TestGetCompressionAlgoIndex(t *testing.T) {
 		{"ZLIB", 1, "zlib"},
 		{"ZIP", 2, "zip"},
 		{"FLATE", 3, "flate"},
+		{"ZSTD", 4, "zstd"},
 		{"INVALID", 0, "dump"},
 		{"INVALID", 0, ""},
 	}
@@ -221,6 +229,14 @@ func TestGetCompressionAlgoIndex(t *testing.T) {
 	}
 }
 
+func TestParseCompressionAlgo(t *testing.T) {
+	idx, err := ParseCompressionAlgo("zstd")
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(4), idx)
+	_, err = ParseCompressionAlgo("gizp")
+	assert.ErrorContains(t, err, "unknown compression algorithm 'gizp', use one of gzip, zlib, zip, flate, zstd")
+}
+
 // Tests for Archive Reader
 
 func TestOpenArchiveReader(t *testing.T) {
@@ -243,6 +259,126 @@ func TestOpenArchiveReader(t *testing.T) {
 	assert.NoError(t, ra.Unpack("../test/public.pem", algo, "", "", ""))
 }
 
+func TestCompressionOptions_RoundTrip(t *testing.T) {
+	contents := bytes.Repeat([]byte("Hold your breath and count to 10. "), 100000)
+	tests := []struct {
+		name    string
+		algo    uint8
+		options CompressionOptions
+	}{
+		{"parallel gzip", 0, CompressionOptions{}},
+		{"single-threaded gzip", 0, CompressionOptions{Level: 9, Threads: 1}},
+		{"zlib level", 1, CompressionOptions{Level: 1}},
+		{"parallel zstd", 4, CompressionOptions{Threads: 4}},
+		{"zstd level", 4, CompressionOptions{Level: 19, Threads: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, tt.options.Validate(tt.algo))
+			sig := NewSignatureList("SHA256")
+			arc := CreateArchiveWriterWithOptions(true, tt.algo, tt.options, EnvelopeVersionLatest)
+			defer arc.Cleanup()
+			assert.NoError(t, arc.AddToArchive("foo", contents))
+			assert.NoError(t, sig.AddFile("foo", contents))
+			assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+			size, err := arc.Finalize()
+			assert.NoError(t, err)
+			assert.Less(t, size, int64(len(contents)/10))
+
+			f, err := os.Open(arc.outFile.Name())
+			assert.NoError(t, err)
+			ra, err := OpenArchiveReader(f, tt.algo)
+			assert.NoError(t, err)
+			assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", t.TempDir(), "", ""))
+			assert.NoError(t, ra.Close())
+		})
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	for algo := uint8(0); algo < uint8(len(compressionAlgorithms)); algo++ {
+		t.Run(GetCompressionAlgoName(algo), func(t *testing.T) {
+			arc := CreateArchiveWriter(true, algo)
+			defer arc.Cleanup()
+			assert.NoError(t, arc.AddToArchive("foo", []byte("Hold your breath and count to 10.")))
+			_, err := arc.Finalize()
+			assert.NoError(t, err)
+			payload, err := os.ReadFile(arc.outFile.Name())
+			assert.NoError(t, err)
+
+			detected, ok := DetectCompression(payload)
+			if algo == GetCompressionAlgoIndex(CompressionFlate) {
+				assert.False(t, ok)
+				return
+			}
+			assert.True(t, ok)
+			assert.Equal(t, algo, detected)
+		})
+	}
+	_, ok := DetectCompression([]byte("no"))
+	assert.False(t, ok)
+}
+
+func TestDetectCompression_TarLookingLikeZlib(t *testing.T) {
+	// "80", "Xf" and "Hj" are valid zlib headers, but uncompressed tar payloads may start with them
+	for _, name := range []string{"80-data.bin", "Xfile", "Hjson"} {
+		t.Run(name, func(t *testing.T) {
+			arc := CreateArchiveWriter(true, GetCompressionAlgoIndex(CompressionZip))
+			defer arc.Cleanup()
+			sig := NewSignatureList("SHA256")
+			assert.NoError(t, arc.AddToArchive(name, []byte("contents")))
+			assert.NoError(t, sig.AddFile(name, []byte("contents")))
+			assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+			_, err := arc.Finalize()
+			assert.NoError(t, err)
+			payload, err := os.ReadFile(arc.outFile.Name())
+			assert.NoError(t, err)
+
+			detected, ok := DetectCompression(payload)
+			assert.True(t, ok)
+			assert.Equal(t, GetCompressionAlgoIndex(CompressionZip), detected)
+			ra, err := OpenArchive(payload, GetCompressionAlgoIndex(CompressionZip))
+			assert.NoError(t, err)
+			assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", t.TempDir(), "", ""))
+		})
+	}
+
+	// A weak zlib match never overrides the header
+	_, algo := sniffCompression(bytes.NewReader([]byte("Xf not compressed")), GetCompressionAlgoIndex(CompressionGzip), LoggerOrDefault(nil))
+	assert.Equal(t, GetCompressionAlgoIndex(CompressionGzip), algo)
+}
+
+func TestOpenArchiveReader_DamagedHeader(t *testing.T) {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, GetCompressionAlgoIndex(CompressionZstd))
+	defer arc.Cleanup()
+	assert.NoError(t, arc.AddToArchive("foo", []byte("Hold your breath and count to 10.")))
+	assert.NoError(t, sig.AddFile("foo", []byte("Hold your breath and count to 10.")))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+
+	// The header declares gzip, but the payload is detected as zstd
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	defer f.Close()
+	ra, err := OpenArchiveReader(f, GetCompressionAlgoIndex(CompressionGzip))
+	assert.NoError(t, err)
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", t.TempDir(), "", ""))
+
+	// Undetectable payloads are reported as corrupt
+	_, err = OpenArchive([]byte("definitely not compressed"), GetCompressionAlgoIndex(CompressionGzip))
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+	assert.ErrorContains(t, err, "payload cannot be decompressed as gzip")
+}
+
+func TestCompressionOptions_Validate(t *testing.T) {
+	assert.NoError(t, CompressionOptions{Level: 22}.Validate(4))
+	assert.ErrorContains(t, CompressionOptions{Level: 10}.Validate(0), "compression level of gzip must be between 1 and 9")
+	assert.ErrorContains(t, CompressionOptions{Level: -1}.Validate(4), "compression level of zstd must be between 1 and 22")
+	assert.ErrorContains(t, CompressionOptions{Threads: -1}.Validate(0), "threads must not be negative")
+}
+
 func TestReadArchive_InitializeCompression(t *testing.T) {
 	type args struct {
 		r               io.Reader
@@ -318,3 +454,229 @@ func TestReadArchive_InitializeCompression(t *testing.T) {
 		})
 	}
 }
+
+func TestReadArchive_List(t *testing.T) {
+	// Arrange
+	sig := NewSignatureList("SHA512")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	assert.NoError(t, arc.AddToArchive("path/to/foo", []byte("Hold your breath and count to 10.")))
+	assert.NoError(t, arc.AddToArchive(".images/docker.io/alpine:3.17.oci", []byte("not really an image")))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+
+	// Act
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	headers, err := ra.List()
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Len(t, headers, 2)
+	assert.Equal(t, "path/to/foo", headers[0].Name)
+	assert.Equal(t, int64(33), headers[0].Size)
+	list := ListString(headers)
+	assert.Contains(t, list, "file  path/to/foo (33 Bytes)")
+	assert.Contains(t, list, "image docker.io/alpine:3.17 (19 Bytes)")
+	assert.Contains(t, list, "2 entries, 52 Bytes")
+}
+
+func BenchmarkReadArchive_UnpackSmallFiles(b *testing.B) {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	contents := bytes.Repeat([]byte("x"), 512)
+	for i := 0; i < 2000; i++ {
+		name := fmt.Sprintf("files/%04d.txt", i)
+		assert.NoError(b, arc.AddToArchive(name, contents))
+		assert.NoError(b, sig.AddFile(name, contents))
+	}
+	assert.NoError(b, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(arc.outFile.Name())
+		assert.NoError(b, err)
+		ra, err := OpenArchiveReader(f, 0)
+		assert.NoError(b, err)
+		assert.NoError(b, ra.Unpack("../test/public.pem", "SHA256", b.TempDir(), "", ""))
+		_ = f.Close()
+	}
+}
+
+func TestReadArchive_UnpackEscapingNames(t *testing.T) {
+	for _, name := range []string{"../escape.txt", "a/../../escape.txt", "/etc/escape.txt"} {
+		base := t.TempDir()
+		arc, err := OpenArchiveReader(bytes.NewReader(tarSeed(name, tar.TypeReg, "outside")), GetCompressionAlgoIndex(CompressionZip))
+		assert.NoError(t, err)
+		err = arc.Unpack("../test/public.pem", "SHA256", filepath.Join(base, "out"), "", "")
+		assert.ErrorIs(t, err, ErrCorruptEnvelope, name)
+		_, err = os.Stat(filepath.Join(base, "escape.txt"))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+}
+
+func TestReadArchive_StoreFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no permission bits")
+	}
+	recorded := map[string]string{paxModeRecorded: "1"}
+	dir := t.TempDir()
+	mode := func(name string) os.FileMode {
+		info, err := os.Stat(filepath.Join(dir, name))
+		assert.NoError(t, err)
+		return info.Mode().Perm()
+	}
+	store := func(h *tar.Header, name string) {
+		h.Size = 3
+		assert.NoError(t, (&ReadArchive{}).storeFile(h, strings.NewReader("new"), filepath.Join(dir, name)))
+	}
+
+	// New files get the recorded mode without the umask
+	store(&tar.Header{Name: "run.sh", Mode: 0750, PAXRecords: recorded}, "run.sh")
+	assert.Equal(t, 0750&^umask(), mode("run.sh"))
+
+	// Existing files get the recorded mode, also if it is wider
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tool"), []byte("old"), 0644))
+	assert.NoError(t, os.Chmod(filepath.Join(dir, "tool"), 0644))
+	store(&tar.Header{Name: "tool", Mode: 0755, PAXRecords: recorded}, "tool")
+	assert.Equal(t, 0755&^umask(), mode("tool"))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "config"), []byte("old"), 0644))
+	assert.NoError(t, os.Chmod(filepath.Join(dir, "config"), 0644))
+	store(&tar.Header{Name: "config", Mode: 0600, PAXRecords: recorded}, "config")
+	assert.Equal(t, os.FileMode(0600), mode("config"))
+
+	// With OnlyNarrowModes, existing files are never widened, only narrowed
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "secret"), []byte("old"), 0600))
+	assert.NoError(t, (&ReadArchive{OnlyNarrowModes: true}).storeFile(&tar.Header{Name: "secret", Mode: 0755, Size: 3, PAXRecords: recorded},
+		strings.NewReader("new"), filepath.Join(dir, "secret")))
+	assert.Equal(t, os.FileMode(0600), mode("secret"))
+
+	// Modes of older packages are not recorded, existing files keep theirs and new ones get those of os.Create
+	store(&tar.Header{Name: "secret", Mode: 0755}, "secret")
+	assert.Equal(t, os.FileMode(0600), mode("secret"))
+	store(&tar.Header{Name: "data", Mode: 0755}, "data")
+	assert.Equal(t, 0666&^umask(), mode("data"))
+}
+
+func TestFileProvider_RecordsMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no permission bits")
+	}
+	src := filepath.Join(t.TempDir(), "secret")
+	assert.NoError(t, os.WriteFile(src, []byte("secret"), 0600))
+	assert.NoError(t, os.Chmod(src, 0600))
+	entries, err := FileProvider(src).Resolve()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, os.FileMode(0600), entries[0].Mode)
+
+	var buf bytes.Buffer
+	arc := &WriteArchive{tarWriter: tar.NewWriter(&buf)}
+	f, err := entries[0].Open()
+	assert.NoError(t, err)
+	defer f.Close()
+	assert.NoError(t, arc.writeFile("secret", f, entries[0].Mode, nil))
+	assert.NoError(t, arc.tarWriter.Close())
+	h, err := tar.NewReader(&buf).Next()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0600), h.Mode)
+	assert.True(t, modeRecorded(h))
+}
+
+func TestWriteArchive_RecordsImageDigest(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "image.oci")
+	assert.NoError(t, os.WriteFile(src, []byte("image contents"), 0644))
+	origin := &EntryOrigin{}
+	entry := ProvidedEntry{
+		Name:   ParseContainerImage("registry.example.com/app:1.0").ToFileName(),
+		Source: "registry.example.com/app:1.0",
+		Open: func() (*os.File, error) {
+			origin.ImageDigest = "sha256:aaa"
+			return os.Open(src)
+		},
+		Origin: origin,
+	}
+
+	var buf bytes.Buffer
+	arc := &WriteArchive{tarWriter: tar.NewWriter(&buf)}
+	assert.NoError(t, arc.addEntry(entry, NewSignatureList("SHA256")))
+	assert.NoError(t, arc.tarWriter.Close())
+	h, err := tar.NewReader(&buf).Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:aaa", h.PAXRecords[paxImageDigest])
+}
+
+func TestReadArchive_StoreFilePreserveTimes(t *testing.T) {
+	modified := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	h := &tar.Header{Name: "data.txt", Mode: 0644, Size: 3, ModTime: modified}
+	dir := t.TempDir()
+	assert.NoError(t, (&ReadArchive{PreserveTimes: true}).storeFile(h, strings.NewReader("abc"), filepath.Join(dir, "kept.txt")))
+	info, err := os.Stat(filepath.Join(dir, "kept.txt"))
+	assert.NoError(t, err)
+	assert.True(t, modified.Equal(info.ModTime()), info.ModTime())
+
+	assert.NoError(t, (&ReadArchive{}).storeFile(h, strings.NewReader("abc"), filepath.Join(dir, "now.txt")))
+	info, err = os.Stat(filepath.Join(dir, "now.txt"))
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+}
+
+// longNames are beyond the 100 characters of the tar name field and the 255 of the USTAR prefix split,
+// with each path component within the 255 bytes most file systems allow
+var longNames = []string{
+	strings.Repeat("n", 101),
+	strings.Repeat("directory-name-of-fifty-characters-for-long-paths/", 6) + "file.txt",
+	strings.Repeat("c", 250) + "/" + strings.Repeat("f", 100) + ".txt",
+}
+
+func TestReadArchive_UnpackLongNames(t *testing.T) {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	for _, name := range longNames {
+		assert.NoError(t, arc.AddToArchive(name, []byte("contents of "+name[:10])))
+		assert.NoError(t, sig.AddFile(name, []byte("contents of "+name[:10])))
+	}
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+
+	headers, err := openTestArchive(t, arc).List()
+	assert.NoError(t, err)
+	assert.Len(t, headers, len(longNames))
+	for i, h := range headers {
+		assert.Equal(t, longNames[i], h.Name)
+		// Long names are stored in PAX records, as tar implementations without them would truncate them to 100 characters
+		assert.NotZero(t, h.Format&tar.FormatPAX, h.Name)
+	}
+
+	out := t.TempDir()
+	assert.NoError(t, openTestArchive(t, arc).Unpack("../test/public.pem", "SHA256", out, "", ""))
+	for _, name := range longNames {
+		contents, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		assert.NoError(t, err, len(name))
+		assert.Equal(t, "contents of "+name[:10], string(contents))
+	}
+
+	// Plain tar output keeps the names as well
+	output := filepath.Join(t.TempDir(), "out.tar")
+	ra := openTestArchive(t, arc)
+	ra.Output, err = CreateArchiveOutput(output, OutputTar)
+	assert.NoError(t, err)
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", "", "", ""))
+	assert.NoError(t, ra.Output.Commit())
+	f, err := os.Open(output)
+	assert.NoError(t, err)
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for _, name := range longNames {
+		h, err := tr.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, name, h.Name)
+	}
+}
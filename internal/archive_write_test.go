@@ -15,10 +15,13 @@ package internal
  */
 
 import (
+	"archive/tar"
 	"bytes"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -66,6 +69,19 @@ func TestEnvelope_WriteHeader(t *testing.T) {
 			wantW:   "\xDBIPC\x4C\x39\x05\x00\x00\x00\x00\x00\x00",
 			wantErr: assert.NoError,
 		},
+		{
+			name: "Version 2 envelope with trailer",
+			buf:  &bytes.Buffer{},
+			fields: &Envelope{
+				Version:         EnvelopeVersion2,
+				Flags:           EnvelopeFlagTrailer,
+				PayloadLen:      1337,
+				CompressionAlgo: 2,
+				HashAlgorithm:   12,
+			},
+			wantW:   "\xDBIPV\x02\x4C\x01\x39\x05\x00\x00\x00\x00\x00\x00",
+			wantErr: assert.NoError,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -225,3 +241,96 @@ func Test_WriteOutput(t *testing.T) {
 	assert.NoError(t, envel.WriteOutput(f, arc))
 	assert.FileExists(t, "../test/tmp.out")
 }
+
+func TestAddContents_PinnedDigest(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "foo.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("foo"), 0644))
+	tests := []struct {
+		name    string
+		digests map[string]string
+		wantErr string
+	}{
+		{"matching digest", map[string]string{src: "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"}, ""},
+		{"tampered file", map[string]string{src: "sha256:0000000000000000000000000000000000000000000000000000000000000000"}, "digest mismatch"},
+		{"pinned file not added", map[string]string{src + ".missing": "sha256:00"}, "digests can only be pinned for single files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arc := CreateArchiveWriter(true, 0)
+			defer arc.Cleanup()
+			arc.FileDigests = tt.digests
+			err := arc.AddContents([]string{src}, nil, NewSignatureList("SHA256"))
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddContents_Duplicates(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "foo.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("foo"), 0644))
+
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	err := arc.AddContents([]string{src, filepath.Join(dir, "*.txt")}, nil, NewSignatureList("SHA256"))
+	assert.ErrorContains(t, err, "duplicate entry foo.txt")
+
+	arc = CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.Duplicates = DuplicatesSkip
+	arc.Report = NewReport("seal", "out", "SHA256")
+	assert.NoError(t, arc.AddContents([]string{src, filepath.Join(dir, "*.txt")}, nil, NewSignatureList("SHA256")))
+	assert.Len(t, arc.Report.Entries, 1)
+}
+
+func TestWriteToArchive_LongName(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "foo.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("foo"), 0644))
+	longName := strings.Repeat("directory/", 30) + strings.Repeat("x", 200) + ".txt"
+	var buf bytes.Buffer
+	arc := &WriteArchive{tarWriter: tar.NewWriter(&buf)}
+	f, err := os.Open(src)
+	assert.NoError(t, err)
+	defer f.Close()
+	assert.NoError(t, arc.WriteToArchive(longName, f))
+	assert.NoError(t, arc.tarWriter.Close())
+
+	h, err := tar.NewReader(&buf).Next()
+	assert.NoError(t, err)
+	assert.Equal(t, longName, h.Name)
+	assert.Equal(t, tar.FormatPAX, h.Format)
+}
+
+func TestWriteToArchive_LargeFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("copies more than 8 GiB")
+	}
+	src := filepath.Join(t.TempDir(), "large.bin")
+	f, err := os.Create(src)
+	assert.NoError(t, err)
+	defer f.Close()
+	// Sparse file larger than the 8 GiB limit of the USTAR format
+	size := int64(8<<30 + 1)
+	if err = f.Truncate(size); err != nil {
+		t.Skipf("filesystem does not support large sparse files: %v", err)
+	}
+	counter := &countingWriter{}
+	arc := &WriteArchive{tarWriter: tar.NewWriter(counter), NoSparse: true}
+	assert.NoError(t, arc.WriteToArchive("large.bin", f))
+	assert.NoError(t, arc.tarWriter.Close())
+	assert.Greater(t, counter.n, size)
+}
+
+// countingWriter discards all data but counts the bytes written
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,223 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"time"
+)
+
+// auditKeyMinLength is the minimum length of a key used for signing audit records
+const auditKeyMinLength = 32
+
+// auditTailSize is the number of bytes read from the end of an audit log to find the last record
+const auditTailSize = 64 * 1024
+
+// An AuditRecord documents a single seal or unseal operation in the audit log.
+// If the log is signed, every record carries an HMAC-SHA256 over its contents and the MAC of the previous record,
+// so changing, removing or reordering records is detected by VerifyAuditLog.
+type AuditRecord struct {
+	Time          time.Time `json:"time"`
+	Operation     string    `json:"operation"`
+	User          string    `json:"user"`
+	Host          string    `json:"host"`
+	Package       string    `json:"package"`
+	Digest        string    `json:"digest,omitempty"`
+	SigningKey    string    `json:"signing_key,omitempty"`
+	DecryptionKey string    `json:"decryption_key,omitempty"`
+	Recipients    []string  `json:"recipients,omitempty"`
+	FleetID       string    `json:"fleet_id,omitempty"`
+	DryRun        bool      `json:"dry_run,omitempty"`
+	Success       bool      `json:"success"`
+	Error         string    `json:"error,omitempty"`
+	PreviousMAC   string    `json:"previous_mac,omitempty"`
+	MAC           string    `json:"mac,omitempty"`
+}
+
+// NewAuditRecord starts a record of an operation on a package by the current user
+func NewAuditRecord(operation, pkg string) *AuditRecord {
+	record := &AuditRecord{
+		Time:      time.Now().UTC(),
+		Operation: operation,
+		Package:   pkg,
+	}
+	if u, err := user.Current(); err == nil {
+		record.User = u.Username
+	}
+	record.Host, _ = os.Hostname()
+	return record
+}
+
+// KeyFingerprint provides the fingerprint of a key file or AWS KMS key, or an empty string if it cannot be read
+func KeyFingerprint(path string) string {
+	if path == "" {
+		return ""
+	}
+	info, err := GetKeyInfo(path)
+	if err != nil {
+		return ""
+	}
+	return info.Fingerprint
+}
+
+// SetDigest records the SHA-256 digest of the envelope, if it has one
+func (r *AuditRecord) SetDigest(checksum []byte) {
+	if checksum != nil {
+		r.Digest = "sha256:" + hex.EncodeToString(checksum)
+	}
+}
+
+// Finish sets the result of the operation
+func (r *AuditRecord) Finish(err error) {
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// LoadAuditKey reads the key audit records are signed with from a file
+func LoadAuditKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < auditKeyMinLength {
+		return nil, fmt.Errorf("audit key must be at least %d bytes, got %d", auditKeyMinLength, len(key))
+	}
+	return key, nil
+}
+
+// sign calculates the MAC of the record, chained to the MAC of the previous record
+func (r *AuditRecord) sign(key []byte) (string, error) {
+	unsigned := *r
+	unsigned.MAC = ""
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// AppendAuditRecord appends a record as JSON line to an audit log, creating it if necessary.
+// If a key path is provided, the record is signed and chained to the last record of the log.
+// The log is locked exclusively while reading the last record and appending.
+func AppendAuditRecord(logPath, keyPath string, record *AuditRecord) error {
+	var key []byte
+	if keyPath != "" {
+		var err error
+		if key, err = LoadAuditKey(keyPath); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(logPath, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Concurrent appends must not chain to the same record
+	if err = lockExclusive(f); err != nil {
+		return fmt.Errorf("cannot lock audit log: %w", err)
+	}
+	if key != nil {
+		last, err := lastAuditRecord(f)
+		if err != nil {
+			return err
+		}
+		if last != nil {
+			record.PreviousMAC = last.MAC
+		}
+		if record.MAC, err = record.sign(key); err != nil {
+			return err
+		}
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// lastAuditRecord reads the last record of an audit log, or nil if it is empty
+func lastAuditRecord(f *os.File) (*AuditRecord, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := max(info.Size()-auditTailSize, 0)
+	tail := make([]byte, info.Size()-offset)
+	if _, err = f.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	lines := bytes.Split(bytes.TrimRight(tail, "\n"), []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		return nil, nil
+	}
+	record := &AuditRecord{}
+	if err = json.Unmarshal(lines[len(lines)-1], record); err != nil {
+		return nil, fmt.Errorf("cannot read last audit record: %v", err)
+	}
+	return record, nil
+}
+
+// VerifyAuditLog checks the MACs and the chain of all records in a signed audit log.
+// It returns the number of verified records or the first line that fails verification.
+func VerifyAuditLog(logPath, keyPath string) (int, error) {
+	key, err := LoadAuditKey(keyPath)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(logPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, auditTailSize), auditTailSize)
+	count := 0
+	previous := ""
+	for scanner.Scan() {
+		count++
+		record := &AuditRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return count - 1, fmt.Errorf("%w: audit record in line %d cannot be read: %v", ErrBadSignature, count, err)
+		}
+		if record.PreviousMAC != previous {
+			return count - 1, fmt.Errorf("%w: audit record in line %d does not follow the previous record", ErrBadSignature, count)
+		}
+		expected, err := record.sign(key)
+		if err != nil {
+			return count - 1, err
+		}
+		if !hmac.Equal([]byte(expected), []byte(record.MAC)) {
+			return count - 1, fmt.Errorf("%w: audit record in line %d has an invalid MAC", ErrBadSignature, count)
+		}
+		previous = record.MAC
+	}
+	return count, scanner.Err()
+}
@@ -0,0 +1,131 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeAuditKey writes an audit key to a temporary file
+func writeAuditKey(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "audit.key")
+	assert.NoError(t, os.WriteFile(path, []byte("0123456789abcdef0123456789abcdef"), 0600))
+	return path
+}
+
+// appendTestRecords appends a successful and a failed record to an audit log
+func appendTestRecords(t *testing.T, logPath, keyPath string) {
+	record := NewAuditRecord("seal", "out.ipc")
+	record.SigningKey = KeyFingerprint(filepath.Join(TestFilePath, "private.pem"))
+	record.SetDigest([]byte{0xAB, 0xCD})
+	record.Finish(nil)
+	assert.NoError(t, AppendAuditRecord(logPath, keyPath, record))
+	record = NewAuditRecord("unseal", "out.ipc")
+	record.Finish(errors.New("fnord"))
+	assert.NoError(t, AppendAuditRecord(logPath, keyPath, record))
+}
+
+func TestAppendAuditRecord(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	appendTestRecords(t, logPath, "")
+	data, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"operation":"seal"`)
+	assert.Contains(t, lines[0], `"digest":"sha256:abcd"`)
+	assert.Contains(t, lines[0], `"signing_key":"sha256:`)
+	assert.Contains(t, lines[0], `"success":true`)
+	assert.NotContains(t, lines[0], `"mac"`)
+	assert.Contains(t, lines[1], `"success":false,"error":"fnord"`)
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	keyPath := writeAuditKey(t)
+	appendTestRecords(t, logPath, keyPath)
+	appendTestRecords(t, logPath, keyPath)
+
+	count, err := VerifyAuditLog(logPath, keyPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	data, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+
+	// Changed record
+	tampered := filepath.Join(t.TempDir(), "changed.log")
+	assert.NoError(t, os.WriteFile(tampered, bytes.Replace(data, []byte("fnord"), []byte("fjord"), 1), 0600))
+	count, err = VerifyAuditLog(tampered, keyPath)
+	assert.ErrorIs(t, err, ErrBadSignature)
+	assert.ErrorContains(t, err, "line 2 has an invalid MAC")
+	assert.Equal(t, 1, count)
+
+	// Removed record
+	tampered = filepath.Join(t.TempDir(), "removed.log")
+	assert.NoError(t, os.WriteFile(tampered, bytes.Join([][]byte{lines[0], lines[2], lines[3]}, nil), 0600))
+	_, err = VerifyAuditLog(tampered, keyPath)
+	assert.ErrorContains(t, err, "line 2 does not follow the previous record")
+
+	// Other key
+	otherKey := filepath.Join(t.TempDir(), "other.key")
+	assert.NoError(t, os.WriteFile(otherKey, []byte("fedcba9876543210fedcba9876543210"), 0600))
+	_, err = VerifyAuditLog(logPath, otherKey)
+	assert.ErrorContains(t, err, "line 1 has an invalid MAC")
+}
+
+func TestAppendAuditRecord_Concurrent(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	keyPath := writeAuditKey(t)
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			appendTestRecords(t, logPath, keyPath)
+		}()
+	}
+	wg.Wait()
+
+	// Every record is chained to exactly one predecessor
+	count, err := VerifyAuditLog(logPath, keyPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 32, count)
+}
+
+func TestVerifyAuditLog_Unsigned(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	appendTestRecords(t, logPath, "")
+	_, err := VerifyAuditLog(logPath, writeAuditKey(t))
+	assert.ErrorIs(t, err, ErrBadSignature)
+}
+
+func TestLoadAuditKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short.key")
+	assert.NoError(t, os.WriteFile(path, []byte("short"), 0600))
+	_, err := LoadAuditKey(path)
+	assert.ErrorContains(t, err, "audit key must be at least 32 bytes")
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	assert.Error(t, AppendAuditRecord(logPath, path, NewAuditRecord("seal", "x")))
+	assert.NoFileExists(t, logPath)
+}
@@ -15,20 +15,128 @@ package aws
  */
 
 import (
+	"context"
+	"fmt"
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	stscredsv2 "github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"log"
+	"net/http"
+	"net/url"
+	"strings"
 )
 
-var sess *session.Session
+// Config holds the options of the AWS sessions used for KMS, S3 and Secrets Manager.
+// Empty fields use the defaults of the AWS SDK, e.g. from AWS_REGION, AWS_PROFILE or the instance role.
+type Config struct {
+	// Region is the AWS region, e.g. eu-central-1
+	Region string
+	// Profile is the name of a profile in the shared AWS configuration and credentials files
+	Profile string
+	// EndpointURL replaces the endpoints of all AWS services, e.g. http://localhost:4566 for localstack
+	EndpointURL string
+	// RoleARN is a role assumed with the credentials of the session before accessing any service
+	RoleARN string
+	// S3Tags are set as tags on all objects uploaded to S3
+	S3Tags map[string]string
+	// S3ServerSideEncryption is the encryption of uploaded objects, AES256, aws:kms or aws:kms:dsse; aws:kms if only S3KMSKeyID is set
+	S3ServerSideEncryption string
+	// S3KMSKeyID is the KMS key uploaded objects are encrypted with, the default key of the bucket if empty
+	S3KMSKeyID string
+	// S3ACL is the canned ACL of uploaded objects, e.g. bucket-owner-full-control
+	S3ACL string
+	// S3StorageClass is the storage class of uploaded objects, e.g. STANDARD_IA
+	S3StorageClass string
+}
+
+// Validate checks the endpoint URL and role ARN
+func (c Config) Validate() error {
+	if c.EndpointURL != "" {
+		if u, err := url.Parse(c.EndpointURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid AWS endpoint URL '%s', expected e.g. http://localhost:4566", c.EndpointURL)
+		}
+	}
+	if c.RoleARN != "" && !strings.HasPrefix(c.RoleARN, "arn:") {
+		return fmt.Errorf("invalid AWS role ARN '%s', expected e.g. arn:aws:iam::123456789012:role/sealpack", c.RoleARN)
+	}
+	return c.validateS3Upload()
+}
+
+var (
+	// sessionConfig holds the options sessions are created with
+	sessionConfig Config
+	sess          *session.Session
+	// httpClient sends the requests of the sessions, the default client of the AWS SDK if nil
+	httpClient *http.Client
+)
+
+// Configure sets the options of the AWS sessions. Sessions created before are replaced on next use.
+func Configure(c Config) {
+	sessionConfig = c
+	sess, s3Session, smSession = nil, nil, nil
+}
+
+// SetHTTPClient sets the client sending the requests of the sessions, e.g. to cap their bandwidth.
+// Sessions created before are replaced on next use.
+func SetHTTPClient(client *http.Client) {
+	httpClient = client
+	sess, s3Session, smSession = nil, nil, nil
+}
 
 // verifyAwsSession should be called to ensure an existing AWS session.
 // If none is existing, a new one will be created.
 func verifyAwsSession() {
 	if sess == nil {
 		var err error
-		sess, err = session.NewSession()
+		sess, err = newSession(sessionConfig)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 }
+
+// newSession creates an AWS SDK v1 session for S3 and Secrets Manager
+func newSession(c Config) (*session.Session, error) {
+	opts := session.Options{Profile: c.Profile, SharedConfigState: session.SharedConfigEnable}
+	opts.Config.HTTPClient = httpClient
+	if c.Region != "" {
+		opts.Config.Region = aws.String(c.Region)
+	}
+	if c.EndpointURL != "" {
+		// Custom endpoints like localstack do not serve buckets as subdomains
+		opts.Config.Endpoint = aws.String(c.EndpointURL)
+		opts.Config.S3ForcePathStyle = aws.Bool(true)
+	}
+	s, err := session.NewSessionWithOptions(opts)
+	if err != nil || c.RoleARN == "" {
+		return s, err
+	}
+	return s.Copy(&aws.Config{Credentials: stscreds.NewCredentials(s, c.RoleARN)}), nil
+}
+
+// loadOptions provides the options of AWS SDK v2 configurations, as used for KMS
+func loadOptions(ctx context.Context, c Config) ([]func(*config.LoadOptions) error, error) {
+	var opts []func(*config.LoadOptions) error
+	if c.Region != "" {
+		opts = append(opts, config.WithRegion(c.Region))
+	}
+	if c.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(c.Profile))
+	}
+	if c.EndpointURL != "" {
+		opts = append(opts, config.WithBaseEndpoint(c.EndpointURL))
+	}
+	if c.RoleARN != "" {
+		base, err := config.LoadDefaultConfig(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		provider := stscredsv2.NewAssumeRoleProvider(sts.NewFromConfig(base), c.RoleARN)
+		opts = append(opts, config.WithCredentialsProvider(awsv2.NewCredentialsCache(provider)))
+	}
+	return opts, nil
+}
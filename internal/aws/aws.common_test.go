@@ -0,0 +1,62 @@
+package aws
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, Config{}.Validate())
+	assert.NoError(t, Config{EndpointURL: "http://localhost:4566", RoleARN: "arn:aws:iam::123456789012:role/sealpack"}.Validate())
+	assert.ErrorContains(t, Config{EndpointURL: "localhost:4566"}.Validate(), "invalid AWS endpoint URL")
+	assert.ErrorContains(t, Config{RoleARN: "sealpack"}.Validate(), "invalid AWS role ARN")
+	assert.NoError(t, Config{S3ServerSideEncryption: "aws:kms", S3KMSKeyID: "alias/sealpack", S3ACL: "bucket-owner-full-control", S3StorageClass: "STANDARD_IA"}.Validate())
+	assert.ErrorContains(t, Config{S3ServerSideEncryption: "kms"}.Validate(), "invalid S3 server-side encryption")
+	assert.ErrorContains(t, Config{S3ServerSideEncryption: "AES256", S3KMSKeyID: "alias/sealpack"}.Validate(), "needs server-side encryption aws:kms")
+	assert.ErrorContains(t, Config{S3ACL: "owner"}.Validate(), "invalid S3 ACL")
+	assert.ErrorContains(t, Config{S3StorageClass: "COLD"}.Validate(), "invalid S3 storage class")
+	assert.ErrorContains(t, Config{S3Tags: map[string]string{"": "x"}}.Validate(), "empty key")
+}
+
+func TestNewSession(t *testing.T) {
+	s, err := newSession(Config{Region: "eu-central-1", EndpointURL: "http://localhost:4566"})
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-central-1", *s.Config.Region)
+	assert.Equal(t, "http://localhost:4566", *s.Config.Endpoint)
+	assert.True(t, *s.Config.S3ForcePathStyle)
+}
+
+func TestLoadOptions(t *testing.T) {
+	opts, err := loadOptions(context.Background(), Config{Region: "eu-central-1", Profile: "ci", EndpointURL: "http://localhost:4566"})
+	assert.NoError(t, err)
+	lo := config.LoadOptions{}
+	for _, opt := range opts {
+		assert.NoError(t, opt(&lo))
+	}
+	assert.Equal(t, "eu-central-1", lo.Region)
+	assert.Equal(t, "ci", lo.SharedConfigProfile)
+	assert.Equal(t, "http://localhost:4566", lo.BaseEndpoint)
+}
+
+func TestConfigure(t *testing.T) {
+	defer Configure(Config{})
+	Configure(Config{Region: "us-east-1"})
+	verifyAwsSession()
+	assert.Equal(t, "us-east-1", *sess.Config.Region)
+}
@@ -0,0 +1,127 @@
+//go:build integration
+
+package aws
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/sigstore/sigstore/pkg/signature/options"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// DefaultIntegrationEndpoint is the endpoint of a localstack instance started by `make test-integration`
+const DefaultIntegrationEndpoint = "http://localhost:4566"
+
+// setupIntegration configures the AWS sessions for localstack or another mock given by SEALPACK_TEST_AWS_ENDPOINT.
+// It fails if the endpoint is not reachable, as integration tests are only run on purpose.
+func setupIntegration(t *testing.T) {
+	endpoint := os.Getenv("SEALPACK_TEST_AWS_ENDPOINT")
+	if endpoint == "" {
+		endpoint = DefaultIntegrationEndpoint
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		t.Fatalf("AWS mock not reachable at %s, start it with 'make localstack': %v", endpoint, err)
+	}
+	_ = resp.Body.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	Configure(Config{Region: "us-east-1", EndpointURL: endpoint})
+	t.Cleanup(func() { Configure(Config{}) })
+	verifyAwsSession()
+}
+
+// uniqueName provides a resource name not colliding with earlier test runs against the same instance
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano())
+}
+
+func TestIntegration_KmsSignVerify(t *testing.T) {
+	setupIntegration(t)
+	key, err := kms.New(sess).CreateKey(&kms.CreateKeyInput{
+		KeySpec:  aws.String(kms.KeySpecEccNistP256),
+		KeyUsage: aws.String(kms.KeyUsageTypeSignVerify),
+	})
+	assert.NoError(t, err)
+	uri := "awskms:///" + *key.KeyMetadata.Arn
+
+	signer, err := CreateKmsSigner(uri)
+	assert.NoError(t, err)
+	message := []byte("Hold your breath and count to 10.")
+	sig, err := signer.SignMessage(bytes.NewReader(message), options.WithContext(context.Background()), options.WithCryptoSignerOpts(crypto.SHA256))
+	assert.NoError(t, err)
+
+	verifier, err := CreateKmsVerifier(uri)
+	assert.NoError(t, err)
+	assert.NoError(t, verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(message), options.WithContext(context.Background())))
+	assert.Error(t, verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader([]byte("tampered")), options.WithContext(context.Background())))
+}
+
+func TestIntegration_S3(t *testing.T) {
+	setupIntegration(t)
+	verifyS3Session()
+	bucket := uniqueName("sealpack")
+	_, err := s3Session.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	assert.NoError(t, err)
+	uri := S3UriPrefix + bucket + "/releases/prod.ipc"
+
+	assert.NoError(t, S3UploadArchive(bytes.NewReader([]byte("sealed contents")), uri))
+	contents, err := S3DownloadResource(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "sealed contents", string(contents))
+
+	link, err := S3CreatePresignedDownload(uri, time.Hour)
+	assert.NoError(t, err)
+	resp, err := http.Get(link)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = S3DownloadResource(S3UriPrefix + bucket + "/missing.ipc")
+	assert.Error(t, err)
+}
+
+func TestIntegration_SecretsManager(t *testing.T) {
+	setupIntegration(t)
+	verifySmSession()
+	name := uniqueName("sealpack-key")
+	key := bytes.Repeat([]byte{0x42}, 32)
+	_, err := smSession.CreateSecret(&secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		SecretString: aws.String(base64.StdEncoding.EncodeToString(key)),
+	})
+	assert.NoError(t, err)
+
+	loaded, err := GetEncryptionKey(name)
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	_, err = GetEncryptionKey(uniqueName("missing"))
+	assert.Error(t, err)
+}
@@ -22,12 +22,18 @@ import (
 
 // CreateKmsSigner creates a signer instance from a KMS ARN
 func CreateKmsSigner(uri string) (signature.Signer, error) {
-	verifyAwsSession()
-	return kmssigner.LoadSignerVerifier(context.Background(), uri)
+	opts, err := loadOptions(context.Background(), sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	return kmssigner.LoadSignerVerifier(context.Background(), uri, opts...)
 }
 
 // CreateKmsVerifier creates a verifier instance from a KMS ARN
 func CreateKmsVerifier(uri string) (signature.Verifier, error) {
-	verifyAwsSession()
-	return kmssigner.LoadSignerVerifier(context.Background(), uri)
+	opts, err := loadOptions(context.Background(), sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	return kmssigner.LoadSignerVerifier(context.Background(), uri, opts...)
 }
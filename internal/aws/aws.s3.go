@@ -18,14 +18,19 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"io"
+	"net/url"
+	"slices"
 	"strings"
 	"time"
 )
 
 const (
 	PresignValidDuration = 5 * time.Minute
-	S3UriPrefix          = "s3://"
+	// MaxPresignValidity is the longest validity of presigned links S3 accepts
+	MaxPresignValidity = 7 * 24 * time.Hour
+	S3UriPrefix        = "s3://"
 )
 
 type S3Uri struct {
@@ -46,6 +51,7 @@ func verifyS3Session() {
 
 // S3DownloadResource downloads an object by its key and returns the contents as byte slice.
 func S3DownloadResource(uri string) ([]byte, error) {
+	verifyS3Session()
 	s3uri, err := parseS3Uri(uri)
 	if err != nil {
 		return nil, err
@@ -60,8 +66,90 @@ func S3DownloadResource(uri string) ([]byte, error) {
 	return io.ReadAll(objectOut.Body)
 }
 
-// S3CreatePresignedDownload creates a presigned link to an object and returns it as string.
-func S3CreatePresignedDownload(uri string) (string, error) {
+// S3ObjectSize provides the size of an object in bytes without downloading it.
+func S3ObjectSize(uri string) (int64, error) {
+	verifyS3Session()
+	s3uri, err := parseS3Uri(uri)
+	if err != nil {
+		return 0, err
+	}
+	headOut, err := s3Session.HeadObject(&s3.HeadObjectInput{
+		Bucket: s3uri.Bucket,
+		Key:    s3uri.Key,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return aws.Int64Value(headOut.ContentLength), nil
+}
+
+// S3DownloadRange downloads length bytes of an object starting at offset.
+func S3DownloadRange(uri string, offset, length int64) ([]byte, error) {
+	verifyS3Session()
+	s3uri, err := parseS3Uri(uri)
+	if err != nil {
+		return nil, err
+	}
+	objectOut, err := s3Session.GetObject(&s3.GetObjectInput{
+		Bucket: s3uri.Bucket,
+		Key:    s3uri.Key,
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = objectOut.Body.Close() }()
+	return io.ReadAll(objectOut.Body)
+}
+
+// S3Object is an object listed by S3ListObjects
+type S3Object struct {
+	URI      string
+	Modified time.Time
+}
+
+// S3ListObjects lists all objects below a prefix, given as s3://bucket/prefix.
+func S3ListObjects(uri string) ([]S3Object, error) {
+	verifyS3Session()
+	parts := strings.SplitN(strings.TrimPrefix(uri, S3UriPrefix), "/", 2)
+	if parts[0] == "" {
+		return nil, fmt.Errorf("invalid S3 URI")
+	}
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(parts[0])}
+	if len(parts) > 1 && parts[1] != "" {
+		input.Prefix = aws.String(parts[1])
+	}
+	var objects []S3Object
+	err := s3Session.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, S3Object{
+				URI:      S3UriPrefix + parts[0] + "/" + aws.StringValue(object.Key),
+				Modified: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// S3DeleteObject deletes an object given as s3://bucket/key
+func S3DeleteObject(uri string) error {
+	verifyS3Session()
+	s3uri, err := parseS3Uri(uri)
+	if err != nil {
+		return err
+	}
+	_, err = s3Session.DeleteObject(&s3.DeleteObjectInput{Bucket: s3uri.Bucket, Key: s3uri.Key})
+	return err
+}
+
+// S3CreatePresignedDownload creates a presigned link to an object valid for validity, PresignValidDuration if zero,
+// and returns it as string.
+func S3CreatePresignedDownload(uri string, validity time.Duration) (string, error) {
+	verifyS3Session()
 	s3uri, err := parseS3Uri(uri)
 	if err != nil {
 		return "", err
@@ -70,7 +158,10 @@ func S3CreatePresignedDownload(uri string) (string, error) {
 		Bucket: s3uri.Bucket,
 		Key:    s3uri.Key,
 	})
-	return req.Presign(PresignValidDuration)
+	if validity == 0 {
+		validity = PresignValidDuration
+	}
+	return req.Presign(validity)
 }
 
 // S3UploadArchive uploads the byte slice of the archive to S3.
@@ -80,10 +171,16 @@ func S3UploadArchive(reader io.ReadSeeker, uri string) error {
 	if err != nil {
 		return err
 	}
+	options := sessionConfig.s3UploadOptions()
 	_, err = s3Session.PutObject(&s3.PutObjectInput{
-		Bucket: s3uri.Bucket,
-		Key:    s3uri.Key,
-		Body:   reader,
+		Bucket:               s3uri.Bucket,
+		Key:                  s3uri.Key,
+		Body:                 reader,
+		Tagging:              options.Tagging,
+		ServerSideEncryption: options.ServerSideEncryption,
+		SSEKMSKeyId:          options.SSEKMSKeyId,
+		ACL:                  options.ACL,
+		StorageClass:         options.StorageClass,
 	})
 	if err != nil {
 		return err
@@ -91,6 +188,93 @@ func S3UploadArchive(reader io.ReadSeeker, uri string) error {
 	return nil
 }
 
+// S3UploadStream uploads a stream of unknown length to S3 in parts. The upload is aborted if reading fails.
+func S3UploadStream(reader io.Reader, uri string) error {
+	verifyS3Session()
+	s3uri, err := parseS3Uri(uri)
+	if err != nil {
+		return err
+	}
+	options := sessionConfig.s3UploadOptions()
+	_, err = s3manager.NewUploaderWithClient(s3Session).Upload(&s3manager.UploadInput{
+		Bucket:               s3uri.Bucket,
+		Key:                  s3uri.Key,
+		Body:                 reader,
+		Tagging:              options.Tagging,
+		ServerSideEncryption: options.ServerSideEncryption,
+		SSEKMSKeyId:          options.SSEKMSKeyId,
+		ACL:                  options.ACL,
+		StorageClass:         options.StorageClass,
+	})
+	return err
+}
+
+// maxS3Tags is the number of tags S3 allows on an object
+const maxS3Tags = 10
+
+// validateS3Upload checks the tags, encryption, ACL and storage class of uploaded objects
+func (c Config) validateS3Upload() error {
+	if len(c.S3Tags) > maxS3Tags {
+		return fmt.Errorf("too many S3 tags: %d, at most %d are allowed", len(c.S3Tags), maxS3Tags)
+	}
+	for key := range c.S3Tags {
+		if key == "" {
+			return fmt.Errorf("invalid S3 tag with empty key")
+		}
+	}
+	if c.S3ServerSideEncryption != "" && !slices.Contains(s3.ServerSideEncryption_Values(), c.S3ServerSideEncryption) {
+		return fmt.Errorf("invalid S3 server-side encryption '%s', use %s", c.S3ServerSideEncryption, strings.Join(s3.ServerSideEncryption_Values(), ", "))
+	}
+	if c.S3KMSKeyID != "" && c.S3ServerSideEncryption == s3.ServerSideEncryptionAes256 {
+		return fmt.Errorf("a KMS key for S3 needs server-side encryption %s or %s", s3.ServerSideEncryptionAwsKms, s3.ServerSideEncryptionAwsKmsDsse)
+	}
+	if c.S3ACL != "" && !slices.Contains(s3.ObjectCannedACL_Values(), c.S3ACL) {
+		return fmt.Errorf("invalid S3 ACL '%s', use %s", c.S3ACL, strings.Join(s3.ObjectCannedACL_Values(), ", "))
+	}
+	if c.S3StorageClass != "" && !slices.Contains(s3.StorageClass_Values(), c.S3StorageClass) {
+		return fmt.Errorf("invalid S3 storage class '%s', use %s", c.S3StorageClass, strings.Join(s3.StorageClass_Values(), ", "))
+	}
+	return nil
+}
+
+// s3UploadOptions are the headers set on single and multipart uploads, nil if not configured
+type s3UploadOptions struct {
+	Tagging              *string
+	ServerSideEncryption *string
+	SSEKMSKeyId          *string
+	ACL                  *string
+	StorageClass         *string
+}
+
+// s3UploadOptions provides the headers of uploads. A KMS key without encryption selects aws:kms.
+func (c Config) s3UploadOptions() s3UploadOptions {
+	options := s3UploadOptions{
+		ServerSideEncryption: optionalString(c.S3ServerSideEncryption),
+		SSEKMSKeyId:          optionalString(c.S3KMSKeyID),
+		ACL:                  optionalString(c.S3ACL),
+		StorageClass:         optionalString(c.S3StorageClass),
+	}
+	if c.S3KMSKeyID != "" && c.S3ServerSideEncryption == "" {
+		options.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+	}
+	if len(c.S3Tags) > 0 {
+		tags := url.Values{}
+		for key, value := range c.S3Tags {
+			tags.Set(key, value)
+		}
+		options.Tagging = aws.String(tags.Encode())
+	}
+	return options
+}
+
+// optionalString provides a pointer to a string, or nil for an empty string, as AWS SDK inputs expect
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
 // parseS3Uri parses a string-based URI with a s3:// file wrapper to bucket and key
 func parseS3Uri(s3uri string) (*S3Uri, error) {
 	parts := strings.SplitN(strings.TrimPrefix(s3uri, S3UriPrefix), "/", 2)
@@ -0,0 +1,46 @@
+package aws
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConfig_S3UploadOptions(t *testing.T) {
+	options := Config{}.s3UploadOptions()
+	assert.Nil(t, options.Tagging)
+	assert.Nil(t, options.ServerSideEncryption)
+	assert.Nil(t, options.SSEKMSKeyId)
+	assert.Nil(t, options.ACL)
+	assert.Nil(t, options.StorageClass)
+
+	options = Config{
+		S3Tags:         map[string]string{"team": "ops", "release": "1.2 beta"},
+		S3KMSKeyID:     "alias/sealpack",
+		S3ACL:          "bucket-owner-full-control",
+		S3StorageClass: "STANDARD_IA",
+	}.s3UploadOptions()
+	assert.Equal(t, "release=1.2+beta&team=ops", aws.StringValue(options.Tagging))
+	assert.Equal(t, "aws:kms", aws.StringValue(options.ServerSideEncryption))
+	assert.Equal(t, "alias/sealpack", aws.StringValue(options.SSEKMSKeyId))
+	assert.Equal(t, "bucket-owner-full-control", aws.StringValue(options.ACL))
+	assert.Equal(t, "STANDARD_IA", aws.StringValue(options.StorageClass))
+
+	options = Config{S3ServerSideEncryption: "AES256"}.s3UploadOptions()
+	assert.Equal(t, "AES256", aws.StringValue(options.ServerSideEncryption))
+	assert.Nil(t, options.SSEKMSKeyId)
+}
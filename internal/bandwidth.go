@@ -0,0 +1,171 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/innomotics/sealpack/internal/aws"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimitChunk limits the bytes transferred at once, so concurrent transfers share the bandwidth smoothly
+const rateLimitChunk = 32 << 10
+
+// BandwidthLimits cap the bandwidth of network transfers in bytes per second. Zero values disable the respective cap.
+type BandwidthLimits struct {
+	// MaxDownloadRate caps registry pulls, downloads of HTTP sources and downloads from S3 and SFTP
+	MaxDownloadRate int64
+	// MaxUploadRate caps registry pushes and uploads to S3 and SFTP
+	MaxUploadRate int64
+}
+
+// Validate checks the limits for negative values
+func (l BandwidthLimits) Validate() error {
+	if l.MaxDownloadRate < 0 || l.MaxUploadRate < 0 {
+		return fmt.Errorf("bandwidth limits must not be negative")
+	}
+	return nil
+}
+
+var (
+	// downloadLimiter and uploadLimiter are shared by all transfers in their direction, nil if unlimited
+	downloadLimiter *rateLimiter
+	uploadLimiter   *rateLimiter
+	// now and sleep are the clock of the limiters
+	now   = time.Now
+	sleep = time.Sleep
+)
+
+// LimitBandwidth caps the bandwidth of all following network transfers
+func LimitBandwidth(limits BandwidthLimits) {
+	downloadLimiter, uploadLimiter = newRateLimiter(limits.MaxDownloadRate), newRateLimiter(limits.MaxUploadRate)
+	aws.SetHTTPClient(&http.Client{Transport: limitedTransport{base: http.DefaultTransport}})
+}
+
+// registryOptions let registry transfers obey the bandwidth limits
+func registryOptions() []crane.Option {
+	return []crane.Option{crane.WithTransport(limitedTransport{base: remote.DefaultTransport})}
+}
+
+// rateLimiter is a token bucket allowing bursts of up to one second
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter of rate bytes per second, nil if rate is zero
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rate), last: now()}
+}
+
+// wait blocks until n bytes transferred fit the rate. Transfers exceeding the available bandwidth
+// are charged in advance, so concurrent transfers wait for each other.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	t := now()
+	l.tokens = min(l.tokens+t.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = t
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay > 0 {
+		sleep(delay)
+	}
+}
+
+// limitedReader reads in small chunks, waiting for the limiter after each
+type limitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+// limitReader caps reading from r by the limiter, if there is one
+func limitReader(r io.Reader, limiter *rateLimiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &limitedReader{r: r, limiter: limiter}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > rateLimitChunk {
+		p = p[:rateLimitChunk]
+	}
+	n, err := r.r.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
+
+// limitedWriter writes in small chunks, waiting for the limiter before each
+type limitedWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+// limitWriter caps writing to w by the limiter, if there is one
+func limitWriter(w io.Writer, limiter *rateLimiter) io.Writer {
+	if limiter == nil {
+		return w
+	}
+	return &limitedWriter{w: w, limiter: limiter}
+}
+
+func (w *limitedWriter) Write(p []byte) (written int, err error) {
+	for len(p) > 0 {
+		chunk := p[:min(len(p), rateLimitChunk)]
+		w.limiter.wait(len(chunk))
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// limitedBody caps a request or response body, closing the original one
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// limitedTransport caps request bodies by the upload and response bodies by the download limit
+type limitedTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if limiter := uploadLimiter; limiter != nil && req.Body != nil && req.Body != http.NoBody {
+		// A RoundTripper must not modify the request
+		req = req.Clone(req.Context())
+		req.Body = limitedBody{Reader: limitReader(req.Body, limiter), Closer: req.Body}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if limiter := downloadLimiter; err == nil && limiter != nil {
+		resp.Body = limitedBody{Reader: limitReader(resp.Body, limiter), Closer: resp.Body}
+	}
+	return resp, err
+}
@@ -0,0 +1,114 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockSleep lets rate limiters advance a fake clock instead of sleeping and provides the time slept
+func mockSleep(t *testing.T) func() time.Duration {
+	oldNow, oldSleep := now, sleep
+	t.Cleanup(func() { now, sleep = oldNow, oldSleep })
+	var mu sync.Mutex
+	var total time.Duration
+	start := time.Now()
+	now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return start.Add(total)
+	}
+	sleep = func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		total += d
+	}
+	return func() time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		return total
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	slept := mockSleep(t)
+	assert.Nil(t, newRateLimiter(0))
+	limiter := newRateLimiter(1000)
+	limiter.wait(500)
+	limiter.wait(1500)
+	assert.Equal(t, 2*time.Second, slept())
+	// Idle time allows a burst of up to one second
+	sleep(5 * time.Second)
+	limiter.wait(1000)
+	assert.Equal(t, 7*time.Second, slept())
+	limiter.wait(500)
+	assert.Equal(t, 7*time.Second+time.Second/2, slept())
+}
+
+func TestLimitWriter(t *testing.T) {
+	slept := mockSleep(t)
+	buf := &bytes.Buffer{}
+	assert.Same(t, buf, limitWriter(buf, nil))
+	w := limitWriter(buf, newRateLimiter(1<<20))
+	n, err := w.Write(make([]byte, 3*rateLimitChunk+1))
+	assert.NoError(t, err)
+	assert.Equal(t, 3*rateLimitChunk+1, n)
+	assert.Equal(t, 3*rateLimitChunk+1, buf.Len())
+	assert.Equal(t, time.Duration(3*rateLimitChunk+1)*time.Second/(1<<20), slept())
+}
+
+func TestLimitedTransport(t *testing.T) {
+	slept := mockSleep(t)
+	contents := bytes.Repeat([]byte("x"), 100<<10)
+	var uploaded int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		uploaded = len(body)
+		_, _ = w.Write(contents)
+	}))
+	defer server.Close()
+	defer LimitBandwidth(BandwidthLimits{})
+	client := &http.Client{Transport: limitedTransport{base: http.DefaultTransport}}
+
+	LimitBandwidth(BandwidthLimits{MaxDownloadRate: 1 << 20})
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, contents, body)
+	// 100 KiB at 1 MiB/s
+	assert.InDelta(t, time.Second*100/1024, slept(), float64(time.Millisecond))
+
+	LimitBandwidth(BandwidthLimits{MaxUploadRate: 1 << 20})
+	before := slept()
+	resp, err = client.Post(server.URL, "application/octet-stream", bytes.NewReader(contents[:50<<10]))
+	assert.NoError(t, err)
+	_, _ = io.Copy(io.Discard, resp.Body)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, 50<<10, uploaded)
+	assert.InDelta(t, time.Second*50/1024, slept()-before, float64(time.Millisecond))
+}
+
+func TestBandwidthLimits_Validate(t *testing.T) {
+	assert.NoError(t, BandwidthLimits{MaxDownloadRate: 1}.Validate())
+	assert.Error(t, BandwidthLimits{MaxUploadRate: -1}.Validate())
+}
@@ -0,0 +1,321 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/innomotics/sealpack/internal/aws"
+	"github.com/sigstore/sigstore/pkg/signature/options"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CatalogPayloadType is the DSSE payload type of signed catalogs
+const CatalogPayloadType = "application/vnd.sealpack.catalog+json"
+
+var s3ListObjects = aws.S3ListObjects
+
+// packageVersionPattern splits file names like app-1.2.3 or app_v2.0.0-rc1 into name and version
+var packageVersionPattern = regexp.MustCompile(`^(.+?)[-_]v?(\d+(?:\.\d+)*(?:[-+~][0-9A-Za-z.+~-]*)?)$`)
+
+// Catalog lists the packages available in a directory or S3 prefix, so devices can discover updates
+type Catalog struct {
+	Created  time.Time      `json:"created"`
+	Packages []CatalogEntry `json:"packages"`
+}
+
+// CatalogEntry describes a package as far as it can be read without any key
+type CatalogEntry struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Digest is the SHA-256 digest of the package as recorded in apply states
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Public bool   `json:"public,omitempty"`
+	Fleet  bool   `json:"fleet,omitempty"`
+	// Receivers is the number of recipient keys, Recipients their fingerprints if the package records them
+	Receivers  int      `json:"receivers"`
+	Recipients []string `json:"recipients,omitempty"`
+	URL        string   `json:"url"`
+	// Channel is the release channel the package is offered in, initially the one it was sealed for
+	Channel string `json:"channel,omitempty"`
+	// Annotations are the signed annotations of the package
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ScanCatalog reads the envelopes of all packages in a directory or below an s3:// prefix.
+// Packages are located by baseURL joined with their relative path, or by their path if baseURL is empty.
+// Files which are no packages are skipped.
+func ScanCatalog(source, baseURL string) (*Catalog, error) {
+	files, err := listPackages(source)
+	if err != nil {
+		return nil, err
+	}
+	catalog := &Catalog{Created: time.Now().UTC(), Packages: make([]CatalogEntry, 0, len(files))}
+	for _, file := range files {
+		location, rel := file.location, file.rel
+		entry, err := catalogEntry(location)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read package %s: %w", location, err)
+		}
+		if entry == nil {
+			continue
+		}
+		entry.Name, entry.Version = ParsePackageName(path.Base(rel))
+		entry.URL = location
+		if baseURL != "" {
+			if entry.URL, err = url.JoinPath(baseURL, rel); err != nil {
+				return nil, fmt.Errorf("invalid base URL '%s': %v", baseURL, err)
+			}
+		}
+		catalog.Packages = append(catalog.Packages, *entry)
+	}
+	sort.Slice(catalog.Packages, func(i, j int) bool {
+		a, b := catalog.Packages[i], catalog.Packages[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.URL < b.URL
+	})
+	return catalog, nil
+}
+
+// ParsePackageName splits the file name of a package into name and version, e.g. app-1.2.3.ipc into app and 1.2.3.
+// The version is empty if the name contains none.
+func ParsePackageName(fileName string) (string, string) {
+	if ext := path.Ext(fileName); len(ext) > 1 && (ext[1] < '0' || ext[1] > '9') {
+		fileName = strings.TrimSuffix(fileName, ext)
+	}
+	if match := packageVersionPattern.FindStringSubmatch(fileName); match != nil {
+		return match[1], match[2]
+	}
+	return fileName, ""
+}
+
+// repositoryFile is a file of a directory, S3 prefix or SFTP directory of packages
+type repositoryFile struct {
+	location string
+	// rel is the slash-separated path relative to the repository
+	rel      string
+	modified time.Time
+}
+
+// listPackages lists all files of a directory, S3 prefix or SFTP directory, sorted by their location
+func listPackages(source string) ([]repositoryFile, error) {
+	var files []repositoryFile
+	var err error
+	switch lower := strings.ToLower(source); {
+	case strings.HasPrefix(lower, aws.S3UriPrefix):
+		objects, listErr := s3ListObjects(source)
+		if listErr != nil {
+			return nil, wrapNetworkError(listErr)
+		}
+		prefix := strings.TrimSuffix(source, "/") + "/"
+		for _, object := range objects {
+			rel := strings.TrimPrefix(object.URI, prefix)
+			if rel == object.URI {
+				// Objects named like the prefix, e.g. s3://bucket/pkg- for pkg-1.ipc
+				rel = path.Base(object.URI)
+			}
+			files = append(files, repositoryFile{location: object.URI, rel: rel, modified: object.Modified})
+		}
+	case strings.HasPrefix(lower, SFTPUriPrefix):
+		client, dir, dialErr := dialSFTP(source)
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		defer client.Close()
+		err = walkSFTP(client, dir, func(p string, info fs.FileInfo) {
+			rel := strings.TrimPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+			files = append(files, repositoryFile{location: strings.TrimSuffix(source, "/") + "/" + rel, rel: rel, modified: info.ModTime()})
+		})
+	default:
+		err = filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(source, p)
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files = append(files, repositoryFile{location: p, rel: filepath.ToSlash(rel), modified: info.ModTime()})
+			return nil
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].location < files[j].location })
+	return files, err
+}
+
+// isPackage checks the magic bytes of a file for a package
+func isPackage(src RangeSource) (bool, error) {
+	magic := make([]byte, len(EnvelopeMagicBytes))
+	if _, err := src.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return false, err
+	}
+	return string(magic) == EnvelopeMagicBytes || string(magic) == EnvelopeMagicBytesV2, nil
+}
+
+// catalogEntry reads the envelope of a package, nil if the file is no package
+func catalogEntry(location string) (*CatalogEntry, error) {
+	src, err := OpenRangeSource(location, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	if ok, err := isPackage(src); !ok || err != nil {
+		return nil, err
+	}
+	pkg := io.NewSectionReader(src, 0, src.Size())
+	envelope, err := ParseEnvelopeUnverified(pkg)
+	if err != nil {
+		return nil, err
+	}
+	checksum := envelope.Checksum
+	if checksum == nil {
+		// Packages without checksum trailer are hashed completely
+		h := sha256.New()
+		if _, err = io.Copy(h, io.NewSectionReader(src, 0, src.Size())); err != nil {
+			return nil, err
+		}
+		checksum = h.Sum(nil)
+	}
+	entry := &CatalogEntry{
+		Size:        src.Size(),
+		Public:      len(envelope.ReceiverKeys) == 0,
+		Fleet:       envelope.HasFleetKey(),
+		Receivers:   len(envelope.recipientKeys()),
+		Channel:     envelope.Channel,
+		Annotations: envelope.Annotations,
+	}
+	if envelope.HasRecipientHints() {
+		entry.Recipients = envelope.RecipientFingerprints()
+	}
+	entry.Digest, err = PackageDigest(location, checksum)
+	return entry, err
+}
+
+// InChannel provides the entries offered in a channel
+func (c *Catalog) InChannel(channel string) []CatalogEntry {
+	var entries []CatalogEntry
+	for _, entry := range c.Packages {
+		if entry.Channel == channel {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// FilterAnnotations removes all entries not matching the filters, given as "key=value" or "key" for any value
+func (c *Catalog) FilterAnnotations(filters []string) {
+	packages := c.Packages[:0]
+	for _, entry := range c.Packages {
+		if MatchAnnotations(entry.Annotations, filters) {
+			packages = append(packages, entry)
+		}
+	}
+	c.Packages = packages
+}
+
+// Promote moves all entries of a package version to a channel, e.g. from beta to stable.
+// The packages are not touched, the catalog has to be signed again.
+func (c *Catalog) Promote(name, version, channel string) error {
+	if err := ValidateChannel(channel); err != nil {
+		return err
+	}
+	promoted := false
+	for i, entry := range c.Packages {
+		if entry.Name == name && entry.Version == version {
+			c.Packages[i].Channel = channel
+			promoted = true
+		}
+	}
+	if !promoted {
+		return fmt.Errorf("catalog contains no package %s in version %s", name, version)
+	}
+	c.Created = time.Now().UTC()
+	return nil
+}
+
+// Sign signs the catalog as DSSE envelope with the private key
+func (c *Catalog) Sign(privateKeyPath string) ([]byte, error) {
+	signer, err := CreateSigner(privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not create signer: %v", err)
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.SignMessage(bytes.NewReader(preAuthEncoding(CatalogPayloadType, payload)), options.NoOpOptionImpl{})
+	if err != nil {
+		return nil, fmt.Errorf("failed signing catalog: %v", err)
+	}
+	envelope, err := json.MarshalIndent(&DSSEEnvelope{
+		PayloadType: CatalogPayloadType,
+		Payload:     payload,
+		Signatures:  []DSSESignature{{Sig: sig}},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(envelope, '\n'), nil
+}
+
+// VerifyCatalog checks the signature of a catalog with the public key of the signing entity and returns the catalog
+func VerifyCatalog(envelope []byte, publicKeyPath string) (*Catalog, error) {
+	dsse := &DSSEEnvelope{}
+	if err := json.Unmarshal(envelope, dsse); err != nil {
+		return nil, fmt.Errorf("invalid catalog: %w", err)
+	}
+	if dsse.PayloadType != CatalogPayloadType {
+		return nil, fmt.Errorf("invalid catalog payload type '%s'", dsse.PayloadType)
+	}
+	verifier, err := CreateVerifier(publicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	valid := false
+	for _, sig := range dsse.Signatures {
+		if verifier.VerifySignature(bytes.NewReader(sig.Sig), bytes.NewReader(preAuthEncoding(dsse.PayloadType, dsse.Payload))) == nil {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, WithHint(fmt.Errorf("%w: catalog signature is invalid", ErrBadSignature), signerHint, publicKeyPath)
+	}
+	catalog := &Catalog{}
+	if err = json.Unmarshal(dsse.Payload, catalog); err != nil {
+		return nil, fmt.Errorf("invalid catalog: %w", err)
+	}
+	return catalog, nil
+}
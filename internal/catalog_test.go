@@ -0,0 +1,136 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/innomotics/sealpack/internal/aws"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyGolden copies a golden package into a repository directory
+func copyGolden(t *testing.T, fixture, target string) {
+	data, err := os.ReadFile(goldenPackage(fixture))
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(target), 0755))
+	assert.NoError(t, os.WriteFile(target, data, 0644))
+}
+
+func TestParsePackageName(t *testing.T) {
+	for fileName, expected := range map[string][2]string{
+		"app-1.2.3.ipc":         {"app", "1.2.3"},
+		"app_v2.0.0-rc1.sealed": {"app", "2.0.0-rc1"},
+		"edge-agent-10":         {"edge-agent", "10"},
+		"firmware-2.1":          {"firmware", "2.1"},
+		"rootfs.ipc":            {"rootfs", ""},
+		"v5-sealed.ipc":         {"v5-sealed", ""},
+	} {
+		name, version := ParsePackageName(fileName)
+		assert.Equal(t, expected, [2]string{name, version}, fileName)
+	}
+}
+
+func TestScanCatalog(t *testing.T) {
+	repo := t.TempDir()
+	copyGolden(t, "v5-sealed", filepath.Join(repo, "app-1.2.0.ipc"))
+	copyGolden(t, "recipient-hints", filepath.Join(repo, "app-1.3.0.ipc"))
+	copyGolden(t, "public", filepath.Join(repo, "tools", "debug-0.1.ipc"))
+	copyGolden(t, "v1-sealed", filepath.Join(repo, "legacy.ipc"))
+	assert.NoError(t, os.WriteFile(filepath.Join(repo, "README.txt"), []byte("no package"), 0644))
+
+	catalog, err := ScanCatalog(repo, "https://updates.example.com/stable/")
+	assert.NoError(t, err)
+	assert.Len(t, catalog.Packages, 4)
+	app := catalog.Packages[0]
+	assert.Equal(t, "app", app.Name)
+	assert.Equal(t, "1.2.0", app.Version)
+	assert.Equal(t, "https://updates.example.com/stable/app-1.2.0.ipc", app.URL)
+	assert.Equal(t, 1, app.Receivers)
+	assert.Empty(t, app.Recipients)
+	info, err := os.Stat(filepath.Join(repo, "app-1.2.0.ipc"))
+	assert.NoError(t, err)
+	assert.Equal(t, info.Size(), app.Size)
+	raw, err := os.Open(filepath.Join(repo, "app-1.2.0.ipc"))
+	assert.NoError(t, err)
+	defer raw.Close()
+	envelope, err := ParseEnvelope(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(envelope.Checksum), app.Digest)
+
+	assert.Equal(t, "1.3.0", catalog.Packages[1].Version)
+	assert.Len(t, catalog.Packages[1].Recipients, 2)
+	debug := catalog.Packages[2]
+	assert.Equal(t, "debug", debug.Name)
+	assert.True(t, debug.Public)
+	assert.Equal(t, "https://updates.example.com/stable/tools/debug-0.1.ipc", debug.URL)
+	// Packages without checksum trailer are hashed completely
+	legacy := catalog.Packages[3]
+	data, err := os.ReadFile(filepath.Join(repo, "legacy.ipc"))
+	assert.NoError(t, err)
+	digest := sha256.Sum256(data)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(digest[:]), legacy.Digest)
+
+	catalog, err = ScanCatalog(repo, "")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(repo, "app-1.2.0.ipc"), catalog.Packages[0].URL)
+
+	copyGolden(t, "corrupt-version", filepath.Join(repo, "broken-1.0.ipc"))
+	_, err = ScanCatalog(repo, "")
+	assert.ErrorContains(t, err, "broken-1.0.ipc")
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+}
+
+func TestScanCatalog_S3(t *testing.T) {
+	tmpList, tmpSize, tmpRange := s3ListObjects, s3ObjectSize, s3DownloadRange
+	defer func() { s3ListObjects, s3ObjectSize, s3DownloadRange = tmpList, tmpSize, tmpRange }()
+	data, err := os.ReadFile(goldenPackage("public"))
+	assert.NoError(t, err)
+	s3ListObjects = func(uri string) ([]aws.S3Object, error) {
+		assert.Equal(t, "s3://updates/stable", uri)
+		return []aws.S3Object{{URI: "s3://updates/stable/app-2.0.ipc"}}, nil
+	}
+	s3ObjectSize = func(string) (int64, error) { return int64(len(data)), nil }
+	s3DownloadRange = func(_ string, offset, length int64) ([]byte, error) {
+		return data[offset : offset+length], nil
+	}
+	catalog, err := ScanCatalog("s3://updates/stable", "")
+	assert.NoError(t, err)
+	assert.Len(t, catalog.Packages, 1)
+	assert.Equal(t, "app", catalog.Packages[0].Name)
+	assert.Equal(t, "s3://updates/stable/app-2.0.ipc", catalog.Packages[0].URL)
+}
+
+func TestCatalog_Sign(t *testing.T) {
+	repo := t.TempDir()
+	copyGolden(t, "sealed", filepath.Join(repo, "app-1.0.ipc"))
+	catalog, err := ScanCatalog(repo, "https://updates.example.com")
+	assert.NoError(t, err)
+	signed, err := catalog.Sign("../test/private.pem")
+	assert.NoError(t, err)
+
+	verified, err := VerifyCatalog(signed, "../test/public.pem")
+	assert.NoError(t, err)
+	assert.Equal(t, catalog.Packages, verified.Packages)
+	assert.True(t, catalog.Created.Equal(verified.Created))
+
+	_, err = VerifyCatalog(signed, "../test/pkcs1-public.pem")
+	assert.ErrorIs(t, err, ErrBadSignature)
+	_, err = VerifyCatalog([]byte(`{"payloadType":"application/vnd.in-toto+json"}`), "../test/public.pem")
+	assert.ErrorContains(t, err, "invalid catalog payload type")
+}
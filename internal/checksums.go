@@ -0,0 +1,63 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ChecksumList collects the SHA-256 sums of extracted files, so they can be verified later using sha256sum -c
+type ChecksumList struct {
+	mutex sync.Mutex
+	sums  map[string]string
+}
+
+// NewChecksumList creates an empty ChecksumList
+func NewChecksumList() *ChecksumList {
+	return &ChecksumList{sums: map[string]string{}}
+}
+
+// add records the sum of an archive entry under its path relative to the output path.
+// It is safe for concurrent use by extraction workers.
+func (c *ChecksumList) add(name string, sum []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sums[filepath.ToSlash(localFileName(name))] = hex.EncodeToString(sum)
+}
+
+// Bytes formats the list like sha256sum does, sorted by path.
+// Paths containing a backslash or newline are escaped and their lines start with a backslash.
+func (c *ChecksumList) Bytes() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	paths := make([]string, 0, len(c.sums))
+	for path := range c.sums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	sb := strings.Builder{}
+	for _, path := range paths {
+		escaped := strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(path)
+		if escaped != path {
+			sb.WriteString("\\")
+		}
+		sb.WriteString(c.sums[path] + "  " + escaped + "\n")
+	}
+	return []byte(sb.String())
+}
@@ -0,0 +1,55 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestChecksumList_Bytes(t *testing.T) {
+	list := NewChecksumList()
+	list.add("b/file.txt", []byte{0xAB, 0xCD})
+	list.add("a.txt", []byte{0x01})
+	list.add("back\\slash", []byte{0x02})
+	list.add("new\nline", []byte{0x03})
+	assert.Equal(t, "01  a.txt\n"+
+		"abcd  b/file.txt\n"+
+		"\\02  back\\\\slash\n"+
+		"\\03  new\\nline\n", string(list.Bytes()))
+	assert.Empty(t, NewChecksumList().Bytes())
+}
+
+func TestReadArchive_UnpackChecksums(t *testing.T) {
+	contents := []byte("Hold your breath and count to 10.")
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	assert.NoError(t, arc.AddToArchive("dir/data.txt", contents))
+	assert.NoError(t, sig.AddFile("dir/data.txt", contents))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+
+	ra := openTestArchive(t, arc)
+	ra.Checksums = NewChecksumList()
+	ra.Parallel = 2
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", t.TempDir(), "", ""))
+	sum := sha256.Sum256(contents)
+	// Only contents are listed, not the TOC
+	assert.Equal(t, hex.EncodeToString(sum[:])+"  dir/data.txt\n", string(ra.Checksums.Bytes()))
+}
@@ -0,0 +1,246 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// paxChunkSize holds the real size of a file stored as content-defined chunks
+	paxChunkSize = "SEALPACK.chunks.size"
+	// paxChunkMap lists the chunks of a file as comma-separated <sha256 hex>:<length> pairs.
+	// Chunks not stored by a previous entry follow in order as data of the entry.
+	paxChunkMap = "SEALPACK.chunks.map"
+	// chunkMinSize is the size below which no chunk boundary is set
+	chunkMinSize = 16 << 10
+	// chunkMaxSize is the size at which a chunk boundary is enforced; larger chunks are invalid
+	chunkMaxSize = 256 << 10
+	// chunkMask selects the bits of the rolling hash that must be zero at a boundary, 64 KiB chunks on average
+	chunkMask = uint64(1<<16-1) << 48
+)
+
+// gearTable maps bytes to the pseudo-random values of the gear rolling hash.
+// It is generated with SplitMix64 from a fixed seed, so chunk boundaries never change between releases.
+var gearTable = func() (table [256]uint64) {
+	seed := uint64(0x5ea1_9ac6_0000_0001)
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunk is a content-defined region of a file, identified by its SHA-256 digest
+type chunk struct {
+	Offset int64
+	Length int64
+	Digest string
+}
+
+// chunkFile splits contents into chunks at positions defined by a gear rolling hash, as done by FastCDC.
+// Inserting or removing bytes only changes the chunks around the modification.
+func chunkFile(r io.Reader) ([]chunk, error) {
+	var chunks []chunk
+	var offset, length int64
+	var rolling uint64
+	sum := sha256.New()
+	buf := make([]byte, copyBufferSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		data := buf[:n]
+		for len(data) > 0 {
+			cut, boundary := len(data), false
+			for i, b := range data {
+				length++
+				rolling = rolling<<1 + gearTable[b]
+				if length >= chunkMaxSize || (length >= chunkMinSize && rolling&chunkMask == 0) {
+					cut, boundary = i+1, true
+					break
+				}
+			}
+			sum.Write(data[:cut])
+			data = data[cut:]
+			if boundary {
+				chunks = append(chunks, chunk{Offset: offset, Length: length, Digest: hex.EncodeToString(sum.Sum(nil))})
+				offset, length, rolling = offset+length, 0, 0
+				sum.Reset()
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if length > 0 {
+		chunks = append(chunks, chunk{Offset: offset, Length: length, Digest: hex.EncodeToString(sum.Sum(nil))})
+	}
+	return chunks, nil
+}
+
+// setChunkHeader stores the chunk map in the PAX records of a header and sets the size to the size of the new chunks.
+// Chunks stored before are only referenced; the returned chunks need to be written as data of the entry.
+func setChunkHeader(h *tar.Header, chunks []chunk, stored map[string]bool) []chunk {
+	values := make([]string, len(chunks))
+	var added []chunk
+	var packed int64
+	for i, c := range chunks {
+		values[i] = c.Digest + ":" + strconv.FormatInt(c.Length, 10)
+		if !stored[c.Digest] {
+			stored[c.Digest] = true
+			added = append(added, c)
+			packed += c.Length
+		}
+	}
+	if h.PAXRecords == nil {
+		h.PAXRecords = map[string]string{}
+	}
+	h.PAXRecords[paxChunkSize] = strconv.FormatInt(h.Size, 10)
+	h.PAXRecords[paxChunkMap] = strings.Join(values, ",")
+	h.Size = packed
+	return added
+}
+
+// isChunkedEntry checks if an archive entry contains a file stored as chunks
+func isChunkedEntry(h *tar.Header) bool {
+	_, ok := h.PAXRecords[paxChunkMap]
+	return ok
+}
+
+// parseChunkHeader reads and validates the chunk map of a chunked file from its header
+func parseChunkHeader(h *tar.Header) ([]chunk, error) {
+	size, err := strconv.ParseInt(h.PAXRecords[paxChunkSize], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chunk size of %s: %v", h.Name, err)
+	}
+	var chunks []chunk
+	var offset int64
+	for _, value := range strings.Split(h.PAXRecords[paxChunkMap], ",") {
+		if value == "" && size == 0 {
+			continue
+		}
+		digest, length, _ := strings.Cut(value, ":")
+		c := chunk{Offset: offset, Digest: digest}
+		if c.Length, err = strconv.ParseInt(length, 10, 64); err != nil || c.Length <= 0 || c.Length > chunkMaxSize {
+			return nil, fmt.Errorf("invalid chunk map of %s: chunk of %s bytes", h.Name, length)
+		}
+		if raw, err := hex.DecodeString(digest); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("invalid chunk map of %s: invalid digest %s", h.Name, digest)
+		}
+		offset += c.Length
+		chunks = append(chunks, c)
+	}
+	if offset != size {
+		return nil, fmt.Errorf("invalid chunk map of %s: %d bytes mapped, size is %d", h.Name, offset, size)
+	}
+	return chunks, nil
+}
+
+// chunkCache keeps the chunks read from an archive on disk, so later entries can reference them
+type chunkCache struct {
+	dir    string
+	stored map[string]bool
+}
+
+// newChunkCache creates an empty cache in a new temporary directory
+func newChunkCache() (*chunkCache, error) {
+	dir, err := os.MkdirTemp("", "sealpack-chunks-")
+	if err != nil {
+		return nil, err
+	}
+	return &chunkCache{dir: dir, stored: map[string]bool{}}, nil
+}
+
+// remove overwrites and deletes all cached chunks, as they hold plaintext contents
+func (c *chunkCache) remove() error {
+	var errs []error
+	for digest := range c.stored {
+		f, err := os.OpenFile(filepath.Join(c.dir, digest), os.O_RDWR, 0)
+		if err == nil {
+			err = removeTempFile(f)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(append(errs, os.RemoveAll(c.dir))...)
+}
+
+// chunkedReader assembles the real contents of a chunked entry from its new chunks and the cache
+type chunkedReader struct {
+	name    string
+	r       io.Reader
+	cache   *chunkCache
+	chunks  []chunk
+	current *bytes.Reader
+}
+
+// newChunkedReader creates a Reader for the real contents of a chunked archive entry
+func newChunkedReader(h *tar.Header, r io.Reader, cache *chunkCache) (io.Reader, error) {
+	chunks, err := parseChunkHeader(h)
+	if err != nil {
+		return nil, corruptEnvelope(err)
+	}
+	return &chunkedReader{name: h.Name, r: r, cache: cache, chunks: chunks}, nil
+}
+
+// Read implements io.Reader
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.current == nil || c.current.Len() == 0 {
+		if len(c.chunks) == 0 {
+			return 0, io.EOF
+		}
+		data, err := c.load(c.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		c.chunks = c.chunks[1:]
+		c.current = bytes.NewReader(data)
+	}
+	return c.current.Read(p)
+}
+
+// load reads a chunk from the cache, or from the entry if it is new, verifying and caching it
+func (c *chunkedReader) load(ch chunk) ([]byte, error) {
+	path := filepath.Join(c.cache.dir, ch.Digest)
+	if c.cache.stored[ch.Digest] {
+		return os.ReadFile(path)
+	}
+	data := make([]byte, ch.Length)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, fmt.Errorf("chunk %s of %s: %w", ch.Digest, c.name, io.ErrUnexpectedEOF)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != ch.Digest {
+		return nil, corruptEnvelope(fmt.Errorf("chunk %s of %s does not match its digest", ch.Digest, c.name))
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	c.cache.stored[ch.Digest] = true
+	return data, nil
+}
@@ -0,0 +1,134 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// randomBytes provides reproducible incompressible contents
+func randomBytes(seed int64, size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestChunkFile(t *testing.T) {
+	data := randomBytes(1, 4<<20)
+	chunks, err := chunkFile(bytes.NewReader(data))
+	assert.NoError(t, err)
+	var offset int64
+	for i, c := range chunks {
+		assert.Equal(t, offset, c.Offset)
+		assert.LessOrEqual(t, c.Length, int64(chunkMaxSize))
+		if i < len(chunks)-1 {
+			assert.GreaterOrEqual(t, c.Length, int64(chunkMinSize))
+		}
+		offset += c.Length
+	}
+	assert.Equal(t, int64(len(data)), offset)
+
+	// Boundaries depend on the contents, so inserting bytes only changes the chunks around the insertion
+	shifted, err := chunkFile(bytes.NewReader(append([]byte("inserted"), data...)))
+	assert.NoError(t, err)
+	digests := map[string]bool{}
+	for _, c := range chunks {
+		digests[c.Digest] = true
+	}
+	shared := 0
+	for _, c := range shifted {
+		if digests[c.Digest] {
+			shared++
+		}
+	}
+	assert.GreaterOrEqual(t, shared, len(chunks)-2)
+}
+
+func TestChunks_SealUnseal(t *testing.T) {
+	dir := t.TempDir()
+	release1 := randomBytes(2, 2<<20)
+	release2 := append(bytes.Clone(release1[:1<<20]), append([]byte("patched"), release1[1<<20:]...)...)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "rootfs-1.img"), release1, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "rootfs-2.img"), release2, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "empty"), nil, 0644))
+
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.Dedup = true
+	sig := NewSignatureList("SHA256")
+	assert.NoError(t, arc.AddContents([]string{dir}, nil, sig))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	size, err := arc.Finalize()
+	assert.NoError(t, err)
+	// The second release only adds the chunks around the patch
+	assert.Less(t, size, int64(len(release1)+len(release1)/4))
+
+	out := t.TempDir()
+	ra := openTestArchive(t, arc)
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""))
+	for name, expected := range map[string][]byte{"rootfs-1.img": release1, "rootfs-2.img": release2, "empty": nil} {
+		contents, err := os.ReadFile(filepath.Join(out, filepath.Base(dir), name))
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(expected, contents), name)
+	}
+	assert.Nil(t, ra.chunks)
+
+	headers, err := openTestArchive(t, arc).List()
+	assert.NoError(t, err)
+	assert.Contains(t, ListString(headers), "rootfs-2.img (2097159 Bytes)")
+}
+
+func TestChunkedReader_Corrupt(t *testing.T) {
+	data := []byte("contents")
+	h := &tar.Header{Name: "foo", Size: int64(len(data))}
+	setChunkHeader(h, []chunk{{Length: int64(len(data)), Digest: strings.Repeat("00", 32)}}, map[string]bool{})
+	cache, err := newChunkCache()
+	assert.NoError(t, err)
+	defer cache.remove()
+	r, err := newChunkedReader(h, bytes.NewReader(data), cache)
+	assert.NoError(t, err)
+	_, err = r.Read(make([]byte, 16))
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+}
+
+func TestParseChunkHeader_Invalid(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	tests := []struct {
+		name   string
+		size   string
+		layout string
+		want   string
+	}{
+		{"invalid size", "x", digest + ":5", "invalid chunk size"},
+		{"invalid length", "5", digest + ":x", "chunk of x bytes"},
+		{"too large", "1048576", digest + ":1048576", "chunk of 1048576 bytes"},
+		{"invalid digest", "5", "abc:5", "invalid digest abc"},
+		{"size mismatch", "10", digest + ":5", "5 bytes mapped, size is 10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &tar.Header{Name: "foo", PAXRecords: map[string]string{paxChunkSize: tt.size, paxChunkMap: tt.layout}}
+			_, err := parseChunkHeader(h)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
@@ -27,8 +27,13 @@ import (
 var (
 	createKmsSigner   = aws.CreateKmsSigner
 	createKmsVerifier = aws.CreateKmsVerifier
+	// ConfigureAWS sets the options of the sessions used for KMS keys, S3 outputs and Secrets Manager
+	ConfigureAWS = aws.Configure
 )
 
+// AWSConfig holds the region, profile, endpoint and role of the AWS sessions
+type AWSConfig = aws.Config
+
 const (
 	DefaultRegistry = "docker.io"
 )
@@ -40,10 +45,27 @@ type PackageContent interface {
 // ReadConfiguration searches for the latest configuration file and reads the contents.
 // The contents are parsed as a slice of PackageContent from a JSON or YAML file.
 func ReadConfiguration(fileName string, files *[]string, images *[]*ContainerImage) error {
-	data, err := os.ReadFile(fileName)
+	profile, err := ReadContentProfile(fileName, "", nil)
 	if err != nil {
 		return err
 	}
+	if profile.Files != nil {
+		*files = EntryNames(profile.Files)
+	}
+	if profile.Images != nil {
+		*images = ParseContainerImages(profile.Images, profile.Tag)
+	}
+	return nil
+}
+
+// ReadContentProfile reads a contents file from JSON or YAML and merges the common contents with a named profile.
+// Files and images of the profile are added to the common ones. An empty profile name returns the common contents only.
+// All entries of the result are expanded using ExpandVariables.
+func ReadContentProfile(fileName, profile string, vars map[string]string) (*ContentProfile, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
 	var contents ArchiveContents
 	switch strings.ToLower(filepath.Ext(fileName)) {
 	case ".json":
@@ -56,17 +78,104 @@ func ReadConfiguration(fileName string, files *[]string, images *[]*ContainerIma
 		err = fmt.Errorf("invalid file type: %s", filepath.Ext(fileName))
 	}
 	if err != nil {
+		return nil, err
+	}
+	result := &ContentProfile{
+		Files:        contents.Files,
+		Images:       contents.Images,
+		RestartUnits: contents.RestartUnits,
+	}
+	if profile != "" {
+		selected, ok := contents.Profiles[profile]
+		if !ok || selected == nil {
+			return nil, fmt.Errorf("profile '%s' not found in %s", profile, fileName)
+		}
+		result.Files = append(result.Files, selected.Files...)
+		result.Images = append(result.Images, selected.Images...)
+		result.RestartUnits = append(result.RestartUnits, selected.RestartUnits...)
+		result.Recipients = selected.Recipients
+		result.Output = selected.Output
+		result.Tag = selected.Tag
+	}
+	if err = result.expand(vars); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// expand replaces all variables in the entries of a ContentProfile
+func (p *ContentProfile) expand(vars map[string]string) (err error) {
+	for _, list := range [][]ContentEntry{p.Files, p.Images} {
+		for i := range list {
+			if list[i].Name, err = ExpandVariables(list[i].Name, vars); err != nil {
+				return err
+			}
+		}
+	}
+	for _, list := range [][]string{p.Recipients, p.RestartUnits} {
+		for i := range list {
+			if list[i], err = ExpandVariables(list[i], vars); err != nil {
+				return err
+			}
+		}
+	}
+	if p.Output, err = ExpandVariables(p.Output, vars); err != nil {
 		return err
 	}
-	if contents.Files != nil {
-		*files = contents.Files
+	p.Tag, err = ExpandVariables(p.Tag, vars)
+	return err
+}
+
+// ExpandVariables replaces ${VAR} and $VAR in a string. Values from vars take precedence over environment variables.
+// Referencing a variable that is defined in neither is an error.
+func ExpandVariables(value string, vars map[string]string) (string, error) {
+	var missing []string
+	expanded := os.Expand(value, func(name string) string {
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		missing = append(missing, name)
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined variables in '%s': %s", value, strings.Join(missing, ", "))
 	}
-	if contents.Images != nil {
-		imgList := make([]*ContainerImage, len(contents.Images))
-		for i := 0; i < len(contents.Images); i++ {
-			imgList[i] = ParseContainerImage(contents.Images[i])
+	return expanded, nil
+}
+
+// ParseContainerImages parses a list of image entries. If a default tag is provided, it is used for all images without tag.
+func ParseContainerImages(entries []ContentEntry, defaultTag string) []*ContainerImage {
+	images := make([]*ContainerImage, len(entries))
+	for i, entry := range entries {
+		images[i] = ParseContainerImage(entry.Name)
+		images[i].Digest = entry.Digest
+		if defaultTag != "" && !strings.Contains(entry.Name[strings.LastIndex(entry.Name, "/")+1:], ":") {
+			images[i].Tag = defaultTag
 		}
-		*images = imgList
 	}
-	return nil
+	return images
+}
+
+// FileDigests collects the expected digests of all file entries, identified by their absolute path.
+// Entries of registered content providers, e.g. URLs, are identified by their name.
+func FileDigests(entries []ContentEntry) (map[string]string, error) {
+	digests := map[string]string{}
+	for _, entry := range entries {
+		if entry.Digest == "" {
+			continue
+		}
+		if HasContentProvider(entry.Name) {
+			digests[entry.Name] = entry.Digest
+			continue
+		}
+		abs, err := filepath.Abs(entry.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path '%s': %v", entry.Name, err)
+		}
+		digests[abs] = entry.Digest
+	}
+	return digests, nil
 }
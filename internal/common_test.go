@@ -100,3 +100,107 @@ func Test_ReadConfigurationNonExisting(t *testing.T) {
 	configFile := filepath.Join(os.TempDir(), "nonexisting.yaml")
 	assert.ErrorContains(t, ReadConfiguration(configFile, &files, &images), "no such file or directory")
 }
+
+func Test_ReadContentProfile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "content-config.yaml")
+	yamlConfig := []byte(`files:
+- common.txt
+images:
+- alpine
+restart_units:
+- app.service
+profiles:
+  prod:
+    files:
+    - prod.yaml
+    images:
+    - ghcr.io/simatic/sample
+    - busybox:1.36
+    recipients:
+    - prod-device.pem
+    restart_units:
+    - gateway.service
+    output: s3://bucket/prod.ipc
+    tag: v1.0.0
+  dev: {}`)
+	assert.NoError(t, os.WriteFile(configFile, yamlConfig, 0644))
+
+	common, err := ReadContentProfile(configFile, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"common.txt"}, EntryNames(common.Files))
+	assert.Empty(t, common.Recipients)
+
+	prod, err := ReadContentProfile(configFile, "prod", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"common.txt", "prod.yaml"}, EntryNames(prod.Files))
+	assert.Equal(t, []string{"alpine", "ghcr.io/simatic/sample", "busybox:1.36"}, EntryNames(prod.Images))
+	assert.Equal(t, []string{"prod-device.pem"}, prod.Recipients)
+	assert.Equal(t, []string{"app.service", "gateway.service"}, prod.RestartUnits)
+	assert.Equal(t, "s3://bucket/prod.ipc", prod.Output)
+	assert.Equal(t, "v1.0.0", prod.Tag)
+
+	dev, err := ReadContentProfile(configFile, "dev", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alpine"}, EntryNames(dev.Images))
+
+	_, err = ReadContentProfile(configFile, "staging", nil)
+	assert.ErrorContains(t, err, "profile 'staging' not found")
+}
+
+func Test_ParseContainerImages(t *testing.T) {
+	images := ParseContainerImages([]ContentEntry{{Name: "alpine"}, {Name: "busybox:1.36"}, {Name: "localhost.local:5000/foo", Digest: "sha256:abc"}}, "v2")
+	assert.Equal(t, "v2", images[0].Tag)
+	assert.Equal(t, "1.36", images[1].Tag)
+	assert.Equal(t, "v2", images[2].Tag)
+	assert.Equal(t, "sha256:abc", images[2].Digest)
+	assert.Equal(t, "latest", ParseContainerImages([]ContentEntry{{Name: "alpine"}}, "")[0].Tag)
+}
+
+func Test_ReadContentProfile_Variables(t *testing.T) {
+	t.Setenv("SEALPACK_TEST_REGISTRY", "ghcr.io")
+	t.Setenv("SEALPACK_TEST_VERSION", "v0.0.1")
+	configFile := filepath.Join(t.TempDir(), "content-config.json")
+	jsonConfig := []byte(`{"files":["release-${SEALPACK_TEST_VERSION}.yaml"],"images":["${SEALPACK_TEST_REGISTRY}/simatic/sample:$SEALPACK_TEST_VERSION"]}`)
+	assert.NoError(t, os.WriteFile(configFile, jsonConfig, 0644))
+
+	contents, err := ReadContentProfile(configFile, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"release-v0.0.1.yaml"}, EntryNames(contents.Files))
+	assert.Equal(t, []string{"ghcr.io/simatic/sample:v0.0.1"}, EntryNames(contents.Images))
+
+	contents, err = ReadContentProfile(configFile, "", map[string]string{"SEALPACK_TEST_VERSION": "v1.0.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ghcr.io/simatic/sample:v1.0.0"}, EntryNames(contents.Images))
+}
+
+func Test_ExpandVariables(t *testing.T) {
+	value, err := ExpandVariables("no variables", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "no variables", value)
+	_, err = ExpandVariables("alpine:${SEALPACK_TEST_UNDEFINED}", nil)
+	assert.ErrorContains(t, err, "undefined variables in 'alpine:${SEALPACK_TEST_UNDEFINED}': SEALPACK_TEST_UNDEFINED")
+}
+
+func Test_ReadContentProfile_Digests(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "content-config.json")
+	assert.NoError(t, os.WriteFile(jsonFile, []byte(`{"files":["plain.txt",{"name":"pinned.txt","digest":"sha256:abc"}],"images":[{"name":"alpine:3.17","digest":"sha256:def"}]}`), 0644))
+	yamlFile := filepath.Join(dir, "content-config.yaml")
+	assert.NoError(t, os.WriteFile(yamlFile, []byte(`files:
+- plain.txt
+- name: pinned.txt
+  digest: sha256:abc
+images:
+- name: alpine:3.17
+  digest: sha256:def`), 0644))
+	for _, file := range []string{jsonFile, yamlFile} {
+		contents, err := ReadContentProfile(file, "", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []ContentEntry{{Name: "plain.txt"}, {Name: "pinned.txt", Digest: "sha256:abc"}}, contents.Files)
+		assert.Equal(t, []ContentEntry{{Name: "alpine:3.17", Digest: "sha256:def"}}, contents.Images)
+		digests, err := FileDigests(contents.Files)
+		assert.NoError(t, err)
+		abs, _ := filepath.Abs("pinned.txt")
+		assert.Equal(t, map[string]string{abs: "sha256:abc"}, digests)
+	}
+}
@@ -0,0 +1,79 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ConflictPolicy decides how Unpack handles files already present in the output path
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite replaces existing files, the default
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkip keeps existing files; their contents in the package are only verified
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictBackup moves existing files to a copy with BackupSuffix before replacing them
+	ConflictBackup ConflictPolicy = "backup"
+	// ConflictFail aborts unsealing at the first existing file
+	ConflictFail ConflictPolicy = "fail"
+	// BackupSuffix is appended to the names of files moved aside by ConflictBackup
+	BackupSuffix = ".bak"
+)
+
+// ParseConflictPolicy parses the name of a ConflictPolicy, an empty name is ConflictOverwrite
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(name); policy {
+	case "":
+		return ConflictOverwrite, nil
+	case ConflictOverwrite, ConflictSkip, ConflictBackup, ConflictFail:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid conflict policy %q, allowed values are 'skip', 'overwrite', 'backup' and 'fail'", name)
+}
+
+// resolveConflict applies the ConflictPolicy of the archive to a file about to be written.
+// It returns true if the existing file is kept, so its contents must only be read.
+func (arc *ReadArchive) resolveConflict(fullFile string) (keep bool, err error) {
+	if arc.OnConflict == "" || arc.OnConflict == ConflictOverwrite {
+		return false, nil
+	}
+	if _, err = os.Lstat(fullFile); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	switch arc.OnConflict {
+	case ConflictSkip:
+		arc.logger().Infof("unseal: skipping %s, which exists already", fullFile)
+		return true, nil
+	case ConflictBackup:
+		arc.logger().Infof("unseal: moving existing %s to %s", fullFile, fullFile+BackupSuffix)
+		return false, os.Rename(fullFile, fullFile+BackupSuffix)
+	default:
+		return false, abortUnpack(fmt.Errorf("unseal: %s exists already", fullFile))
+	}
+}
+
+// skipFile reads the contents of a file kept by resolveConflict, so they are still verified
+func skipFile(r io.Reader) error {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	_, err := io.CopyBuffer(io.Discard, r, *buf)
+	return err
+}
@@ -0,0 +1,82 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"archive/tar"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	for name, expected := range map[string]ConflictPolicy{"": ConflictOverwrite, "overwrite": ConflictOverwrite, "skip": ConflictSkip, "backup": ConflictBackup, "fail": ConflictFail} {
+		policy, err := ParseConflictPolicy(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, policy)
+	}
+	_, err := ParseConflictPolicy("rename")
+	assert.ErrorContains(t, err, "invalid conflict policy")
+}
+
+func TestReadArchive_StoreFileOnConflict(t *testing.T) {
+	contents := "new contents"
+	tests := []struct {
+		policy  ConflictPolicy
+		want    string
+		backup  string
+		wantErr string
+	}{
+		{policy: "", want: contents},
+		{policy: ConflictOverwrite, want: contents},
+		{policy: ConflictSkip, want: "old contents"},
+		{policy: ConflictBackup, want: contents, backup: "old contents"},
+		{policy: ConflictFail, want: "old contents", wantErr: "exists already"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			fullFile := filepath.Join(t.TempDir(), "config.yaml")
+			assert.NoError(t, os.WriteFile(fullFile, []byte("old contents"), 0644))
+			arc := &ReadArchive{OnConflict: tt.policy}
+			h := &tar.Header{Name: "config.yaml", Size: int64(len(contents))}
+			reader := strings.NewReader(contents)
+			err := arc.storeFile(h, reader, fullFile)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				// Kept files are still read completely, so their contents are verified
+				assert.Zero(t, reader.Len())
+			}
+			written, err := os.ReadFile(fullFile)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(written))
+			backup, err := os.ReadFile(fullFile + BackupSuffix)
+			if tt.backup == "" {
+				assert.ErrorIs(t, err, os.ErrNotExist)
+			} else {
+				assert.Equal(t, tt.backup, string(backup))
+			}
+
+			// Files not present yet are always written
+			newFile := filepath.Join(filepath.Dir(fullFile), "new.yaml")
+			assert.NoError(t, arc.storeFile(h, strings.NewReader(contents), newFile))
+			written, err = os.ReadFile(newFile)
+			assert.NoError(t, err)
+			assert.Equal(t, contents, string(written))
+		})
+	}
+}
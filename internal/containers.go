@@ -15,73 +15,233 @@ package internal
  */
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 const (
 	TmpFolderName          = "crane.dl"
-	ContainerDSocketFolder = "/run"
-	ContainerDSocketFile   = "containerd.sock"
 	LocalContainerRegistry = "local"
+	// leaseExpiration limits how long a lease of an aborted unseal protects imported contents from garbage collection
+	leaseExpiration = 24 * time.Hour
 )
 
 var (
-	ContainerDSocket  = ""
-	containerDClient  *containerd.Client
-	containerDContext context.Context
+	ContainerDSocket    = ""
+	containerDClient    *containerd.Client
+	containerDContext   context.Context
+	pullImage           = crane.Pull
+	existingImageDigest = ImageDigest
 )
 
-// GetContainerDSocket searched for a containerD socket in the /run folder
-func GetContainerDSocket() (string, error) {
-	if ContainerDSocket == "" {
-		err := filepath.Walk(ContainerDSocketFolder, func(path string, info fs.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if strings.HasSuffix(path, ContainerDSocketFile) && (info.Mode()&os.ModeSocket) > 0 {
-				ContainerDSocket = path
-				return io.EOF
-			}
-			return nil
-		})
-		if err != nil && err != io.EOF {
-			return "", err
-		}
-	}
-	return ContainerDSocket, nil
+// ImageSource configures where images are read from
+type ImageSource struct {
+	// StagingDir holds pre-staged images read instead of pulling, see StagedImage
+	StagingDir string
+	// Cache stores the layers of pulled images, may be nil
+	Cache *LayerCache
 }
 
 // SaveImage with from a registry to a local OCI file.
-func SaveImage(img *ContainerImage) (result *os.File, err error) {
-	tmpdir := filepath.Join(os.TempDir(), TmpFolderName, img.ToFileName())
+func SaveImage(img *ContainerImage, source ImageSource) (*os.File, error) {
+	result, _, err := saveImage(img, source)
+	return result, err
+}
+
+// saveImage stores an image like SaveImage and provides the digest of its manifest
+func saveImage(img *ContainerImage, source ImageSource) (result *os.File, digest string, err error) {
+	tmpdir := filepath.Join(os.TempDir(), TmpFolderName, localFileName(img.ToFileName()))
 	if err = os.MkdirAll(filepath.Dir(tmpdir), 0777); err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	image, err := crane.Pull(img.String())
+	image, err := loadImage(img, source)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	if err = crane.Save(image, img.String(), tmpdir); err != nil {
-		return nil, err
+	hash, err := image.Digest()
+	if err != nil {
+		return nil, "", wrapNetworkError(err)
+	}
+	digest = hash.String()
+	if img.Digest != "" && digest != img.Digest {
+		if err = matchIndexDigest(img, source, digest); err != nil {
+			return nil, "", err
+		}
+	}
+	tag, err := img.ImportTag()
+	if err != nil {
+		return nil, "", err
+	}
+	if err = crane.Save(image, tag.String(), tmpdir); err != nil {
+		return nil, "", err
+	}
+	if err = normalizeImageArchive(tmpdir); err != nil {
+		return nil, "", fmt.Errorf("image %s: failed normalizing archive: %w", img, err)
 	}
 	if result, err = os.Open(tmpdir); err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return result, err
+	return result, digest, nil
+}
+
+// matchIndexDigest checks a pinned digest that differs from the one of the pulled image manifest.
+// For multi-platform images, the pinned digest may be the one of the image index the manifest was resolved from,
+// as shown by `crane digest` for its tag; staged images have no index.
+func matchIndexDigest(img *ContainerImage, source ImageSource, digest string) error {
+	mismatch := fmt.Errorf("image %s: digest mismatch: expected %s, got %s", img, img.Digest, digest)
+	if source.StagingDir != "" {
+		return mismatch
+	}
+	raw, err := fetchManifest(img.String(), registryOptions()...)
+	if err != nil {
+		return wrapNetworkError(err)
+	}
+	if hash, _, err := v1.SHA256(bytes.NewReader(raw)); err != nil || hash.String() != img.Digest {
+		return mismatch
+	}
+	index, err := v1.ParseIndexManifest(bytes.NewReader(raw))
+	if err != nil {
+		return mismatch
+	}
+	for _, manifest := range index.Manifests {
+		if manifest.Digest.String() == digest {
+			return nil
+		}
+	}
+	return mismatch
+}
+
+// normalizeImageArchive rewrites an image archive with its entries sorted by name and without timestamps, owners
+// or varying modes, so the TOC hash of an unchanged image stays stable across pulls and sealpack versions
+func normalizeImageArchive(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	entries, normalized, err := readImageArchive(f)
+	if err != nil || normalized {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	out, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(out.Name()) }()
+	tw := tar.NewWriter(out)
+	for _, e := range entries {
+		if err = writeImageEntry(tw, e.name, e.size, io.NewSectionReader(f, e.offset, e.size)); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
+
+// imageArchiveEntry locates the data of an entry in an image archive
+type imageArchiveEntry struct {
+	name   string
+	offset int64
+	size   int64
+}
+
+// readImageArchive locates all entries of an image archive.
+// It reports whether the archive is normalized already, i.e. stored exactly like normalizeImageArchive writes it.
+func readImageArchive(f *os.File) (entries []imageArchiveEntry, normalized bool, err error) {
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	normalized = true
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return entries, normalized, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			return nil, false, fmt.Errorf("unexpected entry %s", h.Name)
+		}
+		// The data of an entry starts right after its headers, tar.Reader does not read ahead
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, false, err
+		}
+		normalized = normalized && h.Mode == 0644 && h.ModTime.Unix() == 0 && h.Uid == 0 && h.Gid == 0 && h.Uname == "" && h.Gname == "" &&
+			(len(entries) == 0 || entries[len(entries)-1].name < h.Name)
+		entries = append(entries, imageArchiveEntry{name: h.Name, offset: offset, size: h.Size})
+	}
+}
+
+// writeImageEntry adds an entry to a normalized image archive
+func writeImageEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0644}); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// EstimateImageSize fetches only the manifest of an image and estimates the size of its OCI archive.
+// No layers are downloaded.
+func EstimateImageSize(img *ContainerImage, source ImageSource) (int64, error) {
+	image, err := loadImage(img, source)
+	if err != nil {
+		return 0, err
+	}
+	manifest, err := image.Manifest()
+	if err != nil {
+		return 0, wrapNetworkError(err)
+	}
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+// loadImage provides an image from its registry, or from the staging directory of the source if set
+func loadImage(img *ContainerImage, source ImageSource) (v1.Image, error) {
+	if source.StagingDir != "" {
+		return StagedImage(source.StagingDir, img)
+	}
+	image, err := pullImage(img.String(), registryOptions()...)
+	if err != nil {
+		return nil, wrapNetworkError(err)
+	}
+	if source.Cache != nil {
+		image = source.Cache.Image(image)
+	}
+	return image, nil
 }
 
 // CleanupImages removes the temp folder where container images are stored.
@@ -89,10 +249,16 @@ func CleanupImages() error {
 	return os.RemoveAll(filepath.Join(os.TempDir(), TmpFolderName))
 }
 
-// ParseContainerImage takes a string describing an image and parses the registry, name and tag out of it.
+// ParseContainerImage takes a string describing an image and parses the registry, name, tag and digest out of it.
+// Images referenced by digest only have no tag; otherwise the tag defaults to latest.
 func ParseContainerImage(name string) *ContainerImage {
-	name = strings.TrimPrefix(name, "/")
+	name = strings.TrimPrefix(strings.TrimSuffix(name, OCISuffix), "/")
 	registry := DefaultRegistry
+	digest := ""
+	if at := strings.LastIndex(name, "@"); at >= 0 {
+		digest = name[at+1:]
+		name = name[:at]
+	}
 	// Pattern tries to find a domain in the image name ('.'-separated string with '/' only at the end)
 	regPattern := regexp.MustCompile("^([^/]+(\\.[^/]+)+)/")
 	regDomain := regPattern.FindString(name)
@@ -101,14 +267,19 @@ func ParseContainerImage(name string) *ContainerImage {
 		registry = name[:firstSlash]
 		name = name[firstSlash+1:]
 	}
-	imgParts := strings.Split(strings.TrimSuffix(name, OCISuffix), ":")
+	imgParts := strings.Split(name, ":")
 	if len(imgParts) < 2 {
-		imgParts = append(imgParts, "latest")
+		tag := "latest"
+		if digest != "" {
+			tag = ""
+		}
+		imgParts = append(imgParts, tag)
 	}
 	return &ContainerImage{
 		Registry: registry,
 		Name:     imgParts[0],
 		Tag:      imgParts[1],
+		Digest:   digest,
 	}
 }
 
@@ -122,7 +293,7 @@ func getContainerDClient(namespace string) (*containerd.Client, context.Context,
 		if err != nil {
 			return nil, nil, err
 		}
-		if _, err = os.Stat(sock); os.IsNotExist(err) || os.IsPermission(err) {
+		if err = containerDAvailable(sock); err != nil {
 			return nil, nil, err
 		}
 		containerDClient, err = containerd.New(sock)
@@ -143,54 +314,176 @@ func getContainerDClient(namespace string) (*containerd.Client, context.Context,
 }
 
 // ImportImage imports one OCI image into a local containerd storage or a provided registry.
-func ImportImage(namespace, targetRegistry string, tarReader io.ReadCloser, tag *name.Tag) (newImport bool, err error) {
+// Local imports are protected from garbage collection by the lease until it is committed or aborted.
+// The digest of the imported image is returned, so it can be referenced unambiguously.
+func ImportImage(namespace, targetRegistry string, tarReader io.ReadCloser, tag *name.Tag, lease *ImportLease) (digest string, newImport bool, err error) {
 	switch targetRegistry {
 	case LocalContainerRegistry:
-		return importLocal(namespace, tarReader, tag)
+		return importLocal(namespace, tarReader, tag, lease)
 	default:
 		return importToRegistry(targetRegistry, tarReader, tag)
 	}
 }
 
+// ImageDigest looks up the manifest digest a tag currently has in the local containerd namespace or the target
+// registry, so importing an image with it would re-tag an existing image. It is empty if the tag does not exist.
+func ImageDigest(namespace, targetRegistry string, tag name.Tag) (string, error) {
+	if targetRegistry == LocalContainerRegistry {
+		client, ctx, err := getContainerDClient(namespace)
+		if err != nil {
+			return "", err
+		}
+		img, err := client.ImageService().Get(ctx, tag.Name())
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				return "", nil
+			}
+			return "", err
+		}
+		return img.Target.Digest.String(), nil
+	}
+	target, err := name.NewRepository(targetRegistry)
+	if err != nil {
+		return "", err
+	}
+	tag.Repository = target
+	digest, err := crane.Digest(tag.Name())
+	if err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", wrapNetworkError(err)
+	}
+	return digest, nil
+}
+
+// RetaggedImages provides the tags that importing the image entries would move away from an existing image.
+// Tags already pointing to the digest recorded for an image at seal time are left out, as importing it changes nothing.
+func RetaggedImages(images []*tar.Header, namespace, targetRegistry string) ([]string, error) {
+	var tags []string
+	for _, h := range images {
+		tag, err := ParseContainerImage(strings.TrimPrefix(h.Name, ContainerImagePrefix+"/")).ImportTag()
+		if err != nil {
+			return nil, err
+		}
+		existing, err := existingImageDigest(namespace, targetRegistry, tag)
+		if err != nil {
+			return nil, err
+		}
+		if existing != "" && existing != h.PAXRecords[paxImageDigest] {
+			tags = append(tags, tag.Name())
+		}
+	}
+	return tags, nil
+}
+
+// ImageFallbackEnabled tells whether images are stored as OCI files if they cannot be imported into a local containerD
+// instance, as the fallback is enabled or the default of the platform
+func ImageFallbackEnabled(fallback bool) bool {
+	return fallback || localImportFallback
+}
+
 // importLocal imports an image to a locally running containerd instance
-func importLocal(namespace string, tarReader io.ReadCloser, tag *name.Tag) (newImport bool, err error) {
+func importLocal(namespace string, tarReader io.ReadCloser, tag *name.Tag, lease *ImportLease) (digest string, newImport bool, err error) {
 	var oldImg containerd.Image
 	var newImg []images.Image
 	client, ctx, err := getContainerDClient(namespace)
 	if err != nil {
-		return false, err
+		return "", false, err
+	}
+	if lease != nil {
+		ctx = lease.ctx
 	}
 	oldImg, _ = client.GetImage(ctx, tag.Name())
 	newImg, err = client.Import(ctx, tarReader)
 	if err != nil {
 		return
 	}
+	if len(newImg) == 0 {
+		return "", false, fmt.Errorf("no image found in archive of %s", tag.Name())
+	}
+	digest = newImg[0].Target.Digest.String()
 	if oldImg != nil && oldImg.Target().Digest != newImg[0].Target.Digest {
 		newImport = true
 	}
-	err = client.Close()
 	return
 }
 
+// An ImportLease protects contents imported into a local containerD instance from garbage collection,
+// so images are neither collected nor orphaned while an unseal is verified or rolled back.
+type ImportLease struct {
+	ctx   context.Context
+	lease leases.Lease
+	ended bool
+}
+
+// NewImportLease creates a lease in the containerD namespace that imports can be done in
+func NewImportLease(namespace string) (*ImportLease, error) {
+	client, ctx, err := getContainerDClient(namespace)
+	if err != nil {
+		return nil, err
+	}
+	lease, err := client.LeasesService().Create(ctx, leases.WithRandomID(), leases.WithExpiration(leaseExpiration))
+	if err != nil {
+		return nil, err
+	}
+	return &ImportLease{
+		ctx:   leases.WithLease(ctx, lease.ID),
+		lease: lease,
+	}, nil
+}
+
+// Commit releases the lease after a successful import, leaving the imported images to protect their contents
+func (l *ImportLease) Commit() error {
+	return l.end()
+}
+
+// Abort releases the lease after a failed import and lets containerD collect all unreferenced contents immediately
+func (l *ImportLease) Abort() error {
+	return l.end(leases.SynchronousDelete)
+}
+
+// end deletes the lease and closes the containerD client. Ending a lease more than once has no effect.
+func (l *ImportLease) end(opts ...leases.DeleteOpt) (err error) {
+	if l == nil || l.ended {
+		return nil
+	}
+	l.ended = true
+	if err = containerDClient.LeasesService().Delete(l.ctx, l.lease, opts...); err != nil {
+		return err
+	}
+	err = containerDClient.Close()
+	containerDClient, containerDContext = nil, nil
+	return err
+}
+
 // importToRegistry imports a container image into a target registry
-func importToRegistry(targetRegistry string, tarReader io.ReadCloser, tag *name.Tag) (newImport bool, err error) {
+func importToRegistry(targetRegistry string, tarReader io.ReadCloser, tag *name.Tag) (digAfter string, newImport bool, err error) {
 	var img v1.Image
 	var digBefore v1.Hash
-	var digAfter string
-	tag.Repository, err = name.NewRepository(targetRegistry)
-	if err != nil {
+	var target name.Repository
+	if target, err = name.NewRepository(targetRegistry); err != nil {
+		return
+	}
+	// The tarball is opened once per manifest and layer, so the stream is spooled to a temporary file
+	var spool *os.File
+	if spool, err = createTempFile("sealpack-import-*" + OCISuffix); err != nil {
+		return
+	}
+	defer func() { _ = removeTempFile(spool) }()
+	if _, err = io.CopyBuffer(spool, tarReader, make([]byte, copyBufferSize)); err != nil {
 		return
 	}
-	img, err = tarball.Image(func() (io.ReadCloser, error) {
-		return tarReader, nil
-	}, tag)
+	// The image is stored in the tarball by its original tag, so it must be looked up before retagging
+	img, err = tarball.ImageFromPath(spool.Name(), tag)
 	if err != nil {
 		return
 	}
+	tag.Repository = target
 	digBefore, err = img.Digest()
-	err = crane.Push(img, tag.Name())
+	err = crane.Push(img, tag.Name(), registryOptions()...)
 	if err != nil {
-		return
+		return "", false, wrapNetworkError(err)
 	}
 	digAfter, err = crane.Digest(tag.Name())
 	if digBefore.String() != digAfter {
@@ -0,0 +1,71 @@
+//go:build !windows
+
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ContainerDSocketFolder = "/run"
+	ContainerDSocketFile   = "containerd.sock"
+	// localImportFallback defines if images are stored as files without asking if no containerD is available
+	localImportFallback = false
+)
+
+// GetContainerDSocket searched for a containerD socket in the /run folder
+func GetContainerDSocket() (string, error) {
+	if ContainerDSocket == "" {
+		err := filepath.Walk(ContainerDSocketFolder, func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if strings.HasSuffix(path, ContainerDSocketFile) && (info.Mode()&os.ModeSocket) > 0 {
+				ContainerDSocket = path
+				return io.EOF
+			}
+			return nil
+		})
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+	}
+	return ContainerDSocket, nil
+}
+
+// containerDAvailable checks if the containerD socket exists and is accessible
+func containerDAvailable(sock string) error {
+	_, err := os.Stat(sock)
+	return err
+}
+
+// ProbeLocalImport checks if a local containerD instance is available to import images into
+func ProbeLocalImport() error {
+	sock, err := GetContainerDSocket()
+	if err != nil || sock == "" {
+		return fmt.Errorf("%w: no containerd socket found in %s", ErrNoContainerD, ContainerDSocketFolder)
+	}
+	if err = containerDAvailable(sock); err != nil {
+		return fmt.Errorf("%w: %w", ErrNoContainerD, err)
+	}
+	return nil
+}
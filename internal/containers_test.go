@@ -15,12 +15,23 @@ package internal
  */
 
 import (
+	"archive/tar"
+	"bytes"
 	"fmt"
+	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 /*
@@ -53,7 +64,7 @@ func Test_SaveImageAndCleanup(t *testing.T) {
 	assert.Equal(t, "docker.io", ci.Registry)
 	assert.Equal(t, "alpine", ci.Name)
 	assert.Equal(t, "3.17", ci.Tag)
-	file, err := SaveImage(ci)
+	file, err := SaveImage(ci, ImageSource{})
 	assert.NoError(t, err)
 	stat, err := file.Stat()
 	assert.NoError(t, err)
@@ -90,6 +101,34 @@ func Test_ParseContainerImageMin(t *testing.T) {
 	assert.Equal(t, "latest", result.Tag)
 }
 
+func Test_ParseContainerImageDigest(t *testing.T) {
+	digest := "sha256:8914eb54f968791faf6a8638949e480fef81e697984fba772b3976835194c6d4"
+	tests := []struct {
+		input     string
+		tag       string
+		importTag string
+	}{
+		{"ghcr.io/simatic/sample@" + digest, "", "ghcr.io/simatic/sample:sha256-8914eb54f968791faf6a8638949e480fef81e697984fba772b3976835194c6d4"},
+		{"ghcr.io/simatic/sample:v0.0.1@" + digest, "v0.0.1", "ghcr.io/simatic/sample:v0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result := ParseContainerImage(tt.input)
+			assert.Equal(t, "ghcr.io", result.Registry)
+			assert.Equal(t, "simatic/sample", result.Name)
+			assert.Equal(t, tt.tag, result.Tag)
+			assert.Equal(t, digest, result.Digest)
+			assert.Equal(t, tt.input, result.String())
+			assert.Equal(t, filepath.Join(ContainerImagePrefix, tt.input+OCISuffix), result.ToFileName())
+			// File names in the archive must parse back to the same image
+			assert.Equal(t, result, ParseContainerImage(strings.TrimPrefix(result.ToFileName(), ContainerImagePrefix+"/")))
+			tag, err := result.ImportTag()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.importTag, tag.String())
+		})
+	}
+}
+
 func TestRemoveAll(t *testing.T) {
 	tagsList := tagList{}
 	for _, s := range []string{"cr.example.com/foobar:latest", "cr.example.com/foobar:v1", "cr.example.com/fnord:latest"} {
@@ -188,3 +227,255 @@ func Test_contains(t *testing.T) {
 		})
 	}
 }
+
+func Test_SaveImage_PinnedDigest(t *testing.T) {
+	oldPull, oldFetch := pullImage, fetchManifest
+	defer func() { pullImage, fetchManifest = oldPull, oldFetch }()
+	img, err := random.Image(64, 1)
+	assert.NoError(t, err)
+	pullImage = func(src string, opt ...crane.Option) (v1.Image, error) {
+		return img, nil
+	}
+	fetchManifest = func(src string, opt ...crane.Option) ([]byte, error) {
+		return img.RawManifest()
+	}
+	digest, err := img.Digest()
+	assert.NoError(t, err)
+	defer CleanupImages()
+
+	pinned := ParseContainerImage("alpine:3.17")
+	pinned.Digest = digest.String()
+	f, err := SaveImage(pinned, ImageSource{})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	pinned.Digest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	_, err = SaveImage(pinned, ImageSource{})
+	assert.ErrorContains(t, err, "digest mismatch")
+}
+
+func TestSaveImage_PinnedIndexDigest(t *testing.T) {
+	oldPull, oldFetch := pullImage, fetchManifest
+	defer func() { pullImage, fetchManifest = oldPull, oldFetch }()
+	index, err := random.Index(64, 1, 2)
+	assert.NoError(t, err)
+	manifest, err := index.IndexManifest()
+	assert.NoError(t, err)
+	// The platform image is resolved from the index
+	pullImage = func(src string, opt ...crane.Option) (v1.Image, error) {
+		return index.Image(manifest.Manifests[1].Digest)
+	}
+	fetchManifest = func(src string, opt ...crane.Option) ([]byte, error) {
+		return index.RawManifest()
+	}
+	indexDigest, err := index.Digest()
+	assert.NoError(t, err)
+	defer CleanupImages()
+
+	pinned := ParseContainerImage("alpine:3.17")
+	pinned.Digest = indexDigest.String()
+	f, err := SaveImage(pinned, ImageSource{})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	// Neither another digest nor the index digest for staged images is accepted
+	pinned.Digest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	_, err = SaveImage(pinned, ImageSource{})
+	assert.ErrorContains(t, err, "digest mismatch")
+	pinned.Digest = indexDigest.String()
+	assert.ErrorContains(t, matchIndexDigest(pinned, ImageSource{StagingDir: t.TempDir()}, manifest.Manifests[1].Digest.String()), "digest mismatch")
+}
+
+func TestNormalizeImageArchive(t *testing.T) {
+	img, err := random.Image(64, 2)
+	assert.NoError(t, err)
+	tag, err := name.NewTag("registry.example.com/app:1.0")
+	assert.NoError(t, err)
+	saved := filepath.Join(t.TempDir(), "saved.tar")
+	assert.NoError(t, tarball.WriteToFile(saved, tag, img))
+
+	// The same entries in reverse order and with varying metadata
+	var entries []*tar.Header
+	var contents [][]byte
+	f, err := os.Open(saved)
+	assert.NoError(t, err)
+	tr := tar.NewReader(f)
+	for h, err := tr.Next(); err == nil; h, err = tr.Next() {
+		data, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		entries, contents = append([]*tar.Header{h}, entries...), append([][]byte{data}, contents...)
+	}
+	assert.NoError(t, f.Close())
+	shuffled := filepath.Join(t.TempDir(), "shuffled.tar")
+	out, err := os.Create(shuffled)
+	assert.NoError(t, err)
+	tw := tar.NewWriter(out)
+	for i, h := range entries {
+		h.ModTime, h.Uid, h.Uname, h.Mode = time.Now(), 1000, "builder", 0600
+		assert.NoError(t, tw.WriteHeader(h))
+		_, err = tw.Write(contents[i])
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, out.Close())
+
+	assert.NoError(t, normalizeImageArchive(saved))
+	assert.NoError(t, normalizeImageArchive(shuffled))
+	want, err := os.ReadFile(saved)
+	assert.NoError(t, err)
+	got, err := os.ReadFile(shuffled)
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+	normalized, err := tarball.ImageFromPath(saved, &tag)
+	assert.NoError(t, err)
+	wantDigest, _ := img.Digest()
+	gotDigest, err := normalized.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, wantDigest, gotDigest)
+}
+
+// createImageArchive creates a signed archive containing a single file and an image
+func createImageArchive(t *testing.T) string {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	t.Cleanup(func() { _ = arc.Cleanup() })
+	for name, contents := range map[string][]byte{
+		"files/a.txt": []byte("file contents"),
+		ParseContainerImage("registry.example.com/app:1.0").ToFileName(): []byte("image contents"),
+	} {
+		assert.NoError(t, arc.AddToArchive(name, contents))
+		assert.NoError(t, sig.AddFile(name, contents))
+	}
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+	return arc.outFile.Name()
+}
+
+func TestReadArchive_Images(t *testing.T) {
+	f, err := os.Open(createImageArchive(t))
+	assert.NoError(t, err)
+	defer f.Close()
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	images, err := ra.Images()
+	assert.NoError(t, err)
+	assert.Len(t, images, 1)
+	assert.Equal(t, ParseContainerImage("registry.example.com/app:1.0").ToFileName(), images[0].Name)
+}
+
+func TestImageFallbackEnabled(t *testing.T) {
+	assert.True(t, ImageFallbackEnabled(true))
+	assert.Equal(t, localImportFallback, ImageFallbackEnabled(false))
+}
+
+func TestReadArchive_UnpackWithoutContainerD(t *testing.T) {
+	defer func(sock string) { ContainerDSocket = sock }(ContainerDSocket)
+	ContainerDSocket = filepath.Join(t.TempDir(), "containerd.sock")
+	archive := createImageArchive(t)
+
+	for _, fallback := range []bool{true, false} {
+		t.Run(fmt.Sprintf("fallback=%t", fallback), func(t *testing.T) {
+			if !fallback && localImportFallback {
+				t.Skip("images are always stored as files on this platform")
+			}
+			f, err := os.Open(archive)
+			assert.NoError(t, err)
+			defer f.Close()
+			ra, err := OpenArchiveReader(f, 0)
+			assert.NoError(t, err)
+			ra.ImageFallback = fallback
+			ra.Report = NewReport("unseal", "test", "SHA256")
+			out := t.TempDir()
+			err = ra.Unpack("../test/public.pem", "SHA256", out, "default", LocalContainerRegistry)
+			if !fallback {
+				assert.ErrorIs(t, err, ErrPartialImport)
+				assert.ErrorIs(t, err, ErrNoContainerD)
+				return
+			}
+			assert.NoError(t, err)
+			contents, err := os.ReadFile(filepath.Join(out, localFileName(ParseContainerImage("registry.example.com/app:1.0").ToFileName())))
+			assert.NoError(t, err)
+			assert.Equal(t, "image contents", string(contents))
+			for _, entry := range ra.Report.Entries {
+				if entry.Type == ReportTypeImage {
+					assert.Equal(t, ImportStatusStored, entry.ImportStatus)
+				}
+			}
+		})
+	}
+}
+
+func TestImportLease_Ended(t *testing.T) {
+	var lease *ImportLease
+	assert.NoError(t, lease.Commit())
+	assert.NoError(t, lease.Abort())
+	// A lease that already ended must not access containerD again
+	lease = &ImportLease{ended: true}
+	assert.NoError(t, lease.Commit())
+	assert.NoError(t, lease.Abort())
+}
+
+func TestImportImage_Registry(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	img, err := random.Image(1024, 2)
+	assert.NoError(t, err)
+	tag, err := name.NewTag("registry.example.com/app:1.0")
+	assert.NoError(t, err)
+	buf := new(bytes.Buffer)
+	assert.NoError(t, tarball.Write(tag, img, buf))
+
+	target := strings.TrimPrefix(server.URL, "http://") + "/app"
+	digest, _, err := ImportImage("", target, io.NopCloser(buf), &tag, nil)
+	assert.NoError(t, err)
+	expected, err := img.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, expected.String(), digest)
+	assert.Equal(t, target+":1.0", tag.Name())
+}
+
+func TestImageDigest_Registry(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	target := strings.TrimPrefix(server.URL, "http://") + "/app"
+	img, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, crane.Push(img, target+":1.0"))
+	pushed, err := img.Digest()
+	assert.NoError(t, err)
+
+	for ref, expected := range map[string]string{"registry.example.com/app:1.0": pushed.String(), "registry.example.com/app:2.0": ""} {
+		tag, err := name.NewTag(ref)
+		assert.NoError(t, err)
+		digest, err := ImageDigest("", target, tag)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, digest, ref)
+	}
+}
+
+func TestRetaggedImages(t *testing.T) {
+	defer func() { existingImageDigest = ImageDigest }()
+	existingImageDigest = func(_, _ string, tag name.Tag) (string, error) {
+		return map[string]string{"1.0": "sha256:aaa", "2.0": "sha256:bbb"}[tag.TagStr()], nil
+	}
+	header := func(ref, digest string) *tar.Header {
+		h := &tar.Header{Name: ParseContainerImage(ref).ToFileName()}
+		if digest != "" {
+			h.PAXRecords = map[string]string{paxImageDigest: digest}
+		}
+		return h
+	}
+	tags, err := RetaggedImages([]*tar.Header{
+		// Unchanged digest
+		header("registry.example.com/app:1.0", "sha256:aaa"),
+		// Changed digest
+		header("registry.example.com/app:2.0", "sha256:ccc"),
+		// Not present yet
+		header("registry.example.com/app:3.0", "sha256:ddd"),
+		// Sealed without a recorded digest
+		header("registry.example.com/other:1.0", ""),
+	}, "", "registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"registry.example.com/app:2.0", "registry.example.com/other:1.0"}, tags)
+}
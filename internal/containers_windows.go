@@ -0,0 +1,60 @@
+//go:build windows
+
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"github.com/Microsoft/go-winio"
+	"time"
+)
+
+const (
+	// ContainerDPipe is the named pipe a containerD instance on Windows listens on by default
+	ContainerDPipe = `\\.\pipe\containerd-containerd`
+	// containerDDialTimeout limits how long probing the named pipe may take
+	containerDDialTimeout = 2 * time.Second
+	// localImportFallback defines if images are stored as files without asking if no containerD is available.
+	// Windows build agents often run without containerD, so this is the default there.
+	localImportFallback = true
+)
+
+// GetContainerDSocket returns the named pipe of the local containerD instance
+func GetContainerDSocket() (string, error) {
+	if ContainerDSocket == "" {
+		ContainerDSocket = ContainerDPipe
+	}
+	return ContainerDSocket, nil
+}
+
+// containerDAvailable checks if a containerD instance is listening on the named pipe
+func containerDAvailable(pipe string) error {
+	timeout := containerDDialTimeout
+	conn, err := winio.DialPipe(pipe, &timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// ProbeLocalImport checks if a local containerD instance is available to import images into
+func ProbeLocalImport() error {
+	pipe, _ := GetContainerDSocket()
+	if err := containerDAvailable(pipe); err != nil {
+		return fmt.Errorf("%w: no containerd listening on %s: %w", ErrNoContainerD, pipe, err)
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+const (
+	// ImageSignaturePrefix is the folder cosign signatures of images are stored in within an archive
+	ImageSignaturePrefix  = ".signatures"
+	SignatureBundleSuffix = ".json"
+
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	cosignSignatureType       = "cosign container image signature"
+	tarballManifestFile       = "manifest.json"
+	tarballLayerSuffix        = ".tar.gz"
+)
+
+// fetchManifest retrieves the raw manifest a reference points to, which may be an index
+var fetchManifest = crane.Manifest
+
+// A CosignSignature is a simple signing payload and its base64 encoded signature as created by cosign
+type CosignSignature struct {
+	Payload   []byte `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// An ImageSignatureBundle contains everything needed to verify the cosign signatures of an image offline.
+// If the signed reference is a multi-platform index, it is stored in Index, while Manifest is the bundled image.
+type ImageSignatureBundle struct {
+	Index      []byte            `json:"index,omitempty"`
+	Manifest   []byte            `json:"manifest"`
+	Signatures []CosignSignature `json:"signatures"`
+}
+
+// cosignPayload is the part of the simple signing format that is verified
+type cosignPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// tarballManifest is an entry of the manifest.json of an image tarball
+type tarballManifest struct {
+	Config string   `json:"Config"`
+	Layers []string `json:"Layers"`
+}
+
+// signatureBundleName creates the name of the signature bundle of an image within an archive
+func signatureBundleName(img *ContainerImage) string {
+	return path.Join(ImageSignaturePrefix, img.String()+SignatureBundleSuffix)
+}
+
+// FetchSignatureBundle downloads the manifests and cosign signatures of an image.
+// The signatures are looked up by the cosign tag convention <repository>:sha256-<hex>.sig
+func FetchSignatureBundle(img *ContainerImage) (*ImageSignatureBundle, error) {
+	image, err := pullImage(img.String(), registryOptions()...)
+	if err != nil {
+		return nil, wrapNetworkError(err)
+	}
+	bundle := &ImageSignatureBundle{}
+	if bundle.Manifest, err = image.RawManifest(); err != nil {
+		return nil, wrapNetworkError(err)
+	}
+	signed, err := fetchManifest(img.String())
+	if err != nil {
+		return nil, wrapNetworkError(err)
+	}
+	if !bytes.Equal(signed, bundle.Manifest) {
+		bundle.Index = signed
+	}
+	digest, _, err := v1.SHA256(bytes.NewReader(signed))
+	if err != nil {
+		return nil, err
+	}
+	sigRef := fmt.Sprintf("%s/%s:%s-%s.sig", img.Registry, img.Name, digest.Algorithm, digest.Hex)
+	sigImage, err := pullImage(sigRef, registryOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("no cosign signature found for %s: %w", img, wrapNetworkError(err))
+	}
+	sigManifest, err := sigImage.Manifest()
+	if err != nil {
+		return nil, wrapNetworkError(err)
+	}
+	layers, err := sigImage.Layers()
+	if err != nil {
+		return nil, wrapNetworkError(err)
+	}
+	for i, layer := range layers {
+		sig, ok := sigManifest.Layers[i].Annotations[cosignSignatureAnnotation]
+		if !ok {
+			continue
+		}
+		r, err := layer.Uncompressed()
+		if err != nil {
+			return nil, wrapNetworkError(err)
+		}
+		payload, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			return nil, wrapNetworkError(err)
+		}
+		bundle.Signatures = append(bundle.Signatures, CosignSignature{Payload: payload, Signature: sig})
+	}
+	if len(bundle.Signatures) == 0 {
+		return nil, fmt.Errorf("no cosign signature found for %s", img)
+	}
+	return bundle, nil
+}
+
+// An ImagePolicy defines the keys bundled images must be signed with to be imported.
+// Images not matching any rule are rejected.
+type ImagePolicy struct {
+	Rules []*ImagePolicyRule `json:"rules" yaml:"rules"`
+}
+
+// An ImagePolicyRule requires images matching a pattern to be signed by at least one of the keys.
+// Images is a path.Match pattern on registry/name, e.g. registry.example.com/apps/*
+type ImagePolicyRule struct {
+	Images    string   `json:"images" yaml:"images"`
+	Keys      []string `json:"keys" yaml:"keys"`
+	verifiers []signature.Verifier
+}
+
+// LoadImagePolicy reads an image policy from a JSON or YAML file and loads all keys.
+// Relative key paths are resolved against the folder of the policy file.
+func LoadImagePolicy(fileName string) (*ImagePolicy, error) {
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	policy := &ImagePolicy{}
+	if err = yaml.Unmarshal(contents, policy); err != nil {
+		return nil, fmt.Errorf("invalid image policy %s: %w", fileName, err)
+	}
+	if len(policy.Rules) == 0 {
+		return nil, fmt.Errorf("image policy %s contains no rules", fileName)
+	}
+	for _, rule := range policy.Rules {
+		if _, err = path.Match(rule.Images, ""); err != nil {
+			return nil, fmt.Errorf("invalid image pattern '%s': %w", rule.Images, err)
+		}
+		if len(rule.Keys) == 0 {
+			return nil, fmt.Errorf("image policy rule for '%s' has no keys", rule.Images)
+		}
+		for _, key := range rule.Keys {
+			if !IsExternalKey(key) && !filepath.IsAbs(key) {
+				key = filepath.Join(filepath.Dir(fileName), key)
+			}
+			verifier, err := CreateVerifier(key)
+			if err != nil {
+				return nil, fmt.Errorf("loading key %s failed: %w", key, err)
+			}
+			rule.verifiers = append(rule.verifiers, verifier)
+		}
+	}
+	return policy, nil
+}
+
+// ruleFor finds the first rule matching an image
+func (p *ImagePolicy) ruleFor(img *ContainerImage) *ImagePolicyRule {
+	for _, rule := range p.Rules {
+		if ok, _ := path.Match(rule.Images, img.Registry+"/"+img.Name); ok {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Verify checks that an image tarball is signed according to the policy.
+// The signatures must be valid for the signed manifest, and the tarball must contain exactly the config and layers of it.
+func (p *ImagePolicy) Verify(img *ContainerImage, bundle *ImageSignatureBundle, tarball io.ReadSeeker) error {
+	rule := p.ruleFor(img)
+	if rule == nil {
+		return fmt.Errorf("%w: image %s is not covered by the image policy", ErrBadSignature, img)
+	}
+	if bundle == nil {
+		return fmt.Errorf("%w: image %s has no bundled cosign signatures", ErrBadSignature, img)
+	}
+	signed, err := bundle.signedDigest()
+	if err != nil {
+		return fmt.Errorf("%w: image %s: %w", ErrBadSignature, img, err)
+	}
+	if !rule.verify(img, signed, bundle.Signatures) {
+		return fmt.Errorf("%w: image %s has no valid cosign signature for %s", ErrBadSignature, img, signed)
+	}
+	if err = verifyTarball(tarball, bundle.Manifest); err != nil {
+		return fmt.Errorf("%w: image %s: %w", ErrBadSignature, img, err)
+	}
+	return nil
+}
+
+// signedDigest calculates the digest cosign signatures refer to and makes sure the bundled manifest belongs to it
+func (b *ImageSignatureBundle) signedDigest() (v1.Hash, error) {
+	manifest, _, err := v1.SHA256(bytes.NewReader(b.Manifest))
+	if err != nil || b.Index == nil {
+		return manifest, err
+	}
+	index, err := v1.ParseIndexManifest(bytes.NewReader(b.Index))
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	for _, m := range index.Manifests {
+		if m.Digest == manifest {
+			digest, _, err := v1.SHA256(bytes.NewReader(b.Index))
+			return digest, err
+		}
+	}
+	return v1.Hash{}, fmt.Errorf("manifest %s is not part of the signed index", manifest)
+}
+
+// verify checks if any of the signatures is a valid cosign signature of the digest by a key of the rule
+func (r *ImagePolicyRule) verify(img *ContainerImage, digest v1.Hash, signatures []CosignSignature) bool {
+	repo, err := name.NewRepository(img.Registry + "/" + img.Name)
+	if err != nil {
+		return false
+	}
+	for _, sig := range signatures {
+		raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+		if err != nil {
+			continue
+		}
+		var payload cosignPayload
+		if err = json.Unmarshal(sig.Payload, &payload); err != nil ||
+			payload.Critical.Type != cosignSignatureType ||
+			payload.Critical.Image.DockerManifestDigest != digest.String() {
+			continue
+		}
+		signedRepo, err := name.NewRepository(payload.Critical.Identity.DockerReference)
+		if err != nil || signedRepo.Name() != repo.Name() {
+			continue
+		}
+		for _, verifier := range r.verifiers {
+			if verifier.VerifySignature(bytes.NewReader(raw), bytes.NewReader(sig.Payload)) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyTarball makes sure an image tarball contains exactly the config and layers of a manifest.
+// The contents of every entry are hashed, so the names cannot be trusted without reading them.
+func verifyTarball(tarball io.ReadSeeker, rawManifest []byte) error {
+	manifest, err := v1.ParseManifest(bytes.NewReader(rawManifest))
+	if err != nil {
+		return err
+	}
+	if _, err = tarball.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	digests := map[string]string{}
+	var entries []tarballManifest
+	tr := tar.NewReader(tarball)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if h.Name == tarballManifestFile {
+			if err = json.NewDecoder(tr).Decode(&entries); err != nil {
+				return err
+			}
+			continue
+		}
+		hash := sha256.New()
+		if _, err = io.Copy(hash, tr); err != nil {
+			return err
+		}
+		digests[h.Name] = hex.EncodeToString(hash.Sum(nil))
+	}
+	if len(entries) != 1 {
+		return errors.New("tarball must contain exactly one image")
+	}
+	if digests[entries[0].Config] != manifest.Config.Digest.Hex || entries[0].Config != manifest.Config.Digest.String() {
+		return fmt.Errorf("config does not match signed manifest")
+	}
+	if len(entries[0].Layers) != len(manifest.Layers) {
+		return fmt.Errorf("layers do not match signed manifest")
+	}
+	for i, layer := range manifest.Layers {
+		file := entries[0].Layers[i]
+		if file != layer.Digest.Hex+tarballLayerSuffix || digests[file] != layer.Digest.Hex {
+			return fmt.Errorf("layer %s does not match signed manifest", layer.Digest)
+		}
+	}
+	if _, err = tarball.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// signedImage pushes a random image and its cosign signature to a registry
+func signedImage(t *testing.T, host string, key *ecdsa.PrivateKey) *ContainerImage {
+	img, err := random.Image(512, 2)
+	assert.NoError(t, err)
+	ref := host + "/apps/app:1.0"
+	assert.NoError(t, crane.Push(img, ref))
+	digest, err := img.Digest()
+	assert.NoError(t, err)
+
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"%s/apps/app"},"image":{"docker-manifest-digest":"%s"},"type":"%s"},"optional":null}`, host, digest, cosignSignatureType))
+	sum := sha256.Sum256(payload)
+	sig, err := key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	assert.NoError(t, err)
+	sigImage, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, types.MediaType("application/vnd.dev.cosign.simplesigning.v1+json")),
+		Annotations: map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, crane.Push(sigImage, fmt.Sprintf("%s/apps/app:%s-%s.sig", host, digest.Algorithm, digest.Hex)))
+	return ParseContainerImage(ref)
+}
+
+// writePolicy stores a public key and a policy requiring it for a pattern
+func writePolicy(t *testing.T, key *ecdsa.PrivateKey, pattern string) string {
+	dir := t.TempDir()
+	pub, err := EncodePublicKey(key.Public())
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "cosign.pub"), pub, 0644))
+	policy := fmt.Sprintf("rules:\n  - images: \"%s\"\n    keys: [cosign.pub]\n", pattern)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "policy.yaml"), []byte(policy), 0644))
+	return filepath.Join(dir, "policy.yaml")
+}
+
+func TestImagePolicy_Verify(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	defer func() { _ = CleanupImages() }()
+	host := strings.TrimPrefix(server.URL, "http://")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	img := signedImage(t, host, key)
+
+	bundle, err := FetchSignatureBundle(img)
+	assert.NoError(t, err)
+	assert.Len(t, bundle.Signatures, 1)
+	tarball, err := SaveImage(img, ImageSource{})
+	assert.NoError(t, err)
+	defer tarball.Close()
+
+	policy, err := LoadImagePolicy(writePolicy(t, key, host+"/apps/*"))
+	assert.NoError(t, err)
+	assert.NoError(t, policy.Verify(img, bundle, tarball))
+
+	t.Run("wrong key", func(t *testing.T) {
+		policy, err := LoadImagePolicy(writePolicy(t, otherKey, host+"/apps/*"))
+		assert.NoError(t, err)
+		assert.ErrorIs(t, policy.Verify(img, bundle, tarball), ErrBadSignature)
+	})
+	t.Run("not covered", func(t *testing.T) {
+		policy, err := LoadImagePolicy(writePolicy(t, key, "registry.example.com/*"))
+		assert.NoError(t, err)
+		assert.ErrorIs(t, policy.Verify(img, bundle, tarball), ErrBadSignature)
+	})
+	t.Run("unsigned", func(t *testing.T) {
+		assert.ErrorIs(t, policy.Verify(img, nil, tarball), ErrBadSignature)
+	})
+	t.Run("other contents", func(t *testing.T) {
+		other, err := random.Image(512, 2)
+		assert.NoError(t, err)
+		otherManifest, err := other.RawManifest()
+		assert.NoError(t, err)
+		// A valid signature must not be usable for a manifest it was not created for
+		swapped := &ImageSignatureBundle{Manifest: otherManifest, Signatures: bundle.Signatures}
+		assert.ErrorIs(t, policy.Verify(img, swapped, tarball), ErrBadSignature)
+		tampered := bytes.NewReader(bytes.Replace(mustReadAll(t, tarball), []byte("manifest.json"), []byte("manifest.jsoN"), 1))
+		assert.ErrorIs(t, policy.Verify(img, bundle, tampered), ErrBadSignature)
+	})
+}
+
+func TestFetchSignatureBundle_Unsigned(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	img, err := random.Image(512, 1)
+	assert.NoError(t, err)
+	ref := strings.TrimPrefix(server.URL, "http://") + "/app:1.0"
+	assert.NoError(t, crane.Push(img, ref))
+	_, err = FetchSignatureBundle(ParseContainerImage(ref))
+	assert.ErrorContains(t, err, "no cosign signature found")
+}
+
+func TestLoadImagePolicy_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"empty.yaml":   "rules: []\n",
+		"nokeys.yaml":  "rules:\n  - images: \"*\"\n",
+		"badkey.yaml":  "rules:\n  - images: \"*\"\n    keys: [missing.pub]\n",
+		"pattern.yaml": "rules:\n  - images: \"[\"\n    keys: [missing.pub]\n",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+		_, err := LoadImagePolicy(filepath.Join(dir, name))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestReadArchive_UnpackImagePolicy(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	defer func() { _ = CleanupImages() }()
+	host := strings.TrimPrefix(server.URL, "http://")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	img := signedImage(t, host, key)
+
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.ImageSignatures = true
+	assert.NoError(t, arc.AddContents(nil, []*ContainerImage{img}, sig))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err = arc.Finalize()
+	assert.NoError(t, err)
+
+	for name, pattern := range map[string]string{"allowed": host + "/apps/*", "rejected": "registry.example.com/*"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(arc.outFile.Name())
+			assert.NoError(t, err)
+			defer f.Close()
+			ra, err := OpenArchiveReader(f, 0)
+			assert.NoError(t, err)
+			ra.DryRun = true
+			ra.ImagePolicy, err = LoadImagePolicy(writePolicy(t, key, pattern))
+			assert.NoError(t, err)
+			err = ra.Unpack("../test/public.pem", "SHA256", "", "", host)
+			if name == "allowed" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrBadSignature)
+			}
+		})
+	}
+}
+
+func mustReadAll(t *testing.T, f *os.File) []byte {
+	_, err := f.Seek(0, 0)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(f)
+	assert.NoError(t, err)
+	return buf.Bytes()
+}
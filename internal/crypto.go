@@ -15,17 +15,23 @@ package internal
  */
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/ovh/symmecrypt"
 	"github.com/ovh/symmecrypt/ciphers/xchacha20poly1305"
 	"github.com/ovh/symmecrypt/keyloader"
 	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/options"
+	"github.com/youmark/pkcs8"
 	"io"
 	"os"
 	"strings"
@@ -36,20 +42,96 @@ type Signer struct {
 	Signer *signature.SignerVerifier
 }
 
+const (
+	// DefaultSignatureHash is used for signatures if none is configured and for packages not recording one
+	DefaultSignatureHash = "SHA256"
+	// SignatureHashEd25519ph selects Ed25519ph, which signs a SHA-512 digest instead of the message, for Ed25519 keys
+	SignatureHashEd25519ph = "Ed25519ph"
+	// pemHint explains how to provide keys that are not PEM encoded
+	pemHint = "keys must be PEM encoded; convert a DER encoded key with `openssl pkey -inform DER -in %s`"
+)
+
+// signatureHashes maps normalized names of hashes available for signatures to their crypto.Hash
+var signatureHashes = map[string]crypto.Hash{
+	"SHA256":    crypto.SHA256,
+	"SHA384":    crypto.SHA384,
+	"SHA512":    crypto.SHA512,
+	"ED25519PH": crypto.SHA512,
+}
+
+// PassphraseEnvVar is the environment variable the passphrase of encrypted private keys is read from by default
+const PassphraseEnvVar = "SEALPACK_KEY_PASSPHRASE"
+
+// PassphraseProvider is called to retrieve the passphrase of an encrypted private key.
+// By default, the passphrase is read from the PassphraseEnvVar environment variable.
+var PassphraseProvider = func(path string) ([]byte, error) {
+	passphrase, ok := os.LookupEnv(PassphraseEnvVar)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is encrypted, provide its passphrase in %s", path, PassphraseEnvVar)
+	}
+	return []byte(passphrase), nil
+}
+
 // CreateSigner chooses the correct signature.Signer depending on the private key string
 func CreateSigner(privateKeyPath string) (signature.Signer, error) {
-	if strings.HasPrefix(privateKeyPath, "awskms:///") {
-		return createKmsSigner(privateKeyPath)
+	return CreateSignerWithHash(privateKeyPath, DefaultSignatureHash)
+}
+
+// CreateSignerWithHash creates a signature.Signer using the named signature hash from the KeyProvider of the key.
+// KMS keys always use the hash defined by their key spec.
+func CreateSignerWithHash(privateKeyPath, signatureHash string) (signature.Signer, error) {
+	provider, err := KeyProviderFor(privateKeyPath)
+	if err != nil {
+		return nil, err
 	}
-	return CreatePKISigner(privateKeyPath)
+	return provider.Signer(privateKeyPath, signatureHash)
 }
 
 // CreateVerifier chooses the correct signature.Verifier depending on the private key string
 func CreateVerifier(publicKeyPath string) (signature.Verifier, error) {
-	if strings.HasPrefix(publicKeyPath, "awskms:///") {
-		return createKmsVerifier(publicKeyPath)
+	return CreateVerifierWithHash(publicKeyPath, DefaultSignatureHash)
+}
+
+// CreateVerifierWithHash creates a signature.Verifier using the named signature hash from the KeyProvider of the key.
+// KMS keys always use the hash defined by their key spec.
+func CreateVerifierWithHash(publicKeyPath, signatureHash string) (signature.Verifier, error) {
+	provider, err := KeyProviderFor(publicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Verifier(publicKeyPath, signatureHash)
+}
+
+// ParseSignatureHash normalizes the name of a signature hash, e.g. "sha-384" to "SHA384".
+// An empty name selects the DefaultSignatureHash.
+func ParseSignatureHash(name string) (string, error) {
+	if name == "" {
+		return DefaultSignatureHash, nil
+	}
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", "_", "").Replace(name))
+	if _, ok := signatureHashes[normalized]; !ok {
+		return "", fmt.Errorf("unsupported signature hash '%s', use one of SHA256, SHA384, SHA512, %s", name, SignatureHashEd25519ph)
+	}
+	if normalized == strings.ToUpper(SignatureHashEd25519ph) {
+		return SignatureHashEd25519ph, nil
+	}
+	return normalized, nil
+}
+
+// signatureOptions provides the options to load a signer or verifier for a key with the named signature hash
+func signatureOptions(signatureHash string, key any) ([]signature.LoadOption, error) {
+	name, err := ParseSignatureHash(signatureHash)
+	if err != nil {
+		return nil, err
 	}
-	return CreatePKIVerifier(publicKeyPath)
+	if name == SignatureHashEd25519ph {
+		switch key.(type) {
+		case ed25519.PrivateKey, ed25519.PublicKey:
+			return []signature.LoadOption{options.WithED25519ph()}, nil
+		}
+		return nil, fmt.Errorf("signature hash %s requires an Ed25519 key", SignatureHashEd25519ph)
+	}
+	return []signature.LoadOption{options.WithHash(signatureHashes[name])}, nil
 }
 
 // LoadPublicKey reads and parses a public key from a file
@@ -58,13 +140,27 @@ func LoadPublicKey(path string) (crypto.PublicKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	if isOpenPGPArmor(keyBytes, openPGPPublicKeyBlock) {
+		keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyBytes))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OpenPGP public key %s: %w", path, err)
+		}
+		return keyring, nil
+	}
 	block, _ := pem.Decode(keyBytes)
 	if block == nil {
-		return nil, errors.New("file does not contain PEM data")
+		if sshKeys, err := parseAuthorizedKeys(keyBytes, path); err == nil && len(sshKeys) > 0 {
+			return sshKeys[0].Key, nil
+		}
+		return nil, WithHint(errors.New("file does not contain PEM data"), pemHint, path)
 	}
 	key, err := parsePublicKey(block.Bytes)
 	if err != nil {
-		return nil, err
+		if strings.Contains(block.Type, "PRIVATE KEY") {
+			return nil, WithHint(err, "%s contains a private key, but a public key is required here; "+
+				"did you swap the keys? Extract the public key with `openssl pkey -in %s -pubout`", path, path)
+		}
+		return nil, WithHint(err, "%s must contain a public key in PKIX, PKCS#1 or OpenSSH format", path)
 	}
 	return key.(crypto.PublicKey), nil
 }
@@ -80,15 +176,37 @@ func parsePublicKey(block []byte) (any, error) {
 
 // LoadPrivateKey reads and parses a private key from a file
 func LoadPrivateKey(path string) (interface{}, error) {
-	keyBytes, err := os.ReadFile(path)
+	keyBytes, err := readLocked(path)
 	if err != nil {
 		return nil, err
 	}
-	block, _ := pem.Decode(keyBytes)
+	defer keyBytes.Destroy()
+	if isOpenPGPArmor(keyBytes.Bytes(), openPGPPrivateKeyBlock) {
+		return readOpenPGPPrivateKey(keyBytes.Bytes(), path)
+	}
+	block, _ := pem.Decode(keyBytes.Bytes())
 	if block == nil {
-		return nil, errors.New("file does not contain PEM data")
+		return nil, WithHint(errors.New("file does not contain PEM data"), pemHint, path)
+	}
+	defer wipe(block.Bytes)
+	if block.Type == pemTypeOpenSSHPrivateKey {
+		return parseOpenSSHPrivateKey(keyBytes.Bytes(), path)
 	}
-	return parsePrivateKey(block.Bytes)
+	if block.Type == pemTypeEncryptedPrivateKey {
+		passphrase, err := PassphraseProvider(path)
+		if err != nil {
+			return nil, err
+		}
+		return pkcs8.ParsePKCS8PrivateKey(block.Bytes, passphrase)
+	}
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		if strings.Contains(block.Type, "PUBLIC KEY") {
+			return nil, WithHint(err, "%s contains a public key, but a private key is required here; did you swap the keys?", path)
+		}
+		return nil, WithHint(err, "%s must contain a private key in PKCS#1, PKCS#8, SEC 1 or OpenSSH format", path)
+	}
+	return key, nil
 }
 
 // parsePrivateKey tries to parse the byte slice as PKCS1, PKCS8 and EC key and provides it back
@@ -115,7 +233,7 @@ func CreatePKISigner(pkeyPath string) (signature.Signer, error) {
 	if err != nil {
 		return nil, err
 	}
-	return signature.LoadSigner(pKey, crypto.SHA256)
+	return signature.LoadSigner(pKey, signatureHashes[DefaultSignatureHash])
 }
 
 // CreatePKIVerifier builds a verifier based on a public key
@@ -124,7 +242,7 @@ func CreatePKIVerifier(skeyPath string) (signature.Verifier, error) {
 	if err != nil {
 		return nil, err
 	}
-	return signature.LoadVerifier(pubKey, crypto.SHA256)
+	return signature.LoadVerifier(pubKey, signatureHashes[DefaultSignatureHash])
 }
 
 // Encrypt the contents of an os.File with a random key and retrieve the results as []byte
@@ -146,7 +264,9 @@ func Encrypt(unencrypted []byte) ([]byte, []byte, error) {
 	return encrypted, []byte(keyConfig.Key), nil
 }
 
-func EncryptWriter(w io.Writer) (string, io.WriteCloser) {
+// EncryptWriter encrypts everything written to w with a new random key.
+// The key is returned as byte slice, so it can be wiped once it has been sealed for all recipients.
+func EncryptWriter(w io.Writer) ([]byte, io.WriteCloser) {
 	keyConfig, _ := keyloader.GenerateKey(
 		xchacha20poly1305.CipherName, // The recommended cipher
 		"log_key",
@@ -154,7 +274,21 @@ func EncryptWriter(w io.Writer) (string, io.WriteCloser) {
 		time.Now(),
 	)
 	key, _ := keyloader.NewKey(keyConfig)
-	return keyConfig.Key, symmecrypt.NewWriter(w, key)
+	return []byte(keyConfig.Key), symmecrypt.NewWriter(w, key)
+}
+
+// EncryptFramedWriter is like EncryptWriter, but encrypts in authenticated frames as written by EnvelopeVersion5 and newer
+func EncryptFramedWriter(w io.Writer) ([]byte, io.WriteCloser, error) {
+	keyConfig, err := keyloader.GenerateKey(xchacha20poly1305.CipherName, "log_key", false, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	key := []byte(keyConfig.Key)
+	fw, err := NewFrameWriter(w, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, fw, nil
 }
 
 // TryUnsealKey loads a key from JSON without configstore
@@ -163,28 +297,58 @@ func TryUnsealKey(encrypted []byte, rsaKey *rsa.PrivateKey) (symmecrypt.Key, err
 	if err != nil {
 		return nil, err
 	}
+	defer wipe(keyBytes)
 	return symmecrypt.NewKey(xchacha20poly1305.CipherName, string(keyBytes))
 }
 
+// tryUnsealKeyWith decrypts a key entry with an RSA key, TPM key or OpenPGP keyring, or unwraps it with an ECDSA or Ed25519 key.
+// It provides the plain payload key, which has to be wiped after use.
+func tryUnsealKeyWith(entry []byte, privateKey crypto.PrivateKey) ([]byte, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return rsa.DecryptPKCS1v15(rand.Reader, key, entry)
+	case openpgp.EntityList:
+		return UnwrapOpenPGPKey(key, entry)
+	case tpmKey:
+		return key.decrypt(entry)
+	case crypto.Decrypter:
+		// Keys of registered providers decrypt RSA PKCS#1 v1.5 key entries within their backend
+		return key.Decrypt(rand.Reader, entry, nil)
+	default:
+		return UnwrapHybridKey(privateKey, entry)
+	}
+}
+
 // AddKeys encrypts the symmetric key for every receiver and attaches them to the envelope
-func AddKeys(recipientPubKeyPaths []string, envelope *Envelope, plainKey []byte) error {
+func AddKeys(recipients []RecipientKey, envelope *Envelope, plainKey []byte) error {
 	var err error
-	envelope.ReceiverKeys = [][]byte{}
-	envelope.ReceiverKeys = make([][]byte, len(recipientPubKeyPaths))
-	for iKey, recipientPubKeyPath := range recipientPubKeyPaths {
-		var recPubKey crypto.PublicKey
-		if recPubKey, err = LoadPublicKey(recipientPubKeyPath); err != nil {
-			return err
-		}
-		if key, ok := recPubKey.(*rsa.PublicKey); ok {
+	envelope.ReceiverKeys = make([][]byte, len(recipients))
+	for iKey, recipient := range recipients {
+		switch key := recipient.Key.(type) {
+		case *rsa.PublicKey:
 			if envelope.ReceiverKeys[iKey], err = rsa.EncryptPKCS1v15(rand.Reader, key, plainKey); err != nil {
 				return err
 			}
 			if len(envelope.ReceiverKeys[iKey]) != key.Size() {
 				return fmt.Errorf("key size must be %d bits", key.Size())
 			}
-		} else {
-			return fmt.Errorf("encryption key %d cannot be used for encryption. Please provide a valid RSA public key", iKey+1)
+		case *ecdsa.PublicKey, ed25519.PublicKey:
+			// Hybrid and OpenPGP key entries do not fit the fixed key sizes of older envelopes
+			if envelope.Version < EnvelopeVersion3 {
+				return fmt.Errorf("recipient key %s requires envelope version %d or newer", recipient.ID, EnvelopeVersion3)
+			}
+			if envelope.ReceiverKeys[iKey], err = WrapHybridKey(key, plainKey); err != nil {
+				return fmt.Errorf("cannot encrypt for recipient key %s: %w", recipient.ID, err)
+			}
+		case *openpgp.Entity:
+			if envelope.Version < EnvelopeVersion3 {
+				return fmt.Errorf("recipient key %s requires envelope version %d or newer", recipient.ID, EnvelopeVersion3)
+			}
+			if envelope.ReceiverKeys[iKey], err = WrapOpenPGPKey(key, plainKey); err != nil {
+				return fmt.Errorf("cannot encrypt for recipient key %s: %w", recipient.ID, err)
+			}
+		default:
+			return fmt.Errorf("encryption key %s cannot be used for encryption. Please provide a valid RSA, ECDSA, Ed25519 or OpenPGP public key", recipient.ID)
 		}
 	}
 	return nil
@@ -15,6 +15,7 @@ package internal
  */
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rand"
@@ -62,6 +63,53 @@ func Test_CreateSignerAWS(t *testing.T) {
 	assert.Implements(t, (*signature.Signer)(nil), sig)
 }
 
+func TestParseSignatureHash(t *testing.T) {
+	for in, want := range map[string]string{"": "SHA256", "sha-384": "SHA384", "SHA512": "SHA512", "ed25519ph": SignatureHashEd25519ph} {
+		got, err := ParseSignatureHash(in)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := ParseSignatureHash("MD5")
+	assert.ErrorContains(t, err, "unsupported signature hash 'MD5'")
+}
+
+func TestCreateSignerWithHash(t *testing.T) {
+	message := []byte("Hold your breath and count to 10.")
+	signer, err := CreateSignerWithHash(filepath.Join(TestFilePath, "private.pem"), "SHA384")
+	assert.NoError(t, err)
+	sig, err := signer.SignMessage(bytes.NewReader(message))
+	assert.NoError(t, err)
+	verifier, err := CreateVerifierWithHash(filepath.Join(TestFilePath, "public.pem"), "SHA384")
+	assert.NoError(t, err)
+	assert.NoError(t, verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(message)))
+	verifier, err = CreateVerifier(filepath.Join(TestFilePath, "public.pem"))
+	assert.NoError(t, err)
+	assert.Error(t, verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(message)))
+
+	_, err = CreateSignerWithHash(filepath.Join(TestFilePath, "private.pem"), SignatureHashEd25519ph)
+	assert.ErrorContains(t, err, "requires an Ed25519 key")
+}
+
+func TestCreateSignerWithHash_Ed25519ph(t *testing.T) {
+	key, err := GenerateKey(KeyTypeEd25519, 0)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	privPath, pubPath := filepath.Join(dir, "private.pem"), filepath.Join(dir, "public.pem")
+	assert.NoError(t, WriteKeyPair(key, privPath, pubPath, nil))
+
+	message := []byte("Hold your breath and count to 10.")
+	signer, err := CreateSignerWithHash(privPath, SignatureHashEd25519ph)
+	assert.NoError(t, err)
+	sig, err := signer.SignMessage(bytes.NewReader(message))
+	assert.NoError(t, err)
+	verifier, err := CreateVerifierWithHash(pubPath, SignatureHashEd25519ph)
+	assert.NoError(t, err)
+	assert.NoError(t, verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(message)))
+	verifier, err = CreateVerifier(pubPath)
+	assert.NoError(t, err)
+	assert.Error(t, verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(message)))
+}
+
 // /////////////////////
 // Test LoadPublicKey //
 // /////////////////////
@@ -0,0 +1,350 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bufio"
+	"crypto"
+	"encoding/binary"
+	"fmt"
+	"github.com/innomotics/sealpack/format"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Status of a section of a diagnosed envelope
+const (
+	SectionIntact    = "intact"
+	SectionDamaged   = "damaged"
+	SectionTruncated = "truncated"
+	SectionUnchecked = "unchecked"
+)
+
+// A DiagnosedSection describes the state of one part of an envelope
+type DiagnosedSection struct {
+	Name   string
+	Offset int64
+	Length int64
+	Status string
+	Detail string
+}
+
+// A Diagnosis describes which sections of an envelope are intact and where damage begins
+type Diagnosis struct {
+	Size     int64
+	Sections []*DiagnosedSection
+	// DamagedAt is the offset of the first damaged or truncated byte, -1 if none was found
+	DamagedAt int64
+}
+
+// Intact determines whether no section is damaged or truncated
+func (d *Diagnosis) Intact() bool {
+	return d.DamagedAt < 0
+}
+
+// String prints the diagnosis as one line per section
+func (d *Diagnosis) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("File size: %d Bytes\n", d.Size))
+	for _, s := range d.Sections {
+		sb.WriteString(fmt.Sprintf("\t%-8s %10d +%-10d %-9s %s\n", s.Name, s.Offset, s.Length, s.Status, s.Detail))
+	}
+	if d.Intact() {
+		sb.WriteString("No damage found.\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("Damage begins at byte %d.\n", d.DamagedAt))
+	}
+	return sb.String()
+}
+
+// add appends a section and tracks the first damaged offset
+func (d *Diagnosis) add(name string, offset, length int64, status, detail string, args ...any) {
+	d.Sections = append(d.Sections, &DiagnosedSection{
+		Name:   name,
+		Offset: offset,
+		Length: length,
+		Status: status,
+		Detail: fmt.Sprintf(detail, args...),
+	})
+	// truncation always begins at the end of the file
+	if status == SectionTruncated {
+		offset = d.Size
+	}
+	if (status == SectionDamaged || status == SectionTruncated) && (d.DamagedAt < 0 || offset < d.DamagedAt) {
+		d.DamagedAt = offset
+	}
+}
+
+// Diagnose walks the structure of a possibly damaged envelope without stopping at the first error.
+// Only failures reading the input are returned as error, all damage is part of the Diagnosis.
+func Diagnose(input io.ReadSeeker) (*Diagnosis, error) {
+	size, err := input.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	d := &Diagnosis{Size: size, DamagedAt: -1}
+	head := make([]byte, format.MaxHeaderSize)
+	if _, err = input.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	n, err := io.ReadFull(input, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	// Magic bytes: a damaged magic is reported, but the layout is guessed from its last byte
+	envel := &Envelope{Version: EnvelopeVersion1}
+	magicLen := int64(len(EnvelopeMagicBytes))
+	switch {
+	case size < magicLen:
+		d.add("magic", 0, magicLen, SectionTruncated, "file ends after %d bytes", size)
+		return d, nil
+	case string(head[:magicLen]) == EnvelopeMagicBytes:
+		d.add("magic", 0, magicLen, SectionIntact, "sealpack envelope version 1")
+	case string(head[:magicLen]) == EnvelopeMagicBytesV2:
+		envel.Version = EnvelopeVersion2
+		d.add("magic", 0, magicLen, SectionIntact, "sealpack envelope version 2 or newer")
+	default:
+		if head[magicLen-1] == EnvelopeMagicBytesV2[magicLen-1] {
+			envel.Version = EnvelopeVersion2
+		}
+		d.add("magic", 0, magicLen, SectionDamaged, "expected %q, found %q; assuming version %d layout", EnvelopeMagicBytes, head[:magicLen], envel.Version)
+	}
+
+	// Header: version, config, flags, payload length and from EnvelopeVersion6 on its checksum
+	if envel.Version > EnvelopeVersion1 && len(head) > int(magicLen) && head[magicLen] >= EnvelopeVersion6 && head[magicLen] <= EnvelopeVersionLatest {
+		envel.Version = head[magicLen]
+	}
+	headerLen := envel.headerSize() - magicLen
+	if size < envel.headerSize() {
+		d.add("header", magicLen, headerLen, SectionTruncated, "file ends at byte %d", size)
+		return d, nil
+	}
+	header := head[magicLen:envel.headerSize()]
+	var problems []string
+	if envel.Version > EnvelopeVersion1 {
+		if header[0] < EnvelopeVersion2 || header[0] > EnvelopeVersionLatest {
+			problems = append(problems, fmt.Sprintf("unknown version %d", header[0]))
+		} else {
+			envel.Version = header[0]
+		}
+		header = header[1:]
+	}
+	envel.HashAlgorithm = crypto.Hash(header[0] & 0b00011111)
+	envel.CompressionAlgo = header[0] >> 5
+	compression := "unknown compression"
+	if int(envel.CompressionAlgo) < len(compressionAlgorithms) {
+		compression = compressionAlgorithms[envel.CompressionAlgo]
+	} else {
+		problems = append(problems, fmt.Sprintf("unknown compression %d", envel.CompressionAlgo))
+	}
+	if !envel.HashAlgorithm.Available() {
+		problems = append(problems, fmt.Sprintf("unknown hash algorithm %d", envel.HashAlgorithm))
+	}
+	header = header[1:]
+	if envel.Version > EnvelopeVersion1 {
+		envel.Flags = header[0]
+		if envel.Flags&^knownFlags != 0 {
+			problems = append(problems, fmt.Sprintf("unknown flags 0x%02x", envel.Flags))
+		}
+		header = header[1:]
+	}
+	envel.PayloadLen = int64(binary.LittleEndian.Uint64(header))
+	if envel.Version >= EnvelopeVersion6 && crc32.Checksum(head[:format.HeaderSize], crcTable) != binary.LittleEndian.Uint32(header[8:]) {
+		problems = append(problems, "checksum does not match")
+	}
+	payloadStart := envel.headerSize()
+	if len(problems) > 0 {
+		d.add("header", magicLen, headerLen, SectionDamaged, strings.Join(problems, ", "))
+	} else {
+		d.add("header", magicLen, headerLen, SectionIntact, "%s, %s, payload length %d", compression, envel.HashAlgorithm, envel.PayloadLen)
+	}
+
+	// Payload: compared to the actual size, as it is only covered by signatures after decryption
+	payloadEnd := payloadStart + envel.PayloadLen
+	if envel.PayloadLen < 0 || payloadEnd > size {
+		d.add("payload", payloadStart, max(envel.PayloadLen, 0), SectionTruncated, "only %d bytes present", size-payloadStart)
+		d.add("keys", size, 0, SectionTruncated, "missing, file ends within the payload")
+		if envel.HasTrailer() {
+			d.add("trailer", size, 0, SectionTruncated, "missing, file ends within the payload")
+		}
+		return d, nil
+	}
+
+	// Trailer: locates the end of the keys and the damaged chunks
+	end := size
+	var trailer *checksumTrailer
+	var trailerErr error
+	if envel.HasTrailer() {
+		if trailer, trailerErr = readTrailer(input); trailerErr == nil && trailer.offset >= payloadEnd {
+			end = trailer.offset
+			d.add("payload", payloadStart, envel.PayloadLen, SectionUnchecked, "checked by the trailer")
+		} else if trailerErr == nil {
+			trailer, trailerErr = nil, fmt.Errorf("checksum trailer overlaps the payload")
+		}
+	}
+	if trailer == nil {
+		d.add("payload", payloadStart, envel.PayloadLen, SectionUnchecked, "content is only verified on unseal")
+	}
+
+	// Signature: precedes the keys in signed envelopes
+	keysStart := payloadEnd
+	if envel.IsSigned() {
+		if keysStart, err = d.diagnoseSignature(input, payloadEnd, end); err != nil {
+			return nil, err
+		}
+	}
+
+	// Index: locates the entries of indexed payloads, follows the signature
+	if envel.HasIndex() {
+		if keysStart, err = d.diagnoseIndex(input, envel, keysStart, end); err != nil {
+			return nil, err
+		}
+	}
+
+	// Labels: channel and annotations of the package, follow the index
+	if envel.HasLabels() {
+		if keysStart, err = d.diagnoseLabels(input, envel, keysStart, end); err != nil {
+			return nil, err
+		}
+	}
+
+	// Keys: one length-prefixed record per receiver
+	if err = d.diagnoseKeys(input, envel, keysStart, end); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case trailerErr != nil:
+		d.add("trailer", max(size-trailerFixedSize, payloadEnd), min(trailerFixedSize, size-payloadEnd), SectionDamaged, trailerErr.Error())
+	case trailer != nil:
+		if err = d.diagnoseChunks(input, trailer); err != nil {
+			return nil, err
+		}
+	case envel.Version == EnvelopeVersion1:
+		d.add("trailer", size, 0, SectionUnchecked, "version 1 envelopes have no checksum")
+	}
+	return d, nil
+}
+
+// diagnoseSignature checks the structure of the signature record and provides the offset of the keys following it
+func (d *Diagnosis) diagnoseSignature(input io.ReadSeeker, start, end int64) (int64, error) {
+	if _, err := input.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	signatureHash, sig, n, err := readSignatureRecord(bufio.NewReader(io.LimitReader(input, end-start)))
+	if err != nil {
+		d.add("signature", start, end-start, SectionDamaged, "invalid signature record: %v", err)
+		return end, nil
+	}
+	d.add("signature", start, n, SectionUnchecked, "%d bytes using %s, verified on unseal", len(sig), signatureHash)
+	return start + n, nil
+}
+
+// diagnoseIndex checks the index record and provides the offset of the keys following it
+func (d *Diagnosis) diagnoseIndex(input io.ReadSeeker, envel *Envelope, start, end int64) (int64, error) {
+	if _, err := input.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := envel.readIndexRecord(bufio.NewReader(io.LimitReader(input, end-start))); err != nil {
+		d.add("index", start, end-start, SectionDamaged, "invalid index record: %v", err)
+		return end, nil
+	}
+	n := int64(len(envel.indexRecord()))
+	d.add("index", start, n, SectionIntact, "index of %d bytes at byte %d of the payload", envel.IndexLength, envel.IndexOffset)
+	return start + n, nil
+}
+
+// diagnoseLabels checks the labels record and provides the offset of the keys following it
+func (d *Diagnosis) diagnoseLabels(input io.ReadSeeker, envel *Envelope, start, end int64) (int64, error) {
+	if _, err := input.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := envel.readLabelsRecord(bufio.NewReader(io.LimitReader(input, end-start))); err != nil {
+		d.add("labels", start, end-start, SectionDamaged, "invalid labels record: %v", err)
+		return end, nil
+	}
+	n := int64(len(envel.labelsRecord()))
+	channel := "no channel"
+	if envel.Channel != "" {
+		channel = "channel " + envel.Channel
+	}
+	d.add("labels", start, n, SectionIntact, "%s, %d annotations", channel, len(envel.Annotations))
+	return start + n, nil
+}
+
+// diagnoseKeys walks the receiver key records between start and end
+func (d *Diagnosis) diagnoseKeys(input io.ReadSeeker, envel *Envelope, start, end int64) error {
+	if _, err := input.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	rd := bufio.NewReader(io.LimitReader(input, end-start))
+	var sizes []string
+	offset := start
+	for offset < end {
+		length, err := envel.readKeyLength(rd)
+		if err != nil {
+			d.add("keys", start, end-start, SectionDamaged, "invalid length of key record %d at byte %d: %v", len(sizes)+1, offset, err)
+			return nil
+		}
+		prefix := int64(1)
+		if envel.Version >= EnvelopeVersion3 {
+			prefix = int64(len(binary.AppendUvarint(nil, uint64(length))))
+		}
+		if offset+prefix+length > end {
+			d.add("keys", start, end-start, SectionTruncated, "key record %d at byte %d needs %d bytes, only %d present", len(sizes)+1, offset, length, end-offset-prefix)
+			return nil
+		}
+		if length == 0 {
+			d.add("keys", start, end-start, SectionDamaged, "empty key record %d at byte %d", len(sizes)+1, offset)
+			return nil
+		}
+		sizes = append(sizes, fmt.Sprintf("%d", length))
+		if _, err = rd.Discard(int(length)); err != nil {
+			return err
+		}
+		offset += prefix + length
+	}
+	if len(sizes) == 0 {
+		d.add("keys", start, 0, SectionIntact, "no receivers, public package")
+	} else {
+		d.add("keys", start, end-start, SectionIntact, "%d receivers, key sizes %s bytes", len(sizes), strings.Join(sizes, ", "))
+	}
+	return nil
+}
+
+// diagnoseChunks verifies all chunks of the trailer and reports the damaged ones
+func (d *Diagnosis) diagnoseChunks(input io.ReadSeeker, trailer *checksumTrailer) error {
+	length := int64(trailerFixedSize + 4*len(trailer.chunks))
+	corrupted, _, err := trailer.corruptedChunks(input)
+	if err != nil {
+		return err
+	}
+	if len(corrupted) == 0 {
+		d.add("trailer", trailer.offset, length, SectionIntact, "%d chunks match their checksums", len(trailer.chunks))
+		return nil
+	}
+	ranges := make([]string, 0, len(corrupted))
+	for _, chunk := range corrupted {
+		start, end := trailer.chunkRange(chunk)
+		ranges = append(ranges, fmt.Sprintf("%d-%d", start, end-1))
+	}
+	d.add("trailer", trailer.offset, length, SectionIntact, "%d of %d chunks do not match their checksums", len(corrupted), len(trailer.chunks))
+	start, _ := trailer.chunkRange(corrupted[0])
+	d.add("content", start, trailer.offset-start, SectionDamaged, "checksum mismatch in bytes %s", strings.Join(ranges, ", "))
+	return nil
+}
@@ -0,0 +1,99 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// section finds a diagnosed section by name
+func section(d *Diagnosis, name string) *DiagnosedSection {
+	for _, s := range d.Sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestDiagnose_Intact(t *testing.T) {
+	raw := envelopeV2(t, bytes.Repeat([]byte("0123456789abcdef"), 1024))
+
+	d, err := Diagnose(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.True(t, d.Intact())
+	assert.Equal(t, int64(len(raw)), d.Size)
+	for _, name := range []string{"magic", "header", "keys", "trailer"} {
+		assert.Equal(t, SectionIntact, section(d, name).Status, name)
+	}
+	assert.Contains(t, section(d, "keys").Detail, "1 receivers")
+	assert.Contains(t, d.String(), "No damage found.")
+}
+
+func TestDiagnose_Corrupted(t *testing.T) {
+	raw := envelopeV2(t, bytes.Repeat([]byte("0123456789abcdef"), checksumChunkSize/4))
+	raw[2*checksumChunkSize+5] ^= 0xFF
+
+	d, err := Diagnose(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.False(t, d.Intact())
+	assert.Equal(t, int64(2*checksumChunkSize), d.DamagedAt)
+	assert.Equal(t, SectionDamaged, section(d, "content").Status)
+	assert.Contains(t, section(d, "content").Detail, "2097152-3145727")
+}
+
+func TestDiagnose_TruncatedV1(t *testing.T) {
+	raw := append([]byte(EnvelopeMagicBytes), 0x05, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	raw = append(raw, bytes.Repeat([]byte{0x42}, 100)...)
+
+	d, err := Diagnose(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.False(t, d.Intact())
+	assert.Equal(t, SectionIntact, section(d, "header").Status)
+	assert.Equal(t, SectionTruncated, section(d, "payload").Status)
+	assert.Equal(t, SectionTruncated, section(d, "keys").Status)
+	assert.Contains(t, section(d, "payload").Detail, "only 100 bytes present")
+	assert.Equal(t, int64(113), d.DamagedAt)
+}
+
+func TestDiagnose_DamagedMagic(t *testing.T) {
+	raw := envelopeV2(t, []byte("payload"))
+	raw[0] = 'X'
+
+	d, err := Diagnose(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, SectionDamaged, section(d, "magic").Status)
+	assert.Equal(t, SectionIntact, section(d, "keys").Status)
+	assert.Equal(t, int64(0), d.DamagedAt)
+}
+
+func TestDiagnose_Empty(t *testing.T) {
+	d, err := Diagnose(bytes.NewReader(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, SectionTruncated, section(d, "magic").Status)
+}
+
+func TestDiagnose_HeaderChecksum(t *testing.T) {
+	raw := envelopeV6([]byte("payload"))
+	raw[6] ^= 0x40
+
+	d, err := Diagnose(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, SectionDamaged, section(d, "header").Status)
+	assert.Contains(t, section(d, "header").Detail, "checksum does not match")
+	assert.Equal(t, int64(19), section(d, "payload").Offset)
+}
@@ -0,0 +1,41 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// preallocateMinSize is the size from which files are preallocated, if enabled.
+// Smaller files are written quickly enough for preallocation not to pay off.
+const preallocateMinSize = 16 << 20
+
+// reserveSpace checks that the filesystem of a file has room for its size on top of all files currently being written.
+// The returned function releases the reservation once the file is written.
+func (arc *ReadArchive) reserveSpace(fullFile string, size int64) (release func(), err error) {
+	dir := filepath.Dir(fullFile)
+	available, err := availableSpace(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot determine available space in %s: %w", dir, err)
+	}
+	reserved := arc.reservedSpace.Add(size)
+	release = func() { arc.reservedSpace.Add(-size) }
+	if available >= 0 && reserved > available {
+		release()
+		return nil, fmt.Errorf("not enough space to write %s: %d bytes required, %d bytes available in %s", fullFile, reserved, available, dir)
+	}
+	return release, nil
+}
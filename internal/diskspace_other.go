@@ -0,0 +1,31 @@
+//go:build !linux && !windows
+
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"os"
+)
+
+// availableSpace cannot be determined on this platform, so -1 disables space checks
+func availableSpace(dir string) (int64, error) {
+	return -1, nil
+}
+
+// preallocate is not supported on this platform, files are written without preallocation
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
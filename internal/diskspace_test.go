@@ -0,0 +1,60 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadArchive_ReserveSpace(t *testing.T) {
+	available, err := availableSpace(t.TempDir())
+	assert.NoError(t, err)
+	if available < 0 {
+		t.Skip("available space cannot be determined on this platform")
+	}
+	arc := &ReadArchive{}
+	fullFile := filepath.Join(t.TempDir(), "huge.img")
+	_, err = arc.reserveSpace(fullFile, 1<<62)
+	assert.ErrorContains(t, err, "not enough space to write "+fullFile)
+	assert.ErrorContains(t, err, "bytes available")
+	assert.Zero(t, arc.reservedSpace.Load())
+
+	// Files being written concurrently count against the same free space
+	release, err := arc.reserveSpace(fullFile, available/2+1)
+	assert.NoError(t, err)
+	_, err = arc.reserveSpace(fullFile, available/2+1)
+	assert.Error(t, err)
+	release()
+	assert.Zero(t, arc.reservedSpace.Load())
+
+	_, err = arc.reserveSpace(filepath.Join(t.TempDir(), "missing", "file"), 1)
+	assert.ErrorContains(t, err, "cannot determine available space")
+}
+
+func TestReadArchive_StoreFilePreallocated(t *testing.T) {
+	contents := bytes.Repeat([]byte("Hold your breath and count to 10. "), preallocateMinSize/32)
+	fullFile := filepath.Join(t.TempDir(), "large.bin")
+	arc := &ReadArchive{Preallocate: true}
+	h := &tar.Header{Name: "large.bin", Size: int64(len(contents))}
+	assert.NoError(t, arc.storeFile(h, bytes.NewReader(contents), fullFile))
+	written, err := os.ReadFile(fullFile)
+	assert.NoError(t, err)
+	assert.Equal(t, contents, written)
+}
@@ -0,0 +1,96 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+)
+
+const (
+	// KeyStoreFile keeps the device key in a private key file only readable by its owner
+	KeyStoreFile = "file"
+	// KeyStoreTPM keeps the device key in the TPM, it never leaves the device
+	KeyStoreTPM = "tpm"
+)
+
+// DeviceIdentity is the recipient key of a device created on enrollment
+type DeviceIdentity struct {
+	// Reference is the private key path or TPM key reference the device unseals with
+	Reference string
+	// Public is the public key the packaging backend seals for
+	Public crypto.PublicKey
+	// signer is the private key of file key stores, used to sign certificate requests
+	signer crypto.Signer
+}
+
+// CreateDeviceIdentity creates a new recipient key in the key store.
+// File keys are written to privateKeyPath, TPM keys are persisted at tpmHandle.
+func CreateDeviceIdentity(keyStore, privateKeyPath, tpmHandle string, passphrase []byte) (*DeviceIdentity, error) {
+	switch keyStore {
+	case KeyStoreFile:
+		key, err := GenerateKey(KeyTypeRSA, 0)
+		if err != nil {
+			return nil, err
+		}
+		privPem, err := EncodePrivateKey(key, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if err = writeNewFile(privateKeyPath, setKeyUsage(privPem, KeyUsageRecipient), 0600); err != nil {
+			return nil, err
+		}
+		return &DeviceIdentity{Reference: privateKeyPath, Public: key.Public(), signer: key}, nil
+	case KeyStoreTPM:
+		if tpmHandle == "" {
+			tpmHandle = DefaultTPMHandle
+		}
+		pub, err := CreateTPMKey(tpmHandle)
+		if err != nil {
+			return nil, err
+		}
+		return &DeviceIdentity{Reference: TPMKeyPrefix + tpmHandle, Public: pub}, nil
+	}
+	return nil, fmt.Errorf("unknown key store '%s', use one of %s, %s", keyStore, KeyStoreFile, KeyStoreTPM)
+}
+
+// PublicKeyPEM encodes the public key of the device, declared for usage as recipient
+func (d *DeviceIdentity) PublicKeyPEM() ([]byte, error) {
+	pubPem, err := EncodePublicKey(d.Public)
+	if err != nil {
+		return nil, err
+	}
+	return setKeyUsage(pubPem, KeyUsageRecipient), nil
+}
+
+// CertificateRequest creates a PEM encoded PKCS#10 certificate request for the device ID.
+// TPM keys are restricted to decryption and cannot sign a certificate request.
+func (d *DeviceIdentity) CertificateRequest(deviceID string) ([]byte, error) {
+	if d.signer == nil {
+		return nil, WithHint(fmt.Errorf("cannot create a certificate request with %s", d.Reference),
+			"TPM keys can only decrypt; register the public key with the packaging backend instead")
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: deviceID},
+	}, d.signer)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
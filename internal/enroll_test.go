@@ -0,0 +1,121 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mockTPMTool replaces the tpm2-tools with a TPM holding a key pair from files, recording all commands
+func mockTPMTool(t *testing.T) *[]string {
+	old := runTPMTool
+	t.Cleanup(func() { runTPMTool = old })
+	pubPem, err := os.ReadFile(filepath.Join(TestFilePath, "public.pem"))
+	assert.NoError(t, err)
+	privateKey, err := LoadPrivateKey(filepath.Join(TestFilePath, "private.pem"))
+	assert.NoError(t, err)
+	var commands []string
+	runTPMTool = func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		switch name {
+		case "tpm2_readpublic":
+			return nil, os.WriteFile(args[len(args)-1], pubPem, 0644)
+		case "tpm2_rsadecrypt":
+			plain, err := rsa.DecryptPKCS1v15(nil, privateKey.(*rsa.PrivateKey), mustReadFile(t, args[len(args)-1]))
+			if err != nil {
+				return nil, err
+			}
+			return nil, os.WriteFile(args[len(args)-2], plain, 0600)
+		}
+		return nil, nil
+	}
+	return &commands
+}
+
+// mustReadFile reads a file in tests
+func mustReadFile(t *testing.T, path string) []byte {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	return data
+}
+
+func TestCreateDeviceIdentity_File(t *testing.T) {
+	privPath := filepath.Join(t.TempDir(), "device-private.pem")
+	identity, err := CreateDeviceIdentity(KeyStoreFile, privPath, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, privPath, identity.Reference)
+	assert.Equal(t, KeyUsageRecipient, ReadKeyUsage(privPath))
+	info, err := os.Stat(privPath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	pubPem, err := identity.PublicKeyPEM()
+	assert.NoError(t, err)
+	assert.Contains(t, string(pubPem), "Usage: recipient")
+
+	csrPem, err := identity.CertificateRequest("line-7-device-42")
+	assert.NoError(t, err)
+	block, _ := pem.Decode(csrPem)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	assert.NoError(t, err)
+	assert.NoError(t, csr.CheckSignature())
+	assert.Equal(t, "line-7-device-42", csr.Subject.CommonName)
+
+	// Existing keys are never overwritten
+	_, err = CreateDeviceIdentity(KeyStoreFile, privPath, "", nil)
+	assert.Error(t, err)
+	_, err = CreateDeviceIdentity("hsm", privPath, "", nil)
+	assert.ErrorContains(t, err, "unknown key store 'hsm'")
+}
+
+func TestCreateDeviceIdentity_TPM(t *testing.T) {
+	commands := mockTPMTool(t)
+	identity, err := CreateDeviceIdentity(KeyStoreTPM, "", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "tpm:"+DefaultTPMHandle, identity.Reference)
+	assert.True(t, IsTPMKey(identity.Reference))
+	if assert.Len(t, *commands, 5) {
+		assert.Contains(t, (*commands)[1], "decrypt")
+		assert.NotContains(t, (*commands)[1], "sign")
+		assert.True(t, strings.HasSuffix((*commands)[3], " "+DefaultTPMHandle))
+	}
+	_, err = identity.CertificateRequest("device")
+	assert.ErrorContains(t, err, "cannot create a certificate request")
+
+	info, err := GetKeyInfo(identity.Reference)
+	assert.NoError(t, err)
+	assert.True(t, info.Private)
+	assert.Equal(t, "RSA", info.Type)
+}
+
+func TestTryUnsealKeyWith_TPM(t *testing.T) {
+	mockTPMTool(t)
+	keys, err := LoadRecipientKeys([]string{filepath.Join(TestFilePath, "public.pem")})
+	assert.NoError(t, err)
+	plainKey, _ := EncryptWriter(&bytes.Buffer{})
+	envelope := &Envelope{}
+	assert.NoError(t, AddKeys(keys, envelope, plainKey))
+	unsealed, err := tryUnsealKeyWith(envelope.ReceiverKeys[0], tpmKey{handle: DefaultTPMHandle})
+	assert.NoError(t, err)
+	assert.Equal(t, plainKey, unsealed)
+}
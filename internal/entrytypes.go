@@ -0,0 +1,55 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// unpackOther handles archive entries other than regular files, which are not in the TOC.
+// Directories are created and PAX global headers ignored; all other types, like links and devices, are skipped with
+// a warning, so packages of newer sealpack versions can still be unpacked. With StrictEntries, they are rejected instead.
+func (arc *ReadArchive) unpackOther(outputPath string, h *tar.Header) error {
+	if arc.StrictEntries {
+		return WithHint(fmt.Errorf("unsupported entry type %q of %s", h.Typeflag, h.Name),
+			"the package may have been sealed by a newer sealpack version; unseal without strict entries to skip such entries")
+	}
+	switch h.Typeflag {
+	case tar.TypeXGlobalHeader:
+		return nil
+	case tar.TypeDir:
+		if !filepath.IsLocal(localFileName(h.Name)) {
+			return corruptEnvelope(fmt.Errorf("invalid entry name %s leading out of the output path", h.Name))
+		}
+		if arc.DryRun || arc.Output != nil {
+			return nil
+		}
+		fullDir := filepath.Join(outputPath, localFileName(h.Name))
+		if err := os.MkdirAll(fullDir, 0755); err != nil {
+			return fmt.Errorf("creating directory %s failed: %w", fullDir, err)
+		}
+		// applyDirectories handles the directories in front of a file, including the last one
+		return arc.applyDirectories(outputPath, fullDir+string(filepath.Separator))
+	case tar.TypeSymlink:
+		// Links are not signed, so they are never created
+		arc.logger().Warnf("unseal: skipping %s, symbolic links to %s are not created by unseal", h.Name, h.Linkname)
+		return nil
+	}
+	arc.logger().Warnf("unseal: skipping %s, entries of type %q are not supported by this sealpack version", h.Name, h.Typeflag)
+	return nil
+}
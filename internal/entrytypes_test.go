@@ -0,0 +1,80 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// otherEntriesArchive seals a file together with entries of other types, as newer sealpack versions may write them
+func otherEntriesArchive(t *testing.T) *WriteArchive {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	for _, h := range []*tar.Header{
+		{Typeflag: tar.TypeXGlobalHeader, Name: "global", PAXRecords: map[string]string{"comment": "future"}},
+		{Typeflag: tar.TypeDir, Name: "empty/dir/", Mode: 0755},
+		{Typeflag: tar.TypeSymlink, Name: "link.txt", Linkname: "/etc/passwd"},
+		{Typeflag: tar.TypeLink, Name: "hard.txt", Linkname: "data.txt"},
+		{Typeflag: tar.TypeFifo, Name: "fifo"},
+		{Typeflag: 'Z', Name: "future.bin"},
+	} {
+		assert.NoError(t, arc.tarWriter.WriteHeader(h))
+	}
+	assert.NoError(t, arc.AddToArchive("data.txt", []byte("contents")))
+	assert.NoError(t, sig.AddFile("data.txt", []byte("contents")))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+	return arc
+}
+
+func TestReadArchive_UnpackOtherEntries(t *testing.T) {
+	arc := otherEntriesArchive(t)
+	defer arc.Cleanup()
+	out := t.TempDir()
+	ra := openTestArchive(t, arc)
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""))
+	info, err := os.Stat(filepath.Join(out, "empty", "dir"))
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+	for _, skipped := range []string{"global", "link.txt", "hard.txt", "fifo", "future.bin"} {
+		_, err = os.Lstat(filepath.Join(out, skipped))
+		assert.ErrorIs(t, err, os.ErrNotExist, skipped)
+	}
+	written, err := os.ReadFile(filepath.Join(out, "data.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "contents", string(written))
+}
+
+func TestReadArchive_UnpackOtherEntriesStrict(t *testing.T) {
+	arc := otherEntriesArchive(t)
+	defer arc.Cleanup()
+	ra := openTestArchive(t, arc)
+	ra.StrictEntries = true
+	assert.ErrorContains(t, ra.Unpack("../test/public.pem", "SHA256", t.TempDir(), "", ""), "unsupported entry type 'g' of global")
+}
+
+func TestReadArchive_UnpackEscapingDirectory(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	arc, err := OpenArchiveReader(bytes.NewReader(tarSeed("../escape/", tar.TypeDir, "")), GetCompressionAlgoIndex(CompressionZip))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, arc.Unpack("../test/public.pem", "SHA256", out, "", ""), ErrCorruptEnvelope)
+	assert.NoDirExists(t, filepath.Join(filepath.Dir(out), "escape"))
+}
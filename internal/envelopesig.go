@@ -0,0 +1,136 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/innomotics/sealpack/format"
+	"io"
+)
+
+// maxSignatureLength limits the size of the signature in an envelope signature record
+const maxSignatureLength = format.MaxSignatureLength
+
+// IsSigned determines whether the envelope contains a signature record
+func (e *Envelope) IsSigned() bool {
+	return e.Version >= EnvelopeVersion4 && e.Flags&EnvelopeFlagSignature != 0
+}
+
+// signedMessage is what the envelope signature covers: the header, a SHA-256 digest of the payload, the index and labels records and the keys.
+// Thereby, neither the algorithms in the header can be changed nor recipients added or removed undetected.
+func (e *Envelope) signedMessage(payloadDigest []byte) ([]byte, error) {
+	msg := &bytes.Buffer{}
+	if err := e.WriteHeader(msg); err != nil {
+		return nil, err
+	}
+	msg.Write(payloadDigest)
+	if e.HasIndex() {
+		msg.Write(e.indexRecord())
+	}
+	if e.HasLabels() {
+		msg.Write(e.labelsRecord())
+	}
+	if err := e.WriteKeys(msg); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// sign creates the envelope signature with the Signer
+func (e *Envelope) sign(payloadDigest []byte) error {
+	msg, err := e.signedMessage(payloadDigest)
+	if err != nil {
+		return err
+	}
+	if e.SignatureHash, err = ParseSignatureHash(e.SignatureHash); err != nil {
+		return err
+	}
+	if e.Signature, err = e.Signer.SignMessage(bytes.NewReader(msg)); err != nil {
+		return fmt.Errorf("could not sign envelope: %w", err)
+	}
+	return nil
+}
+
+// VerifySignature checks the envelope signature with the public key of the signing entity.
+// The payload is read once to calculate its digest, the PayloadReader is reset to the payload afterwards.
+func (e *Envelope) VerifySignature(publicKeyPath string) error {
+	if !e.IsSigned() {
+		return fmt.Errorf("%w: envelope is not signed", ErrBadSignature)
+	}
+	verifier, err := CreateVerifierWithHash(publicKeyPath, e.SignatureHash)
+	if err != nil {
+		return err
+	}
+	if _, err = e.PayloadReader.Seek(e.headerSize(), io.SeekStart); err != nil {
+		return err
+	}
+	digest := sha256.New()
+	if _, err = io.CopyN(digest, e.PayloadReader, e.PayloadLen); err != nil {
+		return corruptEnvelope(err)
+	}
+	if _, err = e.PayloadReader.Seek(e.headerSize(), io.SeekStart); err != nil {
+		return err
+	}
+	msg, err := e.signedMessage(digest.Sum(nil))
+	if err != nil {
+		return err
+	}
+	if err = verifier.VerifySignature(bytes.NewReader(e.Signature), bytes.NewReader(msg)); err != nil {
+		return WithHint(fmt.Errorf("%w: envelope signature does not match: %w", ErrBadSignature, err), signerHint, publicKeyPath)
+	}
+	return nil
+}
+
+// signatureRecord encodes the name of the signature hash and the signature, each prefixed with its length as uvarint
+func signatureRecord(signatureHash string, sig []byte) []byte {
+	return format.AppendSignatureRecord(nil, signatureHash, sig)
+}
+
+// readSignatureRecord reads a signature record, providing the number of bytes read as well
+func readSignatureRecord(rd io.ByteReader) (signatureHash string, sig []byte, n int64, err error) {
+	var name []byte
+	if name, n, err = readRecordField(rd, format.MaxSignatureHashLength); err != nil {
+		return "", nil, n, err
+	}
+	var m int64
+	if sig, m, err = readRecordField(rd, maxSignatureLength); err != nil {
+		return "", nil, n + m, err
+	}
+	if signatureHash, err = ParseSignatureHash(string(name)); err != nil {
+		return "", nil, n + m, err
+	}
+	return signatureHash, sig, n + m, nil
+}
+
+// readRecordField reads a field prefixed with its length as uvarint
+func readRecordField(rd io.ByteReader, maxLength uint64) ([]byte, int64, error) {
+	length, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return nil, 0, err
+	}
+	if length > maxLength {
+		return nil, 0, fmt.Errorf("invalid field length %d", length)
+	}
+	field := make([]byte, length)
+	for i := range field {
+		if field[i], err = rd.ReadByte(); err != nil {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+	}
+	return field, int64(len(binary.AppendUvarint(nil, length))) + int64(length), nil
+}
@@ -0,0 +1,88 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvelope_VerifySignature(t *testing.T) {
+	raw := signTestEnvelope(t, &Envelope{Version: EnvelopeVersion4, ReceiverKeys: [][]byte{[]byte("fuyoooh!")}, SignatureHash: "sha-384"}, []byte("Hold your breath and count to 10."))
+
+	env, err := ParseEnvelope(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.True(t, env.IsSigned())
+	assert.Equal(t, "SHA384", env.SignatureHash)
+	assert.Equal(t, [][]byte{[]byte("fuyoooh!")}, env.ReceiverKeys)
+	assert.NoError(t, env.VerifySignature(filepath.Join(TestFilePath, "public.pem")))
+	assert.Contains(t, env.String(), "Envelope signed using SHA384")
+
+	// Payload can be read after verification
+	pos, err := env.PayloadReader.Seek(0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, env.headerSize(), pos)
+}
+
+func TestEnvelope_VerifySignatureTampered(t *testing.T) {
+	raw := signTestEnvelope(t, &Envelope{Version: EnvelopeVersion4, SignatureHash: "sha-384"}, []byte("Hold your breath and count to 10."))
+	publicKey := filepath.Join(TestFilePath, "public.pem")
+
+	env, err := ParseEnvelope(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	env.CompressionAlgo = 4
+	assert.ErrorIs(t, env.VerifySignature(publicKey), ErrBadSignature)
+
+	env, err = ParseEnvelope(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	env.ReceiverKeys = [][]byte{[]byte("attacker")}
+	assert.ErrorIs(t, env.VerifySignature(publicKey), ErrBadSignature)
+
+	// Changing the payload is detected as well, even if the checksum trailer is recalculated
+	env, err = ParseEnvelope(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	tampered := bytes.Clone(raw)
+	tampered[env.headerSize()] ^= 0xFF
+	env.PayloadReader = bytes.NewReader(tampered)
+	assert.ErrorIs(t, env.VerifySignature(publicKey), ErrBadSignature)
+}
+
+func TestEnvelope_Unsigned(t *testing.T) {
+	env, err := ParseEnvelope(bytes.NewReader(envelopeV2(t, []byte("payload"))))
+	assert.NoError(t, err)
+	assert.False(t, env.IsSigned())
+	assert.ErrorIs(t, env.VerifySignature(filepath.Join(TestFilePath, "public.pem")), ErrBadSignature)
+	assert.Contains(t, env.String(), "not signed")
+}
+
+func TestParseEnvelope_UnknownFlags(t *testing.T) {
+	raw := signTestEnvelope(t, &Envelope{Version: EnvelopeVersion4, SignatureHash: "sha-384"}, []byte("payload"))
+	raw[6] |= 1 << 7
+
+	_, err := ParseEnvelope(bytes.NewReader(raw))
+	assert.ErrorContains(t, err, "unsupported envelope flags")
+}
+
+func TestDiagnose_Signature(t *testing.T) {
+	raw := signTestEnvelope(t, &Envelope{Version: EnvelopeVersion4, ReceiverKeys: [][]byte{[]byte("fuyoooh!")}, SignatureHash: "sha-384"}, []byte("payload"))
+
+	d, err := Diagnose(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.True(t, d.Intact())
+	assert.Contains(t, section(d, "signature").Detail, "using SHA384")
+	assert.Contains(t, section(d, "keys").Detail, "1 receivers")
+}
@@ -0,0 +1,121 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Failure classes of sealpack operations. Errors returned by the library wrap one of these,
+// so callers can branch on the class using errors.Is.
+var (
+	ErrBadSignature    = errors.New("bad signature")
+	ErrNotRecipient    = errors.New("not a recipient")
+	ErrCorruptEnvelope = errors.New("corrupt envelope")
+	ErrNetwork         = errors.New("network failure")
+	ErrPartialImport   = errors.New("partial import")
+	ErrNoContainerD    = errors.New("no local containerd available")
+	ErrLimitExceeded   = errors.New("limit exceeded")
+	ErrLocked          = errors.New("locked by another unseal")
+)
+
+// signerHint explains signatures not matching the public key of the signing entity
+const signerHint = "the package was not signed with the private key matching %s, or it was modified after sealing; " +
+	"check that the signer public key belongs to the sealing entity"
+
+// awsError is implemented by errors of the AWS SDK, which do not support errors.Unwrap.
+type awsError interface {
+	OrigErr() error
+}
+
+// wrapNetworkError marks an error as ErrNetwork if it was caused by a network operation.
+// Other errors are returned unchanged.
+func wrapNetworkError(err error) error {
+	if err == nil || errors.Is(err, ErrNetwork) {
+		return err
+	}
+	var netErr net.Error
+	cause := err
+	for cause != nil {
+		if errors.As(cause, &netErr) {
+			return fmt.Errorf("%w: %w", ErrNetwork, err)
+		}
+		awsErr, ok := cause.(awsError)
+		if !ok {
+			break
+		}
+		cause = awsErr.OrigErr()
+	}
+	return err
+}
+
+// corruptEnvelope marks an error that occurred while parsing an envelope as ErrCorruptEnvelope.
+func corruptEnvelope(err error) error {
+	if err == nil || errors.Is(err, ErrCorruptEnvelope) {
+		return err
+	}
+	return WithHint(fmt.Errorf("%w: %w", ErrCorruptEnvelope, err),
+		"the file is not a sealpack package or it is damaged; run `sealpack diagnose` on it for details")
+}
+
+// hintError attaches a hint on how to resolve an error without changing its message
+type hintError struct {
+	err  error
+	hint string
+}
+
+func (e *hintError) Error() string {
+	return e.err.Error()
+}
+
+func (e *hintError) Unwrap() error {
+	return e.err
+}
+
+// WithHint attaches a hint on how to resolve the error. A nil error stays nil.
+func WithHint(err error, format string, args ...any) error {
+	if err == nil {
+		return nil
+	}
+	return &hintError{err: err, hint: fmt.Sprintf(format, args...)}
+}
+
+// Hints collects the hints attached to an error and all errors it wraps, outermost first, without duplicates
+func Hints(err error) []string {
+	var hints []string
+	seen := map[string]bool{}
+	var walk func(error)
+	walk = func(err error) {
+		if err == nil {
+			return
+		}
+		if h, ok := err.(*hintError); ok && !seen[h.hint] {
+			seen[h.hint] = true
+			hints = append(hints, h.hint)
+		}
+		switch e := err.(type) {
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				walk(inner)
+			}
+		}
+	}
+	walk(err)
+	return hints
+}
@@ -0,0 +1,107 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func Test_wrapNetworkError(t *testing.T) {
+	dnsErr := &net.DNSError{Err: "no such host", Name: "index.docker.io"}
+	tests := []struct {
+		name      string
+		err       error
+		isNetwork bool
+	}{
+		{"nil", nil, false},
+		{"plain error", fmt.Errorf("fnord"), false},
+		{"net error", dnsErr, true},
+		{"wrapped net error", fmt.Errorf("pull: %w", dnsErr), true},
+		{"AWS request error", awserr.New("RequestError", "send request failed", dnsErr), true},
+		{"AWS other error", awserr.New("AccessDenied", "access denied", nil), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := wrapNetworkError(tt.err)
+			assert.Equal(t, tt.isNetwork, errors.Is(err, ErrNetwork))
+			if tt.err != nil {
+				assert.ErrorContains(t, err, tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestParseEnvelope_CorruptEnvelope(t *testing.T) {
+	_, err := ParseEnvelope(bytes.NewReader([]byte("Pink fluffy unicorns dancing on rainbows.")))
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+	_, err = ParseEnvelope(bytes.NewReader([]byte("\xDBIPC\x07")))
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+}
+
+func TestVerifier_Verify_BadSignature(t *testing.T) {
+	fields := createValidVerifierFields()
+	v := &Verifier{
+		sigVerifier:  fields.sigVerifier,
+		toc:          fields.toc,
+		tocSignature: bytes.NewBuffer([]byte("Fnord")),
+		Signatures:   fields.Signatures,
+	}
+	assert.ErrorIs(t, v.Verify(t.TempDir(), "", ""), ErrBadSignature)
+}
+
+func TestGetPayload_NotRecipient(t *testing.T) {
+	envelope := &Envelope{ReceiverKeys: [][]byte{make([]byte, 512)}}
+	_, err := envelope.GetPayload("../test/private.pem")
+	assert.ErrorIs(t, err, ErrNotRecipient)
+	_, err = envelope.GetPayload("../test/ec-private.pem")
+	assert.ErrorIs(t, err, ErrNotRecipient)
+}
+
+func TestWithHint(t *testing.T) {
+	assert.NoError(t, WithHint(nil, "never shown"))
+	err := WithHint(fmt.Errorf("%w: fnord", ErrNotRecipient), "use key %s", "foo.pem")
+	assert.EqualError(t, err, "not a recipient: fnord")
+	assert.ErrorIs(t, err, ErrNotRecipient)
+	assert.Equal(t, []string{"use key foo.pem"}, Hints(err))
+}
+
+func TestHints(t *testing.T) {
+	inner := WithHint(errors.New("inner"), "inner hint")
+	outer := WithHint(fmt.Errorf("outer: %w", inner), "outer hint")
+	joined := errors.Join(outer, WithHint(errors.New("other"), "inner hint"), errors.New("plain"))
+	assert.Equal(t, []string{"outer hint", "inner hint"}, Hints(joined))
+	assert.Empty(t, Hints(errors.New("plain")))
+	assert.Empty(t, Hints(nil))
+}
+
+func TestGetPayload_Hints(t *testing.T) {
+	envelope := &Envelope{ReceiverKeys: [][]byte{make([]byte, 512)}}
+	_, err := envelope.GetPayload("../test/private.pem")
+	assert.Len(t, Hints(err), 1)
+	assert.Contains(t, Hints(err)[0], "sealed for 1 other keys; the public key of ../test/private.pem has the fingerprint sha256:")
+	_, err = envelope.GetPayload("../test/ec-private.pem")
+	assert.Len(t, Hints(err), 1)
+	assert.Contains(t, Hints(err)[0], "the public key of ../test/ec-private.pem has the fingerprint sha256:")
+	_, err = envelope.GetPayload("../test/public.pem")
+	assert.Equal(t, []string{"../test/public.pem contains a public key, but a private key is required here; did you swap the keys?"}, Hints(err))
+	_, err = ParseEnvelope(bytes.NewReader([]byte("Pink fluffy unicorns dancing on rainbows.")))
+	assert.Contains(t, Hints(err)[0], "sealpack diagnose")
+}
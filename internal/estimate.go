@@ -0,0 +1,142 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"io"
+	"strings"
+)
+
+const (
+	// estimateSampleSize limits the contents of an entry compressed to estimate its compression ratio
+	estimateSampleSize = 1 << 20
+	// tarEntryOverhead approximates header and padding of an entry in the payload
+	tarEntryOverhead = 1024
+	// tocOverhead approximates the TOC signature and the end of the payload
+	tocOverhead = 2048
+)
+
+// SizeEstimate are the expected sizes of a package, determined without pulling images
+type SizeEstimate struct {
+	Entries []*PlannedEntry
+	// Raw is the size of all contents
+	Raw int64
+	// Compressed is the expected size of the compressed payload
+	Compressed int64
+	// Encrypted is the expected size of the sealed package, including envelope and keys
+	Encrypted int64
+	// CompressionAlgo is the algorithm the compressed size is estimated for
+	CompressionAlgo uint8
+}
+
+// EstimateProviders resolves the entries of all providers and estimates the sizes of a package sealed with the envelope.
+// Local contents are sampled to estimate their compression, images and downloads are assumed not to compress.
+// The envelope receives the keys and records of the package, its payload is not used.
+func EstimateProviders(providers []ContentProvider, envelope *Envelope, compression CompressionOptions, public bool) (*SizeEstimate, error) {
+	estimate := &SizeEstimate{CompressionAlgo: envelope.CompressionAlgo}
+	compressed := float64(tocOverhead)
+	for _, provider := range providers {
+		entries, err := provider.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			planned, err := planEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+			ratio := 1.0
+			if entry.Local && !planned.Estimated {
+				if ratio, err = compressionRatio(entry, envelope.CompressionAlgo, compression); err != nil {
+					return nil, fmt.Errorf("failed sampling %s: %w", entry.Source, err)
+				}
+			}
+			estimate.Entries = append(estimate.Entries, planned)
+			estimate.Raw += planned.Size
+			// Every entry is also listed in the TOC with its hash
+			tocLine := len(planned.Name) + len(Delimiter) + envelope.HashAlgorithm.Size() + 1
+			compressed += float64(planned.Size)*ratio + float64(tarEntryOverhead+tocLine)
+		}
+	}
+	estimate.Compressed = int64(compressed)
+	estimate.Encrypted = estimate.Compressed
+	if !public {
+		estimate.Encrypted += encryptionOverhead(estimate.Compressed, envelope.Version)
+	}
+	overhead, err := envelope.overhead()
+	if err != nil {
+		return nil, err
+	}
+	estimate.Encrypted += overhead
+	if envelope.HasTrailer() {
+		// The trailer holds a checksum of every chunk of the payload
+		estimate.Encrypted += 4 * (estimate.Encrypted / checksumChunkSize)
+	}
+	return estimate, nil
+}
+
+// compressionRatio compresses the beginning of an entry like the payload and provides the ratio of compressed to raw size
+func compressionRatio(entry ProvidedEntry, compressionAlgo uint8, compression CompressionOptions) (float64, error) {
+	f, err := entry.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+	compression.Threads = 1
+	counter := &positionWriter{w: io.Discard}
+	arc := &WriteArchive{compression: compression}
+	arc.InitializeCompression(counter, compressionAlgo)
+	n, err := io.Copy(arc.compressWriter, io.LimitReader(f, estimateSampleSize))
+	if err != nil {
+		return 0, err
+	}
+	if err = arc.compressWriter.Close(); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 1, nil
+	}
+	return float64(counter.written) / float64(n), nil
+}
+
+// encryptionOverhead is the size added by encrypting a payload as the envelope version does
+func encryptionOverhead(size int64, version uint8) int64 {
+	if version >= EnvelopeVersion5 {
+		frames := size/PayloadFrameSize + 1
+		return frameNoncePrefixSize + frames*chacha20poly1305.Overhead
+	}
+	return chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+}
+
+// overhead writes the envelope without payload and provides its size
+func (e *Envelope) overhead() (int64, error) {
+	counter := &positionWriter{w: io.Discard}
+	if err := e.writeEnvelope(counter, strings.NewReader("")); err != nil {
+		return 0, err
+	}
+	return counter.written, nil
+}
+
+// String formats the estimate as a human-readable report
+func (s *SizeEstimate) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(PlanString(s.Entries))
+	sb.WriteString("Expected sizes:\n")
+	sb.WriteString(fmt.Sprintf("\traw:        %d Bytes\n", s.Raw))
+	sb.WriteString(fmt.Sprintf("\tcompressed: ~%d Bytes (%s)\n", s.Compressed, compressionAlgorithms[s.CompressionAlgo]))
+	sb.WriteString(fmt.Sprintf("\tencrypted:  ~%d Bytes\n", s.Encrypted))
+	return sb.String()
+}
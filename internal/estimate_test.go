@@ -0,0 +1,82 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEstimateProviders(t *testing.T) {
+	old := pullImage
+	defer func() { pullImage = old }()
+	img, err := random.Image(1024, 2)
+	assert.NoError(t, err)
+	pullImage = func(src string, opt ...crane.Option) (v1.Image, error) {
+		return img, nil
+	}
+	dir := t.TempDir()
+	noise := make([]byte, 1<<20)
+	_, _ = rand.Read(noise)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "noise.bin"), noise, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "text.txt"), bytes.Repeat([]byte("hello world\n"), 1<<16), 0644))
+	providers := []ContentProvider{FileProvider(dir), &ImageProvider{Image: ParseContainerImage("alpine:3.17")}}
+	envelope := &Envelope{Version: EnvelopeVersionLatest, HashAlgorithm: crypto.SHA512}
+
+	estimate, err := EstimateProviders(providers, envelope, CompressionOptions{}, false)
+	assert.NoError(t, err)
+	assert.Len(t, estimate.Entries, 3)
+	imageSize := estimate.Entries[2].Size
+	assert.Equal(t, int64(1<<20+12<<16)+imageSize, estimate.Raw)
+	// Noise and image do not compress, the text does
+	assert.Greater(t, estimate.Compressed, int64(1<<20)+imageSize)
+	assert.Less(t, estimate.Compressed, int64(1<<20)+imageSize+64<<10)
+	assert.Greater(t, estimate.Encrypted, estimate.Compressed)
+	assert.Contains(t, estimate.String(), "compressed: ~")
+	assert.Contains(t, estimate.String(), "(gzip)")
+}
+
+func TestEstimateProviders_MatchesArchive(t *testing.T) {
+	dir := t.TempDir()
+	contents := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 1<<14)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "fox.txt"), contents, 0644))
+	envelope := &Envelope{Version: EnvelopeVersionLatest, HashAlgorithm: crypto.SHA512}
+	estimate, err := EstimateProviders([]ContentProvider{FileProvider(filepath.Join(dir, "fox.txt"))}, envelope, CompressionOptions{}, true)
+	assert.NoError(t, err)
+
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	sig := NewSignatureList("SHA512")
+	assert.NoError(t, arc.AddToArchive("fox.txt", contents))
+	assert.NoError(t, sig.AddFile("fox.txt", contents))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	size, err := arc.Finalize()
+	assert.NoError(t, err)
+	// Small payloads are dominated by the approximated overhead
+	assert.InDelta(t, size, estimate.Compressed, 4096)
+}
+
+func TestEncryptionOverhead(t *testing.T) {
+	assert.Equal(t, int64(frameNoncePrefixSize+16), encryptionOverhead(0, EnvelopeVersion5))
+	assert.Equal(t, int64(frameNoncePrefixSize+3*16), encryptionOverhead(2*PayloadFrameSize+1, EnvelopeVersion5))
+	assert.Equal(t, int64(24+16), encryptionOverhead(PayloadFrameSize, EnvelopeVersion4))
+}
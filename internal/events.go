@@ -0,0 +1,69 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+// Events receives the progress of sealing, unsealing and rolling back, so applications embedding sealpack can
+// drive UIs, progress APIs and auditing without parsing logs. Callbacks are called synchronously and should return quickly.
+// OnEntryExtracted is called concurrently if files are written in parallel.
+type Events interface {
+	// OnFileAdded is called after a file has been hashed and added to a package being sealed
+	OnFileAdded(entry ReportEntry)
+	// OnImagePulled is called after a container image has been pulled and added to a package being sealed
+	OnImagePulled(entry ReportEntry)
+	// OnEntryExtracted is called after a file has been written or an image has been imported while unsealing
+	OnEntryExtracted(entry ReportEntry)
+	// OnVerificationResult is called once the signatures of a package have been verified; err is nil if they are valid
+	OnVerificationResult(err error)
+	// OnRollback is called after a rollback bundle has been applied; err is nil if the rollback succeeded
+	OnRollback(bundle string, err error)
+}
+
+// NoEvents ignores all events; embed it to only implement the events of interest
+type NoEvents struct{}
+
+func (NoEvents) OnFileAdded(ReportEntry)      {}
+func (NoEvents) OnImagePulled(ReportEntry)    {}
+func (NoEvents) OnEntryExtracted(ReportEntry) {}
+func (NoEvents) OnVerificationResult(error)   {}
+func (NoEvents) OnRollback(string, error)     {}
+
+// finishEntry finishes an entry of a package being sealed, adds it to the report and passes it to the events
+func (arc *WriteArchive) finishEntry(entry *ReportEntry, signatures *FileSignatures) {
+	if arc.Report == nil && arc.Events == nil {
+		return
+	}
+	entry.finish(signatures)
+	arc.Report.add(entry)
+	if arc.Events == nil {
+		return
+	}
+	if entry.Type == ReportTypeImage {
+		arc.Events.OnImagePulled(*entry)
+	} else {
+		arc.Events.OnFileAdded(*entry)
+	}
+}
+
+// finishEntry finishes an entry of a package being unsealed, adds it to the report and passes it to the events
+func (arc *ReadArchive) finishEntry(entry *ReportEntry, signatures *FileSignatures) {
+	if arc.Report == nil && arc.Events == nil {
+		return
+	}
+	entry.finish(signatures)
+	arc.Report.add(entry)
+	if arc.Events != nil {
+		arc.Events.OnEntryExtracted(*entry)
+	}
+}
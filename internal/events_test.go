@@ -0,0 +1,74 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// recordedEvents records the names of all entries and the verification results
+type recordedEvents struct {
+	NoEvents
+	mutex     sync.Mutex
+	added     []string
+	extracted []string
+	verified  []error
+}
+
+func (e *recordedEvents) OnFileAdded(entry ReportEntry) {
+	e.added = append(e.added, entry.Name)
+}
+
+func (e *recordedEvents) OnEntryExtracted(entry ReportEntry) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.extracted = append(e.extracted, entry.Name)
+}
+
+func (e *recordedEvents) OnVerificationResult(err error) {
+	e.verified = append(e.verified, err)
+}
+
+func TestEvents_SealAndUnpack(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "data.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("Hold your breath and count to 10."), 0644))
+	events := &recordedEvents{}
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.Events = events
+	sig := NewSignatureList("SHA256")
+	assert.NoError(t, arc.AddProviders([]ContentProvider{FileProvider(src)}, sig))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"data.txt"}, events.added)
+
+	ra := openTestArchive(t, arc)
+	ra.Events = events
+	ra.Parallel = 2
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", t.TempDir(), "", ""))
+	assert.Equal(t, []string{"data.txt"}, events.extracted)
+	assert.Equal(t, []error{nil}, events.verified)
+
+	ra = openTestArchive(t, arc)
+	ra.Events = events
+	assert.Error(t, ra.Unpack("../test/ec-public.pem", "SHA256", t.TempDir(), "", ""))
+	assert.Len(t, events.verified, 2)
+	assert.ErrorIs(t, events.verified[1], ErrBadSignature)
+}
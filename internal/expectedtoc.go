@@ -0,0 +1,159 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ReadExpectedToc reads an expected TOC, e.g. from a build manifest, mapping entry names to hex digests.
+// It has the format of sha256sum: one "<digest>  <name>" line per entry; empty lines and lines starting with # are ignored.
+func ReadExpectedToc(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseExpectedToc(data)
+}
+
+// parseExpectedToc parses the lines of an expected TOC, unescaping names like sha256sum does
+func parseExpectedToc(data []byte) (map[string]string, error) {
+	expected := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		escaped := strings.HasPrefix(text, "\\")
+		digest, name, found := strings.Cut(strings.TrimPrefix(text, "\\"), " ")
+		if !found || len(name) < 2 || (name[0] != ' ' && name[0] != '*') {
+			return nil, fmt.Errorf("invalid expected TOC line %d: %q", line, text)
+		}
+		if _, err := hex.DecodeString(digest); err != nil {
+			return nil, fmt.Errorf("invalid digest in expected TOC line %d: %q", line, digest)
+		}
+		name = name[1:]
+		if escaped {
+			name = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(name)
+		}
+		if _, ok := expected[name]; ok {
+			return nil, fmt.Errorf("duplicate entry %s in expected TOC line %d", name, line)
+		}
+		expected[name] = strings.ToLower(digest)
+	}
+	return expected, scanner.Err()
+}
+
+// Digests reads all contents of the archive without extracting them and verifies them against the signed TOC.
+// It provides the hex digests of all entries of the TOC calculated using the hash, keyed by entry name.
+func (arc *ReadArchive) Digests(signingKeyPath, hashingAlgorithm string, hash crypto.Hash) (map[string]string, error) {
+	verifier, err := NewVerifier(signingKeyPath, hashingAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	verifier.Logger = arc.Logger
+	defer arc.removeChunks()
+	digests := map[string]string{}
+	limits := &limitCounter{limits: arc.Limits}
+	for {
+		var h *tar.Header
+		h, err = arc.TarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = limits.add(h); err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.HasPrefix(h.Name, TocFileName):
+			err = verifier.AddTocComponent(h, arc.TarReader)
+		case h.Name == ProvenanceFileName || h.Name == RekorFileName:
+			// The provenance and the Rekor entry are verified on their own and not part of the TOC
+		case isLayerEntry(h):
+			err = arc.cacheLayer(h)
+		case h.Name == MetadataFileName || h.Name == RollbackFileName || h.Name == OriginsFileName:
+			// Metadata, origins and rollback manifests are part of the TOC, but not of build manifests
+			err = verifier.Signatures.AddFileFromReader(h.Name, arc.TarReader)
+		default:
+			var contents io.Reader
+			if contents, err = arc.contentReader(h); err != nil {
+				return nil, err
+			}
+			sum := hash.New()
+			err = verifier.Signatures.AddFileWhileReading(h.Name, contents, func(r io.Reader) error {
+				_, err := io.Copy(sum, r)
+				return err
+			})
+			digests[h.Name] = hex.EncodeToString(sum.Sum(nil))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if verifier.toc == nil || verifier.tocSignature == nil {
+		return nil, fmt.Errorf("%w: package contains no signed TOC", ErrBadSignature)
+	}
+	// Nothing has been written, so there is nothing to roll back
+	if err = verifier.Verify("", "", ""); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// CompareToc checks that the digests contain exactly the entries of the expected TOC with the same digests.
+// All differences are reported at once.
+func CompareToc(expected, digests map[string]string) error {
+	names := make([]string, 0, len(expected)+len(digests))
+	for name := range expected {
+		names = append(names, name)
+	}
+	for name := range digests {
+		if _, ok := expected[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		want, declared := expected[name]
+		got, contained := digests[name]
+		switch {
+		case !contained:
+			errs = append(errs, fmt.Errorf("%s is missing in the package", name))
+		case !declared:
+			errs = append(errs, fmt.Errorf("%s is not declared in the expected TOC", name))
+		case want != got:
+			errs = append(errs, fmt.Errorf("%s has digest %s, expected %s", name, got, want))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("package does not match the expected TOC: %w", errors.Join(errs...))
+	}
+	return nil
+}
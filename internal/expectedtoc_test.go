@@ -0,0 +1,100 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	// fooDigest is the SHA-256 digest of "foo"
+	fooDigest = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	// barDigest is the SHA-256 digest of "bar"
+	barDigest = "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9"
+)
+
+func TestParseExpectedToc(t *testing.T) {
+	expected, err := parseExpectedToc([]byte("# build 42\n" + fooDigest + "  dir/foo\n\n" +
+		barDigest + " *bar\r\n\\" + fooDigest + "  new\\nline\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"dir/foo": fooDigest, "bar": barDigest, "new\nline": fooDigest}, expected)
+
+	_, err = parseExpectedToc([]byte(fooDigest + "\n"))
+	assert.ErrorContains(t, err, "invalid expected TOC line 1")
+	_, err = parseExpectedToc([]byte("xyz  foo\n"))
+	assert.ErrorContains(t, err, "invalid digest in expected TOC line 1")
+	_, err = parseExpectedToc([]byte(fooDigest + "  foo\n" + barDigest + "  foo\n"))
+	assert.ErrorContains(t, err, "duplicate entry foo in expected TOC line 2")
+}
+
+func TestReadExpectedToc(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "toc.txt")
+	assert.NoError(t, os.WriteFile(path, []byte(fooDigest+"  foo\n"), 0644))
+	expected, err := ReadExpectedToc(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": fooDigest}, expected)
+	_, err = ReadExpectedToc(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestCompareToc(t *testing.T) {
+	digests := map[string]string{"foo": fooDigest, "bar": barDigest}
+	assert.NoError(t, CompareToc(map[string]string{"foo": fooDigest, "bar": barDigest}, digests))
+
+	err := CompareToc(map[string]string{"foo": barDigest, "baz": fooDigest}, digests)
+	assert.ErrorContains(t, err, "package does not match the expected TOC")
+	assert.ErrorContains(t, err, "bar is not declared in the expected TOC")
+	assert.ErrorContains(t, err, "baz is missing in the package")
+	assert.ErrorContains(t, err, "foo has digest "+fooDigest+", expected "+barDigest)
+}
+
+func TestReadArchive_Digests(t *testing.T) {
+	sig := NewSignatureList("SHA512")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	for name, contents := range map[string]string{"foo": "foo", "dir/bar": "bar"} {
+		assert.NoError(t, arc.AddToArchive(name, []byte(contents)))
+		assert.NoError(t, sig.AddFile(name, []byte(contents)))
+	}
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+
+	digests, err := openTestArchive(t, arc).Digests("../test/public.pem", "SHA512", crypto.SHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": fooDigest, "dir/bar": barDigest}, digests)
+
+	_, err = openTestArchive(t, arc).Digests("../test/pkcs1-public.pem", "SHA512", crypto.SHA256)
+	assert.ErrorIs(t, err, ErrBadSignature)
+
+	ra := openTestArchive(t, arc)
+	ra.Limits = UnpackLimits{MaxEntries: 2}
+	_, err = ra.Digests("../test/public.pem", "SHA512", crypto.SHA256)
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+}
+
+func TestReadArchive_Digests_Unsigned(t *testing.T) {
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	assert.NoError(t, arc.AddToArchive("foo", []byte("foo")))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+	_, err = openTestArchive(t, arc).Digests("../test/public.pem", "SHA512", crypto.SHA256)
+	assert.ErrorIs(t, err, ErrBadSignature)
+}
@@ -0,0 +1,80 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EntryFailure is an entry that could not be written or imported while unpacking with ContinueOnError
+type EntryFailure struct {
+	Name        string
+	Destination string
+	Err         error
+}
+
+// abortError marks failures that abort Unpack even with ContinueOnError, as the user asked for it
+type abortError struct {
+	err error
+}
+
+func (e *abortError) Error() string {
+	return e.err.Error()
+}
+
+func (e *abortError) Unwrap() error {
+	return e.err
+}
+
+// abortUnpack marks an error to abort Unpack even with ContinueOnError
+func abortUnpack(err error) error {
+	return &abortError{err: err}
+}
+
+// tolerate records the failure to write or import an entry and clears the error if ContinueOnError is set,
+// so the remaining contents of the entry are only hashed and Unpack continues with the next entry.
+// Failures concerning the package as a whole, like bad signatures, corruptions and exceeded limits, are returned.
+func (arc *ReadArchive) tolerate(entry *ReportEntry, err error) error {
+	var abort *abortError
+	if err == nil || !arc.ContinueOnError || arc.Output != nil || errors.As(err, &abort) ||
+		errors.Is(err, ErrBadSignature) || errors.Is(err, ErrCorruptEnvelope) || errors.Is(err, ErrLimitExceeded) {
+		return err
+	}
+	entry.Error = err.Error()
+	if entry.Type == ReportTypeImage && entry.ImportStatus != ImportStatusFailed {
+		entry.ImportStatus = ImportStatusFailed
+	}
+	arc.logger().Errorf("unseal: %s failed, continuing with the remaining entries: %v", entry.Name, err)
+	arc.failuresMutex.Lock()
+	defer arc.failuresMutex.Unlock()
+	arc.Failures = append(arc.Failures, &EntryFailure{Name: entry.Name, Destination: entry.Destination, Err: err})
+	return nil
+}
+
+// failuresError summarizes the entries that failed after the package was verified, so it is known that all others are intact
+func (arc *ReadArchive) failuresError() error {
+	if len(arc.Failures) == 0 {
+		return nil
+	}
+	sb := strings.Builder{}
+	for _, f := range arc.Failures {
+		sb.WriteString(fmt.Sprintf("\n\t%s: %v", f.Name, f.Err))
+	}
+	arc.logger().Errorf("unseal: %d entries failed, all other contents are verified:%s", len(arc.Failures), sb.String())
+	return WithHint(fmt.Errorf("%w: %d entries could not be written or imported", ErrPartialImport, len(arc.Failures)),
+		"the package is intact; fix the causes listed and unseal it again")
+}
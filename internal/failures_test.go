@@ -0,0 +1,88 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingArchive seals three files, of which b.txt cannot be written as a directory of that name exists in the output path
+func failingArchive(t *testing.T) (*WriteArchive, string) {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		assert.NoError(t, arc.AddToArchive(name, []byte("contents of "+name)))
+		assert.NoError(t, sig.AddFile(name, []byte("contents of "+name)))
+	}
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+	out := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(out, "b.txt"), 0755))
+	return arc, out
+}
+
+func TestReadArchive_UnpackContinueOnError(t *testing.T) {
+	for _, parallel := range []int{1, 2} {
+		arc, out := failingArchive(t)
+		defer arc.Cleanup()
+		ra := openTestArchive(t, arc)
+		ra.ContinueOnError = true
+		ra.Parallel = parallel
+		ra.Report = NewReport("unseal", "test.ipc", "SHA256")
+		err := ra.Unpack("../test/public.pem", "SHA256", out, "", "")
+		assert.ErrorIs(t, err, ErrPartialImport)
+		assert.ErrorContains(t, err, "1 entries could not be written or imported")
+		assert.Len(t, ra.Failures, 1)
+		assert.Equal(t, "b.txt", ra.Failures[0].Name)
+		// The entries after the failed one are written and verified
+		for _, name := range []string{"a.txt", "c.txt"} {
+			written, err := os.ReadFile(filepath.Join(out, name))
+			assert.NoError(t, err)
+			assert.Equal(t, "contents of "+name, string(written))
+		}
+		for _, entry := range ra.Report.Entries {
+			if entry.Name == "b.txt" {
+				assert.NotEmpty(t, entry.Error)
+			} else {
+				assert.Empty(t, entry.Error, entry.Name)
+			}
+		}
+	}
+}
+
+func TestReadArchive_UnpackStopsOnError(t *testing.T) {
+	arc, out := failingArchive(t)
+	defer arc.Cleanup()
+	ra := openTestArchive(t, arc)
+	assert.Error(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""))
+	assert.Empty(t, ra.Failures)
+	assert.NoFileExists(t, filepath.Join(out, "c.txt"))
+}
+
+func TestReadArchive_ContinueOnErrorAborts(t *testing.T) {
+	arc, out := failingArchive(t)
+	defer arc.Cleanup()
+	assert.NoError(t, os.WriteFile(filepath.Join(out, "a.txt"), []byte("old"), 0644))
+	ra := openTestArchive(t, arc)
+	ra.ContinueOnError = true
+	ra.OnConflict = ConflictFail
+	// The conflict policy asks to abort, so it is not recorded as failure
+	assert.ErrorContains(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""), "a.txt exists already")
+	assert.Empty(t, ra.Failures)
+}
@@ -16,19 +16,36 @@ package internal
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/innomotics/sealpack/internal/aws"
 	"io"
 	"os"
 	"strings"
+	"time"
 )
 
 var uploadS3 = aws.S3UploadArchive
+var downloadS3 = aws.S3DownloadResource
+var presignS3 = aws.S3CreatePresignedDownload
 var stdout = os.Stdout
+var stdin io.Reader = os.Stdin
+
+// ReadFileBytes reads a regular file, S3 object or stdin completely
+func ReadFileBytes(input string) ([]byte, error) {
+	if strings.HasPrefix(strings.ToLower(input), aws.S3UriPrefix) {
+		contents, err := downloadS3(input)
+		return contents, wrapNetworkError(err)
+	}
+	if input == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(input)
+}
 
 // WriteFileBytes allows for writing a byte slice to a regular file, S3 bucket or stdout
 func WriteFileBytes(output string, contents []byte) error {
 	if strings.HasPrefix(output, aws.S3UriPrefix) {
-		return uploadS3(bytes.NewReader(contents), output)
+		return wrapNetworkError(uploadS3(bytes.NewReader(contents), output))
 	} else {
 		var of io.ReadWriteCloser
 		var err error
@@ -65,9 +82,26 @@ func CleanupFileWriter(output string, f *os.File) error {
 			return err
 		}
 		if err = uploadS3(tmp, output); err != nil {
-			return err
+			return wrapNetworkError(err)
 		}
 		return os.RemoveAll(f.Name())
 	}
 	return nil
 }
+
+// MaxPresignValidity is the longest validity of presigned download links
+const MaxPresignValidity = aws.MaxPresignValidity
+
+// IsS3Location checks whether a path refers to an S3 object
+func IsS3Location(path string) bool {
+	return strings.HasPrefix(strings.ToLower(path), aws.S3UriPrefix)
+}
+
+// PresignDownload creates a link to download an uploaded S3 object without access to its bucket, valid for validity
+func PresignDownload(output string, validity time.Duration) (string, error) {
+	if !IsS3Location(output) {
+		return "", fmt.Errorf("download links can only be created for S3 outputs, not %s", output)
+	}
+	link, err := presignS3(output, validity)
+	return link, wrapNetworkError(err)
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// localFileName converts a slash separated archive name into a local file name
+func localFileName(name string) string {
+	return filepath.FromSlash(name)
+}
+
+// longOutputPath provides the output path to unpack to; only Windows limits the length of relative paths
+func longOutputPath(outputPath string) string {
+	return outputPath
+}
+
+// readUmask provides the file mode creation mask of the process. Linux reports it without changing it;
+// elsewhere it is set and restored, so it must be read before any files are created in parallel.
+func readUmask() os.FileMode {
+	if status, err := os.ReadFile("/proc/self/status"); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if value, ok := strings.CutPrefix(line, "Umask:"); ok {
+				if mask, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32); err == nil {
+					return os.FileMode(mask)
+				}
+			}
+		}
+	}
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return os.FileMode(mask)
+}
@@ -20,9 +20,11 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 )
 
 func Test_WriteFile(t *testing.T) {
@@ -179,3 +181,41 @@ func TestCleanupFileWriter_ErrorsUpload(t *testing.T) {
 	assert.ErrorContains(t, result, "faked upload error here")
 	uploadS3 = tmp
 }
+
+func TestResolveFiles_SlashNames(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "data", "sub"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "data", "sub", "a.txt"), []byte("a"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "data", "b.txt"), []byte("b"), 0644))
+	resolved, err := ResolveFiles([]string{filepath.Join(dir, "data")})
+	assert.NoError(t, err)
+	var names []string
+	for _, r := range resolved {
+		names = append(names, r.Name)
+	}
+	assert.ElementsMatch(t, []string{"data/b.txt", "data/sub"}, names)
+}
+
+func Test_localFileName(t *testing.T) {
+	name := localFileName(".images/cr.siemens.com/sealpack:latest.oci")
+	assert.Equal(t, ".images", strings.Split(name, string(filepath.Separator))[0])
+	if runtime.GOOS == "windows" {
+		assert.NotContains(t, name, ":")
+	}
+}
+
+func TestPresignDownload(t *testing.T) {
+	tmp := presignS3
+	defer func() { presignS3 = tmp }()
+	presignS3 = func(uri string, validity time.Duration) (string, error) {
+		assert.Equal(t, "s3://bucket/fw.sealed", uri)
+		assert.Equal(t, 72*time.Hour, validity)
+		return "https://bucket.s3.amazonaws.com/fw.sealed?X-Amz-Signature=abc", nil
+	}
+	link, err := PresignDownload("s3://bucket/fw.sealed", 72*time.Hour)
+	assert.NoError(t, err)
+	assert.Contains(t, link, "X-Amz-Signature")
+
+	_, err = PresignDownload("fw.sealed", time.Hour)
+	assert.ErrorContains(t, err, "only be created for S3 outputs")
+}
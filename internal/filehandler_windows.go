@@ -0,0 +1,46 @@
+//go:build windows
+
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// invalidNameChars replaces characters that are valid in archive names but not in Windows file names,
+// e.g. the colon separating image tags.
+var invalidNameChars = strings.NewReplacer(":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+
+// localFileName converts a slash separated archive name into a local file name
+func localFileName(name string) string {
+	return filepath.FromSlash(invalidNameChars.Replace(name))
+}
+
+// longOutputPath makes the output path absolute. Go uses extended-length paths for absolute paths only,
+// so files below it can exceed MAX_PATH of 260 characters.
+func longOutputPath(outputPath string) string {
+	if abs, err := filepath.Abs(outputPath); err == nil {
+		return abs
+	}
+	return outputPath
+}
+
+// readUmask provides the file mode creation mask of the process; Windows has none
+func readUmask() os.FileMode {
+	return 0
+}
@@ -0,0 +1,77 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// signTestEnvelope provides the bytes of an envelope with the payload, signed with the test key using its SignatureHash.
+// Version, receiver keys and labels are taken from the envelope; the hash algorithm defaults to SHA256.
+func signTestEnvelope(t *testing.T, envelope *Envelope, payload []byte) []byte {
+	payloadFile := filepath.Join(t.TempDir(), "payload")
+	assert.NoError(t, os.WriteFile(payloadFile, payload, 0644))
+	signatureHash, err := ParseSignatureHash(envelope.SignatureHash)
+	assert.NoError(t, err)
+	envelope.Signer, err = CreateSignerWithHash(filepath.Join(TestFilePath, "private.pem"), signatureHash)
+	assert.NoError(t, err)
+	envelope.PayloadLen = int64(len(payload))
+	if envelope.HashAlgorithm == 0 {
+		envelope.HashAlgorithm = crypto.SHA256
+	}
+	envelope.PayloadWriter, err = os.Open(payloadFile)
+	assert.NoError(t, err)
+	defer envelope.PayloadWriter.Close()
+	return envelope.ToBytes()
+}
+
+// sealTestArchive seals files with the contents by name and a TOC signed with the test key. The files are added from
+// disk, so their sources are recorded. Extend, if not nil, adds further components before the archive is finalized,
+// like a provenance statement or a Rekor entry. The archive is cleaned up with the test.
+func sealTestArchive(t *testing.T, contents map[string]string, extend func(arc *WriteArchive, sig *FileSignatures)) *WriteArchive {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	t.Cleanup(func() { _ = arc.Cleanup() })
+	dir := t.TempDir()
+	for name, data := range contents {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+		f, err := os.Open(path)
+		assert.NoError(t, err)
+		assert.NoError(t, arc.storeContents(f, name, path, 0, nil, sig))
+	}
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	if extend != nil {
+		extend(arc, sig)
+	}
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+	return arc
+}
+
+// openTestArchive opens an archive written by the test for reading
+func openTestArchive(t *testing.T, arc *WriteArchive) *ReadArchive {
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	return ra
+}
@@ -0,0 +1,276 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// fleetKeyMinLength is the minimum length of a fleet master secret or device key in bytes
+	fleetKeyMinLength = 32
+	// fleetSaltLength is the length of the random HKDF salt stored with the fleet key entry
+	fleetSaltLength = 32
+	// fleetTagLength is the length of the tag a device finds its record in the fleet key entry by
+	fleetTagLength = 16
+	// fleetInfoPrefix is prepended to the fleet ID to form the HKDF info of the wrapping keys
+	fleetInfoPrefix = "sealpack fleet key "
+	// fleetDeviceInfoPrefix is prepended to fleet and device ID to form the HKDF info of device keys
+	fleetDeviceInfoPrefix = "sealpack fleet device "
+	// DefaultFleetID is the fleet ID used if none is provided
+	DefaultFleetID = "default"
+)
+
+// LoadFleetKey reads a fleet master secret or a device key derived from it from a file
+func LoadFleetKey(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) < fleetKeyMinLength {
+		return nil, fmt.Errorf("fleet key must be at least %d bytes, got %d", fleetKeyMinLength, len(secret))
+	}
+	return secret, nil
+}
+
+// LoadFleetDevices reads the IDs of the devices of a fleet from a file, one per line.
+// Empty lines and lines starting with # are ignored, duplicates are only returned once.
+func LoadFleetDevices(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var devices []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		device := strings.TrimSpace(scanner.Text())
+		if device == "" || strings.HasPrefix(device, "#") || seen[device] {
+			continue
+		}
+		seen[device] = true
+		devices = append(devices, device)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no device IDs in %s", path)
+	}
+	return devices, nil
+}
+
+// DeriveDeviceKey derives the key of a single device of a fleet from the fleet master secret using HKDF-SHA256.
+// Devices are provisioned with their device key only, the master secret never leaves the sealing side.
+func DeriveDeviceKey(secret []byte, fleetID, deviceID string) ([]byte, error) {
+	if deviceID == "" {
+		return nil, fmt.Errorf("no device ID provided")
+	}
+	key := make([]byte, fleetKeyMinLength)
+	info := fleetDeviceInfoPrefix + fleetID + "\x00" + deviceID
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// deriveFleetKey derives the wrapping key of a package for a device and the tag of its record from the device key
+// using HKDF-SHA256. As the salt is random for every package, so is the wrapping key.
+func deriveFleetKey(deviceKey, salt []byte, fleetID string) (key, tag []byte, err error) {
+	okm := make([]byte, chacha20poly1305.KeySize+fleetTagLength)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, deviceKey, salt, []byte(fleetInfoPrefix+fleetID)), okm); err != nil {
+		return nil, nil, err
+	}
+	return okm[:chacha20poly1305.KeySize], okm[chacha20poly1305.KeySize:], nil
+}
+
+// fleetRecordLength is the length of the record of a single device for a payload key of keyLen bytes
+func fleetRecordLength(keyLen int) int {
+	return fleetTagLength + keyLen + chacha20poly1305.Overhead
+}
+
+// WrapFleetKey encrypts the symmetric key for every device of a fleet with a key derived from its device key.
+// The resulting entry consists of the HKDF salt, the length of the symmetric key as uvarint and a record per device,
+// holding its tag and the sealed key.
+func WrapFleetKey(secret []byte, fleetID string, deviceIDs []string, plainKey []byte) ([]byte, error) {
+	if len(deviceIDs) == 0 {
+		return nil, fmt.Errorf("no device IDs provided")
+	}
+	entry := make([]byte, fleetSaltLength, fleetSaltLength+binary.MaxVarintLen64+len(deviceIDs)*fleetRecordLength(len(plainKey)))
+	if _, err := rand.Read(entry); err != nil {
+		return nil, err
+	}
+	entry = binary.AppendUvarint(entry, uint64(len(plainKey)))
+	for _, deviceID := range deviceIDs {
+		deviceKey, err := DeriveDeviceKey(secret, fleetID, deviceID)
+		if err != nil {
+			return nil, err
+		}
+		entry, err = sealFleetRecord(entry, deviceKey, fleetID, plainKey)
+		wipe(deviceKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+// sealFleetRecord appends the record of a device to the fleet key entry, which already starts with the salt
+func sealFleetRecord(entry, deviceKey []byte, fleetID string, plainKey []byte) ([]byte, error) {
+	key, tag, err := deriveFleetKey(deviceKey, entry[:fleetSaltLength], fleetID)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(key)
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	// The wrapping key is only used once, so is the all-zero nonce
+	return aead.Seal(append(entry, tag...), make([]byte, aead.NonceSize()), plainKey, []byte(fleetID)), nil
+}
+
+// parseFleetEntry splits a fleet key entry into its salt and device records
+func parseFleetEntry(entry []byte) (salt []byte, records [][]byte, err error) {
+	if len(entry) <= fleetSaltLength {
+		return nil, nil, fmt.Errorf("invalid fleet key entry length %d", len(entry))
+	}
+	keyLen, n := binary.Uvarint(entry[fleetSaltLength:])
+	if n <= 0 || keyLen == 0 || keyLen > maxKeyLength {
+		return nil, nil, fmt.Errorf("invalid fleet key entry length %d", len(entry))
+	}
+	body, recordLen := entry[fleetSaltLength+n:], fleetRecordLength(int(keyLen))
+	if len(body) == 0 || len(body)%recordLen != 0 {
+		return nil, nil, fmt.Errorf("invalid fleet key entry length %d", len(entry))
+	}
+	for len(body) > 0 {
+		records, body = append(records, body[:recordLen]), body[recordLen:]
+	}
+	return entry[:fleetSaltLength], records, nil
+}
+
+// UnwrapFleetKey decrypts the symmetric key wrapped by WrapFleetKey with the key of a single device
+func UnwrapFleetKey(deviceKey []byte, fleetID string, entry []byte) ([]byte, error) {
+	salt, records, err := parseFleetEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	key, tag, err := deriveFleetKey(deviceKey, salt, fleetID)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(key)
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if bytes.Equal(record[:fleetTagLength], tag) {
+			return aead.Open(nil, make([]byte, aead.NonceSize()), record[fleetTagLength:], []byte(fleetID))
+		}
+	}
+	return nil, fmt.Errorf("no record for the device key in fleet %s", fleetID)
+}
+
+// fleetDeviceCount is the number of devices a fleet key entry holds records for, 0 if it is invalid
+func fleetDeviceCount(entry []byte) int {
+	_, records, err := parseFleetEntry(entry)
+	if err != nil {
+		return 0
+	}
+	return len(records)
+}
+
+// AddFleetKey wraps the symmetric key for all devices of a fleet and stores it as first key entry of the envelope.
+// Fleet keys need EnvelopeVersion4 or newer to be flagged in the header.
+func AddFleetKey(fleetKeyPath, fleetID string, deviceIDs []string, envelope *Envelope, plainKey []byte) error {
+	if envelope.Version < EnvelopeVersion4 {
+		return fmt.Errorf("fleet keys require envelope version %d or newer", EnvelopeVersion4)
+	}
+	secret, err := LoadFleetKey(fleetKeyPath)
+	if err != nil {
+		return err
+	}
+	defer wipe(secret)
+	if fleetID == "" {
+		fleetID = DefaultFleetID
+	}
+	entry, err := WrapFleetKey(secret, fleetID, deviceIDs, plainKey)
+	if err != nil {
+		return err
+	}
+	if len(entry) > maxFleetKeyLength {
+		return fmt.Errorf("fleet key entry of %d devices exceeds %d bytes", len(deviceIDs), maxFleetKeyLength)
+	}
+	envelope.ReceiverKeys = append([][]byte{entry}, envelope.ReceiverKeys...)
+	envelope.Flags |= EnvelopeFlagFleetKey
+	return nil
+}
+
+// HasFleetKey determines whether the first key entry of the envelope is wrapped for a fleet
+func (e *Envelope) HasFleetKey() bool {
+	return e.Version >= EnvelopeVersion4 && e.Flags&EnvelopeFlagFleetKey != 0 && len(e.ReceiverKeys) > 0
+}
+
+// recipientKeys are the key entries wrapped for individual recipients
+func (e *Envelope) recipientKeys() [][]byte {
+	if e.HasFleetKey() {
+		return e.ReceiverKeys[1:]
+	}
+	return e.ReceiverKeys
+}
+
+// GetFleetPayload provides the Payload from the envelope, decrypted with a key derived from the device key
+func (e *Envelope) GetFleetPayload(deviceKeyPath, fleetID string) (io.Reader, error) {
+	plainKey, err := e.FleetPayloadKey(deviceKeyPath, fleetID)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(plainKey)
+	return e.decryptPayload(plainKey)
+}
+
+// FleetPayloadKey unwraps the payload key with a key derived from the device key.
+// The key has to be wiped after use.
+func (e *Envelope) FleetPayloadKey(deviceKeyPath, fleetID string) ([]byte, error) {
+	if !e.HasFleetKey() {
+		return nil, fmt.Errorf("%w: not sealed for a fleet", ErrNotRecipient)
+	}
+	deviceKey, err := LoadFleetKey(deviceKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(deviceKey)
+	if fleetID == "" {
+		fleetID = DefaultFleetID
+	}
+	plainKey, err := UnwrapFleetKey(deviceKey, fleetID, e.ReceiverKeys[0])
+	if err != nil {
+		return nil, WithHint(fmt.Errorf("%w: not sealed for the provided device key and fleet ID", ErrNotRecipient),
+			"provide the device key derived with `sealpack key fleet-device` for this device and fleet")
+	}
+	return plainKey, nil
+}
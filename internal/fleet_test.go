@@ -0,0 +1,176 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFleetKey writes a fleet master secret to a temporary file
+func writeFleetKey(t *testing.T, secret string) string {
+	path := filepath.Join(t.TempDir(), "fleet.key")
+	assert.NoError(t, os.WriteFile(path, []byte(secret), 0600))
+	return path
+}
+
+// writeDeviceKey derives the key of a device from a fleet master secret file and writes it to a temporary file
+func writeDeviceKey(t *testing.T, fleetKey, fleetID, deviceID string) string {
+	secret, err := LoadFleetKey(fleetKey)
+	assert.NoError(t, err)
+	key, err := DeriveDeviceKey(secret, fleetID, deviceID)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), deviceID+".fleet.key")
+	assert.NoError(t, os.WriteFile(path, key, 0600))
+	return path
+}
+
+func TestWrapFleetKey(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	entry, err := WrapFleetKey(secret, "line-7", []string{"device-1", "device-2", "device-3"}, []byte("payload key"))
+	assert.NoError(t, err)
+	// salt, key length and a record of tag, key and AEAD tag per device
+	assert.Len(t, entry, fleetSaltLength+1+3*(fleetTagLength+len("payload key")+16))
+	assert.Equal(t, 3, fleetDeviceCount(entry))
+
+	for _, device := range []string{"device-1", "device-3"} {
+		deviceKey, err := DeriveDeviceKey(secret, "line-7", device)
+		assert.NoError(t, err)
+		plain, err := UnwrapFleetKey(deviceKey, "line-7", entry)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("payload key"), plain)
+	}
+
+	// Neither the master secret nor keys of other devices or fleets unwrap the key
+	_, err = UnwrapFleetKey(secret, "line-7", entry)
+	assert.ErrorContains(t, err, "no record for the device key")
+	deviceKey, err := DeriveDeviceKey(secret, "line-7", "device-4")
+	assert.NoError(t, err)
+	_, err = UnwrapFleetKey(deviceKey, "line-7", entry)
+	assert.Error(t, err)
+	deviceKey, err = DeriveDeviceKey(secret, "line-8", "device-1")
+	assert.NoError(t, err)
+	_, err = UnwrapFleetKey(deviceKey, "line-8", entry)
+	assert.Error(t, err)
+	_, err = UnwrapFleetKey(deviceKey, "line-7", entry[:40])
+	assert.ErrorContains(t, err, "invalid fleet key entry length")
+
+	// Every wrapping uses a fresh salt
+	other, err := WrapFleetKey(secret, "line-7", []string{"device-1", "device-2", "device-3"}, []byte("payload key"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, entry, other)
+
+	_, err = WrapFleetKey(secret, "line-7", nil, []byte("payload key"))
+	assert.ErrorContains(t, err, "no device IDs provided")
+}
+
+func TestDeriveDeviceKey(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	key, err := DeriveDeviceKey(secret, "line-7", "device-1")
+	assert.NoError(t, err)
+	assert.Len(t, key, fleetKeyMinLength)
+	again, err := DeriveDeviceKey(secret, "line-7", "device-1")
+	assert.NoError(t, err)
+	assert.Equal(t, key, again)
+	other, err := DeriveDeviceKey(secret, "line-7", "device-2")
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, other)
+	_, err = DeriveDeviceKey(secret, "line-7", "")
+	assert.ErrorContains(t, err, "no device ID provided")
+}
+
+func TestLoadFleetDevices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices")
+	assert.NoError(t, os.WriteFile(path, []byte("# line 7\ndevice-1\n\n  device-2 \ndevice-1\n"), 0644))
+	devices, err := LoadFleetDevices(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"device-1", "device-2"}, devices)
+
+	assert.NoError(t, os.WriteFile(path, []byte("# no devices yet\n"), 0644))
+	_, err = LoadFleetDevices(path)
+	assert.ErrorContains(t, err, "no device IDs")
+}
+
+func TestLoadFleetKey(t *testing.T) {
+	_, err := LoadFleetKey(writeFleetKey(t, "too short"))
+	assert.ErrorContains(t, err, "fleet key must be at least 32 bytes")
+	_, err = LoadFleetKey(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestAddFleetKey(t *testing.T) {
+	fleetKey := writeFleetKey(t, "0123456789abcdef0123456789abcdef")
+	envelope := &Envelope{Version: EnvelopeVersion3}
+	assert.ErrorContains(t, AddFleetKey(fleetKey, "", []string{"device-1"}, envelope, []byte("key")), "require envelope version 4")
+
+	envelope = &Envelope{Version: EnvelopeVersion4, HashAlgorithm: crypto.SHA256, ReceiverKeys: [][]byte{[]byte("rsa")}}
+	assert.NoError(t, AddFleetKey(fleetKey, "", []string{"device-1"}, envelope, []byte("key")))
+	assert.True(t, envelope.HasFleetKey())
+	assert.Len(t, envelope.ReceiverKeys, 2)
+	assert.Equal(t, [][]byte{[]byte("rsa")}, envelope.recipientKeys())
+	assert.Contains(t, envelope.String(), "Sealed for a fleet key")
+	assert.Contains(t, envelope.String(), "Sealed for 1 receivers")
+}
+
+func TestEnvelope_GetFleetPayload(t *testing.T) {
+	fleetKey := writeFleetKey(t, "0123456789abcdef0123456789abcdef")
+	encrypted := &bytes.Buffer{}
+	plainKey, w := EncryptWriter(encrypted)
+	_, err := w.Write([]byte("Hold your breath and count to 10."))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	payloadFile := filepath.Join(t.TempDir(), "payload")
+	assert.NoError(t, os.WriteFile(payloadFile, encrypted.Bytes(), 0644))
+	envelope := &Envelope{
+		Version:       EnvelopeVersion4,
+		PayloadLen:    int64(encrypted.Len()),
+		HashAlgorithm: crypto.SHA256,
+	}
+	devices := make([]string, 2000)
+	for i := range devices {
+		devices[i] = fmt.Sprintf("device-%d", i)
+	}
+	assert.NoError(t, AddFleetKey(fleetKey, "line-7", devices, envelope, []byte(plainKey)))
+	// The entry exceeds the size limit of recipient key entries
+	assert.Greater(t, len(envelope.ReceiverKeys[0]), maxKeyLength)
+	envelope.PayloadWriter, err = os.Open(payloadFile)
+	assert.NoError(t, err)
+	defer envelope.PayloadWriter.Close()
+
+	env, err := ParseEnvelope(bytes.NewReader(envelope.ToBytes()))
+	assert.NoError(t, err)
+	assert.True(t, env.HasFleetKey())
+
+	deviceKey := writeDeviceKey(t, fleetKey, "line-7", "device-1999")
+	payload, err := env.GetFleetPayload(deviceKey, "line-7")
+	assert.NoError(t, err)
+	contents, err := io.ReadAll(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hold your breath and count to 10.", string(contents))
+
+	_, err = env.GetFleetPayload(deviceKey, "line-8")
+	assert.ErrorIs(t, err, ErrNotRecipient)
+	_, err = env.GetFleetPayload(fleetKey, "line-7")
+	assert.ErrorIs(t, err, ErrNotRecipient)
+	_, err = env.GetPayload(filepath.Join(TestFilePath, "private.pem"))
+	assert.ErrorIs(t, err, ErrNotRecipient)
+}
@@ -0,0 +1,303 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ovh/symmecrypt/symutils"
+	"golang.org/x/crypto/chacha20poly1305"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const (
+	// PayloadFrameSize is the size of the plaintext of every payload frame but the last
+	PayloadFrameSize = 4 << 20
+	// frameNoncePrefixSize is the size of the random nonce prefix stored in front of the frames.
+	// The nonce of a frame consists of the prefix, the frame index and a flag marking the last frame.
+	frameNoncePrefixSize = chacha20poly1305.NonceSizeX - 9
+	// encryptedFrameSize is the size of every encrypted frame but the last
+	encryptedFrameSize = PayloadFrameSize + chacha20poly1305.Overhead
+	// maxFrameWorkers limits the frames decrypted in parallel, bounding the memory used to twice as many frames
+	maxFrameWorkers = 8
+)
+
+// newFrameAEAD creates the cipher of payload frames from the payload key
+func newFrameAEAD(plainKey []byte) (cipher.AEAD, error) {
+	key, err := symutils.RawKey(plainKey, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// frameNonce provides the nonce of a frame. Binding index and last flag prevents reordering, dropping and truncating frames.
+func frameNonce(prefix []byte, index uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[frameNoncePrefixSize:], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// frameWriter encrypts a stream in frames of PayloadFrameSize, each authenticated on its own
+type frameWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint64
+	buf    []byte
+}
+
+// NewFrameWriter encrypts everything written to w in authenticated frames with the payload key.
+// A frame is only written once the next byte arrives or the writer is closed, so the last frame can be marked.
+func NewFrameWriter(w io.Writer, plainKey []byte) (io.WriteCloser, error) {
+	aead, err := newFrameAEAD(plainKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, frameNoncePrefixSize)
+	if _, err = rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &frameWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, encryptedFrameSize)}, nil
+}
+
+// Write implements io.Writer
+func (f *frameWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(f.buf) == PayloadFrameSize {
+			if err := f.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := min(len(p), PayloadFrameSize-len(f.buf))
+		f.buf = append(f.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// flush encrypts and writes the buffered frame
+func (f *frameWriter) flush(last bool) error {
+	sealed := f.aead.Seal(f.buf[:0], frameNonce(f.prefix, f.index, last), f.buf, nil)
+	if _, err := f.w.Write(sealed); err != nil {
+		return err
+	}
+	f.index++
+	f.buf = f.buf[:0]
+	return nil
+}
+
+// Close writes the last frame, which may be empty. It does not close the underlying writer.
+func (f *frameWriter) Close() error {
+	defer wipe(f.buf[:cap(f.buf)])
+	return f.flush(true)
+}
+
+// frameReader decrypts the frames written by a frameWriter in order.
+// Frames are read in batches of one frame per worker, which are decrypted in parallel while the previous batch is consumed.
+type frameReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	workers int
+	// index, offset and the byte read ahead are only used by the batch in progress
+	index     uint64
+	offset    int64
+	readAhead []byte
+	ready     [][]byte
+	plain     []byte
+	pending   chan frameBatch
+	err       error
+}
+
+// frameBatch holds the frames decrypted in one batch. If a frame fails, only the frames before it are provided.
+type frameBatch struct {
+	frames [][]byte
+	last   bool
+	err    error
+}
+
+// frameWorkers is the number of frames decrypted in parallel, tied to GOMAXPROCS.
+// It is limited to maxFrameWorkers, as every worker holds a frame in memory.
+func frameWorkers() int {
+	return max(1, min(runtime.GOMAXPROCS(0), maxFrameWorkers))
+}
+
+// NewFrameReader decrypts the frames read from r with the payload key, using a worker per CPU up to maxFrameWorkers.
+// Every frame is authenticated before any of its contents are provided, so all contents before a damaged frame can be read.
+func NewFrameReader(r io.Reader, plainKey []byte) (io.Reader, error) {
+	return newFrameReader(r, plainKey, frameWorkers())
+}
+
+// newFrameReader decrypts the frames read from r with the provided number of workers
+func newFrameReader(r io.Reader, plainKey []byte, workers int) (io.Reader, error) {
+	aead, err := newFrameAEAD(plainKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, frameNoncePrefixSize)
+	if _, err = io.ReadFull(r, prefix); err != nil {
+		return nil, corruptEnvelope(fmt.Errorf("payload too short for its frame header: %w", err))
+	}
+	f := &frameReader{r: r, aead: aead, prefix: prefix, workers: max(1, workers), offset: frameNoncePrefixSize}
+	f.prefetch()
+	return f, nil
+}
+
+// Read implements io.Reader
+func (f *frameReader) Read(p []byte) (int, error) {
+	for len(f.plain) == 0 {
+		if len(f.ready) > 0 {
+			f.plain, f.ready = f.ready[0], f.ready[1:]
+			continue
+		}
+		if f.err != nil {
+			return 0, f.err
+		}
+		batch := <-f.pending
+		f.ready, f.err = batch.frames, batch.err
+		switch {
+		case f.err == nil && batch.last:
+			f.err = io.EOF
+		case f.err == nil:
+			f.prefetch()
+		}
+	}
+	n := copy(p, f.plain)
+	f.plain = f.plain[n:]
+	return n, nil
+}
+
+// prefetch decrypts the next batch in the background. The result is buffered, so an abandoned reader does not block it.
+func (f *frameReader) prefetch() {
+	f.pending = make(chan frameBatch, 1)
+	go func(pending chan<- frameBatch) {
+		pending <- f.nextBatch()
+	}(f.pending)
+}
+
+// sealedFrame is a frame read from the payload, decrypted in place
+type sealedFrame struct {
+	data   []byte
+	index  uint64
+	offset int64
+	last   bool
+	err    error
+}
+
+// nextBatch reads a frame per worker and decrypts them in parallel.
+// One byte more than a full frame is read ahead to detect the last frame.
+func (f *frameReader) nextBatch() frameBatch {
+	var frames []*sealedFrame
+	var batch frameBatch
+	for len(frames) < f.workers && !batch.last {
+		buf := make([]byte, encryptedFrameSize+1)
+		start := copy(buf, f.readAhead)
+		n, err := io.ReadFull(f.r, buf[start:])
+		n += start
+		batch.last = err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !batch.last {
+			batch.err = err
+			break
+		}
+		size := min(n, encryptedFrameSize)
+		f.readAhead = buf[size:n]
+		frames = append(frames, &sealedFrame{data: buf[:size], index: f.index, offset: f.offset, last: batch.last})
+		f.offset += int64(size)
+		f.index++
+	}
+	var wg sync.WaitGroup
+	for _, frame := range frames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			frame.data, frame.err = f.aead.Open(frame.data[:0], frameNonce(f.prefix, frame.index, frame.last), frame.data, nil)
+		}()
+	}
+	wg.Wait()
+	for _, frame := range frames {
+		if frame.err != nil {
+			msg := fmt.Sprintf("payload frame %d at offset %d cannot be authenticated", frame.index, frame.offset)
+			if frame.last {
+				msg += ", the payload may be truncated"
+			}
+			batch.err = corruptEnvelope(errors.New(msg))
+			break
+		}
+		batch.frames = append(batch.frames, frame.data)
+	}
+	return batch
+}
+
+// PayloadFrames provides random access to the frames of an encrypted payload.
+// Frames can be decrypted in any order and in parallel.
+type PayloadFrames struct {
+	r      io.ReaderAt
+	size   int64
+	aead   cipher.AEAD
+	prefix []byte
+}
+
+// OpenPayloadFrames reads the frame header of a payload of the given size
+func OpenPayloadFrames(r io.ReaderAt, size int64, plainKey []byte) (*PayloadFrames, error) {
+	aead, err := newFrameAEAD(plainKey)
+	if err != nil {
+		return nil, err
+	}
+	if size < frameNoncePrefixSize+chacha20poly1305.Overhead {
+		return nil, corruptEnvelope(errors.New("payload too short for its frame header"))
+	}
+	prefix := make([]byte, frameNoncePrefixSize)
+	if _, err = r.ReadAt(prefix, 0); err != nil {
+		return nil, err
+	}
+	return &PayloadFrames{r: r, size: size, aead: aead, prefix: prefix}, nil
+}
+
+// Count is the number of frames of the payload
+func (p *PayloadFrames) Count() int {
+	return int((p.size - frameNoncePrefixSize + encryptedFrameSize - 1) / encryptedFrameSize)
+}
+
+// Frame decrypts the frame at the index, containing the plaintext from index*PayloadFrameSize on
+func (p *PayloadFrames) Frame(index int) ([]byte, error) {
+	if index < 0 || index >= p.Count() {
+		return nil, fmt.Errorf("payload frame %d out of range, payload has %d frames", index, p.Count())
+	}
+	offset := frameNoncePrefixSize + int64(index)*encryptedFrameSize
+	sealed := make([]byte, min(encryptedFrameSize, p.size-offset))
+	if _, err := p.r.ReadAt(sealed, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	plain, err := p.aead.Open(sealed[:0], frameNonce(p.prefix, uint64(index), index == p.Count()-1), sealed, nil)
+	if err != nil {
+		return nil, corruptEnvelope(fmt.Errorf("payload frame %d at offset %d cannot be authenticated", index, offset))
+	}
+	return plain, nil
+}
@@ -0,0 +1,142 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+// encryptFrames encrypts data in frames, writing it in pieces of the given size
+func encryptFrames(t *testing.T, data []byte, piece int) ([]byte, []byte) {
+	encrypted := &bytes.Buffer{}
+	key, w, err := EncryptFramedWriter(encrypted)
+	assert.NoError(t, err)
+	for len(data) > 0 {
+		n := min(piece, len(data))
+		_, err = w.Write(data[:n])
+		assert.NoError(t, err)
+		data = data[n:]
+	}
+	assert.NoError(t, w.Close())
+	return key, encrypted.Bytes()
+}
+
+func TestFrameWriter_Roundtrip(t *testing.T) {
+	for _, size := range []int{0, 1, PayloadFrameSize - 1, PayloadFrameSize, PayloadFrameSize + 1, 2*PayloadFrameSize + 12345} {
+		data := randomBytes(int64(size), size)
+		key, encrypted := encryptFrames(t, data, 100000)
+		frames := (size + PayloadFrameSize) / PayloadFrameSize
+		if size > 0 && size%PayloadFrameSize == 0 {
+			frames--
+		}
+		assert.Equal(t, frameNoncePrefixSize+size+frames*16, len(encrypted), "size %d", size)
+
+		// Batches of one, some or all frames
+		for _, workers := range []int{1, 2, 8} {
+			r, err := newFrameReader(bytes.NewReader(encrypted), key, workers)
+			assert.NoError(t, err)
+			plain, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(data, plain), "size %d, %d workers", size, workers)
+		}
+
+		p, err := OpenPayloadFrames(bytes.NewReader(encrypted), int64(len(encrypted)), key)
+		assert.NoError(t, err)
+		assert.Equal(t, frames, p.Count(), "size %d", size)
+		for i := p.Count() - 1; i >= 0; i-- {
+			frame, err := p.Frame(i)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(data[i*PayloadFrameSize:min(size, (i+1)*PayloadFrameSize)], frame))
+		}
+	}
+}
+
+func TestFrameReader_Damaged(t *testing.T) {
+	data := randomBytes(1, 2*PayloadFrameSize+100)
+	key, encrypted := encryptFrames(t, data, len(data))
+
+	// Contents before the damaged frame are recovered, whether it is decrypted in the same batch or not
+	damaged := bytes.Clone(encrypted)
+	damaged[frameNoncePrefixSize+encryptedFrameSize+10] ^= 0xFF
+	for _, workers := range []int{1, 8} {
+		r, err := newFrameReader(bytes.NewReader(damaged), key, workers)
+		assert.NoError(t, err)
+		plain, err := io.ReadAll(r)
+		assert.ErrorIs(t, err, ErrCorruptEnvelope)
+		assert.ErrorContains(t, err, "payload frame 1 at offset 4194335")
+		assert.Equal(t, data[:PayloadFrameSize], plain)
+	}
+
+	p, err := OpenPayloadFrames(bytes.NewReader(damaged), int64(len(damaged)), key)
+	assert.NoError(t, err)
+	_, err = p.Frame(0)
+	assert.NoError(t, err)
+	_, err = p.Frame(1)
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+	_, err = p.Frame(3)
+	assert.ErrorContains(t, err, "out of range")
+
+	// Truncating at a frame boundary leaves a frame not marked as last
+	r, err := NewFrameReader(bytes.NewReader(encrypted[:frameNoncePrefixSize+2*encryptedFrameSize]), key)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "payload may be truncated")
+
+	// Swapped frames fail authentication
+	swapped := bytes.Clone(encrypted)
+	first := frameNoncePrefixSize
+	copy(swapped[first:], encrypted[first+encryptedFrameSize:first+2*encryptedFrameSize])
+	copy(swapped[first+encryptedFrameSize:], encrypted[first:first+encryptedFrameSize])
+	r, err = NewFrameReader(bytes.NewReader(swapped), key)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "payload frame 0 at offset 15")
+
+	_, err = NewFrameReader(bytes.NewReader(encrypted[:5]), key)
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+	r, err = NewFrameReader(bytes.NewReader(encrypted), []byte("0123456789abcdef0123456789abcdef"))
+	assert.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+}
+
+func TestEnvelope_DecryptPayload(t *testing.T) {
+	data := []byte("Hold your breath and count to 10.")
+	framedKey, framed := encryptFrames(t, data, len(data))
+	encrypted := &bytes.Buffer{}
+	key, w := EncryptWriter(encrypted)
+	_, err := w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	for _, tt := range []struct {
+		version uint8
+		key     []byte
+		payload []byte
+	}{
+		{EnvelopeVersion4, key, encrypted.Bytes()},
+		{EnvelopeVersion5, framedKey, framed},
+	} {
+		e := &Envelope{Version: tt.version, PayloadLen: int64(len(tt.payload)), PayloadReader: bytes.NewReader(append(tt.payload, "trailer"...))}
+		r, err := e.decryptPayload(tt.key)
+		assert.NoError(t, err)
+		plain, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, data, plain)
+	}
+}
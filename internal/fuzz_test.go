@@ -0,0 +1,125 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fuzzLimits keep the fuzzed archives from filling the disk
+var fuzzLimits = UnpackLimits{MaxTotalSize: 1 << 20, MaxFileSize: 1 << 20, MaxEntries: 64}
+
+// addGoldenSeeds adds all golden packages to the seed corpus
+func addGoldenSeeds(f *testing.F, add func(data []byte, envelope *Envelope)) {
+	packages, err := filepath.Glob(filepath.Join(GoldenPath, "*.ipc"))
+	assert.NoError(f, err)
+	for _, p := range packages {
+		data, err := os.ReadFile(p)
+		assert.NoError(f, err)
+		envelope, err := ParseEnvelope(bytes.NewReader(data))
+		if err != nil {
+			envelope = nil
+		}
+		add(data, envelope)
+	}
+}
+
+// tarSeed creates an uncompressed tar archive with a single entry
+func tarSeed(name string, typeflag byte, contents string) []byte {
+	buf := &bytes.Buffer{}
+	w := tar.NewWriter(buf)
+	_ = w.WriteHeader(&tar.Header{Name: name, Typeflag: typeflag, Size: int64(len(contents)), Mode: 0644})
+	_, _ = w.Write([]byte(contents))
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func FuzzParseEnvelope(f *testing.F) {
+	addGoldenSeeds(f, func(data []byte, _ *Envelope) { f.Add(data) })
+	f.Add([]byte(EnvelopeMagicBytes))
+	f.Add([]byte(EnvelopeMagicBytesV2 + "\x04\x05\x03"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		envelope, err := ParseEnvelope(bytes.NewReader(data))
+		if err != nil {
+			assert.ErrorIs(t, err, ErrCorruptEnvelope)
+			return
+		}
+		assert.GreaterOrEqual(t, envelope.PayloadLen, int64(0))
+		assert.LessOrEqual(t, envelope.headerSize()+envelope.PayloadLen, int64(len(data)))
+		for i, key := range envelope.ReceiverKeys {
+			assert.LessOrEqual(t, len(key), envelope.keyLengthLimit(i))
+		}
+		_ = envelope.String()
+	})
+}
+
+func FuzzReadKeys(f *testing.F) {
+	addGoldenSeeds(f, func(data []byte, envelope *Envelope) {
+		if envelope != nil && !envelope.HasTrailer() {
+			f.Add(envelope.Version, envelope.Flags, data[envelope.headerSize()+envelope.PayloadLen:])
+		}
+	})
+	f.Add(EnvelopeVersion3, EnvelopeFlagRecipientHints, []byte{0x02, 0xAA, 0xBB})
+	f.Add(EnvelopeVersion4, EnvelopeFlagSignature, []byte{0x06, 'S', 'H', 'A', '2', '5', '6', 0x01, 0x00})
+	f.Add(EnvelopeVersion2, uint8(0), []byte{0x01, 1, 2, 3, 4, 5, 6, 7, 8})
+	f.Fuzz(func(t *testing.T, version, flags uint8, data []byte) {
+		envelope := &Envelope{Version: version % (EnvelopeVersionLatest + 1), Flags: flags}
+		if err := envelope.readKeys(bufio.NewReader(bytes.NewReader(data))); err != nil {
+			return
+		}
+		for i, key := range envelope.ReceiverKeys {
+			assert.LessOrEqual(t, len(key), envelope.keyLengthLimit(i))
+		}
+		if envelope.HasRecipientHints() {
+			assert.Len(t, envelope.RecipientHints, len(envelope.recipientKeys()))
+		}
+		_ = envelope.keyDescriptions()
+	})
+}
+
+func FuzzUnpack(f *testing.F) {
+	addGoldenSeeds(f, func(data []byte, envelope *Envelope) {
+		if envelope != nil && len(envelope.ReceiverKeys) == 0 {
+			f.Add(envelope.CompressionAlgo, data[envelope.headerSize():envelope.headerSize()+envelope.PayloadLen])
+		}
+	})
+	zip := GetCompressionAlgoIndex(CompressionZip)
+	f.Add(zip, tarSeed("../escape.txt", tar.TypeReg, "outside"))
+	f.Add(zip, tarSeed("/etc/escape.txt", tar.TypeReg, "outside"))
+	f.Add(zip, tarSeed("link", tar.TypeSymlink, ""))
+	f.Add(zip, tarSeed(TocFileName, tar.TypeReg, "not a TOC"))
+	f.Fuzz(func(t *testing.T, compressionAlgo uint8, data []byte) {
+		base := t.TempDir()
+		arc, err := OpenArchiveReader(bytes.NewReader(data), compressionAlgo%uint8(len(compressionAlgorithms)))
+		if err != nil {
+			return
+		}
+		arc.Limits = fuzzLimits
+		arc.ImageFallback = true
+		_ = arc.Unpack(goldenKey("signer", "public"), "SHA256", filepath.Join(base, "out"), "", "")
+		// Nothing must be written outside the output path, whatever the archive contains
+		entries, err := os.ReadDir(base)
+		assert.NoError(t, err)
+		for _, entry := range entries {
+			assert.Equal(t, "out", entry.Name())
+		}
+	})
+}
@@ -0,0 +1,276 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// updateGolden regenerates the fixtures instead of verifying them: go test ./internal -run TestGolden -update
+var updateGolden = flag.Bool("update", false, "regenerate the golden packages in testdata/golden")
+
+const (
+	// GoldenPath holds the golden packages and the keys they were created with
+	GoldenPath = "testdata/golden"
+	// goldenFile is the single file sealed into every golden package
+	goldenFile     = "hello.txt"
+	goldenContents = "Hold your breath and count to 10.\n"
+)
+
+// goldenFixture describes how a golden package was sealed
+type goldenFixture struct {
+	name        string
+	version     uint8
+	public      bool
+	recipients  []string
+	hints       bool
+	compression string
+	hash        string
+}
+
+// goldenFixtures are all golden packages. Once shipped, a fixture must never be changed or removed,
+// as devices in the field have to unseal packages of that format.
+func goldenFixtures() []goldenFixture {
+	fixtures := []goldenFixture{
+		{"public", EnvelopeVersion4, true, nil, false, CompressionGzip, "SHA256"},
+		{"sealed", EnvelopeVersion4, false, []string{"recipient1"}, false, CompressionGzip, "SHA256"},
+		{"multiple-recipients", EnvelopeVersion4, false, []string{"recipient1", "recipient2"}, false, CompressionGzip, "SHA256"},
+		{"recipient-hints", EnvelopeVersion4, false, []string{"recipient1", "recipient2"}, true, CompressionGzip, "SHA256"},
+		{"v1-sealed", EnvelopeVersion1, false, []string{"recipient1"}, false, CompressionGzip, "SHA512"},
+		{"v2-sealed", EnvelopeVersion2, false, []string{"recipient1"}, false, CompressionGzip, "SHA512"},
+		{"v3-sealed", EnvelopeVersion3, false, []string{"recipient1"}, false, CompressionGzip, "SHA512"},
+		{"v5-sealed", EnvelopeVersion5, false, []string{"recipient1"}, false, CompressionGzip, "SHA256"},
+		{"v6-sealed", EnvelopeVersion6, false, []string{"recipient1"}, false, CompressionGzip, "SHA256"},
+	}
+	for _, compression := range compressionAlgorithms {
+		for _, hash := range []string{"SHA256", "SHA512", "SHA3256"} {
+			fixtures = append(fixtures, goldenFixture{
+				fmt.Sprintf("%s-%s", compression, strings.ToLower(hash)), EnvelopeVersion4, false,
+				[]string{"recipient1"}, false, compression, hash,
+			})
+		}
+	}
+	return fixtures
+}
+
+// goldenCorruption describes a damaged variant of the sealed golden package and the error it must cause
+type goldenCorruption struct {
+	name   string
+	damage func(data []byte) []byte
+	errMsg string
+}
+
+var goldenCorruptions = []goldenCorruption{
+	{"corrupt-magic", func(data []byte) []byte { data[3] = 'X'; return data }, "not a valid sealpack file"},
+	{"corrupt-version", func(data []byte) []byte { data[4] = 0xFF; return data }, "unsupported envelope version 255"},
+	{"corrupt-flags", func(data []byte) []byte { data[6] |= 0x80; return data }, "unsupported envelope flags"},
+	{"corrupt-payload", func(data []byte) []byte { data[100] ^= 0xFF; return data }, "checksum"},
+	{"corrupt-truncated", func(data []byte) []byte { return data[:len(data)-100] }, ""},
+}
+
+// goldenKey provides the path of a fixture key, e.g. goldenKey("signer", "public")
+func goldenKey(name, kind string) string {
+	return filepath.Join(GoldenPath, fmt.Sprintf("%s-%s.pem", name, kind))
+}
+
+// goldenPackage provides the path of a golden package
+func goldenPackage(name string) string {
+	return filepath.Join(GoldenPath, name+".ipc")
+}
+
+// goldenFlags are the flags expected in the header of a golden package
+func (f goldenFixture) goldenFlags() uint8 {
+	var flags uint8
+	if f.version >= EnvelopeVersion2 {
+		flags |= 1 // checksum trailer
+	}
+	if f.version >= EnvelopeVersion4 {
+		flags |= 2 // envelope signature
+	}
+	if f.hints {
+		flags |= 8 // recipient hints
+	}
+	return flags
+}
+
+// expectedHeader spells out the header bytes of a golden package independent of Envelope.WriteHeader
+func (f goldenFixture) expectedHeader(payloadLen int64) []byte {
+	config := GetCompressionAlgoIndex(f.compression)<<5 | uint8(GetHashAlgorithm(f.hash))
+	header := []byte{0xDB, 'I', 'P', 'C', config}
+	if f.version > EnvelopeVersion1 {
+		header = []byte{0xDB, 'I', 'P', 'V', f.version, config, f.goldenFlags()}
+	}
+	header = binary.LittleEndian.AppendUint64(header, uint64(payloadLen))
+	if f.version >= EnvelopeVersion6 {
+		header = binary.LittleEndian.AppendUint32(header, crc32.Checksum(header, crc32.MakeTable(crc32.Castagnoli)))
+	}
+	return header
+}
+
+// writeGoldenPackage seals a golden package like Seal does, using the fixture keys
+func writeGoldenPackage(t *testing.T, f goldenFixture) {
+	envelope := Envelope{
+		Version:         f.version,
+		HashAlgorithm:   GetHashAlgorithm(f.hash),
+		CompressionAlgo: GetCompressionAlgoIndex(f.compression),
+	}
+	var err error
+	if f.version >= EnvelopeVersion4 {
+		envelope.Signer, err = CreateSignerWithHash(goldenKey("signer", "private"), DefaultSignatureHash)
+		assert.NoError(t, err)
+		envelope.SignatureHash = DefaultSignatureHash
+	}
+	arc := CreateArchiveWriterWithOptions(f.public, envelope.CompressionAlgo, CompressionOptions{}, f.version)
+	defer arc.Cleanup()
+	signatures := NewSignatureList(f.hash)
+	assert.NoError(t, arc.AddToArchive(goldenFile, []byte(goldenContents)))
+	assert.NoError(t, signatures.AddFile(goldenFile, []byte(goldenContents)))
+	assert.NoError(t, arc.AddToc(goldenKey("signer", "private"), signatures))
+	envelope.PayloadLen, err = arc.Finalize()
+	assert.NoError(t, err)
+	if !f.public {
+		var paths []string
+		for _, recipient := range f.recipients {
+			paths = append(paths, goldenKey(recipient, "public"))
+		}
+		recipients, err := LoadRecipientKeys(paths)
+		assert.NoError(t, err)
+		assert.NoError(t, AddKeys(recipients, &envelope, arc.EncryptionKey))
+		if f.hints {
+			assert.NoError(t, AddRecipientHints(recipients, &envelope))
+		}
+	}
+	out, err := os.Create(goldenPackage(f.name))
+	assert.NoError(t, err)
+	assert.NoError(t, envelope.WriteOutput(out, arc))
+}
+
+// unsealGolden decrypts and unpacks a golden package and provides the contents of its file
+func unsealGolden(t *testing.T, data []byte, f goldenFixture, privateKeyPath string) (string, error) {
+	envelope, err := ParseEnvelope(bytes.NewReader(data))
+	assert.NoError(t, err)
+	payload, err := envelope.GetPayload(privateKeyPath)
+	if err != nil {
+		return "", err
+	}
+	arc, err := OpenArchiveReader(payload, envelope.CompressionAlgo)
+	assert.NoError(t, err)
+	out := t.TempDir()
+	if err = arc.Unpack(goldenKey("signer", "public"), f.hash, out, "", ""); err != nil {
+		return "", err
+	}
+	contents, err := os.ReadFile(filepath.Join(out, goldenFile))
+	return string(contents), err
+}
+
+func TestGolden_Update(t *testing.T) {
+	if !*updateGolden {
+		t.Skip("run with -update to regenerate the golden packages")
+	}
+	for _, f := range goldenFixtures() {
+		writeGoldenPackage(t, f)
+	}
+	sealed, err := os.ReadFile(goldenPackage("sealed"))
+	assert.NoError(t, err)
+	for _, c := range goldenCorruptions {
+		assert.NoError(t, os.WriteFile(goldenPackage(c.name), c.damage(bytes.Clone(sealed)), 0644))
+	}
+}
+
+func TestGolden_Unseal(t *testing.T) {
+	for _, f := range goldenFixtures() {
+		t.Run(f.name, func(t *testing.T) {
+			data, err := os.ReadFile(goldenPackage(f.name))
+			assert.NoError(t, err)
+			envelope, err := ParseEnvelope(bytes.NewReader(data))
+			assert.NoError(t, err)
+			assert.Equal(t, f.expectedHeader(envelope.PayloadLen), data[:envelope.headerSize()])
+			assert.Equal(t, max(f.version, EnvelopeVersion1), envelope.Version)
+			assert.Len(t, envelope.recipientKeys(), len(f.recipients))
+			if f.version >= EnvelopeVersion4 {
+				assert.NoError(t, envelope.VerifySignature(goldenKey("signer", "public")))
+			}
+
+			if f.public {
+				contents, err := unsealGolden(t, data, f, "")
+				assert.NoError(t, err)
+				assert.Equal(t, goldenContents, contents)
+				return
+			}
+			for _, recipient := range []string{"recipient1", "recipient2"} {
+				contents, err := unsealGolden(t, data, f, goldenKey(recipient, "private"))
+				if strings.Contains(strings.Join(f.recipients, ","), recipient) {
+					assert.NoError(t, err)
+					assert.Equal(t, goldenContents, contents)
+				} else {
+					assert.ErrorIs(t, err, ErrNotRecipient)
+				}
+			}
+		})
+	}
+}
+
+func TestGolden_Rewrite(t *testing.T) {
+	// Writing a parsed golden package must reproduce it byte by byte
+	for _, f := range goldenFixtures() {
+		t.Run(f.name, func(t *testing.T) {
+			data, err := os.ReadFile(goldenPackage(f.name))
+			assert.NoError(t, err)
+			envelope, err := ParseEnvelope(bytes.NewReader(data))
+			assert.NoError(t, err)
+			if envelope.IsSigned() {
+				// RSA PKCS #1 v1.5 signatures are deterministic
+				envelope.Signer, err = CreateSignerWithHash(goldenKey("signer", "private"), envelope.SignatureHash)
+				assert.NoError(t, err)
+			}
+			rewritten := &bytes.Buffer{}
+			payload := io.NewSectionReader(bytes.NewReader(data), envelope.headerSize(), envelope.PayloadLen)
+			assert.NoError(t, envelope.writeEnvelope(rewritten, payload))
+			assert.Equal(t, data, rewritten.Bytes())
+		})
+	}
+}
+
+func TestGolden_Corrupted(t *testing.T) {
+	for _, c := range goldenCorruptions {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := os.ReadFile(goldenPackage(c.name))
+			assert.NoError(t, err)
+			_, err = ParseEnvelope(bytes.NewReader(data))
+			assert.ErrorIs(t, err, ErrCorruptEnvelope)
+			if c.errMsg != "" {
+				assert.ErrorContains(t, err, c.errMsg)
+			}
+		})
+	}
+}
+
+func TestGolden_HashAlgorithms(t *testing.T) {
+	// The header stores the hash as crypto.Hash value, so these values are part of the format
+	assert.Equal(t, crypto.Hash(5), crypto.SHA256)
+	assert.Equal(t, crypto.Hash(7), crypto.SHA512)
+	assert.Equal(t, crypto.Hash(11), crypto.SHA3_256)
+}
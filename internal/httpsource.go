@@ -0,0 +1,155 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// httpClient downloads the contents of HTTP sources
+var httpClient = &http.Client{Transport: limitedTransport{base: http.DefaultTransport}}
+
+// HTTPProvider downloads a file from an http:// or https:// URL when it is added to the archive.
+// The entry is named after the last element of the URL path.
+type HTTPProvider struct {
+	URL string
+	// Header is sent with every request, e.g. for authorization
+	Header http.Header
+}
+
+// newHTTPProvider is the ContentProviderFactory of HTTP sources
+func newHTTPProvider(spec string) (ContentProvider, error) {
+	provider := &HTTPProvider{URL: spec}
+	if _, err := provider.entryName(); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// ParseHTTPHeaders parses headers given as "Name: value"
+func ParseHTTPHeaders(headers []string) (http.Header, error) {
+	parsed := http.Header{}
+	for _, header := range headers {
+		name, value, found := strings.Cut(header, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid HTTP header '%s', use 'Name: value'", header)
+		}
+		parsed.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return parsed, nil
+}
+
+// entryName provides the name of the downloaded file inside the archive
+func (p *HTTPProvider) entryName() (string, error) {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL '%s': %v", p.URL, err)
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return "", fmt.Errorf("URL %s does not name a file", u.Redacted())
+	}
+	return name, nil
+}
+
+// Resolve provides the entry of the URL; nothing is downloaded before it is opened
+func (p *HTTPProvider) Resolve() ([]ProvidedEntry, error) {
+	name, err := p.entryName()
+	if err != nil {
+		return nil, err
+	}
+	return []ProvidedEntry{{Name: name, Source: p.URL, Open: p.download, Size: p.size, Origin: &EntryOrigin{URL: p.URL}}}, nil
+}
+
+// request sends a request for the URL and fails on any status but 200 OK
+func (p *HTTPProvider) request(method string) (*http.Response, error) {
+	return p.send(method, "", http.StatusOK)
+}
+
+// send sends a request for the URL, optionally for a byte range, and fails on any status but the expected one
+func (p *HTTPProvider) send(method, byteRange string, status int) (*http.Response, error) {
+	req, err := http.NewRequest(method, p.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL '%s': %v", p.URL, err)
+	}
+	for name, values := range p.Header {
+		req.Header[name] = values
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, wrapNetworkError(fmt.Errorf("failed downloading %s: %w", req.URL.Redacted(), err))
+	}
+	if resp.StatusCode != status {
+		_ = resp.Body.Close()
+		if status == http.StatusPartialContent && resp.StatusCode == http.StatusOK {
+			return nil, fmt.Errorf("server of %s does not support range requests", req.URL.Redacted())
+		}
+		err = fmt.Errorf("failed downloading %s: %s", req.URL.Redacted(), resp.Status)
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return nil, WithHint(err, "provide credentials with --http-header, e.g. 'Authorization: Bearer <token>'")
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// download stores the contents next to the pulled images, so they are removed by CleanupImages
+func (p *HTTPProvider) download() (*os.File, error) {
+	resp, err := p.request(http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	dir := filepath.Join(os.TempDir(), TmpFolderName)
+	if err = os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "download-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		_ = removeTempFile(f)
+		return nil, wrapNetworkError(fmt.Errorf("failed downloading %s: %w", resp.Request.URL.Redacted(), err))
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		_ = removeTempFile(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// size asks the server for the size of the contents; it is estimated if the server does not provide it
+func (p *HTTPProvider) size() (int64, bool, error) {
+	resp, err := p.request(http.MethodHead)
+	if err != nil {
+		return 0, true, err
+	}
+	_ = resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return 0, true, nil
+	}
+	return resp.ContentLength, false, nil
+}
@@ -0,0 +1,114 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newArtifactServer serves firmware under /fw-1.2.bin, requiring the bearer token if one is provided
+func newArtifactServer(t *testing.T, token string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/fw-1.2.bin" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("firmware"))
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { _ = CleanupImages() })
+	return server
+}
+
+func TestParseHTTPHeaders(t *testing.T) {
+	header, err := ParseHTTPHeaders([]string{"Authorization: Bearer token", "X-Trace:1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+	assert.Equal(t, "1", header.Get("X-Trace"))
+	_, err = ParseHTTPHeaders([]string{"Authorization"})
+	assert.ErrorContains(t, err, "invalid HTTP header 'Authorization'")
+}
+
+func TestNewContentProvider_HTTP(t *testing.T) {
+	provider, err := NewContentProvider("https://artifacts.example.com/fw/fw-1.2.bin?version=2")
+	assert.NoError(t, err)
+	entries, err := provider.Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, "fw-1.2.bin", entries[0].Name)
+	assert.Equal(t, "https://artifacts.example.com/fw/fw-1.2.bin?version=2", entries[0].Source)
+
+	_, err = NewContentProvider("https://artifacts.example.com/")
+	assert.ErrorContains(t, err, "does not name a file")
+	assert.True(t, HasContentProvider("http://artifacts.example.com/fw.bin"))
+	assert.False(t, HasContentProvider("fw.bin"))
+}
+
+func TestHTTPProvider(t *testing.T) {
+	server := newArtifactServer(t, "secret")
+	header := http.Header{"Authorization": {"Bearer secret"}}
+	entries, err := (&HTTPProvider{URL: server.URL + "/fw-1.2.bin", Header: header}).Resolve()
+	assert.NoError(t, err)
+	size, estimated, err := entries[0].Size()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), size)
+	assert.False(t, estimated)
+
+	f, err := entries[0].Open()
+	assert.NoError(t, err)
+	defer f.Close()
+	contents := make([]byte, 16)
+	n, _ := f.Read(contents)
+	assert.Equal(t, "firmware", string(contents[:n]))
+
+	entries, err = (&HTTPProvider{URL: server.URL + "/fw-1.2.bin"}).Resolve()
+	assert.NoError(t, err)
+	_, err = entries[0].Open()
+	assert.ErrorContains(t, err, "401 Unauthorized")
+	assert.Contains(t, Hints(err)[0], "--http-header")
+
+	entries, err = (&HTTPProvider{URL: server.URL + "/fw-1.3.bin", Header: header}).Resolve()
+	assert.NoError(t, err)
+	_, err = entries[0].Open()
+	assert.ErrorContains(t, err, "404 Not Found")
+}
+
+func TestAddContents_HTTP(t *testing.T) {
+	server := newArtifactServer(t, "")
+	url := server.URL + "/fw-1.2.bin"
+	sum := sha256.Sum256([]byte("firmware"))
+	digests, err := FileDigests([]ContentEntry{{Name: url, Digest: "sha256:" + hex.EncodeToString(sum[:])}})
+	assert.NoError(t, err)
+	assert.Contains(t, digests, url)
+
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.FileDigests = digests
+	assert.NoError(t, arc.AddContents([]string{url}, nil, NewSignatureList("SHA256")))
+	assert.Equal(t, url, arc.sources["fw-1.2.bin"])
+
+	arc = CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.FileDigests = map[string]string{url: "sha256:" + hex.EncodeToString(make([]byte, 32))}
+	assert.ErrorContains(t, arc.AddContents([]string{url}, nil, NewSignatureList("SHA256")), "file "+url)
+}
@@ -0,0 +1,162 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"math/big"
+	"slices"
+)
+
+// hybridInfo is the HKDF info of keys wrapping the payload key for ECDSA and Ed25519 recipients
+const hybridInfo = "sealpack hybrid key"
+
+// hybridEntryCurves maps the lengths of hybrid key entries wrapping a payload key to their key agreement.
+// The lengths never match RSA key entries, which are multiples of 8 bytes.
+var hybridEntryCurves = map[int]string{
+	32 + hybridEntryOverhead:  "X25519",
+	65 + hybridEntryOverhead:  "ECDH P-256",
+	97 + hybridEntryOverhead:  "ECDH P-384",
+	133 + hybridEntryOverhead: "ECDH P-521",
+}
+
+// hybridEntryOverhead is the length of a hybrid key entry without the ephemeral public key
+const hybridEntryOverhead = chacha20poly1305.NonceSizeX + 64 + chacha20poly1305.Overhead
+
+// curve25519P is the prime of the field of Curve25519 and Ed25519
+var curve25519P, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+// ecdhPublicKey converts the public key of an ECDSA or Ed25519 recipient for key agreement
+func ecdhPublicKey(pub crypto.PublicKey) (*ecdh.PublicKey, error) {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		return key.ECDH()
+	case ed25519.PublicKey:
+		// The Montgomery u-coordinate of the Edwards point (x, y) is (1 + y) / (1 - y)
+		encoded := slices.Clone(key)
+		encoded[31] &= 0x7f
+		slices.Reverse(encoded)
+		y := new(big.Int).SetBytes(encoded)
+		denominator := new(big.Int).Sub(big.NewInt(1), y)
+		if denominator.Mod(denominator, curve25519P).Sign() == 0 {
+			return nil, fmt.Errorf("invalid Ed25519 public key")
+		}
+		u := new(big.Int).Add(big.NewInt(1), y)
+		u.Mul(u, denominator.ModInverse(denominator, curve25519P)).Mod(u, curve25519P)
+		montgomery := u.FillBytes(make([]byte, 32))
+		slices.Reverse(montgomery)
+		return ecdh.X25519().NewPublicKey(montgomery)
+	}
+	return nil, fmt.Errorf("unsupported key type %T for key agreement", pub)
+}
+
+// ecdhPrivateKey converts the private key of an ECDSA or Ed25519 recipient for key agreement
+func ecdhPrivateKey(priv crypto.PrivateKey) (*ecdh.PrivateKey, error) {
+	switch key := priv.(type) {
+	case *ecdsa.PrivateKey:
+		return key.ECDH()
+	case ed25519.PrivateKey:
+		// The X25519 scalar is derived from the seed like the Ed25519 signing scalar
+		digest := sha512.Sum512(key.Seed())
+		defer wipe(digest[:])
+		return ecdh.X25519().NewPrivateKey(digest[:32])
+	}
+	return nil, fmt.Errorf("unsupported key type %T for key agreement", priv)
+}
+
+// deriveHybridKey derives the wrapping key from the shared secret, bound to both public keys
+func deriveHybridKey(shared, ephemeral, recipient []byte) ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	salt := append(slices.Clone(ephemeral), recipient...)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(hybridInfo)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapHybridKey encrypts the symmetric key for an ECDSA or Ed25519 recipient with an ephemeral key agreement.
+// The resulting entry consists of the ephemeral public key, the nonce and the sealed key.
+func WrapHybridKey(pub crypto.PublicKey, plainKey []byte) ([]byte, error) {
+	recipient, err := ecdhPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(shared)
+	key, err := deriveHybridKey(shared, ephemeral.PublicKey().Bytes(), recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(key)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	entry := append(ephemeral.PublicKey().Bytes(), make([]byte, aead.NonceSize())...)
+	nonce := entry[len(entry)-aead.NonceSize():]
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(entry, nonce, plainKey, recipient.Bytes()), nil
+}
+
+// UnwrapHybridKey decrypts a symmetric key wrapped by WrapHybridKey with the private key of the recipient
+func UnwrapHybridKey(priv crypto.PrivateKey, entry []byte) ([]byte, error) {
+	private, err := ecdhPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	publicLength := len(private.PublicKey().Bytes())
+	if len(entry) < publicLength+chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead {
+		return nil, fmt.Errorf("invalid hybrid key entry length %d", len(entry))
+	}
+	ephemeral, err := private.Curve().NewPublicKey(entry[:publicLength])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := private.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(shared)
+	key, err := deriveHybridKey(shared, entry[:publicLength], private.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(key)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := entry[publicLength : publicLength+aead.NonceSize()]
+	return aead.Open(nil, nonce, entry[publicLength+aead.NonceSize():], private.PublicKey().Bytes())
+}
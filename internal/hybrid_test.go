@@ -0,0 +1,80 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWrapHybridKey(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	for name, key := range map[string]crypto.Signer{"Ed25519": edKey, "ECDSA": ecKey} {
+		t.Run(name, func(t *testing.T) {
+			entry, err := WrapHybridKey(key.Public(), []byte("payload key"))
+			assert.NoError(t, err)
+			plain, err := UnwrapHybridKey(key, entry)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("payload key"), plain)
+
+			// Every wrapping uses a fresh ephemeral key
+			other, err := WrapHybridKey(key.Public(), []byte("payload key"))
+			assert.NoError(t, err)
+			assert.NotEqual(t, entry, other)
+
+			_, err = UnwrapHybridKey(key, entry[:10])
+			assert.Error(t, err)
+		})
+	}
+
+	entry, err := WrapHybridKey(edKey.Public(), []byte("payload key"))
+	assert.NoError(t, err)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, err = UnwrapHybridKey(otherKey, entry)
+	assert.Error(t, err)
+	_, err = UnwrapHybridKey(ecKey, entry)
+	assert.Error(t, err)
+}
+
+func TestEcdhPublicKey_Ed25519(t *testing.T) {
+	// The converted public key must match the public key of the converted private key
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	pub, err := ecdhPublicKey(key.Public())
+	assert.NoError(t, err)
+	priv, err := ecdhPrivateKey(key)
+	assert.NoError(t, err)
+	assert.Equal(t, priv.PublicKey().Bytes(), pub.Bytes())
+}
+
+func TestAddKeys_Hybrid(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	recipients := []RecipientKey{{ID: "edge", Key: key.Public()}}
+	assert.ErrorContains(t, AddKeys(recipients, &Envelope{Version: EnvelopeVersion2}, []byte("key")), "requires envelope version 3")
+
+	plainKey, _ := EncryptWriter(&bytes.Buffer{})
+	envelope := &Envelope{Version: EnvelopeVersion3, HashAlgorithm: crypto.SHA256}
+	assert.NoError(t, AddKeys(recipients, envelope, plainKey))
+	unsealed, err := tryUnsealKeyWith(envelope.ReceiverKeys[0], key)
+	assert.NoError(t, err)
+	assert.Equal(t, plainKey, unsealed)
+	assert.Contains(t, envelope.String(), "Key 1: 136 Bytes, XChaCha20-Poly1305 with X25519 ephemeral key agreement")
+}
@@ -0,0 +1,367 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/innomotics/sealpack/format"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// maxIndexLength limits the size of the compressed index read from a package
+const maxIndexLength = format.MaxIndexLength
+
+// IndexEntry locates an entry of an indexed payload: it is stored as a compression member of its own,
+// starting at Offset of the compressed payload and Length bytes long.
+type IndexEntry struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// CheckIndexable fails for compression algorithms whose streams cannot be split into independent members
+func CheckIndexable(compressionAlgo uint8) error {
+	switch GetCompressionAlgoName(compressionAlgo) {
+	case CompressionGzip, CompressionZstd, CompressionZip:
+		return nil
+	}
+	return fmt.Errorf("an index requires gzip, zstd or zip compression, %s streams cannot be split", GetCompressionAlgoName(compressionAlgo))
+}
+
+// positionWriter counts the bytes written to the underlying writer, which it never closes
+type positionWriter struct {
+	w       io.Writer
+	written int64
+}
+
+// Write implements io.Writer
+func (c *positionWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+// Close does not close the underlying writer, it is closed by Finalize
+func (c *positionWriter) Close() error {
+	return nil
+}
+
+// memberWriter passes the tar stream to the current compression writer of an archive
+type memberWriter struct {
+	arc *WriteArchive
+}
+
+// Write implements io.Writer
+func (m memberWriter) Write(p []byte) (int, error) {
+	return m.arc.compressWriter.Write(p)
+}
+
+// beginEntry starts a new compression member for the next entry and records it in the index, if the archive is indexed.
+// The previous entry is completed by flushing the tar writer, so none of its padding ends up in the new member.
+func (arc *WriteArchive) beginEntry(name string) error {
+	if !arc.Index {
+		return nil
+	}
+	if len(arc.index) > 0 {
+		if err := arc.tarWriter.Flush(); err != nil {
+			return err
+		}
+		if err := arc.nextMember(); err != nil {
+			return err
+		}
+	}
+	arc.index = append(arc.index, IndexEntry{Name: name, Offset: arc.plain.written})
+	return nil
+}
+
+// nextMember ends the current compression member and starts the next one.
+// Concatenated gzip members and zstd frames form a valid stream, so the payload is unpacked as before.
+func (arc *WriteArchive) nextMember() error {
+	if err := CheckIndexable(arc.compressionAlgo); err != nil {
+		return err
+	}
+	resetter, ok := arc.compressWriter.(interface{ Reset(io.Writer) })
+	if !ok {
+		// Uncompressed payloads need no members
+		return nil
+	}
+	if err := arc.compressWriter.Close(); err != nil {
+		return err
+	}
+	resetter.Reset(arc.plain)
+	return nil
+}
+
+// writeIndex completes the lengths of all entries and writes the index as last member, after the end of the tar stream
+func (arc *WriteArchive) writeIndex() error {
+	if err := arc.nextMember(); err != nil {
+		return err
+	}
+	arc.IndexOffset = arc.plain.written
+	for i := range arc.index {
+		end := arc.IndexOffset
+		if i+1 < len(arc.index) {
+			end = arc.index[i+1].Offset
+		}
+		arc.index[i].Length = end - arc.index[i].Offset
+	}
+	return json.NewEncoder(arc.compressWriter).Encode(arc.index)
+}
+
+// HasIndex determines whether the envelope locates an index of the payload entries
+func (e *Envelope) HasIndex() bool {
+	return e.Version >= EnvelopeVersion5 && e.Flags&EnvelopeFlagIndexed != 0
+}
+
+// SetIndex records where the index of the finalized archive is stored in the payload
+func (e *Envelope) SetIndex(arc *WriteArchive) error {
+	if e.Version < EnvelopeVersion5 {
+		return fmt.Errorf("an index requires envelope version %d or newer", EnvelopeVersion5)
+	}
+	e.IndexOffset, e.IndexLength = arc.IndexOffset, arc.IndexLength
+	e.Flags |= EnvelopeFlagIndexed
+	return nil
+}
+
+// indexRecord encodes offset and length of the index as uvarints
+func (e *Envelope) indexRecord() []byte {
+	return format.AppendIndexRecord(nil, uint64(e.IndexOffset), uint64(e.IndexLength))
+}
+
+// readIndexRecord reads the index location following the signature record
+func (e *Envelope) readIndexRecord(rd io.ByteReader) error {
+	offset, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return err
+	}
+	length, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return err
+	}
+	if length > maxIndexLength || offset+length > uint64(e.PayloadLen) {
+		return fmt.Errorf("index of %d bytes at %d exceeds the payload", length, offset)
+	}
+	e.IndexOffset, e.IndexLength = int64(offset), int64(length)
+	return nil
+}
+
+// IndexedPayload provides the entries of an indexed payload, only reading the frames containing them.
+// It allows extracting single files from remote packages without downloading them completely.
+type IndexedPayload struct {
+	envelope *Envelope
+	payload  *io.SectionReader
+	frames   *PayloadFrames
+	entries  map[string]IndexEntry
+	// The last decrypted frame is kept, as index, TOC and signature are mostly stored in the same frames
+	frameIndex int
+	frame      []byte
+}
+
+// OpenIndexedPayload reads the index of a package. The payload key is only needed for sealed packages, it is wiped after use.
+func OpenIndexedPayload(e *Envelope, pkg io.ReaderAt, plainKey []byte) (*IndexedPayload, error) {
+	defer wipe(plainKey)
+	if !e.HasIndex() {
+		return nil, WithHint(errors.New("package has no index to extract single files"),
+			"seal the package with --index, or unseal it completely")
+	}
+	p := &IndexedPayload{envelope: e, payload: io.NewSectionReader(pkg, e.headerSize(), e.PayloadLen), frameIndex: -1}
+	if len(e.ReceiverKeys) > 0 {
+		var err error
+		if p.frames, err = OpenPayloadFrames(p.payload, e.PayloadLen, plainKey); err != nil {
+			return nil, err
+		}
+	}
+	arc, err := p.openMember(e.IndexOffset, e.IndexLength)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = arc.Close() }()
+	var entries []IndexEntry
+	if err = json.NewDecoder(arc.compressReader).Decode(&entries); err != nil {
+		return nil, corruptEnvelope(fmt.Errorf("invalid payload index: %w", err))
+	}
+	p.entries = make(map[string]IndexEntry, len(entries))
+	for _, entry := range entries {
+		p.entries[entry.Name] = entry
+	}
+	return p, nil
+}
+
+// Extract writes a single entry to the output path after verifying it against the signed TOC of the package.
+// Only the members of the entry, the TOC and its signature are read.
+func (p *IndexedPayload) Extract(name, signingKeyPath, hashingAlgorithm, outputPath string) (err error) {
+	if !filepath.IsLocal(localFileName(name)) {
+		return fmt.Errorf("invalid entry name %s leading out of the output path", name)
+	}
+	v, err := NewVerifier(signingKeyPath, hashingAlgorithm)
+	if err != nil {
+		return err
+	}
+	expected, err := p.signedDigest(name, v)
+	if err != nil {
+		return err
+	}
+	arc, h, err := p.openEntry(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = arc.Close() }()
+	if isChunkedEntry(h) || isLayeredEntry(h) {
+		return fmt.Errorf("%s is stored as deduplicated chunks or layers, which cannot be extracted alone", name)
+	}
+	contents, err := arc.contentReader(h)
+	if err != nil {
+		return err
+	}
+	fullFile := filepath.Join(outputPath, localFileName(name))
+	if err = os.MkdirAll(filepath.Dir(fullFile), 0755); err != nil {
+		return err
+	}
+	// The contents are only verified once read completely, so they are written next to the file and only renamed
+	// to it if they match. A tampered entry never replaces an existing file, not even partially.
+	tmpDir, err := os.MkdirTemp(filepath.Dir(fullFile), "."+filepath.Base(fullFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	tmpFile := filepath.Join(tmpDir, filepath.Base(fullFile))
+	if err = v.Signatures.AddFileWhileReading(name, contents, func(r io.Reader) error {
+		return arc.storeFile(h, r, tmpFile)
+	}); err != nil {
+		return err
+	}
+	if (*v.Signatures)[name] != string(expected) {
+		return WithHint(fmt.Errorf("%w: contents of %s do not match the signed TOC", ErrBadSignature, name),
+			"the contents were modified after sealing; obtain an intact copy of the package")
+	}
+	if info, err := os.Lstat(fullFile); err == nil && !modeRecorded(h) {
+		// Replaced files keep their mode as if written in place, unless the package records one
+		if err = os.Chmod(tmpFile, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpFile, fullFile)
+}
+
+// signedDigest verifies the TOC signature and provides the digest the TOC records for the entry
+func (p *IndexedPayload) signedDigest(name string, v *Verifier) ([]byte, error) {
+	for _, component := range []string{TocFileName, TocFileName + ".sig"} {
+		arc, h, err := p.openEntry(component)
+		if err != nil {
+			return nil, WithHint(fmt.Errorf("%w: %w", ErrBadSignature, err), missingTocHint)
+		}
+		err = v.AddTocComponent(h, arc.TarReader)
+		_ = arc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	toc := bytes.Clone(v.toc.Bytes())
+	if err := v.sigVerifier.VerifySignature(v.tocSignature, v.toc); err != nil {
+		return nil, WithHint(fmt.Errorf("%w: %w", ErrBadSignature, err), signerHint, v.signingKey)
+	}
+	entries, err := parseToc(toc, hashFunc.Size())
+	if err != nil {
+		return nil, corruptEnvelope(err)
+	}
+	digest, ok := entries[name]
+	if !ok {
+		return nil, WithHint(fmt.Errorf("%s is not contained in the package", name), "entry names are shown by `sealpack list`")
+	}
+	return digest, nil
+}
+
+// openEntry opens the member of an entry and reads its header
+func (p *IndexedPayload) openEntry(name string) (*ReadArchive, *tar.Header, error) {
+	entry, ok := p.entries[name]
+	if !ok {
+		return nil, nil, WithHint(fmt.Errorf("%s is not contained in the package index", name), "entry names are shown by `sealpack list`")
+	}
+	arc, err := p.openMember(entry.Offset, entry.Length)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err := arc.TarReader.Next()
+	if err == nil && h.Name != name {
+		err = fmt.Errorf("index entry %s points to %s", name, h.Name)
+	}
+	if err != nil {
+		_ = arc.Close()
+		return nil, nil, corruptEnvelope(err)
+	}
+	return arc, h, nil
+}
+
+// openMember decompresses a member of the payload
+func (p *IndexedPayload) openMember(offset, length int64) (*ReadArchive, error) {
+	if offset < 0 || length < 0 {
+		return nil, corruptEnvelope(fmt.Errorf("invalid payload index member of %d bytes at %d", length, offset))
+	}
+	// Public members are read in blocks of 1 MiB instead of the small reads of decompression
+	var r io.Reader = bufio.NewReaderSize(io.NewSectionReader(p.payload, offset, length), 1<<20)
+	if p.frames != nil {
+		r = &frameRangeReader{payload: p, offset: offset, end: offset + length}
+	}
+	arc := &ReadArchive{}
+	if err := arc.InitializeCompression(r, p.envelope.CompressionAlgo); err != nil {
+		return nil, corruptEnvelope(fmt.Errorf("payload member at %d cannot be decompressed: %w", offset, err))
+	}
+	arc.TarReader = tar.NewReader(arc.compressReader)
+	return arc, nil
+}
+
+// decryptFrame provides a decrypted frame, keeping the last one
+func (p *IndexedPayload) decryptFrame(index int) ([]byte, error) {
+	if index != p.frameIndex {
+		frame, err := p.frames.Frame(index)
+		if err != nil {
+			return nil, err
+		}
+		p.frameIndex, p.frame = index, frame
+	}
+	return p.frame, nil
+}
+
+// frameRangeReader reads a range of the decrypted payload, decrypting the frames it spans one by one
+type frameRangeReader struct {
+	payload     *IndexedPayload
+	offset, end int64
+}
+
+// Read implements io.Reader
+func (r *frameRangeReader) Read(b []byte) (int, error) {
+	if r.offset >= r.end {
+		return 0, io.EOF
+	}
+	frame, err := r.payload.decryptFrame(int(r.offset / PayloadFrameSize))
+	if err != nil {
+		return 0, err
+	}
+	start := r.offset % PayloadFrameSize
+	if start >= int64(len(frame)) {
+		return 0, corruptEnvelope(fmt.Errorf("payload member exceeds the payload at %d", r.offset))
+	}
+	n := copy(b, frame[start:min(int64(len(frame)), start+r.end-r.offset)])
+	r.offset += int64(n)
+	return n, nil
+}
@@ -0,0 +1,298 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// indexedContents span several payload frames, so entries start inside and across frames
+var indexedContents = map[string][]byte{
+	"etc/app/config.yaml": []byte("listen: 0.0.0.0:8080\n"),
+	"rootfs.img":          randomBytes(3, 9<<20),
+	"README":              []byte("Hold your breath and count to 10."),
+}
+
+// sealIndexed writes an indexed package of indexedContents and provides its path
+func sealIndexed(t *testing.T, public bool, compression string, index bool) string {
+	envelope := Envelope{
+		Version:         EnvelopeVersion5,
+		HashAlgorithm:   crypto.SHA256,
+		CompressionAlgo: GetCompressionAlgoIndex(compression),
+	}
+	var err error
+	envelope.Signer, err = CreateSignerWithHash("../test/private.pem", DefaultSignatureHash)
+	assert.NoError(t, err)
+	envelope.SignatureHash = DefaultSignatureHash
+	arc := CreateArchiveWriterWithOptions(public, envelope.CompressionAlgo, CompressionOptions{}, EnvelopeVersion5)
+	defer arc.Cleanup()
+	arc.Index = index
+	signatures := NewSignatureList("SHA256")
+	for _, name := range []string{"etc/app/config.yaml", "rootfs.img", "README"} {
+		assert.NoError(t, arc.AddToArchive(name, indexedContents[name]))
+		assert.NoError(t, signatures.AddFile(name, indexedContents[name]))
+	}
+	assert.NoError(t, arc.AddToc("../test/private.pem", signatures))
+	envelope.PayloadLen, err = arc.Finalize()
+	assert.NoError(t, err)
+	if index {
+		assert.NoError(t, envelope.SetIndex(arc))
+	}
+	if !public {
+		recipients, err := LoadRecipientKeys([]string{"../test/public.pem"})
+		assert.NoError(t, err)
+		assert.NoError(t, AddKeys(recipients, &envelope, arc.EncryptionKey))
+	}
+	path := filepath.Join(t.TempDir(), "indexed.ipc")
+	out, err := os.Create(path)
+	assert.NoError(t, err)
+	assert.NoError(t, envelope.WriteOutput(out, arc))
+	return path
+}
+
+// openIndexed opens the indexed payload of a package with ranged reads
+func openIndexed(t *testing.T, src RangeSource) (*IndexedPayload, error) {
+	envelope, err := ParseEnvelopeUnverified(io.NewSectionReader(src, 0, src.Size()))
+	assert.NoError(t, err)
+	var plainKey []byte
+	if len(envelope.ReceiverKeys) > 0 {
+		plainKey, err = envelope.PayloadKey("../test/private.pem")
+		assert.NoError(t, err)
+	}
+	return OpenIndexedPayload(envelope, src, plainKey)
+}
+
+func TestIndexedPayload_Extract(t *testing.T) {
+	for _, tt := range []struct {
+		public      bool
+		compression string
+	}{
+		{true, "gzip"},
+		{false, "gzip"},
+		{false, "zstd"},
+		{false, "zip"},
+	} {
+		t.Run(fmt.Sprintf("public=%v/%s", tt.public, tt.compression), func(t *testing.T) {
+			path := sealIndexed(t, tt.public, tt.compression, true)
+			src, err := OpenRangeSource(path, nil)
+			assert.NoError(t, err)
+			defer src.Close()
+			payload, err := openIndexed(t, src)
+			assert.NoError(t, err)
+			out := t.TempDir()
+			for _, name := range []string{"etc/app/config.yaml", "rootfs.img"} {
+				assert.NoError(t, payload.Extract(name, "../test/public.pem", "SHA256", out))
+				contents, err := os.ReadFile(filepath.Join(out, name))
+				assert.NoError(t, err)
+				assert.True(t, bytes.Equal(indexedContents[name], contents), name)
+			}
+			_, err = os.Stat(filepath.Join(out, "README"))
+			assert.True(t, os.IsNotExist(err))
+			assert.ErrorContains(t, payload.Extract("missing", "../test/public.pem", "SHA256", out), "missing is not contained in the package")
+
+			// Indexed packages are unsealed completely as before
+			raw, err := os.Open(path)
+			assert.NoError(t, err)
+			defer raw.Close()
+			envelope, err := ParseEnvelope(raw)
+			assert.NoError(t, err)
+			assert.True(t, envelope.HasIndex())
+			assert.Contains(t, envelope.String(), "Payload indexed")
+			assert.NoError(t, envelope.VerifySignature("../test/public.pem"))
+			plain, err := envelope.GetPayload("../test/private.pem")
+			assert.NoError(t, err)
+			ra, err := OpenArchiveReader(plain, envelope.CompressionAlgo)
+			assert.NoError(t, err)
+			out = t.TempDir()
+			assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""))
+			assert.NoError(t, ra.Close())
+			contents, err := os.ReadFile(filepath.Join(out, "README"))
+			assert.NoError(t, err)
+			assert.Equal(t, indexedContents["README"], contents)
+		})
+	}
+}
+
+func TestIndexedPayload_Tampered(t *testing.T) {
+	path := sealIndexed(t, true, "zip", true)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	at := bytes.Index(data, indexedContents["etc/app/config.yaml"])
+	assert.Greater(t, at, 0)
+	data[at] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+
+	src, err := OpenRangeSource(path, nil)
+	assert.NoError(t, err)
+	defer src.Close()
+	payload, err := openIndexed(t, src)
+	assert.NoError(t, err)
+	out := t.TempDir()
+	assert.ErrorIs(t, payload.Extract("etc/app/config.yaml", "../test/public.pem", "SHA256", out), ErrBadSignature)
+	_, err = os.Stat(filepath.Join(out, "etc/app/config.yaml"))
+	assert.True(t, os.IsNotExist(err))
+	// An existing file is left untouched and nothing is left behind next to it
+	assert.NoError(t, os.WriteFile(filepath.Join(out, "etc/app/config.yaml"), []byte("installed"), 0644))
+	assert.ErrorIs(t, payload.Extract("etc/app/config.yaml", "../test/public.pem", "SHA256", out), ErrBadSignature)
+	contents, err := os.ReadFile(filepath.Join(out, "etc/app/config.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "installed", string(contents))
+	files, err := os.ReadDir(filepath.Join(out, "etc/app"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	// Other entries are still intact
+	assert.NoError(t, payload.Extract("README", "../test/public.pem", "SHA256", out))
+	assert.ErrorIs(t, payload.Extract("README", "../test/pkcs1-public.pem", "SHA256", out), ErrBadSignature)
+}
+
+func TestOpenIndexedPayload_NotIndexed(t *testing.T) {
+	src, err := OpenRangeSource(sealIndexed(t, true, "gzip", false), nil)
+	assert.NoError(t, err)
+	defer src.Close()
+	_, err = openIndexed(t, src)
+	assert.ErrorContains(t, err, "package has no index")
+	assert.Contains(t, Hints(err), "seal the package with --index, or unseal it completely")
+}
+
+func TestIndexedPayload_HTTP(t *testing.T) {
+	data, err := os.ReadFile(sealIndexed(t, false, "zstd", true))
+	assert.NoError(t, err)
+	// The second frame only holds the middle of the large image
+	skipped := int64(6 << 20)
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/no-ranges.ipc" {
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			if r.Method == http.MethodGet {
+				_, _ = w.Write(data)
+			}
+			return
+		}
+		if r.Method == http.MethodGet {
+			var start, end int64
+			_, _ = fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+			if start <= skipped && skipped <= end {
+				requested = append(requested, r.Header.Get("Range"))
+			}
+		}
+		http.ServeContent(w, r, "pkg.ipc", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+	header := http.Header{"Authorization": {"Bearer token"}}
+
+	src, err := OpenRangeSource(server.URL+"/pkg.ipc", header)
+	assert.NoError(t, err)
+	defer src.Close()
+	assert.Equal(t, int64(len(data)), src.Size())
+	payload, err := openIndexed(t, src)
+	assert.NoError(t, err)
+	out := t.TempDir()
+	assert.NoError(t, payload.Extract("etc/app/config.yaml", "../test/public.pem", "SHA256", out))
+	contents, err := os.ReadFile(filepath.Join(out, "etc/app/config.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, indexedContents["etc/app/config.yaml"], contents)
+	assert.Empty(t, requested)
+
+	src, err = OpenRangeSource(server.URL+"/no-ranges.ipc", header)
+	assert.NoError(t, err)
+	_, err = src.ReadAt(make([]byte, 10), 0)
+	assert.ErrorContains(t, err, "does not support range requests")
+	_, err = OpenRangeSource(server.URL+"/pkg.ipc", nil)
+	assert.ErrorContains(t, err, "401 Unauthorized")
+}
+
+func TestBlockRange_ReadAt(t *testing.T) {
+	data := randomBytes(4, 3*rangeBlockSize+10)
+	var fetches int
+	src := &blockRange{size: int64(len(data)), fetch: func(offset, length int64) ([]byte, error) {
+		fetches++
+		return data[offset : offset+length], nil
+	}}
+	buf := make([]byte, 100)
+	n, err := src.ReadAt(buf, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, data[10:110], buf)
+	// Small reads are served from the last block
+	_, err = src.ReadAt(buf, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fetches)
+
+	n, err = src.ReadAt(buf, int64(len(data)-50))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 50, n)
+	assert.Equal(t, data[len(data)-50:], buf[:n])
+
+	large := make([]byte, 2*rangeBlockSize)
+	_, err = src.ReadAt(large, rangeBlockSize/2)
+	assert.NoError(t, err)
+	assert.Equal(t, data[rangeBlockSize/2:rangeBlockSize/2+len(large)], large)
+}
+
+func TestOpenRangeSource_S3(t *testing.T) {
+	data := []byte("sealed package")
+	tmpSize, tmpRange := s3ObjectSize, s3DownloadRange
+	defer func() { s3ObjectSize, s3DownloadRange = tmpSize, tmpRange }()
+	s3ObjectSize = func(uri string) (int64, error) {
+		assert.Equal(t, "s3://bucket/pkg", uri)
+		return int64(len(data)), nil
+	}
+	s3DownloadRange = func(uri string, offset, length int64) ([]byte, error) {
+		return data[offset : offset+length], nil
+	}
+	src, err := OpenRangeSource("s3://bucket/pkg", nil)
+	assert.NoError(t, err)
+	buf := make([]byte, 7)
+	_, err = src.ReadAt(buf, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, "package", string(buf))
+}
+
+func TestCheckIndexable(t *testing.T) {
+	for _, algo := range []string{"gzip", "zstd", "zip"} {
+		assert.NoError(t, CheckIndexable(GetCompressionAlgoIndex(algo)))
+	}
+	assert.ErrorContains(t, CheckIndexable(GetCompressionAlgoIndex("zlib")), "zlib streams cannot be split")
+}
+
+func TestEnvelope_IndexRecord(t *testing.T) {
+	e := &Envelope{Version: EnvelopeVersion5, PayloadLen: 1000}
+	assert.NoError(t, e.SetIndex(&WriteArchive{IndexOffset: 900, IndexLength: 100}))
+	assert.True(t, e.HasIndex())
+	parsed := &Envelope{Version: EnvelopeVersion5, PayloadLen: 1000}
+	assert.NoError(t, parsed.readIndexRecord(bytes.NewReader(e.indexRecord())))
+	assert.Equal(t, int64(900), parsed.IndexOffset)
+	assert.Equal(t, int64(100), parsed.IndexLength)
+
+	parsed.PayloadLen = 999
+	assert.ErrorContains(t, parsed.readIndexRecord(bytes.NewReader(e.indexRecord())), "exceeds the payload")
+	assert.ErrorContains(t, (&Envelope{Version: EnvelopeVersion4}).SetIndex(&WriteArchive{}), "requires envelope version 5")
+	assert.False(t, strings.Contains((&Envelope{Version: EnvelopeVersion4, HashAlgorithm: crypto.SHA256, Flags: EnvelopeFlagIndexed}).String(), "indexed"))
+}
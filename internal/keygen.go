@@ -0,0 +1,157 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/youmark/pkcs8"
+	"os"
+	"strings"
+)
+
+const (
+	KeyTypeRSA     = "rsa"
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeEd25519 = "ed25519"
+
+	KeyUsageSigning   = "signing"
+	KeyUsageRecipient = "recipient"
+
+	pemTypePrivateKey          = "PRIVATE KEY"
+	pemTypeEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+	pemTypePublicKey           = "PUBLIC KEY"
+)
+
+// defaultKeyBits defines the key size used if none is provided
+var defaultKeyBits = map[string]int{
+	KeyTypeRSA:     4096,
+	KeyTypeECDSA:   384,
+	KeyTypeEd25519: 256,
+}
+
+// GenerateKey creates a new private key of a type and size.
+// If bits is 0, a sensible default is used for the key type.
+func GenerateKey(keyType string, bits int) (crypto.Signer, error) {
+	keyType = strings.ToLower(keyType)
+	if bits == 0 {
+		bits = defaultKeyBits[keyType]
+	}
+	switch keyType {
+	case KeyTypeRSA:
+		if bits < 2048 {
+			return nil, fmt.Errorf("RSA keys must have at least 2048 bits")
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case KeyTypeECDSA:
+		var curve elliptic.Curve
+		switch bits {
+		case 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("ECDSA keys must have 256, 384 or 521 bits")
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unknown key type '%s', use one of %s, %s, %s", keyType, KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519)
+	}
+}
+
+// EncodePrivateKey encodes a private key as PKCS8 PEM block.
+// If a passphrase is provided, the key is stored as encrypted PKCS8.
+func EncodePrivateKey(key crypto.Signer, passphrase []byte) ([]byte, error) {
+	if len(passphrase) > 0 {
+		der, err := pkcs8.MarshalPrivateKey(key, passphrase, nil)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: pemTypeEncryptedPrivateKey, Bytes: der}), nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der}), nil
+}
+
+// EncodePublicKey encodes a public key as PKIX PEM block.
+func EncodePublicKey(key crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypePublicKey, Bytes: der}), nil
+}
+
+// WriteKeyPair stores a private key and its public key as PEM files.
+// Existing files are never overwritten, and the private key is only readable by the owner.
+func WriteKeyPair(key crypto.Signer, privateKeyPath, publicKeyPath string, passphrase []byte) error {
+	return WriteKeyPairWithUsage(key, privateKeyPath, publicKeyPath, passphrase, "")
+}
+
+// WriteKeyPairWithUsage stores a key pair like WriteKeyPair, declaring the usage in the PEM headers of both keys,
+// so they are refused for the other usage.
+func WriteKeyPairWithUsage(key crypto.Signer, privateKeyPath, publicKeyPath string, passphrase []byte, usage string) error {
+	privPem, err := EncodePrivateKey(key, passphrase)
+	if err != nil {
+		return err
+	}
+	pubPem, err := EncodePublicKey(key.Public())
+	if err != nil {
+		return err
+	}
+	privPem, pubPem = setKeyUsage(privPem, usage), setKeyUsage(pubPem, usage)
+	if err = writeNewFile(privateKeyPath, privPem, 0600); err != nil {
+		return err
+	}
+	if err = writeNewFile(publicKeyPath, pubPem, 0644); err != nil {
+		// A private key without its public key is useless, and would block generating the pair again
+		_ = os.Remove(privateKeyPath)
+		return err
+	}
+	return nil
+}
+
+// writeNewFile writes contents to a file that must not exist yet. The file is removed again if it cannot be written completely.
+func writeNewFile(name string, contents []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(contents); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(name)
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyType string
+		bits    int
+		wantErr string
+	}{
+		{"RSA", KeyTypeRSA, 2048, ""},
+		{"RSA uppercase", "RSA", 2048, ""},
+		{"RSA too small", KeyTypeRSA, 1024, "at least 2048 bits"},
+		{"ECDSA default", KeyTypeECDSA, 0, ""},
+		{"ECDSA invalid curve", KeyTypeECDSA, 128, "256, 384 or 521 bits"},
+		{"Ed25519", KeyTypeEd25519, 0, ""},
+		{"Unknown", "dsa", 0, "unknown key type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := GenerateKey(tt.keyType, tt.bits)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, key)
+		})
+	}
+}
+
+func TestWriteKeyPair_Roundtrip(t *testing.T) {
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519} {
+		t.Run(keyType, func(t *testing.T) {
+			bits := 0
+			if keyType == KeyTypeRSA {
+				bits = 2048
+			}
+			key, err := GenerateKey(keyType, bits)
+			assert.NoError(t, err)
+			dir := t.TempDir()
+			privPath, pubPath := filepath.Join(dir, "private.pem"), filepath.Join(dir, "public.pem")
+			assert.NoError(t, WriteKeyPair(key, privPath, pubPath, nil))
+			info, err := os.Stat(privPath)
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+			// Keys must be usable for signing and verification
+			signer, err := CreatePKISigner(privPath)
+			assert.NoError(t, err)
+			verifier, err := CreatePKIVerifier(pubPath)
+			assert.NoError(t, err)
+			assert.NotNil(t, signer)
+			assert.NotNil(t, verifier)
+
+			// Never overwrite existing keys
+			assert.ErrorIs(t, WriteKeyPair(key, privPath, pubPath, nil), os.ErrExist)
+
+			// No private key is left behind if the public key cannot be written
+			otherPriv := filepath.Join(dir, "other.pem")
+			assert.ErrorIs(t, WriteKeyPair(key, otherPriv, pubPath, nil), os.ErrExist)
+			assert.NoFileExists(t, otherPriv)
+		})
+	}
+}
+
+func TestWriteKeyPair_Passphrase(t *testing.T) {
+	old := PassphraseProvider
+	defer func() { PassphraseProvider = old }()
+	key, err := GenerateKey(KeyTypeECDSA, 256)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	privPath, pubPath := filepath.Join(dir, "private.pem"), filepath.Join(dir, "public.pem")
+	assert.NoError(t, WriteKeyPair(key, privPath, pubPath, []byte("fnord")))
+	contents, err := os.ReadFile(privPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "ENCRYPTED PRIVATE KEY")
+
+	// Correct passphrase
+	PassphraseProvider = func(path string) ([]byte, error) { return []byte("fnord"), nil }
+	loaded, err := LoadPrivateKey(privPath)
+	assert.NoError(t, err)
+	assert.True(t, key.(*ecdsa.PrivateKey).Equal(loaded))
+
+	// Wrong passphrase
+	PassphraseProvider = func(path string) ([]byte, error) { return []byte("foo"), nil }
+	_, err = LoadPrivateKey(privPath)
+	assert.Error(t, err)
+
+	// No passphrase
+	PassphraseProvider = func(path string) ([]byte, error) { return nil, fmt.Errorf("no passphrase") }
+	_, err = LoadPrivateKey(privPath)
+	assert.ErrorContains(t, err, "no passphrase")
+}
+
+func TestEncodePublicKey(t *testing.T) {
+	rsaKey, _ := GenerateKey(KeyTypeRSA, 2048)
+	edKey, _ := GenerateKey(KeyTypeEd25519, 0)
+	for _, pub := range []any{rsaKey.(*rsa.PrivateKey).Public(), edKey.(ed25519.PrivateKey).Public()} {
+		pemBytes, err := EncodePublicKey(pub)
+		assert.NoError(t, err)
+		assert.Contains(t, string(pemBytes), "BEGIN PUBLIC KEY")
+	}
+}
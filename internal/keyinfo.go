@@ -0,0 +1,160 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"strings"
+)
+
+// KeyInfo describes a private or public key and what it can be used for
+type KeyInfo struct {
+	Path        string
+	Type        string
+	Bits        int
+	Private     bool
+	Fingerprint string
+	Signing     bool
+	Encryption  bool
+	// DeclaredUsage is the usage recorded by keygen, if any
+	DeclaredUsage string
+}
+
+// Fingerprint calculates the SHA-256 fingerprint of the DER-encoded SubjectPublicKeyInfo of a public key.
+// OpenPGP keys are identified by the primary key of their first entity.
+func Fingerprint(pub crypto.PublicKey) (string, error) {
+	switch key := pub.(type) {
+	case *openpgp.Entity:
+		pub = openPGPPublicKey(key)
+	case openpgp.EntityList:
+		pub = openPGPPublicKey(key[0])
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// GetKeyInfo loads a private key, public key or key of a KeyProvider, e.g. AWS KMS or TPM, and describes it
+func GetKeyInfo(path string) (*KeyInfo, error) {
+	info := &KeyInfo{Path: path}
+	var pub crypto.PublicKey
+	if IsExternalKey(path) {
+		provider, err := KeyProviderFor(path)
+		if err != nil {
+			return nil, err
+		}
+		if pub, err = provider.PublicKey(path); err != nil {
+			return nil, err
+		}
+		info.Private = true
+	} else if priv, err := LoadPrivateKey(path); err == nil {
+		switch key := priv.(type) {
+		case crypto.Signer:
+			pub = key.Public()
+		case openpgp.EntityList:
+			pub = key
+		default:
+			return nil, fmt.Errorf("unsupported private key type %T", priv)
+		}
+		info.Private = true
+	} else if pub, err = LoadPublicKey(path); err != nil {
+		return nil, fmt.Errorf("neither a private nor a public key: %v", err)
+	}
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		info.Type = "RSA"
+		info.Bits = key.N.BitLen()
+		info.Signing = true
+		info.Encryption = true
+	case *ecdsa.PublicKey:
+		info.Type = "ECDSA " + key.Curve.Params().Name
+		info.Bits = key.Curve.Params().BitSize
+		info.Signing = true
+		info.Encryption = true
+	case ed25519.PublicKey:
+		info.Type = "Ed25519"
+		info.Bits = 256
+		info.Signing = true
+		info.Encryption = true
+	case openpgp.EntityList:
+		entity := key[0]
+		bits, _ := entity.PrimaryKey.BitLength()
+		info.Type = fmt.Sprintf("OpenPGP %s (key ID %s)", openPGPKeyType(entity), entity.PrimaryKey.KeyIdString())
+		info.Bits = int(bits)
+		info.Signing = entity.PrimaryKey.CanSign()
+		info.Encryption = openPGPCanEncrypt(entity)
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	var err error
+	if info.Fingerprint, err = Fingerprint(pub); err != nil {
+		return nil, err
+	}
+	info.DeclaredUsage = ReadKeyUsage(path)
+	return info, nil
+}
+
+// keyTypeName names the type of a private key like GetKeyInfo does
+func keyTypeName(key any) string {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return "RSA"
+	case *ecdsa.PrivateKey:
+		return "ECDSA " + k.Curve.Params().Name
+	case ed25519.PrivateKey:
+		return "Ed25519"
+	}
+	return fmt.Sprintf("%T", key)
+}
+
+// Usage describes what a key can be used for in sealpack
+func (k *KeyInfo) Usage() string {
+	switch {
+	case k.Signing && k.Encryption:
+		return "signing and encryption"
+	case k.Encryption:
+		return "encryption"
+	default:
+		return "signing"
+	}
+}
+
+// String prints a string representation of a KeyInfo
+func (k *KeyInfo) String() string {
+	kind := "public"
+	if k.Private {
+		kind = "private"
+	}
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s is a %s %s key.\n", k.Path, k.Type, kind))
+	sb.WriteString(fmt.Sprintf("\tSize: %d Bit\n", k.Bits))
+	sb.WriteString(fmt.Sprintf("\tFingerprint: %s\n", k.Fingerprint))
+	sb.WriteString(fmt.Sprintf("\tUsable for %s\n", k.Usage()))
+	if k.DeclaredUsage != "" {
+		sb.WriteString(fmt.Sprintf("\tDeclared for %s\n", k.DeclaredUsage))
+	}
+	return sb.String()
+}
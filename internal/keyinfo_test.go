@@ -0,0 +1,63 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetKeyInfo(t *testing.T) {
+	tests := []struct {
+		file       string
+		keyType    string
+		bits       int
+		private    bool
+		encryption bool
+	}{
+		{"private.pem", "RSA", 4096, true, true},
+		{"public.pem", "RSA", 4096, false, true},
+		{"pkcs1-public.pem", "RSA", 1024, false, true},
+		{"ec-private.pem", "Ed25519", 256, true, true},
+		{"asn1-public.pem", "ECDSA P-256", 256, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			info, err := GetKeyInfo(filepath.Join(TestFilePath, tt.file))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.keyType, info.Type)
+			assert.Equal(t, tt.bits, info.Bits)
+			assert.Equal(t, tt.private, info.Private)
+			assert.True(t, info.Signing)
+			assert.Equal(t, tt.encryption, info.Encryption)
+			assert.Regexp(t, "^sha256:[0-9a-f]{64}$", info.Fingerprint)
+			assert.Contains(t, info.String(), info.Fingerprint)
+		})
+	}
+}
+
+func TestGetKeyInfo_SameFingerprint(t *testing.T) {
+	priv, err := GetKeyInfo(filepath.Join(TestFilePath, "private.pem"))
+	assert.NoError(t, err)
+	pub, err := GetKeyInfo(filepath.Join(TestFilePath, "public.pem"))
+	assert.NoError(t, err)
+	assert.Equal(t, priv.Fingerprint, pub.Fingerprint)
+}
+
+func TestGetKeyInfo_Invalid(t *testing.T) {
+	_, err := GetKeyInfo(filepath.Join(TestFilePath, "nonexistent.pem"))
+	assert.ErrorContains(t, err, "neither a private nor a public key")
+}
@@ -0,0 +1,181 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"strings"
+)
+
+// KeyProvider loads keys from a backend, chosen by the scheme of the key reference, e.g. awskms:///alias/signing.
+// Operations a backend does not support return an error.
+type KeyProvider interface {
+	// Signer creates a signer for the key using the named signature hash
+	Signer(ref, signatureHash string) (signature.Signer, error)
+	// Verifier creates a verifier for the key using the named signature hash
+	Verifier(ref, signatureHash string) (signature.Verifier, error)
+	// PrivateKey provides the key decrypting the key entry of a recipient, usually a crypto.Decrypter
+	PrivateKey(ref string) (crypto.PrivateKey, error)
+	// PublicKey provides the public key, e.g. to calculate its fingerprint
+	PublicKey(ref string) (crypto.PublicKey, error)
+}
+
+// reservedKeySchemes are known key backends without a built-in provider
+var reservedKeySchemes = []string{"gcpkms", "pkcs11", "vault"}
+
+// keyProviders are the registered providers by the scheme of the key references they handle
+var keyProviders = map[string]KeyProvider{
+	"file":   fileKeyProvider{},
+	"awskms": awsKmsKeyProvider{},
+	"tpm":    tpmKeyProvider{},
+	"tpm2":   tpmKeyProvider{},
+}
+
+// RegisterKeyProvider registers a provider for all key references with the scheme, e.g. "vault" for vault://...
+func RegisterKeyProvider(scheme string, provider KeyProvider) {
+	keyProviders[scheme] = provider
+}
+
+// keyScheme provides the scheme of a key reference, or an empty string for file paths.
+// Single letters are Windows drive letters, not schemes.
+func keyScheme(ref string) string {
+	scheme, _, found := strings.Cut(ref, ":")
+	if !found || len(scheme) < 2 || strings.ContainsAny(scheme, `/\.`) {
+		return ""
+	}
+	return scheme
+}
+
+// KeyProviderFor provides the KeyProvider for a key reference; references without a scheme are files
+func KeyProviderFor(ref string) (KeyProvider, error) {
+	scheme := keyScheme(ref)
+	if provider, ok := keyProviders[scheme]; ok {
+		return provider, nil
+	}
+	for _, reserved := range reservedKeySchemes {
+		if scheme == reserved {
+			return nil, WithHint(fmt.Errorf("no key provider for %s keys", scheme),
+				"embed sealpack and register a provider for the scheme %s with sealpack.RegisterKeyProvider", scheme)
+		}
+	}
+	if scheme != "" && strings.HasPrefix(ref, scheme+"://") {
+		return nil, fmt.Errorf("unsupported key reference %s: no key provider for scheme %s", ref, scheme)
+	}
+	return keyProviders["file"], nil
+}
+
+// IsExternalKey checks whether a key reference points to a key that is not read from a local file, e.g. in a KMS or TPM
+func IsExternalKey(ref string) bool {
+	provider, err := KeyProviderFor(ref)
+	return err != nil || provider != keyProviders["file"]
+}
+
+// fileKeyProvider reads PEM, OpenSSH and OpenPGP keys from files, referenced by path or file:// URI
+type fileKeyProvider struct{}
+
+// path strips the file:// scheme from a key reference
+func (fileKeyProvider) path(ref string) string {
+	return strings.TrimPrefix(ref, "file://")
+}
+
+func (p fileKeyProvider) Signer(ref, signatureHash string) (signature.Signer, error) {
+	pKey, err := LoadPrivateKey(p.path(ref))
+	if err != nil {
+		return nil, err
+	}
+	if keyring, ok := pKey.(openpgp.EntityList); ok {
+		return newOpenPGPSigner(keyring, signatureHash)
+	}
+	opts, err := signatureOptions(signatureHash, pKey)
+	if err != nil {
+		return nil, err
+	}
+	return signature.LoadSignerWithOpts(pKey, opts...)
+}
+
+func (p fileKeyProvider) Verifier(ref, signatureHash string) (signature.Verifier, error) {
+	pubKey, err := LoadPublicKey(p.path(ref))
+	if err != nil {
+		return nil, err
+	}
+	if keyring, ok := pubKey.(openpgp.EntityList); ok {
+		return &openPGPVerifier{keyring: keyring}, nil
+	}
+	opts, err := signatureOptions(signatureHash, pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return signature.LoadVerifierWithOpts(pubKey, opts...)
+}
+
+func (p fileKeyProvider) PrivateKey(ref string) (crypto.PrivateKey, error) {
+	return LoadPrivateKey(p.path(ref))
+}
+
+func (p fileKeyProvider) PublicKey(ref string) (crypto.PublicKey, error) {
+	return LoadPublicKey(p.path(ref))
+}
+
+// awsKmsKeyProvider signs and verifies with AWS KMS keys; they always use the hash defined by their key spec
+type awsKmsKeyProvider struct{}
+
+func (awsKmsKeyProvider) Signer(ref, _ string) (signature.Signer, error) {
+	return createKmsSigner(ref)
+}
+
+func (awsKmsKeyProvider) Verifier(ref, _ string) (signature.Verifier, error) {
+	return createKmsVerifier(ref)
+}
+
+func (awsKmsKeyProvider) PrivateKey(ref string) (crypto.PrivateKey, error) {
+	return nil, WithHint(fmt.Errorf("AWS KMS key %s cannot decrypt packages", ref),
+		"use a private key file or a TPM key for unsealing")
+}
+
+func (awsKmsKeyProvider) PublicKey(ref string) (crypto.PublicKey, error) {
+	verifier, err := createKmsVerifier(ref)
+	if err != nil {
+		return nil, err
+	}
+	return verifier.PublicKey()
+}
+
+// tpmKeyProvider decrypts with RSA keys persisted in a TPM, referenced as tpm:<handle> or tpm2://<handle>
+type tpmKeyProvider struct{}
+
+// key provides the TPM key at the handle of the reference
+func (tpmKeyProvider) key(ref string) tpmKey {
+	_, handle, _ := strings.Cut(ref, ":")
+	return tpmKey{handle: strings.TrimPrefix(handle, "//")}
+}
+
+func (tpmKeyProvider) Signer(ref, _ string) (signature.Signer, error) {
+	return nil, fmt.Errorf("TPM key %s cannot sign packages", ref)
+}
+
+func (tpmKeyProvider) Verifier(ref, _ string) (signature.Verifier, error) {
+	return nil, fmt.Errorf("TPM key %s cannot verify packages", ref)
+}
+
+func (p tpmKeyProvider) PrivateKey(ref string) (crypto.PrivateKey, error) {
+	return p.key(ref), nil
+}
+
+func (p tpmKeyProvider) PublicKey(ref string) (crypto.PublicKey, error) {
+	return p.key(ref).Public()
+}
@@ -0,0 +1,128 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// remoteDecrypter is a crypto.Decrypter whose private key stays in its backend
+type remoteDecrypter struct {
+	key *rsa.PrivateKey
+}
+
+func (d remoteDecrypter) Public() crypto.PublicKey { return d.key.Public() }
+
+func (d remoteDecrypter) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return d.key.Decrypt(rand, msg, opts)
+}
+
+// testKeyProvider resolves all references to the test key pair
+type testKeyProvider struct{}
+
+func (testKeyProvider) Signer(_, signatureHash string) (signature.Signer, error) {
+	return CreateSignerWithHash(filepath.Join(TestFilePath, "private.pem"), signatureHash)
+}
+
+func (testKeyProvider) Verifier(_, signatureHash string) (signature.Verifier, error) {
+	return CreateVerifierWithHash(filepath.Join(TestFilePath, "public.pem"), signatureHash)
+}
+
+func (testKeyProvider) PrivateKey(string) (crypto.PrivateKey, error) {
+	key, err := LoadPrivateKey(filepath.Join(TestFilePath, "private.pem"))
+	if err != nil {
+		return nil, err
+	}
+	return remoteDecrypter{key: key.(*rsa.PrivateKey)}, nil
+}
+
+func (testKeyProvider) PublicKey(string) (crypto.PublicKey, error) {
+	return LoadPublicKey(filepath.Join(TestFilePath, "public.pem"))
+}
+
+func TestKeyProviderFor(t *testing.T) {
+	tests := []struct {
+		ref      string
+		provider KeyProvider
+		errMsg   string
+	}{
+		{"private.pem", fileKeyProvider{}, ""},
+		{"/etc/sealpack/private.pem", fileKeyProvider{}, ""},
+		{`C:\keys\private.pem`, fileKeyProvider{}, ""},
+		{"file:///etc/sealpack/private.pem", fileKeyProvider{}, ""},
+		{"awskms:///alias/signing", awsKmsKeyProvider{}, ""},
+		{"tpm:0x81000100", tpmKeyProvider{}, ""},
+		{"tpm2://0x81000100", tpmKeyProvider{}, ""},
+		{"vault://transit/keys/signing", nil, "no key provider for vault keys"},
+		{"pkcs11:token=sealpack;object=signing", nil, "no key provider for pkcs11 keys"},
+		{"fnord://key", nil, "no key provider for scheme fnord"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			provider, err := KeyProviderFor(tt.ref)
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.provider, provider)
+			} else {
+				assert.ErrorContains(t, err, tt.errMsg)
+			}
+			assert.Equal(t, tt.provider != fileKeyProvider{}, IsExternalKey(tt.ref))
+		})
+	}
+}
+
+func TestKeyProviders_TPMHandle(t *testing.T) {
+	assert.Equal(t, tpmKey{handle: "0x81000100"}, tpmKeyProvider{}.key("tpm:0x81000100"))
+	assert.Equal(t, tpmKey{handle: "0x81000100"}, tpmKeyProvider{}.key("tpm2://0x81000100"))
+	assert.True(t, IsTPMKey("tpm2:0x81000100"))
+	assert.False(t, IsTPMKey("awskms:///alias/signing"))
+}
+
+func TestRegisterKeyProvider(t *testing.T) {
+	RegisterKeyProvider("test", testKeyProvider{})
+	defer delete(keyProviders, "test")
+
+	signer, err := CreateSigner("test://signing")
+	assert.NoError(t, err)
+	verifier, err := CreateVerifier("test://signing")
+	assert.NoError(t, err)
+	sig, err := signer.SignMessage(bytes.NewReader([]byte("message")))
+	assert.NoError(t, err)
+	assert.NoError(t, verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader([]byte("message"))))
+
+	info, err := GetKeyInfo("test://signing")
+	assert.NoError(t, err)
+	assert.True(t, info.Private)
+	assert.Equal(t, "RSA", info.Type)
+
+	// Keys of providers decrypt key entries as crypto.Decrypter
+	pKey, err := testKeyProvider{}.PrivateKey("test://signing")
+	assert.NoError(t, err)
+	plainKey := make([]byte, 32)
+	_, _ = rand.Read(plainKey)
+	entry, err := rsa.EncryptPKCS1v15(rand.Reader, pKey.(remoteDecrypter).key.Public().(*rsa.PublicKey), plainKey)
+	assert.NoError(t, err)
+	unsealed, err := tryUnsealKeyWith(entry, pKey)
+	assert.NoError(t, err)
+	assert.Equal(t, plainKey, unsealed)
+}
@@ -0,0 +1,171 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keyringExtensions are the file extensions considered as keys when reading a keyring directory
+var keyringExtensions = []string{".pem", ".pub", ".keyring", ".asc"}
+
+// keyringIdHeader is the PEM header that can carry the ID of a key in a keyring
+const keyringIdHeader = "Id"
+
+// A RecipientKey is a recipient's public key together with an ID to refer to it
+type RecipientKey struct {
+	ID  string
+	Key crypto.PublicKey
+	// Usage is the usage declared in the PEM header of the key, if any
+	Usage string
+}
+
+// LoadRecipientKeys expands paths of public keys, keyring files and keyring directories to all contained keys.
+// Keys contained more than once are only returned once.
+func LoadRecipientKeys(paths []string) ([]RecipientKey, error) {
+	var keys []RecipientKey
+	seen := map[string]bool{}
+	for _, path := range paths {
+		loaded, err := loadKeyringPath(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range loaded {
+			fp, err := Fingerprint(key.Key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient key %s: %v", key.ID, err)
+			}
+			if seen[fp] {
+				continue
+			}
+			seen[fp] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// loadKeyringPath reads all keys from a key file, keyring file or directory of those
+func loadKeyringPath(path string) ([]RecipientKey, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return loadKeyringFile(path)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []RecipientKey
+	for _, entry := range entries {
+		if entry.IsDir() || !hasKeyringExtension(entry.Name()) {
+			continue
+		}
+		loaded, err := loadKeyringFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, loaded...)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("keyring directory %s does not contain any public keys", path)
+	}
+	return keys, nil
+}
+
+// hasKeyringExtension checks whether a file name has one of the keyringExtensions
+func hasKeyringExtension(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range keyringExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// loadKeyringFile reads all keys from a file containing one or more PEM-encoded public keys
+func loadKeyringFile(path string) ([]RecipientKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isOpenPGPArmor(data, openPGPPublicKeyBlock) {
+		return parseOpenPGPKeyring(data, path)
+	}
+	keys, err := parseKeyring(data, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		keys, _ = parseAuthorizedKeys(data, path)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s does not contain PEM data", path)
+	}
+	return keys, nil
+}
+
+// parseKeyring parses concatenated PEM-encoded public keys.
+// Each key is identified by an "Id" PEM header, the last comment line (starting with '#') before it,
+// or by its source and position within it.
+func parseKeyring(data []byte, source string) ([]RecipientKey, error) {
+	var keys []RecipientKey
+	rest := data
+	for {
+		preamble := rest
+		if idx := bytes.Index(rest, []byte("-----BEGIN")); idx >= 0 {
+			preamble = rest[:idx]
+		}
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return keys, nil
+		}
+		id := block.Headers[keyringIdHeader]
+		if id == "" {
+			id = lastComment(preamble)
+		}
+		if id == "" && len(keys) == 0 {
+			id = source
+		} else if id == "" {
+			id = fmt.Sprintf("%s#%d", source, len(keys)+1)
+		}
+		key, err := parsePublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %v", id, err)
+		}
+		keys = append(keys, RecipientKey{ID: id, Key: key.(crypto.PublicKey), Usage: block.Headers[keyUsageHeader]})
+	}
+}
+
+// lastComment returns the text of the last comment line in a block of text
+func lastComment(text []byte) string {
+	lines := strings.Split(string(text), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); strings.HasPrefix(line, "#") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readTestKey(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join(TestFilePath, name))
+	assert.NoError(t, err)
+	return data
+}
+
+func TestLoadRecipientKeys_File(t *testing.T) {
+	path := filepath.Join(TestFilePath, "public.pem")
+	keys, err := LoadRecipientKeys([]string{path})
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, path, keys[0].ID)
+}
+
+func TestLoadRecipientKeys_Keyring(t *testing.T) {
+	keyring := "# device-001\n" + string(readTestKey(t, "public.pem")) +
+		"\n# ignored\n# device-002\n" + string(readTestKey(t, "pkcs1-public.pem")) +
+		string(readTestKey(t, "asn1-public.pem"))
+	path := filepath.Join(t.TempDir(), "fleet.keyring")
+	assert.NoError(t, os.WriteFile(path, []byte(keyring), 0644))
+
+	keys, err := LoadRecipientKeys([]string{path})
+	assert.NoError(t, err)
+	assert.Len(t, keys, 3)
+	assert.Equal(t, "device-001", keys[0].ID)
+	assert.Equal(t, "device-002", keys[1].ID)
+	assert.Equal(t, path+"#3", keys[2].ID)
+}
+
+func TestLoadRecipientKeys_PemHeaderId(t *testing.T) {
+	keyring := "-----BEGIN PUBLIC KEY-----\nId: device-042\n\n" +
+		string(readTestKey(t, "public.pem"))[len("-----BEGIN PUBLIC KEY-----\n"):]
+	keys, err := parseKeyring([]byte(keyring), "test")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, "device-042", keys[0].ID)
+}
+
+func TestLoadRecipientKeys_Directory(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.pem"), readTestKey(t, "public.pem"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.pub"), readTestKey(t, "pkcs1-public.pem"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "README.txt"), []byte("not a key"), 0644))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "sub.pem"), 0755))
+
+	keys, err := LoadRecipientKeys([]string{dir, filepath.Join(TestFilePath, "public.pem")})
+	assert.NoError(t, err)
+	// public.pem is contained twice and only used once
+	assert.Len(t, keys, 2)
+	assert.Equal(t, filepath.Join(dir, "a.pem"), keys[0].ID)
+	assert.Equal(t, filepath.Join(dir, "b.pub"), keys[1].ID)
+}
+
+func TestLoadRecipientKeys_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	_, err := LoadRecipientKeys([]string{dir})
+	assert.ErrorContains(t, err, "does not contain any public keys")
+
+	path := filepath.Join(dir, "empty.pem")
+	assert.NoError(t, os.WriteFile(path, []byte("# nothing here\n"), 0644))
+	_, err = LoadRecipientKeys([]string{path})
+	assert.ErrorContains(t, err, "does not contain PEM data")
+
+	broken := filepath.Join(dir, "broken.keyring")
+	assert.NoError(t, os.WriteFile(broken, []byte("# device-007\n-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n"), 0644))
+	_, err = LoadRecipientKeys([]string{broken})
+	assert.ErrorContains(t, err, "invalid public key device-007")
+
+	_, err = LoadRecipientKeys([]string{filepath.Join(dir, "missing.pem")})
+	assert.Error(t, err)
+}
+
+func TestAddKeys_Keyring(t *testing.T) {
+	keys, err := LoadRecipientKeys([]string{filepath.Join(TestFilePath, "public.pem"), filepath.Join(TestFilePath, "pkcs1-public.pem")})
+	assert.NoError(t, err)
+	envelope := &Envelope{}
+	assert.NoError(t, AddKeys(keys, envelope, []byte("secret")))
+	assert.Len(t, envelope.ReceiverKeys, 2)
+
+	keys, err = LoadRecipientKeys([]string{filepath.Join(TestFilePath, "asn1-public.pem")})
+	assert.NoError(t, err)
+	assert.ErrorContains(t, AddKeys(keys, envelope, []byte("secret")), "asn1-public.pem requires envelope version 3")
+	envelope.Version = EnvelopeVersion3
+	assert.NoError(t, AddKeys(keys, envelope, []byte("secret")))
+}
@@ -0,0 +1,141 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"os"
+)
+
+// keyUsageHeader is the PEM header keygen records the intended usage of a key in
+const keyUsageHeader = "Usage"
+
+// allowDualUseHint explains how to use a key for both signing and encryption anyway
+const allowDualUseHint = "use separate keys for signing and encryption, or set --allow-dual-use to use %s anyway"
+
+// setKeyUsage records the usage in the PEM header of an encoded key
+func setKeyUsage(data []byte, usage string) []byte {
+	block, _ := pem.Decode(data)
+	if block == nil || usage == "" {
+		return data
+	}
+	if block.Headers == nil {
+		block.Headers = map[string]string{}
+	}
+	block.Headers[keyUsageHeader] = usage
+	return pem.EncodeToMemory(block)
+}
+
+// ReadKeyUsage provides the usage a key file has been created for, or an empty string if it does not declare any
+func ReadKeyUsage(path string) string {
+	if IsExternalKey(path) {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return ""
+	}
+	return block.Headers[keyUsageHeader]
+}
+
+// publicKeyFingerprint provides the fingerprint of a public key or an unencrypted private key file.
+// Keys that cannot be read without a passphrase or from a KeyProvider yield an empty fingerprint.
+func publicKeyFingerprint(path string) string {
+	if IsExternalKey(path) {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if isOpenPGPArmor(data, openPGPPublicKeyBlock) || isOpenPGPArmor(data, openPGPPrivateKeyBlock) {
+		// The public keys of OpenPGP keyrings are readable without a passphrase
+		keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return ""
+		}
+		fingerprint, _ := Fingerprint(keyring)
+		return fingerprint
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type == pemTypeEncryptedPrivateKey {
+		return ""
+	}
+	defer wipe(block.Bytes)
+	var pub crypto.PublicKey
+	if key, err := parsePrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			pub = signer.Public()
+		}
+	} else if key, err := parsePublicKey(block.Bytes); err == nil {
+		pub = key
+	}
+	if pub == nil {
+		return ""
+	}
+	fingerprint, _ := Fingerprint(pub)
+	return fingerprint
+}
+
+// CheckSealKeyUsage refuses recipient keys for signing, signing keys as recipients and sealing a package for the key
+// it is signed with. Keys without declared usage are only checked for the latter.
+func CheckSealKeyUsage(signingKeyPath string, recipients []RecipientKey) error {
+	var errs []error
+	if ReadKeyUsage(signingKeyPath) == KeyUsageRecipient {
+		errs = append(errs, WithHint(fmt.Errorf("%s is a recipient key and must not be used for signing", signingKeyPath),
+			allowDualUseHint, "it"))
+	}
+	signer := publicKeyFingerprint(signingKeyPath)
+	for _, recipient := range recipients {
+		if recipient.Usage == KeyUsageSigning {
+			errs = append(errs, WithHint(fmt.Errorf("recipient key %s is a signing key and must not be used for encryption", recipient.ID),
+				allowDualUseHint, "it"))
+		} else if fingerprint, _ := Fingerprint(recipient.Key); signer != "" && fingerprint == signer {
+			errs = append(errs, WithHint(fmt.Errorf("recipient key %s belongs to the signing key %s", recipient.ID, signingKeyPath),
+				allowDualUseHint, "the same key pair"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckUnsealKeyUsage refuses signing keys for decryption, recipient keys for verifying signatures and decrypting with
+// the key pair a package has been signed with
+func CheckUnsealKeyUsage(privateKeyPath, signingKeyPath string) error {
+	var errs []error
+	if ReadKeyUsage(signingKeyPath) == KeyUsageRecipient {
+		errs = append(errs, WithHint(fmt.Errorf("%s is a recipient key and must not be used for verifying signatures", signingKeyPath),
+			allowDualUseHint, "it"))
+	}
+	if privateKeyPath == "" {
+		return errors.Join(errs...)
+	}
+	if ReadKeyUsage(privateKeyPath) == KeyUsageSigning {
+		errs = append(errs, WithHint(fmt.Errorf("%s is a signing key and must not be used for decryption", privateKeyPath),
+			allowDualUseHint, "it"))
+	} else if signer := publicKeyFingerprint(signingKeyPath); signer != "" && signer == publicKeyFingerprint(privateKeyPath) {
+		errs = append(errs, WithHint(fmt.Errorf("private key %s belongs to the signing key %s", privateKeyPath, signingKeyPath),
+			allowDualUseHint, "the same key pair"))
+	}
+	return errors.Join(errs...)
+}
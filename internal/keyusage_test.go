@@ -0,0 +1,78 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+// writeUsageKeyPair creates a key pair declared for a usage and returns the paths of the private and public key
+func writeUsageKeyPair(t *testing.T, usage string) (string, string) {
+	key, err := GenerateKey(KeyTypeRSA, 2048)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	privPath, pubPath := filepath.Join(dir, "private.pem"), filepath.Join(dir, "public.pem")
+	assert.NoError(t, WriteKeyPairWithUsage(key, privPath, pubPath, nil, usage))
+	return privPath, pubPath
+}
+
+func TestReadKeyUsage(t *testing.T) {
+	privPath, pubPath := writeUsageKeyPair(t, KeyUsageRecipient)
+	assert.Equal(t, KeyUsageRecipient, ReadKeyUsage(privPath))
+	assert.Equal(t, KeyUsageRecipient, ReadKeyUsage(pubPath))
+	assert.Empty(t, ReadKeyUsage(filepath.Join(TestFilePath, "private.pem")))
+	assert.Empty(t, ReadKeyUsage("awskms:///alias/sealpack"))
+
+	// The usage header does not affect parsing the keys
+	_, err := LoadPrivateKey(privPath)
+	assert.NoError(t, err)
+	keys, err := LoadRecipientKeys([]string{pubPath})
+	assert.NoError(t, err)
+	assert.Equal(t, KeyUsageRecipient, keys[0].Usage)
+	info, err := GetKeyInfo(pubPath)
+	assert.NoError(t, err)
+	assert.Contains(t, info.String(), "Declared for recipient")
+}
+
+func TestCheckSealKeyUsage(t *testing.T) {
+	signPriv, signPub := writeUsageKeyPair(t, KeyUsageSigning)
+	recvPriv, recvPub := writeUsageKeyPair(t, KeyUsageRecipient)
+	load := func(path string) []RecipientKey {
+		keys, err := LoadRecipientKeys([]string{path})
+		assert.NoError(t, err)
+		return keys
+	}
+	assert.NoError(t, CheckSealKeyUsage(signPriv, load(recvPub)))
+	assert.ErrorContains(t, CheckSealKeyUsage(recvPriv, nil), "is a recipient key and must not be used for signing")
+	assert.ErrorContains(t, CheckSealKeyUsage(signPriv, load(signPub)), "is a signing key and must not be used for encryption")
+	// Keys without declared usage must not be the same key pair
+	legacy := filepath.Join(TestFilePath, "private.pem")
+	err := CheckSealKeyUsage(legacy, load(filepath.Join(TestFilePath, "public.pem")))
+	assert.ErrorContains(t, err, "belongs to the signing key")
+	assert.Contains(t, Hints(err)[0], "--allow-dual-use")
+}
+
+func TestCheckUnsealKeyUsage(t *testing.T) {
+	signPriv, signPub := writeUsageKeyPair(t, KeyUsageSigning)
+	recvPriv, recvPub := writeUsageKeyPair(t, KeyUsageRecipient)
+	assert.NoError(t, CheckUnsealKeyUsage(recvPriv, signPub))
+	assert.NoError(t, CheckUnsealKeyUsage("", signPub))
+	assert.ErrorContains(t, CheckUnsealKeyUsage(signPriv, signPub), "is a signing key and must not be used for decryption")
+	assert.ErrorContains(t, CheckUnsealKeyUsage(recvPriv, recvPub), "must not be used for verifying signatures")
+	assert.ErrorContains(t, CheckUnsealKeyUsage(filepath.Join(TestFilePath, "private.pem"), filepath.Join(TestFilePath, "public.pem")),
+		"belongs to the signing key")
+}
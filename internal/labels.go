@@ -0,0 +1,196 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/innomotics/sealpack/format"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxChannelLength limits the size of a channel name
+	maxChannelLength = format.MaxChannelLength
+	// maxAnnotations limits the number of annotations of a package
+	maxAnnotations = format.MaxAnnotations
+	// maxAnnotationKeyLength and maxAnnotationValueLength limit the size of a single annotation
+	maxAnnotationKeyLength   = format.MaxAnnotationKeyLength
+	maxAnnotationValueLength = format.MaxAnnotationValueLength
+)
+
+// channelPattern matches channel names like dev, beta or stable
+var channelPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// annotationKeyPattern matches annotation keys like ticket, build.id or vendor.com/hw-revision
+var annotationKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// ValidateChannel checks the name of a release channel
+func ValidateChannel(channel string) error {
+	if len(channel) > maxChannelLength || !channelPattern.MatchString(channel) {
+		return fmt.Errorf("invalid channel '%s', use up to %d lowercase letters, digits, '.', '_' and '-'", channel, maxChannelLength)
+	}
+	return nil
+}
+
+// ValidateAnnotations checks number, keys and values of annotations
+func ValidateAnnotations(annotations map[string]string) error {
+	if len(annotations) > maxAnnotations {
+		return fmt.Errorf("%d annotations exceed the limit of %d", len(annotations), maxAnnotations)
+	}
+	for key, value := range annotations {
+		if len(key) > maxAnnotationKeyLength || !annotationKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid annotation key '%s', use up to %d letters, digits, '.', '_', '/' and '-'", key, maxAnnotationKeyLength)
+		}
+		if len(value) > maxAnnotationValueLength || !utf8.ValidString(value) {
+			return fmt.Errorf("invalid value of annotation '%s', use up to %d bytes of UTF-8", key, maxAnnotationValueLength)
+		}
+	}
+	return nil
+}
+
+// ParseAnnotations parses annotations given as "key=value"
+func ParseAnnotations(pairs []string) (map[string]string, error) {
+	annotations := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid annotation '%s', use key=value", pair)
+		}
+		annotations[key] = value
+	}
+	return annotations, ValidateAnnotations(annotations)
+}
+
+// MatchAnnotations determines whether annotations satisfy all filters given as "key=value", or "key" for any value
+func MatchAnnotations(annotations map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		key, value, hasValue := strings.Cut(filter, "=")
+		actual, ok := annotations[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasLabels determines whether the envelope records channel or annotations of the package
+func (e *Envelope) HasLabels() bool {
+	return e.Version >= EnvelopeVersion5 && e.Flags&EnvelopeFlagLabels != 0
+}
+
+// SetChannel records the channel the package is sealed for, covered by the envelope signature
+func (e *Envelope) SetChannel(channel string) error {
+	if e.Version < EnvelopeVersion5 {
+		return fmt.Errorf("a channel requires envelope version %d or newer", EnvelopeVersion5)
+	}
+	if err := ValidateChannel(channel); err != nil {
+		return err
+	}
+	e.Channel = channel
+	e.Flags |= EnvelopeFlagLabels
+	return nil
+}
+
+// SetAnnotations records annotations of the package, covered by the envelope signature
+func (e *Envelope) SetAnnotations(annotations map[string]string) error {
+	if len(annotations) == 0 {
+		return nil
+	}
+	if e.Version < EnvelopeVersion5 {
+		return fmt.Errorf("annotations require envelope version %d or newer", EnvelopeVersion5)
+	}
+	if err := ValidateAnnotations(annotations); err != nil {
+		return err
+	}
+	e.Annotations = annotations
+	e.Flags |= EnvelopeFlagLabels
+	return nil
+}
+
+// labelsRecord encodes the channel, possibly empty, and the annotations sorted by key, all strings prefixed with their length as uvarint
+func (e *Envelope) labelsRecord() []byte {
+	return format.AppendLabelsRecord(nil, e.Channel, e.Annotations)
+}
+
+// readLabelsRecord reads channel and annotations following the index record
+func (e *Envelope) readLabelsRecord(rd io.ByteReader) error {
+	channel, err := readRecordString(rd, maxChannelLength)
+	if err != nil {
+		return err
+	}
+	if channel != "" {
+		if err = ValidateChannel(channel); err != nil {
+			return err
+		}
+	}
+	count, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return err
+	}
+	if count > maxAnnotations {
+		return fmt.Errorf("%d annotations exceed the limit of %d", count, maxAnnotations)
+	}
+	var annotations map[string]string
+	if count > 0 {
+		annotations = make(map[string]string, count)
+	}
+	for range count {
+		key, err := readRecordString(rd, maxAnnotationKeyLength)
+		if err != nil {
+			return err
+		}
+		if annotations[key], err = readRecordString(rd, maxAnnotationValueLength); err != nil {
+			return err
+		}
+	}
+	if err = ValidateAnnotations(annotations); err != nil {
+		return err
+	}
+	e.Channel, e.Annotations = channel, annotations
+	return nil
+}
+
+// annotationKeys provides the keys of annotations in sorted order
+func annotationKeys(annotations map[string]string) []string {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readRecordString reads a string prefixed with its length as uvarint
+func readRecordString(rd io.ByteReader, maxLength uint64) (string, error) {
+	length, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return "", err
+	}
+	if length > maxLength {
+		return "", fmt.Errorf("invalid string length %d", length)
+	}
+	s := make([]byte, length)
+	for i := range s {
+		if s[i], err = rd.ReadByte(); err != nil {
+			return "", err
+		}
+	}
+	return string(s), nil
+}
@@ -0,0 +1,142 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// labeledEnvelope creates the bytes of a signed envelope sealed for a channel with annotations
+func labeledEnvelope(t *testing.T, channel string, annotations map[string]string) []byte {
+	envelope := &Envelope{Version: EnvelopeVersion5, ReceiverKeys: [][]byte{[]byte("fuyoooh!")}, SignatureHash: "sha-256"}
+	if channel != "" {
+		assert.NoError(t, envelope.SetChannel(channel))
+	}
+	assert.NoError(t, envelope.SetAnnotations(annotations))
+	return signTestEnvelope(t, envelope, []byte("payload"))
+}
+
+func TestValidateChannel(t *testing.T) {
+	for _, channel := range []string{"dev", "beta", "stable", "lts-2026.1", "canary_eu"} {
+		assert.NoError(t, ValidateChannel(channel), channel)
+	}
+	for _, channel := range []string{"", "Stable", "-beta", "beta channel", "../stable", string(bytes.Repeat([]byte("a"), 65))} {
+		assert.Error(t, ValidateChannel(channel), channel)
+	}
+}
+
+func TestEnvelope_Labels(t *testing.T) {
+	raw := labeledEnvelope(t, "beta", nil)
+
+	env, err := ParseEnvelope(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.True(t, env.HasLabels())
+	assert.Equal(t, "beta", env.Channel)
+	assert.Equal(t, [][]byte{[]byte("fuyoooh!")}, env.ReceiverKeys)
+	assert.NoError(t, env.VerifySignature(filepath.Join(TestFilePath, "public.pem")))
+	assert.Contains(t, env.String(), "Sealed for channel beta")
+
+	// The channel is covered by the envelope signature
+	env.Channel = "stable"
+	assert.ErrorIs(t, env.VerifySignature(filepath.Join(TestFilePath, "public.pem")), ErrBadSignature)
+
+	d, err := Diagnose(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.True(t, d.Intact())
+	assert.Contains(t, section(d, "labels").Detail, "channel beta, 0 annotations")
+	assert.Contains(t, section(d, "keys").Detail, "1 receivers")
+
+	assert.ErrorContains(t, (&Envelope{Version: EnvelopeVersion4}).SetChannel("beta"), "requires envelope version 5")
+}
+
+func TestEnvelope_Annotations(t *testing.T) {
+	annotations := map[string]string{"ticket": "OPS-123", "build.id": "4711", "vendor.com/hw-revision": "rev2", "note": ""}
+	raw := labeledEnvelope(t, "", annotations)
+
+	env, err := ParseEnvelope(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Empty(t, env.Channel)
+	assert.Equal(t, annotations, env.Annotations)
+	assert.NoError(t, env.VerifySignature(filepath.Join(TestFilePath, "public.pem")))
+	assert.Contains(t, env.String(), "\t\tbuild.id=4711\n\t\tnote=\n\t\tticket=OPS-123\n")
+	assert.NotContains(t, env.String(), "channel")
+
+	env.Annotations["ticket"] = "OPS-999"
+	assert.ErrorIs(t, env.VerifySignature(filepath.Join(TestFilePath, "public.pem")), ErrBadSignature)
+
+	d, err := Diagnose(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Contains(t, section(d, "labels").Detail, "no channel, 4 annotations")
+}
+
+func TestParseAnnotations(t *testing.T) {
+	annotations, err := ParseAnnotations([]string{"ticket=OPS-123", "query=a=b", "empty="})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"ticket": "OPS-123", "query": "a=b", "empty": ""}, annotations)
+
+	for _, invalid := range []string{"ticket", "=value", "with space=1", "-key=1", "key=" + strings.Repeat("x", 1025)} {
+		_, err = ParseAnnotations([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+	many := make([]string, 65)
+	for i := range many {
+		many[i] = fmt.Sprintf("key%d=value", i)
+	}
+	_, err = ParseAnnotations(many)
+	assert.ErrorContains(t, err, "exceed the limit")
+}
+
+func TestMatchAnnotations(t *testing.T) {
+	annotations := map[string]string{"ticket": "OPS-123", "hw": "rev2"}
+	assert.True(t, MatchAnnotations(annotations, nil))
+	assert.True(t, MatchAnnotations(annotations, []string{"ticket=OPS-123", "hw"}))
+	assert.False(t, MatchAnnotations(annotations, []string{"ticket=OPS-124"}))
+	assert.False(t, MatchAnnotations(annotations, []string{"build"}))
+	assert.False(t, MatchAnnotations(nil, []string{"hw"}))
+}
+
+func TestCatalog_Promote(t *testing.T) {
+	repo := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(repo, "app-1.2.0.ipc"), labeledEnvelope(t, "beta", map[string]string{"build": "4711"}), 0644))
+	copyGolden(t, "v5-sealed", filepath.Join(repo, "app-1.1.0.ipc"))
+
+	catalog, err := ScanCatalog(repo, "")
+	assert.NoError(t, err)
+	assert.Len(t, catalog.Packages, 2)
+	assert.Empty(t, catalog.Packages[0].Channel)
+	assert.Equal(t, "beta", catalog.Packages[1].Channel)
+	assert.Equal(t, map[string]string{"build": "4711"}, catalog.Packages[1].Annotations)
+	assert.Len(t, catalog.InChannel("beta"), 1)
+	assert.Empty(t, catalog.InChannel("stable"))
+
+	created := catalog.Created
+	assert.NoError(t, catalog.Promote("app", "1.2.0", "stable"))
+	assert.Equal(t, "1.2.0", catalog.InChannel("stable")[0].Version)
+	assert.Empty(t, catalog.InChannel("beta"))
+	assert.False(t, catalog.Created.Before(created))
+
+	assert.ErrorContains(t, catalog.Promote("app", "9.9.9", "stable"), "no package app in version 9.9.9")
+	assert.Error(t, catalog.Promote("app", "1.2.0", "Stable"))
+
+	catalog.FilterAnnotations([]string{"build=4711"})
+	assert.Len(t, catalog.Packages, 1)
+	assert.Equal(t, "1.2.0", catalog.Packages[0].Version)
+}
@@ -0,0 +1,271 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// layerCachePrefix starts the names of all layers in a cache, other files are left alone
+const layerCachePrefix = "sha256-"
+
+// LayerCache stores the compressed layers of pulled images by digest, so repeated seals do not download them again.
+// The least recently used layers are evicted once the cache exceeds its maximum size.
+type LayerCache struct {
+	// Dir holds the layers, it is shared by concurrent seals
+	Dir string
+	// MaxSize bounds the cache in bytes, 0 does not bound it
+	MaxSize int64
+	mu      sync.Mutex
+}
+
+// Image takes the layers of an image from the cache and stores the missing ones while they are pulled
+func (c *LayerCache) Image(image v1.Image) v1.Image {
+	return &cachedImage{Image: image, cache: c}
+}
+
+// path provides the file of a layer, empty if the layer is not addressed by SHA-256
+func (c *LayerCache) path(digest v1.Hash) string {
+	if digest.Algorithm != "sha256" {
+		return ""
+	}
+	return filepath.Join(c.Dir, layerCachePrefix+digest.Hex)
+}
+
+// evict removes the least recently used layers until the cache fits its maximum size
+func (c *LayerCache) evict() error {
+	if c.MaxSize <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+	var layers []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), layerCachePrefix) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			layers = append(layers, info)
+			total += info.Size()
+		}
+	}
+	sort.Slice(layers, func(i, j int) bool { return layers[i].ModTime().Before(layers[j].ModTime()) })
+	for _, layer := range layers {
+		if total <= c.MaxSize {
+			break
+		}
+		// Layers still read by other seals are removed once they are closed, except on Windows
+		if err = os.Remove(filepath.Join(c.Dir, layer.Name())); err == nil || errors.Is(err, os.ErrNotExist) {
+			total -= layer.Size()
+		}
+	}
+	return nil
+}
+
+// cachedImage replaces the layers of an image by cached ones
+type cachedImage struct {
+	v1.Image
+	cache *LayerCache
+}
+
+// Layers implements v1.Image
+func (i *cachedImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	cached := make([]v1.Layer, len(layers))
+	for idx, layer := range layers {
+		cached[idx] = &cachedLayer{Layer: layer, cache: i.cache}
+	}
+	return cached, nil
+}
+
+// cachedLayer reads the compressed contents of a layer from the cache, or stores them while reading them from the registry
+type cachedLayer struct {
+	v1.Layer
+	cache *LayerCache
+}
+
+// Compressed implements v1.Layer
+func (l *cachedLayer) Compressed() (io.ReadCloser, error) {
+	digest, err := l.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := l.Size()
+	if err != nil {
+		return nil, err
+	}
+	path := l.cache.path(digest)
+	if path == "" {
+		return l.Layer.Compressed()
+	}
+	if f, err := os.Open(path); err == nil {
+		// Using a layer marks it as recently used
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+		log.Debugf("layer %s found in cache", digest)
+		return &cacheReader{f: f, layer: newLayerDigest(digest, size)}, nil
+	}
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(l.cache.Dir, 0700); err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(l.cache.Dir, ".layer-*")
+	if err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+	return &cacheWriter{r: io.TeeReader(rc, tmp), rc: rc, tmp: tmp, layer: newLayerDigest(digest, size), path: path, cache: l.cache}, nil
+}
+
+// layerDigest verifies the contents of a layer. As consumers like tarball.Write read exactly the size of the layer
+// without reaching EOF, the contents are verified as soon as all of them have been read.
+type layerDigest struct {
+	digest   hash.Hash
+	expected string
+	size     int64
+	read     int64
+}
+
+func newLayerDigest(digest v1.Hash, size int64) *layerDigest {
+	return &layerDigest{digest: sha256.New(), expected: digest.Hex, size: size}
+}
+
+// add hashes contents read and reports whether the layer is complete and whether it matches its digest
+func (d *layerDigest) add(p []byte, err error) (complete bool, valid bool) {
+	_, _ = d.digest.Write(p)
+	d.read += int64(len(p))
+	if d.read < d.size && err != io.EOF {
+		return false, false
+	}
+	return true, d.read == d.size && hex.EncodeToString(d.digest.Sum(nil)) == d.expected
+}
+
+// cacheReader reads a cached layer and removes it from the cache if it does not match its digest.
+// The file is closed once the layer has been read, as tarball.Write does not close the layers it writes.
+type cacheReader struct {
+	f     *os.File
+	layer *layerDigest
+	done  bool
+}
+
+// Read implements io.Reader
+func (c *cacheReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	n, err := c.f.Read(p)
+	complete, valid := c.layer.add(p[:n], err)
+	if !complete {
+		return n, err
+	}
+	c.done = true
+	_ = c.f.Close()
+	if !valid {
+		_ = os.Remove(c.f.Name())
+		return n, fmt.Errorf("cached layer %s is corrupted and was removed, seal again to pull it", c.f.Name())
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (c *cacheReader) Close() error {
+	if c.done {
+		return nil
+	}
+	c.done = true
+	return c.f.Close()
+}
+
+// cacheWriter copies a layer into a temporary file of the cache while it is read.
+// If it is read completely and matches its digest, it is added to the cache right away, as tarball.Write does not
+// close the layers it writes.
+type cacheWriter struct {
+	r     io.Reader
+	rc    io.ReadCloser
+	tmp   *os.File
+	layer *layerDigest
+	path  string
+	cache *LayerCache
+	done  bool
+}
+
+// Read implements io.Reader
+func (c *cacheWriter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if complete, valid := c.layer.add(p[:n], err); complete && !c.done {
+		c.done = true
+		if valid {
+			c.commit()
+		} else {
+			c.discard()
+		}
+	}
+	return n, err
+}
+
+// commit moves the layer into the cache and evicts the least recently used ones
+func (c *cacheWriter) commit() {
+	if err := c.tmp.Close(); err != nil {
+		_ = os.Remove(c.tmp.Name())
+		return
+	}
+	if err := os.Rename(c.tmp.Name(), c.path); err != nil {
+		// Another seal may have added the layer meanwhile
+		_ = os.Remove(c.tmp.Name())
+		return
+	}
+	if err := c.cache.evict(); err != nil {
+		log.Warnf("failed evicting layers from cache %s: %v", c.cache.Dir, err)
+	}
+}
+
+// discard removes the temporary file of a layer not read completely
+func (c *cacheWriter) discard() {
+	_ = c.tmp.Close()
+	_ = os.Remove(c.tmp.Name())
+}
+
+// Close implements io.Closer
+func (c *cacheWriter) Close() error {
+	if !c.done {
+		c.done = true
+		c.discard()
+	}
+	return c.rc.Close()
+}
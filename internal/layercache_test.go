@@ -0,0 +1,127 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pullCounter counts how often the compressed layers of an image are read, like downloads from a registry
+type pullCounter struct {
+	v1.Image
+	pulls int
+}
+
+func (p *pullCounter) Layers() ([]v1.Layer, error) {
+	layers, err := p.Image.Layers()
+	for i, layer := range layers {
+		layers[i] = &countedLayer{Layer: layer, counter: p}
+	}
+	return layers, err
+}
+
+type countedLayer struct {
+	v1.Layer
+	counter *pullCounter
+}
+
+func (l *countedLayer) Compressed() (io.ReadCloser, error) {
+	l.counter.pulls++
+	return l.Layer.Compressed()
+}
+
+func TestLayerCache(t *testing.T) {
+	img, err := random.Image(1024, 3)
+	assert.NoError(t, err)
+	counter := &pullCounter{Image: img}
+	cache := &LayerCache{Dir: filepath.Join(t.TempDir(), "cache")}
+	dir := t.TempDir()
+
+	assert.NoError(t, crane.Save(cache.Image(counter), "alpine:3.17", filepath.Join(dir, "first.oci")))
+	assert.Equal(t, 3, counter.pulls)
+	entries, err := os.ReadDir(cache.Dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	assert.NoError(t, crane.Save(cache.Image(counter), "alpine:3.17", filepath.Join(dir, "second.oci")))
+	assert.Equal(t, 3, counter.pulls)
+	first, _ := os.ReadFile(filepath.Join(dir, "first.oci"))
+	second, _ := os.ReadFile(filepath.Join(dir, "second.oci"))
+	assert.Equal(t, first, second)
+}
+
+func TestLayerCache_Corrupted(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	cache := &LayerCache{Dir: t.TempDir()}
+	layers, _ := cache.Image(img).Layers()
+	rc, err := layers[0].Compressed()
+	assert.NoError(t, err)
+	_, err = io.Copy(io.Discard, rc)
+	assert.NoError(t, err)
+	assert.NoError(t, rc.Close())
+
+	digest, _ := layers[0].Digest()
+	path := cache.path(digest)
+	assert.NoError(t, os.WriteFile(path, []byte("tampered"), 0600))
+	rc, err = layers[0].Compressed()
+	assert.NoError(t, err)
+	_, err = io.Copy(io.Discard, rc)
+	assert.ErrorContains(t, err, "corrupted")
+	assert.NoError(t, rc.Close())
+	assert.NoFileExists(t, path)
+}
+
+func TestLayerCache_Incomplete(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	cache := &LayerCache{Dir: t.TempDir()}
+	layers, _ := cache.Image(img).Layers()
+	rc, err := layers[0].Compressed()
+	assert.NoError(t, err)
+	_, err = rc.Read(make([]byte, 10))
+	assert.NoError(t, err)
+	assert.NoError(t, rc.Close())
+	entries, err := os.ReadDir(cache.Dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestLayerCache_Evict(t *testing.T) {
+	cache := &LayerCache{Dir: t.TempDir(), MaxSize: 250}
+	old := time.Now().Add(-time.Hour)
+	for i, name := range []string{"sha256-a", "sha256-b", "sha256-c", "unrelated"} {
+		path := filepath.Join(cache.Dir, name)
+		assert.NoError(t, os.WriteFile(path, make([]byte, 100), 0600))
+		modified := old.Add(time.Duration(i) * time.Minute)
+		assert.NoError(t, os.Chtimes(path, modified, modified))
+	}
+	// Reading a layer marks it as recently used
+	modified := time.Now()
+	assert.NoError(t, os.Chtimes(filepath.Join(cache.Dir, "sha256-a"), modified, modified))
+
+	assert.NoError(t, cache.evict())
+	assert.FileExists(t, filepath.Join(cache.Dir, "sha256-a"))
+	assert.NoFileExists(t, filepath.Join(cache.Dir, "sha256-b"))
+	assert.FileExists(t, filepath.Join(cache.Dir, "sha256-c"))
+	assert.FileExists(t, filepath.Join(cache.Dir, "unrelated"))
+}
@@ -0,0 +1,241 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// LayersPrefix is the area of the archive holding the layers of images stored with DedupLayers, each stored once
+	LayersPrefix = ".layers"
+	// paxLayersSize holds the real size of an image archive stored without its layers
+	paxLayersSize = "SEALPACK.layers.size"
+	// paxLayersMap lists the layers of an image archive stored in the layers area as comma-separated names
+	paxLayersMap = "SEALPACK.layers.map"
+)
+
+// layerNamePattern matches the layer entries of image archives, named by the digest of the layer
+var layerNamePattern = regexp.MustCompile(`^[0-9a-f]{64}\.tar(\.gz)?$`)
+
+// writeLayered adds a normalized image archive without its layers, which are stored in the layers area
+// unless a previous image stored them already. It reports false if the image cannot be stored this way.
+func (arc *WriteArchive) writeLayered(header *tar.Header, contents *os.File) (bool, error) {
+	entries, normalized, err := readImageArchive(contents)
+	if err != nil || !normalized {
+		// Images are hashed as stored, which only normalized archives can be reassembled to
+		return false, nil
+	}
+	var layers []string
+	thin := &bytes.Buffer{}
+	tw := tar.NewWriter(thin)
+	for _, e := range entries {
+		data := io.NewSectionReader(contents, e.offset, e.size)
+		if !layerNamePattern.MatchString(e.name) {
+			if err = writeImageEntry(tw, e.name, e.size, data); err != nil {
+				return true, err
+			}
+			continue
+		}
+		layers = append(layers, e.name)
+		if err = arc.writeLayer(e.name, e.size, data); err != nil {
+			return true, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return true, err
+	}
+	if header.PAXRecords == nil {
+		header.PAXRecords = map[string]string{}
+	}
+	header.PAXRecords[paxLayersSize] = strconv.FormatInt(header.Size, 10)
+	header.PAXRecords[paxLayersMap] = strings.Join(layers, ",")
+	header.Size = int64(thin.Len())
+	if err = arc.beginEntry(header.Name); err != nil {
+		return true, err
+	}
+	if err = arc.tarWriter.WriteHeader(header); err != nil {
+		return true, fmt.Errorf("cannot add %s to archive: %w", header.Name, err)
+	}
+	if _, err = io.Copy(arc.tarWriter, thin); err != nil {
+		return true, fileSizeError(header.Name, err)
+	}
+	return true, arc.tarWriter.Flush()
+}
+
+// writeLayer adds a layer to the layers area, unless it has been added before
+func (arc *WriteArchive) writeLayer(name string, size int64, data io.Reader) error {
+	if arc.layers[name] {
+		return nil
+	}
+	if arc.layers == nil {
+		arc.layers = map[string]bool{}
+	}
+	entry := path.Join(LayersPrefix, name)
+	if err := arc.beginEntry(entry); err != nil {
+		return err
+	}
+	if err := arc.tarWriter.WriteHeader(&tar.Header{Name: entry, Size: size, Mode: 0644, Format: tar.FormatPAX}); err != nil {
+		return fmt.Errorf("cannot add %s to archive: %w", entry, err)
+	}
+	if _, err := io.CopyN(arc.tarWriter, data, size); err != nil {
+		return fileSizeError(entry, err)
+	}
+	arc.layers[name] = true
+	return arc.tarWriter.Flush()
+}
+
+// isLayeredEntry checks if an archive entry contains an image archive stored without its layers
+func isLayeredEntry(h *tar.Header) bool {
+	_, ok := h.PAXRecords[paxLayersMap]
+	return ok
+}
+
+// isLayerEntry checks if an archive entry is a layer in the layers area
+func isLayerEntry(h *tar.Header) bool {
+	return strings.HasPrefix(h.Name, LayersPrefix+"/")
+}
+
+// cacheLayer keeps a layer of the layers area on disk, so the images referencing it can be reassembled.
+// Layers are not part of the TOC, they are verified with the images they are reassembled to.
+func (arc *ReadArchive) cacheLayer(h *tar.Header) error {
+	name := strings.TrimPrefix(h.Name, LayersPrefix+"/")
+	if !layerNamePattern.MatchString(name) {
+		return corruptEnvelope(fmt.Errorf("invalid layer %s", h.Name))
+	}
+	if arc.layers == nil {
+		var err error
+		if arc.layers, err = newChunkCache(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(filepath.Join(arc.layers.dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	// Registered first, so a partially written layer is wiped as well
+	arc.layers.stored[name] = true
+	_, err = io.Copy(f, arc.TarReader)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newLayeredReader reassembles the image archive of a layered entry from its entries and the cached layers
+func (arc *ReadArchive) newLayeredReader(h *tar.Header) (io.Reader, error) {
+	size, err := strconv.ParseInt(h.PAXRecords[paxLayersSize], 10, 64)
+	if err != nil {
+		return nil, corruptEnvelope(fmt.Errorf("invalid layers size of %s: %v", h.Name, err))
+	}
+	type source struct {
+		name string
+		data []byte
+	}
+	var sources []source
+	thin := tar.NewReader(arc.TarReader)
+	for {
+		th, err := thin.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, corruptEnvelope(fmt.Errorf("invalid image archive %s: %w", h.Name, err))
+		}
+		data, err := io.ReadAll(thin)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source{name: th.Name, data: data})
+	}
+	for _, layer := range strings.Split(h.PAXRecords[paxLayersMap], ",") {
+		if arc.layers == nil || !arc.layers.stored[layer] {
+			return nil, corruptEnvelope(fmt.Errorf("layer %s of %s is missing", layer, h.Name))
+		}
+		sources = append(sources, source{name: layer})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].name < sources[j].name })
+	pr, pw := io.Pipe()
+	arc.closeLayered()
+	arc.assembling = pr
+	go func() {
+		tw := tar.NewWriter(pw)
+		var err error
+		for _, s := range sources {
+			if s.data != nil {
+				err = writeImageEntry(tw, s.name, int64(len(s.data)), bytes.NewReader(s.data))
+			} else {
+				err = arc.writeCachedLayer(tw, s.name)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return &sizeCheckReader{name: h.Name, r: pr, remaining: size}, nil
+}
+
+// closeLayered stops reassembling an image archive, which has not been read completely
+func (arc *ReadArchive) closeLayered() {
+	if arc.assembling != nil {
+		_ = arc.assembling.CloseWithError(fmt.Errorf("unseal: image archive has not been read completely"))
+		arc.assembling = nil
+	}
+}
+
+// sizeCheckReader fails if a reassembled image archive does not have the size it was sealed with
+type sizeCheckReader struct {
+	name      string
+	r         io.Reader
+	remaining int64
+}
+
+// Read implements io.Reader
+func (s *sizeCheckReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.remaining -= int64(n)
+	if s.remaining < 0 || (err == io.EOF && s.remaining != 0) {
+		return n, corruptEnvelope(fmt.Errorf("reassembled image %s does not match its size", s.name))
+	}
+	return n, err
+}
+
+// writeCachedLayer adds a cached layer to a reassembled image archive
+func (arc *ReadArchive) writeCachedLayer(tw *tar.Writer, name string) error {
+	f, err := os.Open(filepath.Join(arc.layers.dir, name))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeImageEntry(tw, name, info.Size(), f)
+}
@@ -0,0 +1,122 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// saveNormalizedImage writes an image archive like SaveImage does
+func saveNormalizedImage(t *testing.T, img v1.Image, ref string) *os.File {
+	tag, err := name.NewTag(ref)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "image.tar")
+	assert.NoError(t, tarball.WriteToFile(path, tag, img))
+	assert.NoError(t, normalizeImageArchive(path))
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	return f
+}
+
+func TestWriteArchive_DedupLayers(t *testing.T) {
+	base, err := random.Image(1024, 2)
+	assert.NoError(t, err)
+	extra, err := random.Layer(1024, "application/vnd.docker.image.rootfs.diff.tar.gzip")
+	assert.NoError(t, err)
+	derived, err := mutate.AppendLayers(base, extra)
+	assert.NoError(t, err)
+
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.DedupLayers = true
+	sig := NewSignatureList("SHA256")
+	for ref, img := range map[string]v1.Image{"registry.example.com/base:1.0": base, "registry.example.com/app:1.0": derived} {
+		f := saveNormalizedImage(t, img, ref)
+		assert.NoError(t, arc.storeContents(f, ParseContainerImage(ref).ToFileName(), ref, 0, nil, sig))
+	}
+	// Not normalized, so stored as it is
+	plain := filepath.Join(t.TempDir(), "plain.tar")
+	tag, _ := name.NewTag("registry.example.com/plain:1.0")
+	assert.NoError(t, tarball.WriteToFile(plain, tag, base))
+	f, err := os.Open(plain)
+	assert.NoError(t, err)
+	assert.NoError(t, arc.storeContents(f, ParseContainerImage("registry.example.com/plain:1.0").ToFileName(), "plain", 0, nil, sig))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err = arc.Finalize()
+	assert.NoError(t, err)
+
+	ra := openTestArchive(t, arc)
+	layers, layered := 0, 0
+	for h, err := ra.TarReader.Next(); err == nil; h, err = ra.TarReader.Next() {
+		if isLayerEntry(h) {
+			layers++
+		}
+		if isLayeredEntry(h) {
+			layered++
+		}
+	}
+	// Three distinct layers, the base layers are only stored once
+	assert.Equal(t, 3, layers)
+	assert.Equal(t, 2, layered)
+
+	// Verifying the TOC requires reassembling the images exactly as they were hashed
+	ra = openTestArchive(t, arc)
+	ra.DryRun = true
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", t.TempDir(), "", ""))
+	headers, err := openTestArchive(t, arc).List()
+	assert.NoError(t, err)
+	for _, h := range headers {
+		assert.False(t, strings.HasPrefix(h.Name, LayersPrefix), h.Name)
+	}
+	assert.Len(t, headers, 3)
+}
+
+func TestReadArchive_LayeredReaderMissingLayer(t *testing.T) {
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.DedupLayers = true
+	img, err := random.Image(256, 1)
+	assert.NoError(t, err)
+	f := saveNormalizedImage(t, img, "registry.example.com/app:1.0")
+	// Pretend the layer has been stored by a previous image
+	entries, _, err := readImageArchive(f)
+	assert.NoError(t, err)
+	arc.layers = map[string]bool{}
+	for _, e := range entries {
+		if layerNamePattern.MatchString(e.name) {
+			arc.layers[e.name] = true
+		}
+	}
+	assert.NoError(t, arc.storeContents(f, ParseContainerImage("registry.example.com/app:1.0").ToFileName(), "app", 0, nil, NewSignatureList("SHA256")))
+	_, err = arc.Finalize()
+	assert.NoError(t, err)
+
+	ra := openTestArchive(t, arc)
+	defer ra.removeChunks()
+	h, err := ra.TarReader.Next()
+	assert.NoError(t, err)
+	assert.True(t, isLayeredEntry(h))
+	_, err = ra.contentReader(h)
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+}
@@ -0,0 +1,63 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"fmt"
+)
+
+// UnpackLimits restrict the contents extracted from an archive, protecting against decompression bombs.
+// Zero values disable the respective limit.
+type UnpackLimits struct {
+	// MaxTotalSize is the maximum number of bytes of all entries together
+	MaxTotalSize int64
+	// MaxFileSize is the maximum number of bytes of a single entry
+	MaxFileSize int64
+	// MaxEntries is the maximum number of entries
+	MaxEntries int
+}
+
+// Validate checks the limits for negative values
+func (l UnpackLimits) Validate() error {
+	if l.MaxTotalSize < 0 || l.MaxFileSize < 0 || l.MaxEntries < 0 {
+		return fmt.Errorf("unpack limits must not be negative")
+	}
+	return nil
+}
+
+// limitCounter accounts the entries of an archive against UnpackLimits
+type limitCounter struct {
+	limits    UnpackLimits
+	entries   int
+	totalSize int64
+}
+
+// add accounts an entry by its header before any of its contents are read.
+// The size in the header is reliable, as the tar reader never provides more contents than that.
+func (c *limitCounter) add(h *tar.Header) error {
+	size := contentSize(h)
+	c.entries++
+	c.totalSize += size
+	switch {
+	case c.limits.MaxEntries > 0 && c.entries > c.limits.MaxEntries:
+		return fmt.Errorf("%w: archive contains more than %d entries", ErrLimitExceeded, c.limits.MaxEntries)
+	case c.limits.MaxFileSize > 0 && size > c.limits.MaxFileSize:
+		return fmt.Errorf("%w: %s has %d bytes, more than the maximum of %d", ErrLimitExceeded, h.Name, size, c.limits.MaxFileSize)
+	case c.limits.MaxTotalSize > 0 && c.totalSize > c.limits.MaxTotalSize:
+		return fmt.Errorf("%w: archive contents exceed the maximum of %d bytes at %s", ErrLimitExceeded, c.limits.MaxTotalSize, h.Name)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLimitCounter(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits UnpackLimits
+		sizes  []int64
+		errMsg string
+	}{
+		{"unlimited", UnpackLimits{}, []int64{1 << 40, 1 << 40, 1 << 40}, ""},
+		{"within limits", UnpackLimits{MaxTotalSize: 30, MaxFileSize: 10, MaxEntries: 3}, []int64{10, 10, 10}, ""},
+		{"too many entries", UnpackLimits{MaxEntries: 2}, []int64{1, 1, 1}, "more than 2 entries"},
+		{"file too large", UnpackLimits{MaxFileSize: 10}, []int64{10, 11}, "entry-1 has 11 bytes, more than the maximum of 10"},
+		{"total too large", UnpackLimits{MaxTotalSize: 25}, []int64{10, 10, 10}, "exceed the maximum of 25 bytes at entry-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := &limitCounter{limits: tt.limits}
+			var err error
+			for i, size := range tt.sizes {
+				if err = counter.add(&tar.Header{Name: "entry-" + string(rune('0'+i)), Size: size}); err != nil {
+					break
+				}
+			}
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrLimitExceeded)
+				assert.ErrorContains(t, err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestLimitCounter_SparseSize(t *testing.T) {
+	// Sparse files are accounted with their expanded size
+	counter := &limitCounter{limits: UnpackLimits{MaxFileSize: 100}}
+	h := &tar.Header{Name: "disk.img", Size: 10, PAXRecords: map[string]string{paxSparseSize: "4096", paxSparseMap: "0,10"}}
+	assert.ErrorIs(t, counter.add(h), ErrLimitExceeded)
+}
+
+func TestUnpackLimits_Validate(t *testing.T) {
+	assert.NoError(t, UnpackLimits{}.Validate())
+	assert.Error(t, UnpackLimits{MaxEntries: -1}.Validate())
+	assert.Error(t, UnpackLimits{MaxTotalSize: -1}.Validate())
+}
+
+func TestReadArchive_UnpackLimits(t *testing.T) {
+	// Highly compressible contents, as found in decompression bombs
+	contents := bytes.Repeat([]byte{0}, 1<<20)
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	assert.NoError(t, arc.AddToArchive("zeros", contents))
+	assert.NoError(t, sig.AddFile("zeros", contents))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+
+	out := t.TempDir()
+	ra := openTestArchive(t, arc)
+	ra.Limits = UnpackLimits{MaxFileSize: 1 << 19}
+	assert.ErrorIs(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""), ErrLimitExceeded)
+	_, err = os.Stat(filepath.Join(out, "zeros"))
+	assert.True(t, os.IsNotExist(err))
+
+	ra = openTestArchive(t, arc)
+	ra.Limits = UnpackLimits{MaxFileSize: 1 << 20, MaxEntries: 3}
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""))
+}
@@ -0,0 +1,169 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockRetryInterval is the time between attempts to acquire a lock held by another process
+const lockRetryInterval = 200 * time.Millisecond
+
+// errWouldBlock is returned by tryLock if the lock is held by another process
+var errWouldBlock = errors.New("lock is held")
+
+// LockOptions define how to handle locks held by another process
+type LockOptions struct {
+	// NoWait fails immediately with ErrLocked instead of waiting for the lock
+	NoWait bool
+	// Timeout limits how long to wait for the lock, 0 waits indefinitely
+	Timeout time.Duration
+	// Logger receives a message when waiting for a lock, the global logger if nil
+	Logger log.Interface
+}
+
+// A Lock is an advisory lock held by this process, protecting a resource from concurrent unseals
+type Lock struct {
+	files []*os.File
+}
+
+// OutputLockName is the name of the lock protecting an output path. Paths are resolved, so all
+// ways of referring to the same directory share one lock.
+func OutputLockName(outputPath string) (string, error) {
+	return pathLockName("output", outputPath)
+}
+
+// pathLockName names the lock of a path by a hash of its absolute form
+func pathLockName(kind, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return kind + "-" + hex.EncodeToString(sum[:8]), nil
+}
+
+// NamespaceLockName is the name of the lock protecting image imports into a containerD namespace
+func NamespaceLockName(namespace string) string {
+	return "containerd-" + strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(namespace)
+}
+
+// lockDir is the directory lock files are kept in, only accessible by the user running sealpack
+var lockDir = defaultLockDir()
+
+// lockPath provides the path of the lock file of a name, creating the lock directory if needed.
+// The directory must be owned by the current user and not be writable by others, so no other user can replace
+// lock files with links or hold the locks.
+func lockPath(name string) (string, error) {
+	if err := os.MkdirAll(lockDir, 0700); err != nil {
+		return "", fmt.Errorf("cannot create lock directory %s: %w", lockDir, err)
+	}
+	if err := checkLockDir(lockDir); err != nil {
+		return "", WithHint(err, "remove %s; sealpack creates it only accessible by the user unsealing", lockDir)
+	}
+	return filepath.Join(lockDir, "sealpack-"+name+".lock"), nil
+}
+
+// AcquireLock acquires the locks of all names in order. The lock files are kept in a directory of the user
+// outside the output path, so removing an output path during a rollback does not remove its lock.
+func AcquireLock(options LockOptions, names ...string) (*Lock, error) {
+	lock := &Lock{}
+	for _, name := range names {
+		path, err := lockPath(name)
+		if err != nil {
+			_ = lock.Release()
+			return nil, err
+		}
+		f, err := acquireLockFile(path, options)
+		if err != nil {
+			_ = lock.Release()
+			return nil, err
+		}
+		lock.files = append(lock.files, f)
+	}
+	return lock, nil
+}
+
+// acquireLockFile opens a lock file and locks it, waiting as defined by the options.
+// The process holding the lock writes its PID to the file, so waiting processes can name it.
+func acquireLockFile(path string, options LockOptions) (*os.File, error) {
+	f, err := openLockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var deadline time.Time
+	if options.Timeout > 0 {
+		deadline = time.Now().Add(options.Timeout)
+	}
+	for waiting := false; ; waiting = true {
+		if err = tryLock(f); err == nil {
+			break
+		}
+		if !errors.Is(err, errWouldBlock) {
+			_ = f.Close()
+			return nil, err
+		}
+		holder := lockHolder(path)
+		if options.NoWait || !deadline.IsZero() && time.Now().After(deadline) {
+			_ = f.Close()
+			return nil, WithHint(fmt.Errorf("%w: %s is held by %s", ErrLocked, path, holder),
+				"wait for the other unseal to finish; remove the lock file only if no sealpack process is running")
+		}
+		if !waiting {
+			LoggerOrDefault(options.Logger).Infof("unseal: waiting for %s held by %s", path, holder)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// lockHolder describes the process holding a lock from the PID in its lock file
+func lockHolder(path string) string {
+	pid, err := os.ReadFile(path)
+	if err != nil || len(pid) == 0 {
+		return "another process"
+	}
+	return "process " + string(pid)
+}
+
+// Release releases all locks. The lock files are kept, as removing them could let two processes lock different files.
+// Releasing a nil Lock has no effect.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	var errs []error
+	for _, f := range l.files {
+		errs = append(errs, f.Close())
+	}
+	l.files = nil
+	return errors.Join(errs...)
+}
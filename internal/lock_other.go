@@ -0,0 +1,97 @@
+//go:build !windows
+
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// defaultLockDir keeps the locks of root in /run/lock and those of other users in a directory of their own
+func defaultLockDir() string {
+	if info, err := os.Stat("/run/lock"); err == nil && info.IsDir() && os.Geteuid() == 0 {
+		return "/run/lock/sealpack"
+	}
+	return filepath.Join(os.TempDir(), "sealpack-"+strconv.Itoa(os.Geteuid()))
+}
+
+// checkLockDir makes sure the lock directory is no link, is owned by the current user and not writable by others
+func checkLockDir(dir string) error {
+	var st unix.Stat_t
+	if err := unix.Lstat(dir, &st); err != nil {
+		return err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		return fmt.Errorf("lock directory %s is not a directory", dir)
+	}
+	if int(st.Uid) != os.Geteuid() || st.Mode&0022 != 0 {
+		return fmt.Errorf("lock directory %s is owned by user %d or writable by others", dir, st.Uid)
+	}
+	return nil
+}
+
+// openLockFile creates a lock file exclusively or opens the existing one, never following links.
+// Existing lock files must be regular files owned by the current user.
+func openLockFile(path string) (*os.File, error) {
+	for {
+		fd, err := unix.Open(path, unix.O_RDWR|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0600)
+		if errors.Is(err, unix.EEXIST) {
+			fd, err = unix.Open(path, unix.O_RDWR|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+			if errors.Is(err, unix.ENOENT) {
+				// Removed in between, create it again
+				continue
+			}
+		}
+		if errors.Is(err, unix.ELOOP) {
+			return nil, fmt.Errorf("lock file %s is a symbolic link", path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var st unix.Stat_t
+		if err = unix.Fstat(fd, &st); err == nil && (st.Mode&unix.S_IFMT != unix.S_IFREG || int(st.Uid) != os.Geteuid()) {
+			err = fmt.Errorf("lock file %s is no regular file owned by user %d", path, os.Geteuid())
+		}
+		if err != nil {
+			_ = unix.Close(fd)
+			return nil, err
+		}
+		return os.NewFile(uintptr(fd), path), nil
+	}
+}
+
+// tryLock acquires an exclusive advisory lock on a file without blocking
+func tryLock(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return errWouldBlock
+	}
+	return err
+}
+
+// lockExclusive acquires an exclusive advisory lock on a file, waiting for other holders; closing the file releases it
+func lockExclusive(f *os.File) error {
+	for {
+		if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); !errors.Is(err, unix.EINTR) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testLockName provides the lock name of a new output path, removing its lock file after the test
+func testLockName(t *testing.T) string {
+	name, err := OutputLockName(t.TempDir())
+	assert.NoError(t, err)
+	path, err := lockPath(name)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = os.Remove(path) })
+	return name
+}
+
+func TestOutputLockName(t *testing.T) {
+	dir := t.TempDir()
+	name, err := OutputLockName(dir)
+	assert.NoError(t, err)
+	other, err := OutputLockName(filepath.Join(dir, "sub", ".."))
+	assert.NoError(t, err)
+	assert.Equal(t, name, other)
+	other, err = OutputLockName(filepath.Join(dir, "sub"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, name, other)
+	assert.Equal(t, "containerd-k8s.io", NamespaceLockName("k8s.io"))
+}
+
+func TestAcquireLock(t *testing.T) {
+	name := testLockName(t)
+	lock, err := AcquireLock(LockOptions{}, name)
+	assert.NoError(t, err)
+	path, err := lockPath(name)
+	assert.NoError(t, err)
+	pid, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid()), string(pid))
+
+	_, err = AcquireLock(LockOptions{NoWait: true}, name)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.ErrorContains(t, err, "held by process "+strconv.Itoa(os.Getpid()))
+	assert.NotEmpty(t, Hints(err))
+
+	started := time.Now()
+	_, err = AcquireLock(LockOptions{Timeout: 300 * time.Millisecond}, name)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.GreaterOrEqual(t, time.Since(started), 300*time.Millisecond)
+
+	// Waiting succeeds as soon as the lock is released
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = lock.Release()
+	}()
+	next, err := AcquireLock(LockOptions{}, name)
+	assert.NoError(t, err)
+	assert.NoError(t, next.Release())
+	assert.NoError(t, (*Lock)(nil).Release())
+}
+
+func TestAcquireLock_ReleasesOnFailure(t *testing.T) {
+	first, second := testLockName(t), testLockName(t)
+	held, err := AcquireLock(LockOptions{}, second)
+	assert.NoError(t, err)
+	defer held.Release()
+
+	_, err = AcquireLock(LockOptions{NoWait: true}, first, second)
+	assert.ErrorIs(t, err, ErrLocked)
+	// The first lock was released when the second could not be acquired
+	lock, err := AcquireLock(LockOptions{NoWait: true}, first)
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Release())
+}
+
+func TestAcquireLock_RejectsForeignFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("lock directories are protected by the ACLs of the temporary directory")
+	}
+	defer func(dir string) { lockDir = dir }(lockDir)
+	lockDir = filepath.Join(t.TempDir(), "locks")
+
+	// Links planted as lock files are never followed, so their targets are not truncated
+	target := filepath.Join(t.TempDir(), "shadow")
+	assert.NoError(t, os.WriteFile(target, []byte("keep"), 0600))
+	name := testLockName(t)
+	path, err := lockPath(name)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Symlink(target, path))
+	_, err = AcquireLock(LockOptions{NoWait: true}, name)
+	assert.ErrorContains(t, err, "symbolic link")
+	contents, err := os.ReadFile(target)
+	assert.NoError(t, err)
+	assert.Equal(t, "keep", string(contents))
+
+	// Lock directories writable by others are refused
+	assert.NoError(t, os.Chmod(lockDir, 0777))
+	_, err = AcquireLock(LockOptions{NoWait: true}, name+"-other")
+	assert.ErrorContains(t, err, "writable by others")
+	assert.NotEmpty(t, Hints(err))
+}
@@ -0,0 +1,64 @@
+//go:build windows
+
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"os"
+	"path/filepath"
+)
+
+// defaultLockDir keeps the locks in the temporary directory of the user, which other users cannot access
+func defaultLockDir() string {
+	return filepath.Join(os.TempDir(), "sealpack")
+}
+
+// checkLockDir makes sure the lock directory is no link; access is restricted by the ACLs of the temporary directory
+func checkLockDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("lock directory %s is not a directory", dir)
+	}
+	return nil
+}
+
+// openLockFile opens or creates a lock file, refusing links
+func openLockFile(path string) (*os.File, error) {
+	if info, err := os.Lstat(path); err == nil && !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("lock file %s is no regular file", path)
+	}
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+}
+
+// tryLock acquires an exclusive lock on the first byte of a file without blocking
+func tryLock(f *os.File) error {
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &windows.Overlapped{})
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errWouldBlock
+	}
+	return err
+}
+
+// lockExclusive acquires an exclusive lock on the first byte of a file, waiting for other holders; closing the file releases it
+func lockExclusive(f *os.File) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &windows.Overlapped{})
+}
@@ -0,0 +1,42 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/apex/log"
+)
+
+// LoggerOrDefault provides the logger, or the global apex/log logger with its configured handler if it is nil
+func LoggerOrDefault(logger log.Interface) log.Interface {
+	if logger == nil {
+		return log.Log
+	}
+	return logger
+}
+
+// logger provides the logger of the archive
+func (arc *WriteArchive) logger() log.Interface {
+	return LoggerOrDefault(arc.Logger)
+}
+
+// logger provides the logger of the archive
+func (arc *ReadArchive) logger() log.Interface {
+	return LoggerOrDefault(arc.Logger)
+}
+
+// logger provides the logger of the verifier
+func (v *Verifier) logger() log.Interface {
+	return LoggerOrDefault(v.Logger)
+}
@@ -0,0 +1,38 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLoggerOrDefault(t *testing.T) {
+	assert.Equal(t, log.Log, LoggerOrDefault(nil))
+	logger := &log.Logger{Handler: memory.New(), Level: log.DebugLevel}
+	assert.Equal(t, logger, LoggerOrDefault(logger))
+}
+
+func TestOpenArchiveReaderWithLogger(t *testing.T) {
+	handler := memory.New()
+	logger := &log.Logger{Handler: handler, Level: log.InfoLevel}
+	_, err := OpenArchiveReaderWithLogger(bytes.NewReader([]byte("definitely not compressed")), GetCompressionAlgoIndex(CompressionGzip), logger)
+	assert.Error(t, err)
+	assert.Len(t, handler.Entries, 1)
+	assert.Contains(t, handler.Entries[0].Message, "payload does not look gzip compressed")
+}
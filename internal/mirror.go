@@ -0,0 +1,247 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/innomotics/sealpack/internal/aws"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// mirrorBufferSize is the number of bytes read from the source at once
+const mirrorBufferSize = 8 << 20
+
+var s3UploadStream = aws.S3UploadStream
+
+// errNoPackage marks files skipped when mirroring collections
+var errNoPackage = errors.New("not a sealpack package")
+
+// MirroredPackage is a package copied by Mirror
+type MirroredPackage struct {
+	Source string
+	Target string
+	Size   int64
+}
+
+// Mirror copies packages from the source to the target, both local paths, s3:// or sftp:// URIs.
+// Single packages may also be read from http:// and https:// URLs, sending the header with every request.
+// Local directories and URIs ending with / are mirrored with all packages below them, other files are skipped.
+// Packages are streamed and verified against their checksum trailer, a target is only created if it is intact.
+func Mirror(source, target string, header http.Header) ([]MirroredPackage, error) {
+	if !isCollection(source) {
+		if isCollection(target) {
+			target = joinLocation(target, path.Base(strings.SplitN(source, "?", 2)[0]))
+		}
+		size, err := mirrorPackage(source, target, header)
+		if errors.Is(err, errNoPackage) {
+			err = corruptEnvelope(fmt.Errorf("%s is %w", source, errNoPackage))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []MirroredPackage{{Source: source, Target: target, Size: size}}, nil
+	}
+	if isHTTP(source) {
+		return nil, fmt.Errorf("cannot list the packages of %s, mirror single packages from HTTP servers", source)
+	}
+	files, err := listPackages(source)
+	if err != nil {
+		return nil, err
+	}
+	var mirrored []MirroredPackage
+	for _, file := range files {
+		dst := joinLocation(target, file.rel)
+		size, err := mirrorPackage(file.location, dst, header)
+		if errors.Is(err, errNoPackage) {
+			continue
+		}
+		if err != nil {
+			return mirrored, fmt.Errorf("cannot mirror %s: %w", file.location, err)
+		}
+		mirrored = append(mirrored, MirroredPackage{Source: file.location, Target: dst, Size: size})
+	}
+	return mirrored, nil
+}
+
+// isHTTP determines whether a location is an http:// or https:// URL
+func isHTTP(location string) bool {
+	lower := strings.ToLower(location)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// isCollection determines whether a location is a local directory or a remote URI ending with /
+func isCollection(location string) bool {
+	if strings.Contains(location, "://") {
+		return strings.HasSuffix(location, "/")
+	}
+	info, err := os.Stat(location)
+	return err == nil && info.IsDir()
+}
+
+// joinLocation appends a slash-separated relative path to a local directory or remote URI
+func joinLocation(base, rel string) string {
+	if strings.Contains(base, "://") {
+		return strings.TrimSuffix(base, "/") + "/" + rel
+	}
+	return filepath.Join(base, filepath.FromSlash(rel))
+}
+
+// mirrorPackage copies a single package, errNoPackage if the source is no package
+func mirrorPackage(source, target string, header http.Header) (int64, error) {
+	src, err := OpenRangeSource(source, header)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	if ok, err := isPackage(src); err != nil || !ok {
+		if err == nil {
+			err = errNoPackage
+		}
+		return 0, err
+	}
+	pkg := io.NewSectionReader(src, 0, src.Size())
+	envelope, err := ParseEnvelopeUnverified(pkg)
+	if err != nil {
+		return 0, err
+	}
+	if !envelope.HasTrailer() {
+		return 0, WithHint(fmt.Errorf("%s has no checksum trailer, its integrity cannot be verified while mirroring", source),
+			"reseal the package with a current version of sealpack")
+	}
+	trailer, err := readTrailer(pkg)
+	if err != nil {
+		return 0, corruptEnvelope(err)
+	}
+	contents := &verifyingReader{
+		r:        bufio.NewReaderSize(io.NewSectionReader(src, 0, trailer.offset), mirrorBufferSize),
+		sums:     newChecksumWriter(io.Discard, trailer.chunkSize),
+		expected: trailer,
+	}
+	// The trailer itself is copied as is, it was checked while parsing
+	r := io.MultiReader(contents, io.NewSectionReader(src, trailer.offset, src.Size()-trailer.offset))
+	if err = writeTarget(target, r); err != nil {
+		return 0, err
+	}
+	return src.Size(), nil
+}
+
+// verifyingReader checks the contents read against a checksum trailer, failing at the first corrupted chunk
+type verifyingReader struct {
+	r        io.Reader
+	sums     *checksumWriter
+	expected *checksumTrailer
+	checked  int
+}
+
+// Read implements io.Reader
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	_, _ = v.sums.Write(p[:n])
+	for ; v.checked < len(v.sums.chunks); v.checked++ {
+		if v.checked >= len(v.expected.chunks) || v.sums.chunks[v.checked] != v.expected.chunks[v.checked] {
+			return 0, corruptEnvelope(v.expected.chunkError(v.checked))
+		}
+	}
+	if err == io.EOF {
+		actual := v.sums.Trailer()
+		if len(actual.chunks) != len(v.expected.chunks) || actual.offset != v.expected.offset {
+			return 0, corruptEnvelope(fmt.Errorf("file truncated: expected %d bytes, got %d", v.expected.offset, actual.offset))
+		}
+		if last := len(actual.chunks) - 1; last >= v.checked && actual.chunks[last] != v.expected.chunks[last] {
+			return 0, corruptEnvelope(v.expected.chunkError(last))
+		}
+		if !bytes.Equal(actual.digest, v.expected.digest) {
+			return 0, corruptEnvelope(fmt.Errorf("file corrupted: SHA-256 checksum does not match"))
+		}
+	}
+	return n, err
+}
+
+// writeTarget writes a stream to a local file, S3 or an SFTP server. Partial targets are removed if copying fails.
+func writeTarget(target string, r io.Reader) error {
+	lower := strings.ToLower(target)
+	switch {
+	case strings.HasPrefix(lower, aws.S3UriPrefix):
+		return wrapNetworkError(s3UploadStream(r, target))
+	case strings.HasPrefix(lower, SFTPUriPrefix):
+		return writeSFTP(target, r)
+	case strings.Contains(target, "://"):
+		return fmt.Errorf("cannot mirror to %s, use a local path, s3:// or sftp:// target", target)
+	}
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(target)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), target)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// writeSFTP writes a stream to a temporary file on an SFTP server and renames it to the target when complete
+func writeSFTP(target string, r io.Reader) error {
+	client, p, err := dialSFTP(target)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err = client.MkdirAll(path.Dir(p)); err != nil {
+		return err
+	}
+	part := p + ".part"
+	f, err := client.Create(part)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// Version 3 of the protocol cannot rename onto existing files
+		if err = client.Remove(p); errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	}
+	if err == nil {
+		err = client.Rename(part, p)
+	}
+	if err != nil {
+		_ = client.Remove(part)
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"github.com/innomotics/sealpack/internal/aws"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+	repo := t.TempDir()
+	copyGolden(t, "v5-sealed", filepath.Join(repo, "app-1.2.0.ipc"))
+	copyGolden(t, "public", filepath.Join(repo, "tools", "debug-0.1.ipc"))
+	assert.NoError(t, os.WriteFile(filepath.Join(repo, "README.txt"), []byte("no package"), 0644))
+
+	target := filepath.Join(t.TempDir(), "mirror")
+	mirrored, err := Mirror(repo, target, nil)
+	assert.NoError(t, err)
+	assert.Len(t, mirrored, 2)
+	for fixture, rel := range map[string]string{"v5-sealed": "app-1.2.0.ipc", "public": "tools/debug-0.1.ipc"} {
+		expected, err := os.ReadFile(goldenPackage(fixture))
+		assert.NoError(t, err)
+		actual, err := os.ReadFile(filepath.Join(target, filepath.FromSlash(rel)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+	_, err = os.Stat(filepath.Join(target, "README.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Single packages are copied into target directories
+	mirrored, err = Mirror(filepath.Join(repo, "app-1.2.0.ipc"), filepath.Join(target, "tools"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(target, "tools", "app-1.2.0.ipc"), mirrored[0].Target)
+	_, err = Mirror(filepath.Join(repo, "README.txt"), target, nil)
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+}
+
+func TestMirror_Corrupted(t *testing.T) {
+	data, err := os.ReadFile(goldenPackage("v5-sealed"))
+	assert.NoError(t, err)
+	src := filepath.Join(t.TempDir(), "app.ipc")
+	raw, err := os.Open(goldenPackage("v5-sealed"))
+	assert.NoError(t, err)
+	defer raw.Close()
+	trailer, err := readTrailer(raw)
+	assert.NoError(t, err)
+	data[trailer.offset-10] ^= 0xff
+	assert.NoError(t, os.WriteFile(src, data, 0644))
+
+	target := filepath.Join(t.TempDir(), "app.ipc")
+	_, err = Mirror(src, target, nil)
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+	assert.Contains(t, err.Error(), "file corrupted")
+	entries, err := os.ReadDir(filepath.Dir(target))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Packages without trailer cannot be verified
+	_, err = Mirror(goldenPackage("v1-sealed"), target, nil)
+	assert.ErrorContains(t, err, "no checksum trailer")
+}
+
+func TestVerifyingReader(t *testing.T) {
+	contents := bytes.Repeat([]byte("sealpack"), 1000)
+	sums := newChecksumWriter(io.Discard, 1024)
+	_, _ = sums.Write(contents)
+	expected := sums.Trailer()
+
+	read, err := io.ReadAll(&verifyingReader{r: bytes.NewReader(contents), sums: newChecksumWriter(io.Discard, 1024), expected: expected})
+	assert.NoError(t, err)
+	assert.Equal(t, contents, read)
+
+	corrupted := bytes.Clone(contents)
+	corrupted[2000] = 'x'
+	_, err = io.ReadAll(&verifyingReader{r: bytes.NewReader(corrupted), sums: newChecksumWriter(io.Discard, 1024), expected: expected})
+	assert.ErrorContains(t, err, "file corrupted at byte 1024")
+	_, err = io.ReadAll(&verifyingReader{r: bytes.NewReader(contents[:7000]), sums: newChecksumWriter(io.Discard, 1024), expected: expected})
+	assert.ErrorContains(t, err, "file truncated")
+}
+
+func TestMirror_S3(t *testing.T) {
+	data, err := os.ReadFile(goldenPackage("v5-sealed"))
+	assert.NoError(t, err)
+	tmpList, tmpSize, tmpRange, tmpUpload := s3ListObjects, s3ObjectSize, s3DownloadRange, s3UploadStream
+	defer func() {
+		s3ListObjects, s3ObjectSize, s3DownloadRange, s3UploadStream = tmpList, tmpSize, tmpRange, tmpUpload
+	}()
+	s3ListObjects = func(string) ([]aws.S3Object, error) {
+		return []aws.S3Object{{URI: "s3://src/releases/app.ipc"}}, nil
+	}
+	s3ObjectSize = func(string) (int64, error) { return int64(len(data)), nil }
+	s3DownloadRange = func(_ string, offset, length int64) ([]byte, error) {
+		return data[offset : offset+length], nil
+	}
+	uploads := map[string][]byte{}
+	s3UploadStream = func(reader io.Reader, uri string) error {
+		contents, err := io.ReadAll(reader)
+		if err == nil {
+			uploads[uri] = contents
+		}
+		return err
+	}
+
+	mirrored, err := Mirror("s3://src/releases/", "s3://dst/stable/", nil)
+	assert.NoError(t, err)
+	assert.Len(t, mirrored, 1)
+	assert.Equal(t, data, uploads["s3://dst/stable/app.ipc"])
+
+	data = bytes.Clone(data)
+	data[100] ^= 0xff
+	_, err = Mirror("s3://src/releases/app.ipc", "s3://dst/broken.ipc", nil)
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+	assert.NotContains(t, uploads, "s3://dst/broken.ipc")
+}
+
+func TestMirror_SFTP(t *testing.T) {
+	root := t.TempDir()
+	addr := startSSHServer(t, root)
+	repo := t.TempDir()
+	copyGolden(t, "v5-sealed", filepath.Join(repo, "app.ipc"))
+	copyGolden(t, "public", filepath.Join(repo, "tools", "debug.ipc"))
+	expected, err := os.ReadFile(goldenPackage("public"))
+	assert.NoError(t, err)
+
+	mirrored, err := Mirror(repo, "sftp://"+addr+"/srv/packages/", nil)
+	assert.NoError(t, err)
+	assert.Len(t, mirrored, 2)
+	actual, err := os.ReadFile(filepath.Join(root, "srv", "packages", "tools", "debug.ipc"))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// Existing targets are replaced
+	_, err = Mirror(filepath.Join(repo, "tools", "debug.ipc"), "sftp://"+addr+"/srv/packages/app.ipc", nil)
+	assert.NoError(t, err)
+	actual, err = os.ReadFile(filepath.Join(root, "srv", "packages", "app.ipc"))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	target := t.TempDir()
+	mirrored, err = Mirror("sftp://"+addr+"/srv/packages/", target, nil)
+	assert.NoError(t, err)
+	assert.Len(t, mirrored, 2)
+	for _, pkg := range mirrored {
+		assert.True(t, strings.HasPrefix(pkg.Target, target))
+		actual, err = os.ReadFile(pkg.Target)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+}
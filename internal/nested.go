@@ -0,0 +1,179 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MaxNestingDepth limits how deep nested packages are unsealed or inspected recursively
+const MaxNestingDepth = 8
+
+// NestedPackage declares an entry of a package to be a sealed package itself, e.g. the bundle of a single device in a site bundle
+type NestedPackage struct {
+	// Name of the entry holding the nested package
+	Name string `json:"name"`
+	// Target is the directory below the output path the nested package is unsealed into
+	Target string `json:"target"`
+}
+
+// ParseNestedPackage parses a nested package given as path or path=target.
+// The entry is named like the file, the target defaults to the file name without extension.
+func ParseNestedPackage(spec string) (string, NestedPackage, error) {
+	file, target := spec, ""
+	if i := strings.LastIndex(spec, "="); i >= 0 {
+		file, target = spec[:i], spec[i+1:]
+		if target == "" {
+			return "", NestedPackage{}, fmt.Errorf("invalid nested package '%s': empty target", spec)
+		}
+	}
+	name := filepath.Base(file)
+	if target == "" {
+		target = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	pkg := NestedPackage{Name: name, Target: target}
+	return file, pkg, pkg.Validate()
+}
+
+// Validate checks that name and target of a nested package stay inside the output path
+func (p NestedPackage) Validate() error {
+	if !filepath.IsLocal(filepath.FromSlash(p.Name)) || path.Clean(p.Name) != p.Name {
+		return fmt.Errorf("invalid nested package name '%s'", p.Name)
+	}
+	if !filepath.IsLocal(filepath.FromSlash(p.Target)) || path.Clean(p.Target) != p.Target {
+		return fmt.Errorf("invalid target '%s' of nested package %s", p.Target, p.Name)
+	}
+	return nil
+}
+
+// validateNested checks all nested packages, which must neither share an entry nor a target
+func validateNested(packages []NestedPackage) error {
+	names := map[string]bool{}
+	targets := map[string]bool{}
+	for _, pkg := range packages {
+		if err := pkg.Validate(); err != nil {
+			return err
+		}
+		if names[pkg.Name] {
+			return fmt.Errorf("nested package %s is declared twice", pkg.Name)
+		}
+		if targets[pkg.Target] {
+			return fmt.Errorf("target '%s' is used by several nested packages", pkg.Target)
+		}
+		names[pkg.Name], targets[pkg.Target] = true, true
+	}
+	return nil
+}
+
+// NestedProvider provides a sealed package as entry of another package
+type NestedProvider struct {
+	// Path of the sealed package
+	Path string
+	// Package declares the entry
+	Package NestedPackage
+}
+
+// Resolve checks that the file is a sealed package and provides it under the name of the declaration
+func (p *NestedProvider) Resolve() ([]ProvidedEntry, error) {
+	f, err := os.Open(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading nested package: %v", err)
+	}
+	_, err = ParseEnvelopeUnverified(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("nested package %s: %w", p.Path, err)
+	}
+	file := FileProvider(p.Path)
+	entries, err := file.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	entries[0].Name = p.Package.Name
+	entries[0].Mode = 0644
+	return entries, nil
+}
+
+// SpoolNested reads the metadata of a package and copies all entries starting like a sealed package into dir.
+// It provides the metadata, nil if the package has none, and the spooled files by entry name.
+// The contents are not verified against the TOC, so they only suit inspection.
+func (arc *ReadArchive) SpoolNested(dir string) (*PackageMetadata, map[string]string, error) {
+	defer arc.removeChunks()
+	var metadata *PackageMetadata
+	spooled := map[string]string{}
+	for {
+		h, err := arc.TarReader.Next()
+		if err == io.EOF {
+			return metadata, spooled, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case h.Name == MetadataFileName:
+			data, err := io.ReadAll(io.LimitReader(arc.TarReader, maxMetadataSize))
+			if err != nil {
+				return nil, nil, err
+			}
+			metadata = &PackageMetadata{}
+			if err = json.Unmarshal(data, metadata); err != nil {
+				return nil, nil, corruptEnvelope(fmt.Errorf("invalid package metadata: %w", err))
+			}
+		case h.Typeflag != tar.TypeReg || strings.HasPrefix(h.Name, TocFileName) || strings.HasPrefix(h.Name, ContainerImagePrefix) || isLayerEntry(h):
+			continue
+		default:
+			r, err := arc.contentReader(h)
+			if err != nil {
+				return nil, nil, err
+			}
+			file, err := spoolPackage(dir, r)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed reading %s: %w", h.Name, err)
+			}
+			if file != "" {
+				spooled[h.Name] = file
+			}
+		}
+	}
+}
+
+// spoolPackage copies r into a temporary file in dir if it starts like a sealed package, otherwise it returns an empty name
+func spoolPackage(dir string, r io.Reader) (string, error) {
+	rd := bufio.NewReader(r)
+	magic, _ := rd.Peek(len(EnvelopeMagicBytes))
+	if string(magic) != EnvelopeMagicBytes && string(magic) != EnvelopeMagicBytesV2 {
+		return "", nil
+	}
+	f, err := os.CreateTemp(dir, "nested-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, rd)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,95 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseNestedPackage(t *testing.T) {
+	tests := []struct {
+		spec, file string
+		want       NestedPackage
+		wantErr    string
+	}{
+		{"bundles/plc-01.ipc", "bundles/plc-01.ipc", NestedPackage{Name: "plc-01.ipc", Target: "plc-01"}, ""},
+		{"bundles/plc-01.ipc=devices/plc-01", "bundles/plc-01.ipc", NestedPackage{Name: "plc-01.ipc", Target: "devices/plc-01"}, ""},
+		{"plc-01.ipc=", "", NestedPackage{}, "empty target"},
+		{"plc-01.ipc=../plc-01", "", NestedPackage{}, "invalid target"},
+		{"plc-01.ipc=/plc-01", "", NestedPackage{}, "invalid target"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			file, pkg, err := ParseNestedPackage(tt.spec)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.file, file)
+			assert.Equal(t, tt.want, pkg)
+		})
+	}
+}
+
+func TestPackageMetadata_ValidateNested(t *testing.T) {
+	a := NestedPackage{Name: "a.ipc", Target: "a"}
+	assert.NoError(t, (&PackageMetadata{Packages: []NestedPackage{a, {Name: "b.ipc", Target: "b"}}}).Validate())
+	assert.ErrorContains(t, (&PackageMetadata{Packages: []NestedPackage{a, a}}).Validate(), "declared twice")
+	assert.ErrorContains(t, (&PackageMetadata{Packages: []NestedPackage{a, {Name: "b.ipc", Target: "a"}}}).Validate(), "used by several")
+	assert.ErrorContains(t, (&PackageMetadata{Packages: []NestedPackage{{Name: "../a.ipc", Target: "a"}}}).Validate(), "invalid nested package name")
+}
+
+func TestNestedProvider_RejectsPlainFiles(t *testing.T) {
+	plain := filepath.Join(t.TempDir(), "plain.ipc")
+	assert.NoError(t, os.WriteFile(plain, []byte("no package"), 0644))
+	_, err := (&NestedProvider{Path: plain, Package: NestedPackage{Name: "plain.ipc", Target: "plain"}}).Resolve()
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+}
+
+func TestReadArchive_SpoolNested(t *testing.T) {
+	nested := "testdata/golden/gzip-sha256.ipc"
+	plain := filepath.Join(t.TempDir(), "readme.txt")
+	assert.NoError(t, os.WriteFile(plain, []byte("site bundle"), 0644))
+	pkg := NestedPackage{Name: "device.ipc", Target: "devices/device"}
+
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	sig := NewSignatureList("SHA256")
+	providers := []ContentProvider{FileProvider(plain), &NestedProvider{Path: nested, Package: pkg}}
+	assert.NoError(t, arc.AddProviders(providers, sig))
+	assert.NoError(t, arc.AddMetadata(&PackageMetadata{Packages: []NestedPackage{pkg}}, sig))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	defer f.Close()
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	metadata, spooled, err := ra.SpoolNested(t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, []NestedPackage{pkg}, metadata.Packages)
+	assert.Len(t, spooled, 1)
+	want, err := os.ReadFile(nested)
+	assert.NoError(t, err)
+	got, err := os.ReadFile(spooled["device.ipc"])
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,229 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/eddsa"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"io"
+	"strings"
+)
+
+const (
+	// openPGPPublicKeyBlock is the armor type of OpenPGP public keyrings, as exported by "gpg --export --armor"
+	openPGPPublicKeyBlock = "PGP PUBLIC KEY BLOCK"
+	// openPGPPrivateKeyBlock is the armor type of OpenPGP secret keyrings, as exported by "gpg --export-secret-keys --armor"
+	openPGPPrivateKeyBlock = "PGP PRIVATE KEY BLOCK"
+)
+
+// isOpenPGPArmor checks whether data contains an ASCII armored OpenPGP block of the type
+func isOpenPGPArmor(data []byte, blockType string) bool {
+	return bytes.Contains(data, []byte("-----BEGIN "+blockType+"-----"))
+}
+
+// readOpenPGPPrivateKey reads an armored OpenPGP secret keyring, asking for the passphrase if its keys are encrypted
+func readOpenPGPPrivateKey(data []byte, path string) (openpgp.EntityList, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenPGP private key %s: %w", path, err)
+	}
+	var passphrase []byte
+	defer func() { wipe(passphrase) }()
+	for _, entity := range keyring {
+		if entity.PrivateKey == nil {
+			return nil, WithHint(fmt.Errorf("%s does not contain OpenPGP private keys", path),
+				"export the secret keys with `gpg --export-secret-keys --armor`")
+		}
+		privateKeys := []*packet.PrivateKey{entity.PrivateKey}
+		for _, subkey := range entity.Subkeys {
+			privateKeys = append(privateKeys, subkey.PrivateKey)
+		}
+		for _, key := range privateKeys {
+			if key == nil || !key.Encrypted {
+				continue
+			}
+			if passphrase == nil {
+				if passphrase, err = PassphraseProvider(path); err != nil {
+					return nil, err
+				}
+			}
+			if err = key.Decrypt(passphrase); err != nil {
+				return nil, fmt.Errorf("invalid OpenPGP private key %s: %w", path, err)
+			}
+		}
+	}
+	return keyring, nil
+}
+
+// parseOpenPGPKeyring reads the entities of an armored OpenPGP public keyring as recipient keys.
+// Each key is identified by the name of its first identity, or by its key ID.
+func parseOpenPGPKeyring(data []byte, source string) ([]RecipientKey, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenPGP keyring %s: %w", source, err)
+	}
+	keys := make([]RecipientKey, len(keyring))
+	for i, entity := range keyring {
+		keys[i] = RecipientKey{ID: openPGPEntityName(entity), Key: entity}
+	}
+	return keys, nil
+}
+
+// openPGPEntityName names an entity by its first identity, or by its key ID
+func openPGPEntityName(entity *openpgp.Entity) string {
+	for name := range entity.Identities {
+		return name
+	}
+	return entity.PrimaryKey.KeyIdString()
+}
+
+// WrapOpenPGPKey encrypts the symmetric key for an OpenPGP recipient as binary OpenPGP message
+func WrapOpenPGPKey(entity *openpgp.Entity, plainKey []byte) ([]byte, error) {
+	entry := &bytes.Buffer{}
+	w, err := openpgp.Encrypt(entry, []*openpgp.Entity{entity}, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(plainKey); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return entry.Bytes(), nil
+}
+
+// UnwrapOpenPGPKey decrypts a symmetric key wrapped by WrapOpenPGPKey with the secret keyring of the recipient
+func UnwrapOpenPGPKey(keyring openpgp.EntityList, entry []byte) ([]byte, error) {
+	prompt := func([]openpgp.Key, bool) ([]byte, error) {
+		return nil, errors.New("OpenPGP private key is not decrypted")
+	}
+	md, err := openpgp.ReadMessage(bytes.NewReader(entry), keyring, prompt, nil)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(md.UnverifiedBody)
+}
+
+// openPGPEntryKeyID provides the ID of the key an entry is encrypted for, if it is an OpenPGP message
+func openPGPEntryKeyID(entry []byte) (uint64, bool) {
+	p, err := packet.Read(bytes.NewReader(entry))
+	if err != nil {
+		return 0, false
+	}
+	encryptedKey, ok := p.(*packet.EncryptedKey)
+	if !ok {
+		return 0, false
+	}
+	return encryptedKey.KeyId, true
+}
+
+// openPGPSigner creates detached OpenPGP signatures with the first entity of a secret keyring
+type openPGPSigner struct {
+	entity *openpgp.Entity
+	hash   crypto.Hash
+}
+
+// newOpenPGPSigner creates a signature.Signer for an OpenPGP secret keyring using the named signature hash
+func newOpenPGPSigner(keyring openpgp.EntityList, signatureHash string) (signature.Signer, error) {
+	hash, err := openPGPHash(signatureHash)
+	if err != nil {
+		return nil, err
+	}
+	return &openPGPSigner{entity: keyring[0], hash: hash}, nil
+}
+
+// SignMessage creates a detached binary OpenPGP signature of the message
+func (s *openPGPSigner) SignMessage(message io.Reader, _ ...signature.SignOption) ([]byte, error) {
+	sig := &bytes.Buffer{}
+	if err := openpgp.DetachSign(sig, s.entity, message, &packet.Config{DefaultHash: s.hash}); err != nil {
+		return nil, err
+	}
+	return sig.Bytes(), nil
+}
+
+// PublicKey provides the primary public key of the signing entity
+func (s *openPGPSigner) PublicKey(_ ...signature.PublicKeyOption) (crypto.PublicKey, error) {
+	return openPGPPublicKey(s.entity), nil
+}
+
+// openPGPVerifier checks detached OpenPGP signatures against a public keyring
+type openPGPVerifier struct {
+	keyring openpgp.EntityList
+}
+
+// VerifySignature checks a detached binary OpenPGP signature of the message
+func (v *openPGPVerifier) VerifySignature(sig, message io.Reader, _ ...signature.VerifyOption) error {
+	_, err := openpgp.CheckDetachedSignature(v.keyring, message, sig, nil)
+	return err
+}
+
+// PublicKey provides the primary public key of the first entity of the keyring
+func (v *openPGPVerifier) PublicKey(_ ...signature.PublicKeyOption) (crypto.PublicKey, error) {
+	return openPGPPublicKey(v.keyring[0]), nil
+}
+
+// openPGPHash provides the hash of OpenPGP signatures for the named signature hash
+func openPGPHash(signatureHash string) (crypto.Hash, error) {
+	name, err := ParseSignatureHash(signatureHash)
+	if err != nil {
+		return 0, err
+	}
+	if name == SignatureHashEd25519ph {
+		return 0, fmt.Errorf("signature hash %s requires an Ed25519 key", SignatureHashEd25519ph)
+	}
+	return signatureHashes[name], nil
+}
+
+// openPGPCanEncrypt checks whether an entity has a subkey or primary key that messages can be encrypted for
+func openPGPCanEncrypt(entity *openpgp.Entity) bool {
+	for _, subkey := range entity.Subkeys {
+		if subkey.Sig.FlagsValid && subkey.Sig.FlagEncryptCommunications && subkey.PublicKey.PubKeyAlgo.CanEncrypt() {
+			return true
+		}
+	}
+	return entity.PrimaryKey.PubKeyAlgo.CanEncrypt()
+}
+
+// openPGPPublicKey provides the primary public key of an entity, with EdDSA keys on Curve25519 as ed25519.PublicKey,
+// so they are fingerprinted like other Ed25519 keys
+func openPGPPublicKey(entity *openpgp.Entity) crypto.PublicKey {
+	if key, ok := entity.PrimaryKey.PublicKey.(*eddsa.PublicKey); ok && len(key.X) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(key.X)
+	}
+	return entity.PrimaryKey.PublicKey
+}
+
+// openPGPKeyType names the algorithm of the primary key of an entity
+func openPGPKeyType(entity *openpgp.Entity) string {
+	switch entity.PrimaryKey.PubKeyAlgo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly:
+		return "RSA"
+	case packet.PubKeyAlgoDSA:
+		return "DSA"
+	case packet.PubKeyAlgoECDSA:
+		return "ECDSA"
+	case packet.PubKeyAlgoEdDSA:
+		return "EdDSA"
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", entity.PrimaryKey.PublicKey), "*")
+}
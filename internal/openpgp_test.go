@@ -0,0 +1,175 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeOpenPGPKeyPair creates an OpenPGP RSA entity and writes its armored secret and public keyrings
+func writeOpenPGPKeyPair(t *testing.T, name string) (*openpgp.Entity, string, string) {
+	return writeOpenPGPKeyPairWithConfig(t, name, &packet.Config{DefaultHash: crypto.SHA256})
+}
+
+// writeOpenPGPKeyPairWithConfig creates an OpenPGP entity with the algorithm of the config and writes its keyrings
+func writeOpenPGPKeyPairWithConfig(t *testing.T, name string, config *packet.Config) (*openpgp.Entity, string, string) {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", config)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	privPath, pubPath := filepath.Join(dir, name+"-private.asc"), filepath.Join(dir, name+"-public.asc")
+	for path, blockType := range map[string]string{privPath: openpgp.PrivateKeyType, pubPath: openpgp.PublicKeyType} {
+		buf := &bytes.Buffer{}
+		w, err := armor.Encode(buf, blockType, nil)
+		assert.NoError(t, err)
+		if path == privPath {
+			assert.NoError(t, entity.SerializePrivate(w, nil))
+		} else {
+			assert.NoError(t, entity.Serialize(w))
+		}
+		assert.NoError(t, w.Close())
+		assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0600))
+	}
+	return entity, privPath, pubPath
+}
+
+func TestWrapOpenPGPKey(t *testing.T) {
+	entity, privPath, _ := writeOpenPGPKeyPair(t, "device-1")
+	entry, err := WrapOpenPGPKey(entity, []byte("payload key"))
+	assert.NoError(t, err)
+	keyID, ok := openPGPEntryKeyID(entry)
+	assert.True(t, ok)
+	assert.Equal(t, entity.Subkeys[0].PublicKey.KeyId, keyID)
+
+	priv, err := LoadPrivateKey(privPath)
+	assert.NoError(t, err)
+	plain, err := UnwrapOpenPGPKey(priv.(openpgp.EntityList), entry)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("payload key"), plain)
+
+	other, _, _ := writeOpenPGPKeyPair(t, "device-2")
+	_, err = UnwrapOpenPGPKey(openpgp.EntityList{other}, entry)
+	assert.Error(t, err)
+
+	_, ok = openPGPEntryKeyID(make([]byte, 512))
+	assert.False(t, ok)
+}
+
+func TestOpenPGPSignerVerifier(t *testing.T) {
+	_, privPath, pubPath := writeOpenPGPKeyPair(t, "signer")
+	signer, err := CreateSignerWithHash(privPath, "SHA384")
+	assert.NoError(t, err)
+	sig, err := signer.SignMessage(strings.NewReader("Hold your breath and count to 10."))
+	assert.NoError(t, err)
+
+	verifier, err := CreateVerifier(pubPath)
+	assert.NoError(t, err)
+	assert.NoError(t, verifier.VerifySignature(bytes.NewReader(sig), strings.NewReader("Hold your breath and count to 10.")))
+	assert.Error(t, verifier.VerifySignature(bytes.NewReader(sig), strings.NewReader("Hold your breath and count to 11.")))
+
+	_, _, otherPath := writeOpenPGPKeyPair(t, "other")
+	verifier, err = CreateVerifier(otherPath)
+	assert.NoError(t, err)
+	assert.Error(t, verifier.VerifySignature(bytes.NewReader(sig), strings.NewReader("Hold your breath and count to 10.")))
+
+	_, err = CreateSignerWithHash(privPath, SignatureHashEd25519ph)
+	assert.ErrorContains(t, err, "requires an Ed25519 key")
+}
+
+func TestOpenPGPRecipients(t *testing.T) {
+	entity, privPath, pubPath := writeOpenPGPKeyPair(t, "device-1")
+	keys, err := loadKeyringFile(pubPath)
+	assert.NoError(t, err)
+	if !assert.Len(t, keys, 1) {
+		return
+	}
+	assert.Equal(t, "device-1 <device-1@example.com>", keys[0].ID)
+
+	plainKey, _ := EncryptWriter(&bytes.Buffer{})
+	envelope := &Envelope{Version: EnvelopeVersion2}
+	assert.ErrorContains(t, AddKeys(keys, envelope, plainKey), "requires envelope version 3")
+	envelope = &Envelope{Version: EnvelopeVersion3, HashAlgorithm: crypto.SHA256}
+	assert.NoError(t, AddKeys(keys, envelope, plainKey))
+	assert.Contains(t, envelope.String(), "OpenPGP message for key ID "+entity.Subkeys[0].PublicKey.KeyIdString())
+
+	priv, err := LoadPrivateKey(privPath)
+	assert.NoError(t, err)
+	unsealed, err := tryUnsealKeyWith(envelope.ReceiverKeys[0], priv)
+	assert.NoError(t, err)
+	assert.Equal(t, plainKey, unsealed)
+
+	fingerprint, err := Fingerprint(entity)
+	assert.NoError(t, err)
+	assert.Equal(t, fingerprint, publicKeyFingerprint(privPath))
+	assert.Equal(t, fingerprint, publicKeyFingerprint(pubPath))
+}
+
+func TestGetKeyInfo_OpenPGP(t *testing.T) {
+	entity, privPath, pubPath := writeOpenPGPKeyPair(t, "device-1")
+	for _, path := range []string{privPath, pubPath} {
+		info, err := GetKeyInfo(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "OpenPGP RSA (key ID "+entity.PrimaryKey.KeyIdString()+")", info.Type)
+		assert.Equal(t, 2048, info.Bits)
+		assert.True(t, info.Signing)
+		assert.True(t, info.Encryption)
+		assert.Equal(t, path == privPath, info.Private)
+	}
+}
+
+func TestOpenPGP_Curve25519(t *testing.T) {
+	config := &packet.Config{DefaultHash: crypto.SHA256, Algorithm: packet.PubKeyAlgoEdDSA}
+	entity, privPath, pubPath := writeOpenPGPKeyPairWithConfig(t, "device-1", config)
+	assert.Equal(t, packet.PubKeyAlgoECDH, entity.Subkeys[0].PublicKey.PubKeyAlgo)
+
+	// The payload key is wrapped for the Curve25519 encryption subkey
+	entry, err := WrapOpenPGPKey(entity, []byte("payload key"))
+	assert.NoError(t, err)
+	keyID, ok := openPGPEntryKeyID(entry)
+	assert.True(t, ok)
+	assert.Equal(t, entity.Subkeys[0].PublicKey.KeyId, keyID)
+	priv, err := LoadPrivateKey(privPath)
+	assert.NoError(t, err)
+	plain, err := UnwrapOpenPGPKey(priv.(openpgp.EntityList), entry)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("payload key"), plain)
+
+	// Signatures are made with the EdDSA primary key
+	signer, err := CreateSignerWithHash(privPath, "SHA256")
+	assert.NoError(t, err)
+	sig, err := signer.SignMessage(strings.NewReader("Hold your breath and count to 10."))
+	assert.NoError(t, err)
+	verifier, err := CreateVerifier(pubPath)
+	assert.NoError(t, err)
+	assert.NoError(t, verifier.VerifySignature(bytes.NewReader(sig), strings.NewReader("Hold your breath and count to 10.")))
+	assert.Error(t, verifier.VerifySignature(bytes.NewReader(sig), strings.NewReader("Hold your breath and count to 11.")))
+
+	info, err := GetKeyInfo(pubPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "OpenPGP EdDSA (key ID "+entity.PrimaryKey.KeyIdString()+")", info.Type)
+	assert.True(t, info.Signing)
+	assert.True(t, info.Encryption)
+	fingerprint, err := Fingerprint(entity)
+	assert.NoError(t, err)
+	assert.Equal(t, fingerprint, publicKeyFingerprint(privPath))
+}
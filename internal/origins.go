@@ -0,0 +1,153 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	// OriginsFileName is the archive entry holding the origins of all entries, signed as part of the TOC
+	OriginsFileName = ".sealpack.origins"
+	// maxOriginsSize limits the size of the origins read from a package
+	maxOriginsSize = 16 << 20
+)
+
+// EntryOrigin records the build input an entry of a package was created from
+type EntryOrigin struct {
+	// Path is the file the contents were read from when sealing
+	Path string `json:"path,omitempty"`
+	// URL is the location the contents were downloaded from
+	URL string `json:"url,omitempty"`
+	// Commit is the revision of the sources the contents were built from, e.g. a git commit
+	Commit string `json:"commit,omitempty"`
+	// ImageDigest is the manifest digest of a pulled image
+	ImageDigest string `json:"image_digest,omitempty"`
+}
+
+// Origins map the names of archive entries to their origins
+type Origins map[string]*EntryOrigin
+
+// recordOrigin keeps the origin of an entry, adding the commit of the build input if configured
+func (arc *WriteArchive) recordOrigin(name string, origin *EntryOrigin) {
+	if !arc.RecordOrigins {
+		return
+	}
+	if origin == nil {
+		origin = &EntryOrigin{}
+	}
+	if arc.OriginCommit != "" {
+		origin.Commit = arc.OriginCommit
+	}
+	if arc.origins == nil {
+		arc.origins = Origins{}
+	}
+	arc.origins[name] = origin
+}
+
+// AddOrigins adds the origins of all entries to the archive and the TOC, so they are signed with the contents.
+// Nothing is added if no origins have been recorded.
+func (arc *WriteArchive) AddOrigins(signatures *FileSignatures) error {
+	if len(arc.origins) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(arc.origins)
+	if err != nil {
+		return err
+	}
+	if err = arc.AddToArchive(OriginsFileName, data); err != nil {
+		return fmt.Errorf("seal: failed adding origins to archive: %v", err)
+	}
+	return signatures.AddFile(OriginsFileName, data)
+}
+
+// readOrigins hashes the origins of a package for the TOC without acting on them; they are only read by inspect
+func (arc *ReadArchive) readOrigins(h *tar.Header, v *Verifier) error {
+	return v.Signatures.AddFileFromReader(h.Name, io.LimitReader(arc.TarReader, maxOriginsSize))
+}
+
+// ReadOrigins searches the archive for the origins of its entries.
+// If a signing key is provided, the origins are verified against the signed TOC, otherwise they only suit inspection.
+func (arc *ReadArchive) ReadOrigins(signingKeyPath, hashingAlgorithm string) (Origins, error) {
+	var data []byte
+	var verifier *Verifier
+	if signingKeyPath != "" {
+		var err error
+		if verifier, err = NewVerifier(signingKeyPath, hashingAlgorithm); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		h, err := arc.TarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case h.Name == OriginsFileName:
+			if data, err = io.ReadAll(io.LimitReader(arc.TarReader, maxOriginsSize)); err != nil {
+				return nil, err
+			}
+		case verifier != nil && strings.HasPrefix(h.Name, TocFileName):
+			if err = verifier.AddTocComponent(h, arc.TarReader); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if data == nil {
+		return nil, fmt.Errorf("package records no origins, seal it with --origins")
+	}
+	if verifier != nil {
+		if err := verifier.verifyEntry(OriginsFileName, data); err != nil {
+			return nil, err
+		}
+	}
+	origins := Origins{}
+	if err := json.Unmarshal(data, &origins); err != nil {
+		return nil, corruptEnvelope(fmt.Errorf("invalid origins: %w", err))
+	}
+	return origins, nil
+}
+
+// String lists the origins of all entries sorted by name
+func (o Origins) String() string {
+	names := make([]string, 0, len(o))
+	for name := range o {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sb := strings.Builder{}
+	sb.WriteString("Origins of the package contents:\n")
+	for _, name := range names {
+		sb.WriteString(fmt.Sprintf("\t%s\n", name))
+		origin := o[name]
+		if origin == nil {
+			continue
+		}
+		for _, field := range [][2]string{{"path", origin.Path}, {"url", origin.URL}, {"commit", origin.Commit}, {"image digest", origin.ImageDigest}} {
+			if field[1] != "" {
+				sb.WriteString(fmt.Sprintf("\t\t%s: %s\n", field[0], field[1]))
+			}
+		}
+	}
+	return sb.String()
+}
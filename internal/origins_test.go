@@ -0,0 +1,77 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createOriginsArchive seals a file and a download with recorded origins
+func createOriginsArchive(t *testing.T) (*WriteArchive, string) {
+	file := filepath.Join(t.TempDir(), "foo")
+	assert.NoError(t, os.WriteFile(file, []byte("foo"), 0644))
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	t.Cleanup(func() { _ = arc.Cleanup() })
+	arc.RecordOrigins = true
+	arc.OriginCommit = "4f2c9e1"
+	assert.NoError(t, arc.AddProviders([]ContentProvider{FileProvider(file)}, sig))
+	assert.NoError(t, arc.AddOrigins(sig))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+	return arc, file
+}
+
+func TestReadArchive_Origins(t *testing.T) {
+	arc, file := createOriginsArchive(t)
+
+	origins, err := openTestArchive(t, arc).ReadOrigins("../test/public.pem", "SHA256")
+	assert.NoError(t, err)
+	assert.Equal(t, Origins{"foo": {Path: file, Commit: "4f2c9e1"}}, origins)
+	assert.Contains(t, origins.String(), "\tfoo\n\t\tpath: "+file+"\n\t\tcommit: 4f2c9e1\n")
+
+	_, err = openTestArchive(t, arc).ReadOrigins("../test/ec-public.pem", "SHA256")
+	assert.ErrorIs(t, err, ErrBadSignature)
+
+	out := t.TempDir()
+	ra := openTestArchive(t, arc)
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""))
+	assert.FileExists(t, filepath.Join(out, "foo"))
+	assert.NoFileExists(t, filepath.Join(out, OriginsFileName))
+
+	headers, err := openTestArchive(t, arc).List()
+	assert.NoError(t, err)
+	assert.Len(t, headers, 1)
+}
+
+func TestReadArchive_OriginsMissing(t *testing.T) {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	assert.NoError(t, arc.AddToArchive("foo", []byte("foo")))
+	assert.NoError(t, sig.AddFile("foo", []byte("foo")))
+	// Nothing is added unless origins are recorded
+	assert.NoError(t, arc.AddOrigins(sig))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+
+	_, err = openTestArchive(t, arc).ReadOrigins("", "SHA256")
+	assert.ErrorContains(t, err, "package records no origins")
+}
@@ -0,0 +1,177 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OutputFormat selects whether Unpack extracts to a directory or writes a plain archive
+type OutputFormat string
+
+const (
+	OutputDir OutputFormat = "dir"
+	OutputTar OutputFormat = "tar"
+	OutputZip OutputFormat = "zip"
+)
+
+// ParseOutputFormat parses the name of an OutputFormat, an empty name is OutputDir
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	switch format := OutputFormat(strings.ToLower(name)); format {
+	case "":
+		return OutputDir, nil
+	case OutputDir, OutputTar, OutputZip:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid output format %q, allowed values are 'dir', 'tar' and 'zip'", name)
+}
+
+// An ArchiveOutput collects unpacked contents in a plain tar or zip archive.
+// The archive is written to a temporary file next to its path and only moved there by Commit,
+// so contents of packages failing verification are never handed on.
+type ArchiveOutput struct {
+	path string
+	file *os.File
+	tw   *tar.Writer
+	zw   *zip.Writer
+}
+
+// CreateArchiveOutput starts a plain archive of a format to be stored at path
+func CreateArchiveOutput(path string, format OutputFormat) (*ArchiveOutput, error) {
+	if format != OutputTar && format != OutputZip {
+		return nil, fmt.Errorf("cannot write an archive in format %s", format)
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".sealpack-*.part")
+	if err != nil {
+		return nil, err
+	}
+	out := &ArchiveOutput{path: path, file: file}
+	if format == OutputTar {
+		out.tw = tar.NewWriter(file)
+	} else {
+		out.zw = zip.NewWriter(file)
+	}
+	return out, nil
+}
+
+// Path is the location the archive is stored at by Commit
+func (o *ArchiveOutput) Path() string {
+	return o.path
+}
+
+// add writes an entry with its real size and contents to the archive.
+// PAX records of the package are not copied, as they describe how the entry is stored in the package;
+// records are only stored in tar archives.
+func (o *ArchiveOutput) add(h *tar.Header, size int64, r io.Reader, records map[string]string) error {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	var w io.Writer
+	var err error
+	if o.tw != nil {
+		err = o.tw.WriteHeader(&tar.Header{Name: h.Name, Size: size, Mode: h.Mode, ModTime: h.ModTime, PAXRecords: records, Format: tar.FormatPAX})
+		w = o.tw
+	} else {
+		fh := &zip.FileHeader{Name: h.Name, Method: zip.Deflate, Modified: h.ModTime}
+		fh.SetMode(os.FileMode(h.Mode))
+		w, err = o.zw.CreateHeader(fh)
+	}
+	if err != nil {
+		return err
+	}
+	written, err := io.CopyBuffer(w, r, *buf)
+	if err == nil && written != size {
+		err = fmt.Errorf("%s has %d bytes instead of %d", h.Name, written, size)
+	}
+	return err
+}
+
+// WriteDetachedToc adds the verified TOC to the archive output and writes its signature to a detached file,
+// so tools understanding tar, but not the envelope, can verify the contents. Unpack must have collected the TOC in Installed.
+func (arc *ReadArchive) WriteDetachedToc(signaturePath string) error {
+	if arc.Output == nil || arc.Installed == nil || arc.Installed.toc == nil {
+		return fmt.Errorf("no verified TOC to write")
+	}
+	toc := arc.Installed.toc
+	h := &tar.Header{Name: TocFileName, Mode: 0644, ModTime: time.Now()}
+	records := map[string]string{paxHashingAlgorithm: arc.Installed.hashingAlgorithm, paxSignatureHash: arc.Installed.signatureHash}
+	if err := arc.Output.add(h, int64(len(toc)), bytes.NewReader(toc), records); err != nil {
+		return err
+	}
+	return WriteFileBytes(signaturePath, arc.Installed.signature)
+}
+
+// Commit completes the archive and moves it to its path
+func (o *ArchiveOutput) Commit() (err error) {
+	if o.tw != nil {
+		err = o.tw.Close()
+	} else {
+		err = o.zw.Close()
+	}
+	if err == nil {
+		err = o.file.Sync()
+	}
+	if err != nil {
+		_ = o.Abort()
+		return err
+	}
+	if err = o.file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(o.file.Name(), o.path)
+}
+
+// Abort removes the incomplete archive; it is safe to call after Commit
+func (o *ArchiveOutput) Abort() error {
+	if _, err := o.file.Stat(); err != nil {
+		// Committed or aborted before
+		return nil
+	}
+	return removeTempFile(o.file)
+}
+
+// writeOutput adds a content file or image to the ArchiveOutput of the archive.
+// Images are verified against the ImagePolicy first, but never imported.
+func (arc *ReadArchive) writeOutput(h *tar.Header, r io.Reader, entry *ReportEntry) error {
+	entry.Destination = arc.Output.path
+	if strings.HasPrefix(h.Name, ContainerImagePrefix) {
+		entry.ImportStatus = ImportStatusStored
+		if arc.ImagePolicy != nil {
+			spool, err := arc.verifyImage(h, r)
+			if err != nil {
+				entry.ImportStatus = ImportStatusFailed
+				return err
+			}
+			defer func() { _ = removeTempFile(spool) }()
+			r = spool
+		}
+	}
+	if arc.Checksums == nil {
+		return arc.Output.add(h, contentSize(h), r, nil)
+	}
+	sum := sha256.New()
+	if err := arc.Output.add(h, contentSize(h), io.TeeReader(r, sum), nil); err != nil {
+		return err
+	}
+	arc.Checksums.add(h.Name, sum.Sum(nil))
+	return nil
+}
@@ -0,0 +1,149 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseOutputFormat(t *testing.T) {
+	for name, expected := range map[string]OutputFormat{"": OutputDir, "dir": OutputDir, "TAR": OutputTar, "zip": OutputZip} {
+		format, err := ParseOutputFormat(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, format)
+	}
+	_, err := ParseOutputFormat("7z")
+	assert.ErrorContains(t, err, "invalid output format")
+}
+
+// unpackToOutput unpacks a small files archive into an ArchiveOutput
+func unpackToOutput(t *testing.T, format OutputFormat, tamper bool) (string, error) {
+	arc := createSmallFilesArchive(t, 20, tamper)
+	defer arc.Cleanup()
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	defer f.Close()
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	// Parallel extraction is disabled for archive outputs
+	ra.Parallel = 4
+	ra.Checksums = NewChecksumList()
+	path := filepath.Join(t.TempDir(), "contents."+string(format))
+	ra.Output, err = CreateArchiveOutput(path, format)
+	assert.NoError(t, err)
+	defer func() { _ = ra.Output.Abort() }()
+	if err = ra.Unpack("../test/public.pem", "SHA256", path, "", ""); err != nil {
+		return path, err
+	}
+	assert.Len(t, ra.Checksums.sums, 20)
+	return path, ra.Output.Commit()
+}
+
+func TestReadArchive_UnpackToTar(t *testing.T) {
+	path, err := unpackToOutput(t, OutputTar, false)
+	assert.NoError(t, err)
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for i := 0; i < 20; i++ {
+		h, err := tr.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("files/%04d.txt", i), h.Name)
+		contents, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("contents of file %d", i), string(contents))
+	}
+	_, err = tr.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadArchive_UnpackToZip(t *testing.T) {
+	path, err := unpackToOutput(t, OutputZip, false)
+	assert.NoError(t, err)
+	zr, err := zip.OpenReader(path)
+	assert.NoError(t, err)
+	defer zr.Close()
+	assert.Len(t, zr.File, 20)
+	r, err := zr.Open("files/0007.txt")
+	assert.NoError(t, err)
+	contents, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "contents of file 7", string(contents))
+}
+
+func TestReadArchive_UnpackToOutputTampered(t *testing.T) {
+	path, err := unpackToOutput(t, OutputTar, true)
+	assert.ErrorIs(t, err, ErrBadSignature)
+	// Neither the archive nor its temporary file are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestReadArchive_WriteDetachedToc(t *testing.T) {
+	arc := createSmallFilesArchive(t, 5, false)
+	defer arc.Cleanup()
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	defer f.Close()
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "contents.tar")
+	ra.Output, err = CreateArchiveOutput(path, OutputTar)
+	assert.NoError(t, err)
+	defer func() { _ = ra.Output.Abort() }()
+	assert.ErrorContains(t, ra.WriteDetachedToc(path+".sig"), "no verified TOC")
+	ra.Installed = NewTocFile()
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", path, "", ""))
+	assert.NoError(t, ra.WriteDetachedToc(path+".sig"))
+	assert.NoError(t, ra.Output.Commit())
+
+	// The TOC is the last entry and can be verified with the detached signature
+	out, err := os.Open(path)
+	assert.NoError(t, err)
+	defer out.Close()
+	tr := tar.NewReader(out)
+	var toc []byte
+	var entries int
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		entries++
+		if h.Name == TocFileName {
+			assert.Equal(t, "SHA256", h.PAXRecords[paxHashingAlgorithm])
+			toc, err = io.ReadAll(tr)
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, 6, entries)
+	assert.Contains(t, string(toc), "files/0003.txt")
+	signature, err := os.ReadFile(path + ".sig")
+	assert.NoError(t, err)
+	verifier, err := CreateVerifier("../test/public.pem")
+	assert.NoError(t, err)
+	assert.NoError(t, verifier.VerifySignature(bytes.NewReader(signature), bytes.NewReader(toc)))
+}
@@ -0,0 +1,128 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Ownership maps the owner and permissions of files and directories created by Unpack
+type Ownership struct {
+	// UID and GID are set as owner, -1 keeps the respective owner of the unsealing process
+	UID, GID int
+	// Mask is cleared from the mode recorded in the package for files and from 0777 for directories, if Chmod is set
+	Mask  os.FileMode
+	Chmod bool
+}
+
+// ParseOwner parses an owner given as user[:group] by name or numeric ID.
+// A missing group keeps the group of the unsealing process.
+func ParseOwner(owner string) (uid, gid int, err error) {
+	name, group, hasGroup := strings.Cut(owner, ":")
+	if uid, err = lookupID(name, func(n string) (string, error) {
+		u, err := user.Lookup(n)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	}); err != nil {
+		return -1, -1, fmt.Errorf("invalid owner %q: %w", owner, err)
+	}
+	gid = -1
+	if hasGroup {
+		if gid, err = lookupID(group, func(n string) (string, error) {
+			g, err := user.LookupGroup(n)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return -1, -1, fmt.Errorf("invalid group in owner %q: %w", owner, err)
+		}
+	}
+	return uid, gid, nil
+}
+
+// lookupID resolves a numeric ID or a name using lookup
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, fmt.Errorf("missing name or ID")
+	}
+	id, err := strconv.Atoi(name)
+	if err != nil {
+		var resolved string
+		if resolved, err = lookup(name); err != nil {
+			return -1, err
+		}
+		id, err = strconv.Atoi(resolved)
+	}
+	if err == nil && id < 0 {
+		err = fmt.Errorf("negative ID %d", id)
+	}
+	return id, err
+}
+
+// ParseModeMask parses an octal permission mask like 027
+func ParseModeMask(mask string) (os.FileMode, error) {
+	bits, err := strconv.ParseUint(mask, 8, 32)
+	if err != nil || bits > 0777 {
+		return 0, fmt.Errorf("invalid mode mask %q, expected octal permission bits like 027", mask)
+	}
+	return os.FileMode(bits), nil
+}
+
+// apply sets the owner and masked mode of a file or directory
+func (o *Ownership) apply(path string, mode os.FileMode) error {
+	if o.Chmod {
+		if err := os.Chmod(path, mode.Perm()&^o.Mask); err != nil {
+			return err
+		}
+	}
+	if o.UID >= 0 || o.GID >= 0 {
+		return os.Lchown(path, o.UID, o.GID)
+	}
+	return nil
+}
+
+// applyDirectories applies the Ownership to all directories between the output path and a file, excluding the output path.
+// Each directory is only changed once per Unpack.
+func (arc *ReadArchive) applyDirectories(outputPath, fullFile string) error {
+	if arc.Ownership == nil {
+		return nil
+	}
+	if arc.ownedDirs == nil {
+		arc.ownedDirs = map[string]bool{}
+	}
+	rel, err := filepath.Rel(outputPath, filepath.Dir(fullFile))
+	if err != nil || rel == "." {
+		return err
+	}
+	dir := outputPath
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		if arc.ownedDirs[dir] {
+			continue
+		}
+		if err = arc.Ownership.apply(dir, 0777); err != nil {
+			return fmt.Errorf("cannot set ownership of %s: %w", dir, err)
+		}
+		arc.ownedDirs[dir] = true
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"archive/tar"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseOwner(t *testing.T) {
+	uid, gid, err := ParseOwner("1000:1001")
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, uid)
+	assert.Equal(t, 1001, gid)
+	uid, gid, err = ParseOwner("1000")
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, uid)
+	assert.Equal(t, -1, gid)
+
+	current, err := user.Current()
+	assert.NoError(t, err)
+	if runtime.GOOS != "windows" {
+		uid, _, err = ParseOwner(current.Username)
+		assert.NoError(t, err)
+		assert.Equal(t, current.Uid, strconv.Itoa(uid))
+	}
+
+	for _, owner := range []string{"", ":1000", "-1", "1000:", "sealpack-no-such-user"} {
+		_, _, err = ParseOwner(owner)
+		assert.Error(t, err, owner)
+	}
+}
+
+func TestParseModeMask(t *testing.T) {
+	mask, err := ParseModeMask("027")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0027), mask)
+	for _, invalid := range []string{"", "9", "1777", "rwx"} {
+		_, err = ParseModeMask(invalid)
+		assert.ErrorContains(t, err, "invalid mode mask", invalid)
+	}
+}
+
+func TestReadArchive_Ownership(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not supported on Windows")
+	}
+	outputPath := t.TempDir()
+	// Changing the owner to the current user needs no privileges
+	arc := &ReadArchive{Ownership: &Ownership{UID: os.Getuid(), GID: os.Getgid(), Mask: 0027, Chmod: true}}
+	fullFile := filepath.Join(outputPath, "etc", "app", "config.yaml")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(fullFile), 0777))
+	assert.NoError(t, arc.applyDirectories(outputPath, fullFile))
+	h := &tar.Header{Name: "etc/app/config.yaml", Mode: 0755, Size: 4}
+	assert.NoError(t, arc.storeFile(h, strings.NewReader("test"), fullFile))
+
+	for path, mode := range map[string]os.FileMode{
+		filepath.Join(outputPath, "etc"):        0750,
+		filepath.Join(outputPath, "etc", "app"): 0750,
+		fullFile:                                0750,
+	} {
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, mode, info.Mode().Perm(), path)
+	}
+	// The output path itself is left untouched
+	assert.Len(t, arc.ownedDirs, 2)
+}
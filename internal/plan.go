@@ -0,0 +1,92 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PlannedEntry describes an entry that would be added to an archive without actually adding it.
+type PlannedEntry struct {
+	Name      string
+	Source    string
+	Size      int64
+	Estimated bool
+}
+
+// PlanContents resolves all files and image manifests to the list of entries a seal would create.
+// Sizes of files are exact, sizes of images are estimated from their manifests.
+func PlanContents(files []string, images []*ContainerImage, httpHeader http.Header) ([]*PlannedEntry, error) {
+	providers, err := ContentProviders(files, images, false, httpHeader, nil)
+	if err != nil {
+		return nil, err
+	}
+	return PlanProviders(providers)
+}
+
+// PlanProviders resolves the entries of all providers without opening their contents
+func PlanProviders(providers []ContentProvider) ([]*PlannedEntry, error) {
+	var plan []*PlannedEntry
+	for _, provider := range providers {
+		entries, err := provider.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			planned, err := planEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+			plan = append(plan, planned)
+		}
+	}
+	return plan, nil
+}
+
+// planEntry describes a provided entry with its size, if the provider knows it
+func planEntry(entry ProvidedEntry) (*PlannedEntry, error) {
+	size, estimated := int64(0), true
+	if entry.Size != nil {
+		var err error
+		if size, estimated, err = entry.Size(); err != nil {
+			return nil, err
+		}
+	}
+	return &PlannedEntry{
+		Name:      entry.Name,
+		Source:    entry.Source,
+		Size:      size,
+		Estimated: estimated,
+	}, nil
+}
+
+// PlanString formats a list of planned entries as a human-readable TOC
+func PlanString(plan []*PlannedEntry) string {
+	var total int64
+	sb := strings.Builder{}
+	sb.WriteString("Package would contain:\n")
+	for _, entry := range plan {
+		approx := ""
+		if entry.Estimated {
+			approx = "~"
+		}
+		sb.WriteString(fmt.Sprintf("\t%s (%s%d Bytes) from %s\n", entry.Name, approx, entry.Size, entry.Source))
+		total += entry.Size
+	}
+	sb.WriteString(fmt.Sprintf("\t%d entries, ~%d Bytes uncompressed\n", len(plan), total))
+	return sb.String()
+}
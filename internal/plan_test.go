@@ -0,0 +1,89 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanContents(t *testing.T) {
+	old := pullImage
+	defer func() { pullImage = old }()
+	img, err := random.Image(1024, 2)
+	assert.NoError(t, err)
+	pullImage = func(src string, opt ...crane.Option) (v1.Image, error) {
+		return img, nil
+	}
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "foo.txt"), []byte("foo"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "bar.txt"), []byte("barbar"), 0644))
+
+	plan, err := PlanContents([]string{dir}, []*ContainerImage{ParseContainerImage("alpine:3.17")}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, plan, 3)
+	base := filepath.Base(dir)
+	assert.Equal(t, base+"/bar.txt", plan[0].Name)
+	assert.Equal(t, int64(6), plan[0].Size)
+	assert.False(t, plan[0].Estimated)
+	assert.Equal(t, base+"/foo.txt", plan[1].Name)
+	assert.Equal(t, ".images/docker.io/alpine:3.17.oci", plan[2].Name)
+	assert.True(t, plan[2].Estimated)
+	assert.Greater(t, plan[2].Size, int64(2048))
+	assert.Contains(t, PlanString(plan), "3 entries")
+}
+
+func TestPlanContents_ImageError(t *testing.T) {
+	old := pullImage
+	defer func() { pullImage = old }()
+	pullImage = func(src string, opt ...crane.Option) (v1.Image, error) {
+		return nil, fmt.Errorf("fnord")
+	}
+	_, err := PlanContents(nil, []*ContainerImage{ParseContainerImage("alpine:3.17")}, nil)
+	assert.ErrorContains(t, err, "fnord")
+}
+
+func TestReadArchive_UnpackDryRun(t *testing.T) {
+	// Arrange
+	sig := NewSignatureList("SHA512")
+	arc := CreateArchiveWriter(true, 0)
+	assert.NoError(t, arc.AddToArchive("path/to/foo", []byte("Hold your breath and count to 10.")))
+	assert.NoError(t, sig.AddFile("path/to/foo", []byte("Hold your breath and count to 10.")))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+	defer arc.Cleanup()
+
+	// Act
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	ra.DryRun = true
+	ra.Report = NewReport("unseal", "foo", "SHA512")
+	out := filepath.Join(t.TempDir(), "out")
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA512", out, "", ""))
+
+	// Assert
+	assert.NoDirExists(t, out)
+	assert.Len(t, ra.Report.Entries, 1)
+	assert.Equal(t, filepath.Join(out, "path/to/foo"), ra.Report.Entries[0].Destination)
+}
@@ -0,0 +1,214 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sigstore/sigstore/pkg/signature/options"
+	"io"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ProvenanceFileName is the archive entry holding the signed provenance statement.
+	// It is signed on its own, as it refers to the TOC and therefore cannot be part of it.
+	ProvenanceFileName = ".sealpack.provenance"
+
+	InTotoStatementType  = "https://in-toto.io/Statement/v1"
+	SLSAProvenanceType   = "https://slsa.dev/provenance/v1"
+	InTotoPayloadType    = "application/vnd.in-toto+json"
+	SealpackBuildType    = "https://github.com/innomotics/sealpack/seal/v1"
+	SealpackBuilderID    = "https://github.com/innomotics/sealpack"
+	sealpackModulePath   = "github.com/innomotics/sealpack"
+	dssePreAuthEncPrefix = "DSSEv1"
+)
+
+// A ResourceDescriptor identifies an artifact by name, location and digest
+type ResourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+// A ProvenanceStatement is an in-toto statement with a SLSA provenance predicate describing how a package was sealed
+type ProvenanceStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []ResourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     SLSAProvenance       `json:"predicate"`
+}
+
+// SLSAProvenance is the SLSA v1 provenance predicate
+type SLSAProvenance struct {
+	BuildDefinition struct {
+		BuildType            string               `json:"buildType"`
+		ExternalParameters   map[string]any       `json:"externalParameters"`
+		ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID      string            `json:"id"`
+			Version map[string]string `json:"version,omitempty"`
+		} `json:"builder"`
+		Metadata struct {
+			StartedOn  time.Time `json:"startedOn"`
+			FinishedOn time.Time `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// A DSSEEnvelope wraps a signed payload as defined by the Dead Simple Signing Envelope specification
+type DSSEEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     []byte          `json:"payload"`
+	Signatures  []DSSESignature `json:"signatures"`
+}
+
+// A DSSESignature is one signature of a DSSEEnvelope
+type DSSESignature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// digestName converts a hash into the algorithm name used in in-toto digests, e.g. sha512
+func digestName(h crypto.Hash) string {
+	return strings.ToLower(strings.ReplaceAll(h.String(), "-", ""))
+}
+
+// preAuthEncoding creates the byte sequence that is actually signed in a DSSE envelope
+func preAuthEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("%s %d %s %d %s", dssePreAuthEncPrefix, len(payloadType), payloadType, len(payload), payload))
+}
+
+// NewProvenance creates a provenance statement for a package.
+// The subject is the TOC, so the statement covers all contents; every content is listed as resolved dependency
+// with the source it was added from.
+func NewProvenance(signatures *FileSignatures, sources map[string]string, parameters map[string]any, started time.Time) *ProvenanceStatement {
+	algo := digestName(hashFunc)
+	toc := hashFunc.New()
+	toc.Write(signatures.Bytes())
+	statement := &ProvenanceStatement{
+		Type: InTotoStatementType,
+		Subject: []ResourceDescriptor{{
+			Name:   TocFileName,
+			Digest: map[string]string{algo: hex.EncodeToString(toc.Sum(nil))},
+		}},
+		PredicateType: SLSAProvenanceType,
+	}
+	names := make([]string, 0, len(*signatures))
+	for name := range *signatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	definition := &statement.Predicate.BuildDefinition
+	definition.BuildType = SealpackBuildType
+	definition.ExternalParameters = parameters
+	definition.ResolvedDependencies = make([]ResourceDescriptor, 0, len(names))
+	for _, name := range names {
+		definition.ResolvedDependencies = append(definition.ResolvedDependencies, ResourceDescriptor{
+			Name:   name,
+			URI:    sources[name],
+			Digest: map[string]string{algo: hex.EncodeToString([]byte((*signatures)[name]))},
+		})
+	}
+	run := &statement.Predicate.RunDetails
+	run.Builder.ID = SealpackBuilderID
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Path == sealpackModulePath {
+		run.Builder.Version = map[string]string{sealpackModulePath: info.Main.Version}
+	}
+	run.Metadata.StartedOn = started.UTC()
+	run.Metadata.FinishedOn = time.Now().UTC()
+	return statement
+}
+
+// AddProvenance signs a provenance statement for all contents of the WriteArchive as DSSE envelope and adds it.
+// It must be called after all contents have been added.
+func (arc *WriteArchive) AddProvenance(privateKeyPath string, signatures *FileSignatures, parameters map[string]any, started time.Time) error {
+	signer, err := CreateSigner(privateKeyPath)
+	if err != nil {
+		return fmt.Errorf("seal: could not create signer: %v", err)
+	}
+	payload, err := json.Marshal(NewProvenance(signatures, arc.sources, parameters, started))
+	if err != nil {
+		return err
+	}
+	sig, err := signer.SignMessage(bytes.NewReader(preAuthEncoding(InTotoPayloadType, payload)), options.NoOpOptionImpl{})
+	if err != nil {
+		return fmt.Errorf("seal: failed signing provenance: %v", err)
+	}
+	envelope, err := json.MarshalIndent(&DSSEEnvelope{
+		PayloadType: InTotoPayloadType,
+		Payload:     payload,
+		Signatures:  []DSSESignature{{Sig: sig}},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return arc.AddToArchive(ProvenanceFileName, append(envelope, '\n'))
+}
+
+// ReadProvenance searches the archive for the signed provenance statement and returns its DSSE envelope.
+func (arc *ReadArchive) ReadProvenance() ([]byte, error) {
+	for {
+		h, err := arc.TarReader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("package contains no provenance")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Name == ProvenanceFileName {
+			return io.ReadAll(arc.TarReader)
+		}
+	}
+}
+
+// VerifyProvenance checks the signature of a DSSE envelope with the public key of the signing entity
+// and returns the contained provenance statement.
+func VerifyProvenance(envelope []byte, publicKeyPath string) (*ProvenanceStatement, error) {
+	dsse := &DSSEEnvelope{}
+	if err := json.Unmarshal(envelope, dsse); err != nil {
+		return nil, fmt.Errorf("invalid provenance: %w", err)
+	}
+	if dsse.PayloadType != InTotoPayloadType {
+		return nil, fmt.Errorf("invalid provenance payload type '%s'", dsse.PayloadType)
+	}
+	verifier, err := CreateVerifier(publicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	valid := false
+	for _, sig := range dsse.Signatures {
+		if verifier.VerifySignature(bytes.NewReader(sig.Sig), bytes.NewReader(preAuthEncoding(dsse.PayloadType, dsse.Payload))) == nil {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("%w: provenance signature is invalid", ErrBadSignature)
+	}
+	statement := &ProvenanceStatement{}
+	if err = json.Unmarshal(dsse.Payload, statement); err != nil {
+		return nil, fmt.Errorf("invalid provenance statement: %w", err)
+	}
+	return statement, nil
+}
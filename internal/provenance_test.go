@@ -0,0 +1,86 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// addTestProvenance adds a provenance statement signed with the test key to an archive sealed by sealTestArchive
+func addTestProvenance(t *testing.T) func(arc *WriteArchive, sig *FileSignatures) {
+	return func(arc *WriteArchive, sig *FileSignatures) {
+		parameters := map[string]any{"files": []string{arc.sources["data.txt"]}}
+		assert.NoError(t, arc.AddProvenance("../test/private.pem", sig, parameters, time.Now()))
+	}
+}
+
+func TestProvenance_RoundTrip(t *testing.T) {
+	arc := sealTestArchive(t, map[string]string{"data.txt": "some data"}, addTestProvenance(t))
+	file := arc.sources["data.txt"]
+	envelope, err := openTestArchive(t, arc).ReadProvenance()
+	assert.NoError(t, err)
+
+	statement, err := VerifyProvenance(envelope, "../test/public.pem")
+	assert.NoError(t, err)
+	assert.Equal(t, InTotoStatementType, statement.Type)
+	assert.Equal(t, SLSAProvenanceType, statement.PredicateType)
+	assert.Len(t, statement.Subject, 1)
+	assert.Equal(t, TocFileName, statement.Subject[0].Name)
+	assert.Len(t, statement.Subject[0].Digest["sha256"], 64)
+	deps := statement.Predicate.BuildDefinition.ResolvedDependencies
+	assert.Len(t, deps, 1)
+	assert.Equal(t, "data.txt", deps[0].Name)
+	assert.Equal(t, file, deps[0].URI)
+	// sha256 of "some data"
+	assert.Equal(t, "1307990e6ba5ca145eb35e99182a9bec46531bc54ddf656a602c780fa0240dee", deps[0].Digest["sha256"])
+	assert.Equal(t, SealpackBuilderID, statement.Predicate.RunDetails.Builder.ID)
+}
+
+func TestProvenance_Tampered(t *testing.T) {
+	arc := sealTestArchive(t, map[string]string{"data.txt": "some data"}, addTestProvenance(t))
+	envelope, err := openTestArchive(t, arc).ReadProvenance()
+	assert.NoError(t, err)
+	dsse := &DSSEEnvelope{}
+	assert.NoError(t, json.Unmarshal(envelope, dsse))
+	dsse.Payload = bytes.Replace(dsse.Payload, []byte("data.txt"), []byte("evil.txt"), 1)
+	tampered, err := json.Marshal(dsse)
+	assert.NoError(t, err)
+	_, err = VerifyProvenance(tampered, "../test/public.pem")
+	assert.ErrorIs(t, err, ErrBadSignature)
+}
+
+func TestProvenance_Unpack(t *testing.T) {
+	arc := sealTestArchive(t, map[string]string{"data.txt": "some data"}, addTestProvenance(t))
+	out := t.TempDir()
+	assert.NoError(t, openTestArchive(t, arc).Unpack("../test/public.pem", "SHA256", out, "", ""))
+	assert.FileExists(t, filepath.Join(out, "data.txt"))
+	assert.NoFileExists(t, filepath.Join(out, ProvenanceFileName))
+
+	headers, err := openTestArchive(t, arc).List()
+	assert.NoError(t, err)
+	assert.Len(t, headers, 1)
+}
+
+func TestReadProvenance_Missing(t *testing.T) {
+	arc := createSmallFilesArchive(t, 1, false)
+	defer arc.Cleanup()
+	_, err := openTestArchive(t, arc).ReadProvenance()
+	assert.ErrorContains(t, err, "no provenance")
+}
@@ -0,0 +1,224 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProvidedEntry is an entry of an archive provided by a ContentProvider.
+// Contents are only opened when the entry is added, so duplicates are skipped before anything is read or pulled.
+type ProvidedEntry struct {
+	// Name of the entry inside the archive, always using slashes
+	Name string
+	// Source describes where the contents originate from, e.g. a path or an image reference
+	Source string
+	// Open provides the contents. The file is closed once it has been added to the archive.
+	Open func() (*os.File, error)
+	// Size provides the size of the contents for planning a seal, and whether it is only estimated; optional
+	Size func() (size int64, estimated bool, err error)
+	// Mode are the permission bits stored for the entry, 0755 if zero
+	Mode os.FileMode
+	// Origin records the build input of the contents, if origins are recorded; set by Open for pulled images
+	Origin *EntryOrigin
+	// Local marks contents opened without downloading them, so they can be sampled when estimating a seal
+	Local bool
+	// Link is the target of a symbolic link the entry was found as, handled by the symlink policy of the archive
+	Link string
+}
+
+// ContentProvider resolves a source of contents, like a file glob or a container image, to entries of an archive
+type ContentProvider interface {
+	Resolve() ([]ProvidedEntry, error)
+}
+
+// ContentProviderFactory creates the ContentProvider for a content specification, e.g. a path or a URL
+type ContentProviderFactory func(spec string) (ContentProvider, error)
+
+// contentProviders are the registered factories by the prefix of the specifications they handle
+var contentProviders = map[string]ContentProviderFactory{
+	"http://":  newHTTPProvider,
+	"https://": newHTTPProvider,
+}
+
+// RegisterContentProvider registers a factory for all content specifications starting with prefix, e.g. "https://".
+// Specifications without a registered prefix are resolved as files, directories or globs.
+func RegisterContentProvider(prefix string, factory ContentProviderFactory) {
+	contentProviders[prefix] = factory
+}
+
+// contentProviderPrefix provides the longest registered prefix of a content specification, or an empty string
+func contentProviderPrefix(spec string) string {
+	longest := ""
+	for prefix := range contentProviders {
+		if strings.HasPrefix(spec, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	return longest
+}
+
+// HasContentProvider checks whether a content specification is resolved by a registered provider instead of as file
+func HasContentProvider(spec string) bool {
+	return contentProviderPrefix(spec) != ""
+}
+
+// NewContentProvider creates the provider of a content specification, choosing the longest registered prefix
+func NewContentProvider(spec string) (ContentProvider, error) {
+	if prefix := contentProviderPrefix(spec); prefix != "" {
+		return contentProviders[prefix](spec)
+	}
+	return FileProvider(spec), nil
+}
+
+// ContentProviders creates the providers for files and images in the order their contents are added.
+// The HTTP header is sent with all downloads of HTTP sources.
+func ContentProviders(files []string, images []*ContainerImage, imageSignatures bool, httpHeader http.Header, ctx context.Context) ([]ContentProvider, error) {
+	providers := make([]ContentProvider, 0, len(files)+len(images))
+	for _, spec := range files {
+		provider, err := NewContentProvider(spec)
+		if err != nil {
+			return nil, err
+		}
+		if download, ok := provider.(*HTTPProvider); ok {
+			download.Header = httpHeader
+		}
+		providers = append(providers, provider)
+	}
+	for _, img := range images {
+		providers = append(providers, &ImageProvider{Image: img, Signatures: imageSignatures, Context: ctx})
+	}
+	return providers, nil
+}
+
+// FileProvider provides a file, the files of a directory or the files matching a glob
+type FileProvider string
+
+// Resolve expands the path to the files it refers to
+func (p FileProvider) Resolve() ([]ProvidedEntry, error) {
+	resolved, err := ResolveFiles([]string{string(p)})
+	if err != nil {
+		return nil, err
+	}
+	return fileEntries(resolved), nil
+}
+
+// fileEntries provides the entries of files resolved on disk
+func fileEntries(resolved []ResolvedFile) []ProvidedEntry {
+	entries := make([]ProvidedEntry, len(resolved))
+	for i, file := range resolved {
+		var mode os.FileMode
+		if info, err := os.Stat(file.Path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		entries[i] = ProvidedEntry{
+			Name:   file.Name,
+			Source: file.Path,
+			Open: func() (*os.File, error) {
+				f, err := os.Open(file.Path)
+				if err != nil {
+					return nil, fmt.Errorf("failed reading file: %v", err)
+				}
+				return f, nil
+			},
+			Size: func() (int64, bool, error) {
+				info, err := os.Stat(file.Path)
+				if err != nil {
+					return 0, false, fmt.Errorf("failed reading file: %v", err)
+				}
+				return info.Size(), false, nil
+			},
+			Mode:   mode,
+			Origin: &EntryOrigin{Path: file.Path},
+			Local:  true,
+			Link:   file.Link,
+		}
+	}
+	return entries
+}
+
+// ImageProvider provides a container image pulled from its registry or read from a staging directory, preceded by its cosign signatures if requested
+type ImageProvider struct {
+	Image *ContainerImage
+	// Signatures adds the signature bundle of the image, so it can be verified before the image is imported
+	Signatures bool
+	// Context carries the trace pulling is recorded in, may be nil
+	Context context.Context
+	// Source configures staged images and the layer cache
+	Source ImageSource
+}
+
+// Resolve provides the entries of the image; nothing is pulled before they are opened
+func (p *ImageProvider) Resolve() ([]ProvidedEntry, error) {
+	origin := &EntryOrigin{}
+	image := ProvidedEntry{
+		Name:   p.Image.ToFileName(),
+		Source: p.Image.String(),
+		Open: func() (f *os.File, err error) {
+			_, end := StartPhase(p.Context, PhasePull, attribute.String("image", p.Image.String()))
+			f, origin.ImageDigest, err = saveImage(p.Image, p.Source)
+			end(err)
+			if err != nil {
+				return nil, fmt.Errorf("failed reading image: %v", err)
+			}
+			return f, nil
+		},
+		Origin: origin,
+		Size: func() (int64, bool, error) {
+			size, err := EstimateImageSize(p.Image, p.Source)
+			if err != nil {
+				return 0, true, fmt.Errorf("failed reading image manifest of %s: %w", p.Image.String(), err)
+			}
+			return size, true, nil
+		},
+	}
+	if !p.Signatures {
+		return []ProvidedEntry{image}, nil
+	}
+	bundle := ProvidedEntry{
+		Name:   signatureBundleName(p.Image),
+		Source: p.Image.String(),
+		Open:   p.openSignatureBundle,
+	}
+	return []ProvidedEntry{bundle, image}, nil
+}
+
+// openSignatureBundle fetches the signatures of the image and stores them next to the pulled images,
+// so they are removed by CleanupImages
+func (p *ImageProvider) openSignatureBundle() (*os.File, error) {
+	bundle, err := FetchSignatureBundle(p.Image)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Join(os.TempDir(), TmpFolderName, localFileName(signatureBundleName(p.Image)))
+	if err = os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(name, contents, 0600); err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
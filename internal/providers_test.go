@@ -0,0 +1,129 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// staticProvider provides the same file under fixed names and counts how often it is opened
+type staticProvider struct {
+	file   string
+	names  []string
+	opened int
+}
+
+func (p *staticProvider) Resolve() ([]ProvidedEntry, error) {
+	entries := make([]ProvidedEntry, len(p.names))
+	for i, name := range p.names {
+		entries[i] = ProvidedEntry{Name: name, Source: "static:" + name, Open: func() (*os.File, error) {
+			p.opened++
+			return os.Open(p.file)
+		}}
+	}
+	return entries, nil
+}
+
+// registerTestProvider registers a factory for the test only
+func registerTestProvider(t *testing.T, prefix string, factory ContentProviderFactory) {
+	RegisterContentProvider(prefix, factory)
+	t.Cleanup(func() { delete(contentProviders, prefix) })
+}
+
+func TestNewContentProvider(t *testing.T) {
+	registerTestProvider(t, "mem://", func(spec string) (ContentProvider, error) {
+		return &staticProvider{names: []string{"short"}}, nil
+	})
+	registerTestProvider(t, "mem://long/", func(spec string) (ContentProvider, error) {
+		return &staticProvider{names: []string{"long"}}, nil
+	})
+	registerTestProvider(t, "fail://", func(spec string) (ContentProvider, error) {
+		return nil, fmt.Errorf("unsupported %s", spec)
+	})
+
+	provider, err := NewContentProvider("mem://long/file")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"long"}, provider.(*staticProvider).names)
+	provider, err = NewContentProvider("mem://other")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"short"}, provider.(*staticProvider).names)
+	provider, err = NewContentProvider("some/path/*.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, FileProvider("some/path/*.txt"), provider)
+	_, err = NewContentProvider("fail://x")
+	assert.ErrorContains(t, err, "unsupported fail://x")
+}
+
+func TestFileProvider_Resolve(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "foo.txt"), []byte("foo"), 0644))
+	entries, err := FileProvider(filepath.Join(dir, "*.txt")).Resolve()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "foo.txt", entries[0].Name)
+	assert.Equal(t, filepath.Join(dir, "foo.txt"), entries[0].Source)
+	size, estimated, err := entries[0].Size()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+	assert.False(t, estimated)
+}
+
+func TestImageProvider_Resolve(t *testing.T) {
+	img := ParseContainerImage("alpine:3.18")
+	entries, err := (&ImageProvider{Image: img}).Resolve()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, img.ToFileName(), entries[0].Name)
+
+	// The signature bundle precedes the image, so it can be verified before importing
+	entries, err = (&ImageProvider{Image: img, Signatures: true}).Resolve()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, signatureBundleName(img), entries[0].Name)
+	assert.Equal(t, img.ToFileName(), entries[1].Name)
+	assert.Nil(t, entries[0].Size)
+}
+
+func TestAddProviders(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "foo.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("foo"), 0644))
+	registerTestProvider(t, "static://", func(spec string) (ContentProvider, error) {
+		return &staticProvider{file: src, names: []string{"a.txt", "b.txt"}}, nil
+	})
+
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	sig := NewSignatureList("SHA256")
+	assert.NoError(t, arc.AddContents([]string{"static://any", src}, nil, sig))
+	assert.Equal(t, map[string]string{"a.txt": "static:a.txt", "b.txt": "static:b.txt", "foo.txt": src}, arc.sources)
+
+	// Duplicates are skipped before their contents are opened
+	provider := &staticProvider{file: src, names: []string{"a.txt", "a.txt"}}
+	arc = CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.Duplicates = DuplicatesSkip
+	assert.NoError(t, arc.AddProviders([]ContentProvider{provider}, NewSignatureList("SHA256")))
+	assert.Equal(t, 1, provider.opened)
+}
+
+func TestPlanProviders_UnknownSize(t *testing.T) {
+	plan, err := PlanProviders([]ContentProvider{&staticProvider{names: []string{"a.txt"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []*PlannedEntry{{Name: "a.txt", Source: "static:a.txt", Estimated: true}}, plan)
+}
@@ -0,0 +1,132 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"github.com/innomotics/sealpack/internal/aws"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var s3DeleteObject = aws.S3DeleteObject
+
+// RetentionPolicy decides which packages of a repository are kept.
+// The most recent package of each channel is always kept, so devices still find the current release.
+type RetentionPolicy struct {
+	// KeepLast is the number of most recently modified packages kept per channel, all if 0
+	KeepLast int
+	// MaxAge is the age after which packages are removed, none if 0
+	MaxAge time.Duration
+}
+
+// Validate checks the policy for at least one valid rule
+func (p RetentionPolicy) Validate() error {
+	if p.KeepLast < 0 || p.MaxAge < 0 {
+		return fmt.Errorf("invalid retention policy: keep-last and max-age must not be negative")
+	}
+	if p.KeepLast == 0 && p.MaxAge == 0 {
+		return WithHint(errors.New("no retention rule given"), "set the number of packages to keep or their maximum age")
+	}
+	return nil
+}
+
+// PrunedPackage is a package removed by Prune
+type PrunedPackage struct {
+	Location string
+	// Channel groups the versions of a package, formed by its directory and name, e.g. stable/app
+	Channel  string
+	Version  string
+	Modified time.Time
+}
+
+// Prune removes the packages of a directory, S3 prefix or SFTP directory not retained by the policy.
+// Packages are only listed if dryRun is set. Files which are no packages are never removed.
+func Prune(source string, policy RetentionPolicy, dryRun bool) ([]PrunedPackage, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if isHTTP(source) {
+		return nil, fmt.Errorf("cannot list the packages of %s, prune a local directory, s3:// or sftp:// location", source)
+	}
+	files, err := listPackages(source)
+	if err != nil {
+		return nil, err
+	}
+	channels := map[string][]PrunedPackage{}
+	for _, file := range files {
+		src, err := OpenRangeSource(file.location, nil)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := isPackage(src)
+		_ = src.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read package %s: %w", file.location, err)
+		}
+		if !ok {
+			continue
+		}
+		name, version := ParsePackageName(path.Base(file.rel))
+		channel := path.Join(path.Dir(file.rel), name)
+		channels[channel] = append(channels[channel], PrunedPackage{Location: file.location, Channel: channel, Version: version, Modified: file.modified})
+	}
+	var pruned []PrunedPackage
+	now := time.Now()
+	for _, pkgs := range channels {
+		sort.SliceStable(pkgs, func(i, j int) bool {
+			if !pkgs[i].Modified.Equal(pkgs[j].Modified) {
+				return pkgs[i].Modified.After(pkgs[j].Modified)
+			}
+			return pkgs[i].Version > pkgs[j].Version
+		})
+		for i, pkg := range pkgs[1:] {
+			if (policy.KeepLast > 0 && i+1 >= policy.KeepLast) || (policy.MaxAge > 0 && now.Sub(pkg.Modified) > policy.MaxAge) {
+				pruned = append(pruned, pkg)
+			}
+		}
+	}
+	sort.Slice(pruned, func(i, j int) bool { return pruned[i].Location < pruned[j].Location })
+	if dryRun {
+		return pruned, nil
+	}
+	for i, pkg := range pruned {
+		if err = removeLocation(pkg.Location); err != nil {
+			return pruned[:i], fmt.Errorf("cannot remove %s: %w", pkg.Location, err)
+		}
+	}
+	return pruned, nil
+}
+
+// removeLocation deletes a local file, S3 object or file on an SFTP server
+func removeLocation(location string) error {
+	lower := strings.ToLower(location)
+	switch {
+	case strings.HasPrefix(lower, aws.S3UriPrefix):
+		return wrapNetworkError(s3DeleteObject(location))
+	case strings.HasPrefix(lower, SFTPUriPrefix):
+		client, p, err := dialSFTP(location)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		return client.Remove(p)
+	}
+	return os.Remove(location)
+}
@@ -0,0 +1,115 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/innomotics/sealpack/internal/aws"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ageGolden copies a golden package into a repository, modified the given number of days ago
+func ageGolden(t *testing.T, fixture, target string, days int) {
+	copyGolden(t, fixture, target)
+	modified := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	assert.NoError(t, os.Chtimes(target, modified, modified))
+}
+
+func TestPrune(t *testing.T) {
+	repo := t.TempDir()
+	for i, version := range []string{"1.4.0", "1.3.0", "1.2.0", "1.1.0"} {
+		ageGolden(t, "v5-sealed", filepath.Join(repo, "stable", "app-"+version+".ipc"), 10*i)
+	}
+	ageGolden(t, "public", filepath.Join(repo, "stable", "debug-0.1.ipc"), 100)
+	ageGolden(t, "v5-sealed", filepath.Join(repo, "beta", "app-1.5.0.ipc"), 1)
+	ageGolden(t, "v5-sealed", filepath.Join(repo, "beta", "app-1.4.0.ipc"), 50)
+	assert.NoError(t, os.WriteFile(filepath.Join(repo, "catalog.json"), []byte("{}"), 0644))
+
+	pruned, err := Prune(repo, RetentionPolicy{KeepLast: 2}, true)
+	assert.NoError(t, err)
+	assert.Len(t, pruned, 2)
+	assert.Equal(t, filepath.Join(repo, "stable", "app-1.1.0.ipc"), pruned[0].Location)
+	assert.Equal(t, "stable/app", pruned[0].Channel)
+	assert.Equal(t, "1.1.0", pruned[0].Version)
+	assert.FileExists(t, pruned[0].Location)
+
+	pruned, err = Prune(repo, RetentionPolicy{KeepLast: 3, MaxAge: 15 * 24 * time.Hour}, false)
+	assert.NoError(t, err)
+	var removed []string
+	for _, pkg := range pruned {
+		removed = append(removed, pkg.Location)
+		assert.NoFileExists(t, pkg.Location)
+	}
+	assert.Equal(t, []string{
+		filepath.Join(repo, "beta", "app-1.4.0.ipc"),
+		filepath.Join(repo, "stable", "app-1.1.0.ipc"),
+		filepath.Join(repo, "stable", "app-1.2.0.ipc"),
+	}, removed)
+	// The current release of each channel is kept regardless of its age
+	assert.FileExists(t, filepath.Join(repo, "stable", "debug-0.1.ipc"))
+	assert.FileExists(t, filepath.Join(repo, "catalog.json"))
+
+	_, err = Prune(repo, RetentionPolicy{}, false)
+	assert.ErrorContains(t, err, "no retention rule")
+	_, err = Prune(repo, RetentionPolicy{KeepLast: -1}, false)
+	assert.Error(t, err)
+}
+
+func TestPrune_S3(t *testing.T) {
+	data, err := os.ReadFile(goldenPackage("v5-sealed"))
+	assert.NoError(t, err)
+	tmpList, tmpSize, tmpRange, tmpDelete := s3ListObjects, s3ObjectSize, s3DownloadRange, s3DeleteObject
+	defer func() {
+		s3ListObjects, s3ObjectSize, s3DownloadRange, s3DeleteObject = tmpList, tmpSize, tmpRange, tmpDelete
+	}()
+	now := time.Now()
+	s3ListObjects = func(string) ([]aws.S3Object, error) {
+		return []aws.S3Object{
+			{URI: "s3://ci/builds/app-100.ipc", Modified: now.Add(-time.Hour)},
+			{URI: "s3://ci/builds/app-101.ipc", Modified: now},
+			{URI: "s3://ci/builds/app-99.ipc", Modified: now.Add(-2 * time.Hour)},
+		}, nil
+	}
+	s3ObjectSize = func(string) (int64, error) { return int64(len(data)), nil }
+	s3DownloadRange = func(_ string, offset, length int64) ([]byte, error) {
+		return data[offset : offset+length], nil
+	}
+	var deleted []string
+	s3DeleteObject = func(uri string) error {
+		deleted = append(deleted, uri)
+		return nil
+	}
+	pruned, err := Prune("s3://ci/builds/", RetentionPolicy{KeepLast: 1}, false)
+	assert.NoError(t, err)
+	assert.Len(t, pruned, 2)
+	assert.Equal(t, []string{"s3://ci/builds/app-100.ipc", "s3://ci/builds/app-99.ipc"}, deleted)
+}
+
+func TestPrune_SFTP(t *testing.T) {
+	root := t.TempDir()
+	addr := startSSHServer(t, root)
+	ageGolden(t, "v5-sealed", filepath.Join(root, "repo", "app-2.ipc"), 0)
+	ageGolden(t, "v5-sealed", filepath.Join(root, "repo", "app-1.ipc"), 30)
+
+	pruned, err := Prune("sftp://"+addr+"/repo", RetentionPolicy{MaxAge: 24 * time.Hour}, false)
+	assert.NoError(t, err)
+	assert.Len(t, pruned, 1)
+	assert.Equal(t, "sftp://"+addr+"/repo/app-1.ipc", pruned[0].Location)
+	assert.NoFileExists(t, filepath.Join(root, "repo", "app-1.ipc"))
+	assert.FileExists(t, filepath.Join(root, "repo", "app-2.ipc"))
+}
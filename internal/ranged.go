@@ -0,0 +1,144 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"github.com/innomotics/sealpack/internal/aws"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// rangeBlockSize is the minimum number of bytes fetched from remote storage per request
+const rangeBlockSize = 64 << 10
+
+var s3ObjectSize = aws.S3ObjectSize
+var s3DownloadRange = aws.S3DownloadRange
+
+// RangeSource provides random access to a package in a local file, an S3 bucket or on an HTTP or SFTP server
+type RangeSource interface {
+	io.ReaderAt
+	io.Closer
+	// Size is the size of the package in bytes
+	Size() int64
+}
+
+// OpenRangeSource opens a package for ranged reads. Remote packages are fetched in blocks on demand,
+// the header is sent with every HTTP request.
+func OpenRangeSource(uri string, header http.Header) (RangeSource, error) {
+	lower := strings.ToLower(uri)
+	switch {
+	case strings.HasPrefix(lower, aws.S3UriPrefix):
+		size, err := s3ObjectSize(uri)
+		if err != nil {
+			return nil, wrapNetworkError(err)
+		}
+		return &blockRange{size: size, fetch: func(offset, length int64) ([]byte, error) {
+			return s3DownloadRange(uri, offset, length)
+		}}, nil
+	case strings.HasPrefix(lower, SFTPUriPrefix):
+		return openSFTPRange(uri)
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"):
+		provider := &HTTPProvider{URL: uri, Header: header}
+		resp, err := provider.request(http.MethodHead)
+		if err != nil {
+			return nil, err
+		}
+		_ = resp.Body.Close()
+		if resp.ContentLength < 0 {
+			return nil, fmt.Errorf("server does not provide the size of %s, which is required for ranged reads", resp.Request.URL.Redacted())
+		}
+		return &blockRange{size: resp.ContentLength, fetch: provider.fetchRange}, nil
+	}
+	f, err := os.Open(uri)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &fileRange{File: f, size: info.Size()}, nil
+}
+
+// fileRange is a local package
+type fileRange struct {
+	*os.File
+	size int64
+}
+
+// Size implements RangeSource
+func (f *fileRange) Size() int64 {
+	return f.size
+}
+
+// blockRange fetches blocks of a remote package, keeping the last one for the many small reads of decompression
+type blockRange struct {
+	size   int64
+	fetch  func(offset, length int64) ([]byte, error)
+	offset int64
+	block  []byte
+}
+
+// Size implements RangeSource
+func (b *blockRange) Size() int64 {
+	return b.size
+}
+
+// Close implements io.Closer
+func (b *blockRange) Close() error {
+	b.block = nil
+	return nil
+}
+
+// ReadAt implements io.ReaderAt
+func (b *blockRange) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= b.size {
+			return n, io.EOF
+		}
+		if pos < b.offset || pos >= b.offset+int64(len(b.block)) {
+			length := min(max(int64(len(p)-n), rangeBlockSize), b.size-pos)
+			block, err := b.fetch(pos, length)
+			if err != nil {
+				return n, wrapNetworkError(err)
+			}
+			if int64(len(block)) != length {
+				return n, fmt.Errorf("expected %d bytes at %d, got %d", length, pos, len(block))
+			}
+			b.offset, b.block = pos, block
+		}
+		n += copy(p[n:], b.block[pos-b.offset:])
+	}
+	return n, nil
+}
+
+// fetchRange downloads length bytes starting at offset
+func (p *HTTPProvider) fetchRange(offset, length int64) ([]byte, error) {
+	resp, err := p.send(http.MethodGet, fmt.Sprintf("bytes=%d-%d", offset, offset+length-1), http.StatusPartialContent)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return io.ReadAll(io.LimitReader(resp.Body, length+1))
+}
@@ -0,0 +1,116 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/innomotics/sealpack/format"
+)
+
+// recipientHintLength is the length of a recipient hint, the raw SHA-256 fingerprint of the recipient key
+const recipientHintLength = format.RecipientHintLength
+
+// AddRecipientHints records the fingerprints of the recipient keys, so inspect can show whom a package is sealed for.
+// The hints are stored in front of the recipient key entries and need EnvelopeVersion4 or newer to be flagged in the header.
+func AddRecipientHints(recipients []RecipientKey, envelope *Envelope) error {
+	if envelope.Version < EnvelopeVersion4 {
+		return fmt.Errorf("recipient hints require envelope version %d or newer", EnvelopeVersion4)
+	}
+	envelope.RecipientHints = make([][]byte, len(recipients))
+	for i, recipient := range recipients {
+		fingerprint, err := Fingerprint(recipient.Key)
+		if err != nil {
+			return err
+		}
+		if envelope.RecipientHints[i], err = hex.DecodeString(fingerprint[len("sha256:"):]); err != nil {
+			return err
+		}
+	}
+	envelope.Flags |= EnvelopeFlagRecipientHints
+	return nil
+}
+
+// HasRecipientHints determines whether the recipient key entries are prefixed with the fingerprints of their keys
+func (e *Envelope) HasRecipientHints() bool {
+	return e.Version >= EnvelopeVersion4 && e.Flags&EnvelopeFlagRecipientHints != 0
+}
+
+// keyEntries provides the key entries as stored in the envelope, with recipient hints prepended if flagged
+func (e *Envelope) keyEntries() [][]byte {
+	if !e.HasRecipientHints() {
+		return e.ReceiverKeys
+	}
+	offset := len(e.ReceiverKeys) - len(e.recipientKeys())
+	entries := make([][]byte, len(e.ReceiverKeys))
+	for i, key := range e.ReceiverKeys {
+		if i < offset {
+			entries[i] = key
+		} else {
+			entries[i] = append(append([]byte{}, e.RecipientHints[i-offset]...), key...)
+		}
+	}
+	return entries
+}
+
+// splitRecipientHints separates the recipient hints from the key entries read from an envelope
+func (e *Envelope) splitRecipientHints() error {
+	if !e.HasRecipientHints() {
+		return nil
+	}
+	offset := len(e.ReceiverKeys) - len(e.recipientKeys())
+	e.RecipientHints = make([][]byte, 0, len(e.ReceiverKeys)-offset)
+	for i := offset; i < len(e.ReceiverKeys); i++ {
+		if len(e.ReceiverKeys[i]) <= recipientHintLength {
+			return fmt.Errorf("key entry %d is too short for a recipient hint", i+1)
+		}
+		e.RecipientHints = append(e.RecipientHints, e.ReceiverKeys[i][:recipientHintLength])
+		e.ReceiverKeys[i] = e.ReceiverKeys[i][recipientHintLength:]
+	}
+	return nil
+}
+
+// RecipientFingerprints lists the fingerprints of all recipient keys known from hints
+func (e *Envelope) RecipientFingerprints() []string {
+	fingerprints := make([]string, len(e.RecipientHints))
+	for i, hint := range e.RecipientHints {
+		fingerprints[i] = "sha256:" + hex.EncodeToString(hint)
+	}
+	return fingerprints
+}
+
+// keyDescriptions describes every key entry with its length, wrapping algorithm and recipient, if known
+func (e *Envelope) keyDescriptions() []string {
+	offset := len(e.ReceiverKeys) - len(e.recipientKeys())
+	fingerprints := e.RecipientFingerprints()
+	descriptions := make([]string, len(e.ReceiverKeys))
+	for i, key := range e.ReceiverKeys {
+		if i < offset {
+			descriptions[i] = fmt.Sprintf("Key %d: %d Bytes, ChaCha20-Poly1305 with HKDF-SHA256 derived keys of %d fleet devices", i+1, len(key), fleetDeviceCount(key))
+			continue
+		}
+		if curve, ok := hybridEntryCurves[len(key)]; ok {
+			descriptions[i] = fmt.Sprintf("Key %d: %d Bytes, XChaCha20-Poly1305 with %s ephemeral key agreement", i+1, len(key), curve)
+		} else if keyID, ok := openPGPEntryKeyID(key); ok {
+			descriptions[i] = fmt.Sprintf("Key %d: %d Bytes, OpenPGP message for key ID %016X", i+1, len(key), keyID)
+		} else {
+			descriptions[i] = fmt.Sprintf("Key %d: %d Bytes, RSA PKCS#1 v1.5 (%d Bit)", i+1, len(key), len(key)*8)
+		}
+		if i-offset < len(fingerprints) {
+			descriptions[i] += ", recipient " + fingerprints[i-offset]
+		}
+	}
+	return descriptions
+}
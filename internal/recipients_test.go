@@ -0,0 +1,114 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sealTestEnvelope creates a v4 envelope sealed for the test public keys
+func sealTestEnvelope(t *testing.T, contents string, hints bool, fleetKey string) *Envelope {
+	encrypted := &bytes.Buffer{}
+	plainKey, w := EncryptWriter(encrypted)
+	_, err := w.Write([]byte(contents))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	payloadFile := filepath.Join(t.TempDir(), "payload")
+	assert.NoError(t, os.WriteFile(payloadFile, encrypted.Bytes(), 0644))
+
+	recipients, err := LoadRecipientKeys([]string{filepath.Join(TestFilePath, "public.pem"), filepath.Join(TestFilePath, "pkcs1-public.pem")})
+	assert.NoError(t, err)
+	envelope := &Envelope{Version: EnvelopeVersion4, PayloadLen: int64(encrypted.Len()), HashAlgorithm: crypto.SHA256}
+	assert.NoError(t, AddKeys(recipients, envelope, []byte(plainKey)))
+	if hints {
+		assert.NoError(t, AddRecipientHints(recipients, envelope))
+	}
+	if fleetKey != "" {
+		assert.NoError(t, AddFleetKey(fleetKey, "", []string{"device-1"}, envelope, []byte(plainKey)))
+	}
+	envelope.PayloadWriter, err = os.Open(payloadFile)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = envelope.PayloadWriter.Close() })
+	return envelope
+}
+
+func TestAddRecipientHints(t *testing.T) {
+	recipients, err := LoadRecipientKeys([]string{filepath.Join(TestFilePath, "public.pem")})
+	assert.NoError(t, err)
+	assert.ErrorContains(t, AddRecipientHints(recipients, &Envelope{Version: EnvelopeVersion3}), "require envelope version 4")
+
+	envelope := &Envelope{Version: EnvelopeVersion4}
+	assert.NoError(t, AddRecipientHints(recipients, envelope))
+	assert.True(t, envelope.HasRecipientHints())
+	fingerprint, err := Fingerprint(recipients[0].Key)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{fingerprint}, envelope.RecipientFingerprints())
+}
+
+func TestEnvelope_RecipientHints(t *testing.T) {
+	fleetKey := writeFleetKey(t, "0123456789abcdef0123456789abcdef")
+	sealed := sealTestEnvelope(t, "Hold your breath and count to 10.", true, fleetKey)
+	keys := sealed.ReceiverKeys
+
+	env, err := ParseEnvelope(bytes.NewReader(sealed.ToBytes()))
+	assert.NoError(t, err)
+	assert.True(t, env.HasRecipientHints())
+	assert.True(t, env.HasFleetKey())
+	assert.Equal(t, keys, env.ReceiverKeys)
+	assert.Equal(t, sealed.RecipientFingerprints(), env.RecipientFingerprints())
+
+	payload, err := env.GetPayload(filepath.Join(TestFilePath, "private.pem"))
+	assert.NoError(t, err)
+	contents, err := io.ReadAll(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hold your breath and count to 10.", string(contents))
+
+	description := env.String()
+	assert.Contains(t, description, "Envelope version 4")
+	assert.Contains(t, description, "Payload compressed using gzip")
+	assert.Contains(t, description, "Key 1: 129 Bytes, ChaCha20-Poly1305 with HKDF-SHA256 derived keys of 1 fleet devices")
+	assert.Contains(t, description, "Key 2: 512 Bytes, RSA PKCS#1 v1.5 (4096 Bit), recipient "+env.RecipientFingerprints()[0])
+	assert.Contains(t, description, "Key 3: 128 Bytes, RSA PKCS#1 v1.5 (1024 Bit), recipient "+env.RecipientFingerprints()[1])
+}
+
+func TestEnvelope_WithoutRecipientHints(t *testing.T) {
+	env, err := ParseEnvelope(bytes.NewReader(sealTestEnvelope(t, "fnord", false, "").ToBytes()))
+	assert.NoError(t, err)
+	assert.False(t, env.HasRecipientHints())
+	assert.Empty(t, env.RecipientHints)
+	assert.Contains(t, env.String(), "Key 1: 512 Bytes, RSA PKCS#1 v1.5 (4096 Bit)\n")
+}
+
+func TestGetPayload_RecipientHints(t *testing.T) {
+	sealed := sealTestEnvelope(t, "fnord", true, "")
+	sealed.ReceiverKeys = sealed.ReceiverKeys[1:]
+	sealed.RecipientHints = sealed.RecipientHints[1:]
+	env, err := ParseEnvelope(bytes.NewReader(sealed.ToBytes()))
+	assert.NoError(t, err)
+	_, err = env.GetPayload(filepath.Join(TestFilePath, "private.pem"))
+	assert.ErrorIs(t, err, ErrNotRecipient)
+	assert.Contains(t, Hints(err)[0], "but the package is sealed for "+env.RecipientFingerprints()[0])
+}
+
+func TestEnvelope_WriteKeys_HintMismatch(t *testing.T) {
+	envelope := &Envelope{Version: EnvelopeVersion4, Flags: EnvelopeFlagRecipientHints, ReceiverKeys: [][]byte{[]byte("key")}}
+	assert.ErrorContains(t, envelope.WriteKeys(&bytes.Buffer{}), "0 recipient hints for 1 recipient keys")
+}
@@ -0,0 +1,292 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sigstore/sigstore/pkg/signature/options"
+	"io"
+	"math/bits"
+	"net/http"
+	"strings"
+)
+
+const (
+	// RekorFileName is the archive entry holding the transparency log entry of the TOC signature.
+	// It is verified on its own and not part of the TOC.
+	RekorFileName = ".sealpack.rekor"
+	// DefaultRekorURL is the public Rekor instance of the sigstore project
+	DefaultRekorURL = "https://rekor.sigstore.dev"
+	// maxRekorBundleSize limits the size of the transparency log entry read from a package
+	maxRekorBundleSize = 1 << 20
+	// rekorEntriesPath is the API endpoint creating log entries
+	rekorEntriesPath = "/api/v1/log/entries"
+)
+
+// rekorHashes maps the signature hashes of the TOC to the algorithm names of Rekor
+var rekorHashes = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// RekorBundle is a Rekor log entry with everything needed to verify its inclusion offline
+type RekorBundle struct {
+	// Body is the base64 encoded canonical entry as stored in the log
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+	// SignedEntryTimestamp is the signature of the log over body, integrated time, log ID and index
+	SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+	// InclusionProof proves the entry is part of the log tree, missing for logs not providing one
+	InclusionProof *RekorInclusionProof `json:"inclusionProof,omitempty"`
+}
+
+// RekorInclusionProof is the Merkle audit path of an entry to the root of the log tree
+type RekorInclusionProof struct {
+	LogIndex   int64    `json:"logIndex"`
+	RootHash   string   `json:"rootHash"`
+	TreeSize   int64    `json:"treeSize"`
+	Hashes     []string `json:"hashes"`
+	Checkpoint string   `json:"checkpoint,omitempty"`
+}
+
+// rekorEntry is a hashedrekord entry, recording a signature over the hash of an artifact
+type rekorEntry struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+	} `json:"spec"`
+}
+
+// rekorLogEntry is a log entry as returned by the Rekor API
+type rekorLogEntry struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+	Verification   struct {
+		SignedEntryTimestamp []byte               `json:"signedEntryTimestamp"`
+		InclusionProof       *RekorInclusionProof `json:"inclusionProof"`
+	} `json:"verification"`
+}
+
+// newRekorEntry creates the hashedrekord entry of a TOC signature
+func newRekorEntry(toc, tocSignature []byte, signatureHash string, publicKey []byte) (*rekorEntry, error) {
+	algorithm := strings.ToLower(signatureHash)
+	hash, ok := rekorHashes[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("TOC signatures using %s cannot be recorded in Rekor, use SHA256, SHA384 or SHA512", signatureHash)
+	}
+	sum := hash.New()
+	sum.Write(toc)
+	entry := &rekorEntry{APIVersion: "0.0.1", Kind: "hashedrekord"}
+	entry.Spec.Signature.Content = tocSignature
+	entry.Spec.Signature.PublicKey.Content = publicKey
+	entry.Spec.Data.Hash.Algorithm = algorithm
+	entry.Spec.Data.Hash.Value = hex.EncodeToString(sum.Sum(nil))
+	return entry, nil
+}
+
+// AddRekorEntry records the signed TOC in the Rekor transparency log at rekorURL and adds the log entry with its
+// inclusion proof to the archive, so the transparency of the package can be verified offline. AddToc must be called before.
+func (arc *WriteArchive) AddRekorEntry(rekorURL, privateKeyPath string) error {
+	if arc.toc == nil {
+		return fmt.Errorf("archive has no signed TOC to record")
+	}
+	signer, err := CreateSignerWithHash(privateKeyPath, arc.tocSignatureHash)
+	if err != nil {
+		return fmt.Errorf("could not create signer: %v", err)
+	}
+	public, err := signer.PublicKey(options.NoOpOptionImpl{})
+	if err != nil {
+		return err
+	}
+	publicKey, err := EncodePublicKey(public)
+	if err != nil {
+		return err
+	}
+	entry, err := newRekorEntry(arc.toc, arc.tocSignature, arc.tocSignatureHash, publicKey)
+	if err != nil {
+		return err
+	}
+	bundle, err := uploadRekorEntry(rekorURL, entry)
+	if err != nil {
+		return err
+	}
+	arc.logger().Infof("seal: TOC signature recorded in %s at log index %d", rekorURL, bundle.LogIndex)
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return err
+	}
+	return arc.AddToArchive(RekorFileName, data)
+}
+
+// uploadRekorEntry creates a log entry and provides it as RekorBundle
+func uploadRekorEntry(rekorURL string, entry *rekorEntry) (*RekorBundle, error) {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(rekorURL, "/") + rekorEntriesPath
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, wrapNetworkError(fmt.Errorf("failed uploading to Rekor: %w", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed uploading to Rekor: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	entries := map[string]rekorLogEntry{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxRekorBundleSize)).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("invalid Rekor response: %w", err)
+	}
+	for _, logEntry := range entries {
+		return &RekorBundle{
+			Body:                 logEntry.Body,
+			IntegratedTime:       logEntry.IntegratedTime,
+			LogID:                logEntry.LogID,
+			LogIndex:             logEntry.LogIndex,
+			SignedEntryTimestamp: logEntry.Verification.SignedEntryTimestamp,
+			InclusionProof:       logEntry.Verification.InclusionProof,
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid Rekor response: no log entry")
+}
+
+// readRekorBundle reads the transparency log entry of a package, which is verified together with the TOC
+func (arc *ReadArchive) readRekorBundle() error {
+	bundle := &RekorBundle{}
+	if err := json.NewDecoder(io.LimitReader(arc.TarReader, maxRekorBundleSize)).Decode(bundle); err != nil {
+		return corruptEnvelope(fmt.Errorf("invalid Rekor entry: %w", err))
+	}
+	arc.rekorBundle = bundle
+	return nil
+}
+
+// verifyTransparency checks that the TOC signature of the package is recorded in the transparency log of the Rekor key
+func (arc *ReadArchive) verifyTransparency(toc, tocSignature []byte) error {
+	if arc.rekorBundle == nil {
+		return WithHint(fmt.Errorf("%w: package contains no Rekor entry", ErrBadSignature),
+			"seal the package with --rekor or unseal it without --rekor-key")
+	}
+	if err := arc.rekorBundle.Verify(toc, tocSignature, arc.RekorKey); err != nil {
+		return WithHint(fmt.Errorf("%w: %w", ErrBadSignature, err),
+			"the Rekor entry does not prove the TOC signature was published in the transparency log of %s", arc.RekorKey)
+	}
+	arc.logger().Infof("unseal: TOC signature recorded in the transparency log at index %d", arc.rekorBundle.LogIndex)
+	return nil
+}
+
+// Verify checks offline that the entry records the TOC signature, is signed by the log owning the public key at
+// rekorKeyPath and, if it has an inclusion proof, that it is included in the log tree
+func (b *RekorBundle) Verify(toc, tocSignature []byte, rekorKeyPath string) error {
+	body, err := base64.StdEncoding.DecodeString(b.Body)
+	if err != nil {
+		return fmt.Errorf("invalid Rekor entry body: %w", err)
+	}
+	entry := &rekorEntry{}
+	if err = json.Unmarshal(body, entry); err != nil || entry.Kind != "hashedrekord" {
+		return fmt.Errorf("Rekor entry is no hashedrekord")
+	}
+	hash, ok := rekorHashes[entry.Spec.Data.Hash.Algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported Rekor hash %s", entry.Spec.Data.Hash.Algorithm)
+	}
+	sum := hash.New()
+	sum.Write(toc)
+	if entry.Spec.Data.Hash.Value != hex.EncodeToString(sum.Sum(nil)) || !bytes.Equal(entry.Spec.Signature.Content, tocSignature) {
+		return fmt.Errorf("Rekor entry records another TOC signature")
+	}
+	// The signed entry timestamp covers the canonical JSON of these fields, sorted by name
+	payload, err := json.Marshal(struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	}{b.Body, b.IntegratedTime, b.LogID, b.LogIndex})
+	if err != nil {
+		return err
+	}
+	verifier, err := CreateVerifier(rekorKeyPath)
+	if err != nil {
+		return err
+	}
+	if err = verifier.VerifySignature(bytes.NewReader(b.SignedEntryTimestamp), bytes.NewReader(payload)); err != nil {
+		return fmt.Errorf("invalid signed entry timestamp: %w", err)
+	}
+	if b.InclusionProof != nil {
+		return b.InclusionProof.verify(body)
+	}
+	return nil
+}
+
+// verify recomputes the root of the log tree from the entry and its audit path, as defined by RFC 6962
+func (p *RekorInclusionProof) verify(body []byte) error {
+	if p.LogIndex < 0 || p.LogIndex >= p.TreeSize {
+		return fmt.Errorf("invalid inclusion proof: index %d outside tree of size %d", p.LogIndex, p.TreeSize)
+	}
+	index, size := uint64(p.LogIndex), uint64(p.TreeSize)
+	inner := bits.Len64(index ^ (size - 1))
+	border := bits.OnesCount64(index >> inner)
+	if len(p.Hashes) != inner+border {
+		return fmt.Errorf("invalid inclusion proof: %d hashes for a tree of size %d", len(p.Hashes), p.TreeSize)
+	}
+	root := merkleHash([]byte{0}, body)
+	for i, encoded := range p.Hashes {
+		node, err := hex.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("invalid inclusion proof: %w", err)
+		}
+		if i < inner && (index>>i)&1 == 0 {
+			root = merkleHash([]byte{1}, root, node)
+		} else {
+			root = merkleHash([]byte{1}, node, root)
+		}
+	}
+	if hex.EncodeToString(root) != p.RootHash {
+		return fmt.Errorf("inclusion proof does not lead to the root hash %s", p.RootHash)
+	}
+	return nil
+}
+
+// merkleHash hashes the concatenated parts with SHA-256
+func merkleHash(parts ...[]byte) []byte {
+	sum := sha256.New()
+	for _, part := range parts {
+		sum.Write(part)
+	}
+	return sum.Sum(nil)
+}
@@ -0,0 +1,127 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newRekorServer serves a log with the received entry at index 0 of a tree of size 2 and provides the path of its public key
+func newRekorServer(t *testing.T) (*httptest.Server, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	public, err := EncodePublicKey(key.Public())
+	assert.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "rekor.pub")
+	assert.NoError(t, os.WriteFile(keyPath, public, 0644))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != rekorEntriesPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		entry := rekorLogEntry{Body: base64.StdEncoding.EncodeToString(body), IntegratedTime: 1700000000, LogID: "c0d23d6a", LogIndex: 42}
+		payload, _ := json.Marshal(map[string]any{"body": entry.Body, "integratedTime": entry.IntegratedTime, "logID": entry.LogID, "logIndex": entry.LogIndex})
+		digest := sha256.Sum256(payload)
+		entry.Verification.SignedEntryTimestamp, _ = ecdsa.SignASN1(rand.Reader, key, digest[:])
+		sibling := merkleHash([]byte{0}, []byte("other entry"))
+		entry.Verification.InclusionProof = &RekorInclusionProof{
+			TreeSize: 2,
+			Hashes:   []string{hex.EncodeToString(sibling)},
+			RootHash: hex.EncodeToString(merkleHash([]byte{1}, merkleHash([]byte{0}, body), sibling)),
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]rekorLogEntry{"24296fb24b8ad77a": entry})
+	}))
+	t.Cleanup(server.Close)
+	return server, keyPath
+}
+
+// addTestRekorEntry records the TOC signature of an archive sealed by sealTestArchive in the log of the server
+func addTestRekorEntry(t *testing.T, server *httptest.Server) func(arc *WriteArchive, sig *FileSignatures) {
+	return func(arc *WriteArchive, _ *FileSignatures) {
+		assert.NoError(t, arc.AddRekorEntry(server.URL, "../test/private.pem"))
+	}
+}
+
+func TestWriteArchive_AddRekorEntry(t *testing.T) {
+	server, rekorKey := newRekorServer(t)
+	arc := sealTestArchive(t, map[string]string{"foo": "foo"}, addTestRekorEntry(t, server))
+
+	out := t.TempDir()
+	ra := openTestArchive(t, arc)
+	ra.RekorKey = rekorKey
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""))
+	assert.Equal(t, int64(42), ra.rekorBundle.LogIndex)
+	assert.FileExists(t, filepath.Join(out, "foo"))
+	assert.NoFileExists(t, filepath.Join(out, RekorFileName))
+
+	// The entry is only verified if a Rekor key is provided
+	assert.NoError(t, openTestArchive(t, arc).Unpack("../test/public.pem", "SHA256", t.TempDir(), "", ""))
+
+	headers, err := openTestArchive(t, arc).List()
+	assert.NoError(t, err)
+	assert.Len(t, headers, 1)
+}
+
+func TestReadArchive_VerifyTransparency(t *testing.T) {
+	server, rekorKey := newRekorServer(t)
+	_, otherKey := newRekorServer(t)
+
+	out := t.TempDir()
+	ra := openTestArchive(t, sealTestArchive(t, map[string]string{"foo": "foo"}, addTestRekorEntry(t, server)))
+	ra.RekorKey = otherKey
+	err := ra.Unpack("../test/public.pem", "SHA256", out, "", "")
+	assert.ErrorIs(t, err, ErrBadSignature)
+	assert.ErrorContains(t, err, "invalid signed entry timestamp")
+	assert.NoDirExists(t, out)
+
+	ra = openTestArchive(t, sealTestArchive(t, map[string]string{"foo": "foo"}, nil))
+	ra.RekorKey = rekorKey
+	assert.ErrorContains(t, ra.Unpack("../test/public.pem", "SHA256", t.TempDir(), "", ""), "package contains no Rekor entry")
+}
+
+func TestRekorBundle_Verify(t *testing.T) {
+	server, rekorKey := newRekorServer(t)
+	entry, err := newRekorEntry([]byte("toc"), []byte("signature"), "SHA256", []byte("key"))
+	assert.NoError(t, err)
+	bundle, err := uploadRekorEntry(server.URL, entry)
+	assert.NoError(t, err)
+	assert.NoError(t, bundle.Verify([]byte("toc"), []byte("signature"), rekorKey))
+
+	assert.ErrorContains(t, bundle.Verify([]byte("other toc"), []byte("signature"), rekorKey), "records another TOC signature")
+	assert.ErrorContains(t, bundle.Verify([]byte("toc"), []byte("other signature"), rekorKey), "records another TOC signature")
+
+	bundle.InclusionProof.RootHash = hex.EncodeToString(make([]byte, 32))
+	assert.ErrorContains(t, bundle.Verify([]byte("toc"), []byte("signature"), rekorKey), "does not lead to the root hash")
+	bundle.InclusionProof.Hashes = nil
+	assert.ErrorContains(t, bundle.Verify([]byte("toc"), []byte("signature"), rekorKey), "0 hashes for a tree of size 2")
+
+	_, err = newRekorEntry([]byte("toc"), []byte("signature"), SignatureHashEd25519ph, []byte("key"))
+	assert.ErrorContains(t, err, "cannot be recorded in Rekor")
+}
@@ -0,0 +1,143 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ReportTypeFile  = "file"
+	ReportTypeImage = "image"
+
+	ImportStatusImported  = "imported"
+	ImportStatusUnchanged = "unchanged"
+	ImportStatusFailed    = "failed"
+	ImportStatusStored    = "stored"
+)
+
+// Report is a machine-readable summary of all contents processed by a seal or unseal operation.
+type Report struct {
+	Operation string         `json:"operation"`
+	Package   string         `json:"package"`
+	Algorithm string         `json:"hash_algorithm"`
+	Started   time.Time      `json:"started"`
+	Finished  time.Time      `json:"finished"`
+	Success   bool           `json:"success"`
+	Error     string         `json:"error,omitempty"`
+	Entries   []*ReportEntry `json:"entries"`
+	mutex     sync.Mutex
+	// DownloadURL is the presigned link to a package uploaded to S3, if requested
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// ReportEntry describes a single file or image contained in a package.
+type ReportEntry struct {
+	Name         string        `json:"name"`
+	Type         string        `json:"type"`
+	Source       string        `json:"source,omitempty"`
+	Destination  string        `json:"destination,omitempty"`
+	Reference    string        `json:"reference,omitempty"`
+	Digest       string        `json:"digest"`
+	Size         int64         `json:"size"`
+	ImportStatus string        `json:"import_status,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	Started      time.Time     `json:"started"`
+	Duration     time.Duration `json:"duration_ns"`
+}
+
+// NewReport starts a new report for an operation on a package.
+func NewReport(operation, pkg, hashingAlgorithm string) *Report {
+	return &Report{
+		Operation: operation,
+		Package:   pkg,
+		Algorithm: GetHashAlgorithm(hashingAlgorithm).String(),
+		Started:   time.Now(),
+		Entries:   []*ReportEntry{},
+	}
+}
+
+// NewReportEntry creates a new entry and starts its timer.
+func NewReportEntry(name string) *ReportEntry {
+	entryType := ReportTypeFile
+	if strings.HasPrefix(name, ContainerImagePrefix) {
+		entryType = ReportTypeImage
+	}
+	return &ReportEntry{
+		Name:    name,
+		Type:    entryType,
+		Started: time.Now(),
+	}
+}
+
+// Add finishes the timer of an entry and adds it to the Report, taking the digest from the signatures.
+// Calling Add on a nil Report is a no-op, so callers do not need to check whether reporting is enabled.
+func (r *Report) Add(entry *ReportEntry, signatures *FileSignatures) {
+	if r == nil {
+		return
+	}
+	entry.finish(signatures)
+	r.add(entry)
+}
+
+// add adds a finished entry to the Report, a no-op on a nil Report
+func (r *Report) add(entry *ReportEntry) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Entries = append(r.Entries, entry)
+}
+
+// finish stops the timer of an entry and takes its digest from the signatures
+func (e *ReportEntry) finish(signatures *FileSignatures) {
+	e.Duration = time.Since(e.Started)
+	if signatures != nil {
+		if sum, ok := (*signatures)[e.Name]; ok {
+			e.Digest = hex.EncodeToString([]byte(sum))
+		}
+	}
+}
+
+// Finish sets the final state of the Report.
+func (r *Report) Finish(err error) {
+	if r == nil {
+		return
+	}
+	r.Finished = time.Now()
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// Write stores the Report as JSON to a file, S3 bucket or stdout.
+func (r *Report) Write(output string) error {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileBytes(output, append(data, '\n'))
+}
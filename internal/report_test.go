@@ -0,0 +1,97 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReport_NilIsNoop(t *testing.T) {
+	var r *Report
+	r.Add(NewReportEntry("foo"), nil)
+	r.Finish(fmt.Errorf("fnord"))
+	assert.NoError(t, r.Write(filepath.Join(t.TempDir(), "report.json")))
+}
+
+func TestNewReportEntry(t *testing.T) {
+	assert.Equal(t, ReportTypeFile, NewReportEntry("foo/bar").Type)
+	assert.Equal(t, ReportTypeImage, NewReportEntry(ContainerImagePrefix+"/docker.io/alpine:latest.oci").Type)
+}
+
+func TestReport_SealUnseal(t *testing.T) {
+	// Arrange
+	src := filepath.Join(t.TempDir(), "foo.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("Hold your breath and count to 10."), 0644))
+	sealReport := NewReport("seal", "foo.sealed", "SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	arc.Report = sealReport
+	sig := NewSignatureList("SHA256")
+
+	// Act: Seal
+	assert.NoError(t, arc.AddContents([]string{src}, nil, sig))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+	sealReport.Finish(nil)
+
+	// Assert: Seal
+	assert.True(t, sealReport.Success)
+	assert.Equal(t, "SHA-256", sealReport.Algorithm)
+	assert.Len(t, sealReport.Entries, 1)
+	assert.Equal(t, "foo.txt", sealReport.Entries[0].Name)
+	assert.Equal(t, src, sealReport.Entries[0].Source)
+	assert.Equal(t, int64(33), sealReport.Entries[0].Size)
+	assert.Len(t, sealReport.Entries[0].Digest, 64)
+
+	// Act: Unseal
+	unsealReport := NewReport("unseal", "foo.sealed", "SHA256")
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	ra.Report = unsealReport
+	out := t.TempDir()
+	err = ra.Unpack("../test/public.pem", "SHA256", out, "", "")
+	assert.NoError(t, err)
+	unsealReport.Finish(err)
+
+	// Assert: Unseal
+	assert.Len(t, unsealReport.Entries, 1)
+	assert.Equal(t, filepath.Join(out, "foo.txt"), unsealReport.Entries[0].Destination)
+	assert.Equal(t, sealReport.Entries[0].Digest, unsealReport.Entries[0].Digest)
+
+	// Act: Write
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	assert.NoError(t, unsealReport.Write(reportFile))
+	data, err := os.ReadFile(reportFile)
+	assert.NoError(t, err)
+	parsed := map[string]any{}
+	assert.NoError(t, json.Unmarshal(data, &parsed))
+	assert.Equal(t, "unseal", parsed["operation"])
+	assert.Equal(t, true, parsed["success"])
+}
+
+func TestReport_FinishError(t *testing.T) {
+	r := NewReport("unseal", "foo.sealed", "SHA512")
+	r.Finish(fmt.Errorf("tocs not matching"))
+	assert.False(t, r.Success)
+	assert.Equal(t, "tocs not matching", r.Error)
+	assert.False(t, r.Finished.Before(r.Started))
+}
@@ -0,0 +1,304 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// RollbackFileName is the archive entry of a rollback bundle holding its RollbackManifest
+	RollbackFileName = ".sealpack.rollback"
+	// rollbackImageSuffix is appended to image names to keep previous images from being garbage collected
+	rollbackImageSuffix = "-sealpack-rollback"
+)
+
+// RollbackManifest describes how to revert an unseal
+type RollbackManifest struct {
+	// Output is the absolute output path of the reverted unseal
+	Output string `json:"output"`
+	// Namespace is the containerD namespace images were imported into, empty for imports into a registry
+	Namespace string `json:"namespace,omitempty"`
+	// Replaced lists the files whose previous contents are stored in the bundle
+	Replaced []string `json:"replaced"`
+	// Created lists the files that did not exist before and are removed
+	Created []string `json:"created"`
+	// Images lists the image tags to reset
+	Images []RollbackImage `json:"images"`
+}
+
+// RollbackImage is an image tag and the digest it referred to before unsealing
+type RollbackImage struct {
+	Tag string `json:"tag"`
+	// Previous is the digest of the tag before unsealing, empty if it did not exist
+	Previous string `json:"previous,omitempty"`
+	// Backup is the containerD image keeping the previous image from being garbage collected
+	Backup string `json:"backup,omitempty"`
+}
+
+// A RollbackRecorder captures files and image tags before an unseal replaces them
+type RollbackRecorder struct {
+	mu       sync.Mutex
+	dir      string
+	manifest RollbackManifest
+	// modes are the permission bits of the replaced files, restored along with their contents
+	modes map[string]os.FileMode
+}
+
+// NewRollbackRecorder creates a recorder for an unseal into the output path and, for local imports, the namespace.
+// Replaced files are copied to a temporary directory until the bundle is written.
+func NewRollbackRecorder(outputPath, namespace string) (*RollbackRecorder, error) {
+	abs, err := filepath.Abs(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "sealpack-rollback-")
+	if err != nil {
+		return nil, err
+	}
+	return &RollbackRecorder{dir: dir, manifest: RollbackManifest{Output: abs, Namespace: namespace}, modes: map[string]os.FileMode{}}, nil
+}
+
+// Empty checks whether nothing has been captured
+func (r *RollbackRecorder) Empty() bool {
+	return len(r.manifest.Replaced) == 0 && len(r.manifest.Created) == 0 && len(r.manifest.Images) == 0
+}
+
+// Cleanup removes the captured files
+func (r *RollbackRecorder) Cleanup() error {
+	return os.RemoveAll(r.dir)
+}
+
+// captureFile copies a file before it is replaced, or records that it is created
+func (r *RollbackRecorder) captureFile(name, fullFile string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	in, err := os.Open(fullFile)
+	if errors.Is(err, os.ErrNotExist) {
+		r.manifest.Created = append(r.manifest.Created, name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	staged := filepath.Join(r.dir, localFileName(name))
+	if err = os.MkdirAll(filepath.Dir(staged), 0700); err != nil {
+		return err
+	}
+	out, err := os.Create(staged)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	r.manifest.Replaced = append(r.manifest.Replaced, name)
+	r.modes[name] = info.Mode().Perm()
+	return nil
+}
+
+// captureImage records the digest of a tag before an image is imported with it.
+// In containerD, the previous image is kept under a backup name, so it is not garbage collected.
+func (r *RollbackRecorder) captureImage(namespace, targetRegistry string, tag name.Tag) error {
+	img := RollbackImage{Tag: tag.Name()}
+	if targetRegistry == LocalContainerRegistry {
+		client, ctx, err := getContainerDClient(namespace)
+		if err != nil {
+			return err
+		}
+		previous, err := client.ImageService().Get(ctx, img.Tag)
+		if err == nil {
+			img.Previous = previous.Target.Digest.String()
+			img.Backup = img.Tag + rollbackImageSuffix
+			backup := images.Image{Name: img.Backup, Target: previous.Target}
+			if _, err = client.ImageService().Create(ctx, backup); errdefs.IsAlreadyExists(err) {
+				_, err = client.ImageService().Update(ctx, backup, "target")
+			}
+		}
+		if err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+	} else {
+		target, err := name.NewRepository(targetRegistry)
+		if err != nil {
+			return err
+		}
+		tag.Repository = target
+		img.Tag = tag.Name()
+		if img.Previous, err = crane.Digest(img.Tag); err != nil && !isNotFound(err) {
+			return wrapNetworkError(err)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manifest.Images = append(r.manifest.Images, img)
+	return nil
+}
+
+// isNotFound checks whether a registry reported a missing manifest
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
+
+// WriteBundle writes a public package of the captured files and the manifest, signed with the private key.
+// The manifest is the first entry, followed by the previous contents and modes of all replaced files.
+func (r *RollbackRecorder) WriteBundle(output, privateKeyPath, hashingAlgorithm string) (err error) {
+	envelope := Envelope{
+		Version:         EnvelopeVersionLatest,
+		HashAlgorithm:   GetHashAlgorithm(hashingAlgorithm),
+		CompressionAlgo: GetCompressionAlgoIndex(CompressionGzip),
+		SignatureHash:   DefaultSignatureHash,
+	}
+	if envelope.Signer, err = CreateSignerWithHash(privateKeyPath, DefaultSignatureHash); err != nil {
+		return fmt.Errorf("rollback: could not create signer: %v", err)
+	}
+	arc := CreateArchiveWriter(true, envelope.CompressionAlgo)
+	defer func() { _ = arc.Cleanup() }()
+	signatures := NewSignatureList(hashingAlgorithm)
+	manifest, err := json.Marshal(r.manifest)
+	if err != nil {
+		return err
+	}
+	if err = arc.AddToArchive(RollbackFileName, manifest); err != nil {
+		return err
+	}
+	if err = signatures.AddFile(RollbackFileName, manifest); err != nil {
+		return err
+	}
+	for _, name := range r.manifest.Replaced {
+		var f *os.File
+		if f, err = os.Open(filepath.Join(r.dir, localFileName(name))); err != nil {
+			return err
+		}
+		if err = arc.storeContents(f, name, filepath.Join(r.manifest.Output, name), r.modes[name], nil, signatures); err != nil {
+			return err
+		}
+	}
+	if err = arc.AddToc(privateKeyPath, signatures); err != nil {
+		return err
+	}
+	if envelope.PayloadLen, err = arc.Finalize(); err != nil {
+		return err
+	}
+	out, err := NewOutputFile(output)
+	if err != nil {
+		return err
+	}
+	if err = envelope.WriteOutput(out, arc); err != nil {
+		return err
+	}
+	return CleanupFileWriter(output, out)
+}
+
+// readRollbackManifest parses the manifest of a rollback bundle while hashing it for the TOC.
+// It must only be acted upon after the TOC has been verified.
+func (arc *ReadArchive) readRollbackManifest(h *tar.Header, v *Verifier) error {
+	var data []byte
+	err := v.Signatures.AddFileWhileReading(h.Name, arc.TarReader, func(r io.Reader) (err error) {
+		data, err = io.ReadAll(io.LimitReader(r, maxMetadataSize))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	manifest := &RollbackManifest{}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return corruptEnvelope(fmt.Errorf("invalid rollback manifest: %w", err))
+	}
+	if !filepath.IsAbs(manifest.Output) {
+		return corruptEnvelope(fmt.Errorf("invalid rollback manifest: output path '%s' is not absolute", manifest.Output))
+	}
+	arc.RollbackManifest = manifest
+	return nil
+}
+
+// Revert removes the created files and resets all image tags. Replaced files are restored by unpacking the bundle.
+// All failures are reported at once.
+func (m *RollbackManifest) Revert(logger log.Interface) error {
+	var errs []error
+	for _, name := range m.Created {
+		logger.Infof("rollback: removing %s", name)
+		if err := os.Remove(filepath.Join(m.Output, localFileName(name))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	for _, img := range m.Images {
+		logger.Infof("rollback: resetting %s", img.Tag)
+		if err := resetImage(m.Namespace, img); err != nil {
+			errs = append(errs, fmt.Errorf("cannot reset %s: %w", img.Tag, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resetImage points a tag to its previous image again, or removes it if it did not exist before
+var resetImage = func(namespace string, img RollbackImage) error {
+	if namespace == "" {
+		if img.Previous == "" {
+			return crane.Delete(img.Tag)
+		}
+		tag, err := name.NewTag(img.Tag)
+		if err != nil {
+			return err
+		}
+		return crane.Tag(tag.Context().Name()+"@"+img.Previous, tag.TagStr())
+	}
+	client, ctx, err := getContainerDClient(namespace)
+	if err != nil {
+		return err
+	}
+	if img.Previous == "" {
+		if err = client.ImageService().Delete(ctx, img.Tag); errdefs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	backup, err := client.ImageService().Get(ctx, img.Backup)
+	if err != nil {
+		return err
+	}
+	previous := images.Image{Name: img.Tag, Target: backup.Target}
+	if _, err = client.ImageService().Update(ctx, previous, "target"); errdefs.IsNotFound(err) {
+		_, err = client.ImageService().Create(ctx, previous)
+	}
+	if err != nil {
+		return err
+	}
+	return client.ImageService().Delete(ctx, img.Backup)
+}
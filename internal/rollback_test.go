@@ -0,0 +1,139 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRollbackRecorder_WriteBundle(t *testing.T) {
+	out := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(out, "conf"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(out, "conf", "app.yaml"), []byte("previous"), 0750))
+	assert.NoError(t, os.Chmod(filepath.Join(out, "conf", "app.yaml"), 0750))
+	rec, err := NewRollbackRecorder(out, "")
+	assert.NoError(t, err)
+	defer rec.Cleanup()
+	assert.True(t, rec.Empty())
+	assert.NoError(t, rec.captureFile("conf/app.yaml", filepath.Join(out, "conf", "app.yaml")))
+	assert.NoError(t, rec.captureFile("new.txt", filepath.Join(out, "new.txt")))
+	assert.False(t, rec.Empty())
+	assert.Equal(t, []string{"conf/app.yaml"}, rec.manifest.Replaced)
+	assert.Equal(t, []string{"new.txt"}, rec.manifest.Created)
+
+	bundle := filepath.Join(t.TempDir(), "rollback.ipc")
+	assert.NoError(t, rec.WriteBundle(bundle, "../test/private.pem", "SHA256"))
+	f, err := os.Open(bundle)
+	assert.NoError(t, err)
+	defer f.Close()
+	envelope, err := ParseEnvelope(f)
+	assert.NoError(t, err)
+	assert.NoError(t, envelope.VerifySignature("../test/public.pem"))
+	payload, err := envelope.GetPayload("")
+	assert.NoError(t, err)
+	ra, err := OpenArchiveReader(payload, envelope.CompressionAlgo)
+	assert.NoError(t, err)
+
+	// The update narrowed the mode, the rollback restores the previous one exactly
+	restored := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(restored, "conf"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(restored, "conf", "app.yaml"), []byte("updated"), 0600))
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", restored, "", ""))
+	assert.Equal(t, rec.manifest, *ra.RollbackManifest)
+	contents, err := os.ReadFile(filepath.Join(restored, "conf", "app.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "previous", string(contents))
+	info, err := os.Stat(filepath.Join(restored, "conf", "app.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+	assert.NoFileExists(t, filepath.Join(restored, RollbackFileName))
+}
+
+func TestReadArchive_RollbackManifest_Invalid(t *testing.T) {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	assert.NoError(t, arc.AddToArchive(RollbackFileName, []byte(`{"output":"relative"}`)))
+	assert.NoError(t, sig.AddFile(RollbackFileName, []byte(`{"output":"relative"}`)))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+	assert.ErrorContains(t, openTestArchive(t, arc).Unpack("../test/public.pem", "SHA256", t.TempDir(), "", ""), "is not absolute")
+}
+
+func TestRollbackManifest_Revert(t *testing.T) {
+	out := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(out, "new.txt"), []byte("new"), 0644))
+	var reset []RollbackImage
+	defer func(r func(string, RollbackImage) error) { resetImage = r }(resetImage)
+	resetImage = func(namespace string, img RollbackImage) error {
+		reset = append(reset, img)
+		return nil
+	}
+	manifest := &RollbackManifest{
+		Output:  out,
+		Created: []string{"new.txt", "already-removed.txt"},
+		Images:  []RollbackImage{{Tag: "registry.example.com/app:1.0", Previous: "sha256:abc"}},
+	}
+	assert.NoError(t, manifest.Revert(&log.Logger{Handler: discard.Default}))
+	assert.NoFileExists(t, filepath.Join(out, "new.txt"))
+	assert.Equal(t, manifest.Images, reset)
+}
+
+func TestRollbackRecorder_CaptureImage_Registry(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	target := strings.TrimPrefix(server.URL, "http://") + "/app"
+	previous, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, crane.Push(previous, target+":1.0"))
+	previousDigest, err := previous.Digest()
+	assert.NoError(t, err)
+
+	rec, err := NewRollbackRecorder(t.TempDir(), "")
+	assert.NoError(t, err)
+	defer rec.Cleanup()
+	for _, ref := range []string{"registry.example.com/app:1.0", "registry.example.com/app:2.0"} {
+		tag, err := name.NewTag(ref)
+		assert.NoError(t, err)
+		assert.NoError(t, rec.captureImage("", target, tag))
+	}
+	assert.Equal(t, []RollbackImage{{Tag: target + ":1.0", Previous: previousDigest.String()}, {Tag: target + ":2.0"}}, rec.manifest.Images)
+
+	// Unsealing replaced the previous image and created a new tag
+	updated, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, crane.Push(updated, target+":1.0"))
+	assert.NoError(t, crane.Push(updated, target+":2.0"))
+	for _, img := range rec.manifest.Images {
+		assert.NoError(t, resetImage("", img))
+	}
+	digest, err := crane.Digest(target + ":1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, previousDigest.String(), digest)
+	_, err = crane.Digest(target + ":2.0")
+	assert.True(t, isNotFound(err))
+}
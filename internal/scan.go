@@ -0,0 +1,55 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// scanBlockSize is the size of the blocks searched for the magic bytes at once
+const scanBlockSize = 1 << 20
+
+// ScanEnvelope searches the input for the first envelope that can be parsed and verified, skipping data in front of it,
+// e.g. prepended by a transport or a self-extracting stub. It provides the envelope, which reads from the section
+// of the input starting at the envelope, and the offset of the envelope in the input.
+// Magic bytes within other data are ruled out by the header checksum of EnvelopeVersion6 and the checksum trailer.
+func ScanEnvelope(input io.ReaderAt, size int64) (*Envelope, int64, error) {
+	// Both magic byte sequences only differ in their last byte
+	prefix := []byte(EnvelopeMagicBytes[:len(EnvelopeMagicBytes)-1])
+	block := make([]byte, scanBlockSize+len(EnvelopeMagicBytes)-1)
+	for start := int64(0); start < size; start += scanBlockSize {
+		n, err := input.ReadAt(block, start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, 0, err
+		}
+		for i := 0; i < min(n, scanBlockSize); i++ {
+			found := bytes.Index(block[i:n], prefix)
+			if found < 0 || i+found >= scanBlockSize {
+				break
+			}
+			i += found
+			if i+len(prefix) >= n || (block[i+len(prefix)] != EnvelopeMagicBytes[len(prefix)] && block[i+len(prefix)] != EnvelopeMagicBytesV2[len(prefix)]) {
+				continue
+			}
+			offset := start + int64(i)
+			if envel, err := parseEnvelope(io.NewSectionReader(input, offset, size-offset), true); err == nil {
+				return envel, offset, nil
+			}
+		}
+	}
+	return nil, 0, corruptEnvelope(errors.New("no sealpack envelope found"))
+}
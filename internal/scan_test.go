@@ -0,0 +1,78 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"github.com/innomotics/sealpack/format"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// envelopeV6 creates a version 6 envelope around the payload
+func envelopeV6(payload []byte) []byte {
+	envelope := &Envelope{
+		Version:       EnvelopeVersion6,
+		PayloadLen:    int64(len(payload)),
+		HashAlgorithm: crypto.SHA256,
+		ReceiverKeys:  [][]byte{[]byte("fuyoooh!")},
+	}
+	out := &bytes.Buffer{}
+	_ = envelope.writeEnvelope(out, bytes.NewReader(payload))
+	return out.Bytes()
+}
+
+func TestScanEnvelope(t *testing.T) {
+	payload := []byte("0123456789abcdef")
+	// The prefix holds magic bytes followed by a header not matching its checksum
+	prefix := append([]byte("transport header "), envelopeV6(payload)[:format.HeaderSizeV6-1]...)
+	prefix = append(prefix, 0xFF)
+	raw := append(bytes.Clone(prefix), envelopeV6(payload)...)
+
+	_, err := ParseEnvelope(bytes.NewReader(raw))
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+	env, offset, err := ScanEnvelope(bytes.NewReader(raw), int64(len(raw)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(prefix)), offset)
+	assert.Equal(t, EnvelopeVersion6, env.Version)
+	assert.Equal(t, [][]byte{[]byte("fuyoooh!")}, env.ReceiverKeys)
+	read := make([]byte, len(payload))
+	_, err = env.PayloadReader.Read(read)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, read)
+
+	// Envelopes at the start are found without skipping anything
+	raw = envelopeV6(payload)
+	_, offset, err = ScanEnvelope(bytes.NewReader(raw), int64(len(raw)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+}
+
+func TestScanEnvelope_BlockBoundary(t *testing.T) {
+	// The magic bytes span two blocks
+	prefix := bytes.Repeat([]byte{0}, scanBlockSize-2)
+	raw := append(prefix, envelopeV6([]byte("payload"))...)
+	_, offset, err := ScanEnvelope(bytes.NewReader(raw), int64(len(raw)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(prefix)), offset)
+}
+
+func TestScanEnvelope_NotFound(t *testing.T) {
+	raw := append([]byte("no envelope "), []byte(EnvelopeMagicBytesV2)...)
+	_, _, err := ScanEnvelope(bytes.NewReader(raw), int64(len(raw)))
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+	assert.ErrorContains(t, err, "no sealpack envelope found")
+}
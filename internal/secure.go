@@ -0,0 +1,75 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// wipe overwrites key material in memory once it is no longer needed
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// createTempFile creates a temporary file for plaintext or key-dependent contents.
+// Where supported, the file is never linked into the filesystem, so it vanishes with the process.
+func createTempFile(pattern string) (*os.File, error) {
+	if f, err := createUnlinkedTemp(); err == nil {
+		return f, nil
+	}
+	return os.CreateTemp("", pattern)
+}
+
+// removeTempFile overwrites a temporary file with zeros before closing and removing it, so its contents cannot be
+// recovered from the disk afterward. Unlinked temporary files are only overwritten and closed.
+func removeTempFile(f *os.File) error {
+	errs := []error{overwriteFile(f), f.Close()}
+	if !isUnlinkedTemp(f) {
+		if err := os.Remove(f.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// overwriteFile fills a file with zeros up to its current size
+func overwriteFile(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	wipe(*buf)
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.CopyBuffer(struct{ io.Writer }{f}, io.LimitReader(zeroReader{}, info.Size()), *buf); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// zeroReader provides an endless stream of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	wipe(p)
+	return len(p), nil
+}
@@ -0,0 +1,39 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"strings"
+)
+
+// unlinkedTempPrefix is the name of files opened with O_TMPFILE, which can be reopened via procfs
+const unlinkedTempPrefix = "/proc/self/fd/"
+
+// createUnlinkedTemp opens an anonymous file in the temporary directory with O_TMPFILE
+func createUnlinkedTemp() (*os.File, error) {
+	fd, err := unix.Open(os.TempDir(), unix.O_TMPFILE|unix.O_RDWR|unix.O_CLOEXEC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), fmt.Sprintf("%s%d", unlinkedTempPrefix, fd)), nil
+}
+
+// isUnlinkedTemp checks whether a file has been created by createUnlinkedTemp
+func isUnlinkedTemp(f *os.File) bool {
+	return strings.HasPrefix(f.Name(), unlinkedTempPrefix)
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"os"
+)
+
+// createUnlinkedTemp is not supported on this platform, so named temporary files are used
+func createUnlinkedTemp() (*os.File, error) {
+	return nil, fmt.Errorf("unlinked temporary files are only available on Linux")
+}
+
+// isUnlinkedTemp is always false on this platform
+func isUnlinkedTemp(*os.File) bool {
+	return false
+}
@@ -0,0 +1,56 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestWipe(t *testing.T) {
+	key := []byte("secret key")
+	wipe(key)
+	assert.Equal(t, make([]byte, 10), key)
+}
+
+func TestRemoveTempFile(t *testing.T) {
+	f, err := createTempFile("sealpack-test-*")
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("plaintext"))
+	assert.NoError(t, err)
+	assert.NoError(t, overwriteFile(f))
+	contents := make([]byte, 9)
+	_, err = f.ReadAt(contents, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, make([]byte, 9), contents)
+
+	name := f.Name()
+	assert.NoError(t, removeTempFile(f))
+	if !isUnlinkedTemp(f) {
+		assert.NoFileExists(t, name)
+	}
+}
+
+func TestWriteArchive_Cleanup(t *testing.T) {
+	arc := CreateArchiveWriter(false, 0)
+	assert.NotEmpty(t, arc.EncryptionKey)
+	assert.NoError(t, arc.Cleanup())
+	assert.Nil(t, arc.EncryptionKey)
+	// Cleanup is deferred in addition to the regular cleanup
+	assert.NoError(t, arc.Cleanup())
+	_, err := arc.outFile.Stat()
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
@@ -0,0 +1,61 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"errors"
+	"github.com/awnumar/memguard"
+	"os"
+)
+
+// lockedMemoryHint explains how to resolve failures to lock memory
+const lockedMemoryHint = "locking memory needs a sufficient memlock limit (ulimit -l) or the CAP_IPC_LOCK capability"
+
+// errLockedMemory indicates that memguard could not allocate locked memory
+var errLockedMemory = errors.New("cannot allocate locked memory")
+
+// lockBytes moves key material into a memguard buffer, wiping src.
+// memguard signals failed allocations by an empty buffer, which is turned into an error here.
+func lockBytes(src []byte) (*memguard.LockedBuffer, error) {
+	size := len(src)
+	buf := memguard.NewBufferFromBytes(src)
+	if buf.Size() != size {
+		wipe(src)
+		return nil, WithHint(errLockedMemory, lockedMemoryHint)
+	}
+	return buf, nil
+}
+
+// readLocked reads a file, e.g. a private key, into a memguard buffer
+func readLocked(path string) (*memguard.LockedBuffer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := memguard.NewBufferFromReader(f, int(info.Size()))
+	if err != nil {
+		buf.Destroy()
+		return nil, err
+	}
+	if buf.Size() != int(info.Size()) {
+		return nil, WithHint(errLockedMemory, lockedMemoryHint)
+	}
+	return buf, nil
+}
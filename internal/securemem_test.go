@@ -0,0 +1,44 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockBytes(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	buf, err := lockBytes(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("0123456789abcdef0123456789abcdef"), buf.Bytes())
+	// The source is wiped once the key is locked
+	assert.Equal(t, make([]byte, 32), key)
+	buf.Destroy()
+	assert.False(t, buf.IsAlive())
+}
+
+func TestReadLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	assert.NoError(t, os.WriteFile(path, []byte("secret"), 0600))
+	buf, err := readLocked(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), buf.Bytes())
+	buf.Destroy()
+	_, err = readLocked(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
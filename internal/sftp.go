@@ -0,0 +1,211 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// SFTPUriPrefix marks packages on SFTP servers, e.g. sftp://user@host:22/srv/packages/app.ipc
+const SFTPUriPrefix = "sftp://"
+
+// sftpClient is an SFTP session on an SSH connection of its own
+type sftpClient struct {
+	*sftp.Client
+	conn *ssh.Client
+}
+
+// Close ends the session and the connection
+func (c *sftpClient) Close() error {
+	_ = c.Client.Close()
+	return c.conn.Close()
+}
+
+// dialSFTP connects to the server of an sftp:// URI and provides the remote path.
+// The server is authenticated with ~/.ssh/known_hosts, the user with the password of the URI, the SSH agent
+// and the unencrypted default keys in ~/.ssh.
+func dialSFTP(uri string) (*sftpClient, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Path == "" {
+		return nil, "", fmt.Errorf("invalid SFTP URI '%s', use sftp://user@host/path", uri)
+	}
+	config, err := sshClientConfig(u)
+	if err != nil {
+		return nil, "", err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			keyscan := "ssh-keyscan " + u.Hostname()
+			if u.Port() != "" {
+				keyscan = "ssh-keyscan -p " + u.Port() + " " + u.Hostname()
+			}
+			return nil, "", WithHint(fmt.Errorf("%w: host key of %s is not known or does not match", ErrBadSignature, addr),
+				"verify the host key and add it to ~/.ssh/known_hosts, e.g. with %s", keyscan)
+		}
+		return nil, "", wrapNetworkError(fmt.Errorf("cannot connect to %s: %w", addr, err))
+	}
+	session, err := conn.NewSession()
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", err
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", err
+	}
+	if err = session.RequestSubsystem("sftp"); err != nil {
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("%s does not provide SFTP: %w", addr, err)
+	}
+	// Transfers are rate limited on the streams of the session
+	limited := struct {
+		io.Writer
+		io.Closer
+	}{limitWriter(w, uploadLimiter), w}
+	client, err := sftp.NewClientPipe(limitReader(r, downloadLimiter), limited)
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("%s does not provide SFTP: %w", addr, err)
+	}
+	return &sftpClient{Client: client, conn: conn}, u.Path, nil
+}
+
+// sshClientConfig configures the authentication of a connection to the host of the URI
+func sshClientConfig(u *url.URL) (*ssh.ClientConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, WithHint(fmt.Errorf("cannot read known SSH hosts: %w", err),
+			"add the host key of the server to ~/.ssh/known_hosts, e.g. with ssh-keyscan")
+	}
+	name := u.User.Username()
+	if name == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		name = current.Username
+	}
+	var auth []ssh.AuthMethod
+	if password, ok := u.User.Password(); ok {
+		auth = append(auth, ssh.Password(password))
+	}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		pemBytes, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		// Encrypted keys are used through the SSH agent
+		if signer, err := ssh.ParsePrivateKey(pemBytes); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	return &ssh.ClientConfig{User: name, Auth: auth, HostKeyCallback: hostKeys, Timeout: 30 * time.Second}, nil
+}
+
+// sftpRange is a package on an SFTP server
+type sftpRange struct {
+	client *sftpClient
+	file   *sftp.File
+	size   int64
+}
+
+// openSFTPRange opens a package on an SFTP server for ranged reads
+func openSFTPRange(uri string) (*sftpRange, error) {
+	client, p, err := dialSFTP(uri)
+	if err != nil {
+		return nil, err
+	}
+	info, err := client.Stat(p)
+	if err == nil && info.IsDir() {
+		err = fmt.Errorf("%s is a directory", uri)
+	}
+	var file *sftp.File
+	if err == nil {
+		file, err = client.Open(p)
+	}
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return &sftpRange{client: client, file: file, size: info.Size()}, nil
+}
+
+// Size implements RangeSource
+func (s *sftpRange) Size() int64 {
+	return s.size
+}
+
+// ReadAt implements io.ReaderAt
+func (s *sftpRange) ReadAt(p []byte, off int64) (int, error) {
+	return s.file.ReadAt(p, off)
+}
+
+// Close implements io.Closer
+func (s *sftpRange) Close() error {
+	_ = s.file.Close()
+	return s.client.Close()
+}
+
+// walkSFTP calls fn with the paths and attributes of all regular files below a remote directory
+func walkSFTP(client *sftpClient, dir string, fn func(p string, info fs.FileInfo)) error {
+	walker := client.Walk(dir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		if walker.Stat().Mode().IsRegular() {
+			fn(walker.Path(), walker.Stat())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rootedSFTP serves the files below a local directory as root of an SFTP server
+type rootedSFTP string
+
+// local provides the local path of a remote one
+func (r rootedSFTP) local(p string) string {
+	return filepath.Join(string(r), filepath.FromSlash(p))
+}
+
+// Fileread implements sftp.FileReader
+func (r rootedSFTP) Fileread(req *sftp.Request) (io.ReaderAt, error) {
+	return os.Open(r.local(req.Filepath))
+}
+
+// Filewrite implements sftp.FileWriter
+func (r rootedSFTP) Filewrite(req *sftp.Request) (io.WriterAt, error) {
+	return os.OpenFile(r.local(req.Filepath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+// Filecmd implements sftp.FileCmder. Like version 3 servers, renaming onto existing files fails.
+func (r rootedSFTP) Filecmd(req *sftp.Request) error {
+	switch req.Method {
+	case "Mkdir":
+		return os.Mkdir(r.local(req.Filepath), 0755)
+	case "Remove", "Rmdir":
+		return os.Remove(r.local(req.Filepath))
+	case "Rename":
+		if _, err := os.Stat(r.local(req.Target)); err == nil {
+			return fs.ErrExist
+		}
+		return os.Rename(r.local(req.Filepath), r.local(req.Target))
+	case "Setstat":
+		return nil
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// Filelist implements sftp.FileLister
+func (r rootedSFTP) Filelist(req *sftp.Request) (sftp.ListerAt, error) {
+	switch req.Method {
+	case "List":
+		entries, err := os.ReadDir(r.local(req.Filepath))
+		if err != nil {
+			return nil, err
+		}
+		infos := make(fileInfos, 0, len(entries))
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+		return infos, nil
+	case "Stat", "Lstat":
+		info, err := os.Stat(r.local(req.Filepath))
+		if err != nil {
+			return nil, err
+		}
+		return fileInfos{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// fileInfos lists files for the SFTP server
+type fileInfos []fs.FileInfo
+
+// ListAt implements sftp.ListerAt
+func (l fileInfos) ListAt(infos []fs.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// serveSFTP answers SFTP requests with the files below root until the channel is closed
+func serveSFTP(root string, channel io.ReadWriteCloser) {
+	handler := rootedSFTP(root)
+	server := sftp.NewRequestServer(channel, sftp.Handlers{FileGet: handler, FilePut: handler, FileCmd: handler, FileList: handler})
+	_ = server.Serve()
+	_ = server.Close()
+}
+
+// startSSHServer serves SFTP for root on a random port, trusted by ~/.ssh of a temporary home directory.
+// The address of the server is provided.
+func startSSHServer(t *testing.T, root string) string {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	assert.NoError(t, err)
+	_, userPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	userKey, err := ssh.NewSignerFromKey(userPriv)
+	assert.NoError(t, err)
+
+	config := &ssh.ServerConfig{PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if string(key.Marshal()) != string(userKey.PublicKey().Marshal()) {
+			return nil, fmt.Errorf("unknown key")
+		}
+		return nil, nil
+	}}
+	config.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for newChannel := range channels {
+					channel, requests, err := newChannel.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						for req := range requests {
+							ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+							_ = req.Reply(ok, nil)
+							if ok {
+								go serveSFTP(root, channel)
+							}
+						}
+					}()
+				}
+			}()
+		}
+	}()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	block, err := ssh.MarshalPrivateKey(userPriv, "")
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Join(home, ".ssh"), 0700))
+	assert.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "id_ed25519"), pem.EncodeToMemory(block), 0600))
+	addr := listener.Addr().String()
+	knownHosts := knownhosts.Line([]string{addr}, hostKey.PublicKey()) + "\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(knownHosts), 0600))
+	return addr
+}
+
+func TestSFTP(t *testing.T) {
+	root := t.TempDir()
+	addr := startSSHServer(t, root)
+	uri := "sftp://" + addr + "/a/b/file"
+	contents := make([]byte, 100<<10)
+	_, _ = rand.Read(contents)
+
+	assert.NoError(t, writeSFTP(uri, bytes.NewReader([]byte("old"))))
+	// Existing files are replaced
+	assert.NoError(t, writeSFTP(uri, bytes.NewReader(contents)))
+	written, err := os.ReadFile(filepath.Join(root, "a", "b", "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, contents, written)
+	assert.NoFileExists(t, filepath.Join(root, "a", "b", "file.part"))
+
+	src, err := openSFTPRange(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), src.Size())
+	part := make([]byte, 40<<10)
+	n, err := src.ReadAt(part, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, len(part), n)
+	assert.Equal(t, contents[100:100+len(part)], part)
+	_, err = src.ReadAt(part, int64(len(contents)-10))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.NoError(t, src.Close())
+
+	client, dir, err := dialSFTP("sftp://" + addr + "/")
+	assert.NoError(t, err)
+	var files []string
+	assert.NoError(t, walkSFTP(client, dir, func(p string, info fs.FileInfo) {
+		files = append(files, p)
+		assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+	}))
+	assert.NoError(t, client.Close())
+	assert.Equal(t, []string{"/a/b/file"}, files)
+
+	assert.NoError(t, removeLocation(uri))
+	assert.NoFileExists(t, filepath.Join(root, "a", "b", "file"))
+	_, err = openSFTPRange(uri)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestDialSFTP(t *testing.T) {
+	root := t.TempDir()
+	copyGolden(t, "v5-sealed", filepath.Join(root, "pkgs", "app.ipc"))
+	addr := startSSHServer(t, root)
+
+	src, err := OpenRangeSource("sftp://"+addr+"/pkgs/app.ipc", nil)
+	assert.NoError(t, err)
+	defer src.Close()
+	expected, err := os.ReadFile(goldenPackage("v5-sealed"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(expected)), src.Size())
+	envelope, err := ParseEnvelopeUnverified(io.NewSectionReader(src, 0, src.Size()))
+	assert.NoError(t, err)
+	assert.Equal(t, EnvelopeVersion5, envelope.Version)
+
+	_, err = OpenRangeSource("sftp://"+addr+"/pkgs/missing.ipc", nil)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// Unknown host keys are rejected
+	assert.NoError(t, os.WriteFile(filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts"), nil, 0600))
+	_, err = OpenRangeSource("sftp://"+addr+"/pkgs/app.ipc", nil)
+	assert.ErrorIs(t, err, ErrBadSignature)
+	assert.Contains(t, strings.Join(Hints(err), " "), "ssh-keyscan")
+}
@@ -16,6 +16,12 @@ package internal
 
 import (
 	"crypto"
+	"encoding/hex"
+	"fmt"
+	// Register the hash implementations with crypto
+	_ "golang.org/x/crypto/blake2b"
+	_ "golang.org/x/crypto/blake2s"
+	_ "golang.org/x/crypto/sha3"
 	"hash"
 	"io"
 	"os"
@@ -32,45 +38,57 @@ const Delimiter = ":"
 
 // availableHashes maps names of available hashes to their crypto.Hash
 var availableHashes = map[string]crypto.Hash{
-	"SHA224": crypto.SHA224,
-	"SHA256": crypto.SHA256,
-	"SHA384": crypto.SHA384,
-	"SHA512": crypto.SHA512,
+	"SHA224":     crypto.SHA224,
+	"SHA256":     crypto.SHA256,
+	"SHA384":     crypto.SHA384,
+	"SHA512":     crypto.SHA512,
+	"SHA3224":    crypto.SHA3_224,
+	"SHA3256":    crypto.SHA3_256,
+	"SHA3384":    crypto.SHA3_384,
+	"SHA3512":    crypto.SHA3_512,
+	"BLAKE2s256": crypto.BLAKE2s_256,
+	"BLAKE2b256": crypto.BLAKE2b_256,
+	"BLAKE2b384": crypto.BLAKE2b_384,
+	"BLAKE2b512": crypto.BLAKE2b_512,
 	/*
 		"MD5SHA1":    crypto.MD5SHA1,
 		"RIPEMD160":  crypto.RIPEMD160,
-		"SHA3224":    crypto.SHA3_224,
-		"SHA3256":    crypto.SHA3_256,
-		"SHA3384":    crypto.SHA3_384,
-		"SHA3512":    crypto.SHA3_512,
 		"SHA512224":  crypto.SHA512_224,
 		"SHA512256":  crypto.SHA512_256,
-		"BLAKE2s256": crypto.BLAKE2s_256,
-		"BLAKE2b256": crypto.BLAKE2b_256,
-		"BLAKE2b384": crypto.BLAKE2b_384,
-		"BLAKE2b512": crypto.BLAKE2b_512,
 	*/
 }
 
 var (
 	hashAlgo hash.Hash
+	// hashFunc creates independent hashes of the same algorithm as hashAlgo for concurrent use
+	hashFunc crypto.Hash
 )
 
 // GetHashAlgorithm retrieves a crypto.Hash for a name.
 // if no available name is provided, SHA512 is returned.
 func GetHashAlgorithm(algo string) crypto.Hash {
+	h, err := ParseHashAlgorithm(algo)
+	if err != nil {
+		h = crypto.SHA512
+	}
+	return h
+}
+
+// ParseHashAlgorithm retrieves a crypto.Hash for a name, failing for unknown names
+func ParseHashAlgorithm(algo string) (crypto.Hash, error) {
 	re := regexp.MustCompilePOSIX(`[^a-zA-Z0-9]`)
 	h, ok := availableHashes[re.ReplaceAllString(algo, "")]
 	if !ok {
-		h = crypto.SHA512
+		return 0, fmt.Errorf("unknown hashing algorithm '%s'", algo)
 	}
-	return h
+	return h, nil
 }
 
 // NewSignatureList creates a new signature list
 func NewSignatureList(algo string) *FileSignatures {
 	s := &FileSignatures{}
-	hashAlgo = GetHashAlgorithm(algo).New()
+	hashFunc = GetHashAlgorithm(algo)
+	hashAlgo = hashFunc.New()
 	return s
 }
 
@@ -94,6 +112,30 @@ func (f *FileSignatures) AddFileFromReader(name string, contents io.Reader) (err
 	return nil
 }
 
+// AddFileWhileReading hashes contents while they are consumed by a function and adds the hash to the list.
+// Contents not read by the function are hashed afterwards, so the hash always covers all contents.
+func (f *FileSignatures) AddFileWhileReading(name string, contents io.Reader, consume func(io.Reader) error) error {
+	sum, err := hashWhileReading(contents, consume)
+	if err != nil {
+		return err
+	}
+	(*f)[name] = sum
+	return nil
+}
+
+// hashWhileReading hashes contents using a new hash while they are consumed by a function.
+// As it does not use the shared hashAlgo, it is safe for concurrent use.
+func hashWhileReading(contents io.Reader, consume func(io.Reader) error) (string, error) {
+	h := hashFunc.New()
+	if err := consume(io.TeeReader(contents, h)); err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, contents); err != nil {
+		return "", err
+	}
+	return string(h.Sum(nil)), nil
+}
+
 // Bytes gets the list formatted as []byte
 func (f *FileSignatures) Bytes() []byte {
 	keys := make([]string, 0, len(*f))
@@ -123,3 +165,21 @@ func (f *FileSignatures) Equals(other *FileSignatures) bool {
 	}
 	return true
 }
+
+// VerifyDigest checks contents against an expected digest of the form <algorithm>:<hex>, e.g. sha256:2c26b4...
+func VerifyDigest(contents io.Reader, expected string) error {
+	algo, _, found := strings.Cut(expected, ":")
+	hashFunc, ok := availableHashes[strings.ToUpper(algo)]
+	if !found || !ok {
+		return fmt.Errorf("invalid digest '%s'", expected)
+	}
+	h := hashFunc.New()
+	if _, err := io.Copy(h, contents); err != nil {
+		return err
+	}
+	actual := strings.ToLower(algo) + ":" + hex.EncodeToString(h.Sum(nil))
+	if actual != strings.ToLower(expected) {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
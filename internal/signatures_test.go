@@ -28,11 +28,12 @@ import (
 var re = regexp.MustCompile(`[^a-zA-Z0-9]`)
 
 func Test_AvailableHashes(t *testing.T) {
-	list := []string{"SHA224", "SHA256", "SHA384", "SHA512"}
+	list := []string{"SHA224", "SHA256", "SHA384", "SHA512", "SHA3256", "SHA3512", "BLAKE2s256", "BLAKE2b256", "BLAKE2b512"}
 	for _, l := range list {
 		hsh, ex := availableHashes[l]
 		assert.True(t, ex)
 		assert.Equal(t, l, re.ReplaceAllString(hsh.String(), ""))
+		assert.True(t, hsh.Available())
 	}
 	// Some invalid ones
 	list = []string{"MD5", "SHA1", "MD5SHA1", "SHA128", "RIPEMD160"}
@@ -48,6 +49,8 @@ func Test_GetHashAlgorithm(t *testing.T) {
 	for _, l := range list {
 		assert.Equal(t, availableHashes[l], GetHashAlgorithm(l))
 	}
+	assert.Equal(t, crypto.SHA3_256, GetHashAlgorithm("SHA3-256"))
+	assert.Equal(t, crypto.BLAKE2b_512, GetHashAlgorithm("BLAKE2b-512"))
 	// Some invalid ones
 	list = []string{"MD5", "SHA1", "MD5SHA1", "SHA128", "RIPEMD160"}
 	for _, l := range list {
@@ -55,6 +58,14 @@ func Test_GetHashAlgorithm(t *testing.T) {
 	}
 }
 
+func Test_ParseHashAlgorithm(t *testing.T) {
+	h, err := ParseHashAlgorithm("SHA3-384")
+	assert.NoError(t, err)
+	assert.Equal(t, crypto.SHA3_384, h)
+	_, err = ParseHashAlgorithm("SHA521")
+	assert.ErrorContains(t, err, "unknown hashing algorithm 'SHA521'")
+}
+
 func Test_NewSignatureList(t *testing.T) {
 	list := []string{"SHA224", "SHA256", "SHA384", "SHA512"}
 	for _, l := range list {
@@ -122,3 +133,13 @@ func Test_FileSignatures_Equals(t *testing.T) {
 	assert.NoError(t, slOtherContent.AddFile("foo", []byte("bar")))
 	assert.False(t, slNull.Equals(slOtherContent))
 }
+
+func Test_VerifyDigest(t *testing.T) {
+	// sha256 of "foo"
+	digest := "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	assert.NoError(t, VerifyDigest(strings.NewReader("foo"), digest))
+	assert.NoError(t, VerifyDigest(strings.NewReader("foo"), strings.ToUpper(digest)))
+	assert.ErrorContains(t, VerifyDigest(strings.NewReader("bar"), digest), "digest mismatch: expected "+digest)
+	assert.ErrorContains(t, VerifyDigest(strings.NewReader("foo"), "md5:abc"), "invalid digest 'md5:abc'")
+	assert.ErrorContains(t, VerifyDigest(strings.NewReader("foo"), "2c26b46b"), "invalid digest")
+}
@@ -0,0 +1,212 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// paxSparseSize holds the real size of a sparse file
+	paxSparseSize = "SEALPACK.sparse.size"
+	// paxSparseMap holds the data regions of a sparse file as comma-separated offset,length pairs
+	paxSparseMap = "SEALPACK.sparse.map"
+)
+
+// zeroBlock is compared to blocks of sparse files to find holes
+var zeroBlock = make([]byte, copyBufferSize)
+
+// sparseRegion is a region of a sparse file containing data
+type sparseRegion struct {
+	Offset int64
+	Length int64
+}
+
+// sparseRegions detects the data regions of a file. It only returns true if the file contains holes.
+func sparseRegions(f *os.File, size int64) ([]sparseRegion, bool) {
+	regions, err := dataRegions(f, size)
+	if err != nil {
+		return nil, false
+	}
+	var packed int64
+	for _, region := range regions {
+		packed += region.Length
+	}
+	return regions, packed < size
+}
+
+// setSparseHeader stores the data regions in the PAX records of a header and sets the size to the packed size
+func setSparseHeader(h *tar.Header, regions []sparseRegion) {
+	values := make([]string, 0, 2*len(regions))
+	var packed int64
+	for _, region := range regions {
+		values = append(values, strconv.FormatInt(region.Offset, 10), strconv.FormatInt(region.Length, 10))
+		packed += region.Length
+	}
+	if h.PAXRecords == nil {
+		h.PAXRecords = map[string]string{}
+	}
+	h.PAXRecords[paxSparseSize] = strconv.FormatInt(h.Size, 10)
+	h.PAXRecords[paxSparseMap] = strings.Join(values, ",")
+	h.Size = packed
+}
+
+// packedReader reads only the data regions of a file
+func packedReader(f *os.File, regions []sparseRegion) io.Reader {
+	readers := make([]io.Reader, len(regions))
+	for i, region := range regions {
+		readers[i] = io.NewSectionReader(f, region.Offset, region.Length)
+	}
+	return io.MultiReader(readers...)
+}
+
+// isSparseEntry checks if an archive entry contains a packed sparse file
+func isSparseEntry(h *tar.Header) bool {
+	_, ok := h.PAXRecords[paxSparseMap]
+	return ok
+}
+
+// contentSize is the real size of the contents of an archive entry
+func contentSize(h *tar.Header) int64 {
+	if size, err := strconv.ParseInt(h.PAXRecords[paxSparseSize], 10, 64); err == nil && isSparseEntry(h) {
+		return size
+	}
+	if size, err := strconv.ParseInt(h.PAXRecords[paxChunkSize], 10, 64); err == nil && isChunkedEntry(h) {
+		return size
+	}
+	if size, err := strconv.ParseInt(h.PAXRecords[paxLayersSize], 10, 64); err == nil && isLayeredEntry(h) {
+		return size
+	}
+	return h.Size
+}
+
+// diskSize is the space the contents of an archive entry take up on disk; holes of sparse files take up none
+func diskSize(h *tar.Header) int64 {
+	if isSparseEntry(h) {
+		return h.Size
+	}
+	return contentSize(h)
+}
+
+// parseSparseHeader reads and validates the data regions of a packed sparse file from its header
+func parseSparseHeader(h *tar.Header) (regions []sparseRegion, size int64, err error) {
+	if size, err = strconv.ParseInt(h.PAXRecords[paxSparseSize], 10, 64); err != nil {
+		return nil, 0, fmt.Errorf("invalid sparse size of %s: %v", h.Name, err)
+	}
+	values := strings.Split(h.PAXRecords[paxSparseMap], ",")
+	if len(values)%2 != 0 {
+		return nil, 0, fmt.Errorf("invalid sparse map of %s", h.Name)
+	}
+	var end, packed int64
+	for i := 0; i < len(values); i += 2 {
+		var region sparseRegion
+		if region.Offset, err = strconv.ParseInt(values[i], 10, 64); err != nil {
+			return nil, 0, fmt.Errorf("invalid sparse map of %s: %v", h.Name, err)
+		}
+		if region.Length, err = strconv.ParseInt(values[i+1], 10, 64); err != nil {
+			return nil, 0, fmt.Errorf("invalid sparse map of %s: %v", h.Name, err)
+		}
+		if region.Offset < end || region.Length < 0 || region.Offset+region.Length > size {
+			return nil, 0, fmt.Errorf("invalid sparse map of %s: overlapping or out of bounds regions", h.Name)
+		}
+		end = region.Offset + region.Length
+		packed += region.Length
+		regions = append(regions, region)
+	}
+	if packed != h.Size {
+		return nil, 0, fmt.Errorf("invalid sparse map of %s: %d bytes mapped, %d stored", h.Name, packed, h.Size)
+	}
+	return regions, size, nil
+}
+
+// expandedReader expands the packed data of a sparse file to its real contents, filling holes with zeros
+type expandedReader struct {
+	r       io.Reader
+	regions []sparseRegion
+	size    int64
+	pos     int64
+}
+
+// newExpandedReader creates a Reader for the real contents of a packed sparse archive entry
+func newExpandedReader(h *tar.Header, r io.Reader) (io.Reader, error) {
+	regions, size, err := parseSparseHeader(h)
+	if err != nil {
+		return nil, err
+	}
+	return &expandedReader{r: r, regions: regions, size: size}, nil
+}
+
+// Read implements io.Reader
+func (e *expandedReader) Read(p []byte) (n int, err error) {
+	for len(e.regions) > 0 && e.pos >= e.regions[0].Offset+e.regions[0].Length {
+		e.regions = e.regions[1:]
+	}
+	if e.pos >= e.size {
+		return 0, io.EOF
+	}
+	if len(e.regions) == 0 || e.pos < e.regions[0].Offset {
+		holeEnd := e.size
+		if len(e.regions) > 0 {
+			holeEnd = e.regions[0].Offset
+		}
+		n = int(min(int64(len(p)), holeEnd-e.pos))
+		clear(p[:n])
+	} else {
+		regionEnd := e.regions[0].Offset + e.regions[0].Length
+		n, err = e.r.Read(p[:min(int64(len(p)), regionEnd-e.pos)])
+		if err == io.EOF {
+			// The packed data may only end together with the last region
+			err = nil
+			if e.pos+int64(n) < regionEnd || len(e.regions) > 1 {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+	}
+	e.pos += int64(n)
+	return n, err
+}
+
+// writeSparse copies a Reader to a file, skipping blocks containing only zeros so the file system can keep them as holes.
+// The size of buf defines the block size and must not exceed copyBufferSize.
+func writeSparse(f *os.File, r io.Reader, buf []byte) (written int64, err error) {
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			if bytes.Equal(buf[:n], zeroBlock[:n]) {
+				_, err = f.Seek(int64(n), io.SeekCurrent)
+			} else {
+				_, err = f.Write(buf[:n])
+			}
+			if err != nil {
+				return written, err
+			}
+			written += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+	// Trailing holes are not written, so the size needs to be set explicitly
+	return written, f.Truncate(written)
+}
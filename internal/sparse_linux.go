@@ -0,0 +1,42 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+// dataRegions finds all regions of a file containing data using SEEK_DATA and SEEK_HOLE
+func dataRegions(f *os.File, size int64) (regions []sparseRegion, err error) {
+	var data, hole int64
+	for offset := int64(0); offset < size; offset = hole {
+		if data, err = f.Seek(offset, unix.SEEK_DATA); err != nil {
+			if errors.Is(err, syscall.ENXIO) {
+				// No more data until the end of the file
+				break
+			}
+			return nil, err
+		}
+		if hole, err = f.Seek(data, unix.SEEK_HOLE); err != nil {
+			return nil, err
+		}
+		regions = append(regions, sparseRegion{Offset: data, Length: min(hole, size) - data})
+	}
+	_, err = f.Seek(0, 0)
+	return regions, err
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"os"
+)
+
+// dataRegions treats the whole file as data, as holes cannot be detected on this platform
+func dataRegions(f *os.File, size int64) ([]sparseRegion, error) {
+	return []sparseRegion{{Offset: 0, Length: size}}, nil
+}
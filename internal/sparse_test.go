@@ -0,0 +1,113 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// createSparseFile creates a 16 MiB file with data only at its start and in its middle
+func createSparseFile(t *testing.T, name string) []byte {
+	f, err := os.Create(name)
+	assert.NoError(t, err)
+	defer f.Close()
+	assert.NoError(t, f.Truncate(16<<20))
+	_, err = f.WriteAt([]byte("header"), 0)
+	assert.NoError(t, err)
+	_, err = f.WriteAt([]byte("payload"), 8<<20)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Sync())
+	if _, sparse := sparseRegions(f, 16<<20); !sparse {
+		t.Skip("file system does not support sparse files")
+	}
+	contents, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	return contents
+}
+
+func TestSparse_SealUnseal(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "disk.img")
+	expected := createSparseFile(t, src)
+
+	arc := CreateArchiveWriter(true, 0)
+	sig := NewSignatureList("SHA256")
+	assert.NoError(t, arc.AddContents([]string{src}, nil, sig))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	size, err := arc.Finalize()
+	assert.NoError(t, err)
+	assert.Less(t, size, int64(1<<20))
+
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	out := t.TempDir()
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""))
+
+	restored := filepath.Join(out, "disk.img")
+	contents, err := os.ReadFile(restored)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(expected, contents))
+	rf, err := os.Open(restored)
+	assert.NoError(t, err)
+	defer rf.Close()
+	_, sparse := sparseRegions(rf, int64(len(contents)))
+	assert.True(t, sparse)
+}
+
+func TestExpandedReader(t *testing.T) {
+	h := &tar.Header{Name: "foo", Size: 5, PAXRecords: map[string]string{}}
+	setSparseHeader(&tar.Header{Size: 10, PAXRecords: h.PAXRecords}, []sparseRegion{{2, 2}, {7, 3}})
+	assert.Equal(t, int64(10), contentSize(h))
+	r, err := newExpandedReader(h, strings.NewReader("abcde"))
+	assert.NoError(t, err)
+	contents, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x00\x00ab\x00\x00\x00cde"), contents)
+
+	r, err = newExpandedReader(h, strings.NewReader("abc"))
+	assert.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestParseSparseHeader_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   string
+		layout string
+		want   string
+	}{
+		{"invalid size", "x", "0,5", "invalid sparse size"},
+		{"odd map", "10", "0,5,7", "invalid sparse map"},
+		{"overlapping", "10", "0,3,2,2", "overlapping or out of bounds"},
+		{"out of bounds", "10", "8,5", "overlapping or out of bounds"},
+		{"size mismatch", "10", "0,4", "4 bytes mapped, 5 stored"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &tar.Header{Name: "foo", Size: 5, PAXRecords: map[string]string{paxSparseSize: tt.size, paxSparseMap: tt.layout}}
+			_, _, err := parseSparseHeader(h)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+)
+
+// pemTypeOpenSSHPrivateKey is the PEM type of private keys in the OpenSSH format, as written by ssh-keygen
+const pemTypeOpenSSHPrivateKey = "OPENSSH PRIVATE KEY"
+
+// parseOpenSSHPrivateKey parses a private key in the OpenSSH format, asking for the passphrase if it is encrypted
+func parseOpenSSHPrivateKey(pemBytes []byte, path string) (any, error) {
+	key, err := ssh.ParseRawPrivateKey(pemBytes)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		var passphrase []byte
+		if passphrase, err = PassphraseProvider(path); err != nil {
+			return nil, err
+		}
+		defer wipe(passphrase)
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenSSH private key %s: %w", path, err)
+	}
+	// Ed25519 keys are provided as pointer, but crypto/ed25519 uses the value everywhere else
+	if edKey, ok := key.(*ed25519.PrivateKey); ok {
+		return *edKey, nil
+	}
+	return key, nil
+}
+
+// parseAuthorizedKeys parses public keys in the OpenSSH authorized_keys format, one per line.
+// Each key is identified by its comment, or by its source and position within it.
+func parseAuthorizedKeys(data []byte, source string) ([]RecipientKey, error) {
+	var keys []RecipientKey
+	for rest := bytes.TrimSpace(data); len(rest) > 0; rest = bytes.TrimSpace(rest) {
+		sshKey, comment, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			// Only comments and invalid lines remain
+			if len(keys) > 0 {
+				break
+			}
+			return nil, err
+		}
+		rest = next
+		cryptoKey, ok := sshKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported OpenSSH key type %s in %s", sshKey.Type(), source)
+		}
+		id := comment
+		if id == "" && len(keys) == 0 {
+			id = source
+		} else if id == "" {
+			id = fmt.Sprintf("%s#%d", source, len(keys)+1)
+		}
+		keys = append(keys, RecipientKey{ID: id, Key: cryptoKey.CryptoPublicKey()})
+	}
+	return keys, nil
+}
@@ -0,0 +1,99 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadPrivateKey_OpenSSH(t *testing.T) {
+	old := PassphraseProvider
+	defer func() { PassphraseProvider = old }()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	dir := t.TempDir()
+
+	block, err := ssh.MarshalPrivateKey(key, "edge")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "id_ed25519")
+	assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+	loaded, err := LoadPrivateKey(path)
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "edge", []byte("fnord"))
+	assert.NoError(t, err)
+	encPath := filepath.Join(dir, "id_ed25519_enc")
+	assert.NoError(t, os.WriteFile(encPath, pem.EncodeToMemory(block), 0600))
+	PassphraseProvider = func(path string) ([]byte, error) { return []byte("fnord"), nil }
+	loaded, err = LoadPrivateKey(encPath)
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+	PassphraseProvider = func(path string) ([]byte, error) { return []byte("wrong"), nil }
+	_, err = LoadPrivateKey(encPath)
+	assert.ErrorContains(t, err, "invalid OpenSSH private key")
+}
+
+func TestLoadPublicKey_OpenSSH(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sshKey, err := ssh.NewPublicKey(key.Public())
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "id_ecdsa.pub")
+	assert.NoError(t, os.WriteFile(path, ssh.MarshalAuthorizedKey(sshKey), 0644))
+	loaded, err := LoadPublicKey(path)
+	assert.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(loaded))
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, _ := ssh.NewPublicKey(edKey.Public())
+	ecPub, _ := ssh.NewPublicKey(ecKey.Public())
+	data := "# fleet recipients\n" +
+		strings.TrimSpace(string(ssh.MarshalAuthorizedKey(edPub))) + " line-7@plant\n\n" +
+		string(ssh.MarshalAuthorizedKey(ecPub))
+	keys, err := parseAuthorizedKeys([]byte(data), "keys.pub")
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, "line-7@plant", keys[0].ID)
+		assert.Equal(t, edKey.Public(), keys[0].Key)
+		assert.Equal(t, "keys.pub#2", keys[1].ID)
+	}
+
+	_, err = parseAuthorizedKeys([]byte("# nothing here\n"), "empty.pub")
+	assert.Error(t, err)
+}
+
+func TestLoadKeyringFile_AuthorizedKeys(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	pub, _ := ssh.NewPublicKey(key.Public())
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	assert.NoError(t, os.WriteFile(path, ssh.MarshalAuthorizedKey(pub), 0644))
+	keys, err := loadKeyringFile(path)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, path, keys[0].ID)
+	}
+}
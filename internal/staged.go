@@ -0,0 +1,105 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"errors"
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"os"
+	"path/filepath"
+)
+
+// stagedPlatform is chosen from multi-platform images, as it is by registry pulls
+var stagedPlatform = v1.Platform{OS: "linux", Architecture: "amd64"}
+
+// StagedImage loads an image from a directory of pre-staged images instead of its registry, e.g. in air-gapped
+// build enclaves. It is looked up as tarball <registry>/<name>:<tag>.oci or .tar, as OCI layout directory
+// <registry>/<name>:<tag>, or in an OCI layout of the whole directory by its reference name annotation.
+func StagedImage(dir string, img *ContainerImage) (v1.Image, error) {
+	tag, err := img.ImportTag()
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, localFileName(img.Registry+"/"+img.Name+":"+tag.TagStr()))
+	for _, suffix := range []string{OCISuffix, ".tar"} {
+		if info, err := os.Stat(base + suffix); err == nil && info.Mode().IsRegular() {
+			return stagedTarball(base+suffix, tag)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(base, "index.json")); err == nil {
+		return stagedLayout(base, img, tag, true)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		return stagedLayout(dir, img, tag, false)
+	}
+	return nil, WithHint(fmt.Errorf("image %s is not staged in %s", img, dir),
+		"stage it as %s.oci, e.g. with crane pull --format=tarball", base)
+}
+
+// stagedTarball reads an image from a tarball as written by crane or docker save, holding the tag or a single image
+func stagedTarball(path string, tag name.Tag) (v1.Image, error) {
+	image, err := tarball.ImageFromPath(path, &tag)
+	if err != nil {
+		if image, err = tarball.ImageFromPath(path, nil); err != nil {
+			return nil, fmt.Errorf("failed reading staged image %s: %w", path, err)
+		}
+	}
+	return image, nil
+}
+
+// stagedLayout reads an image from an OCI layout by its reference name annotation, which may be the full reference
+// or the tag only. A layout staged for the image alone may hold a single unannotated image.
+func stagedLayout(path string, img *ContainerImage, tag name.Tag, single bool) (v1.Image, error) {
+	index, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading staged images in %s: %w", path, err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading staged images in %s: %w", path, err)
+	}
+	refs := map[string]bool{img.Registry + "/" + img.Name + ":" + tag.TagStr(): true, tag.TagStr(): true, tag.String(): true}
+	for _, desc := range manifest.Manifests {
+		ref := desc.Annotations["org.opencontainers.image.ref.name"]
+		if refs[ref] || (single && len(manifest.Manifests) == 1) {
+			return stagedManifest(index, desc)
+		}
+	}
+	return nil, fmt.Errorf("image %s is not staged in OCI layout %s", img, path)
+}
+
+// stagedManifest provides the image of a descriptor, choosing the platform of a multi-platform image
+func stagedManifest(index v1.ImageIndex, desc v1.Descriptor) (v1.Image, error) {
+	if !desc.MediaType.IsIndex() {
+		return index.Image(desc.Digest)
+	}
+	child, err := index.ImageIndex(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := child.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range manifest.Manifests {
+		if m.Platform != nil && m.Platform.Satisfies(stagedPlatform) {
+			return child.Image(m.Digest)
+		}
+	}
+	return nil, errors.New("staged multi-platform image has no image for " + stagedPlatform.String())
+}
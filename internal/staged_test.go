@@ -0,0 +1,119 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failPulls makes every registry pull fail
+func failPulls(t *testing.T) {
+	old := pullImage
+	t.Cleanup(func() { pullImage = old })
+	pullImage = func(src string, opt ...crane.Option) (v1.Image, error) {
+		return nil, fmt.Errorf("no registry in an air gap")
+	}
+}
+
+func TestStagedImage_Tarball(t *testing.T) {
+	failPulls(t)
+	img, err := random.Image(1024, 2)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	ci := ParseContainerImage("alpine:3.17")
+	staged := filepath.Join(dir, localFileName(ci.String()+OCISuffix))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(staged), 0755))
+	tag, err := name.NewTag("docker.io/alpine:3.17")
+	assert.NoError(t, err)
+	assert.NoError(t, tarball.WriteToFile(staged, tag, img))
+
+	loaded, err := StagedImage(dir, ci)
+	assert.NoError(t, err)
+	want, _ := img.Digest()
+	got, err := loaded.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	f, err := SaveImage(ci, ImageSource{StagingDir: dir})
+	assert.NoError(t, err)
+	defer func() { _ = CleanupImages() }()
+	assert.NoError(t, f.Close())
+	_, err = SaveImage(ci, ImageSource{})
+	assert.ErrorContains(t, err, "air gap")
+}
+
+func TestStagedImage_Layout(t *testing.T) {
+	failPulls(t)
+	img, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	other, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	path, err := layout.Write(dir, empty.Index)
+	assert.NoError(t, err)
+	assert.NoError(t, path.AppendImage(other, layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": "other"})))
+	assert.NoError(t, path.AppendImage(img, layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": "docker.io/library/busybox:1.36"})))
+
+	loaded, err := StagedImage(dir, ParseContainerImage("docker.io/library/busybox:1.36"))
+	assert.NoError(t, err)
+	want, _ := img.Digest()
+	got, _ := loaded.Digest()
+	assert.Equal(t, want, got)
+	size, err := EstimateImageSize(ParseContainerImage("docker.io/library/busybox:1.36"), ImageSource{StagingDir: dir})
+	assert.NoError(t, err)
+	assert.Greater(t, size, int64(1024))
+
+	_, err = StagedImage(dir, ParseContainerImage("docker.io/library/busybox:1.37"))
+	assert.ErrorContains(t, err, "not staged")
+}
+
+func TestStagedImage_MultiPlatform(t *testing.T) {
+	amd64, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	arm64, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}}},
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+	)
+	dir := t.TempDir()
+	ci := ParseContainerImage("alpine:3.17")
+	path, err := layout.Write(filepath.Join(dir, localFileName(ci.String())), empty.Index)
+	assert.NoError(t, err)
+	assert.NoError(t, path.AppendIndex(index))
+
+	loaded, err := StagedImage(dir, ci)
+	assert.NoError(t, err)
+	want, _ := amd64.Digest()
+	got, _ := loaded.Digest()
+	assert.Equal(t, want, got)
+}
+
+func TestStagedImage_Missing(t *testing.T) {
+	_, err := StagedImage(t.TempDir(), ParseContainerImage("alpine:3.17"))
+	assert.ErrorContains(t, err, "is not staged")
+	assert.Contains(t, Hints(err)[0], "alpine:3.17.oci")
+}
@@ -0,0 +1,145 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ApplyState records the last unseal of a package into an installation, i.e. an output path and image target
+type ApplyState struct {
+	Output  string    `json:"output"`
+	Target  string    `json:"target"`
+	Package string    `json:"package"`
+	Digest  string    `json:"digest"`
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// StateStore is a JSON file holding the ApplyState of every installation on a device
+type StateStore struct {
+	path          string
+	Installations []*ApplyState `json:"installations"`
+}
+
+// PackageDigest provides the SHA-256 digest of a package. The checksum of the envelope is used if it has one,
+// otherwise the file is hashed.
+func PackageDigest(path string, checksum []byte) (string, error) {
+	if checksum == nil {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err = io.Copy(h, f); err != nil {
+			return "", err
+		}
+		checksum = h.Sum(nil)
+	}
+	return "sha256:" + hex.EncodeToString(checksum), nil
+}
+
+// LoadStateStore reads a state store, which is empty if the file does not exist yet
+func LoadStateStore(path string) (*StateStore, error) {
+	store := &StateStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("invalid state store %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// Path is the file the state store is kept in
+func (s *StateStore) Path() string {
+	return s.path
+}
+
+// Lookup finds the state of an installation, nil if nothing has been applied to it yet
+func (s *StateStore) Lookup(output, target string) *ApplyState {
+	for _, state := range s.Installations {
+		if state.Output == output && state.Target == target {
+			return state
+		}
+	}
+	return nil
+}
+
+// Applied checks whether the package with the digest was the last one successfully applied to an installation
+func (s *StateStore) Applied(digest, output, target string) bool {
+	state := s.Lookup(output, target)
+	return state != nil && state.Success && state.Digest == digest
+}
+
+// Record stores the state of an installation, replacing its previous state. The store is reloaded while locked,
+// so installations updated concurrently are kept, and written atomically.
+func (s *StateStore) Record(state *ApplyState) error {
+	lockName, err := pathLockName("state", s.path)
+	if err != nil {
+		return err
+	}
+	lock, err := AcquireLock(LockOptions{}, lockName)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Release() }()
+	current, err := LoadStateStore(s.path)
+	if err != nil {
+		return err
+	}
+	if previous := current.Lookup(state.Output, state.Target); previous != nil {
+		*previous = *state
+	} else {
+		current.Installations = append(current.Installations, state)
+	}
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.Installations = current.Installations
+	return nil
+}
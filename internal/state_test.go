@@ -0,0 +1,66 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackageDigest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "package.ipc")
+	assert.NoError(t, os.WriteFile(path, []byte("foo"), 0644))
+	digest, err := PackageDigest(path, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:"+fooDigest, digest)
+	// The checksum of the envelope is used without reading the file
+	digest, err = PackageDigest(filepath.Join(t.TempDir(), "missing.ipc"), []byte{0xab, 0xcd})
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:abcd", digest)
+}
+
+func TestStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	t.Cleanup(func() {
+		name, _ := pathLockName("state", path)
+		_ = os.Remove(filepath.Join(os.TempDir(), "sealpack-"+name+".lock"))
+	})
+	store, err := LoadStateStore(path)
+	assert.NoError(t, err)
+	assert.False(t, store.Applied("sha256:aa", "/opt/app", "containerd://default"))
+
+	assert.NoError(t, store.Record(&ApplyState{Output: "/opt/app", Target: "containerd://default", Digest: "sha256:aa", Success: true}))
+	assert.NoError(t, store.Record(&ApplyState{Output: "/opt/web", Target: "containerd://default", Digest: "sha256:bb", Error: "bad signature"}))
+
+	store, err = LoadStateStore(path)
+	assert.NoError(t, err)
+	assert.True(t, store.Applied("sha256:aa", "/opt/app", "containerd://default"))
+	assert.False(t, store.Applied("sha256:aa", "/opt/app", "registry.local"))
+	assert.False(t, store.Applied("sha256:aa", "/opt/other", "containerd://default"))
+	// Failed applies are repeated
+	assert.False(t, store.Applied("sha256:bb", "/opt/web", "containerd://default"))
+
+	// A newer package replaces the state of the installation
+	assert.NoError(t, store.Record(&ApplyState{Output: "/opt/app", Target: "containerd://default", Digest: "sha256:cc", Success: true}))
+	assert.False(t, store.Applied("sha256:aa", "/opt/app", "containerd://default"))
+	assert.True(t, store.Applied("sha256:cc", "/opt/app", "containerd://default"))
+	assert.Len(t, store.Installations, 2)
+
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0644))
+	_, err = LoadStateStore(path)
+	assert.ErrorContains(t, err, "invalid state store")
+}
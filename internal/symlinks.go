@@ -0,0 +1,104 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+const (
+	// SymlinksFollow adds the contents of the files symbolic links point to, the default
+	SymlinksFollow = "follow"
+	// SymlinksPreserve stores symbolic links as links, without reading their targets
+	SymlinksPreserve = "preserve"
+	// SymlinksReject aborts sealing at the first symbolic link
+	SymlinksReject = "reject"
+)
+
+// applySymlinkPolicy handles an entry found as symbolic link according to the Symlinks policy of the archive.
+// It returns true if the link has been stored as link, so its target must not be added.
+func (arc *WriteArchive) applySymlinkPolicy(entry ProvidedEntry) (stored bool, err error) {
+	switch arc.Symlinks {
+	case SymlinksReject:
+		return false, WithHint(fmt.Errorf("%s is a symbolic link to %s", entry.Source, entry.Link),
+			"add the target of the link instead, or seal with --symlinks follow or preserve")
+	case SymlinksPreserve:
+		arc.logger().Infof("seal: storing %s as symbolic link to %s", entry.Name, entry.Link)
+		return true, arc.writeSymlink(entry)
+	default:
+		arc.logger().Infof("seal: following symbolic link %s to %s", entry.Source, entry.Link)
+		return false, nil
+	}
+}
+
+// followDirectory adds the files of the directory a followed symbolic link points to under the name of the link,
+// like those of a directory given as path. It returns false if the link points to a file, which is added as usual.
+func (arc *WriteArchive) followDirectory(entry ProvidedEntry, signatures *FileSignatures) (followed bool, err error) {
+	if info, err := os.Stat(entry.Source); err != nil || !info.IsDir() {
+		return false, nil
+	}
+	target, err := filepath.EvalSymlinks(entry.Source)
+	if err != nil {
+		return true, fmt.Errorf("invalid symbolic link '%s': %v", entry.Source, err)
+	}
+	if arc.following[target] {
+		return true, fmt.Errorf("symbolic link %s leads back to %s, which is already being followed", entry.Source, target)
+	}
+	if arc.following == nil {
+		arc.following = map[string]bool{}
+	}
+	arc.following[target] = true
+	defer delete(arc.following, target)
+	resolved, err := ResolveFiles([]string{filepath.Join(entry.Source, "*")})
+	if err != nil {
+		return true, err
+	}
+	for _, file := range fileEntries(resolved) {
+		file.Name = path.Join(entry.Name, file.Name)
+		if err = arc.addEntry(file, signatures); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// writeSymlink stores a symbolic link. Like directories, links are not part of the TOC; unseal skips them.
+func (arc *WriteArchive) writeSymlink(entry ProvidedEntry) error {
+	if arc.sources == nil {
+		arc.sources = map[string]string{}
+	}
+	arc.sources[entry.Name] = entry.Source
+	header := &tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     entry.Name,
+		Linkname: entry.Link,
+		Mode:     0777,
+		Format:   tar.FormatPAX,
+	}
+	if info, err := os.Lstat(entry.Source); err == nil {
+		header.ModTime = info.ModTime()
+	}
+	if err := arc.beginEntry(entry.Name); err != nil {
+		return err
+	}
+	if err := arc.tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("cannot add %s to archive: %w", entry.Name, err)
+	}
+	return arc.tarWriter.Flush()
+}
@@ -0,0 +1,150 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// symlinkTree creates a directory with a file and a link to a file outside of it
+func symlinkTree(t *testing.T) string {
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	assert.NoError(t, os.WriteFile(outside, []byte("secret"), 0644))
+	dir := filepath.Join(t.TempDir(), "data")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+	if err := os.Symlink(outside, filepath.Join(dir, "link.txt")); err != nil {
+		t.Skipf("cannot create symbolic links: %v", err)
+	}
+	return dir
+}
+
+func TestResolveFiles_Symlinks(t *testing.T) {
+	dir := symlinkTree(t)
+	resolved, err := ResolveFiles([]string{dir})
+	assert.NoError(t, err)
+	links := map[string]string{}
+	for _, r := range resolved {
+		links[r.Name] = r.Link
+	}
+	assert.Equal(t, "", links["data/a.txt"])
+	assert.Equal(t, "secret.txt", filepath.Base(links["data/link.txt"]))
+}
+
+func TestAddContents_SymlinksFollow(t *testing.T) {
+	dir := symlinkTree(t)
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	sig := NewSignatureList("SHA256")
+	assert.NoError(t, arc.AddContents([]string{dir}, nil, sig))
+	assert.Contains(t, *sig, "data/a.txt")
+	assert.Contains(t, *sig, "data/link.txt")
+}
+
+func TestAddContents_SymlinksReject(t *testing.T) {
+	dir := symlinkTree(t)
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.Symlinks = SymlinksReject
+	err := arc.AddContents([]string{dir}, nil, NewSignatureList("SHA256"))
+	assert.ErrorContains(t, err, "link.txt is a symbolic link to")
+}
+
+func TestAddContents_SymlinksPreserve(t *testing.T) {
+	dir := symlinkTree(t)
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.Symlinks = SymlinksPreserve
+	sig := NewSignatureList("SHA256")
+	assert.NoError(t, arc.AddContents([]string{dir}, nil, sig))
+	assert.NotContains(t, *sig, "data/link.txt")
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+
+	headers, err := openTestArchive(t, arc).List()
+	assert.NoError(t, err)
+	var link *tar.Header
+	for _, h := range headers {
+		if h.Name == "data/link.txt" {
+			link = h
+		}
+	}
+	if assert.NotNil(t, link) {
+		assert.Equal(t, byte(tar.TypeSymlink), link.Typeflag)
+		assert.Equal(t, "secret.txt", filepath.Base(link.Linkname))
+		assert.Contains(t, ListString(headers), "link  data/link.txt -> ")
+	}
+
+	// Links are not signed, so unseal verifies the files and skips the link
+	out := t.TempDir()
+	assert.NoError(t, openTestArchive(t, arc).Unpack("../test/public.pem", "SHA256", out, "", ""))
+	assert.FileExists(t, filepath.Join(out, "data", "a.txt"))
+	_, err = os.Lstat(filepath.Join(out, "data", "link.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// symlinkedDir creates a link to a directory with a file and a link back to the directory
+func symlinkedDir(t *testing.T) string {
+	dir := filepath.Join(t.TempDir(), "real")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+	link := filepath.Join(t.TempDir(), "data")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Skipf("cannot create symbolic links: %v", err)
+	}
+	return link
+}
+
+func TestAddContents_SymlinkedArgument(t *testing.T) {
+	link := symlinkedDir(t)
+	resolved, err := ResolveFiles([]string{link})
+	assert.NoError(t, err)
+	if assert.Len(t, resolved, 1) {
+		assert.Equal(t, "data", resolved[0].Name)
+		assert.NotEmpty(t, resolved[0].Link)
+	}
+
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.Symlinks = SymlinksReject
+	assert.ErrorContains(t, arc.AddContents([]string{link}, nil, NewSignatureList("SHA256")), "data is a symbolic link to")
+
+	arc = CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.Symlinks = SymlinksPreserve
+	sig := NewSignatureList("SHA256")
+	assert.NoError(t, arc.AddContents([]string{link}, nil, sig))
+	assert.Empty(t, *sig)
+
+	arc = CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	sig = NewSignatureList("SHA256")
+	assert.NoError(t, arc.AddContents([]string{link}, nil, sig))
+	assert.Contains(t, *sig, "data/a.txt")
+}
+
+func TestAddContents_SymlinkLoop(t *testing.T) {
+	link := symlinkedDir(t)
+	assert.NoError(t, os.Symlink(".", filepath.Join(link, "self")))
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	err := arc.AddContents([]string{link}, nil, NewSignatureList("SHA256"))
+	assert.ErrorContains(t, err, "which is already being followed")
+}
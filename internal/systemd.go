@@ -0,0 +1,119 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"github.com/apex/log"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MetadataFileName is the archive entry holding the PackageMetadata, signed as part of the TOC
+	MetadataFileName = ".sealpack.metadata"
+	// maxMetadataSize limits the size of the metadata read from a package
+	maxMetadataSize = 1 << 20
+)
+
+// unitNamePattern matches the names of systemd units that can be restarted
+var unitNamePattern = regexp.MustCompile(`^[A-Za-z0-9:_.\\@-]+\.(service|socket|target|timer|path|mount)$`)
+
+// runSystemctl runs systemctl with arguments, replaceable for tests
+var runSystemctl = func(args ...string) error {
+	if out, err := exec.Command("systemctl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// PackageMetadata holds settings for the devices a package is unsealed on
+type PackageMetadata struct {
+	// RestartUnits lists the systemd units to restart after a successful unseal
+	RestartUnits []string `json:"restart_units,omitempty"`
+	// Packages declares the entries holding nested packages
+	Packages []NestedPackage `json:"packages,omitempty"`
+}
+
+// Validate checks the names of all units
+func (m *PackageMetadata) Validate() error {
+	for _, unit := range m.RestartUnits {
+		if !unitNamePattern.MatchString(unit) {
+			return fmt.Errorf("invalid systemd unit name '%s'", unit)
+		}
+	}
+	return validateNested(m.Packages)
+}
+
+// AddMetadata adds the metadata to the archive and the TOC, so it is signed with the contents
+func (arc *WriteArchive) AddMetadata(metadata *PackageMetadata, signatures *FileSignatures) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err = arc.AddToArchive(MetadataFileName, data); err != nil {
+		return fmt.Errorf("seal: failed adding metadata to archive: %v", err)
+	}
+	return signatures.AddFile(MetadataFileName, data)
+}
+
+// readMetadata parses the metadata of a package while hashing it for the TOC.
+// It must only be acted upon after the TOC has been verified.
+func (arc *ReadArchive) readMetadata(h *tar.Header, v *Verifier) error {
+	var data []byte
+	err := v.Signatures.AddFileWhileReading(h.Name, arc.TarReader, func(r io.Reader) (err error) {
+		data, err = io.ReadAll(io.LimitReader(r, maxMetadataSize))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	metadata := &PackageMetadata{}
+	if err = json.Unmarshal(data, metadata); err != nil {
+		return corruptEnvelope(fmt.Errorf("invalid package metadata: %w", err))
+	}
+	if err = metadata.Validate(); err != nil {
+		return corruptEnvelope(err)
+	}
+	arc.Metadata = metadata
+	return nil
+}
+
+// SystemdSupported reports an error if the systemd integration is not available on this platform
+func SystemdSupported() error {
+	return errSystemdUnsupported
+}
+
+// ApplySystemd restarts the units declared by the metadata of a package and notifies systemd about the readiness
+// of sealpack, if it runs as a service of type notify. The metadata may be nil for packages declaring nothing.
+func ApplySystemd(metadata *PackageMetadata, status string, logger log.Interface) error {
+	if errSystemdUnsupported != nil {
+		return errSystemdUnsupported
+	}
+	if metadata != nil {
+		for _, unit := range metadata.RestartUnits {
+			logger.Infof("unseal: restarting %s", unit)
+			if err := runSystemctl("restart", "--", unit); err != nil {
+				_ = notifySystemd("STATUS=failed restarting " + unit)
+				return err
+			}
+		}
+	}
+	return notifySystemd("READY=1\nSTATUS=" + status)
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+)
+
+// errSystemdUnsupported prevents using the systemd integration on platforms without systemd
+var errSystemdUnsupported = fmt.Errorf("systemd integration is only available on Linux")
+
+// notifySystemd is not supported on this platform
+func notifySystemd(string) error {
+	return errSystemdUnsupported
+}
@@ -0,0 +1,87 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto"
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackageMetadata_Validate(t *testing.T) {
+	assert.NoError(t, (&PackageMetadata{RestartUnits: []string{"app.service", "getty@tty1.service", "backup.timer"}}).Validate())
+	assert.ErrorContains(t, (&PackageMetadata{RestartUnits: []string{"--force"}}).Validate(), "invalid systemd unit name '--force'")
+	assert.Error(t, (&PackageMetadata{RestartUnits: []string{"app service"}}).Validate())
+	assert.Error(t, (&PackageMetadata{RestartUnits: []string{"app"}}).Validate())
+}
+
+func TestReadArchive_Metadata(t *testing.T) {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	assert.NoError(t, arc.AddToArchive("foo", []byte("foo")))
+	assert.NoError(t, sig.AddFile("foo", []byte("foo")))
+	assert.NoError(t, arc.AddMetadata(&PackageMetadata{RestartUnits: []string{"app.service"}}, sig))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+
+	out := t.TempDir()
+	ra := openTestArchive(t, arc)
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""))
+	assert.Equal(t, []string{"app.service"}, ra.Metadata.RestartUnits)
+	assert.NoFileExists(t, filepath.Join(out, MetadataFileName))
+
+	headers, err := openTestArchive(t, arc).List()
+	assert.NoError(t, err)
+	assert.Len(t, headers, 1)
+
+	digests, err := openTestArchive(t, arc).Digests("../test/public.pem", "SHA256", crypto.SHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": fooDigest}, digests)
+}
+
+func TestApplySystemd(t *testing.T) {
+	if SystemdSupported() != nil {
+		t.Skip("systemd is not supported on this platform")
+	}
+	var restarted []string
+	defer func(run func(...string) error) { runSystemctl = run }(runSystemctl)
+	runSystemctl = func(args ...string) error {
+		restarted = append(restarted, args[len(args)-1])
+		return nil
+	}
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.NoError(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	logger := &log.Logger{Handler: discard.Default}
+	assert.NoError(t, ApplySystemd(&PackageMetadata{RestartUnits: []string{"app.service", "web.socket"}}, "unsealed app.ipc", logger))
+	assert.Equal(t, []string{"app.service", "web.socket"}, restarted)
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "READY=1\nSTATUS=unsealed app.ipc", string(buf[:n]))
+
+	// Without notify socket and metadata, nothing is done
+	t.Setenv("NOTIFY_SOCKET", "")
+	assert.NoError(t, ApplySystemd(nil, "unsealed app.ipc", logger))
+}
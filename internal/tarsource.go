@@ -0,0 +1,125 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/klauspost/compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gzipMagic starts gzip compressed streams
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// TarProvider provides the regular files of an existing tar stream, optionally gzip compressed,
+// so build scripts producing tarballs can be sealed without restructuring. Modes and modification times are kept.
+// The stream is read once while resolving; each file is spooled to a temporary file removed by CleanupImages.
+type TarProvider struct {
+	// Source is the path of the tarball, or "-" for stdin
+	Source string
+}
+
+// Resolve reads the tar stream and provides an entry per regular file, named like in the stream
+func (p *TarProvider) Resolve() ([]ProvidedEntry, error) {
+	var r io.Reader = stdin
+	if p.Source != "-" {
+		f, err := os.Open(p.Source)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+	buffered := bufio.NewReader(r)
+	if magic, _ := buffered.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tar stream %s: %w", p.Source, err)
+		}
+		defer func() { _ = gz.Close() }()
+		r = gz
+	} else {
+		r = buffered
+	}
+	dir := filepath.Join(os.TempDir(), TmpFolderName)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	var entries []ProvidedEntry
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid tar stream %s: %w", p.Source, err)
+		}
+		switch h.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeReg:
+		default:
+			return nil, fmt.Errorf("unsupported entry %s in tar stream %s: only regular files can be sealed", h.Name, p.Source)
+		}
+		name := path.Clean(h.Name)
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return nil, fmt.Errorf("invalid entry name %s in tar stream %s", h.Name, p.Source)
+		}
+		spooled, err := spoolEntry(dir, tr, h.ModTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading %s from tar stream %s: %w", h.Name, p.Source, err)
+		}
+		size := h.Size
+		entries = append(entries, ProvidedEntry{
+			Name:   name,
+			Source: p.Source + ":" + strings.TrimPrefix(h.Name, "./"),
+			Open: func() (*os.File, error) {
+				return os.Open(spooled)
+			},
+			Size: func() (int64, bool, error) {
+				return size, false, nil
+			},
+			Mode:  os.FileMode(h.Mode).Perm(),
+			Local: true,
+		})
+	}
+}
+
+// spoolEntry copies the contents of an archive entry to a temporary file with its modification time and provides its name
+func spoolEntry(dir string, r io.Reader, modified time.Time) (string, error) {
+	f, err := os.CreateTemp(dir, "entry-")
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = removeTempFile(f)
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+	if !modified.IsZero() {
+		err = os.Chtimes(f.Name(), modified, modified)
+	}
+	return f.Name(), err
+}
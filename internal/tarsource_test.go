@@ -0,0 +1,105 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTarStream creates a tar stream like "tar c ." with a directory and two files
+func writeTarStream(t *testing.T, w io.Writer, extra ...*tar.Header) {
+	tw := tar.NewWriter(w)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "./etc/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for _, file := range [][2]string{{"./etc/app.yaml", "port: 80"}, {"./README", "Hold your breath"}} {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: file[0], Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(file[1]))}))
+		_, err := tw.Write([]byte(file[1]))
+		assert.NoError(t, err)
+	}
+	for _, h := range extra {
+		assert.NoError(t, tw.WriteHeader(h))
+	}
+	assert.NoError(t, tw.Close())
+}
+
+// resolvedContents reads the contents of all provided entries by their names
+func resolvedContents(t *testing.T, entries []ProvidedEntry) map[string]string {
+	contents := map[string]string{}
+	for _, entry := range entries {
+		f, err := entry.Open()
+		assert.NoError(t, err)
+		data, err := io.ReadAll(f)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+		size, estimated, err := entry.Size()
+		assert.NoError(t, err)
+		assert.False(t, estimated)
+		assert.Equal(t, int64(len(data)), size)
+		contents[entry.Name] = string(data)
+	}
+	return contents
+}
+
+func TestTarProvider_Stdin(t *testing.T) {
+	defer func() { stdin = os.Stdin }()
+	defer func() { _ = CleanupImages() }()
+	buf := new(bytes.Buffer)
+	writeTarStream(t, buf)
+	stdin = buf
+
+	entries, err := (&TarProvider{Source: "-"}).Resolve()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, map[string]string{"etc/app.yaml": "port: 80", "README": "Hold your breath"}, resolvedContents(t, entries))
+}
+
+func TestTarProvider_Gzip(t *testing.T) {
+	defer func() { _ = CleanupImages() }()
+	file := filepath.Join(t.TempDir(), "contents.tar.gz")
+	f, err := os.Create(file)
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	writeTarStream(t, gz)
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, f.Close())
+
+	entries, err := (&TarProvider{Source: file}).Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"etc/app.yaml": "port: 80", "README": "Hold your breath"}, resolvedContents(t, entries))
+	assert.Equal(t, file+":etc/app.yaml", entries[0].Source)
+	assert.Equal(t, file+":README", entries[1].Source)
+}
+
+func TestTarProvider_Invalid(t *testing.T) {
+	defer func() { _ = CleanupImages() }()
+	tests := map[string]*tar.Header{
+		"only regular files can be sealed": {Name: "link", Typeflag: tar.TypeSymlink, Linkname: "README"},
+		"invalid entry name":               {Name: "../escape", Typeflag: tar.TypeReg},
+	}
+	for expected, h := range tests {
+		file := filepath.Join(t.TempDir(), "contents.tar")
+		f, err := os.Create(file)
+		assert.NoError(t, err)
+		writeTarStream(t, f, h)
+		assert.NoError(t, f.Close())
+		_, err = (&TarProvider{Source: file}).Resolve()
+		assert.ErrorContains(t, err, expected)
+	}
+}
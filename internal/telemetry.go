@@ -0,0 +1,92 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// instrumentationName identifies the spans and metrics of sealpack
+const instrumentationName = "github.com/innomotics/sealpack"
+
+// Phases of seal and unseal operations, recorded as spans and in the phase duration metric
+const (
+	PhasePull     = "pull"
+	PhaseCompress = "compress"
+	PhaseSign     = "sign"
+	PhaseEncrypt  = "encrypt"
+	PhaseWrite    = "write"
+	PhaseUpload   = "upload"
+	PhaseDecrypt  = "decrypt"
+	PhaseExtract  = "extract"
+	PhaseVerify   = "verify"
+	PhaseImport   = "import"
+)
+
+// The global providers delegate to the ones registered by the application, so instruments can be created upfront.
+// Without registered providers, all telemetry is discarded.
+var (
+	tracer        = otel.Tracer(instrumentationName)
+	meter         = otel.Meter(instrumentationName)
+	operations, _ = meter.Int64Counter("sealpack.operations",
+		metric.WithDescription("Number of seal and unseal operations by result"))
+	phaseDuration, _ = meter.Float64Histogram("sealpack.phase.duration",
+		metric.WithDescription("Duration of the phases of seal and unseal operations"), metric.WithUnit("s"))
+)
+
+// StartOperation starts the span of a seal or unseal operation as child of the span in ctx, if any; ctx may be nil.
+// The returned function ends it and counts the operation with its result.
+func StartOperation(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracer.Start(ctx, operation, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		result := "success"
+		if err != nil {
+			result = "failure"
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		operations.Add(ctx, 1, metric.WithAttributes(attribute.String("operation", operation), attribute.String("result", result)))
+		span.End()
+	}
+}
+
+// StartPhase starts the span of a phase as child of the operation in ctx, which may be nil.
+// The returned function ends it and records the duration of the phase.
+func StartPhase(ctx context.Context, phase string, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	started := time.Now()
+	ctx, span := tracer.Start(ctx, phase, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		result := "success"
+		if err != nil {
+			result = "failure"
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		phaseDuration.Record(ctx, time.Since(started).Seconds(), metric.WithAttributes(attribute.String("phase", phase), attribute.String("result", result)))
+		span.End()
+	}
+}
@@ -0,0 +1,139 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// testSpan records the relevant properties of a span
+type testSpan struct {
+	noop.Span
+	name   string
+	parent *testSpan
+	status codes.Code
+	ended  bool
+}
+
+func (s *testSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+func (s *testSpan) End(...trace.SpanEndOption)          { s.ended = true }
+
+// testTracer records all started spans
+type testTracer struct {
+	noop.Tracer
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	span := &testSpan{name: name}
+	span.parent, _ = trace.SpanFromContext(ctx).(*testSpan)
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type testTracerProvider struct {
+	noop.TracerProvider
+	tracer *testTracer
+}
+
+func (p testTracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer { return p.tracer }
+
+var (
+	recordingTracer    = &testTracer{}
+	registerTracerOnce sync.Once
+)
+
+// recordSpans registers the recording tracer globally, which is only possible once, and resets its spans
+func recordSpans() *testTracer {
+	registerTracerOnce.Do(func() {
+		otel.SetTracerProvider(testTracerProvider{tracer: recordingTracer})
+	})
+	recordingTracer.mu.Lock()
+	recordingTracer.spans = nil
+	recordingTracer.mu.Unlock()
+	return recordingTracer
+}
+
+func TestStartOperation(t *testing.T) {
+	tracer := recordSpans()
+	ctx, end := StartOperation(context.Background(), "seal")
+	_, endCompress := StartPhase(ctx, PhaseCompress)
+	endCompress(nil)
+	_, endWrite := StartPhase(ctx, PhaseWrite)
+	endWrite(errors.New("disk full"))
+	end(errors.New("disk full"))
+
+	assert.Len(t, tracer.spans, 3)
+	operation := tracer.spans[0]
+	assert.Equal(t, "seal", operation.name)
+	assert.Nil(t, operation.parent)
+	assert.Equal(t, codes.Error, operation.status)
+	assert.Equal(t, PhaseCompress, tracer.spans[1].name)
+	assert.Same(t, operation, tracer.spans[1].parent)
+	assert.Equal(t, codes.Unset, tracer.spans[1].status)
+	assert.Equal(t, PhaseWrite, tracer.spans[2].name)
+	assert.Equal(t, codes.Error, tracer.spans[2].status)
+	for _, span := range tracer.spans {
+		assert.True(t, span.ended)
+	}
+}
+
+func TestStartOperation_ParentContext(t *testing.T) {
+	tracer := recordSpans()
+	parent, endParent := tracer.Start(context.Background(), "deploy")
+	_, end := StartOperation(parent, "unseal")
+	end(nil)
+	endParent.End()
+	assert.Len(t, tracer.spans, 2)
+	assert.Same(t, tracer.spans[0], tracer.spans[1].parent)
+}
+
+func TestStartPhase_NilContext(t *testing.T) {
+	tracer := recordSpans()
+	// Archives used without an operation have no context
+	ctx, end := StartPhase(nil, PhaseVerify)
+	assert.NotNil(t, ctx)
+	end(nil)
+	assert.Len(t, tracer.spans, 1)
+	assert.Nil(t, tracer.spans[0].parent)
+}
+
+func TestWriteArchive_Telemetry(t *testing.T) {
+	tracer := recordSpans()
+	ctx, end := StartOperation(context.Background(), "seal")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	arc.Context = ctx
+	assert.NoError(t, arc.AddContents([]string{filepath.Join(TestFilePath, "public.pem")}, nil, NewSignatureList("SHA256")))
+	end(nil)
+
+	var names []string
+	for _, span := range tracer.spans {
+		names = append(names, span.name)
+	}
+	assert.Contains(t, names, PhaseCompress)
+}
@@ -0,0 +1,300 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// paxHashingAlgorithm holds the name of the hash the entries of an exported TOC are hashed with
+	paxHashingAlgorithm = "SEALPACK.hashing.algorithm"
+	// installedImagesFileName is the entry of a TOC file listing the images imported by unseal
+	installedImagesFileName = ".sealpack.images"
+)
+
+// InstalledImage describes where an image of a package has been imported to
+type InstalledImage struct {
+	// Reference is the imported image as registry/name:tag@digest
+	Reference string `json:"reference"`
+	// Namespace is the containerD namespace of local imports, empty for imports into a registry
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// TocFile is a signed TOC stored apart from its package, optionally listing the images unseal imported.
+// It allows verifying installed contents after the package is deleted.
+type TocFile struct {
+	toc              []byte
+	signature        []byte
+	signatureHash    string
+	hashingAlgorithm string
+	// Images maps the image entries of the TOC to where they have been imported to
+	Images map[string]InstalledImage
+}
+
+// NewTocFile creates an empty TocFile to be filled by ReadArchive.Unpack
+func NewTocFile() *TocFile {
+	return &TocFile{Images: map[string]InstalledImage{}}
+}
+
+// Save writes the TOC file as tar archive of the TOC and its signature, as stored in the package.
+// The images are not signed, as they are recorded at unseal; their references contain the digests imported after verification.
+func (t *TocFile) Save(output string) error {
+	if t.toc == nil {
+		return fmt.Errorf("no signed TOC to save")
+	}
+	buf := &bytes.Buffer{}
+	w := tar.NewWriter(buf)
+	if err := bytesToTar(w, TocFileName, t.toc, map[string]string{paxHashingAlgorithm: t.hashingAlgorithm}); err != nil {
+		return err
+	}
+	if err := bytesToTar(w, TocFileName+".sig", t.signature, map[string]string{paxSignatureHash: t.signatureHash}); err != nil {
+		return err
+	}
+	if len(t.Images) > 0 {
+		images, err := json.Marshal(t.Images)
+		if err != nil {
+			return err
+		}
+		if err = bytesToTar(w, installedImagesFileName, images, nil); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return WriteFileBytes(output, buf.Bytes())
+}
+
+// ExportToc writes the signed TOC of the archive to a standalone TocFile, so extracted contents can still be verified
+// after the package is deleted.
+func (arc *WriteArchive) ExportToc(output, hashingAlgorithm string) error {
+	if arc.toc == nil {
+		return fmt.Errorf("archive has no signed TOC to export")
+	}
+	return (&TocFile{
+		toc:              arc.toc,
+		signature:        arc.tocSignature,
+		signatureHash:    arc.tocSignatureHash,
+		hashingAlgorithm: hashingAlgorithm,
+	}).Save(output)
+}
+
+// TocCheck is the result of checking installed contents against a TocFile
+type TocCheck struct {
+	// Verified lists all files and images matching the TOC
+	Verified []string
+	// Skipped lists images neither recorded as imported nor stored as OCI files
+	Skipped []string
+}
+
+// ReadTocFile reads a TocFile and verifies its signature using the public key of the signing entity
+func ReadTocFile(tocPath, signingKeyPath string) (*TocFile, error) {
+	f, err := os.Open(tocPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// The contents are not hashed, so no signature list is needed
+	v := &Verifier{signingKey: signingKeyPath}
+	if v.sigVerifier, err = CreateVerifier(signingKeyPath); err != nil {
+		return nil, err
+	}
+	t := NewTocFile()
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid TOC file: %w", err)
+		}
+		switch h.Name {
+		case TocFileName:
+			t.hashingAlgorithm = h.PAXRecords[paxHashingAlgorithm]
+			err = v.AddTocComponent(h, tr)
+		case TocFileName + ".sig":
+			t.signatureHash = h.PAXRecords[paxSignatureHash]
+			err = v.AddTocComponent(h, tr)
+		case installedImagesFileName:
+			err = json.NewDecoder(tr).Decode(&t.Images)
+		default:
+			err = fmt.Errorf("invalid TOC file: unexpected entry %s", h.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if v.toc == nil || v.tocSignature == nil {
+		return nil, fmt.Errorf("invalid TOC file: TOC or signature missing")
+	}
+	// Verifying consumes the buffers
+	t.toc, t.signature = bytes.Clone(v.toc.Bytes()), bytes.Clone(v.tocSignature.Bytes())
+	if err = v.sigVerifier.VerifySignature(v.tocSignature, v.toc); err != nil {
+		return nil, WithHint(fmt.Errorf("%w: %w", ErrBadSignature, err), signerHint, signingKeyPath)
+	}
+	return t, nil
+}
+
+// Entries provides the hashes of all entries of the TOC and the hash they are calculated with
+func (t *TocFile) Entries() (map[string][]byte, crypto.Hash, error) {
+	hash, err := ParseHashAlgorithm(t.hashingAlgorithm)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid TOC file: %w", err)
+	}
+	entries, err := parseToc(t.toc, hash.Size())
+	return entries, hash, err
+}
+
+// parseToc splits a TOC into its entries. Entries are formatted as name, Delimiter, the raw hash and a newline.
+// Names may contain the Delimiter, so the hash is located by its size.
+func parseToc(toc []byte, hashSize int) (map[string][]byte, error) {
+	entries := map[string][]byte{}
+	for len(toc) > 0 {
+		found := false
+		for i := 0; i+len(Delimiter)+hashSize < len(toc); i++ {
+			end := i + len(Delimiter) + hashSize
+			if string(toc[i:i+len(Delimiter)]) == Delimiter && toc[end] == '\n' {
+				entries[string(toc[:i])] = toc[i+len(Delimiter) : end]
+				toc = toc[end+1:]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid TOC entry %q", toc[:min(len(toc), 64)])
+		}
+	}
+	return entries, nil
+}
+
+// imageDigest looks up the digest an installed image currently has, replaceable for tests
+var imageDigest = installedImageDigest
+
+// installedImageDigest looks up the digest of an imported image by its tag in containerD or the registry
+func installedImageDigest(img InstalledImage) (string, error) {
+	tag, _, _ := strings.Cut(img.Reference, "@")
+	if img.Namespace == "" {
+		digest, err := crane.Digest(tag)
+		if err != nil {
+			return "", wrapNetworkError(err)
+		}
+		return digest, nil
+	}
+	client, ctx, err := getContainerDClient(img.Namespace)
+	if err != nil {
+		return "", err
+	}
+	image, err := client.GetImage(ctx, tag)
+	if err != nil {
+		return "", err
+	}
+	return image.Target().Digest.String(), nil
+}
+
+// CheckInstallation verifies installed contents against a TocFile signed by the signing entity and reports all drift at once.
+// Files are rehashed below root. Images recorded by unseal are looked up by their tag and must still have the imported digest;
+// images stored as OCI files are checked like files, all others are skipped.
+func CheckInstallation(tocPath, signingKeyPath, root string) (*TocCheck, error) {
+	tocFile, err := ReadTocFile(tocPath, signingKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, hash, err := tocFile.Entries()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	check := &TocCheck{}
+	var errs []error
+	for _, name := range names {
+		path := filepath.Join(root, localFileName(name))
+		if strings.HasPrefix(name, ContainerImagePrefix) {
+			img, imported := tocFile.Images[name]
+			if imported {
+				err = verifyInstalledImage(img)
+			} else if _, statErr := os.Stat(path); statErr == nil {
+				err = verifyExtractedFile(path, hash, entries[name])
+			} else {
+				check.Skipped = append(check.Skipped, name)
+				continue
+			}
+		} else if strings.HasPrefix(name, ImageSignaturePrefix) || name == MetadataFileName || name == OriginsFileName {
+			// Signature bundles, metadata and origins are only used on unseal and inspect
+			continue
+		} else {
+			err = verifyExtractedFile(path, hash, entries[name])
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		check.Verified = append(check.Verified, name)
+	}
+	if len(errs) > 0 {
+		return check, WithHint(fmt.Errorf("%w: installed contents do not match the TOC: %w", ErrBadSignature, errors.Join(errs...)),
+			"the contents were modified or removed after unsealing; unseal the package again to restore them")
+	}
+	return check, nil
+}
+
+// verifyInstalledImage compares the current digest of an imported image with the imported one
+func verifyInstalledImage(img InstalledImage) error {
+	_, expected, _ := strings.Cut(img.Reference, "@")
+	digest, err := imageDigest(img)
+	if err != nil {
+		return fmt.Errorf("cannot look up %s: %w", img.Reference, err)
+	}
+	if digest != expected {
+		return fmt.Errorf("changed to %s", digest)
+	}
+	return nil
+}
+
+// verifyExtractedFile compares the hash of a file with the expected one
+func verifyExtractedFile(path string, hash crypto.Hash, expected []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("missing")
+		}
+		return err
+	}
+	defer f.Close()
+	h := hash.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return fmt.Errorf("changed")
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"crypto"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteArchive_ExportToc(t *testing.T) {
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	assert.ErrorContains(t, arc.ExportToc(filepath.Join(t.TempDir(), "package.toc"), "SHA256"), "no signed TOC")
+	assert.ErrorContains(t, NewTocFile().Save(filepath.Join(t.TempDir(), "package.toc")), "no signed TOC")
+}
+
+func TestParseToc(t *testing.T) {
+	sig := NewSignatureList("SHA256")
+	assert.NoError(t, sig.AddFile("a:b", []byte("foo")))
+	assert.NoError(t, sig.AddFile("bar", []byte("bar")))
+	entries, err := parseToc(sig.Bytes(), crypto.SHA256.Size())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"a:b": []byte((*sig)["a:b"]), "bar": []byte((*sig)["bar"])}, entries)
+
+	_, err = parseToc([]byte("foo:short\n"), crypto.SHA256.Size())
+	assert.ErrorContains(t, err, "invalid TOC entry")
+}
+
+func TestCheckInstallation(t *testing.T) {
+	arc := sealTestArchive(t, map[string]string{"foo": "foo", "dir/bar": "bar", ContainerImagePrefix + "/alpine.oci": "image"}, nil)
+	tocPath := filepath.Join(t.TempDir(), "package.toc")
+	assert.NoError(t, arc.ExportToc(tocPath, "SHA256"))
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "dir"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "foo"), []byte("foo"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "dir", "bar"), []byte("bar"), 0644))
+
+	result, err := CheckInstallation(tocPath, "../test/public.pem", root)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dir/bar", "foo"}, result.Verified)
+	assert.Equal(t, []string{ContainerImagePrefix + "/alpine.oci"}, result.Skipped)
+
+	// Images stored as OCI files are checked like files
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, ContainerImagePrefix), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, ContainerImagePrefix, "alpine.oci"), []byte("image"), 0644))
+	result, err = CheckInstallation(tocPath, "../test/public.pem", root)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ContainerImagePrefix + "/alpine.oci", "dir/bar", "foo"}, result.Verified)
+
+	// All drift is reported at once
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "foo"), []byte("changed"), 0644))
+	assert.NoError(t, os.Remove(filepath.Join(root, "dir", "bar")))
+	_, err = CheckInstallation(tocPath, "../test/public.pem", root)
+	assert.ErrorIs(t, err, ErrBadSignature)
+	assert.ErrorContains(t, err, "foo: changed")
+	assert.ErrorContains(t, err, "dir/bar: missing")
+	assert.NotEmpty(t, Hints(err))
+
+	_, err = CheckInstallation(tocPath, "../test/pkcs1-public.pem", root)
+	assert.ErrorIs(t, err, ErrBadSignature)
+}
+
+func TestCheckInstallation_Images(t *testing.T) {
+	digests := map[string]string{"docker.io/library/alpine:3": "sha256:aaa"}
+	defer func() { imageDigest = installedImageDigest }()
+	imageDigest = func(img InstalledImage) (string, error) {
+		tag, _, _ := strings.Cut(img.Reference, "@")
+		if digest, ok := digests[tag]; ok {
+			return digest, nil
+		}
+		return "", fmt.Errorf("not found")
+	}
+
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	for _, name := range []string{ContainerImagePrefix + "/alpine.oci", ContainerImagePrefix + "/busybox.oci"} {
+		assert.NoError(t, arc.AddToArchive(name, []byte("image")))
+		assert.NoError(t, sig.AddFile(name, []byte("image")))
+	}
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	tocFile := NewTocFile()
+	tocFile.toc, tocFile.signature, tocFile.signatureHash, tocFile.hashingAlgorithm = arc.toc, arc.tocSignature, arc.tocSignatureHash, "SHA256"
+	tocFile.Images[ContainerImagePrefix+"/alpine.oci"] = InstalledImage{Reference: "docker.io/library/alpine:3@sha256:aaa", Namespace: "default"}
+	tocFile.Images[ContainerImagePrefix+"/busybox.oci"] = InstalledImage{Reference: "docker.io/library/busybox:1@sha256:bbb", Namespace: "default"}
+	tocPath := filepath.Join(t.TempDir(), "installed.toc")
+	assert.NoError(t, tocFile.Save(tocPath))
+
+	read, err := ReadTocFile(tocPath, "../test/public.pem")
+	assert.NoError(t, err)
+	assert.Equal(t, tocFile.Images, read.Images)
+
+	result, err := CheckInstallation(tocPath, "../test/public.pem", t.TempDir())
+	assert.ErrorContains(t, err, ContainerImagePrefix+"/busybox.oci: cannot look up docker.io/library/busybox:1@sha256:bbb")
+	assert.Equal(t, []string{ContainerImagePrefix + "/alpine.oci"}, result.Verified)
+
+	digests["docker.io/library/busybox:1"] = "sha256:bbb"
+	digests["docker.io/library/alpine:3"] = "sha256:ccc"
+	_, err = CheckInstallation(tocPath, "../test/public.pem", t.TempDir())
+	assert.ErrorContains(t, err, ContainerImagePrefix+"/alpine.oci: changed to sha256:ccc")
+}
+
+func TestReadArchive_Unpack_Installed(t *testing.T) {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	assert.NoError(t, arc.AddToArchive("foo", []byte("foo")))
+	assert.NoError(t, sig.AddFile("foo", []byte("foo")))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+
+	root := t.TempDir()
+	ra := openTestArchive(t, arc)
+	ra.Installed = NewTocFile()
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", root, "", ""))
+	manifest := filepath.Join(t.TempDir(), "installed.toc")
+	assert.NoError(t, ra.Installed.Save(manifest))
+	result, err := CheckInstallation(manifest, "../test/public.pem", root)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, result.Verified)
+}
+
+func TestReadTocFile_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "package.toc")
+	assert.NoError(t, os.WriteFile(path, []byte{}, 0644))
+	_, err := ReadTocFile(path, "../test/public.pem")
+	assert.ErrorContains(t, err, "TOC or signature missing")
+	_, err = ReadTocFile(filepath.Join(t.TempDir(), "missing.toc"), "../test/public.pem")
+	assert.Error(t, err)
+}
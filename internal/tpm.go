@@ -0,0 +1,117 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// TPMKeyPrefix marks key references to a persistent TPM key handle, e.g. tpm:0x81000100
+	TPMKeyPrefix = "tpm:"
+	// DefaultTPMHandle is the persistent handle device keys are created at if none is provided
+	DefaultTPMHandle = "0x81000100"
+)
+
+// runTPMTool runs a command of the tpm2-tools and provides its standard output
+var runTPMTool = func(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, WithHint(fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(stderr.String())),
+			"TPM keys require the tpm2-tools and access to the TPM resource manager /dev/tpmrm0")
+	}
+	return out, nil
+}
+
+// IsTPMKey checks whether a key reference points to a TPM key handle
+func IsTPMKey(path string) bool {
+	_, ok := keyProviders[keyScheme(path)].(tpmKeyProvider)
+	return ok
+}
+
+// tpmKey is an RSA key that never leaves the TPM, referenced by its persistent handle
+type tpmKey struct {
+	handle string
+}
+
+// CreateTPMKey creates an RSA decryption key in the TPM and persists it at the handle.
+// The key is created below the owner storage primary key and cannot be exported.
+func CreateTPMKey(handle string) (crypto.PublicKey, error) {
+	dir, err := os.MkdirTemp("", "sealpack-tpm-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	primary, public, private, key := filepath.Join(dir, "primary.ctx"), filepath.Join(dir, "key.pub"),
+		filepath.Join(dir, "key.priv"), filepath.Join(dir, "key.ctx")
+	for _, args := range [][]string{
+		{"tpm2_createprimary", "-C", "o", "-c", primary},
+		{"tpm2_create", "-C", primary, "-G", "rsa2048", "-u", public, "-r", private,
+			"-a", "fixedtpm|fixedparent|sensitivedataorigin|userwithauth|decrypt"},
+		{"tpm2_load", "-C", primary, "-u", public, "-r", private, "-c", key},
+		{"tpm2_evictcontrol", "-C", "o", "-c", key, handle},
+	} {
+		if _, err = runTPMTool(args[0], args[1:]...); err != nil {
+			return nil, err
+		}
+	}
+	return tpmKey{handle: handle}.Public()
+}
+
+// Public reads the public key of the TPM key
+func (k tpmKey) Public() (crypto.PublicKey, error) {
+	dir, err := os.MkdirTemp("", "sealpack-tpm-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	pemPath := filepath.Join(dir, "public.pem")
+	if _, err = runTPMTool("tpm2_readpublic", "-c", k.handle, "-f", "pem", "-o", pemPath); err != nil {
+		return nil, err
+	}
+	return LoadPublicKey(pemPath)
+}
+
+// decrypt decrypts an RSA PKCS#1 v1.5 key entry within the TPM
+func (k tpmKey) decrypt(entry []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "sealpack-tpm-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	in, out := filepath.Join(dir, "entry"), filepath.Join(dir, "key")
+	if err = os.WriteFile(in, entry, 0600); err != nil {
+		return nil, err
+	}
+	if _, err = runTPMTool("tpm2_rsadecrypt", "-c", k.handle, "-s", "rsaes", "-o", out, in); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(out, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = removeTempFile(f) }()
+	return io.ReadAll(f)
+}
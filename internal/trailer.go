@@ -0,0 +1,193 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/innomotics/sealpack/format"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// checksumChunkSize is the size of the chunks checked separately to locate corruptions
+	checksumChunkSize = format.ChecksumChunkSize
+	// trailerFixedSize is the size of a trailer without chunk checksums:
+	// chunk size, chunk count, SHA-256 digest and trailer length
+	trailerFixedSize = format.TrailerFixedSize
+)
+
+var crcTable = format.CRCTable
+
+// A checksumTrailer is appended to v2 envelopes and covers everything before it.
+// The SHA-256 digest detects any modification, the CRC32C of each chunk tells where a file is corrupted.
+type checksumTrailer struct {
+	chunkSize uint32
+	chunks    []uint32
+	digest    []byte
+	// offset of the trailer in the file, which is the number of bytes covered
+	offset int64
+}
+
+// Bytes encodes the trailer, ending with its own length so it can be found from the end of a file
+func (t *checksumTrailer) Bytes() []byte {
+	length := trailerFixedSize + 4*len(t.chunks)
+	buf := make([]byte, 0, length)
+	buf = binary.LittleEndian.AppendUint32(buf, t.chunkSize)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(t.chunks)))
+	for _, chunk := range t.chunks {
+		buf = binary.LittleEndian.AppendUint32(buf, chunk)
+	}
+	buf = append(buf, t.digest...)
+	return binary.LittleEndian.AppendUint32(buf, uint32(length))
+}
+
+// readTrailer reads the checksum trailer from the end of an envelope
+func readTrailer(input io.ReadSeeker) (*checksumTrailer, error) {
+	size, err := input.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size < trailerFixedSize {
+		return nil, fmt.Errorf("file truncated at byte %d: checksum trailer is missing", size)
+	}
+	if _, err = input.Seek(-4, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	var length uint32
+	if err = binary.Read(input, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length < trailerFixedSize || int64(length) > size || (length-trailerFixedSize)%4 != 0 {
+		return nil, fmt.Errorf("file corrupted or truncated at the end: invalid checksum trailer")
+	}
+	t := &checksumTrailer{offset: size - int64(length)}
+	if _, err = input.Seek(t.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, length)
+	if _, err = io.ReadFull(input, raw); err != nil {
+		return nil, err
+	}
+	t.chunkSize = binary.LittleEndian.Uint32(raw)
+	count := binary.LittleEndian.Uint32(raw[4:])
+	if count != (length-trailerFixedSize)/4 || t.chunkSize == 0 {
+		return nil, fmt.Errorf("file corrupted or truncated at the end: invalid checksum trailer")
+	}
+	for i := uint32(0); i < count; i++ {
+		t.chunks = append(t.chunks, binary.LittleEndian.Uint32(raw[8+4*i:]))
+	}
+	t.digest = raw[8+4*count : 8+4*count+sha256.Size]
+	return t, nil
+}
+
+// Verify checks the contents covered by the trailer and reports the byte offset a corruption starts at
+func (t *checksumTrailer) Verify(input io.ReadSeeker) error {
+	corrupted, actual, err := t.corruptedChunks(input)
+	if err != nil {
+		return err
+	}
+	if len(corrupted) > 0 {
+		return t.chunkError(corrupted[0])
+	}
+	if !bytes.Equal(actual.digest, t.digest) {
+		return fmt.Errorf("file corrupted: SHA-256 checksum does not match")
+	}
+	return nil
+}
+
+// corruptedChunks finds the indices of all chunks not matching their checksum.
+// The trailer calculated from the input is provided as well.
+func (t *checksumTrailer) corruptedChunks(input io.ReadSeeker) ([]int, *checksumTrailer, error) {
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	sums := newChecksumWriter(io.Discard, t.chunkSize)
+	if _, err := io.CopyN(sums, input, t.offset); err != nil {
+		return nil, nil, err
+	}
+	actual := sums.Trailer()
+	var corrupted []int
+	for i := range max(len(t.chunks), len(actual.chunks)) {
+		if i >= len(t.chunks) || i >= len(actual.chunks) || actual.chunks[i] != t.chunks[i] {
+			corrupted = append(corrupted, i)
+		}
+	}
+	return corrupted, actual, nil
+}
+
+// chunkError reports a chunk not matching its checksum
+func (t *checksumTrailer) chunkError(chunk int) error {
+	start, end := t.chunkRange(chunk)
+	return fmt.Errorf("file corrupted at byte %d: checksum of bytes %d to %d does not match", start, start, end-1)
+}
+
+// chunkRange provides the start and end offset of a chunk
+func (t *checksumTrailer) chunkRange(chunk int) (int64, int64) {
+	start := int64(chunk) * int64(t.chunkSize)
+	return start, min(start+int64(t.chunkSize), t.offset)
+}
+
+// A checksumWriter passes everything written to an underlying writer and calculates a checksumTrailer for it
+type checksumWriter struct {
+	w         io.Writer
+	digest    hash.Hash
+	crc       uint32
+	chunkSize uint32
+	inChunk   uint32
+	written   int64
+	chunks    []uint32
+}
+
+// newChecksumWriter creates a checksumWriter with CRCs over chunks of the given size
+func newChecksumWriter(w io.Writer, chunkSize uint32) *checksumWriter {
+	return &checksumWriter{w: w, digest: sha256.New(), chunkSize: chunkSize}
+}
+
+// Write passes p to the underlying writer and updates the checksums with the bytes written
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.digest.Write(p[:n])
+	c.written += int64(n)
+	for rest := p[:n]; len(rest) > 0; {
+		part := rest[:min(uint32(len(rest)), c.chunkSize-c.inChunk)]
+		c.crc = crc32.Update(c.crc, crcTable, part)
+		c.inChunk += uint32(len(part))
+		if c.inChunk == c.chunkSize {
+			c.chunks = append(c.chunks, c.crc)
+			c.crc, c.inChunk = 0, 0
+		}
+		rest = rest[len(part):]
+	}
+	return n, err
+}
+
+// Trailer creates the checksumTrailer of everything written so far
+func (c *checksumWriter) Trailer() *checksumTrailer {
+	chunks := c.chunks
+	if c.inChunk > 0 {
+		chunks = append(chunks[:len(chunks):len(chunks)], c.crc)
+	}
+	return &checksumTrailer{
+		chunkSize: c.chunkSize,
+		chunks:    chunks,
+		digest:    c.digest.Sum(nil),
+		offset:    c.written,
+	}
+}
@@ -0,0 +1,127 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// envelopeV2 creates the bytes of a version 2 envelope with the given payload
+func envelopeV2(t *testing.T, payload []byte) []byte {
+	payloadFile := filepath.Join(t.TempDir(), "payload")
+	assert.NoError(t, os.WriteFile(payloadFile, payload, 0644))
+	envelope := &Envelope{
+		Version:       EnvelopeVersion2,
+		PayloadLen:    int64(len(payload)),
+		HashAlgorithm: crypto.SHA256,
+		ReceiverKeys:  [][]byte{[]byte("fuyoooh!")},
+	}
+	var err error
+	envelope.PayloadWriter, err = os.Open(payloadFile)
+	assert.NoError(t, err)
+	defer envelope.PayloadWriter.Close()
+	return envelope.ToBytes()
+}
+
+func TestParseEnvelope_Trailer(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), checksumChunkSize/8)
+	raw := envelopeV2(t, payload)
+
+	env, err := ParseEnvelope(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.True(t, env.HasTrailer())
+	assert.Len(t, env.Checksum, 32)
+	assert.Equal(t, [][]byte{[]byte("fuyoooh!")}, env.ReceiverKeys)
+	assert.Equal(t, int64(len(payload)), env.PayloadLen)
+	assert.Contains(t, env.String(), "checksum verified")
+	pos, err := env.PayloadReader.Seek(0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), pos)
+}
+
+func TestParseEnvelope_TrailerCorrupted(t *testing.T) {
+	raw := envelopeV2(t, bytes.Repeat([]byte("0123456789abcdef"), checksumChunkSize/8))
+	raw[checksumChunkSize+42] ^= 0xFF
+
+	_, err := ParseEnvelope(bytes.NewReader(raw))
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+	assert.ErrorContains(t, err, "file corrupted at byte 1048576")
+}
+
+func TestParseEnvelope_TrailerTruncated(t *testing.T) {
+	raw := envelopeV2(t, bytes.Repeat([]byte("0123456789abcdef"), 1024))
+
+	_, err := ParseEnvelope(bytes.NewReader(raw[:len(raw)-100]))
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+	_, err = ParseEnvelope(bytes.NewReader(raw[:20]))
+	assert.ErrorIs(t, err, ErrCorruptEnvelope)
+}
+
+func TestParseEnvelope_UnsupportedVersion(t *testing.T) {
+	raw := envelopeV2(t, []byte("payload"))
+	raw[4] = EnvelopeVersionLatest + 1
+
+	_, err := ParseEnvelope(bytes.NewReader(raw))
+	assert.ErrorContains(t, err, fmt.Sprintf("unsupported envelope version %d", EnvelopeVersionLatest+1))
+}
+
+func TestParseEnvelope_VarintKeys(t *testing.T) {
+	keys := [][]byte{[]byte("odd key of 17 byt"), bytes.Repeat([]byte{0x42}, 4096)}
+	envelope := &Envelope{Version: EnvelopeVersion3, ReceiverKeys: keys}
+	var err error
+	envelope.PayloadWriter, err = os.Create(filepath.Join(t.TempDir(), "payload"))
+	assert.NoError(t, err)
+
+	env, err := ParseEnvelope(bytes.NewReader(envelope.ToBytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, EnvelopeVersion3, env.Version)
+	assert.Equal(t, keys, env.ReceiverKeys)
+}
+
+func TestEnvelope_WriteKeysLegacy(t *testing.T) {
+	for _, version := range []uint8{0, EnvelopeVersion1, EnvelopeVersion2} {
+		envelope := &Envelope{Version: version, ReceiverKeys: [][]byte{[]byte("odd key of 17 byt")}}
+		assert.ErrorContains(t, envelope.WriteKeys(&bytes.Buffer{}), "invalid key length 17")
+		envelope.ReceiverKeys = [][]byte{make([]byte, 2048)}
+		assert.ErrorContains(t, envelope.WriteKeys(&bytes.Buffer{}), "invalid key length 2048")
+	}
+}
+
+func TestChecksumWriter_Chunks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sums := newChecksumWriter(buf, 4)
+	_, err := sums.Write([]byte("abcdef"))
+	assert.NoError(t, err)
+	_, err = sums.Write([]byte("ghij"))
+	assert.NoError(t, err)
+	trailer := sums.Trailer()
+	assert.Equal(t, "abcdefghij", buf.String())
+	assert.Len(t, trailer.chunks, 3)
+	assert.Equal(t, int64(10), trailer.offset)
+
+	raw := append(buf.Bytes(), trailer.Bytes()...)
+	read, err := readTrailer(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, trailer.chunks, read.chunks)
+	assert.Equal(t, trailer.digest, read.digest)
+	assert.Equal(t, int64(10), read.offset)
+	assert.NoError(t, read.Verify(bytes.NewReader(raw)))
+}
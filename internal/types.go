@@ -15,7 +15,12 @@ package internal
  */
 
 import (
-	"path/filepath"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/name"
+	"gopkg.in/yaml.v3"
+	"path"
+	"strings"
 )
 
 // ImageContent represents one component to be included in the upgrade package.
@@ -57,9 +62,60 @@ type OutManifest struct {
 }
 
 // ArchiveContents describes all contents for an archive to provide them as a single file.
+// Named profiles add environment-specific contents and settings on top of the common contents.
 type ArchiveContents struct {
-	Files  []string `json:"files"`
-	Images []string `json:"images"`
+	Files        []ContentEntry             `json:"files"`
+	Images       []ContentEntry             `json:"images"`
+	RestartUnits []string                   `json:"restart_units" yaml:"restart_units"`
+	Profiles     map[string]*ContentProfile `json:"profiles" yaml:"profiles"`
+}
+
+// ContentProfile describes the contents and settings of a single environment, e.g. dev, staging or prod.
+type ContentProfile struct {
+	Files        []ContentEntry `json:"files"`
+	Images       []ContentEntry `json:"images"`
+	Recipients   []string       `json:"recipients" yaml:"recipients"`
+	RestartUnits []string       `json:"restart_units" yaml:"restart_units"`
+	Output       string         `json:"output" yaml:"output"`
+	Tag          string         `json:"tag" yaml:"tag"`
+}
+
+// ContentEntry is a file or image in a contents file with an optional expected digest.
+// It is either provided as plain name or as object with name and digest.
+type ContentEntry struct {
+	Name   string `json:"name" yaml:"name"`
+	Digest string `json:"digest" yaml:"digest"`
+}
+
+// contentEntry prevents recursion when unmarshalling a ContentEntry
+type contentEntry ContentEntry
+
+// UnmarshalJSON reads a ContentEntry from a JSON string or object
+func (c *ContentEntry) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Name); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, (*contentEntry)(c))
+}
+
+// UnmarshalYAML reads a ContentEntry from a YAML scalar or mapping
+func (c *ContentEntry) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&c.Name)
+	}
+	return value.Decode((*contentEntry)(c))
+}
+
+// EntryNames returns the names of a list of ContentEntry
+func EntryNames(entries []ContentEntry) []string {
+	if entries == nil {
+		return nil
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name
+	}
+	return names
 }
 
 // ContainerImage describes a container image uniquely
@@ -67,11 +123,37 @@ type ContainerImage struct {
 	Registry string `json:"registry"`
 	Name     string `json:"name"`
 	Tag      string `json:"tag"`
+	Digest   string `json:"digest,omitempty"`
 }
 
 // String creates the image URI form the parts.
 func (i *ContainerImage) String() string {
-	return i.Registry + "/" + i.Name + ":" + i.Tag
+	ref := i.Registry + "/" + i.Name
+	if i.Tag != "" {
+		ref += ":" + i.Tag
+	}
+	if i.Digest != "" {
+		ref += "@" + i.Digest
+	}
+	return ref
+}
+
+// Validate checks if the image forms a valid image reference
+func (i *ContainerImage) Validate() error {
+	if _, err := name.ParseReference(i.String()); err != nil {
+		return fmt.Errorf("invalid image '%s': %w", i, err)
+	}
+	return nil
+}
+
+// ImportTag is the tag an image is stored and imported as.
+// Images referenced by digest only are tagged with the digest as sha256-<hex>.
+func (i *ContainerImage) ImportTag() (name.Tag, error) {
+	tag := i.Tag
+	if tag == "" {
+		tag = strings.Replace(i.Digest, ":", "-", 1)
+	}
+	return name.NewTag(i.Registry + "/" + i.Name + ":" + tag)
 }
 
 const (
@@ -81,5 +163,5 @@ const (
 
 // ToFileName creates a file name to store the image archive in.
 func (i *ContainerImage) ToFileName() string {
-	return filepath.Join(ContainerImagePrefix, i.Registry, i.Name+":"+i.Tag+OCISuffix)
+	return path.Join(ContainerImagePrefix, i.String()+OCISuffix)
 }
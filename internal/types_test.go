@@ -13,3 +13,16 @@ package internal
  *
  * SPDX-License-Identifier:	Apache-2.0
  */
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestContainerImage_Validate(t *testing.T) {
+	assert.NoError(t, ParseContainerImage("alpine:3.20").Validate())
+	assert.NoError(t, ParseContainerImage("registry.example.com/team/app@sha256:"+
+		"2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae").Validate())
+	assert.ErrorContains(t, ParseContainerImage("Foo Bar").Validate(), "invalid image 'docker.io/Foo Bar:latest'")
+	assert.Error(t, ParseContainerImage("alpine@sha256:abc").Validate())
+}
@@ -16,16 +16,26 @@ type tagList []*name.Tag
 // Verifier contains all data necessary to verify the archive's integrity
 type Verifier struct {
 	sigVerifier  signature.Verifier
+	signingKey   string
+	sigHash      string
 	toc          *bytes.Buffer
 	tocSignature *bytes.Buffer
 	unsafeTags   tagList
+	lease        *ImportLease
 	Signatures   *FileSignatures
+	// Transparency additionally checks the TOC and its signature once the signature is verified, if set
+	Transparency func(toc, tocSignature []byte) error
+	// Logger receives the log messages of the verifier, the global logger if nil
+	Logger log.Interface
 }
 
+// missingTocHint is given for packages lacking the TOC or its signature
+const missingTocHint = "the package was not created by sealpack or is damaged; obtain an intact copy of the package"
+
 // NewVerifier Creates a new sealpack integrity verifier structure
 func NewVerifier(signingKeyPath, hashingAlgorithm string) (*Verifier, error) {
 	var err error
-	v := &Verifier{}
+	v := &Verifier{signingKey: signingKeyPath}
 	v.sigVerifier, err = CreateVerifier(signingKeyPath)
 	if err != nil {
 		return nil, err
@@ -46,7 +56,25 @@ func (v *Verifier) AddTocComponent(h *tar.Header, r io.Reader) (err error) {
 		if _, err = io.Copy(v.tocSignature, r); err != nil {
 			return err
 		}
+		return v.useSignatureHash(h.PAXRecords[paxSignatureHash])
+	}
+	return nil
+}
+
+// useSignatureHash switches to the signature hash recorded in a package.
+// Packages not recording one were signed using the DefaultSignatureHash, which an empty sigHash stands for.
+func (v *Verifier) useSignatureHash(signatureHash string) error {
+	name, err := ParseSignatureHash(signatureHash)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBadSignature, err)
+	}
+	if name == v.sigHash || v.sigHash == "" && name == DefaultSignatureHash {
+		return nil
+	}
+	if v.sigVerifier, err = CreateVerifierWithHash(v.signingKey, name); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadSignature, err)
 	}
+	v.sigHash = name
 	return nil
 }
 
@@ -55,24 +83,88 @@ func (v *Verifier) AddUnsafeTag(t *name.Tag) {
 	v.unsafeTags = append(v.unsafeTags, t)
 }
 
+// importLease returns the lease local image imports are done in, creating it on first use
+func (v *Verifier) importLease(namespace string) (*ImportLease, error) {
+	if v.lease == nil {
+		lease, err := NewImportLease(namespace)
+		if err != nil {
+			return nil, err
+		}
+		v.lease = lease
+	}
+	return v.lease, nil
+}
+
+// abortLease releases the import lease of a failed unseal, if any
+func (v *Verifier) abortLease() {
+	if err := v.lease.Abort(); err != nil {
+		v.logger().Errorf("Could not release import lease: %s", err.Error())
+	}
+}
+
 // Verify checks the final integrity of the sealed archive.
 // Rolls back files or tags if integrity was not verified
 func (v *Verifier) Verify(outputPath, namespace, targetRegistry string) (err error) {
+	if v.toc == nil {
+		v.abortLease()
+		return WithHint(fmt.Errorf("%w: package contains no TOC", ErrBadSignature), missingTocHint)
+	}
 	// Test if TOC matches collected signatures TOC amd then verify that the TOC signature matches the binary TOC
 	if bytes.Compare(v.toc.Bytes(), v.Signatures.Bytes()) != 0 {
-		return fmt.Errorf("tocs not matching")
+		v.abortLease()
+		return WithHint(fmt.Errorf("%w: tocs not matching", ErrBadSignature),
+			"the contents were modified after sealing; obtain an intact copy of the package")
+	}
+	if v.tocSignature == nil {
+		v.abortLease()
+		return WithHint(fmt.Errorf("%w: package contains no TOC signature", ErrBadSignature), missingTocHint)
+	}
+	var toc, tocSignature []byte
+	if v.Transparency != nil {
+		// Verifying consumes the buffers
+		toc, tocSignature = bytes.Clone(v.toc.Bytes()), bytes.Clone(v.tocSignature.Bytes())
 	}
 	if err = v.sigVerifier.VerifySignature(v.tocSignature, v.toc); err != nil {
+		err = WithHint(fmt.Errorf("%w: %w", ErrBadSignature, err), signerHint, v.signingKey)
+	} else if v.Transparency != nil {
+		err = v.Transparency(toc, tocSignature)
+	}
+	if err != nil {
 		// As streaming is done before checking the Signature, rollback all
 		// 1) Rollback Files
 		if errInner := os.RemoveAll(outputPath); errInner != nil {
-			log.Errorf("Could not rollback files: %s\n", err.Error())
+			v.logger().Errorf("Could not rollback files: %s", err.Error())
 		}
 		// 2) Rollback Tags
 		if errInner := RemoveAll(namespace, targetRegistry, v.unsafeTags); errInner != nil {
-			log.Errorf("Could not rollback images: %s\n", err.Error())
+			v.logger().Errorf("Could not rollback images: %s", err.Error())
 		}
+		// 3) Release imported contents for garbage collection
+		v.abortLease()
 		return err
 	}
-	return
+	return v.lease.Commit()
+}
+
+// verifyEntry checks the TOC signature and that a single entry is listed in the TOC with the hash of its contents,
+// so it can be trusted without hashing all other contents
+func (v *Verifier) verifyEntry(name string, contents []byte) error {
+	if v.toc == nil || v.tocSignature == nil {
+		return WithHint(fmt.Errorf("%w: package contains no signed TOC", ErrBadSignature), missingTocHint)
+	}
+	entries, err := parseToc(v.toc.Bytes(), hashFunc.Size())
+	if err != nil {
+		return corruptEnvelope(err)
+	}
+	if err = v.sigVerifier.VerifySignature(bytes.NewReader(v.tocSignature.Bytes()), bytes.NewReader(v.toc.Bytes())); err != nil {
+		return WithHint(fmt.Errorf("%w: %w", ErrBadSignature, err), signerHint, v.signingKey)
+	}
+	if err = v.Signatures.AddFile(name, contents); err != nil {
+		return err
+	}
+	if !bytes.Equal(entries[name], []byte((*v.Signatures)[name])) {
+		return WithHint(fmt.Errorf("%w: %s does not match the TOC", ErrBadSignature, name),
+			"the package was modified after sealing; obtain an intact copy of the package")
+	}
+	return nil
 }
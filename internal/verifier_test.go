@@ -117,6 +117,25 @@ func TestVerifier_AddTocComponent(t *testing.T) {
 	}
 }
 
+func TestVerifier_SignatureHash(t *testing.T) {
+	v, err := NewVerifier("../test/public.pem", "SHA512")
+	assert.NoError(t, err)
+	_ = v.Signatures.AddFile("Foo", []byte("Bar-Rick-Ade"))
+	signer, err := CreateSignerWithHash("../test/private.pem", "SHA512")
+	assert.NoError(t, err)
+	signat, err := signer.SignMessage(bytes.NewReader(v.Signatures.Bytes()))
+	assert.NoError(t, err)
+
+	assert.NoError(t, v.AddTocComponent(&tar.Header{Name: TocFileName}, bytes.NewReader(v.Signatures.Bytes())))
+	sigHeader := &tar.Header{Name: TocFileName + ".sig", PAXRecords: map[string]string{paxSignatureHash: "SHA512"}}
+	assert.NoError(t, v.AddTocComponent(sigHeader, bytes.NewReader(signat)))
+	assert.Equal(t, "SHA512", v.sigHash)
+	assert.NoError(t, v.Verify(t.TempDir(), "default", "local"))
+
+	sigHeader.PAXRecords[paxSignatureHash] = "MD5"
+	assert.ErrorIs(t, v.AddTocComponent(sigHeader, bytes.NewReader(signat)), ErrBadSignature)
+}
+
 func TestVerifier_AddUnsafeTag(t *testing.T) {
 	exTag, _ := name.NewTag("foo.bar/repos/tags:v1.23.4-beta2")
 	tests := []struct {
@@ -177,6 +196,19 @@ func TestVerifier_Verify(t *testing.T) {
 			},
 			errContains: "tocs not matching",
 		},
+		{
+			name:        "TOC missing",
+			fields:      verifierFields{Signatures: NewSignatureList("SHA512")},
+			errContains: "package contains no TOC",
+		},
+		{
+			name: "TOC signature missing",
+			fields: verifierFields{
+				toc:        bytes.NewBuffer(NewSignatureList("SHA512").Bytes()),
+				Signatures: NewSignatureList("SHA512"),
+			},
+			errContains: "package contains no TOC signature",
+		},
 		{
 			name:        "Signature wrong",
 			fields:      manipulatedVerifier,
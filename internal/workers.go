@@ -0,0 +1,136 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+// maxBufferedFileSize is the size up to which files are buffered and extracted by workers.
+// Larger files are extracted sequentially, so the memory used by workers stays bounded.
+const maxBufferedFileSize = 16 << 20
+
+// extractJob is a file buffered from the archive to be written by a worker
+type extractJob struct {
+	header   *tar.Header
+	data     []byte
+	fullFile string
+}
+
+// extractWorkers write buffered files of a ReadArchive in parallel.
+// Their hashes are collected separately and merged into the signatures once all workers are done.
+type extractWorkers struct {
+	arc       *ReadArchive
+	jobs      chan extractJob
+	wg        sync.WaitGroup
+	closeJobs sync.Once
+	mutex     sync.Mutex
+	hashes    FileSignatures
+	err       error
+	errSignal chan struct{}
+}
+
+// newExtractWorkers starts a number of workers for extracting files from a ReadArchive
+func newExtractWorkers(arc *ReadArchive, count int) *extractWorkers {
+	w := &extractWorkers{
+		arc:       arc,
+		jobs:      make(chan extractJob, count),
+		hashes:    FileSignatures{},
+		errSignal: make(chan struct{}),
+	}
+	for i := 0; i < count; i++ {
+		w.wg.Add(1)
+		go w.run()
+	}
+	return w
+}
+
+// accepts checks if an archive entry can be extracted by a worker
+func (w *extractWorkers) accepts(h *tar.Header) bool {
+	// Chunked entries depend on the chunks of previous entries, so they are extracted in order
+	return !strings.HasPrefix(h.Name, ContainerImagePrefix) && !strings.HasPrefix(h.Name, TocFileName) && !isChunkedEntry(h) &&
+		h.Size <= maxBufferedFileSize
+}
+
+// submit buffers the current archive entry and hands it over to a worker.
+// If a worker has failed before, its error is returned.
+func (w *extractWorkers) submit(h *tar.Header, fullFile string) error {
+	data := make([]byte, h.Size)
+	if _, err := io.ReadFull(w.arc.TarReader, data); err != nil {
+		return err
+	}
+	select {
+	case w.jobs <- extractJob{header: h, data: data, fullFile: fullFile}:
+		return nil
+	case <-w.errSignal:
+		return w.stop()
+	}
+}
+
+// run extracts files until the jobs are closed
+func (w *extractWorkers) run() {
+	defer w.wg.Done()
+	for job := range w.jobs {
+		sum, err := w.arc.storeBuffered(job.header, job.data, job.fullFile)
+		w.mutex.Lock()
+		if err != nil && w.err == nil {
+			w.err = err
+			close(w.errSignal)
+		}
+		w.hashes[job.header.Name] = sum
+		w.mutex.Unlock()
+	}
+}
+
+// stop stops accepting jobs and waits for all workers to finish. It returns the first error of any worker.
+// Calling stop multiple times is safe.
+func (w *extractWorkers) stop() error {
+	w.closeJobs.Do(func() {
+		close(w.jobs)
+	})
+	w.wg.Wait()
+	return w.err
+}
+
+// merge adds the hashes of all extracted files to the signatures. It must only be called after stop.
+func (w *extractWorkers) merge(signatures *FileSignatures) {
+	for name, sum := range w.hashes {
+		(*signatures)[name] = sum
+	}
+}
+
+// storeBuffered writes a buffered file and returns the hash of its contents
+func (arc *ReadArchive) storeBuffered(h *tar.Header, data []byte, fullFile string) (sum string, err error) {
+	entry := NewReportEntry(h.Name)
+	entry.Size = contentSize(h)
+	entry.Destination = fullFile
+	var contents io.Reader = bytes.NewReader(data)
+	if isSparseEntry(h) {
+		if contents, err = newExpandedReader(h, contents); err != nil {
+			return "", err
+		}
+	}
+	if sum, err = hashWhileReading(contents, func(reader io.Reader) error {
+		return arc.tolerate(entry, arc.storeFile(h, reader, fullFile))
+	}); err != nil {
+		return "", err
+	}
+	arc.finishEntry(entry, &FileSignatures{h.Name: sum})
+	return sum, nil
+}
@@ -0,0 +1,105 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createSmallFilesArchive creates a signed archive containing a number of small files
+func createSmallFilesArchive(t testing.TB, count int, tamper bool) *WriteArchive {
+	sig := NewSignatureList("SHA256")
+	arc := CreateArchiveWriter(true, 0)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("files/%04d.txt", i)
+		contents := []byte(fmt.Sprintf("contents of file %d", i))
+		assert.NoError(t, arc.AddToArchive(name, contents))
+		if tamper && i == count/2 {
+			contents = []byte("something else")
+		}
+		assert.NoError(t, sig.AddFile(name, contents))
+	}
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err := arc.Finalize()
+	assert.NoError(t, err)
+	return arc
+}
+
+func TestReadArchive_UnpackParallel(t *testing.T) {
+	arc := createSmallFilesArchive(t, 200, false)
+	defer arc.Cleanup()
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	defer f.Close()
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	ra.Parallel = 8
+	ra.Report = NewReport("unseal", "test", "SHA256")
+	out := t.TempDir()
+
+	assert.NoError(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""))
+	assert.Len(t, ra.Report.Entries, 200)
+	for i := 0; i < 200; i++ {
+		contents, err := os.ReadFile(filepath.Join(out, fmt.Sprintf("files/%04d.txt", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("contents of file %d", i), string(contents))
+	}
+}
+
+func TestReadArchive_UnpackParallelTampered(t *testing.T) {
+	arc := createSmallFilesArchive(t, 50, true)
+	defer arc.Cleanup()
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	defer f.Close()
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	ra.Parallel = 4
+	assert.ErrorIs(t, ra.Unpack("../test/public.pem", "SHA256", t.TempDir(), "", ""), ErrBadSignature)
+}
+
+func TestReadArchive_UnpackParallelWriteError(t *testing.T) {
+	arc := createSmallFilesArchive(t, 50, false)
+	defer arc.Cleanup()
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	defer f.Close()
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	ra.Parallel = 4
+	out := t.TempDir()
+	// A directory in place of a file makes writing it fail
+	assert.NoError(t, os.MkdirAll(filepath.Join(out, "files", "0010.txt"), 0755))
+	assert.ErrorContains(t, ra.Unpack("../test/public.pem", "SHA256", out, "", ""), "0010.txt")
+}
+
+func BenchmarkReadArchive_UnpackSmallFilesParallel(b *testing.B) {
+	arc := createSmallFilesArchive(b, 2000, false)
+	defer arc.Cleanup()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(arc.outFile.Name())
+		assert.NoError(b, err)
+		ra, err := OpenArchiveReader(f, 0)
+		assert.NoError(b, err)
+		ra.Parallel = 8
+		assert.NoError(b, ra.Unpack("../test/public.pem", "SHA256", b.TempDir(), "", ""))
+		_ = f.Close()
+	}
+}
@@ -0,0 +1,104 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// zipMagic starts zip archives, followed by the header of the first entry or the end of an empty archive
+var zipMagic = []byte("PK")
+
+// ZipProvider provides the regular files of an existing zip archive, keeping their modes and modification times.
+// Each file is spooled to a temporary file removed by CleanupImages.
+type ZipProvider struct {
+	// Source is the path of the zip archive
+	Source string
+}
+
+// IsZipArchive checks whether a file is a zip archive by its first bytes
+func IsZipArchive(name string) (bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+	magic := make([]byte, len(zipMagic))
+	if _, err = io.ReadFull(f, magic); err != nil {
+		return false, nil
+	}
+	return bytes.Equal(magic, zipMagic), nil
+}
+
+// Resolve reads the zip archive and provides an entry per regular file, named like in the archive
+func (p *ZipProvider) Resolve() ([]ProvidedEntry, error) {
+	zr, err := zip.OpenReader(p.Source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive %s: %w", p.Source, err)
+	}
+	defer func() { _ = zr.Close() }()
+	dir := filepath.Join(os.TempDir(), TmpFolderName)
+	if err = os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	entries := make([]ProvidedEntry, 0, len(zr.File))
+	for _, file := range zr.File {
+		mode := file.Mode()
+		if mode.IsDir() {
+			continue
+		}
+		if !mode.IsRegular() {
+			return nil, fmt.Errorf("unsupported entry %s in zip archive %s: only regular files can be sealed", file.Name, p.Source)
+		}
+		name := path.Clean(file.Name)
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return nil, fmt.Errorf("invalid entry name %s in zip archive %s", file.Name, p.Source)
+		}
+		spooled, err := spoolZipEntry(dir, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading %s from zip archive %s: %w", file.Name, p.Source, err)
+		}
+		size := int64(file.UncompressedSize64)
+		entries = append(entries, ProvidedEntry{
+			Name:   name,
+			Source: p.Source + ":" + strings.TrimPrefix(file.Name, "./"),
+			Open: func() (*os.File, error) {
+				return os.Open(spooled)
+			},
+			Size: func() (int64, bool, error) {
+				return size, false, nil
+			},
+			Mode:  mode.Perm(),
+			Local: true,
+		})
+	}
+	return entries, nil
+}
+
+// spoolZipEntry decompresses a file of a zip archive to a temporary file, verifying its CRC-32
+func spoolZipEntry(dir string, file *zip.File) (string, error) {
+	r, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = r.Close() }()
+	return spoolEntry(dir, r, file.Modified)
+}
@@ -0,0 +1,94 @@
+package internal
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import (
+	"archive/zip"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeZipArchive creates a zip archive with a directory, an executable and a plain file
+func writeZipArchive(t *testing.T, modified time.Time) string {
+	file := filepath.Join(t.TempDir(), "legacy.zip")
+	f, err := os.Create(file)
+	assert.NoError(t, err)
+	zw := zip.NewWriter(f)
+	dir := &zip.FileHeader{Name: "bin/"}
+	dir.SetMode(os.ModeDir | 0755)
+	_, err = zw.CreateHeader(dir)
+	assert.NoError(t, err)
+	for _, entry := range []struct {
+		name, contents string
+		mode           os.FileMode
+	}{{"bin/update.sh", "#!/bin/sh", 0750}, {"config.yaml", "port: 80", 0640}} {
+		h := &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: modified}
+		h.SetMode(entry.mode)
+		w, err := zw.CreateHeader(h)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(entry.contents))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, f.Close())
+	return file
+}
+
+func TestIsZipArchive(t *testing.T) {
+	isZip, err := IsZipArchive(writeZipArchive(t, time.Now()))
+	assert.NoError(t, err)
+	assert.True(t, isZip)
+	plain := filepath.Join(t.TempDir(), "plain.tar")
+	assert.NoError(t, os.WriteFile(plain, []byte("x"), 0644))
+	isZip, err = IsZipArchive(plain)
+	assert.NoError(t, err)
+	assert.False(t, isZip)
+	_, err = IsZipArchive(filepath.Join(t.TempDir(), "missing.zip"))
+	assert.Error(t, err)
+}
+
+func TestZipProvider_SealKeepsMetadata(t *testing.T) {
+	defer func() { _ = CleanupImages() }()
+	modified := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	provider := &ZipProvider{Source: writeZipArchive(t, modified)}
+	entries, err := provider.Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"bin/update.sh": "#!/bin/sh", "config.yaml": "port: 80"}, resolvedContents(t, entries))
+
+	arc := CreateArchiveWriter(true, 0)
+	defer arc.Cleanup()
+	sig := NewSignatureList("SHA256")
+	assert.NoError(t, arc.AddProviders([]ContentProvider{provider}, sig))
+	assert.NoError(t, arc.AddToc("../test/private.pem", sig))
+	_, err = arc.Finalize()
+	assert.NoError(t, err)
+
+	f, err := os.Open(arc.outFile.Name())
+	assert.NoError(t, err)
+	defer f.Close()
+	ra, err := OpenArchiveReader(f, 0)
+	assert.NoError(t, err)
+	headers, err := ra.List()
+	assert.NoError(t, err)
+	assert.Len(t, headers, 2)
+	modes := map[string]int64{}
+	for _, h := range headers {
+		modes[h.Name] = h.Mode
+		assert.True(t, modified.Equal(h.ModTime), h.Name)
+	}
+	assert.Equal(t, map[string]int64{"bin/update.sh": 0750, "config.yaml": 0640}, modes)
+}
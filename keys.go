@@ -0,0 +1,162 @@
+package sealpack
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"fmt"
+	"github.com/apex/log"
+	"github.com/innomotics/sealpack/internal"
+	"os"
+	"path/filepath"
+)
+
+// PassphraseEnvVar is the environment variable passphrases of encrypted private keys are read from
+const PassphraseEnvVar = internal.PassphraseEnvVar
+
+type KeygenConfig struct {
+	KeyType    string
+	Bits       int
+	Usage      string
+	Output     string
+	Passphrase []byte
+}
+
+// Keygen creates a new key pair and stores it as <Output>-private.pem and <Output>-public.pem
+func Keygen(config *KeygenConfig) error {
+	key, err := internal.GenerateKey(config.KeyType, config.Bits)
+	if err != nil {
+		return err
+	}
+	privPath, pubPath := config.Output+"-private.pem", config.Output+"-public.pem"
+	if err = internal.WriteKeyPairWithUsage(key, privPath, pubPath, config.Passphrase, config.Usage); err != nil {
+		return err
+	}
+	log.Infof("keygen: created %s key pair %s and %s", config.Usage, privPath, pubPath)
+	return nil
+}
+
+// Key stores a device key can be enrolled in
+const (
+	KeyStoreFile = internal.KeyStoreFile
+	KeyStoreTPM  = internal.KeyStoreTPM
+)
+
+// DefaultTPMHandle is the persistent TPM handle device keys are created at if none is provided
+const DefaultTPMHandle = internal.DefaultTPMHandle
+
+type EnrollConfig struct {
+	DeviceID    string
+	KeyStore    string
+	PrivKeyPath string
+	TPMHandle   string
+	Output      string
+	CSR         bool
+	Passphrase  []byte
+	// KeyReference is set by Enroll to the private key path or TPM key reference to unseal with
+	KeyReference string
+}
+
+// Enroll creates the recipient key of a device in the key store and writes its public key to <Output>-public.pem,
+// and a certificate request for the device ID to <Output>.csr if requested, for registration with the packaging backend
+func Enroll(config *EnrollConfig) error {
+	if config.DeviceID == "" {
+		return fmt.Errorf("no device ID provided")
+	}
+	if config.CSR && config.KeyStore == KeyStoreTPM {
+		return fmt.Errorf("certificate requests require the %s key store, TPM keys can only decrypt", KeyStoreFile)
+	}
+	if config.KeyStore == KeyStoreFile {
+		// The key is referenced by the local configuration, independent of the working directory
+		var err error
+		if config.PrivKeyPath, err = filepath.Abs(config.PrivKeyPath); err != nil {
+			return err
+		}
+	}
+	identity, err := internal.CreateDeviceIdentity(config.KeyStore, config.PrivKeyPath, config.TPMHandle, config.Passphrase)
+	if err != nil {
+		return err
+	}
+	pubPem, err := identity.PublicKeyPEM()
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(config.Output+"-public.pem", pubPem, 0644); err != nil {
+		return err
+	}
+	if config.CSR {
+		csr, err := identity.CertificateRequest(config.DeviceID)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(config.Output+".csr", csr, 0644); err != nil {
+			return err
+		}
+	}
+	fingerprint, err := internal.Fingerprint(identity.Public)
+	if err != nil {
+		return err
+	}
+	config.KeyReference = identity.Reference
+	log.Infof("enroll: created key %s for device %s with fingerprint %s; register %s-public.pem with the packaging backend",
+		identity.Reference, config.DeviceID, fingerprint, config.Output)
+	return nil
+}
+
+// KeyInfo prints type, size, fingerprint and possible usage of a PEM key file or AWS KMS key
+func KeyInfo(path string) error {
+	info, err := internal.GetKeyInfo(path)
+	if err != nil {
+		return err
+	}
+	log.Info(info.String())
+	return nil
+}
+
+type FleetDeviceConfig struct {
+	FleetKeyPath string
+	FleetID      string
+	DeviceID     string
+	Output       string
+}
+
+// DeriveFleetDeviceKey derives the key of a device from the fleet master secret and writes it to Output.
+// Devices are provisioned with this key instead of the master secret, which never has to leave the sealing side.
+func DeriveFleetDeviceKey(config *FleetDeviceConfig) error {
+	secret, err := internal.LoadFleetKey(config.FleetKeyPath)
+	if err != nil {
+		return err
+	}
+	if config.FleetID == "" {
+		config.FleetID = DefaultFleetID
+	}
+	key, err := internal.DeriveDeviceKey(secret, config.FleetID, config.DeviceID)
+	if err != nil {
+		return err
+	}
+	if config.Output == "" {
+		config.Output = config.DeviceID + ".fleet.key"
+	}
+	if err = os.WriteFile(config.Output, key, 0600); err != nil {
+		return err
+	}
+	log.Infof("key fleet-device: derived key of device %s in fleet %s to %s", config.DeviceID, config.FleetID, config.Output)
+	return nil
+}
+
+// SetPassphraseProvider overrides how passphrases of encrypted private keys are retrieved.
+// By default, they are read from the SEALPACK_KEY_PASSPHRASE environment variable.
+func SetPassphraseProvider(provider func(path string) ([]byte, error)) {
+	internal.PassphraseProvider = provider
+}
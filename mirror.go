@@ -0,0 +1,45 @@
+package sealpack
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/apex/log"
+	"github.com/innomotics/sealpack/internal"
+)
+
+// MirroredPackage is a package copied by Mirror
+type MirroredPackage = internal.MirroredPackage
+
+type MirrorConfig struct {
+	// HTTPHeaders are sent with all requests for http:// and https:// packages, formatted as "Name: value"
+	HTTPHeaders []string
+	// AWS configures the sessions used for S3 sources and targets
+	AWS AWSConfig
+}
+
+// Mirror copies a package, directory or prefix of packages between local paths, s3:// and sftp:// locations,
+// verifying every package against its checksum trailer while streaming it
+func Mirror(source, target string, config *MirrorConfig) ([]MirroredPackage, error) {
+	internal.ConfigureAWS(config.AWS)
+	header, err := internal.ParseHTTPHeaders(config.HTTPHeaders)
+	if err != nil {
+		return nil, err
+	}
+	mirrored, err := internal.Mirror(source, target, header)
+	for _, pkg := range mirrored {
+		log.Infof("mirror: copied %s to %s (%d bytes)", pkg.Source, pkg.Target, pkg.Size)
+	}
+	return mirrored, err
+}
@@ -0,0 +1,38 @@
+package sealpack
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import "github.com/innomotics/sealpack/internal"
+
+// ContentProvider resolves a source of contents, like a file glob or a container image, to entries of a package
+type ContentProvider = internal.ContentProvider
+
+// ProvidedEntry is an entry of a package provided by a ContentProvider
+type ProvidedEntry = internal.ProvidedEntry
+
+// RegisterContentProvider registers a factory for all SealConfig.Files starting with prefix, e.g. "https://".
+// Files without a registered prefix are resolved as paths, directories or globs. Register providers before sealing.
+func RegisterContentProvider(prefix string, factory func(spec string) (ContentProvider, error)) {
+	internal.RegisterContentProvider(prefix, factory)
+}
+
+// KeyProvider loads keys from a backend like a KMS, an HSM or a TPM, chosen by the scheme of the key reference
+type KeyProvider = internal.KeyProvider
+
+// RegisterKeyProvider registers a provider for all key references with the scheme, e.g. "vault" for vault://...
+// Built-in schemes are file, awskms, tpm and tpm2; references without a scheme are key files.
+func RegisterKeyProvider(scheme string, provider KeyProvider) {
+	internal.RegisterKeyProvider(scheme, provider)
+}
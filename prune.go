@@ -0,0 +1,57 @@
+package sealpack
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+
+import (
+	"github.com/apex/log"
+	"github.com/innomotics/sealpack/internal"
+	"time"
+)
+
+// PrunedPackage is a package removed by Prune
+type PrunedPackage = internal.PrunedPackage
+
+type PruneConfig struct {
+	// KeepLast is the number of most recently modified packages kept per channel, all if 0
+	KeepLast int
+	// MaxAge is the age after which packages are removed, none if 0
+	MaxAge time.Duration
+	// DryRun only lists the packages which would be removed
+	DryRun bool
+	// Catalog is rewritten for the remaining packages if its PrivKeyPath is set
+	Catalog CatalogConfig
+	// AWS configures the sessions used for S3 repositories
+	AWS AWSConfig
+}
+
+// Prune removes the packages of a directory, s3:// prefix or sftp:// directory not retained by the rules of the config.
+// A channel is a package name within a directory, the most recent package of each channel is always kept.
+func Prune(source string, config *PruneConfig) ([]PrunedPackage, error) {
+	internal.ConfigureAWS(config.AWS)
+	pruned, err := internal.Prune(source, internal.RetentionPolicy{KeepLast: config.KeepLast, MaxAge: config.MaxAge}, config.DryRun)
+	for _, pkg := range pruned {
+		if config.DryRun {
+			log.Infof("prune: would remove %s of %s", pkg.Location, pkg.Channel)
+		} else {
+			log.Infof("prune: removed %s of %s", pkg.Location, pkg.Channel)
+		}
+	}
+	if err != nil || config.DryRun || config.Catalog.PrivKeyPath == "" {
+		return pruned, err
+	}
+	catalog := config.Catalog
+	catalog.AWS = config.AWS
+	return pruned, BuildCatalog(source, &catalog)
+}
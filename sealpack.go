@@ -15,96 +15,459 @@ package sealpack
  */
 
 import (
+	"archive/tar"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/apex/log"
+	"github.com/innomotics/sealpack/format"
 	"github.com/innomotics/sealpack/internal"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 )
 
+// DefaultFleetID is the fleet ID used with fleet keys if none is provided
+const DefaultFleetID = internal.DefaultFleetID
+
+// DefaultRekorURL is the Rekor transparency log TOC signatures are recorded in if no other is provided
+const DefaultRekorURL = internal.DefaultRekorURL
+
+// AWSConfig holds the options of the AWS sessions used for KMS keys, S3 outputs and Secrets Manager.
+// Empty fields use the defaults of the AWS SDK, e.g. from AWS_REGION, AWS_PROFILE or the instance role.
+type AWSConfig = internal.AWSConfig
+
+// ConfigureAWS sets the options of the AWS sessions for operations without a SealConfig or UnsealConfig, e.g. KeyInfo
+func ConfigureAWS(config AWSConfig) {
+	internal.ConfigureAWS(config)
+}
+
+// BandwidthLimits cap the bandwidth of registry pulls and pushes, downloads and S3 and SFTP transfers in bytes per second
+type BandwidthLimits = internal.BandwidthLimits
+
+// LimitBandwidth caps the bandwidth of all network transfers of following operations
+func LimitBandwidth(limits BandwidthLimits) {
+	internal.LimitBandwidth(limits)
+}
+
 type UnsealConfig struct {
 	PrivKeyPath      string
+	FleetKeyPath     string
+	FleetID          string
 	SigningKeyPath   string
 	OutputPath       string
 	HashingAlgorithm string
 	TargetRegistry   string
 	Namespace        string
+	ReportPath       string
+	ImageRefsPath    string
+	DryRun           bool
+	Parallel         int
+	ImageFallback    bool
+	ImagePolicyPath  string
+	MaxTotalSize     int64
+	MaxFileSize      int64
+	MaxEntries       int
+	Preallocate      bool
+	ChecksumsPath    string
+	ManifestPath     string
+	Systemd          bool
+	NoWait           bool
+	LockTimeout      time.Duration
+	StatePath        string
+	Force            bool
+	AllowDualUse     bool
+	// Chown sets the owner of created files and directories as user[:group], by name or numeric ID
+	Chown string
+	// ChmodMask is an octal mask cleared from the mode of created files and directories, e.g. 027
+	ChmodMask string
+	// OutputFormat writes the contents to a plain tar or zip archive at OutputPath instead of extracting them to a directory
+	OutputFormat string
+	// OnConflict handles files already present in the output path: overwrite (default), skip, backup or fail
+	OnConflict string
+	// RekorKeyPath is the public key of a Rekor transparency log; if set, the TOC signature must be recorded in its log
+	RekorKeyPath string
+	// Recursive unseals the nested packages declared by the package into their targets below the output path.
+	// Nested packages not sealed for the private key are kept sealed.
+	Recursive       bool
+	RollbackPath    string
+	RollbackKeyPath string
+	AuditLogPath    string
+	AuditKeyPath    string
+	// HTTPHeaders are sent with all ranged reads of http:// and https:// packages, formatted as "Name: value"
+	HTTPHeaders []string
+	// AWS configures the session used for AWS KMS signing keys
+	AWS AWSConfig
+	// Confirm is asked before unsealing into a non-empty output path or re-tagging existing images.
+	// Unsealing is aborted if it returns false; nothing is asked if nil.
+	Confirm func(question string) (bool, error)
+	// Logger receives the log messages of unsealing, the global apex/log logger if nil
+	Logger log.Interface
+	// Events receive the entries extracted, the verification result and the result of rollbacks, if set
+	Events Events
+	// ContinueOnError continues with the remaining entries if a file cannot be written or an image cannot be imported.
+	// The failures are logged and listed in the report; unsealing fails with ErrPartialImport once the package is verified.
+	ContinueOnError bool
+	// PreserveTimes sets the modification time of written files to the one recorded in the package instead of the time of unsealing
+	PreserveTimes bool
+	// OnlyNarrowModes never widens the mode of files that existed before: they keep the bits of their mode that are
+	// also recorded in the package instead of getting the recorded mode
+	OnlyNarrowModes bool
+	// StrictEntries rejects packages with other entries than regular files, e.g. symlinks made by newer sealpack versions,
+	// instead of creating their directories and skipping the other entries with a warning
+	StrictEntries bool
+	// Scan searches for the envelope behind other data at the start of the file, e.g. prepended by a transport
+	Scan bool
+	// AllowUnsignedEnvelope accepts packages without envelope signature, as sealed with envelope versions 1 to 3.
+	// Their header and keys are not protected, so by default they are rejected.
+	AllowUnsignedEnvelope bool
+	// AllowUnverifiedProvenance reads the provenance of a package without SigningKeyPath, leaving its signature unverified.
+	// As anybody could have signed it, it is rejected by default.
+	AllowUnverifiedProvenance bool
+	// detachedSignaturePath receives the TOC signature of packages unwrapped to a plain tar archive
+	detachedSignaturePath string
+	// nestingDepth counts the packages this one is nested in when unsealing recursively
+	nestingDepth int
 }
 
 type SealConfig struct {
 	PrivKeyPath          string
 	RecipientPubKeyPaths []string
+	FleetKeyPath         string
+	FleetDevicesPath     string
+	FleetID              string
+	RecipientHints       bool
+	AllowDualUse         bool
+	RestartUnits         []string
 	Public               bool
 	Seal                 bool
 	HashingAlgorithm     string
+	SignatureHash        string
 	CompressionAlgorithm string
+	CompressionLevel     int
+	CompressionThreads   int
 	ContentFileName      string
-	Files                []string
-	ImageNames           []string
-	Images               []*internal.ContainerImage
-	Output               string
+	Profile              string
+	Variables            map[string]string
+	FileDigests          map[string]string
+	OnDuplicate          string
+	NoSparse             bool
+	// Symlinks is the policy for symbolic links among the files: follow (default), preserve or reject
+	Symlinks string
+	// Dedup stores files as content-defined chunks, so repeated chunks are only stored once; needs EnvelopeVersion 4
+	Dedup bool
+	// DedupLayers stores the layers of all images once, so images sharing base layers do not repeat them; needs EnvelopeVersion 4
+	DedupLayers bool
+	// Index stores an index of all entries, so single files can be extracted with ranged reads; needs EnvelopeVersion 5
+	Index bool
+	// Channel names the release channel the package is sealed for, e.g. beta; recorded in the envelope and signed with it
+	Channel string
+	// Annotations are "key=value" pairs recorded in the envelope and signed with it, e.g. a ticket or build ID
+	Annotations     []string
+	ImageSignatures bool
+	Provenance      bool
+	// Origins records the origin of every entry (source path, URL, image digest), readable with inspect by recipients
+	Origins bool
+	// OriginCommit is recorded as the commit of the build input of all entries, implies Origins
+	OriginCommit string
+	// Rekor records the TOC signature in the Rekor transparency log and embeds the log entry with its inclusion proof
+	Rekor bool
+	// RekorURL is the Rekor instance used with Rekor, DefaultRekorURL if empty
+	RekorURL        string
+	EnvelopeVersion uint8
+	Strict          bool
+	Files           []string
+	// FromTar adds the regular files of an existing tar stream before all other contents, "-" reads it from stdin
+	FromTar string
+	// ImageDir holds pre-staged image tarballs or OCI layouts, which are read instead of pulling images from registries
+	ImageDir string
+	// ImageCache is a directory storing the layers of pulled images by digest, so repeated seals do not download them again
+	ImageCache string
+	// ImageCacheSize bounds the image cache in bytes by evicting the least recently used layers, 0 does not bound it
+	ImageCacheSize int64
+	// FromZip adds the regular files of an existing zip archive before all other contents
+	FromZip string
+	// Nested are sealed packages added after all other contents and declared in the metadata, given as path or path=target
+	Nested []string
+	// HTTPHeaders are sent with all downloads of http:// and https:// files, formatted as "Name: value"
+	HTTPHeaders  []string
+	ImageNames   []string
+	Images       []*ContainerImage
+	Output       string
+	ReportPath   string
+	TocPath      string
+	AuditLogPath string
+	AuditKeyPath string
+	DryRun       bool
+	// PresignPath receives a presigned download link of the S3 output, a file or "-" for stdout; no link is created if empty
+	PresignPath string
+	// PresignValidity is how long the download link is valid, at most 7 days; 5 minutes if zero
+	PresignValidity time.Duration
+	// AWS configures the sessions used for AWS KMS signing keys and S3 outputs
+	AWS AWSConfig
+	// Logger receives the log messages of sealing, the global apex/log logger if nil
+	Logger log.Interface
+	// Events receive the files and images added, if set
+	Events Events
+	// estimating validates only the contents and keys a size estimate needs, neither output nor signing key
+	estimating bool
 }
 
 // Seal is the combined command for sealing
 func Seal(sealCfg *SealConfig) error {
-	var err error
+	return SealContext(context.Background(), sealCfg)
+}
+
+// SealContext seals like Seal, recording its span as part of the trace in ctx
+func SealContext(ctx context.Context, sealCfg *SealConfig) (err error) {
+	ctx, endOperation := internal.StartOperation(ctx, "seal")
+	defer func() { endOperation(err) }()
+	internal.ConfigureAWS(sealCfg.AWS)
+	logger := sealCfg.logger()
+	var report *internal.Report
+	if sealCfg.ReportPath != "" {
+		report = internal.NewReport("seal", sealCfg.Output, sealCfg.HashingAlgorithm)
+		defer func() {
+			writeReport(report, sealCfg.ReportPath, err, logger)
+		}()
+	}
+
+	audit := internal.NewAuditRecord("seal", sealCfg.Output)
+	defer func() {
+		err = writeAudit(audit, sealCfg.AuditLogPath, sealCfg.AuditKeyPath, err)
+	}()
 
 	// 0 Prepare sealing
+	started := time.Now()
 	if err = prepareSealing(sealCfg); err != nil {
-		log.Error(err.Error())
+		logger.Error(err.Error())
 		return err
 	}
+	if report != nil {
+		// The output may have been provided by a profile
+		report.Package = sealCfg.Output
+	}
+	audit.Package = sealCfg.Output
+	audit.DryRun = sealCfg.DryRun
+	if sealCfg.AuditLogPath != "" {
+		audit.SigningKey = internal.KeyFingerprint(sealCfg.PrivKeyPath)
+	}
+	if sealCfg.DryRun {
+		return planSealing(sealCfg)
+	}
 
 	// 1. Create envelope for the resulting file
 	envelope := internal.Envelope{
+		Version:         sealCfg.EnvelopeVersion,
 		HashAlgorithm:   internal.GetHashAlgorithm(sealCfg.HashingAlgorithm),
 		CompressionAlgo: internal.GetCompressionAlgoIndex(sealCfg.CompressionAlgorithm),
 	}
 
+	if sealCfg.Dedup || sealCfg.DedupLayers {
+		envelope.Flags |= internal.EnvelopeFlagChunked
+	}
+	if envelope.Version >= internal.EnvelopeVersion4 {
+		if envelope.Signer, err = internal.CreateSignerWithHash(sealCfg.PrivKeyPath, sealCfg.SignatureHash); err != nil {
+			return fmt.Errorf("seal: could not create signer: %v", err)
+		}
+		envelope.SignatureHash = sealCfg.SignatureHash
+	}
+
 	// 2. Prepare TARget (pun intended) and add files and signatures
-	log.Debug("seal: Bundling WriteArchive")
-	arc := internal.CreateArchiveWriter(sealCfg.Public, envelope.CompressionAlgo)
+	logger.Debug("seal: Bundling WriteArchive")
+	if sealCfg.Public {
+		logger.Warn("The --public flag was set. Your contents will NOT BE ENCRYPTED. Please verify this is on purpose.")
+	}
+	arc := internal.CreateArchiveWriterWithOptions(sealCfg.Public, envelope.CompressionAlgo, sealCfg.compressionOptions(), envelope.Version)
+	// Removes the payload and wipes its key on all error paths
+	defer func() { _ = arc.Cleanup() }()
+	arc.Report = report
+	arc.FileDigests = sealCfg.FileDigests
+	arc.Duplicates = sealCfg.OnDuplicate
+	arc.Symlinks = sealCfg.Symlinks
+	arc.NoSparse = sealCfg.NoSparse
+	arc.Dedup = sealCfg.Dedup
+	arc.DedupLayers = sealCfg.DedupLayers
+	arc.Index = sealCfg.Index
+	arc.ImageSignatures = sealCfg.ImageSignatures
+	arc.SignatureHash = sealCfg.SignatureHash
+	arc.RecordOrigins = sealCfg.Origins || sealCfg.OriginCommit != ""
+	arc.OriginCommit = sealCfg.OriginCommit
+	arc.Context = ctx
+	arc.Logger = sealCfg.Logger
+	arc.Events = sealCfg.Events
+	if arc.HTTPHeader, err = internal.ParseHTTPHeaders(sealCfg.HTTPHeaders); err != nil {
+		return err
+	}
 	signatures := internal.NewSignatureList(sealCfg.HashingAlgorithm)
-	if err = arc.AddContents(sealCfg.Files, sealCfg.Images, signatures); err != nil {
+	providers, err := sealCfg.contentProviders(arc.HTTPHeader, arc.ImageSignatures, ctx)
+	if err != nil {
 		return err
 	}
+	if err = arc.AddProviders(providers, signatures); err != nil {
+		return err
+	}
+	if err = arc.AddOrigins(signatures); err != nil {
+		return err
+	}
+	if metadata := sealCfg.metadata(); len(metadata.RestartUnits) > 0 || len(metadata.Packages) > 0 {
+		if err = arc.AddMetadata(metadata, signatures); err != nil {
+			return err
+		}
+	}
 	_ = internal.CleanupImages() // Ignore: may not exist if no images have been stored
 
 	// 3. Add TOC and sign it
-	log.Debug("seal: adding TOC")
-	err = arc.AddToc(sealCfg.PrivKeyPath, signatures)
-	if err != nil {
-		return fmt.Errorf("seal: failed adding TOC: %v", err)
+	logger.Debug("seal: adding TOC")
+	if err = signContents(ctx, sealCfg, arc, signatures, started); err != nil {
+		return err
 	}
 	envelope.PayloadLen, err = arc.Finalize()
 	if err != nil {
 		return fmt.Errorf("seal: failed finalizing archive: %v", err)
 	}
+	if sealCfg.Index {
+		if err = envelope.SetIndex(arc); err != nil {
+			return err
+		}
+	}
+	if sealCfg.Channel != "" {
+		if err = envelope.SetChannel(sealCfg.Channel); err != nil {
+			return err
+		}
+	}
+	annotations, err := internal.ParseAnnotations(sealCfg.Annotations)
+	if err != nil {
+		return err
+	}
+	if err = envelope.SetAnnotations(annotations); err != nil {
+		return err
+	}
 
 	// 4. Encrypt keys
-	log.Debugf("seal: encrypting %d keys", len(sealCfg.RecipientPubKeyPaths))
 	// Now create encryption key and seal them for all recipients
 	if !sealCfg.Public {
-		if err = internal.AddKeys(sealCfg.RecipientPubKeyPaths, &envelope, []byte(arc.EncryptionKey)); err != nil {
+		if err = encryptKeys(ctx, sealCfg, &envelope, arc, audit); err != nil {
 			return err
 		}
 	}
 
 	// 5. Write envelope
-	log.Debug("seal: finalize output")
+	logger.Debug("seal: finalize output")
 	out, err := internal.NewOutputFile(sealCfg.Output)
 	if err != nil {
 		return err
 	}
-	if err = envelope.WriteOutput(out, arc); err != nil {
+	_, endWrite := internal.StartPhase(ctx, internal.PhaseWrite)
+	err = envelope.WriteOutput(out, arc)
+	endWrite(err)
+	if err != nil {
 		return err
 	}
+	audit.SetDigest(envelope.Checksum)
 	if err = arc.Cleanup(); err != nil {
 		return err
 	}
-	if err = internal.CleanupFileWriter(sealCfg.Output, out); err != nil {
+	_, endUpload := internal.StartPhase(ctx, internal.PhaseUpload, attribute.String("output", sealCfg.Output))
+	err = internal.CleanupFileWriter(sealCfg.Output, out)
+	endUpload(err)
+	if err != nil {
+		return err
+	}
+	if sealCfg.TocPath != "" {
+		if err = arc.ExportToc(sealCfg.TocPath, sealCfg.HashingAlgorithm); err != nil {
+			return fmt.Errorf("seal: failed exporting TOC: %w", err)
+		}
+	}
+	if sealCfg.PresignPath != "" {
+		if err = presignOutput(sealCfg, report); err != nil {
+			return err
+		}
+	}
+	logger.Info("seal: successfully finished")
+	return nil
+}
+
+// presignOutput writes a presigned download link of the uploaded package, so it can be handed to partners without
+// access to the bucket. The link is added to the report, but never logged.
+func presignOutput(sealCfg *SealConfig, report *internal.Report) error {
+	link, err := internal.PresignDownload(sealCfg.Output, sealCfg.PresignValidity)
+	if err != nil {
+		return fmt.Errorf("seal: failed creating download link: %w", err)
+	}
+	if report != nil {
+		report.DownloadURL = link
+	}
+	if err = internal.WriteFileBytes(sealCfg.PresignPath, []byte(link+"\n")); err != nil {
+		return fmt.Errorf("seal: failed writing download link: %w", err)
+	}
+	sealCfg.logger().Infof("seal: download link of %s written to %s", sealCfg.Output, sealCfg.PresignPath)
+	return nil
+}
+
+// signContents adds the signed TOC and, if configured, the provenance to the archive
+func signContents(ctx context.Context, sealCfg *SealConfig, arc *internal.WriteArchive, signatures *internal.FileSignatures, started time.Time) (err error) {
+	_, end := internal.StartPhase(ctx, internal.PhaseSign)
+	defer func() { end(err) }()
+	if err = arc.AddToc(sealCfg.PrivKeyPath, signatures); err != nil {
+		return fmt.Errorf("seal: failed adding TOC: %v", err)
+	}
+	if sealCfg.Rekor {
+		sealCfg.logger().Debug("seal: recording TOC signature in Rekor")
+		rekorURL := sealCfg.RekorURL
+		if rekorURL == "" {
+			rekorURL = DefaultRekorURL
+		}
+		if err = arc.AddRekorEntry(rekorURL, sealCfg.PrivKeyPath); err != nil {
+			return fmt.Errorf("seal: failed recording TOC signature in Rekor: %v", err)
+		}
+	}
+	if sealCfg.Provenance {
+		sealCfg.logger().Debug("seal: adding provenance")
+		if err = arc.AddProvenance(sealCfg.PrivKeyPath, signatures, sealCfg.provenanceParameters(), started); err != nil {
+			return fmt.Errorf("seal: failed adding provenance: %v", err)
+		}
+	}
+	return nil
+}
+
+// encryptKeys seals the key of the archive for all recipients and the fleet, if configured
+func encryptKeys(ctx context.Context, sealCfg *SealConfig, envelope *internal.Envelope, arc *internal.WriteArchive, audit *internal.AuditRecord) (err error) {
+	_, end := internal.StartPhase(ctx, internal.PhaseEncrypt)
+	defer func() { end(err) }()
+	recipients, err := internal.LoadRecipientKeys(sealCfg.RecipientPubKeyPaths)
+	if err != nil {
+		return fmt.Errorf("seal: failed loading recipient keys: %v", err)
+	}
+	sealCfg.logger().Debugf("seal: encrypting %d keys", len(recipients))
+	for _, recipient := range recipients {
+		fingerprint, _ := internal.Fingerprint(recipient.Key)
+		audit.Recipients = append(audit.Recipients, fingerprint)
+	}
+	if err = internal.AddKeys(recipients, envelope, arc.EncryptionKey); err != nil {
 		return err
 	}
-	log.Info("seal: successfully finished")
+	if sealCfg.RecipientHints {
+		if err = internal.AddRecipientHints(recipients, envelope); err != nil {
+			return fmt.Errorf("seal: failed adding recipient hints: %v", err)
+		}
+	}
+	if sealCfg.FleetKeyPath != "" {
+		devices, err := internal.LoadFleetDevices(sealCfg.FleetDevicesPath)
+		if err != nil {
+			return fmt.Errorf("seal: failed loading fleet devices: %v", err)
+		}
+		sealCfg.logger().Debugf("seal: encrypting key for %d devices of fleet %s", len(devices), sealCfg.FleetID)
+		audit.FleetID = sealCfg.FleetID
+		if err = internal.AddFleetKey(sealCfg.FleetKeyPath, sealCfg.FleetID, devices, envelope, arc.EncryptionKey); err != nil {
+			return fmt.Errorf("seal: failed adding fleet key: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -122,50 +485,1299 @@ func Inspect(sealedFile string) error {
 	return nil
 }
 
-// Unseal is the combined command for unsealing
-func Unseal(sealedFile string, config *UnsealConfig) error {
-	log.Debug("unseal: open sealed file")
+// InspectRecursive inspects a package like Inspect and all nested packages it declares, as far as they can be decrypted
+// with the keys of the config. Nested packages are read without verifying the contents of their parent.
+func InspectRecursive(sealedFile string, config *UnsealConfig) error {
+	internal.ConfigureAWS(config.AWS)
+	return inspectNested(sealedFile, config, 0)
+}
+
+// inspectNested logs the envelope of a package and recurses into the nested packages declared by its metadata
+func inspectNested(sealedFile string, config *UnsealConfig, depth int) error {
+	logger := config.logger()
 	raw, err := os.Open(sealedFile)
 	if err != nil {
 		return err
 	}
-	// Try to parse the envelope
+	defer raw.Close()
 	envelope, err := internal.ParseEnvelope(raw)
 	if err != nil {
 		return err
 	}
-	payload, err := envelope.GetPayload(config.PrivKeyPath)
+	logger.Info(envelope.String())
+	payload, err := openPayload(envelope, config)
+	if depth > 0 && errors.Is(err, ErrNotRecipient) {
+		logger.Info("inspect: not sealed for this key, nested packages cannot be listed")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	archive, err := internal.OpenArchiveReaderWithLogger(payload, envelope.CompressionAlgo, config.Logger)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = archive.Close() }()
+	dir, err := os.MkdirTemp("", "sealpack-nested-")
 	if err != nil {
 		return err
 	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	metadata, spooled, err := archive.SpoolNested(dir)
+	if err != nil || metadata == nil {
+		return err
+	}
+	if len(metadata.Packages) > 0 && depth >= internal.MaxNestingDepth {
+		return fmt.Errorf("inspect: packages are nested deeper than %d levels", internal.MaxNestingDepth)
+	}
+	for _, pkg := range metadata.Packages {
+		file, ok := spooled[pkg.Name]
+		if !ok {
+			return fmt.Errorf("inspect: declared nested package %s is missing", pkg.Name)
+		}
+		logger.Infof("Nested package %s (target %s):", pkg.Name, pkg.Target)
+		if err = inspectNested(file, config, depth+1); err != nil {
+			return fmt.Errorf("inspect: nested package %s: %w", pkg.Name, err)
+		}
+	}
+	return nil
+}
+
+// Diagnose walks the envelope structure of a possibly damaged file and reports which sections are intact.
+// If damage was found, the diagnosis is returned together with ErrCorruptEnvelope.
+func Diagnose(sealedFile string) (*Diagnosis, error) {
+	raw, err := os.Open(sealedFile)
+	if err != nil {
+		return nil, err
+	}
+	defer raw.Close()
+	diagnosis, err := internal.Diagnose(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !diagnosis.Intact() {
+		return diagnosis, fmt.Errorf("%w: damage begins at byte %d", ErrCorruptEnvelope, diagnosis.DamagedAt)
+	}
+	return diagnosis, nil
+}
+
+// Provenance reads the signed provenance statement of a package as DSSE envelope.
+// Sealed packages can only be read by their recipients, so the private key is taken from the config.
+// Its signature is verified with the signing key; without one, it is only read if AllowUnverifiedProvenance is set.
+func Provenance(sealedFile string, config *UnsealConfig) ([]byte, error) {
+	if config.SigningKeyPath == "" && !config.AllowUnverifiedProvenance {
+		return nil, internal.WithHint(errors.New("inspect: the provenance cannot be verified without the public key of the signing entity"),
+			"provide the key with --signer-key, or read the statement UNVERIFIED with --allow-unverified")
+	}
+	internal.ConfigureAWS(config.AWS)
+	raw, err := os.Open(sealedFile)
+	if err != nil {
+		return nil, err
+	}
+	defer raw.Close()
+	envelope, err := internal.ParseEnvelope(raw)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := openPayload(envelope, config)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := internal.OpenArchiveReader(payload, envelope.CompressionAlgo)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = archive.Close() }()
+	provenance, err := archive.ReadProvenance()
+	if err != nil {
+		return nil, err
+	}
+	if config.SigningKeyPath == "" {
+		config.logger().Warn("inspect: provenance is UNVERIFIED, its signature has not been checked")
+		return provenance, nil
+	}
+	if _, err = internal.VerifyProvenance(provenance, config.SigningKeyPath); err != nil {
+		return nil, err
+	}
+	return provenance, nil
+}
+
+// Origins reads the origins recorded for all entries of a package.
+// Sealed packages can only be read by their recipients, so the private key is taken from the config.
+// If a signing key is configured, the origins are verified against the signed TOC.
+func Origins(sealedFile string, config *UnsealConfig) (internal.Origins, error) {
+	internal.ConfigureAWS(config.AWS)
+	raw, err := os.Open(sealedFile)
+	if err != nil {
+		return nil, err
+	}
+	defer raw.Close()
+	envelope, err := internal.ParseEnvelope(raw)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := openPayload(envelope, config)
+	if err != nil {
+		return nil, err
+	}
 	archive, err := internal.OpenArchiveReader(payload, envelope.CompressionAlgo)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = archive.Close() }()
+	// The TOC is hashed with the algorithm recorded in the envelope
+	return archive.ReadOrigins(config.SigningKeyPath, envelope.HashAlgorithm.String())
+}
+
+// List prints the names and sizes of all files and images in a package without extracting them.
+// Sealed packages can only be listed by their recipients, so the private key is taken from the config.
+func List(sealedFile string, config *UnsealConfig) error {
+	internal.ConfigureAWS(config.AWS)
+	raw, err := os.Open(sealedFile)
 	if err != nil {
 		return err
 	}
-	log.Debug("unseal: read contents from archive")
-	err = archive.Unpack(config.SigningKeyPath, config.HashingAlgorithm, config.OutputPath, config.Namespace, config.TargetRegistry)
+	defer raw.Close()
+	envelope, err := internal.ParseEnvelope(raw)
 	if err != nil {
 		return err
 	}
-	log.Info("unseal: finished unsealing")
+	payload, err := openPayload(envelope, config)
+	if err != nil {
+		return err
+	}
+	archive, err := internal.OpenArchiveReaderWithLogger(payload, envelope.CompressionAlgo, config.Logger)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = archive.Close() }()
+	headers, err := archive.List()
+	if err != nil {
+		return err
+	}
+	config.logger().Info(internal.ListString(headers))
 	return nil
 }
 
-// prepareSealing reads the configuration if provided, converting container image formats, and checking some preconditions
-func prepareSealing(sealCfg *SealConfig) error {
-	if sealCfg.ContentFileName != "" {
-		if err := internal.ReadConfiguration(sealCfg.ContentFileName, &sealCfg.Files, &sealCfg.Images); err != nil {
-			return fmt.Errorf("invalid configuration file provided")
+// ExtractOne extracts a single file of an indexed package to the output path of the config.
+// The package may be a local file, an s3:// or an http(s):// URL; only the byte ranges holding the file,
+// the TOC and the keys are read. The file is verified against the signed TOC, but as the payload is not read completely,
+// the checksum trailer and the envelope signature are not verified.
+func ExtractOne(sealedFile, entryPath string, config *UnsealConfig) error {
+	internal.ConfigureAWS(config.AWS)
+	header, err := internal.ParseHTTPHeaders(config.HTTPHeaders)
+	if err != nil {
+		return err
+	}
+	src, err := internal.OpenRangeSource(sealedFile, header)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	envelope, err := internal.ParseEnvelopeUnverified(io.NewSectionReader(src, 0, src.Size()))
+	if err != nil {
+		return err
+	}
+	if !envelope.IsSigned() {
+		// Signed envelopes are not verified to avoid reading the whole payload, the entry is verified against the TOC
+		if err = config.verifyEnvelope(envelope); err != nil {
+			return err
 		}
 	}
-	if len(sealCfg.ImageNames) > 0 {
-		for _, img := range sealCfg.ImageNames {
-			sealCfg.Images = append(sealCfg.Images, internal.ParseContainerImage(img))
+	var plainKey []byte
+	switch {
+	case len(envelope.ReceiverKeys) == 0:
+		config.logger().Info("extract-one: read public archive")
+	case config.FleetKeyPath != "" && envelope.HasFleetKey():
+		plainKey, err = envelope.FleetPayloadKey(config.FleetKeyPath, config.FleetID)
+	default:
+		plainKey, err = envelope.PayloadKey(config.PrivKeyPath)
+	}
+	if err != nil {
+		return err
+	}
+	payload, err := internal.OpenIndexedPayload(envelope, src, plainKey)
+	if err != nil {
+		return err
+	}
+	if err = payload.Extract(entryPath, config.SigningKeyPath, config.HashingAlgorithm, config.OutputPath); err != nil {
+		return err
+	}
+	config.logger().Infof("extract-one: extracted %s to %s", entryPath, config.OutputPath)
+	return nil
+}
+
+// VerifyToc checks that a package contains exactly the entries of an expected TOC with matching digests, without extracting it.
+// The expected TOC has the format of sha256sum, its digests are calculated using digestAlgorithm.
+// The contents are verified against the signed TOC of the package as well, using the keys and algorithm of the config.
+func VerifyToc(sealedFile, expectedTocPath, digestAlgorithm string, config *UnsealConfig) error {
+	internal.ConfigureAWS(config.AWS)
+	digestHash, err := internal.ParseHashAlgorithm(digestAlgorithm)
+	if err != nil {
+		return err
+	}
+	expected, err := internal.ReadExpectedToc(expectedTocPath)
+	if err != nil {
+		return fmt.Errorf("cannot read expected TOC: %w", err)
+	}
+	raw, err := os.Open(sealedFile)
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+	envelope, err := internal.ParseEnvelope(raw)
+	if err != nil {
+		return err
+	}
+	if err = config.verifyEnvelope(envelope); err != nil {
+		return err
+	}
+	payload, err := openPayload(envelope, config)
+	if err != nil {
+		return err
+	}
+	archive, err := internal.OpenArchiveReaderWithLogger(payload, envelope.CompressionAlgo, config.Logger)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = archive.Close() }()
+	archive.Limits = config.unpackLimits()
+	digests, err := archive.Digests(config.SigningKeyPath, config.HashingAlgorithm, digestHash)
+	if err != nil {
+		return err
+	}
+	if err = internal.CompareToc(expected, digests); err != nil {
+		return err
+	}
+	config.logger().Infof("verify: package matches all %d entries of %s", len(expected), expectedTocPath)
+	return nil
+}
+
+// Check verifies an installation against a manifest written by unseal --manifest or seal --export-toc and reports drift.
+// Files are rehashed below the output path of the config and imported images are looked up by their tag.
+// The manifest is verified using the signing key of the config, so the package itself is not needed anymore.
+func Check(manifestPath string, config *UnsealConfig) error {
+	internal.ConfigureAWS(config.AWS)
+	result, err := internal.CheckInstallation(manifestPath, config.SigningKeyPath, config.OutputPath)
+	if err != nil {
+		return err
+	}
+	config.logger().Infof("check: %d entries match %s, %d images skipped", len(result.Verified), manifestPath, len(result.Skipped))
+	return nil
+}
+
+// Unseal is the combined command for unsealing
+func Unseal(sealedFile string, config *UnsealConfig) error {
+	return UnsealContext(context.Background(), sealedFile, config)
+}
+
+// UnsealContext unseals like Unseal, recording its span as part of the trace in ctx
+func UnsealContext(ctx context.Context, sealedFile string, config *UnsealConfig) (err error) {
+	ctx, endOperation := internal.StartOperation(ctx, "unseal")
+	defer func() { endOperation(err) }()
+	internal.ConfigureAWS(config.AWS)
+	logger := config.logger()
+	var report *internal.Report
+	if config.ReportPath != "" {
+		report = internal.NewReport("unseal", sealedFile, config.HashingAlgorithm)
+		defer func() {
+			writeReport(report, config.ReportPath, err, logger)
+		}()
+	}
+	audit := internal.NewAuditRecord("unseal", sealedFile)
+	audit.DryRun = config.DryRun
+	defer func() {
+		err = writeAudit(audit, config.AuditLogPath, config.AuditKeyPath, err)
+	}()
+	if err = config.Validate(); err != nil {
+		return err
+	}
+	lock, err := config.acquireLock()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Release() }()
+	if config.AuditLogPath != "" {
+		audit.SigningKey = internal.KeyFingerprint(config.SigningKeyPath)
+		audit.DecryptionKey = internal.KeyFingerprint(config.PrivKeyPath)
+	}
+	logger.Debug("unseal: open sealed file")
+	raw, err := os.Open(sealedFile)
+	if err != nil {
+		return err
+	}
+	// Try to parse the envelope
+	envelope, err := config.parseEnvelope(raw)
+	if err != nil {
+		return err
+	}
+	audit.SetDigest(envelope.Checksum)
+	if config.FleetKeyPath != "" && envelope.HasFleetKey() {
+		audit.FleetID = config.FleetID
+	}
+	if config.StatePath != "" && !config.DryRun {
+		var state *internal.ApplyState
+		var store *internal.StateStore
+		if state, store, err = config.applyState(sealedFile, envelope.Checksum); err != nil {
+			return err
+		}
+		if !config.Force && store.Applied(state.Digest, state.Output, state.Target) {
+			logger.Infof("unseal: %s has already been applied to %s, use --force to unseal it again", sealedFile, state.Output)
+			return nil
 		}
+		defer func() {
+			err = recordState(store, state, err)
+		}()
 	}
-	// public option cannot be used with receiver keys
-	if sealCfg.Public && len(sealCfg.RecipientPubKeyPaths) > 0 {
-		return fmt.Errorf("cannot use -public with -recipient-pubkey (illogical error)")
+	if err = config.verifyEnvelope(envelope); err != nil {
+		if config.Events != nil {
+			config.Events.OnVerificationResult(err)
+		}
+		return err
+	}
+	if config.Confirm != nil && !config.DryRun {
+		if err = config.confirmOutput(); err != nil {
+			return err
+		}
+	}
+	if err = config.checkImages(envelope); err != nil {
+		return err
+	}
+	_, endDecrypt := internal.StartPhase(ctx, internal.PhaseDecrypt)
+	payload, err := openPayload(envelope, config)
+	endDecrypt(err)
+	if err != nil {
+		return err
+	}
+	archive, err := internal.OpenArchiveReaderWithLogger(payload, envelope.CompressionAlgo, config.Logger)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = archive.Close() }()
+	archive.Report = report
+	archive.Events = config.Events
+	archive.DryRun = config.DryRun
+	archive.Parallel = config.Parallel
+	archive.ImageFallback = config.ImageFallback
+	archive.Limits = config.unpackLimits()
+	archive.Preallocate = config.Preallocate
+	archive.OnConflict, _ = internal.ParseConflictPolicy(config.OnConflict)
+	archive.Ownership, _ = config.ownership()
+	if format, _ := internal.ParseOutputFormat(config.OutputFormat); format != internal.OutputDir && !config.DryRun {
+		if archive.Output, err = internal.CreateArchiveOutput(config.OutputPath, format); err != nil {
+			return err
+		}
+		defer func() { _ = archive.Output.Abort() }()
+	}
+	if config.ChecksumsPath != "" {
+		archive.Checksums = internal.NewChecksumList()
+	}
+	if config.ManifestPath != "" {
+		archive.Installed = internal.NewTocFile()
+	}
+	if config.ImagePolicyPath != "" {
+		if archive.ImagePolicy, err = internal.LoadImagePolicy(config.ImagePolicyPath); err != nil {
+			return err
+		}
+	}
+	archive.RekorKey = config.RekorKeyPath
+	archive.ContinueOnError = config.ContinueOnError
+	archive.StrictEntries = config.StrictEntries
+	archive.PreserveTimes = config.PreserveTimes
+	archive.OnlyNarrowModes = config.OnlyNarrowModes
+	if config.RollbackPath != "" && !config.DryRun {
+		if archive.Rollback, err = internal.NewRollbackRecorder(config.OutputPath, config.localNamespace()); err != nil {
+			return err
+		}
+		defer func() { _ = archive.Rollback.Cleanup() }()
+	}
+	if archive.Output != nil && config.detachedSignaturePath != "" && archive.Installed == nil {
+		archive.Installed = internal.NewTocFile()
+	}
+	logger.Debug("unseal: read contents from archive")
+	extractCtx, endExtract := internal.StartPhase(ctx, internal.PhaseExtract)
+	archive.Context = extractCtx
+	err = archive.Unpack(config.SigningKeyPath, config.HashingAlgorithm, config.OutputPath, config.Namespace, config.TargetRegistry)
+	endExtract(err)
+	if archive.Rollback != nil && !archive.Rollback.Empty() {
+		// A partially applied package is reverted with the same bundle
+		if bundleErr := archive.Rollback.WriteBundle(config.RollbackPath, config.RollbackKeyPath, config.HashingAlgorithm); bundleErr != nil {
+			err = errors.Join(err, fmt.Errorf("unseal: failed writing rollback bundle: %w", bundleErr))
+		} else {
+			logger.Infof("unseal: revert with 'sealpack rollback %s'", config.RollbackPath)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if archive.Output != nil {
+		if config.detachedSignaturePath != "" {
+			if err = archive.WriteDetachedToc(config.detachedSignaturePath); err != nil {
+				return err
+			}
+		}
+		// Only verified contents are handed on
+		if err = archive.Output.Commit(); err != nil {
+			return err
+		}
+		logger.Infof("unseal: wrote verified contents to %s", archive.Output.Path())
+	}
+	if config.DryRun {
+		if archive.Metadata != nil && len(archive.Metadata.RestartUnits) > 0 {
+			logger.Infof("unseal: would restart %s", strings.Join(archive.Metadata.RestartUnits, ", "))
+		}
+		if archive.Metadata != nil && config.Recursive {
+			for _, pkg := range archive.Metadata.Packages {
+				logger.Infof("unseal: would unseal nested package %s into %s", pkg.Name, filepath.Join(config.OutputPath, pkg.Target))
+			}
+		}
+		logger.Info("unseal: dry run finished, contents are valid")
+		return nil
+	}
+	if config.ImageRefsPath != "" {
+		if err = writeImageReferences(archive.ImageReferences, config.ImageRefsPath); err != nil {
+			return err
+		}
+	}
+	if config.ChecksumsPath != "" {
+		if err = internal.WriteFileBytes(config.ChecksumsPath, archive.Checksums.Bytes()); err != nil {
+			return err
+		}
+	}
+	if config.ManifestPath != "" {
+		if err = archive.Installed.Save(config.ManifestPath); err != nil {
+			return fmt.Errorf("unseal: failed writing installation manifest: %w", err)
+		}
+	}
+	if config.Recursive && archive.Metadata != nil {
+		if err = config.unsealNested(ctx, archive.Metadata.Packages); err != nil {
+			return err
+		}
+	}
+	if config.Systemd {
+		if err = internal.ApplySystemd(archive.Metadata, "unsealed "+filepath.Base(sealedFile), logger); err != nil {
+			return err
+		}
+	} else if archive.Metadata != nil && len(archive.Metadata.RestartUnits) > 0 {
+		logger.Warnf("unseal: the package declares units to restart, which requires --systemd: %s", strings.Join(archive.Metadata.RestartUnits, ", "))
+	}
+	logger.Info("unseal: finished unsealing")
+	return nil
+}
+
+// unsealNested unseals the nested packages extracted to the output path into their targets.
+// Reports, state, rollback bundles, checksums and manifests only cover the outermost package.
+func (config *UnsealConfig) unsealNested(ctx context.Context, packages []internal.NestedPackage) error {
+	if len(packages) > 0 && config.nestingDepth >= internal.MaxNestingDepth {
+		return fmt.Errorf("unseal: packages are nested deeper than %d levels", internal.MaxNestingDepth)
+	}
+	logger := config.logger()
+	for _, pkg := range packages {
+		nested := *config
+		nested.OutputPath = filepath.Join(config.OutputPath, filepath.FromSlash(pkg.Target))
+		nested.ReportPath, nested.StatePath, nested.RollbackPath, nested.RollbackKeyPath = "", "", "", ""
+		nested.ChecksumsPath, nested.ManifestPath, nested.ImageRefsPath = "", "", ""
+		nested.nestingDepth++
+		logger.Infof("unseal: unsealing nested package %s into %s", pkg.Name, nested.OutputPath)
+		err := UnsealContext(ctx, filepath.Join(config.OutputPath, filepath.FromSlash(pkg.Name)), &nested)
+		if errors.Is(err, ErrNotRecipient) {
+			logger.Infof("unseal: nested package %s is not sealed for this key, keeping it sealed", pkg.Name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unseal: nested package %s: %w", pkg.Name, err)
+		}
+	}
+	return nil
+}
+
+// confirmOutput asks for confirmation if the output path contains files, which unsealing may overwrite.
+// Other conflict policies than overwriting keep existing files, so nothing is asked.
+func (config *UnsealConfig) confirmOutput() error {
+	if format, _ := internal.ParseOutputFormat(config.OutputFormat); format != internal.OutputDir {
+		if _, err := os.Stat(config.OutputPath); err != nil {
+			return nil
+		}
+		return config.confirm(fmt.Sprintf("Output file %s exists and will be replaced. Continue?", config.OutputPath))
+	}
+	if policy, _ := internal.ParseConflictPolicy(config.OnConflict); policy != internal.ConflictOverwrite {
+		return nil
+	}
+	entries, err := os.ReadDir(config.OutputPath)
+	if os.IsNotExist(err) || (err == nil && len(entries) == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return config.confirm(fmt.Sprintf("Output path %s is not empty, existing files may be overwritten. Continue?", config.OutputPath))
+}
+
+// confirm asks Confirm a question and aborts unsealing unless it is confirmed
+func (config *UnsealConfig) confirm(question string) error {
+	ok, err := config.Confirm(question)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("unseal: aborted before writing to %s", config.OutputPath)
+	}
+	return nil
+}
+
+// localNamespace is the containerD namespace images are imported into, empty if they are pushed to a registry
+func (config *UnsealConfig) localNamespace() string {
+	if config.TargetRegistry == internal.LocalContainerRegistry {
+		return config.Namespace
+	}
+	return ""
+}
+
+// Rollback reverts an unseal with a rollback bundle written by it.
+// The bundle is verified completely before its manifest is acted upon, so it is read twice.
+func Rollback(bundle string, config *UnsealConfig) (err error) {
+	internal.ConfigureAWS(config.AWS)
+	logger := config.logger()
+	if err = config.Validate(); err != nil {
+		return err
+	}
+	verified := &UnsealConfig{SigningKeyPath: config.SigningKeyPath, HashingAlgorithm: config.HashingAlgorithm, DryRun: true, Logger: config.Logger}
+	archive, closeBundle, err := openRollbackBundle(bundle, verified)
+	if err != nil {
+		return err
+	}
+	err = archive.Unpack(config.SigningKeyPath, config.HashingAlgorithm, "", "", "")
+	_ = closeBundle()
+	if err != nil {
+		return err
+	}
+	manifest := archive.RollbackManifest
+	if manifest == nil {
+		return fmt.Errorf("rollback: %s is not a rollback bundle", bundle)
+	}
+	config.OutputPath, config.Namespace, config.TargetRegistry = manifest.Output, manifest.Namespace, ""
+	if manifest.Namespace != "" {
+		config.TargetRegistry = internal.LocalContainerRegistry
+	}
+	lock, err := config.acquireLock()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Release() }()
+	if config.DryRun {
+		logger.Infof("rollback: would restore %d files, remove %d files and reset %d images in %s",
+			len(manifest.Replaced), len(manifest.Created), len(manifest.Images), manifest.Output)
+		return nil
+	}
+	if config.Events != nil {
+		defer func() { config.Events.OnRollback(bundle, err) }()
+	}
+	if archive, closeBundle, err = openRollbackBundle(bundle, config); err != nil {
+		return err
+	}
+	defer func() { _ = closeBundle() }()
+	archive.Events = config.Events
+	logger.Infof("rollback: restoring %d files in %s", len(manifest.Replaced), manifest.Output)
+	if err = archive.Unpack(config.SigningKeyPath, config.HashingAlgorithm, manifest.Output, "", ""); err != nil {
+		return err
+	}
+	if err = manifest.Revert(logger); err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+	logger.Info("rollback: finished reverting")
+	return nil
+}
+
+// parseEnvelope reads the envelope of the sealed file, searching for it behind other data if Scan is set
+func (config *UnsealConfig) parseEnvelope(raw *os.File) (*internal.Envelope, error) {
+	if !config.Scan {
+		envelope, err := internal.ParseEnvelope(raw)
+		if errors.Is(err, format.ErrMagic) {
+			return nil, internal.WithHint(err, "if data was prepended to the package, e.g. by a transport, retry with --scan")
+		}
+		return envelope, err
+	}
+	info, err := raw.Stat()
+	if err != nil {
+		return nil, err
+	}
+	envelope, offset, err := internal.ScanEnvelope(raw, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		config.logger().Warnf("unseal: skipped %d bytes in front of the envelope", offset)
+	}
+	return envelope, nil
+}
+
+// openRollbackBundle opens the payload of a rollback bundle for unpacking
+func openRollbackBundle(bundle string, config *UnsealConfig) (*internal.ReadArchive, func() error, error) {
+	raw, err := os.Open(bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+	archive, err := func() (*internal.ReadArchive, error) {
+		envelope, err := internal.ParseEnvelope(raw)
+		if err != nil {
+			return nil, err
+		}
+		if err = envelope.VerifySignature(config.SigningKeyPath); err != nil {
+			return nil, err
+		}
+		payload, err := openPayload(envelope, config)
+		if err != nil {
+			return nil, err
+		}
+		return internal.OpenArchiveReaderWithLogger(payload, envelope.CompressionAlgo, config.Logger)
+	}()
+	if err != nil {
+		_ = raw.Close()
+		return nil, nil, err
+	}
+	archive.DryRun = config.DryRun
+	return archive, func() error {
+		_ = archive.Close()
+		return raw.Close()
+	}, nil
+}
+
+// applyState starts the ApplyState of unsealing a package and loads the state store it is recorded in
+func (config *UnsealConfig) applyState(sealedFile string, checksum []byte) (*internal.ApplyState, *internal.StateStore, error) {
+	store, err := internal.LoadStateStore(config.StatePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	state := &internal.ApplyState{Package: sealedFile, Target: config.TargetRegistry}
+	if config.TargetRegistry == internal.LocalContainerRegistry {
+		state.Target = "containerd://" + config.Namespace
+	}
+	if state.Output, err = filepath.Abs(config.OutputPath); err != nil {
+		return nil, nil, err
+	}
+	if state.Digest, err = internal.PackageDigest(sealedFile, checksum); err != nil {
+		return nil, nil, err
+	}
+	return state, store, nil
+}
+
+// recordState records the result of an unseal in the state store
+func recordState(store *internal.StateStore, state *internal.ApplyState, err error) error {
+	state.Time = time.Now().UTC()
+	state.Success = err == nil
+	if err != nil {
+		state.Error = err.Error()
+	}
+	if errWrite := store.Record(state); errWrite != nil {
+		return errors.Join(err, fmt.Errorf("could not write state store %s: %w", store.Path(), errWrite))
+	}
+	return err
+}
+
+// acquireLock locks the output path and, for local imports, the containerD namespace against concurrent unseals.
+// Dry runs do not write anything, so they do not lock.
+func (config *UnsealConfig) acquireLock() (*internal.Lock, error) {
+	// Nested packages are unsealed while the lock of the outermost package is held
+	if config.DryRun || config.nestingDepth > 0 {
+		return nil, nil
+	}
+	output, err := internal.OutputLockName(config.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{output}
+	if config.TargetRegistry == internal.LocalContainerRegistry {
+		names = append(names, internal.NamespaceLockName(config.Namespace))
+	}
+	return internal.AcquireLock(internal.LockOptions{NoWait: config.NoWait, Timeout: config.LockTimeout, Logger: config.Logger}, names...)
+}
+
+// writeImageReferences stores the references of all imported images, one per line, for subsequent deployment steps
+func writeImageReferences(refs []string, output string) error {
+	var contents []byte
+	for _, ref := range refs {
+		contents = append(contents, ref+"\n"...)
+	}
+	return internal.WriteFileBytes(output, contents)
+}
+
+// planSealing resolves all contents without pulling any image layers and logs the TOC a seal would create
+func planSealing(sealCfg *SealConfig) error {
+	if _, err := internal.CreateSignerWithHash(sealCfg.PrivKeyPath, sealCfg.SignatureHash); err != nil {
+		return fmt.Errorf("seal: could not create signer: %v", err)
+	}
+	header, err := internal.ParseHTTPHeaders(sealCfg.HTTPHeaders)
+	if err != nil {
+		return err
+	}
+	providers, err := sealCfg.contentProviders(header, false, nil)
+	if err != nil {
+		return err
+	}
+	plan, err := internal.PlanProviders(providers)
+	_ = internal.CleanupImages() // Removes the files spooled from a tar stream
+	if err != nil {
+		return err
+	}
+	sealCfg.logger().Info(internal.PlanString(plan))
+	return nil
+}
+
+// contentProviders creates the providers of all contents in the order they are added, starting with the tar stream
+func (sealCfg *SealConfig) contentProviders(header http.Header, imageSignatures bool, ctx context.Context) ([]internal.ContentProvider, error) {
+	providers, err := internal.ContentProviders(sealCfg.Files, sealCfg.Images, imageSignatures, header, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if sealCfg.FromZip != "" {
+		providers = append([]internal.ContentProvider{&internal.ZipProvider{Source: sealCfg.FromZip}}, providers...)
+	}
+	if sealCfg.FromTar != "" {
+		providers = append([]internal.ContentProvider{&internal.TarProvider{Source: sealCfg.FromTar}}, providers...)
+	}
+	for _, spec := range sealCfg.Nested {
+		file, pkg, err := internal.ParseNestedPackage(spec)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, &internal.NestedProvider{Path: file, Package: pkg})
+	}
+	source := internal.ImageSource{StagingDir: sealCfg.ImageDir}
+	if sealCfg.ImageCache != "" {
+		source.Cache = &internal.LayerCache{Dir: sealCfg.ImageCache, MaxSize: sealCfg.ImageCacheSize}
+	}
+	for _, provider := range providers {
+		if image, ok := provider.(*internal.ImageProvider); ok {
+			image.Source = source
+		}
+	}
+	return providers, nil
+}
+
+// metadata collects the metadata of the package; invalid nested packages are reported by Validate
+func (sealCfg *SealConfig) metadata() *internal.PackageMetadata {
+	metadata := &internal.PackageMetadata{RestartUnits: sealCfg.RestartUnits}
+	for _, spec := range sealCfg.Nested {
+		_, pkg, _ := internal.ParseNestedPackage(spec)
+		metadata.Packages = append(metadata.Packages, pkg)
+	}
+	return metadata
+}
+
+// writeReport finalizes a report with the result of the operation and stores it.
+// Failing to write the report is logged, but does not change the result of the operation.
+func writeReport(report *internal.Report, output string, err error, logger log.Interface) {
+	report.Finish(err)
+	if errWrite := report.Write(output); errWrite != nil {
+		logger.Errorf("could not write report to %s: %v", output, errWrite)
+	}
+}
+
+// writeAudit finalizes an audit record with the result of the operation and appends it to the audit log, if configured.
+// Failing to write the record fails the operation, so it cannot go unrecorded.
+func writeAudit(record *internal.AuditRecord, logPath, keyPath string, err error) error {
+	if logPath == "" {
+		return err
+	}
+	record.Finish(err)
+	if errWrite := internal.AppendAuditRecord(logPath, keyPath, record); errWrite != nil {
+		return errors.Join(err, fmt.Errorf("could not write audit log %s: %w", logPath, errWrite))
+	}
+	return err
+}
+
+// VerifyAuditLog checks the MACs of all records in a signed audit log and logs how many were verified
+func VerifyAuditLog(logPath, keyPath string) error {
+	count, err := internal.VerifyAuditLog(logPath, keyPath)
+	if err != nil {
+		return fmt.Errorf("audit log verified up to record %d: %w", count, err)
+	}
+	log.Infof("audit log %s: all %d records verified", logPath, count)
+	return nil
+}
+
+// validateAudit checks the audit log settings of a configuration
+func validateAudit(logPath, keyPath string) error {
+	if keyPath == "" {
+		return nil
+	}
+	if logPath == "" {
+		return fmt.Errorf("an audit key can only be used with an audit log")
+	}
+	if _, err := internal.LoadAuditKey(keyPath); err != nil {
+		return fmt.Errorf("cannot read audit key: %w", err)
+	}
+	return nil
+}
+
+// normalizeAlgorithms resolves the names of the configured algorithms.
+// Unless strict, unknown hashing and compression names fall back to SHA512 and gzip with a warning.
+func (sealCfg *SealConfig) normalizeAlgorithms() {
+	if _, err := internal.ParseHashAlgorithm(sealCfg.HashingAlgorithm); err != nil {
+		if sealCfg.HashingAlgorithm != "" {
+			sealCfg.logger().Warnf("%v, defaulting to SHA512", err)
+		}
+		sealCfg.HashingAlgorithm = "SHA512"
+	}
+	if _, err := internal.ParseCompressionAlgo(sealCfg.CompressionAlgorithm); err != nil {
+		if sealCfg.CompressionAlgorithm != "" {
+			sealCfg.logger().Warnf("%v, defaulting to %s", err, internal.CompressionGzip)
+		}
+		sealCfg.CompressionAlgorithm = internal.CompressionGzip
+	}
+	sealCfg.SignatureHash, _ = internal.ParseSignatureHash(sealCfg.SignatureHash)
+	if sealCfg.EnvelopeVersion == 0 {
+		sealCfg.EnvelopeVersion = internal.EnvelopeVersionLatest
+	}
+}
+
+// Validate checks the configuration before sealing and reports all errors found at once.
+// Contents files are read by Seal before validating, so their files, images and recipients are included.
+func (sealCfg *SealConfig) Validate() error {
+	var errs []error
+	if sealCfg.Output == "" && !sealCfg.estimating {
+		errs = append(errs, fmt.Errorf("no output provided"))
+	}
+	if sealCfg.PrivKeyPath == "" {
+		if !sealCfg.estimating {
+			errs = append(errs, fmt.Errorf("no private signing key provided"))
+		}
+	} else {
+		errs = append(errs, checkReadable(sealCfg.PrivKeyPath, "private signing key"))
+	}
+	// public option cannot be used with receiver keys
+	if sealCfg.Public && len(sealCfg.RecipientPubKeyPaths) > 0 {
+		errs = append(errs, fmt.Errorf("cannot use -public with -recipient-pubkey (illogical error)"))
+	} else if sealCfg.Public && sealCfg.FleetKeyPath != "" {
+		errs = append(errs, fmt.Errorf("cannot use -public with -fleet-key (illogical error)"))
+	} else if !sealCfg.Public && len(sealCfg.RecipientPubKeyPaths) == 0 && sealCfg.FleetKeyPath == "" && !sealCfg.estimating {
+		errs = append(errs, fmt.Errorf("no recipient public keys or fleet key provided; use -public for packages readable by anyone"))
+	}
+	if sealCfg.RecipientHints {
+		if len(sealCfg.RecipientPubKeyPaths) == 0 {
+			errs = append(errs, fmt.Errorf("recipient hints require recipient public keys"))
+		}
+		if sealCfg.EnvelopeVersion != 0 && sealCfg.EnvelopeVersion < internal.EnvelopeVersion4 {
+			errs = append(errs, fmt.Errorf("recipient hints require envelope version %d or newer", internal.EnvelopeVersion4))
+		}
+	}
+	if sealCfg.FleetKeyPath != "" {
+		if _, err := internal.LoadFleetKey(sealCfg.FleetKeyPath); err != nil {
+			errs = append(errs, fmt.Errorf("cannot read fleet key: %w", err))
+		}
+		if sealCfg.FleetDevicesPath == "" {
+			errs = append(errs, fmt.Errorf("no fleet devices provided; list the device IDs to seal for with -fleet-devices"))
+		} else if _, err := internal.LoadFleetDevices(sealCfg.FleetDevicesPath); err != nil {
+			errs = append(errs, fmt.Errorf("cannot read fleet devices: %w", err))
+		}
+		if sealCfg.EnvelopeVersion != 0 && sealCfg.EnvelopeVersion < internal.EnvelopeVersion4 {
+			errs = append(errs, fmt.Errorf("fleet keys require envelope version %d or newer", internal.EnvelopeVersion4))
+		}
+	}
+	if (sealCfg.Dedup || sealCfg.DedupLayers) && sealCfg.EnvelopeVersion != 0 && sealCfg.EnvelopeVersion < internal.EnvelopeVersion4 {
+		errs = append(errs, fmt.Errorf("deduplication requires envelope version %d or newer", internal.EnvelopeVersion4))
+	}
+	if sealCfg.FromTar != "" && sealCfg.FromTar != "-" {
+		errs = append(errs, checkReadable(sealCfg.FromTar, "tar stream"))
+	}
+	if sealCfg.FromZip != "" {
+		errs = append(errs, checkReadable(sealCfg.FromZip, "zip archive"))
+	}
+	if sealCfg.ImageDir != "" {
+		if info, err := os.Stat(sealCfg.ImageDir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("image directory '%s' is not a directory", sealCfg.ImageDir))
+		}
+		if sealCfg.ImageSignatures {
+			errs = append(errs, fmt.Errorf("image signatures are fetched from registries and cannot be bundled with an image directory"))
+		}
+	}
+	if sealCfg.ImageCacheSize < 0 {
+		errs = append(errs, fmt.Errorf("the image cache size must not be negative"))
+	}
+	if sealCfg.Channel != "" {
+		if sealCfg.EnvelopeVersion != 0 && sealCfg.EnvelopeVersion < internal.EnvelopeVersion5 {
+			errs = append(errs, fmt.Errorf("a channel requires envelope version %d or newer", internal.EnvelopeVersion5))
+		}
+		errs = append(errs, internal.ValidateChannel(sealCfg.Channel))
+	}
+	if len(sealCfg.Annotations) > 0 {
+		if sealCfg.EnvelopeVersion != 0 && sealCfg.EnvelopeVersion < internal.EnvelopeVersion5 {
+			errs = append(errs, fmt.Errorf("annotations require envelope version %d or newer", internal.EnvelopeVersion5))
+		}
+		if _, err := internal.ParseAnnotations(sealCfg.Annotations); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if sealCfg.Index {
+		if sealCfg.EnvelopeVersion != 0 && sealCfg.EnvelopeVersion < internal.EnvelopeVersion5 {
+			errs = append(errs, fmt.Errorf("an index requires envelope version %d or newer", internal.EnvelopeVersion5))
+		}
+		errs = append(errs, internal.CheckIndexable(internal.GetCompressionAlgoIndex(sealCfg.CompressionAlgorithm)))
+	}
+	for _, recipient := range sealCfg.RecipientPubKeyPaths {
+		if err := checkReadable(recipient, "recipient public key"); err != nil {
+			errs = append(errs, err)
+		} else if _, err = internal.LoadRecipientKeys([]string{recipient}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !sealCfg.AllowDualUse && sealCfg.PrivKeyPath != "" {
+		// Unreadable recipient keys have been reported above
+		recipients, _ := internal.LoadRecipientKeys(sealCfg.RecipientPubKeyPaths)
+		errs = append(errs, internal.CheckSealKeyUsage(sealCfg.PrivKeyPath, recipients))
+	}
+	if sealCfg.Strict {
+		if _, err := internal.ParseHashAlgorithm(sealCfg.HashingAlgorithm); sealCfg.HashingAlgorithm != "" && err != nil {
+			errs = append(errs, err)
+		}
+		if _, err := internal.ParseCompressionAlgo(sealCfg.CompressionAlgorithm); sealCfg.CompressionAlgorithm != "" && err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := internal.ParseSignatureHash(sealCfg.SignatureHash); err != nil {
+		errs = append(errs, err)
+	}
+	for _, spec := range sealCfg.Nested {
+		if file, _, err := internal.ParseNestedPackage(spec); err != nil {
+			errs = append(errs, err)
+		} else {
+			errs = append(errs, checkReadable(file, "nested package"))
+		}
+	}
+	errs = append(errs, sealCfg.metadata().Validate())
+	errs = append(errs, validateAudit(sealCfg.AuditLogPath, sealCfg.AuditKeyPath))
+	if sealCfg.EnvelopeVersion > internal.EnvelopeVersionLatest {
+		errs = append(errs, fmt.Errorf("unsupported envelope version %d", sealCfg.EnvelopeVersion))
+	}
+	if sealCfg.OnDuplicate != "" && sealCfg.OnDuplicate != internal.DuplicatesFail && sealCfg.OnDuplicate != internal.DuplicatesSkip {
+		errs = append(errs, fmt.Errorf("invalid duplicate policy '%s', use %s or %s", sealCfg.OnDuplicate, internal.DuplicatesFail, internal.DuplicatesSkip))
+	}
+	if sealCfg.PresignPath != "" && !sealCfg.DryRun && !internal.IsS3Location(sealCfg.Output) {
+		errs = append(errs, fmt.Errorf("download links can only be created for S3 outputs"))
+	}
+	if sealCfg.PresignValidity < 0 || sealCfg.PresignValidity > internal.MaxPresignValidity {
+		errs = append(errs, fmt.Errorf("download link validity must be between 0 and %s", internal.MaxPresignValidity))
+	}
+	switch sealCfg.Symlinks {
+	case "", internal.SymlinksFollow, internal.SymlinksPreserve, internal.SymlinksReject:
+	default:
+		errs = append(errs, fmt.Errorf("invalid symlink policy '%s', use %s, %s or %s", sealCfg.Symlinks, internal.SymlinksFollow, internal.SymlinksPreserve, internal.SymlinksReject))
+	}
+	if idx, err := internal.ParseCompressionAlgo(sealCfg.CompressionAlgorithm); err == nil {
+		errs = append(errs, sealCfg.compressionOptions().Validate(idx))
+	}
+	for _, file := range sealCfg.Files {
+		if internal.HasContentProvider(file) {
+			_, err := internal.NewContentProvider(file)
+			errs = append(errs, err)
+			continue
+		}
+		if matches, err := filepath.Glob(file); err != nil {
+			errs = append(errs, fmt.Errorf("invalid file glob '%s': %v", file, err))
+		} else if len(matches) == 0 {
+			errs = append(errs, fmt.Errorf("file '%s' not found", file))
+		}
+	}
+	for _, img := range sealCfg.Images {
+		errs = append(errs, img.Validate())
+	}
+	if _, err := internal.ParseHTTPHeaders(sealCfg.HTTPHeaders); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, sealCfg.AWS.Validate())
+	return errors.Join(errs...)
+}
+
+// Validate checks the configuration before unsealing and reports all errors found at once
+func (config *UnsealConfig) Validate() error {
+	var errs []error
+	if config.SigningKeyPath == "" {
+		errs = append(errs, fmt.Errorf("no public key of the signing entity provided"))
+	} else {
+		errs = append(errs, checkReadable(config.SigningKeyPath, "signer public key"))
+	}
+	if config.PrivKeyPath != "" {
+		errs = append(errs, checkReadable(config.PrivKeyPath, "private key"))
+	}
+	if !config.AllowDualUse {
+		errs = append(errs, internal.CheckUnsealKeyUsage(config.PrivKeyPath, config.SigningKeyPath))
+	}
+	if config.FleetKeyPath != "" {
+		if _, err := internal.LoadFleetKey(config.FleetKeyPath); err != nil {
+			errs = append(errs, fmt.Errorf("cannot read device key: %w", err))
+		}
+	}
+	if _, err := internal.ParseHashAlgorithm(config.HashingAlgorithm); config.HashingAlgorithm != "" && err != nil {
+		errs = append(errs, err)
+	}
+	if config.Parallel < 0 {
+		errs = append(errs, fmt.Errorf("parallel must not be negative"))
+	}
+	if config.LockTimeout < 0 {
+		errs = append(errs, fmt.Errorf("lock timeout must not be negative"))
+	}
+	errs = append(errs, config.unpackLimits().Validate())
+	errs = append(errs, validateAudit(config.AuditLogPath, config.AuditKeyPath))
+	errs = append(errs, config.validateOutput())
+	if config.ImagePolicyPath != "" {
+		errs = append(errs, checkReadable(config.ImagePolicyPath, "image policy"))
+	}
+	if config.RekorKeyPath != "" {
+		errs = append(errs, checkReadable(config.RekorKeyPath, "Rekor public key"))
+	}
+	if _, err := internal.ParseConflictPolicy(config.OnConflict); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := config.ownership(); err != nil {
+		errs = append(errs, err)
+	}
+	if config.Systemd {
+		errs = append(errs, internal.SystemdSupported())
+	}
+	if (config.RollbackPath == "") != (config.RollbackKeyPath == "") {
+		errs = append(errs, fmt.Errorf("a rollback bundle requires both an output file and a signing key"))
+	} else if config.RollbackKeyPath != "" {
+		errs = append(errs, checkReadable(config.RollbackKeyPath, "rollback signing key"))
+	}
+	errs = append(errs, config.AWS.Validate())
+	return errors.Join(errs...)
+}
+
+// unpackLimits collects the limits of an UnsealConfig
+func (config *UnsealConfig) unpackLimits() internal.UnpackLimits {
+	return internal.UnpackLimits{
+		MaxTotalSize: config.MaxTotalSize,
+		MaxFileSize:  config.MaxFileSize,
+		MaxEntries:   config.MaxEntries,
+	}
+}
+
+// validateOutput checks the output path for the output format and the options only applying to extracted directories
+func (config *UnsealConfig) validateOutput() error {
+	format, err := internal.ParseOutputFormat(config.OutputFormat)
+	if err != nil {
+		return err
+	}
+	info, statErr := os.Stat(config.OutputPath)
+	if format == internal.OutputDir {
+		if config.OutputPath != "" && statErr == nil && !info.IsDir() {
+			return fmt.Errorf("output path '%s' is not a directory", config.OutputPath)
+		}
+		return nil
+	}
+	var errs []error
+	if config.OutputPath == "" || (statErr == nil && info.IsDir()) {
+		errs = append(errs, fmt.Errorf("output path '%s' must be a file name for the %s output format", config.OutputPath, format))
+	}
+	if policy, _ := internal.ParseConflictPolicy(config.OnConflict); policy != internal.ConflictOverwrite {
+		errs = append(errs, fmt.Errorf("a conflict policy cannot be used with the %s output format", format))
+	}
+	if config.Chown != "" || config.ChmodMask != "" {
+		errs = append(errs, fmt.Errorf("ownership options cannot be used with the %s output format", format))
+	}
+	if config.RollbackPath != "" || config.Systemd {
+		errs = append(errs, fmt.Errorf("rollback bundles and systemd cannot be used with the %s output format", format))
+	}
+	if config.Recursive {
+		errs = append(errs, fmt.Errorf("nested packages cannot be unsealed recursively with the %s output format", format))
+	}
+	if config.ContinueOnError {
+		errs = append(errs, fmt.Errorf("continuing on errors cannot be used with the %s output format", format))
+	}
+	return errors.Join(errs...)
+}
+
+// ownership maps Chown and ChmodMask for the archive, nil if neither is set
+func (config *UnsealConfig) ownership() (*internal.Ownership, error) {
+	if config.Chown == "" && config.ChmodMask == "" {
+		return nil, nil
+	}
+	ownership := &internal.Ownership{UID: -1, GID: -1}
+	var err error
+	if config.Chown != "" {
+		if runtime.GOOS == "windows" {
+			return nil, fmt.Errorf("changing the owner of unsealed files is not supported on Windows")
+		}
+		if ownership.UID, ownership.GID, err = internal.ParseOwner(config.Chown); err != nil {
+			return nil, err
+		}
+	}
+	if config.ChmodMask != "" {
+		if ownership.Mask, err = internal.ParseModeMask(config.ChmodMask); err != nil {
+			return nil, err
+		}
+		ownership.Chmod = true
+	}
+	return ownership, nil
+}
+
+// checkImages checks the images of a package before anything is extracted. It fails if no local containerd is available
+// to import them into and images must not be stored as OCI files instead. With Confirm, re-tagging existing images
+// is asked once for all images whose tag currently points to a different digest than the one sealed.
+// The payload is only read through an additional time to find images if one of the checks applies.
+func (config *UnsealConfig) checkImages(envelope *internal.Envelope) error {
+	if format, _ := internal.ParseOutputFormat(config.OutputFormat); format != internal.OutputDir || config.DryRun ||
+		config.TargetRegistry == "" {
+		return nil
+	}
+	var probeErr error
+	if config.TargetRegistry == internal.LocalContainerRegistry {
+		if probeErr = internal.ProbeLocalImport(); probeErr != nil && internal.ImageFallbackEnabled(config.ImageFallback) {
+			// Images stored as OCI files never re-tag anything
+			return nil
+		}
+	}
+	if probeErr == nil && config.Confirm == nil {
+		return nil
+	}
+	images, err := scanImages(envelope, config)
+	if err != nil || len(images) == 0 {
+		return err
+	}
+	if probeErr != nil {
+		return internal.WithHint(fmt.Errorf("unseal: cannot import the %d images of the package: %w", len(images), probeErr),
+			"store the images as OCI files in the output path with --image-fallback or provide a --target-registry")
+	}
+	tags, err := internal.RetaggedImages(images, config.Namespace, config.TargetRegistry)
+	if err != nil || len(tags) == 0 {
+		return err
+	}
+	return config.confirm(fmt.Sprintf("Images %s already exist and will be re-tagged. Continue?", strings.Join(tags, ", ")))
+}
+
+// scanImages lists the image entries of a package by reading through its payload without extracting anything.
+// The payload is rewound afterwards, so it can be unpacked.
+func scanImages(envelope *internal.Envelope, config *UnsealConfig) ([]*tar.Header, error) {
+	payload, err := openPayload(envelope, config)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := internal.OpenArchiveReaderWithLogger(payload, envelope.CompressionAlgo, config.Logger)
+	if err != nil {
+		return nil, err
+	}
+	images, err := archive.Images()
+	_ = archive.Close()
+	if err != nil {
+		return nil, err
+	}
+	return images, envelope.RewindPayload()
+}
+
+// verifyEnvelope verifies the envelope signature. Unsigned envelopes are rejected unless AllowUnsignedEnvelope is set,
+// as anybody could have stripped the signature to change the header or keys without being noticed.
+func (config *UnsealConfig) verifyEnvelope(envelope *internal.Envelope) error {
+	if envelope.IsSigned() {
+		return envelope.VerifySignature(config.SigningKeyPath)
+	}
+	if !config.AllowUnsignedEnvelope {
+		return internal.WithHint(fmt.Errorf("%w: envelope is not signed, changes to its header and keys cannot be detected", ErrBadSignature),
+			"packages are signed since envelope version 4; unseal packages of older versions from trusted sources with --allow-unsigned-envelope")
+	}
+	config.logger().Warn("unseal: envelope without signature, changes to its header and keys cannot be detected")
+	return nil
+}
+
+// openPayload decrypts the payload with the fleet key if one is configured and the package was sealed for a fleet,
+// and with the private key otherwise
+func openPayload(envelope *internal.Envelope, config *UnsealConfig) (io.Reader, error) {
+	switch recipients := len(envelope.ReceiverKeys); {
+	case recipients == 0:
+		config.logger().Info("unseal: read public archive")
+	case envelope.HasFleetKey():
+		config.logger().Infof("unseal: read archive sealed for a fleet and %d receivers", recipients-1)
+	default:
+		config.logger().Infof("unseal: read archive sealed for %d receivers", recipients)
+	}
+	if config.FleetKeyPath != "" && envelope.HasFleetKey() {
+		return envelope.GetFleetPayload(config.FleetKeyPath, config.FleetID)
+	}
+	return envelope.GetPayload(config.PrivKeyPath)
+}
+
+// checkReadable checks if a file can be read; keys of KMS, TPM and other key providers are only checked on use
+func checkReadable(path, usage string) error {
+	if internal.IsExternalKey(path) {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", usage, err)
+	}
+	return f.Close()
+}
+
+// logger provides the configured logger or the global apex/log logger
+func (sealCfg *SealConfig) logger() log.Interface {
+	return internal.LoggerOrDefault(sealCfg.Logger)
+}
+
+// logger provides the configured logger or the global apex/log logger
+func (config *UnsealConfig) logger() log.Interface {
+	return internal.LoggerOrDefault(config.Logger)
+}
+
+// compressionOptions collects the compression settings of a SealConfig
+func (sealCfg *SealConfig) compressionOptions() internal.CompressionOptions {
+	return internal.CompressionOptions{
+		Level:   sealCfg.CompressionLevel,
+		Threads: sealCfg.CompressionThreads,
+	}
+}
+
+// provenanceParameters lists the parameters of a seal invocation recorded in its provenance
+func (sealCfg *SealConfig) provenanceParameters() map[string]any {
+	images := make([]string, 0, len(sealCfg.Images))
+	for _, img := range sealCfg.Images {
+		images = append(images, img.String())
+	}
+	return map[string]any{
+		"contents":    sealCfg.ContentFileName,
+		"profile":     sealCfg.Profile,
+		"files":       sealCfg.Files,
+		"tar":         sealCfg.FromTar,
+		"zip":         sealCfg.FromZip,
+		"images":      images,
+		"public":      sealCfg.Public,
+		"origins":     sealCfg.Origins || sealCfg.OriginCommit != "",
+		"recipients":  len(sealCfg.RecipientPubKeyPaths),
+		"hashing":     sealCfg.HashingAlgorithm,
+		"compression": sealCfg.CompressionAlgorithm,
+	}
+}
+
+// prepareSealing reads the configuration if provided, converting container image formats, and checking some preconditions
+func prepareSealing(sealCfg *SealConfig) error {
+	if sealCfg.ContentFileName != "" {
+		profile, err := internal.ReadContentProfile(sealCfg.ContentFileName, sealCfg.Profile, sealCfg.Variables)
+		if err != nil {
+			return fmt.Errorf("invalid configuration file provided: %v", err)
+		}
+		if profile.Files != nil {
+			sealCfg.Files = internal.EntryNames(profile.Files)
+		}
+		if sealCfg.FileDigests, err = internal.FileDigests(profile.Files); err != nil {
+			return err
+		}
+		if profile.Images != nil {
+			sealCfg.Images = internal.ParseContainerImages(profile.Images, profile.Tag)
+		}
+		sealCfg.RecipientPubKeyPaths = append(sealCfg.RecipientPubKeyPaths, profile.Recipients...)
+		sealCfg.RestartUnits = append(sealCfg.RestartUnits, profile.RestartUnits...)
+		if sealCfg.Output == "" {
+			sealCfg.Output = profile.Output
+		}
+	} else if sealCfg.Profile != "" {
+		return fmt.Errorf("a profile can only be used with a contents file")
+	}
+	if len(sealCfg.ImageNames) > 0 {
+		for _, img := range sealCfg.ImageNames {
+			sealCfg.Images = append(sealCfg.Images, internal.ParseContainerImage(img))
+		}
+	}
+	if err := sealCfg.Validate(); err != nil {
+		return err
 	}
+	sealCfg.normalizeAlgorithms()
 	return nil
 }
@@ -0,0 +1,29 @@
+package sealpack
+
+/*
+ * Sealpack
+ *
+ * Copyright (c) Innomotics GmbH, 2023
+ *
+ * Authors:
+ *  Mathias Haimerl <mathias.haimerl@siemens.com>
+ *
+ * This work is licensed under the terms of the Apache 2.0 license.
+ * See the LICENSE.txt file in the top-level directory.
+ *
+ * SPDX-License-Identifier:	Apache-2.0
+ */
+import "github.com/innomotics/sealpack/internal"
+
+// Unwrap verifies a package and writes its decrypted contents to a plain tar archive at the output path of the config,
+// for tools understanding tar, but not the envelope. The signed TOC is added as .sealpack.toc and its signature is
+// written to signaturePath, next to the archive with the suffix .sig if empty. Images are added as OCI files.
+func Unwrap(sealedFile, signaturePath string, config *UnsealConfig) error {
+	unwrap := *config
+	unwrap.OutputFormat = string(internal.OutputTar)
+	unwrap.detachedSignaturePath = signaturePath
+	if signaturePath == "" {
+		unwrap.detachedSignaturePath = config.OutputPath + ".sig"
+	}
+	return Unseal(sealedFile, &unwrap)
+}